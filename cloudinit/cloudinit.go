@@ -27,6 +27,7 @@ var (
 	vendorDataTemplate = "vendor-data.tmpl"
 	userDataTemplate   = "user-data.tmpl"
 	metaDataTemplate   = "meta-data.tmpl"
+	networkCfgTemplate = "network-config.tmpl"
 
 	validFilenamePattern = `^[^<>:"/\\|?*\x00-\x1F]+$`
 )
@@ -39,6 +40,11 @@ type Config struct {
 	VendorData VendorData
 	MetaData   MetaData
 	UserData   string
+
+	// NetworkConfig is written as the network configuration of the VM. When
+	// nil, the guest applies its default configuration, which is commonly
+	// DHCP on the first interface.
+	NetworkConfig *NetworkConfig
 }
 
 type MetaData struct {
@@ -60,6 +66,37 @@ type VendorData struct {
 	Timezone string
 }
 
+// NetworkConfig is the network configuration of the VM, which is rendered
+// using the version 2 format.
+type NetworkConfig struct {
+	Ethernets []Ethernet
+}
+
+// Ethernet is the configuration of a VM network interface, which is matched
+// using its hardware address.
+type Ethernet struct {
+	// Name identifies the entry within the network configuration.
+	Name        string
+	MAC         string
+	DHCP4       bool
+	DHCP6       bool
+	Addresses   []string
+	Routes      []Route
+	MTU         int
+	Nameservers *Nameservers
+}
+
+type Route struct {
+	To     string
+	Via    string
+	Metric int
+}
+
+type Nameservers struct {
+	Addresses []string
+	Search    []string
+}
+
 type File struct {
 	Path        string
 	Content     string
@@ -109,9 +146,9 @@ func (c *Controller) isValidFilePathSyntax(filePath string) bool {
 }
 
 // Apply takes the cloud init configuration and writes it into an iso (ISO-9660) disk.
-// In order for cloud init to pick it up, the meta-data, user-data and vendor-data
-// files need to be in the root of the disk, and it needs to be labeled with
-// "cidata".
+// In order for cloud init to pick it up, the meta-data, user-data, vendor-data
+// and optional network-config files need to be in the root of the disk, and it
+// needs to be labeled with "cidata".
 func (c *Controller) Apply(ci *Config, ciPath string) error {
 	c.logger.Debug("creating ci config with", fmt.Sprintf("%+v", ci), "in", ciPath)
 
@@ -175,6 +212,22 @@ func (c *Controller) Apply(ci *Config, ciPath string) error {
 		},
 	}
 
+	if ci.NetworkConfig != nil {
+		ncb := &bytes.Buffer{}
+		err = executeTemplate(ci, networkCfgTemplate, ncb)
+		if err != nil {
+			return fmt.Errorf("cloudinit: unable to execute network config template %s: %w",
+				ci.MetaData.InstanceID, err)
+		}
+
+		c.logger.Debug("network-config", "contents", ncb.String())
+
+		l = append(l, Entry{
+			Path:   "/network-config",
+			Reader: ncb,
+		})
+	}
+
 	err = Write(ciPath, "cidata", l)
 	if err != nil {
 		return fmt.Errorf("cloudinit: unable to write configuration to file %s: %w",
@@ -88,6 +88,20 @@ func Test_WriteConfigToISO(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "Valid CloudInit with NetworkConfig",
+			cloudInit: &Config{
+				MetaData: MetaData{
+					LocalHostname: "test-localhost",
+				},
+				NetworkConfig: &NetworkConfig{
+					Ethernets: []Ethernet{
+						{Name: "interface0", MAC: "52:54:00:12:34:56", DHCP4: true},
+					},
+				},
+			},
+			expectError: false,
+		},
 		{
 			name: "User data string",
 			cloudInit: &Config{
@@ -308,6 +322,95 @@ runcmd:
 bootcmd:  
   - bootcmd1 arg arg  
   - bootcmd2 arg arg
+`,
+		},
+		{
+			name: "network_config",
+			config: &Config{
+				NetworkConfig: &NetworkConfig{
+					Ethernets: []Ethernet{
+						{
+							Name:      "interface0",
+							MAC:       "52:54:00:12:34:56",
+							Addresses: []string{"169.254.1.2/30"},
+							Routes:    []Route{{To: "0.0.0.0/0", Via: "169.254.1.1"}},
+						},
+						{
+							Name:  "interface1",
+							MAC:   "52:54:00:65:43:21",
+							DHCP4: true,
+							DHCP6: true,
+						},
+					},
+				},
+			},
+			templatePath: "network-config.tmpl",
+			expectError:  false,
+			expectedContent: `version: 2
+ethernets:
+  interface0:
+    match:
+      macaddress: "52:54:00:12:34:56"
+    dhcp4: false
+    dhcp6: false
+    addresses:
+      - 169.254.1.2/30
+    routes:
+      - to: 0.0.0.0/0
+        via: 169.254.1.1
+  interface1:
+    match:
+      macaddress: "52:54:00:65:43:21"
+    dhcp4: true
+    dhcp6: true
+`,
+		},
+		{
+			name: "network_config_static",
+			config: &Config{
+				NetworkConfig: &NetworkConfig{
+					Ethernets: []Ethernet{
+						{
+							Name:      "interface0",
+							MAC:       "52:54:00:12:34:56",
+							MTU:       9000,
+							Addresses: []string{"192.168.10.5/24", "fd00::5/64"},
+							Routes: []Route{
+								{To: "0.0.0.0/0", Via: "192.168.10.1"},
+								{To: "10.0.0.0/8", Via: "192.168.10.254", Metric: 100},
+							},
+							Nameservers: &Nameservers{
+								Addresses: []string{"192.168.10.1"},
+								Search:    []string{"example.com"},
+							},
+						},
+					},
+				},
+			},
+			templatePath: "network-config.tmpl",
+			expectError:  false,
+			expectedContent: `version: 2
+ethernets:
+  interface0:
+    match:
+      macaddress: "52:54:00:12:34:56"
+    dhcp4: false
+    dhcp6: false
+    mtu: 9000
+    addresses:
+      - 192.168.10.5/24
+      - fd00::5/64
+    routes:
+      - to: 0.0.0.0/0
+        via: 192.168.10.1
+      - to: 10.0.0.0/8
+        via: 192.168.10.254
+        metric: 100
+    nameservers:
+      addresses:
+        - 192.168.10.1
+      search:
+        - example.com
 `,
 		},
 		{
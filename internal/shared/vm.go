@@ -106,6 +106,20 @@ type Config struct {
 	CIUserData        string
 	Volumes           []storage.Volume
 	NetworkInterfaces net.NetworkInterfacesConfig
+
+	// AllocID and TaskName identify the task running within the VM. They are
+	// used to derive stable hardware addresses for the network interfaces
+	// which do not define one.
+	AllocID  string
+	TaskName string
+
+	// MBits is the network bandwidth allocated to the task by Nomad in
+	// megabits per second.
+	MBits int
+
+	// DNS is the DNS configuration of the task, which is applied to the
+	// primary network interface.
+	DNS *drivers.DNSConfig
 }
 
 // Validate validates the configuration.
@@ -160,6 +174,10 @@ func (vm *Config) Copy() *Config {
 		BOOTCMDs:          slices.Clone(vm.BOOTCMDs),
 		CIUserData:        vm.CIUserData,
 		Timezone:          vm.Timezone,
+		AllocID:           vm.AllocID,
+		TaskName:          vm.TaskName,
+		MBits:             vm.MBits,
+		DNS:               vm.DNS.Copy(),
 	}
 
 	if vm.OsVariant != nil {
@@ -212,8 +230,150 @@ func (vm *Config) CloudInitConfig() *cloudinit.Config {
 			Password: vm.Password,
 			SSHKey:   vm.SSHKey,
 		},
-		UserData: vm.CIUserData,
+		UserData:      vm.CIUserData,
+		NetworkConfig: vm.networkConfig(),
+	}
+}
+
+// RequiresNetworkConfig returns if a cloud-init network configuration is
+// generated for the VM. It is required when an interface is attached to the
+// network namespace of its allocation, an interface has its own network
+// configuration, or the task has a DNS configuration. Otherwise, all
+// interfaces use DHCP, which matches the guest default.
+func (vm *Config) RequiresNetworkConfig() bool {
+	if len(vm.NetworkInterfaces) == 0 {
+		return false
+	}
+
+	return vm.interfacesConfigured() || vm.dnsConfigured()
+}
+
+// interfacesConfigured returns if any network interface requires a guest
+// network configuration other than DHCP.
+func (vm *Config) interfacesConfigured() bool {
+	return slices.ContainsFunc(vm.NetworkInterfaces, func(iface *net.NetworkInterfaceConfig) bool {
+		return iface.Isolation != nil || iface.NetworkConfig != nil
+	})
+}
+
+// InterfaceHwaddr returns the hardware address of the network interface at
+// the passed index. When the interface does not define one, the address is
+// derived from the identity of the task. An empty value is returned when the
+// task identity is unknown, leaving the provider to generate the address.
+func (vm *Config) InterfaceHwaddr(i int) string {
+	iface := vm.NetworkInterfaces[i]
+	if iface.MAC != "" {
+		return iface.MAC
+	}
+
+	if vm.AllocID == "" {
+		return ""
+	}
+
+	if iface.Isolation != nil {
+		return net.DeriveIsolationHwaddr(vm.AllocID, vm.TaskName)
+	}
+
+	// The isolation interface is added ahead of the interfaces of the job
+	// specification in group network mode, so the address is derived from
+	// the index of the interface within the job specification. This keeps
+	// the address the same regardless of the network mode.
+	index := i
+	for _, other := range vm.NetworkInterfaces[:i] {
+		if other.Isolation != nil {
+			index--
+		}
+	}
+
+	return net.DeriveHwaddr(vm.AllocID, vm.TaskName, index)
+}
+
+// dnsConfigured returns if the task DNS configuration contains any
+// settings which can be applied to an interface.
+func (vm *Config) dnsConfigured() bool {
+	return vm.DNS != nil && (len(vm.DNS.Servers) > 0 || len(vm.DNS.Searches) > 0)
+}
+
+// networkConfig generates the cloud-init network configuration.
+func (vm *Config) networkConfig() *cloudinit.NetworkConfig {
+	if !vm.RequiresNetworkConfig() {
+		return nil
+	}
+
+	primary := vm.NetworkInterfaces.Primary()
+
+	// When only the task DNS configuration requires the network
+	// configuration, only the primary interface is configured, so the
+	// addressing of the other interfaces is left to the guest.
+	dnsOnly := !vm.interfacesConfigured()
+
+	cfg := &cloudinit.NetworkConfig{}
+	for i, iface := range vm.NetworkInterfaces {
+		// Interfaces are matched using their hardware address, so those
+		// without one can not be configured.
+		if iface.MAC == "" || dnsOnly && iface != primary {
+			continue
+		}
+
+		ethernet := cloudinit.Ethernet{
+			Name: fmt.Sprintf("interface%d", i),
+			MAC:  iface.MAC,
+		}
+
+		switch {
+		case iface.Isolation != nil:
+			ethernet.Addresses = []string{iface.Isolation.Address}
+			ethernet.Routes = []cloudinit.Route{{To: "0.0.0.0/0", Via: iface.Isolation.Gateway}}
+		case iface.NetworkConfig.Static():
+			ethernet.Addresses = slices.Clone(iface.NetworkConfig.Addresses)
+		case iface.Bridged() != nil:
+			// DHCPv6 is only enabled when the network provides IPv6, so the
+			// guest does not wait for a DHCPv6 server which does not exist.
+			ethernet.DHCP4 = true
+			ethernet.DHCP6 = iface.IPv6
+		default:
+			ethernet.DHCP4 = true
+		}
+
+		if nc := iface.NetworkConfig; nc != nil {
+			if nc.Gateway != "" {
+				ethernet.Routes = append(ethernet.Routes, cloudinit.Route{To: "0.0.0.0/0", Via: nc.Gateway})
+			}
+			if nc.Gateway6 != "" {
+				ethernet.Routes = append(ethernet.Routes, cloudinit.Route{To: "::/0", Via: nc.Gateway6})
+			}
+			for _, route := range nc.Routes {
+				ethernet.Routes = append(ethernet.Routes, cloudinit.Route{
+					To:     route.To,
+					Via:    route.Via,
+					Metric: route.Metric,
+				})
+			}
+
+			ethernet.MTU = nc.MTU
+
+			if len(nc.Nameservers) > 0 || len(nc.SearchDomains) > 0 {
+				ethernet.Nameservers = &cloudinit.Nameservers{
+					Addresses: slices.Clone(nc.Nameservers),
+					Search:    slices.Clone(nc.SearchDomains),
+				}
+			}
+		}
+
+		// The task DNS configuration is applied to the primary interface,
+		// unless the interface has its own DNS configuration. Options can
+		// not be expressed within the network configuration, so are ignored.
+		if iface == primary && ethernet.Nameservers == nil && vm.dnsConfigured() {
+			ethernet.Nameservers = &cloudinit.Nameservers{
+				Addresses: slices.Clone(vm.DNS.Servers),
+				Search:    slices.Clone(vm.DNS.Searches),
+			}
+		}
+
+		cfg.Ethernets = append(cfg.Ethernets, ethernet)
 	}
+
+	return cfg
 }
 
 type NetworkInterface struct {
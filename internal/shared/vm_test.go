@@ -6,6 +6,9 @@ package vm
 import (
 	"testing"
 
+	"github.com/hashicorp/nomad-driver-virt/cloudinit"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/shoenig/test/must"
 )
 
@@ -101,3 +104,229 @@ func TestConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_CloudInitConfig_networkConfig(t *testing.T) {
+	bridge := &net.NetworkInterfaceConfig{
+		Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+		MAC:    "52:54:00:65:43:21",
+	}
+
+	t.Run("not isolated", func(t *testing.T) {
+		config := &Config{NetworkInterfaces: net.NetworkInterfacesConfig{bridge}}
+		must.Nil(t, config.CloudInitConfig().NetworkConfig)
+	})
+
+	t.Run("isolated", func(t *testing.T) {
+		config := &Config{
+			NetworkInterfaces: net.NetworkInterfacesConfig{
+				{
+					Isolation: &net.NetworkInterfaceIsolationConfig{
+						Device:  "nvt12345678",
+						Address: "169.254.1.2/30",
+						Gateway: "169.254.1.1",
+					},
+					MAC: "52:54:00:12:34:56",
+				},
+				bridge,
+				{
+					Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0", Mode: net.MacvtapModeBridge},
+					MAC:     "52:54:00:ab:cd:ef",
+				},
+				{
+					Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth1", Mode: net.MacvtapModeBridge},
+				},
+				{
+					Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "routed"},
+					MAC:     "52:54:00:fe:dc:ba",
+					IPv6:    true,
+				},
+			},
+		}
+
+		must.Eq(t, &cloudinit.NetworkConfig{
+			Ethernets: []cloudinit.Ethernet{
+				{
+					Name:      "interface0",
+					MAC:       "52:54:00:12:34:56",
+					Addresses: []string{"169.254.1.2/30"},
+					Routes:    []cloudinit.Route{{To: "0.0.0.0/0", Via: "169.254.1.1"}},
+				},
+				{Name: "interface1", MAC: "52:54:00:65:43:21", DHCP4: true},
+				{Name: "interface2", MAC: "52:54:00:ab:cd:ef", DHCP4: true},
+				{Name: "interface4", MAC: "52:54:00:fe:dc:ba", DHCP4: true, DHCP6: true},
+			},
+		}, config.CloudInitConfig().NetworkConfig)
+	})
+
+	t.Run("static", func(t *testing.T) {
+		config := &Config{
+			NetworkInterfaces: net.NetworkInterfacesConfig{
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &net.NetworkInterfaceNetworkConfig{
+						Addresses: []string{"192.168.122.10/24", "fd00::10/64"},
+						Gateway:   "192.168.122.1",
+						Gateway6:  "fd00::1",
+						Routes: []*net.NetworkInterfaceRouteConfig{
+							{To: "10.0.0.0/8", Via: "192.168.122.254", Metric: 100},
+						},
+						MTU:           1400,
+						Nameservers:   []string{"192.168.122.1"},
+						SearchDomains: []string{"example.com"},
+					},
+					MAC: "52:54:00:12:34:56",
+				},
+				bridge,
+			},
+			DNS: &drivers.DNSConfig{Servers: []string{"1.1.1.1"}},
+		}
+
+		must.Eq(t, &cloudinit.NetworkConfig{
+			Ethernets: []cloudinit.Ethernet{
+				{
+					Name:      "interface0",
+					MAC:       "52:54:00:12:34:56",
+					Addresses: []string{"192.168.122.10/24", "fd00::10/64"},
+					Routes: []cloudinit.Route{
+						{To: "0.0.0.0/0", Via: "192.168.122.1"},
+						{To: "::/0", Via: "fd00::1"},
+						{To: "10.0.0.0/8", Via: "192.168.122.254", Metric: 100},
+					},
+					MTU: 1400,
+					Nameservers: &cloudinit.Nameservers{
+						Addresses: []string{"192.168.122.1"},
+						Search:    []string{"example.com"},
+					},
+				},
+				{Name: "interface1", MAC: "52:54:00:65:43:21", DHCP4: true},
+			},
+		}, config.CloudInitConfig().NetworkConfig)
+	})
+
+	t.Run("task dns", func(t *testing.T) {
+		// Only the primary interface is configured, leaving the addressing
+		// of the other interfaces to the guest.
+		config := &Config{
+			NetworkInterfaces: net.NetworkInterfacesConfig{
+				bridge,
+				{
+					Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "routed"},
+					MAC:     "52:54:00:fe:dc:ba",
+					IPv6:    true,
+				},
+			},
+			DNS: &drivers.DNSConfig{
+				Servers:  []string{"1.1.1.1", "8.8.8.8"},
+				Searches: []string{"service.consul"},
+				Options:  []string{"ndots:2"},
+			},
+		}
+
+		must.Eq(t, &cloudinit.NetworkConfig{
+			Ethernets: []cloudinit.Ethernet{
+				{
+					Name:  "interface0",
+					MAC:   "52:54:00:65:43:21",
+					DHCP4: true,
+					Nameservers: &cloudinit.Nameservers{
+						Addresses: []string{"1.1.1.1", "8.8.8.8"},
+						Search:    []string{"service.consul"},
+					},
+				},
+			},
+		}, config.CloudInitConfig().NetworkConfig)
+	})
+
+	t.Run("task dns primary ipv6", func(t *testing.T) {
+		config := &Config{
+			NetworkInterfaces: net.NetworkInterfacesConfig{
+				bridge,
+				{
+					Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "routed"},
+					Primary: true,
+					MAC:     "52:54:00:fe:dc:ba",
+					IPv6:    true,
+				},
+			},
+			DNS: &drivers.DNSConfig{Servers: []string{"fd00::1"}},
+		}
+
+		must.Eq(t, &cloudinit.NetworkConfig{
+			Ethernets: []cloudinit.Ethernet{
+				{
+					Name:        "interface1",
+					MAC:         "52:54:00:fe:dc:ba",
+					DHCP4:       true,
+					DHCP6:       true,
+					Nameservers: &cloudinit.Nameservers{Addresses: []string{"fd00::1"}},
+				},
+			},
+		}, config.CloudInitConfig().NetworkConfig)
+	})
+
+	t.Run("task dns without interfaces", func(t *testing.T) {
+		config := &Config{DNS: &drivers.DNSConfig{Servers: []string{"1.1.1.1"}}}
+		must.Nil(t, config.CloudInitConfig().NetworkConfig)
+	})
+}
+
+func TestConfig_Copy(t *testing.T) {
+	config := &Config{
+		Name:     "test-vm",
+		CPUs:     2,
+		Memory:   600,
+		CMDs:     []string{"echo hello"},
+		AllocID:  "0ea818bc",
+		TaskName: "web",
+		MBits:    100,
+		NetworkInterfaces: net.NetworkInterfacesConfig{
+			{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"}},
+		},
+		DNS: &drivers.DNSConfig{Servers: []string{"10.0.0.53"}},
+	}
+
+	copied := config.Copy()
+	must.Eq(t, config, copied)
+	must.Eq(t, config.InterfaceHwaddr(0), copied.InterfaceHwaddr(0))
+
+	copied.CMDs[0] = "echo goodbye"
+	copied.DNS.Servers[0] = "10.0.0.54"
+	must.Eq(t, "echo hello", config.CMDs[0])
+	must.Eq(t, "10.0.0.53", config.DNS.Servers[0])
+}
+
+func TestConfig_InterfaceHwaddr(t *testing.T) {
+	ifaces := net.NetworkInterfacesConfig{
+		{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"}},
+		{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr1"}, MAC: "52:54:00:65:43:21"},
+	}
+
+	t.Run("derived", func(t *testing.T) {
+		config := &Config{NetworkInterfaces: ifaces, AllocID: "0ea818bc", TaskName: "web"}
+		must.Eq(t, net.DeriveHwaddr("0ea818bc", "web", 0), config.InterfaceHwaddr(0))
+		must.Eq(t, "52:54:00:65:43:21", config.InterfaceHwaddr(1))
+	})
+
+	t.Run("unknown task", func(t *testing.T) {
+		config := &Config{NetworkInterfaces: ifaces}
+		must.Eq(t, "", config.InterfaceHwaddr(0))
+		must.Eq(t, "52:54:00:65:43:21", config.InterfaceHwaddr(1))
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		// The isolation interface is added ahead of the interfaces of the
+		// job specification, which keep the addresses derived without it.
+		isolated := append(net.NetworkInterfacesConfig{
+			{Isolation: &net.NetworkInterfaceIsolationConfig{Device: "nomad"}},
+		}, ifaces...)
+		isolated = append(isolated, &net.NetworkInterfaceConfig{
+			Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr2"},
+		})
+
+		config := &Config{NetworkInterfaces: isolated, AllocID: "0ea818bc", TaskName: "web"}
+		must.Eq(t, net.DeriveIsolationHwaddr("0ea818bc", "web"), config.InterfaceHwaddr(0))
+		must.Eq(t, net.DeriveHwaddr("0ea818bc", "web", 0), config.InterfaceHwaddr(1))
+		must.Eq(t, "52:54:00:65:43:21", config.InterfaceHwaddr(2))
+		must.Eq(t, net.DeriveHwaddr("0ea818bc", "web", 2), config.InterfaceHwaddr(3))
+	})
+}
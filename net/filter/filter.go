@@ -4,11 +4,37 @@
 package filter
 
 import (
+	"errors"
+
 	"github.com/hashicorp/go-hclog"
 	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
+const (
+	// BackendIPTables is the name of the iptables filter implementation.
+	BackendIPTables = "iptables"
+
+	// BackendNFTables is the name of the nftables filter implementation.
+	BackendNFTables = "nftables"
+)
+
+// ErrLoopbackNotEnabled is returned when configuring a port forward from a
+// loopback address, and the host has not been configured to route localnet
+// packets to the destination device.
+var ErrLoopbackNotEnabled = errors.New("loopback port forwarding not enabled")
+
+// ErrHostNetworkUnavailable is returned when configuring a port forward from
+// the address of a Nomad host network, and the address is not assigned to any
+// interface of the host.
+var ErrHostNetworkUnavailable = errors.New("host network address not assigned to a host interface")
+
+// Backends is the list of available filter implementations.
+var Backends = []string{
+	BackendIPTables,
+	BackendNFTables,
+}
+
 // Filter is the interface to add and remove packet filtering
 // configuration for virt tasks.
 type Filter interface {
@@ -16,3 +42,45 @@ type Filter interface {
 	Configure(*drivers.Resources, *virtnet.NetworkInterfaceBridgeConfig, string) (*virtnet.FilterRemoval, error)
 	Teardown(*virtnet.FilterRemoval) error
 }
+
+// Reconciler is implemented by the filters which can repair their
+// configuration after it has been modified outside of the driver, such as
+// when another tool flushes the tables the filter uses.
+type Reconciler interface {
+	// Reconcile ensures the packet filter contains the base configuration of
+	// the filter and the configuration described by each FilterRemoval of
+	// the live tasks, re-adding anything which is missing. When prune is
+	// set, configuration which does not belong to any of the FilterRemovals
+	// is removed.
+	Reconcile(removals []*virtnet.FilterRemoval, prune bool) (*Drift, error)
+}
+
+// Drift describes the differences found between the packet filter and the
+// expected configuration while reconciling.
+type Drift struct {
+	// Restored is the number of missing rules and chains re-added for each
+	// FilterRemoval, in the order the removals were passed.
+	Restored []int
+
+	// BaseRestored is the number of missing rules and chains of the base
+	// configuration which were re-added.
+	BaseRestored int
+
+	// Removed is the number of stray rules and chains which were removed.
+	Removed int
+}
+
+// Empty returns if no drift was found.
+func (d *Drift) Empty() bool {
+	if d == nil {
+		return true
+	}
+
+	for _, restored := range d.Restored {
+		if restored > 0 {
+			return false
+		}
+	}
+
+	return d.BaseRestored == 0 && d.Removed == 0
+}
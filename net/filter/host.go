@@ -0,0 +1,103 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// RouteLocalnetPathTemplate is the template for generating the path to check for device specific routing support.
+	RouteLocalnetPathTemplate = "/proc/sys/net/ipv4/conf/%s/route_localnet"
+
+	// RouteLocalnetGlobalName is the name of the global kernel configuration for localnet routing.
+	RouteLocalnetGlobalName = "all"
+)
+
+// LoopbackPortForwardsSupported returns if the host has been configured for
+// routing localnet packets to the device. The path template defaults to
+// RouteLocalnetPathTemplate when empty.
+// NOTE: The global configuration overrides device specific configuration.
+func LoopbackPortForwardsSupported(logger hclog.Logger, pathTemplate, device string) bool {
+	if pathTemplate == "" {
+		pathTemplate = RouteLocalnetPathTemplate
+	}
+
+	for _, configName := range []string{RouteLocalnetGlobalName, device} {
+		cfgPath := fmt.Sprintf(pathTemplate, configName)
+		content, err := os.ReadFile(cfgPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			logger.Error("read failed for device loopback support check", "path", cfgPath, "error", err)
+			return false
+		}
+
+		if strings.TrimSpace(string(content)) == "1" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InterfaceByIP identifies which host network interface the passed IP
+// address is linked to.
+func InterfaceByIP(ip net.IP) (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	for _, iface := range interfaces {
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				if iip, _, err := net.ParseCIDR(addr.String()); err == nil {
+					if iip.Equal(ip) {
+						return iface.Name, nil
+					}
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("failed to find interface for IP %q", ip.String())
+}
+
+// RoutingInterfaceByIP returns the name of the interface that can be used
+// to reach the provided address.
+func RoutingInterfaceByIP(ip string) (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	checkAddr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+
+	for _, iface := range interfaces {
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+					if prefix.Contains(checkAddr) {
+						return iface.Name, nil
+					}
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("failed to find interface for IP %q", ip)
+}
@@ -0,0 +1,76 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package filter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+func TestLoopbackPortForwardsSupported(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		deviceContent string // empty string will result in no file
+		globalContent string // empty string will result in no file
+		result        bool
+	}{
+		{
+			desc:          "global only enabled",
+			globalContent: "1",
+			result:        true,
+		},
+		{
+			desc:          "device only route localnet enabled",
+			deviceContent: "1",
+			result:        true,
+		},
+		{
+			desc:          "global disabled device enabled",
+			globalContent: "0",
+			deviceContent: "1",
+			result:        true,
+		},
+		{
+			desc:          "global disabled device disabled",
+			globalContent: "0",
+			deviceContent: "0",
+			result:        false,
+		},
+		{
+			desc:   "all route localnet missing",
+			result: false,
+		},
+	}
+
+	deviceName := "test-dev"
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpl := filepath.Join(t.TempDir(), "%s_route_localnet")
+			if tc.globalContent != "" {
+				must.NoError(t, os.WriteFile(fmt.Sprintf(tmpl, RouteLocalnetGlobalName), []byte(tc.globalContent), 0644))
+			}
+
+			if tc.deviceContent != "" {
+				must.NoError(t, os.WriteFile(fmt.Sprintf(tmpl, deviceName), []byte(tc.deviceContent), 0644))
+			}
+
+			must.Eq(t, tc.result, LoopbackPortForwardsSupported(hclog.NewNullLogger(), tmpl, deviceName))
+		})
+	}
+}
+
+func TestRoutingInterfaceByIP(t *testing.T) {
+	name, err := RoutingInterfaceByIP("127.0.0.1")
+	must.NoError(t, err)
+	must.NotEq(t, "", name)
+
+	_, err = RoutingInterfaceByIP("not-an-ip")
+	must.Error(t, err)
+}
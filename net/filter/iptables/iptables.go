@@ -8,6 +8,8 @@ import (
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
 )
 
 var (
@@ -19,14 +21,12 @@ var (
 )
 
 const (
-	// routeLocalnetPathTemplate is the template for generating the path to check for device specific routing support.
-	routeLocalnetPathTemplate = "/proc/sys/net/ipv4/conf/%s/route_localnet"
-
-	// routeLocalnetGlobalName is the name of the global kernel configuration for localnet routing.
-	routeLocalnetGlobalName = "all"
-
 	// removalName is the name set in the FilterRemoval
 	removalName = "iptables"
+
+	// removalNameIPv6 is the name set in the FilterRemoval when the rules
+	// were added using ip6tables.
+	removalNameIPv6 = "ip6tables"
 )
 
 // Interface for iptables which defines the subset of functions
@@ -36,8 +36,10 @@ type IPTables interface {
 	AppendUnique(table, chain string, rulespec ...string) error
 	ChainExists(table, chain string) (bool, error)
 	ClearAndDeleteChain(table, chain string) error
+	ClearChain(table, chain string) error
 	DeleteChain(table, chain string) error
 	DeleteIfExists(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
 	InsertUnique(table, chain string, pos int, rulespec ...string) error
 	ListChains(table string) ([]string, error)
 	List(table, chain string) ([]string, error)
@@ -62,12 +64,21 @@ func New() (*virtTables, error) {
 
 	nt := &virtTables{
 		ipt:                        ipt,
-		interfaceByIPGetter:        getInterfaceByIP,
+		interfaceByIPGetter:        filter.InterfaceByIP,
 		names:                      NewNames(),
-		routingInterfaceByIPGetter: getRoutingInterfaceByIP,
+		routingInterfaceByIPGetter: filter.RoutingInterfaceByIP,
 		logger:                     hclog.Default().Named("iptables"),
 	}
 
+	// IPv6 support is optional, so failing to find ip6tables is not
+	// terminal; port forwarding for IPv6 addresses will be unavailable.
+	ipt6, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		nt.logger.Warn("ip6tables unavailable, IPv6 port forwarding disabled", "error", err)
+	} else {
+		nt.ipt6 = ipt6
+	}
+
 	if err := nt.setup(); err != nil {
 		return nil, err
 	}
@@ -76,6 +87,33 @@ func New() (*virtTables, error) {
 	return singleton, nil
 }
 
+// Cleanup removes all the chains created by the iptables filter, and the
+// rules which jump to them. It is used when migrating to a different filter
+// implementation, and does not require the filter to have been created.
+func Cleanup(logger hclog.Logger) error {
+	ipt, err := iptables.New()
+	if err != nil {
+		return err
+	}
+
+	nt := &virtTables{
+		ipt:    ipt,
+		names:  NewNames(),
+		logger: logger,
+	}
+
+	if ipt6, err := iptables.NewWithProtocol(iptables.ProtocolIPv6); err == nil {
+		nt.ipt6 = ipt6
+	}
+
+	return nt.removeAll()
+}
+
+// OwnsRemoval returns if the FilterRemoval was created by the iptables filter.
+func OwnsRemoval(removal *virtnet.FilterRemoval) bool {
+	return removal != nil && (removal.Name == removalName || removal.Name == removalNameIPv6)
+}
+
 // isNotExistErr is a helper to check if the error was caused
 // by a rule or chain not existing.
 func isNotExistErr(err error) bool {
@@ -3,6 +3,12 @@
 
 package iptables
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
 const (
 	// defaultChainNameNomadPostrouting is the IPTables chain name used by the
 	// driver for postrouting rules. This is currently used for entries within
@@ -25,6 +31,12 @@ const (
 	// table specifically for handling the special case of loopback addresses.
 	defaultChainNameNomadOutput = "NOMAD_VT_OUT"
 
+	// defaultChainNameNomadEgress is the prefix of the IPTables chain names
+	// used by the driver for applying the egress policy of a task. Each task
+	// with a restricted egress policy has its own chain within the filter
+	// table, which is jumped to from the forward chain.
+	defaultChainNameNomadEgress = "NOMAD_VT_E"
+
 	// defaultChainNameOutput is the name of the output chain within iptables.
 	defaultChainNameOutput = "OUTPUT"
 
@@ -71,6 +83,7 @@ type NomadChainNames struct {
 	Postrouting string
 	Prerouting  string
 	Output      string
+	Egress      string
 }
 
 // NewNames creates a new instance with all values set to defaults.
@@ -86,6 +99,7 @@ func NewNames() *names {
 				Postrouting: defaultChainNameNomadPostrouting,
 				Prerouting:  defaultChainNameNomadPrerouting,
 				Output:      defaultChainNameNomadOutput,
+				Egress:      defaultChainNameNomadEgress,
 			},
 		},
 		tables: &TableNames{
@@ -94,3 +108,18 @@ func NewNames() *names {
 		},
 	}
 }
+
+// egressChain returns the name of the chain which applies the egress policy
+// of the task with the passed address on the passed bridge. The same address
+// may be used on different bridges, so both are included. Chain names are
+// limited to 28 characters, so a hash of them is used within the name.
+func (n *names) egressChain(bridge, ip string) string {
+	sum := sha256.Sum256([]byte(bridge + "/" + ip))
+	return n.chains.Nomad.Egress + "_" + hex.EncodeToString(sum[:4])
+}
+
+// isEgressChain returns if the passed chain name is the name of a task
+// egress chain.
+func (n *names) isEgressChain(name string) bool {
+	return strings.HasPrefix(name, n.chains.Nomad.Egress+"_")
+}
@@ -0,0 +1,588 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package iptables
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-set/v3"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+)
+
+// baseOwner is the owner of the chains and rules of the base configuration,
+// which do not belong to any FilterRemoval.
+const baseOwner = -1
+
+// ruleGroup is an ordered group of rules within a chain which belong to the
+// same owner. The owner is the index of the FilterRemoval the rules were
+// taken from, or baseOwner.
+type ruleGroup struct {
+	owner int
+	rules []*rule
+}
+
+// ownedChain is a chain created by the driver, along with the rules it is
+// expected to contain.
+type ownedChain struct {
+	table  string
+	name   string
+	owner  int
+	groups []*ruleGroup
+
+	// create is set when the chain should be created if missing.
+	create bool
+
+	// known is set when the rules the chain should contain are known, so
+	// stray rules can be identified. The content of task egress chains is
+	// not known when the task state was written before it was recorded.
+	known bool
+}
+
+// group returns the rule group of the owner, adding it if it does not exist.
+func (o *ownedChain) group(owner int) *ruleGroup {
+	if len(o.groups) > 0 && o.groups[len(o.groups)-1].owner == owner {
+		return o.groups[len(o.groups)-1]
+	}
+
+	g := &ruleGroup{owner: owner}
+	o.groups = append(o.groups, g)
+	return g
+}
+
+// ruleCount returns the number of rules the chain should contain.
+func (o *ownedChain) ruleCount() int {
+	count := 0
+	for _, g := range o.groups {
+		count += len(g.rules)
+	}
+
+	return count
+}
+
+// expectedState is the configuration the filter is expected to contain for
+// an address family.
+type expectedState struct {
+	// chains are the chains created by the driver, in creation order.
+	chains []*ownedChain
+
+	// jumps are the rules within the builtin chains which jump to the chains
+	// created by the driver.
+	jumps []*rule
+}
+
+// Reconcile re-adds the chains and rules of the base configuration and of
+// the passed FilterRemovals which are missing. A group of rules belonging to
+// a FilterRemoval is re-added as a whole when any of its rules is missing, so
+// the order of the rules within the group is kept. When prune is set, the
+// chains created by the driver which contain unexpected rules are rebuilt,
+// and task egress chains which do not belong to any FilterRemoval are
+// removed.
+func (n *virtTables) Reconcile(removals []*virtnet.FilterRemoval, prune bool) (*filter.Drift, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	drift := &filter.Drift{Restored: make([]int, len(removals))}
+	record := func(owner, count int) {
+		if owner == baseOwner {
+			drift.BaseRestored += count
+		} else {
+			drift.Restored[owner] += count
+		}
+	}
+
+	var mErr *multierror.Error
+	for _, ipv6 := range []bool{false, true} {
+		req := newRequest()
+		req.ipv6 = ipv6
+
+		ipt, err := n.backend(req)
+		if err != nil {
+			// ip6tables being unavailable means there is nothing to reconcile.
+			continue
+		}
+
+		state, err := n.expectedState(removals, ipv6)
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+		}
+
+		removed, err := n.reconcileState(ipt, state, prune, record)
+		drift.Removed += removed
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+		}
+	}
+
+	return drift, mErr.ErrorOrNil()
+}
+
+// reconcileState applies the expected state of an address family, recording
+// the number of restored chains and rules of each owner. It returns the
+// number of stray rules and chains removed.
+func (n *virtTables) reconcileState(ipt IPTables, state *expectedState, prune bool,
+	record func(owner, count int)) (int, error) {
+
+	var mErr *multierror.Error
+	removed := 0
+
+	// Start with creating any missing chains, as the rules may jump to them.
+	existing := set.New[string](len(state.chains))
+	for _, oc := range state.chains {
+		exists, err := ipt.ChainExists(oc.table, oc.name)
+		if err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("failed to check chain existence: %w", err))
+			continue
+		}
+
+		if !exists {
+			if !oc.create {
+				if !oc.known {
+					n.logger.Warn("unable to restore task egress chain, restart the task to restore it",
+						"chain", oc.name)
+				}
+				continue
+			}
+
+			if err := ipt.NewChain(oc.table, oc.name); err != nil {
+				mErr = multierror.Append(mErr, fmt.Errorf("failed to create new chain: %w", err))
+				continue
+			}
+
+			n.logger.Debug("restored missing chain", "table", oc.table, "chain", oc.name)
+			record(oc.owner, 1)
+		}
+
+		existing.Insert(oc.table + oc.name)
+	}
+
+	// Add the jump rules into the chains.
+	for _, r := range state.jumps {
+		if !existing.Contains(r.table + r.jumpTarget()) {
+			continue
+		}
+
+		exists, err := ipt.Exists(r.table, r.chain, r.spec...)
+		if err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("failed to check rule existence: %w", err))
+			continue
+		}
+
+		if !exists {
+			if err := ipt.InsertUnique(r.table, r.chain, r.position, r.spec...); err != nil {
+				mErr = multierror.Append(mErr, fmt.Errorf("failed to add rule: %w", err))
+				continue
+			}
+
+			n.logger.Debug("restored missing rule", "table", r.table, "rule", r.String())
+			record(baseOwner, 1)
+		}
+	}
+
+	for _, oc := range state.chains {
+		if !existing.Contains(oc.table + oc.name) {
+			continue
+		}
+
+		// Rules jumping to chains which could not be restored cannot be
+		// added.
+		for _, g := range oc.groups {
+			g.rules = slices.DeleteFunc(g.rules, func(r *rule) bool {
+				target := r.jumpTarget()
+				return n.names.isEgressChain(target) && !existing.Contains(r.table+target)
+			})
+		}
+
+		for _, g := range oc.groups {
+			restored, err := n.reconcileGroup(ipt, g)
+			record(g.owner, restored)
+			if err != nil {
+				mErr = multierror.Append(mErr, err)
+			}
+		}
+
+		if !prune || !oc.known {
+			continue
+		}
+
+		stray, err := n.pruneChain(ipt, oc)
+		removed += stray
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+		}
+	}
+
+	if prune {
+		stray, err := n.pruneEgressChains(ipt, state)
+		removed += stray
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+		}
+	}
+
+	return removed, mErr.ErrorOrNil()
+}
+
+// reconcileGroup re-adds the rule group when any of its rules is missing and
+// returns the number of missing rules. The present rules of the group are
+// removed first, so the rules are re-added in order.
+func (n *virtTables) reconcileGroup(ipt IPTables, g *ruleGroup) (int, error) {
+	missing := 0
+	for _, r := range g.rules {
+		exists, err := ipt.Exists(r.table, r.chain, r.spec...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check rule existence: %w", err)
+		}
+
+		if !exists {
+			missing++
+		}
+	}
+
+	if missing == 0 {
+		return 0, nil
+	}
+
+	if missing < len(g.rules) {
+		for _, r := range g.rules {
+			if err := ipt.DeleteIfExists(r.table, r.chain, r.spec...); err != nil && !isNotExistErr(err) {
+				return 0, fmt.Errorf("failed to delete rule: %w", err)
+			}
+		}
+	}
+
+	for _, r := range g.rules {
+		var err error
+		if r.position > 0 {
+			err = ipt.InsertUnique(r.table, r.chain, r.position, r.spec...)
+		} else {
+			err = ipt.AppendUnique(r.table, r.chain, r.spec...)
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("failed to add rule: %w", err)
+		}
+		n.logger.Debug("re-added rule", "table", r.table, "rule", r.String())
+	}
+
+	return missing, nil
+}
+
+// pruneChain rebuilds the chain when it contains rules which are not
+// expected, which removes the stray rules. It returns the number of rules
+// removed.
+func (n *virtTables) pruneChain(ipt IPTables, oc *ownedChain) (int, error) {
+	listed, err := ipt.List(oc.table, oc.name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chain rules: %w", err)
+	}
+
+	expected := make(map[string]int, oc.ruleCount())
+	for _, g := range oc.groups {
+		for _, r := range g.rules {
+			expected[normalizeRule(r.String())]++
+		}
+	}
+
+	stray := 0
+	for _, entry := range listed {
+		if !strings.HasPrefix(entry, "-A ") {
+			continue
+		}
+
+		key := normalizeRule(entry)
+		if expected[key] > 0 {
+			expected[key]--
+			continue
+		}
+		stray++
+	}
+
+	if stray == 0 {
+		return 0, nil
+	}
+
+	n.logger.Debug("removing stray rules", "table", oc.table, "chain", oc.name, "count", stray)
+	if err := ipt.ClearChain(oc.table, oc.name); err != nil {
+		return 0, fmt.Errorf("failed to clear chain: %w", err)
+	}
+
+	for _, g := range oc.groups {
+		for _, r := range g.rules {
+			if r.position > 0 {
+				err = ipt.InsertUnique(r.table, r.chain, r.position, r.spec...)
+			} else {
+				err = ipt.AppendUnique(r.table, r.chain, r.spec...)
+			}
+			if err != nil {
+				return stray, fmt.Errorf("failed to add rule: %w", err)
+			}
+		}
+	}
+
+	return stray, nil
+}
+
+// normalizeRule converts the rule into the form iptables lists it in, so
+// the rules built by the driver can be compared with the listed rules.
+// iptables lists source and destination addresses with their prefix length.
+func normalizeRule(entry string) string {
+	fields := strings.Fields(entry)
+	for i := 1; i < len(fields); i++ {
+		if fields[i-1] != "-s" && fields[i-1] != "-d" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(fields[i])
+		if err != nil {
+			continue
+		}
+		fields[i] = netip.PrefixFrom(addr, addr.BitLen()).String()
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// pruneEgressChains removes the task egress chains which do not belong to
+// any FilterRemoval. It returns the number of chains removed.
+func (n *virtTables) pruneEgressChains(ipt IPTables, state *expectedState) (int, error) {
+	chains, err := ipt.ListChains(n.names.tables.Filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chains: %w", err)
+	}
+
+	var mErr *multierror.Error
+	removed := 0
+	for _, name := range chains {
+		if !n.names.isEgressChain(name) || slices.ContainsFunc(state.chains, func(oc *ownedChain) bool {
+			return oc.table == n.names.tables.Filter && oc.name == name
+		}) {
+			continue
+		}
+
+		n.logger.Debug("removing stray chain", "table", n.names.tables.Filter, "chain", name)
+		if err := ipt.ClearAndDeleteChain(n.names.tables.Filter, name); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("failed to delete chain: %w", err))
+			continue
+		}
+		removed++
+	}
+
+	return removed, mErr.ErrorOrNil()
+}
+
+// expectedState builds the configuration the filter is expected to contain
+// for the address family from the base configuration and the FilterRemovals.
+// Removals which cannot be decoded are skipped and reported in the returned
+// error.
+func (n *virtTables) expectedState(removals []*virtnet.FilterRemoval, ipv6 bool) (*expectedState, error) {
+	name := removalName
+	if ipv6 {
+		name = removalNameIPv6
+	}
+
+	prerouting := &ownedChain{
+		table:  n.names.tables.NAT,
+		name:   n.names.chains.Nomad.Prerouting,
+		owner:  baseOwner,
+		create: true,
+		known:  true,
+	}
+	forward := &ownedChain{
+		table:  n.names.tables.Filter,
+		name:   n.names.chains.Nomad.Forward,
+		owner:  baseOwner,
+		create: true,
+		known:  true,
+	}
+	output := &ownedChain{
+		table: n.names.tables.NAT,
+		name:  n.names.chains.Nomad.Output,
+		owner: baseOwner,
+		known: true,
+	}
+	postrouting := &ownedChain{
+		table: n.names.tables.NAT,
+		name:  n.names.chains.Nomad.Postrouting,
+		owner: baseOwner,
+		known: true,
+	}
+
+	state := &expectedState{
+		chains: []*ownedChain{prerouting, forward, output, postrouting},
+		jumps: []*rule{
+			{
+				table:    n.names.tables.NAT,
+				chain:    n.names.chains.Prerouting,
+				position: 1,
+				spec:     []string{"-j", n.names.chains.Nomad.Prerouting},
+			},
+			{
+				table:    n.names.tables.Filter,
+				chain:    n.names.chains.Forward,
+				position: 1,
+				spec:     []string{"-j", n.names.chains.Nomad.Forward},
+			},
+		},
+	}
+
+	var mErr *multierror.Error
+	egress := map[string]*ownedChain{}
+	masquerades := set.NewHashSet[*rule](0)
+
+	for i, removal := range removals {
+		// Removals of the other address family, or created by a different
+		// filter implementation, do not apply.
+		if removal == nil || removal.Data == nil || removal.Name != name {
+			continue
+		}
+
+		rules, err := decodeRules(removal.Data)
+		if err != nil {
+			n.logger.Error("invalid reconcile data received", "name", removal.Name,
+				"type", hclog.Fmt("%T", removal.Data), "error", err)
+			mErr = multierror.Append(mErr, fmt.Errorf("invalid reconcile data: %w", err))
+			continue
+		}
+
+		for _, r := range rules.slice() {
+			switch {
+			case r.chain == n.names.chains.Nomad.Prerouting:
+				prerouting.group(i).rules = append(prerouting.group(i).rules, r)
+
+			case r.chain == n.names.chains.Nomad.Forward:
+				forward.group(i).rules = append(forward.group(i).rules, r)
+
+				// The position of the rule is not recorded, but jumps to the
+				// task egress chains are always inserted first.
+				if target := r.jumpTarget(); n.names.isEgressChain(target) {
+					r.position = 1
+					if egress[target] == nil {
+						egress[target] = &ownedChain{
+							table: r.table,
+							name:  target,
+							owner: i,
+						}
+					}
+				}
+
+			case r.chain == n.names.chains.Nomad.Postrouting:
+				postrouting.group(i).rules = append(postrouting.group(i).rules, r)
+
+			case r.chain == n.names.chains.Nomad.Output:
+				output.group(i).rules = append(output.group(i).rules, r)
+
+				if masquerade := n.masqueradeRule(r); masquerade != nil {
+					masquerades.Insert(masquerade)
+				}
+
+			case n.names.isEgressChain(r.chain):
+				oc := egress[r.chain]
+				if oc == nil {
+					oc = &ownedChain{
+						table: r.table,
+						name:  r.chain,
+						owner: i,
+					}
+					egress[r.chain] = oc
+				}
+				oc.group(i).rules = append(oc.group(i).rules, r)
+
+			default:
+				n.logger.Debug("skipping unknown rule during reconcile", "table", r.table, "rule", r.String())
+			}
+		}
+	}
+
+	// The loopback port forwarding chains are only required while a task
+	// forwards ports from a loopback address. The masquerade rules are added
+	// ahead of the source translation rules of the tasks.
+	if len(output.groups) > 0 {
+		output.create = true
+		postrouting.create = true
+
+		masquerade := &ruleGroup{owner: baseOwner, rules: masquerades.Slice()}
+		slices.SortFunc(masquerade.rules, func(a, b *rule) int {
+			return strings.Compare(a.Hash(), b.Hash())
+		})
+		postrouting.groups = slices.Insert(postrouting.groups, 0, masquerade)
+
+		state.jumps = append(state.jumps, &rule{
+			table:    n.names.tables.NAT,
+			chain:    n.names.chains.Output,
+			position: 1,
+			spec:     []string{"-j", n.names.chains.Nomad.Output},
+		})
+	}
+
+	// The postrouting chain is also required while a task translates the
+	// source of its connections to an egress address.
+	if len(postrouting.groups) > 0 {
+		postrouting.create = true
+
+		state.jumps = append(state.jumps, &rule{
+			table:    n.names.tables.NAT,
+			chain:    n.names.chains.Postrouting,
+			position: 1,
+			spec:     []string{"-j", n.names.chains.Nomad.Postrouting},
+		})
+	}
+
+	// Egress chains are created before the forward chain rules jump to them.
+	// The content of chains recorded before it was included within the
+	// FilterRemoval is unknown, so they are not created.
+	egressNames := make([]string, 0, len(egress))
+	for name := range egress {
+		egressNames = append(egressNames, name)
+	}
+	slices.Sort(egressNames)
+
+	for _, name := range egressNames {
+		oc := egress[name]
+		oc.known = len(oc.groups) > 0
+		oc.create = oc.known
+		state.chains = append(state.chains, oc)
+	}
+
+	return state, mErr.ErrorOrNil()
+}
+
+// masqueradeRule returns the rule which allows the loopback port forward
+// rule to reach the destination device. It returns nil when the device
+// cannot be identified.
+func (n *virtTables) masqueradeRule(r *rule) *rule {
+	i := slices.Index(r.spec, "--to-destination")
+	if i < 0 || i+1 >= len(r.spec) || n.routingInterfaceByIPGetter == nil {
+		return nil
+	}
+
+	// The destination of a port range does not include the port.
+	ip, _, err := net.SplitHostPort(r.spec[i+1])
+	if err != nil {
+		addr, err := netip.ParseAddr(r.spec[i+1])
+		if err != nil {
+			return nil
+		}
+		ip = addr.String()
+	}
+
+	dstIface, err := n.routingInterfaceByIPGetter(ip)
+	if err != nil {
+		n.logger.Warn("failed to identify loopback port forward device", "ip", ip, "error", err)
+		return nil
+	}
+
+	return &rule{
+		table: n.names.tables.NAT,
+		chain: n.names.chains.Nomad.Postrouting,
+		spec: []string{"-o", dstIface, "-m", "addrtype", "--src-type", "LOCAL",
+			"--dst-type", "UNICAST", "-j", "MASQUERADE"},
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	mock_iptables "github.com/hashicorp/nomad-driver-virt/testutil/mock/iptables"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+)
+
+func Test_virtTables_Reconcile(t *testing.T) {
+	// baseChains returns the expected calls checking the chains and jump
+	// rules of the base configuration, which are all present.
+	baseChains := func(n *names) []any {
+		return []any{
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: true},
+			mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.Exists{Table: "nat", Chain: "PREROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Prerouting}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}, Result: true},
+		}
+	}
+
+	t.Run("in sync", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}, Result: true},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+					"--to-destination", "192.168.122.10:8080"}},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.True(t, drift.Empty())
+	})
+
+	t.Run("missing base configuration", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: true},
+			mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.NewChain{Table: "filter", Chain: n.chains.Nomad.Forward},
+			mock_iptables.Exists{Table: "nat", Chain: "PREROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Prerouting}},
+			mock_iptables.Exists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}},
+			mock_iptables.InsertUnique{Table: "nat", Chain: "PREROUTING", Pos: 1, RuleSpec: []string{
+				"-j", n.chains.Nomad.Prerouting}},
+			mock_iptables.InsertUnique{Table: "filter", Chain: "FORWARD", Pos: 1, RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile(nil, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{}, BaseRestored: 3}, drift)
+	})
+
+	t.Run("missing task rules", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}, Result: true},
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.11:8080"}},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", "192.168.122.11", "-j", "ACCEPT"}},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.11:8080"}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", "192.168.122.11", "-j", "ACCEPT"}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+					"--to-destination", "192.168.122.10:8080"}},
+			},
+			{
+				Name: removalName,
+				Data: Rules{
+					{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+						"--to-destination", "192.168.122.11:8080"},
+					{"filter", n.chains.Nomad.Forward, "-d", "192.168.122.11", "-j", "ACCEPT"},
+				},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{0, 2}}, drift)
+	})
+
+	t.Run("partially missing task rules", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", "192.168.122.0/24", "-d", "192.168.122.10", "-j", "ACCEPT"}},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", "192.168.122.10", "-j", "DROP"}, Result: true},
+			mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", "192.168.122.0/24", "-d", "192.168.122.10", "-j", "ACCEPT"}},
+			mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", "192.168.122.10", "-j", "DROP"}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", "192.168.122.0/24", "-d", "192.168.122.10", "-j", "ACCEPT"}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", "192.168.122.10", "-j", "DROP"}},
+		)
+		defer ipt.AssertExpectations()
+
+		// The present rule is removed so the rules are re-added in order.
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{
+					{"filter", n.chains.Nomad.Forward, "-s", "192.168.122.0/24", "-d", "192.168.122.10", "-j", "ACCEPT"},
+					{"filter", n.chains.Nomad.Forward, "-d", "192.168.122.10", "-j", "DROP"},
+				},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{1}}, drift)
+	})
+
+	t.Run("restored data", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: []any{
+					[]any{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+						"--to-destination", "192.168.122.10:8080"},
+				},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{1}}, drift)
+	})
+
+	t.Run("skips other removals", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...)
+		defer ipt.AssertExpectations()
+
+		// IPv6 removals are skipped when ip6tables is unavailable, and
+		// removals of other filters never apply.
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalNameIPv6,
+				Data: Rules{{"nat", n.chains.Nomad.Prerouting, "-d", "fd00::1", "-j", "DNAT",
+					"--to-destination", "[fd00::10]:8080"}},
+			},
+			{Name: "nftables", Data: map[string]any{"handles": []any{1}}},
+			nil,
+		}, false)
+		must.NoError(t, err)
+		must.True(t, drift.Empty())
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		_, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{Name: removalName, Data: map[string]any{"forwards": 1}},
+		}, false)
+		must.ErrorContains(t, err, "invalid reconcile data")
+	})
+
+	t.Run("loopback", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: true},
+			mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.NewChain{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.NewChain{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.Exists{Table: "nat", Chain: "PREROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Prerouting}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}, Result: true},
+			mock_iptables.Exists{Table: "nat", Chain: "OUTPUT", RuleSpec: []string{
+				"-j", n.chains.Nomad.Output}},
+			mock_iptables.Exists{Table: "nat", Chain: "POSTROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Postrouting}},
+			mock_iptables.InsertUnique{Table: "nat", Chain: "OUTPUT", Pos: 1, RuleSpec: []string{
+				"-j", n.chains.Nomad.Output}},
+			mock_iptables.InsertUnique{Table: "nat", Chain: "POSTROUTING", Pos: 1, RuleSpec: []string{
+				"-j", n.chains.Nomad.Postrouting}},
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Output, RuleSpec: []string{
+				"-d", "127.0.0.1", "-p", "tcp", "--dport", "8080", "-j", "DNAT",
+				"--to-destination", "192.168.122.10:80"}},
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+				"-o", "virbr0", "-m", "addrtype", "--src-type", "LOCAL", "--dst-type", "UNICAST",
+				"-j", "MASQUERADE"}},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Output, RuleSpec: []string{
+				"-d", "127.0.0.1", "-p", "tcp", "--dport", "8080", "-j", "DNAT",
+				"--to-destination", "192.168.122.10:80"}},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+				"-o", "virbr0", "-m", "addrtype", "--src-type", "LOCAL", "--dst-type", "UNICAST",
+				"-j", "MASQUERADE"}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n),
+			WithRoutingInterfaceByIPGetter(func(ip string) (string, error) {
+				must.Eq(t, "192.168.122.10", ip)
+				return "virbr0", nil
+			}),
+		)
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"nat", n.chains.Nomad.Output, "-d", "127.0.0.1", "-p", "tcp", "--dport", "8080",
+					"-j", "DNAT", "--to-destination", "192.168.122.10:80"}},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{1}, BaseRestored: 5}, drift)
+	})
+
+	t.Run("egress address", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: true},
+			mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.NewChain{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.Exists{Table: "nat", Chain: "PREROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Prerouting}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}, Result: true},
+			mock_iptables.Exists{Table: "nat", Chain: "POSTROUTING", RuleSpec: []string{
+				"-j", n.chains.Nomad.Postrouting}},
+			mock_iptables.InsertUnique{Table: "nat", Chain: "POSTROUTING", Pos: 1, RuleSpec: []string{
+				"-j", n.chains.Nomad.Postrouting}},
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+				"-s", "192.168.122.10", "-o", "eth1", "-j", "SNAT", "--to-source", "10.0.0.2"}},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+				"-s", "192.168.122.10", "-o", "eth1", "-j", "SNAT", "--to-source", "10.0.0.2"}},
+		)
+		defer ipt.AssertExpectations()
+
+		// The output chain is only created for loopback port forwards.
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"nat", n.chains.Nomad.Postrouting, "-s", "192.168.122.10", "-o", "eth1",
+					"-j", "SNAT", "--to-source", "10.0.0.2"}},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{1}, BaseRestored: 2}, drift)
+	})
+
+	t.Run("egress", func(t *testing.T) {
+		n := TestNewNames()
+		taskIP := "10.0.22.33"
+		egressChain := n.egressChain("virbr0", taskIP)
+
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.ChainExists{Table: "filter", Chain: egressChain},
+			mock_iptables.NewChain{Table: "filter", Chain: egressChain},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-d", "10.0.0.0/8", "-j", "RETURN"}},
+			mock_iptables.Exists{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-j", "DROP"}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-d", "10.0.0.0/8", "-j", "RETURN"}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-j", "DROP"}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{
+					{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain},
+					{"filter", egressChain, "-d", "10.0.0.0/8", "-j", "RETURN"},
+					{"filter", egressChain, "-j", "DROP"},
+				},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{3}}, drift)
+	})
+
+	t.Run("missing egress jump", func(t *testing.T) {
+		n := TestNewNames()
+		taskIP := "10.0.22.33"
+		egressChain := n.egressChain("virbr0", taskIP)
+
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.ChainExists{Table: "filter", Chain: egressChain, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", taskIP, "-p", "tcp", "--dport", "22", "-j", "ACCEPT"}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+			mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", taskIP, "-p", "tcp", "--dport", "22", "-j", "ACCEPT"}},
+			mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-d", taskIP, "-p", "tcp", "--dport", "22", "-j", "ACCEPT"}},
+			mock_iptables.InsertUnique{Table: "filter", Chain: n.chains.Nomad.Forward, Pos: 1, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+			mock_iptables.Exists{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-j", "DROP"}, Result: true},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{
+					{"filter", n.chains.Nomad.Forward, "-d", taskIP, "-p", "tcp", "--dport", "22", "-j", "ACCEPT"},
+					{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain},
+					{"filter", egressChain, "-j", "DROP"},
+				},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{1}}, drift)
+	})
+
+	t.Run("unknown egress content", func(t *testing.T) {
+		n := TestNewNames()
+		taskIP := "10.0.22.33"
+		egressChain := n.egressChain("virbr0", taskIP)
+
+		// The jump to the egress chain cannot be restored without the chain.
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.ChainExists{Table: "filter", Chain: egressChain},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+			},
+		}, false)
+		must.NoError(t, err)
+		must.True(t, drift.Empty())
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		n := TestNewNames()
+		strayChain := n.egressChain("virbr0", "10.0.22.44")
+
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.Exists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}, Result: true},
+			mock_iptables.List{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: []string{
+				"-N " + n.chains.Nomad.Prerouting,
+				"-A " + n.chains.Nomad.Prerouting + " -d 10.0.0.1/32 -j DNAT --to-destination 192.168.122.10:8080",
+				"-A " + n.chains.Nomad.Prerouting + " -d 10.0.0.1/32 -j DNAT --to-destination 192.168.122.99:8080",
+			}},
+			mock_iptables.ClearChain{Table: "nat", Chain: n.chains.Nomad.Prerouting},
+			mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+				"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}},
+			mock_iptables.List{Table: "filter", Chain: n.chains.Nomad.Forward, Result: []string{
+				"-N " + n.chains.Nomad.Forward,
+			}},
+			mock_iptables.ListChains{Table: "filter", Result: []string{
+				"INPUT", "FORWARD", n.chains.Nomad.Forward, strayChain,
+			}},
+			mock_iptables.ClearAndDeleteChain{Table: "filter", Chain: strayChain},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+					"--to-destination", "192.168.122.10:8080"}},
+			},
+		}, true)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{0}, Removed: 2}, drift)
+	})
+
+	t.Run("prune replaced rule", func(t *testing.T) {
+		n := TestNewNames()
+		taskIP := "10.0.22.33"
+		egressChain := n.egressChain("virbr0", taskIP)
+		accept := []string{"-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+			"--dport", "8080", "-j", "ACCEPT"}
+
+		ipt := mock_iptables.New(t).Expect(baseChains(n)...).Expect(
+			mock_iptables.ChainExists{Table: "filter", Chain: egressChain, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: accept, Result: true},
+			mock_iptables.Exists{Table: "filter", Chain: egressChain, RuleSpec: []string{
+				"-j", "DROP"}, Result: true},
+			mock_iptables.List{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: []string{
+				"-N " + n.chains.Nomad.Prerouting,
+			}},
+			mock_iptables.List{Table: "filter", Chain: n.chains.Nomad.Forward, Result: []string{
+				"-N " + n.chains.Nomad.Forward,
+				"-A " + n.chains.Nomad.Forward + " -d 10.0.22.99/32 -j ACCEPT",
+				"-A " + n.chains.Nomad.Forward + " -s 10.0.22.33/32 -i virbr0 -j " + egressChain,
+				"-A " + n.chains.Nomad.Forward + " -d 10.0.22.33/32 -p tcp -m state --state NEW -m tcp --dport 8080 -j ACCEPT",
+			}},
+			mock_iptables.ClearChain{Table: "filter", Chain: n.chains.Nomad.Forward},
+			mock_iptables.InsertUnique{Table: "filter", Chain: n.chains.Nomad.Forward, Pos: 1, RuleSpec: []string{
+				"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+			mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: accept},
+			mock_iptables.List{Table: "filter", Chain: egressChain, Result: []string{
+				"-N " + egressChain,
+				"-A " + egressChain + " -j DROP",
+			}},
+			mock_iptables.ListChains{Table: "filter", Result: []string{
+				"INPUT", "FORWARD", n.chains.Nomad.Forward, egressChain,
+			}},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		drift, err := vt.Reconcile([]*virtnet.FilterRemoval{
+			{
+				Name: removalName,
+				Data: Rules{
+					{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain},
+					append([]string{"filter", n.chains.Nomad.Forward}, accept...),
+					{"filter", egressChain, "-j", "DROP"},
+				},
+			},
+		}, true)
+		must.NoError(t, err)
+		must.Eq(t, &filter.Drift{Restored: []int{0}, Removed: 1}, drift)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		n := TestNewNames()
+		ipt := mock_iptables.New(t).Expect(
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Err: mock.MockTestErr},
+			mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+			mock_iptables.Exists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{
+				"-j", n.chains.Nomad.Forward}, Result: true},
+		)
+		defer ipt.AssertExpectations()
+
+		vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+		_, err := vt.Reconcile(nil, false)
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+}
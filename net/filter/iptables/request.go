@@ -21,6 +21,7 @@ func newRequest() *request {
 type request struct {
 	chains     *chains    // collection of chains
 	rules      *rules     // collection of rules
+	ipv6       bool       // request applies to ip6tables
 	stampValue uint       // value to stamp on rules/chains for sorting
 	m          sync.Mutex // mutex to sync stamping
 }
@@ -9,6 +9,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/hashicorp/go-set/v3"
 )
 
@@ -21,7 +22,12 @@ func (r Rules) Empty() bool {
 
 // rules converts the raw slice into a collection of rules.
 func (r Rules) rules() set.Collection[*rule] {
-	result := set.NewHashSet[*rule](0)
+	return set.HashSetFrom(r.slice())
+}
+
+// slice converts the raw slice into rules, preserving their order.
+func (r Rules) slice() []*rule {
+	result := make([]*rule, 0, len(r))
 	for _, entry := range r {
 		var newRule *rule
 		switch len(entry) {
@@ -34,12 +40,36 @@ func (r Rules) rules() set.Collection[*rule] {
 		default:
 			newRule = &rule{table: entry[0], chain: entry[1], spec: entry[2:]}
 		}
-		result.Insert(newRule)
+		result = append(result, newRule)
 	}
 
 	return result
 }
 
+// decodeRules converts the data of a FilterRemoval into rules. When the task
+// state has been restored, the data will have been decoded into generic
+// types and requires conversion.
+func decodeRules(data any) (Rules, error) {
+	if rules, ok := data.(Rules); ok {
+		return rules, nil
+	}
+
+	var rules Rules
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dec.Decode(data); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
 // stampFn is the signature for a stamping function.
 type stampFn func(stampable)
 
@@ -243,3 +273,13 @@ func (r *rule) slice() []string {
 func (r *rule) mkchain() *chain {
 	return &chain{table: r.table, chain: r.chain}
 }
+
+// jumpTarget returns the target of the rule, or an empty string when the rule
+// has no target.
+func (r *rule) jumpTarget() string {
+	if i := slices.Index(r.spec, "-j"); i >= 0 && i+1 < len(r.spec) {
+		return r.spec[i+1]
+	}
+
+	return ""
+}
@@ -8,18 +8,23 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
-	"os"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
 	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
-var errLoopbackNotEnabled = errors.New("loopback port forwarding not enabled")
+var (
+	errLoopbackNotEnabled   = filter.ErrLoopbackNotEnabled
+	errLoopbackNotSupported = errors.New("loopback port forwarding not supported for IPv6")
+	errIPv6NotAvailable     = errors.New("ip6tables is not available")
+)
 
 // virtTables implements the filter.Filter interface.
 type virtTables struct {
@@ -28,6 +33,11 @@ type virtTables struct {
 	names  *names
 	m      sync.Mutex
 
+	// ipt6 is the IPTables implementation used for IPv6 rules. It will be nil
+	// if ip6tables is not available on the host, in which case only IPv4 port
+	// forwarding is supported.
+	ipt6 IPTables
+
 	// Everything below is used for testing.
 
 	// routeLocalnetTemplate is a template for creating the path to the kernel
@@ -50,8 +60,12 @@ func (n *virtTables) SetLogger(logger hclog.Logger) {
 }
 
 // Configure configures iptables to enable port forwards based on the passed
-// resources and returns a collection of rules that can be used to remove
-// the configuration with Teardown function.
+// resources, and to apply the ingress and egress policies and the egress
+// address of the bridge config. It returns a collection of rules that can be
+// used to remove the configuration with Teardown function. The address family
+// of the passed IP determines if iptables or ip6tables is configured. Ports
+// with a host IP, policy entries and an egress address of a different address
+// family are skipped, as they cannot apply to the destination.
 func (n *virtTables) Configure(res *drivers.Resources, cfg *virtnet.NetworkInterfaceBridgeConfig, ip string) (rules *virtnet.FilterRemoval, err error) {
 	// Check that received values are suitable for configuration.
 	if res == nil {
@@ -62,24 +76,89 @@ func (n *virtTables) Configure(res *drivers.Resources, cfg *virtnet.NetworkInter
 		return nil, errors.New("cannot configure iptables, bridge config not provided")
 	}
 
-	// If the ports are nil, there's nothing to do.
-	if res.Ports == nil {
-		return &virtnet.FilterRemoval{Name: removalName}, nil
+	taskIP, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task IP address: %w", err)
+	}
+	ipv6 := taskIP.Unmap().Is6()
+
+	name := removalName
+	if ipv6 {
+		name = removalNameIPv6
+	}
+
+	// If the ports are nil, egress is unrestricted and the egress address is
+	// not set, there's nothing to do.
+	if res.Ports == nil && !cfg.Egress.Restricted() && cfg.EgressIP == "" {
+		return &virtnet.FilterRemoval{Name: name}, nil
+	}
+
+	var allocatedPorts structs.AllocatedPorts
+	if res.Ports != nil {
+		allocatedPorts = *res.Ports
+	}
+
+	if ipv6 && n.ipt6 == nil {
+		return nil, fmt.Errorf("cannot configure port forwarding for %q: %w", ip, errIPv6NotAvailable)
 	}
 
 	// Create lookup mapping for ip:interface-name, so we can cache reads of
 	// this and not have to perform the translation each time.
 	interfaceMapping := make(map[string]string)
 
+	// Ports placed on a Nomad host network are validated against the host
+	// interfaces, so a missing address is reported using the host network.
+	hostNetworks := virtnet.HostNetworks(res)
+
 	// Create a new request to build up the desired changes.
 	req := newRequest()
+	req.ipv6 = ipv6
 
 	// Iterate the ports configured within the network interface and pull these
 	// from the task allocated ports.
 	for _, port := range cfg.Ports {
-		reservedPort, ok := res.Ports.Get(port)
+		mapping, err := virtnet.ParsePortMapping(port)
+		if err != nil {
+			return nil, err
+		}
+		proto := string(mapping.Protocol)
+
+		reservedPort, ok := allocatedPorts.Get(mapping.Label)
 		if !ok {
-			n.logger.Error("failed to find reserved port", "port", port)
+			n.logger.Error("failed to find reserved port", "port", mapping.Label)
+			continue
+		}
+
+		// A port range is forwarded using a single rule, which leaves the
+		// destination port untouched as the ports are not translated.
+		dport := strconv.Itoa(reservedPort.Value)
+		taskPort := strconv.Itoa(virtnet.GuestPort(reservedPort))
+		destination := net.JoinHostPort(ip, taskPort)
+		if mapping.IsRange() {
+			first, last, ok, err := mapping.Range(allocatedPorts)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				n.logger.Error("failed to find reserved port", "port", mapping.LastLabel)
+				continue
+			}
+
+			dport = fmt.Sprintf("%d:%d", first.Value, last.Value)
+			taskPort = dport
+			destination = ip
+		}
+
+		// Parse the host IP so we can determine the address family and if it
+		// is a loopback address.
+		hostIP, err := netip.ParseAddr(reservedPort.HostIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host IP address: %w", err)
+		}
+
+		if hostIP.Unmap().Is6() != ipv6 {
+			n.logger.Debug("skipping port with mismatched address family", "port", port,
+				"host_ip", reservedPort.HostIP, "task_ip", ip)
 			continue
 		}
 
@@ -90,22 +169,26 @@ func (n *virtTables) Configure(res *drivers.Resources, cfg *virtnet.NetworkInter
 		if !ok {
 			iface, err = n.interfaceByIPGetter(net.ParseIP(reservedPort.HostIP))
 			if err != nil {
+				if hostNetwork, ok := hostNetworks[mapping.Label]; ok {
+					return nil, fmt.Errorf("%w: port %q placed on host_network %q with address %s",
+						filter.ErrHostNetworkUnavailable, mapping.Label, hostNetwork, reservedPort.HostIP)
+				}
 				return nil, fmt.Errorf("failed to identify IP interface: %w", err)
 			}
 
 			interfaceMapping[reservedPort.HostIP] = iface
 		}
 
-		// Parse the host IP so we can determine if it is a loopback address.
-		hostIP, err := netip.ParseAddr(reservedPort.HostIP)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse host IP address: %w", err)
-		}
-
 		// If the host IP provided is a loopback, it needs to be picked up on the
 		// output chain and redirected to the VM. This is a special case and which
 		// requires the host to be properly configured.
 		if hostIP.IsLoopback() {
+			// The kernel provides no equivalent of route_localnet for IPv6,
+			// so loopback traffic cannot be forwarded to the VM.
+			if ipv6 {
+				return nil, fmt.Errorf("%w - %s", errLoopbackNotSupported, reservedPort.HostIP)
+			}
+
 			// Find the interface that the destination address is attached.
 			dstIface, ok := interfaceMapping[ip]
 			if !ok {
@@ -162,30 +245,66 @@ func (n *virtTables) Configure(res *drivers.Resources, cfg *virtnet.NetworkInter
 					table:     n.names.tables.NAT,
 					chain:     n.names.chains.Nomad.Output,
 					removable: true,
-					spec: []string{"-s", reservedPort.HostIP, "-o", iface, "-p", "tcp", "-m", "tcp",
-						"--dport", strconv.Itoa(reservedPort.Value), "-j", "DNAT", "--to-destination",
-						fmt.Sprintf("%s:%d", ip, reservedPort.To)},
+					spec: []string{"-s", reservedPort.HostIP, "-o", iface, "-p", proto, "-m", proto,
+						"--dport", dport, "-j", "DNAT", "--to-destination", destination},
 				},
 			})
 		} else {
-			// Add prerouting and filtering rule to enable the forward.
-			req.rules.InsertSlice([]*rule{
-				{
-					table:     n.names.tables.NAT,
-					chain:     n.names.chains.Nomad.Prerouting,
-					removable: true,
-					spec: []string{"-d", reservedPort.HostIP, "-i", iface, "-p", "tcp", "-m", "tcp",
-						"--dport", strconv.Itoa(reservedPort.Value), "-j", "DNAT", "--to-destination",
-						fmt.Sprintf("%s:%d", ip, reservedPort.To)},
-				},
-				{
-					table:     n.names.tables.Filter,
-					chain:     n.names.chains.Nomad.Forward,
-					removable: true,
-					spec: []string{"-d", ip, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
-						"--dport", strconv.Itoa(reservedPort.To), "-j", "ACCEPT"},
-				},
+			forwardRules, err := n.forwardRules(ip, proto, taskPort, cfg.IngressCIDRs(mapping, allocatedPorts), ipv6)
+			if err != nil {
+				return nil, err
+			}
+
+			// Add prerouting and filtering rules to enable the forward.
+			req.rules.Insert(&rule{
+				table:     n.names.tables.NAT,
+				chain:     n.names.chains.Nomad.Prerouting,
+				removable: true,
+				spec: []string{"-d", reservedPort.HostIP, "-i", iface, "-p", proto, "-m", proto,
+					"--dport", dport, "-j", "DNAT", "--to-destination", destination},
 			})
+			req.rules.InsertSlice(forwardRules)
+		}
+	}
+
+	if cfg.Egress.Restricted() {
+		egressChain, egressRules, err := n.egressRules(cfg.Egress, cfg.Name, ip, ipv6)
+		if err != nil {
+			return nil, err
+		}
+
+		req.chains.Insert(egressChain)
+		req.rules.InsertSlice(egressRules)
+	}
+
+	egressIP, err := cfg.EgressAddress(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if egressIP != "" {
+		egressAddr, err := netip.ParseAddr(egressIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse egress IP address: %w", err)
+		}
+
+		if egressAddr.Unmap().Is6() != ipv6 {
+			n.logger.Debug("skipping egress address with mismatched address family",
+				"egress_ip", egressIP, "task_ip", ip)
+		} else {
+			iface, ok := interfaceMapping[egressIP]
+			if !ok {
+				iface, err = n.interfaceByIPGetter(net.ParseIP(egressIP))
+				if err != nil {
+					return nil, fmt.Errorf("failed to identify egress IP interface: %w", err)
+				}
+
+				interfaceMapping[egressIP] = iface
+			}
+
+			snatChain, snatRules := n.snatRules(ip, egressIP, iface)
+			req.chains.Insert(snatChain)
+			req.rules.InsertSlice(snatRules)
 		}
 	}
 
@@ -194,7 +313,7 @@ func (n *virtTables) Configure(res *drivers.Resources, cfg *virtnet.NetworkInter
 	}
 
 	return &virtnet.FilterRemoval{
-		Name: removalName,
+		Name: name,
 		Data: req.removalInstructions(),
 	}, nil
 }
@@ -207,24 +326,176 @@ func (n *virtTables) Teardown(removal *virtnet.FilterRemoval) error {
 		return nil
 	}
 
-	rules, ok := removal.Data.(Rules)
-	if !ok {
+	rules, err := decodeRules(removal.Data)
+	if err != nil {
 		n.logger.Error("invalid teardown data received", "name", removal.Name,
-			"type", hclog.Fmt("%T", removal.Data))
+			"type", hclog.Fmt("%T", removal.Data), "error", err)
 		return fmt.Errorf("invalid teardown data, cannot remove iptables rules")
 	}
 	req := newRequest()
-	req.rules.InsertSet(rules.rules())
+	req.ipv6 = removal.Name == removalNameIPv6
+	req.rules.InsertSlice(rules.slice())
+
+	// The egress chain of the task is not included within the rules, so
+	// it is identified by the rule which jumps to it.
+	for _, r := range req.rules.Slice() {
+		if target := r.jumpTarget(); n.names.isEgressChain(target) {
+			req.chains.Insert(&chain{table: r.table, chain: target})
+		}
+	}
 
 	return n.remove(req)
 }
 
+// forwardRules returns the filter rules which accept new connections to the
+// forwarded task port, or range of ports. When source address ranges are
+// provided, only the connections from those sources are accepted and any
+// others are dropped.
+func (n *virtTables) forwardRules(ip, proto, dport string, cidrs []string, ipv6 bool) ([]*rule, error) {
+	spec := []string{"-d", ip, "-p", proto, "-m", "state", "--state", "NEW", "-m", proto,
+		"--dport", dport, "-j"}
+
+	if cidrs == nil {
+		return []*rule{
+			{
+				table:     n.names.tables.Filter,
+				chain:     n.names.chains.Nomad.Forward,
+				removable: true,
+				spec:      append(spec, "ACCEPT"),
+			},
+		}, nil
+	}
+
+	var rules []*rule
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ingress cidr: %w", err)
+		}
+
+		if prefix.Addr().Unmap().Is6() != ipv6 {
+			continue
+		}
+
+		rules = append(rules, &rule{
+			table:     n.names.tables.Filter,
+			chain:     n.names.chains.Nomad.Forward,
+			removable: true,
+			spec:      append([]string{"-s", prefix.String()}, append(slices.Clone(spec), "ACCEPT")...),
+		})
+	}
+
+	return append(rules, &rule{
+		table:     n.names.tables.Filter,
+		chain:     n.names.chains.Nomad.Forward,
+		removable: true,
+		spec:      append(spec, "DROP"),
+	}), nil
+}
+
+// egressRules returns the chain and rules which apply the egress policy to
+// new connections initiated by the task on the bridge. The chain returns to
+// the forward chain for connections which are allowed, and drops all others.
+func (n *virtTables) egressRules(egress *virtnet.NetworkInterfaceEgressConfig, bridge, ip string, ipv6 bool) (*chain, []*rule, error) {
+	egressChain := &chain{
+		table: n.names.tables.Filter,
+		chain: n.names.egressChain(bridge, ip),
+	}
+
+	rules := []*rule{
+		// Jump rule for the task chain, which is used to identify the chain
+		// during teardown. It is inserted ahead of the forward rules, so the
+		// egress policy applies before any forwarded port is accepted. The
+		// bridge is matched as the address may be used on other bridges.
+		{
+			table:     n.names.tables.Filter,
+			chain:     n.names.chains.Nomad.Forward,
+			position:  1,
+			removable: true,
+			spec:      []string{"-s", ip, "-i", bridge, "-j", egressChain.chain},
+		},
+		// Allow responses to connections accepted by the task. The rules of
+		// the chain are removed along with the chain during teardown, but are
+		// included so the chain can be restored when reconciling.
+		{
+			table:     egressChain.table,
+			chain:     egressChain.chain,
+			removable: true,
+			spec:      []string{"-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "RETURN"},
+		},
+	}
+
+	for _, allow := range egress.Allow {
+		prefix, err := netip.ParsePrefix(allow.CIDR)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse egress cidr: %w", err)
+		}
+
+		if prefix.Addr().Unmap().Is6() != ipv6 {
+			continue
+		}
+
+		spec := []string{"-d", prefix.String()}
+		if allow.Protocol != "" {
+			proto := string(allow.Protocol)
+			spec = append(spec, "-p", proto)
+			if allow.Port > 0 {
+				spec = append(spec, "-m", proto, "--dport", strconv.Itoa(allow.Port))
+			}
+		}
+
+		rules = append(rules, &rule{
+			table:     egressChain.table,
+			chain:     egressChain.chain,
+			removable: true,
+			spec:      append(spec, "-j", "RETURN"),
+		})
+	}
+
+	rules = append(rules, &rule{
+		table:     egressChain.table,
+		chain:     egressChain.chain,
+		removable: true,
+		spec:      []string{"-j", "DROP"},
+	})
+
+	return egressChain, rules, nil
+}
+
+// snatRules returns the chain and rules which translate the source of
+// connections initiated by the task to the egress address, when they leave
+// through the host interface the address is assigned to. The rules are
+// evaluated before those of the network, so take precedence over its
+// masquerade rules.
+func (n *virtTables) snatRules(ip, egressIP, iface string) (*chain, []*rule) {
+	postrouting := &chain{
+		table: n.names.tables.NAT,
+		chain: n.names.chains.Nomad.Postrouting,
+	}
+
+	return postrouting, []*rule{
+		{
+			table:    n.names.tables.NAT,
+			chain:    n.names.chains.Postrouting,
+			position: 1,
+			spec:     []string{"-j", n.names.chains.Nomad.Postrouting},
+		},
+		{
+			table:     postrouting.table,
+			chain:     postrouting.chain,
+			removable: true,
+			spec:      []string{"-s", ip, "-o", iface, "-j", "SNAT", "--to-source", egressIP},
+		},
+	}
+}
+
 // setup is responsible for ensuring the local host machine iptables
 // are configured with the chains and rules needed by the driver.
 //
 // On a new machine, this function creates the "NOMAD_VT_PRT" and "NOMAD_VT_FW"
 // chains. The "NOMAD_VT_PRT" chain then has a jump rule added to the "nat"
 // table; the "NOMAD_VT_FW" chain has a jump rule added to the "filter" table.
+// When ip6tables is available, the same configuration is applied to it.
 func (n *virtTables) setup() error {
 	req := newRequest()
 
@@ -260,23 +531,49 @@ func (n *virtTables) setup() error {
 		return fmt.Errorf("setup failure: %w", err)
 	}
 
+	if n.ipt6 != nil {
+		req.ipv6 = true
+		if err := n.add(req); err != nil {
+			return fmt.Errorf("ipv6 setup failure: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// backend returns the IPTables implementation for the address family of
+// the request.
+func (n *virtTables) backend(req *request) (IPTables, error) {
+	if !req.ipv6 {
+		return n.ipt, nil
+	}
+
+	if n.ipt6 == nil {
+		return nil, errIPv6NotAvailable
+	}
+
+	return n.ipt6, nil
+}
+
 // add adds chains and rules to iptables.
 func (n *virtTables) add(req *request) error {
 	n.m.Lock()
 	defer n.m.Unlock()
 
+	ipt, err := n.backend(req)
+	if err != nil {
+		return err
+	}
+
 	// Start with creating any needed chains.
 	for _, c := range req.chains.Slice() {
-		exists, err := n.ipt.ChainExists(c.table, c.chain)
+		exists, err := ipt.ChainExists(c.table, c.chain)
 		if err != nil {
 			return fmt.Errorf("failed to check chain existence: %w", err)
 		}
 
 		if !exists {
-			if err := n.ipt.NewChain(c.table, c.chain); err != nil {
+			if err := ipt.NewChain(c.table, c.chain); err != nil {
 				return fmt.Errorf("failed to create new chain: %w", err)
 			}
 		}
@@ -287,9 +584,9 @@ func (n *virtTables) add(req *request) error {
 	for _, r := range req.rules.Slice() {
 		var err error
 		if r.position > 0 {
-			err = n.ipt.InsertUnique(r.table, r.chain, r.position, r.spec...)
+			err = ipt.InsertUnique(r.table, r.chain, r.position, r.spec...)
 		} else {
-			err = n.ipt.AppendUnique(r.table, r.chain, r.spec...)
+			err = ipt.AppendUnique(r.table, r.chain, r.spec...)
 		}
 
 		if err != nil {
@@ -308,6 +605,11 @@ func (n *virtTables) remove(req *request) error {
 	n.m.Lock()
 	defer n.m.Unlock()
 
+	ipt, err := n.backend(req)
+	if err != nil {
+		return err
+	}
+
 	// ClearAndDeleteChain below will delete all the rules on the
 	// chain, so those rules don't need to be deleted individually.
 	req.rules.RemoveFunc(func(r *rule) bool {
@@ -318,7 +620,7 @@ func (n *virtTables) remove(req *request) error {
 
 	// Remove rules in the request.
 	for _, r := range req.rules.Slice() {
-		if err := n.ipt.DeleteIfExists(r.table, r.chain, r.spec...); err != nil {
+		if err := ipt.DeleteIfExists(r.table, r.chain, r.spec...); err != nil {
 			// NOTE: attempting to delete jump rules that don't exist will
 			// cause a "does not exist" error. Check error and ignore.
 			if !isNotExistErr(err) {
@@ -332,7 +634,7 @@ func (n *virtTables) remove(req *request) error {
 	for _, c := range req.chains.Slice() {
 		// Clear and delete the chain. This function will check that
 		// the chain exists so we don't need to do that here.
-		if err := n.ipt.ClearAndDeleteChain(c.table, c.chain); err != nil {
+		if err := ipt.ClearAndDeleteChain(c.table, c.chain); err != nil {
 			n.logger.Error("failed to delete iptables chain", "error", err, "chain", *c)
 			mErr = multierror.Append(mErr, err)
 		}
@@ -341,81 +643,69 @@ func (n *virtTables) remove(req *request) error {
 	return mErr.ErrorOrNil()
 }
 
-// loopbackPortForwardsSupported returns if the host has been configured for routing localnet packets.
-// NOTE: The global configuration overrides device specific configuration.
-func (n *virtTables) loopbackPortForwardsSupported(device string) bool {
-	for _, configName := range []string{routeLocalnetGlobalName, device} {
-		tmpl := n.routeLocalnetPathTemplate
-		if tmpl == "" {
-			tmpl = routeLocalnetPathTemplate
-		}
+// removeAll removes all the chains created by the driver, and the rules
+// which jump to them, for each available address family. Chains which do
+// not exist are skipped, as the jump rules to them cannot be matched.
+func (n *virtTables) removeAll() error {
+	var mErr *multierror.Error
 
-		cfgPath := fmt.Sprintf(tmpl, configName)
-		content, err := os.ReadFile(cfgPath)
+	for _, ipv6 := range []bool{false, true} {
+		req := newRequest()
+		req.ipv6 = ipv6
+
+		ipt, err := n.backend(req)
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
+			// ip6tables being unavailable means there is nothing to remove.
+			continue
+		}
+
+		for _, c := range []struct {
+			table, chain, nomad string
+		}{
+			{n.names.tables.Filter, n.names.chains.Forward, n.names.chains.Nomad.Forward},
+			{n.names.tables.NAT, n.names.chains.Postrouting, n.names.chains.Nomad.Postrouting},
+			{n.names.tables.NAT, n.names.chains.Prerouting, n.names.chains.Nomad.Prerouting},
+			{n.names.tables.NAT, n.names.chains.Output, n.names.chains.Nomad.Output},
+		} {
+			exists, err := ipt.ChainExists(c.table, c.nomad)
+			if err != nil {
+				mErr = multierror.Append(mErr, err)
 				continue
 			}
 
-			n.logger.Error("read failed for device loopback support check", "path", cfgPath, "error", err)
-			return false
-		}
-
-		if strings.TrimSpace(string(content)) == "1" {
-			return true
-		}
-	}
+			if !exists {
+				continue
+			}
 
-	return false
-}
+			req.chains.Insert(&chain{table: c.table, chain: c.nomad})
+			req.rules.Insert(&rule{table: c.table, chain: c.chain, spec: []string{"-j", c.nomad}})
 
-// getInterfaceByIP is a helper function which identifies which host network
-// interface the passed IP address is linked to.
-func getInterfaceByIP(ip net.IP) (string, error) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", err
-	}
+			// The task egress chains are only jumped to from the forward
+			// chain, so they are removed after it has been cleared.
+			if c.nomad == n.names.chains.Nomad.Forward {
+				chains, err := ipt.ListChains(c.table)
+				if err != nil {
+					mErr = multierror.Append(mErr, err)
+					continue
+				}
 
-	for _, iface := range interfaces {
-		if addrs, err := iface.Addrs(); err == nil {
-			for _, addr := range addrs {
-				if iip, _, err := net.ParseCIDR(addr.String()); err == nil {
-					if iip.Equal(ip) {
-						return iface.Name, nil
+				for _, name := range chains {
+					if n.names.isEgressChain(name) {
+						req.chains.Insert(&chain{table: c.table, chain: name})
 					}
 				}
 			}
 		}
-	}
-
-	return "", fmt.Errorf("failed to find interface for IP %q", ip.String())
-}
-
-// getRoutingInterfaceByIP returns the name of the interface that can be used
-// to reach the provided address.
-func getRoutingInterfaceByIP(ip string) (string, error) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", err
-	}
-
-	checkAddr, err := netip.ParseAddr(ip)
-	if err != nil {
-		return "", err
-	}
 
-	for _, iface := range interfaces {
-		if addrs, err := iface.Addrs(); err == nil {
-			for _, addr := range addrs {
-				if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
-					if prefix.Contains(checkAddr) {
-						return iface.Name, nil
-					}
-				}
-			}
+		if err := n.remove(req); err != nil {
+			mErr = multierror.Append(mErr, err)
 		}
 	}
 
-	return "", fmt.Errorf("failed to find interface for IP %q", ip)
+	return mErr.ErrorOrNil()
+}
+
+// loopbackPortForwardsSupported returns if the host has been configured for routing localnet packets.
+func (n *virtTables) loopbackPortForwardsSupported(device string) bool {
+	return filter.LoopbackPortForwardsSupported(n.logger, n.routeLocalnetPathTemplate, device)
 }
@@ -4,6 +4,7 @@
 package iptables
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -11,8 +12,10 @@ import (
 	"testing"
 
 	"github.com/hashicorp/go-set/v3"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/net/filter"
 	"github.com/hashicorp/nomad-driver-virt/testutil"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
 	mock_iptables "github.com/hashicorp/nomad-driver-virt/testutil/mock/iptables"
 	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/helper/uuid"
@@ -74,7 +77,9 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http", "ssh"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http", "ssh"},
+				},
 			}
 
 			expected := [][]string{
@@ -103,6 +108,42 @@ func Test_virtTables_Configure(t *testing.T) {
 			must.Eq(t, expected, teardownRules.Data.(Rules))
 		})
 
+		t.Run("unmapped", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "192.168.44.22"
+			taskIP := "10.0.22.33"
+			ifaceName := "test0"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "tcp", "-m", "tcp", "--dport", "22224",
+					"-j", "DNAT", "--to-destination", taskIP + ":22224"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+					"--dport", "22224", "-j", "ACCEPT"}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return ifaceName, nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{Label: "metrics", HostIP: hostIP, Value: 22224},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"metrics"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+		})
+
 		t.Run("loopback", func(t *testing.T) {
 			n := TestNewNames()
 			hostIP := "127.0.0.1"
@@ -144,7 +185,9 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
 			}
 
 			expected := [][]string{
@@ -186,12 +229,534 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
 			}
 
 			_, err := vt.Configure(resources, cfg, taskIP)
 			must.ErrorIs(t, err, errLoopbackNotEnabled)
 		})
+
+		t.Run("protocols", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "192.168.44.22"
+			taskIP := "10.0.22.33"
+			ifaceName := "test0"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "udp", "-m", "udp", "--dport", "22222",
+					"-j", "DNAT", "--to-destination", taskIP + ":53"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "udp", "-m", "state", "--state", "NEW", "-m", "udp",
+					"--dport", "53", "-j", "ACCEPT"}},
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "tcp", "-m", "tcp", "--dport", "22222",
+					"-j", "DNAT", "--to-destination", taskIP + ":53"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+					"--dport", "53", "-j", "ACCEPT"}},
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "sctp", "-m", "sctp", "--dport", "22223",
+					"-j", "DNAT", "--to-destination", taskIP + ":3868"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "sctp", "-m", "state", "--state", "NEW", "-m", "sctp",
+					"--dport", "3868", "-j", "ACCEPT"}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return ifaceName, nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "dns",
+						To:     53,
+						HostIP: hostIP,
+						Value:  22222,
+					},
+					{
+						Label:  "diameter",
+						To:     3868,
+						HostIP: hostIP,
+						Value:  22223,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"dns/udp", "dns", "diameter/sctp"},
+				},
+			}
+
+			teardownRules, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+
+			rules := teardownRules.Data.(Rules)
+			must.Len(t, 6, rules)
+			must.SliceContains(t, rules[0], "udp")
+			must.SliceContains(t, rules[1], "udp")
+			must.SliceContains(t, rules[2], "tcp")
+			must.SliceContains(t, rules[3], "tcp")
+			must.SliceContains(t, rules[4], "sctp")
+			must.SliceContains(t, rules[5], "sctp")
+
+			// Ensure the protocol is carried through to the removal of the rules.
+			for _, r := range rules {
+				ipt.Expect(mock_iptables.DeleteIfExists{Table: r[0], Chain: r[1], RuleSpec: r[2:]})
+			}
+			must.NoError(t, vt.Teardown(teardownRules))
+		})
+
+		t.Run("invalid protocol", func(t *testing.T) {
+			ipt := mock_iptables.New(t)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt))
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "dns",
+						To:     53,
+						HostIP: "192.168.44.22",
+						Value:  22222,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"dns/icmp"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, "10.0.22.33")
+			must.ErrorContains(t, err, `invalid protocol "icmp"`)
+		})
+
+		t.Run("ipv6", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "fd00:44::22"
+			taskIP := "fd00:22::33"
+			ifaceName := "test0"
+
+			// The IPv4 implementation should not be called, as the task
+			// address is IPv6 and the IPv4 host port must be skipped.
+			ipt := mock_iptables.New(t)
+			defer ipt.AssertExpectations()
+
+			ipt6 := mock_iptables.New(t).Expect(
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "tcp", "-m", "tcp", "--dport", "22222",
+					"-j", "DNAT", "--to-destination", "[" + taskIP + "]:8000"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+					"--dport", "8000", "-j", "ACCEPT"}},
+			)
+			defer ipt6.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithIP6Tables(ipt6),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return ifaceName, nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "http",
+						To:     8000,
+						HostIP: hostIP,
+						Value:  22222,
+					},
+					{
+						Label:  "ssh",
+						To:     22,
+						HostIP: "192.168.44.22",
+						Value:  22223,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http", "ssh"},
+				},
+			}
+
+			expected := [][]string{
+				{
+					"nat", n.chains.Nomad.Prerouting, "-d", hostIP, "-i", ifaceName, "-p",
+					"tcp", "-m", "tcp", "--dport", "22222", "-j", "DNAT", "--to-destination",
+					"[" + taskIP + "]:8000",
+				},
+				{
+					"filter", n.chains.Nomad.Forward, "-d", taskIP, "-p", "tcp", "-m",
+					"state", "--state", "NEW", "-m", "tcp", "--dport", "8000", "-j", "ACCEPT",
+				},
+			}
+
+			teardownRules, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+			must.Eq(t, removalNameIPv6, teardownRules.Name)
+			must.Eq(t, expected, teardownRules.Data.(Rules))
+
+			ipt6.Expect(
+				mock_iptables.DeleteIfExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: expected[0][2:]},
+				mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: expected[1][2:]},
+			)
+			must.NoError(t, vt.Teardown(teardownRules))
+		})
+
+		t.Run("ipv6 unavailable", func(t *testing.T) {
+			ipt := mock_iptables.New(t)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt))
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "http",
+						To:     8000,
+						HostIP: "fd00:44::22",
+						Value:  22222,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, "fd00:22::33")
+			must.ErrorIs(t, err, errIPv6NotAvailable)
+		})
+
+		t.Run("ipv6 loopback", func(t *testing.T) {
+			ipt := mock_iptables.New(t)
+			defer ipt.AssertExpectations()
+			ipt6 := mock_iptables.New(t)
+			defer ipt6.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithIP6Tables(ipt6),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return "lo", nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "http",
+						To:     8000,
+						HostIP: "::1",
+						Value:  22222,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, "fd00:22::33")
+			must.ErrorIs(t, err, errLoopbackNotSupported)
+		})
+
+		t.Run("ingress cidrs", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "192.168.44.22"
+			taskIP := "10.0.22.33"
+			ifaceName := "test0"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", ifaceName, "-p", "tcp", "-m", "tcp", "--dport", "22222",
+					"-j", "DNAT", "--to-destination", taskIP + ":22"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-s", "10.0.0.0/8", "-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+					"--dport", "22", "-j", "ACCEPT"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "tcp", "-m", "state", "--state", "NEW", "-m", "tcp",
+					"--dport", "22", "-j", "DROP"}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return ifaceName, nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{
+						Label:  "ssh",
+						To:     22,
+						HostIP: hostIP,
+						Value:  22222,
+					},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"ssh"},
+					Ingress: []*virtnet.NetworkInterfaceIngressConfig{
+						{Port: "ssh", CIDRs: []string{"10.0.0.0/8", "fd00::/8"}},
+					},
+				},
+			}
+
+			removal, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+			must.Len(t, 3, removal.Data.(Rules))
+		})
+
+		t.Run("egress", func(t *testing.T) {
+			n := TestNewNames()
+			taskIP := "10.0.22.33"
+			egressChain := n.egressChain("virbr0", taskIP)
+
+			// The address may be used by another task on a different bridge,
+			// which must not share the egress chain.
+			must.NotEq(t, egressChain, n.egressChain("virbr1", taskIP))
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "filter", Chain: egressChain},
+				mock_iptables.NewChain{Table: "filter", Chain: egressChain},
+				mock_iptables.InsertUnique{Table: "filter", Chain: n.chains.Nomad.Forward, Pos: 1, RuleSpec: []string{
+					"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+					"-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "RETURN"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+					"-d", "10.0.0.0/8", "-j", "RETURN"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+					"-d", "192.168.0.0/16", "-p", "udp", "-m", "udp", "--dport", "53", "-j", "RETURN"}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: egressChain, RuleSpec: []string{
+					"-j", "DROP"}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				Name: "virbr0",
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Egress: &virtnet.NetworkInterfaceEgressConfig{
+						Policy: virtnet.EgressPolicyAllowList,
+						Allow: []*virtnet.NetworkInterfaceEgressRule{
+							{CIDR: "10.0.0.0/8"},
+							{CIDR: "192.168.0.0/16", Port: 53, Protocol: virtnet.PortProtocolUDP},
+							{CIDR: "fd00::/8"},
+						},
+					},
+				},
+			}
+
+			removal, err := vt.Configure(&drivers.Resources{}, cfg, taskIP)
+			must.NoError(t, err)
+			must.Eq(t, Rules{
+				{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain},
+				{"filter", egressChain, "-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "RETURN"},
+				{"filter", egressChain, "-d", "10.0.0.0/8", "-j", "RETURN"},
+				{"filter", egressChain, "-d", "192.168.0.0/16", "-p", "udp", "-m", "udp", "--dport", "53", "-j", "RETURN"},
+				{"filter", egressChain, "-j", "DROP"},
+			}, removal.Data.(Rules))
+		})
+
+		t.Run("egress address", func(t *testing.T) {
+			n := TestNewNames()
+			taskIP := "10.0.22.33"
+			egressIP := "192.168.44.23"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.NewChain{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.InsertUnique{Table: "nat", Chain: "POSTROUTING", Pos: 1, RuleSpec: []string{"-j", n.chains.Nomad.Postrouting}},
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+					"-s", taskIP, "-o", "eth1", "-j", "SNAT", "--to-source", egressIP}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(ip net.IP) (string, error) {
+					must.Eq(t, egressIP, ip.String())
+					return "eth1", nil
+				}),
+			)
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					EgressIP: egressIP,
+				},
+			}
+
+			removal, err := vt.Configure(&drivers.Resources{}, cfg, taskIP)
+			must.NoError(t, err)
+			must.Eq(t, Rules{
+				{"nat", n.chains.Nomad.Postrouting, "-s", taskIP, "-o", "eth1", "-j", "SNAT", "--to-source", egressIP},
+			}, removal.Data.(Rules))
+		})
+
+		t.Run("egress host network", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "192.168.44.22"
+			taskIP := "10.0.22.33"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.NewChain{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.InsertUnique{Table: "nat", Chain: "POSTROUTING", Pos: 1, RuleSpec: []string{"-j", n.chains.Nomad.Postrouting}},
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Postrouting, RuleSpec: []string{
+					"-s", taskIP, "-o", "eth1", "-j", "SNAT", "--to-source", hostIP}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return "eth1", nil }),
+			)
+			resources := &drivers.Resources{
+				NomadResources: &structs.AllocatedTaskResources{
+					Networks: structs.Networks{
+						{ReservedPorts: []structs.Port{{Label: "metrics", Value: 9100, HostNetwork: "public"}}},
+					},
+				},
+				Ports: &structs.AllocatedPorts{
+					{Label: "metrics", Value: 9100, HostIP: hostIP},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					EgressIP: "public",
+				},
+			}
+
+			removal, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+			must.Eq(t, Rules{
+				{"nat", n.chains.Nomad.Postrouting, "-s", taskIP, "-o", "eth1", "-j", "SNAT", "--to-source", hostIP},
+			}, removal.Data.(Rules))
+		})
+
+		t.Run("egress host network without ports", func(t *testing.T) {
+			vt, _ := TestNew(t, WithIPTables(mock_iptables.New(t)))
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					EgressIP: "public",
+				},
+			}
+
+			_, err := vt.Configure(&drivers.Resources{}, cfg, "10.0.22.33")
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		})
+
+		t.Run("port range", func(t *testing.T) {
+			n := TestNewNames()
+			hostIP := "192.168.44.22"
+			taskIP := "10.0.22.33"
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.AppendUnique{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", hostIP, "-i", "eth0", "-p", "udp", "-m", "udp", "--dport", "30000:30002",
+					"-j", "DNAT", "--to-destination", taskIP}},
+				mock_iptables.AppendUnique{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-d", taskIP, "-p", "udp", "-m", "state", "--state", "NEW", "-m", "udp",
+					"--dport", "30000:30002", "-j", "ACCEPT"}},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t,
+				WithIPTables(ipt),
+				WithNames(t, n),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return "eth0", nil }),
+			)
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{Label: "rtp0", Value: 30000, HostIP: hostIP},
+					{Label: "rtp1", Value: 30001, HostIP: hostIP},
+					{Label: "rtp2", Value: 30002, HostIP: hostIP},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"rtp0..rtp2/udp"},
+				},
+			}
+
+			removal, err := vt.Configure(resources, cfg, taskIP)
+			must.NoError(t, err)
+			must.Eq(t, Rules{
+				{"nat", n.chains.Nomad.Prerouting, "-d", hostIP, "-i", "eth0", "-p", "udp", "-m", "udp",
+					"--dport", "30000:30002", "-j", "DNAT", "--to-destination", taskIP},
+				{"filter", n.chains.Nomad.Forward, "-d", taskIP, "-p", "udp", "-m", "state", "--state",
+					"NEW", "-m", "udp", "--dport", "30000:30002", "-j", "ACCEPT"},
+			}, removal.Data.(Rules))
+		})
+
+		t.Run("invalid port range", func(t *testing.T) {
+			vt, _ := TestNew(t, WithIPTables(mock_iptables.New(t)))
+			resources := &drivers.Resources{
+				Ports: &structs.AllocatedPorts{
+					{Label: "rtp0", Value: 30000, HostIP: "192.168.44.22"},
+					{Label: "rtp2", Value: 30002, HostIP: "192.168.44.22"},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"rtp0..rtp2"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, "10.0.22.33")
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, "includes port 30001 which is not reserved")
+		})
+
+		t.Run("host network unavailable", func(t *testing.T) {
+			vt, _ := TestNew(t,
+				WithIPTables(mock_iptables.New(t)),
+				WithInterfaceByIPGetter(func(net.IP) (string, error) { return "", errors.New("not found") }),
+			)
+			resources := &drivers.Resources{
+				NomadResources: &structs.AllocatedTaskResources{
+					Networks: structs.Networks{
+						{DynamicPorts: []structs.Port{{Label: "http", HostNetwork: "public"}}},
+					},
+				},
+				Ports: &structs.AllocatedPorts{
+					{Label: "http", Value: 25000, To: 80, HostIP: "203.0.113.10"},
+				},
+			}
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
+			}
+
+			_, err := vt.Configure(resources, cfg, "10.0.22.33")
+			must.ErrorIs(t, err, filter.ErrHostNetworkUnavailable)
+			must.ErrorContains(t, err, `port "http" placed on host_network "public" with address 203.0.113.10`)
+		})
+
+		t.Run("egress address mismatched family", func(t *testing.T) {
+			ipt := mock_iptables.New(t)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt), WithIP6Tables(mock_iptables.New(t)))
+			cfg := &virtnet.NetworkInterfaceBridgeConfig{
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					EgressIP: "192.168.44.23",
+				},
+			}
+
+			removal, err := vt.Configure(&drivers.Resources{}, cfg, "fd00::2")
+			must.NoError(t, err)
+			must.SliceEmpty(t, removal.Data.(Rules))
+		})
 	})
 
 	t.Run("direct", func(t *testing.T) {
@@ -230,7 +795,9 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http", "ssh"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http", "ssh"},
+				},
 			}
 
 			expected := [][]string{
@@ -306,7 +873,9 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http"},
+				},
 			}
 
 			expected := [][]string{
@@ -377,7 +946,9 @@ func Test_virtTables_Configure(t *testing.T) {
 				},
 			}
 			cfg := &virtnet.NetworkInterfaceBridgeConfig{
-				Ports: []string{"http", "ssh"},
+				NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"http", "ssh"},
+				},
 			}
 
 			// Apply the updates.
@@ -415,9 +986,79 @@ func Test_virtTables_Configure(t *testing.T) {
 }
 
 func Test_virtTables_Teardown(t *testing.T) {
-	// This is currently a stub for Teardown tests, of which there are none. This
-	// is because the Teardown function currently just calls `remove` so there is
-	// nothing else to test. If/when that changes, this will no longer be a stub.
+	t.Run("mock", func(t *testing.T) {
+		t.Run("egress", func(t *testing.T) {
+			n := TestNewNames()
+			taskIP := "10.0.22.33"
+			egressChain := n.egressChain("virbr0", taskIP)
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.DeleteIfExists{Table: "filter", Chain: n.chains.Nomad.Forward, RuleSpec: []string{
+					"-s", taskIP, "-i", "virbr0", "-j", egressChain}},
+				mock_iptables.ClearAndDeleteChain{Table: "filter", Chain: egressChain},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+			must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{
+				Name: removalName,
+				Data: Rules{
+					{"filter", n.chains.Nomad.Forward, "-s", taskIP, "-i", "virbr0", "-j", egressChain},
+					{"filter", egressChain, "-j", "DROP"},
+				},
+			}))
+		})
+
+		t.Run("ordered", func(t *testing.T) {
+			n := TestNewNames()
+			removal := Rules{
+				{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-p", "udp", "-j", "DNAT",
+					"--to-destination", "192.168.122.10:53"},
+				{"filter", n.chains.Nomad.Forward, "-d", "192.168.122.10", "-p", "udp", "-j", "ACCEPT"},
+				{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-p", "tcp", "-j", "DNAT",
+					"--to-destination", "192.168.122.10:8080"},
+				{"filter", n.chains.Nomad.Forward, "-d", "192.168.122.10", "-p", "tcp", "-j", "ACCEPT"},
+			}
+
+			// Rules are removed in the order of the removal data.
+			ipt := mock_iptables.New(t)
+			for _, r := range removal {
+				ipt.Expect(mock_iptables.DeleteIfExists{Table: r[0], Chain: r[1], RuleSpec: r[2:]})
+			}
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+			must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: removal}))
+		})
+
+		t.Run("restored", func(t *testing.T) {
+			n := TestNewNames()
+
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.DeleteIfExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, RuleSpec: []string{
+					"-d", "10.0.0.1", "-j", "DNAT", "--to-destination", "192.168.122.10:8080"}},
+			)
+			defer ipt.AssertExpectations()
+
+			// Task state which has been restored contains generic types.
+			vt, _ := TestNew(t, WithIPTables(ipt), WithNames(t, n))
+			must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{
+				Name: removalName,
+				Data: []any{
+					[]any{"nat", n.chains.Nomad.Prerouting, "-d", "10.0.0.1", "-j", "DNAT",
+						"--to-destination", "192.168.122.10:8080"},
+				},
+			}))
+		})
+
+		t.Run("invalid data", func(t *testing.T) {
+			vt, _ := TestNew(t, WithIPTables(mock_iptables.New(t)))
+			must.ErrorContains(t, vt.Teardown(&virtnet.FilterRemoval{
+				Name: removalName,
+				Data: map[string]any{"forwards": 1},
+			}), "invalid teardown data")
+		})
+	})
 }
 
 func Test_virtTables_setup(t *testing.T) {
@@ -996,6 +1637,69 @@ func Test_virtTables_remove(t *testing.T) {
 	})
 }
 
+func Test_virtTables_removeAll(t *testing.T) {
+	t.Run("mock", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			n := TestNewNames()
+			egressChain := n.egressChain("virbr0", "10.0.22.33")
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+				mock_iptables.ListChains{Table: "filter", Result: []string{"INPUT", "FORWARD", n.chains.Nomad.Forward, egressChain}},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting, Result: true},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+				mock_iptables.DeleteIfExists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{"-j", n.chains.Nomad.Forward}},
+				mock_iptables.DeleteIfExists{Table: "nat", Chain: "PREROUTING", RuleSpec: []string{"-j", n.chains.Nomad.Prerouting}},
+				mock_iptables.ClearAndDeleteChain{Table: "filter", Chain: n.chains.Nomad.Forward},
+				mock_iptables.ClearAndDeleteChain{Table: "filter", Chain: egressChain},
+				mock_iptables.ClearAndDeleteChain{Table: "nat", Chain: n.chains.Nomad.Prerouting},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithNames(t, n), WithIPTables(ipt))
+			must.NoError(t, vt.removeAll())
+		})
+
+		t.Run("ipv6", func(t *testing.T) {
+			n := TestNewNames()
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			)
+			defer ipt.AssertExpectations()
+			ipt6 := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Result: true},
+				mock_iptables.ListChains{Table: "filter", Result: []string{n.chains.Nomad.Forward}},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+				mock_iptables.DeleteIfExists{Table: "filter", Chain: "FORWARD", RuleSpec: []string{"-j", n.chains.Nomad.Forward}},
+				mock_iptables.ClearAndDeleteChain{Table: "filter", Chain: n.chains.Nomad.Forward},
+			)
+			defer ipt6.AssertExpectations()
+
+			vt, _ := TestNew(t, WithNames(t, n), WithIPTables(ipt), WithIP6Tables(ipt6))
+			must.NoError(t, vt.removeAll())
+		})
+
+		t.Run("error", func(t *testing.T) {
+			n := TestNewNames()
+			ipt := mock_iptables.New(t).Expect(
+				mock_iptables.ChainExists{Table: "filter", Chain: n.chains.Nomad.Forward, Err: mock.MockTestErr},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Postrouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Prerouting},
+				mock_iptables.ChainExists{Table: "nat", Chain: n.chains.Nomad.Output},
+			)
+			defer ipt.AssertExpectations()
+
+			vt, _ := TestNew(t, WithNames(t, n), WithIPTables(ipt))
+			must.ErrorIs(t, vt.removeAll(), mock.MockTestErr)
+		})
+	})
+}
+
 func Test_virtTables_loopbackPortForwardsSupported(t *testing.T) {
 	testCases := []struct {
 		desc          string
@@ -1060,7 +1764,7 @@ func Test_virtTables_loopbackPortForwardsSupported(t *testing.T) {
 			tdir := t.TempDir()
 			tmpl := filepath.Join(tdir, "/%s_route_localnet")
 			devPath := fmt.Sprintf(tmpl, deviceName)
-			globalPath := fmt.Sprintf(tmpl, routeLocalnetGlobalName)
+			globalPath := fmt.Sprintf(tmpl, filter.RouteLocalnetGlobalName)
 			if tc.globalContent != "" {
 				f, err := os.Create(globalPath)
 				must.NoError(t, err)
@@ -1105,7 +1809,7 @@ func chainRemover(t *testing.T, ipt IPTables, names ...string) func() {
 // the path. Can be used with `WithRoutingLocalnetTemplate`.
 func enableLocalnetRouting(t *testing.T, device string) string {
 	if device == "" {
-		device = routeLocalnetGlobalName
+		device = filter.RouteLocalnetGlobalName
 	}
 
 	tmpl := filepath.Join(t.TempDir(), "localnet-routing-%s")
@@ -9,6 +9,7 @@ import (
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
 	"github.com/hashicorp/nomad/helper/uuid"
 	"github.com/shoenig/test/must"
 )
@@ -32,6 +33,13 @@ func WithIPTables(ipt IPTables) testOption {
 	}
 }
 
+// WithIP6Tables sets a custom IPTables implementation for IPv6.
+func WithIP6Tables(ipt IPTables) testOption {
+	return func(n *virtTables) {
+		n.ipt6 = ipt
+	}
+}
+
 // WithInterfaceByIPGetter sets a custom interfaceByIPGetter.
 func WithInterfaceByIPGetter(fn interfaceByIPGetter) testOption {
 	return func(n *virtTables) {
@@ -80,9 +88,9 @@ func WithLogger(logger hclog.Logger) testOption {
 func TestNew(t must.T, opts ...testOption) (*virtTables, func()) {
 	t.Helper()
 	nt := &virtTables{
-		interfaceByIPGetter:        getInterfaceByIP,
+		interfaceByIPGetter:        filter.InterfaceByIP,
 		names:                      TestNewNames(),
-		routingInterfaceByIPGetter: getRoutingInterfaceByIP,
+		routingInterfaceByIPGetter: filter.RoutingInterfaceByIP,
 		logger:                     hclog.NewNullLogger(),
 	}
 
@@ -113,6 +121,7 @@ func TestNewNames() *names {
 				Postrouting: genTestName(defaultChainNameNomadPostrouting),
 				Prerouting:  genTestName(defaultChainNameNomadPrerouting),
 				Output:      genTestName(defaultChainNameNomadOutput),
+				Egress:      genTestName(defaultChainNameNomadEgress),
 			},
 		},
 		tables: &TableNames{
@@ -131,53 +140,7 @@ func genTestName(prefix string) string {
 // cleanup is used to remove entries from iptables when tests
 // are interacting directly with iptables and are not mocked.
 func (n *virtTables) cleanup(t must.T) {
-	req := newRequest()
-
-	// Add all the custom chains to be removed.
-	req.chains.InsertSlice([]*chain{
-		{
-			table: n.names.tables.Filter,
-			chain: n.names.chains.Nomad.Forward,
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Nomad.Postrouting,
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Nomad.Prerouting,
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Nomad.Output,
-		},
-	})
-
-	// Add all the jump rules to be removed.
-	req.rules.InsertSlice([]*rule{
-		{
-			table: n.names.tables.Filter,
-			chain: n.names.chains.Forward,
-			spec:  []string{"-j", n.names.chains.Nomad.Forward},
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Postrouting,
-			spec:  []string{"-j", n.names.chains.Nomad.Postrouting},
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Prerouting,
-			spec:  []string{"-j", n.names.chains.Nomad.Prerouting},
-		},
-		{
-			table: n.names.tables.NAT,
-			chain: n.names.chains.Output,
-			spec:  []string{"-j", n.names.chains.Nomad.Output},
-		},
-	})
-
-	if err := n.remove(req); err != nil {
+	if err := n.removeAll(); err != nil {
 		t.Fatalf("error encountered during iptables cleanup: %s", err)
 	}
 }
@@ -0,0 +1,399 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"reflect"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/google/nftables"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"golang.org/x/sys/unix"
+)
+
+// registerSize is the size of a 32-bit nftables register. Each part of a
+// concatenated key or value is padded to this size.
+const registerSize = 4
+
+// protocolNumbers maps the supported port protocols to their IP protocol
+// numbers.
+var protocolNumbers = map[virtnet.PortProtocol]byte{
+	virtnet.PortProtocolTCP:  unix.IPPROTO_TCP,
+	virtnet.PortProtocolUDP:  unix.IPPROTO_UDP,
+	virtnet.PortProtocolSCTP: unix.IPPROTO_SCTP,
+}
+
+// Config is the packet filtering configuration of a task. It is used as the
+// data within the FilterRemoval, so teardown can remove the elements which
+// were added.
+type Config struct {
+	Forwards Forwards
+	Egress   *Egress
+	SNAT     *SNAT
+}
+
+// elements converts the configuration into the set elements which implement
+// it.
+func (c *Config) elements() (*elements, error) {
+	result, err := c.Forwards.elements()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Egress != nil {
+		if err := c.Egress.addElements(result); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.SNAT != nil {
+		if err := c.SNAT.addElements(result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Forward describes a single port forward from the host to a task.
+type Forward struct {
+	Protocol string
+	HostIP   string
+	HostPort int
+	TaskIP   string
+	TaskPort int
+
+	// SourceCIDRs restricts the sources which can connect to the task port.
+	// When empty, connections from any source are accepted.
+	SourceCIDRs []string
+}
+
+// Forwards is the collection of port forwards configured for a task.
+type Forwards []Forward
+
+// Egress describes the restricted egress policy of a task. New connections
+// initiated by the task are dropped, unless the destination matches one of
+// the allow rules.
+type Egress struct {
+	TaskIP string
+	Allow  []EgressRule
+}
+
+// EgressRule describes a destination which a task can connect to. An empty
+// protocol matches all protocols and a zero port matches all ports.
+type EgressRule struct {
+	CIDR     string
+	Protocol string
+	Port     int
+}
+
+// SNAT describes the translation of the source of connections initiated by
+// a task to the egress address, when they leave through the host interface
+// the address is assigned to.
+type SNAT struct {
+	TaskIP    string
+	EgressIP  string
+	Interface string
+}
+
+// elements holds the set elements generated for the configuration of a task,
+// grouped by the set they belong to.
+type elements struct {
+	dnat4, dnat6             []nftables.SetElement
+	snat4, snat6             []nftables.SetElement
+	forward4, forward6       []nftables.SetElement
+	allow4, allow6           []nftables.SetElement
+	egress4, egress6         []nftables.SetElement
+	restricted4, restricted6 []nftables.SetElement
+	isolated4, isolated6     []nftables.SetElement
+}
+
+// empty returns if no elements are present.
+func (e *elements) empty() bool {
+	for _, vals := range [][]nftables.SetElement{
+		e.dnat4, e.dnat6, e.snat4, e.snat6, e.forward4, e.forward6, e.allow4, e.allow6,
+		e.egress4, e.egress6, e.restricted4, e.restricted6, e.isolated4, e.isolated6,
+	} {
+		if len(vals) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// elements converts the forwards into the set elements which implement them.
+func (f Forwards) elements() (*elements, error) {
+	result := &elements{}
+
+	for _, fwd := range f {
+		proto, ok := protocolNumbers[virtnet.PortProtocol(fwd.Protocol)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported protocol %q", fwd.Protocol)
+		}
+
+		hostIP, err := netip.ParseAddr(fwd.HostIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host IP address: %w", err)
+		}
+
+		taskIP, err := netip.ParseAddr(fwd.TaskIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse task IP address: %w", err)
+		}
+
+		hostIP, taskIP = hostIP.Unmap(), taskIP.Unmap()
+		if hostIP.Is4() != taskIP.Is4() {
+			return nil, fmt.Errorf("host IP %q and task IP %q have mismatched address families",
+				fwd.HostIP, fwd.TaskIP)
+		}
+
+		dnat := nftables.SetElement{
+			Key: concat(pad([]byte{proto}), hostIP.AsSlice(), port(fwd.HostPort)),
+			Val: concat(taskIP.AsSlice(), port(fwd.TaskPort)),
+		}
+		forward := nftables.SetElement{
+			Key: concat(taskIP.AsSlice(), pad([]byte{proto}), port(fwd.TaskPort)),
+		}
+
+		// Connections to a restricted port are only accepted from the
+		// source ranges within the allow set, and are otherwise dropped.
+		restricted := len(fwd.SourceCIDRs) > 0
+		var allows []nftables.SetElement
+		for _, cidr := range fwd.SourceCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse source CIDR: %w", err)
+			}
+
+			if prefix.Addr().Unmap().Is4() != taskIP.Is4() {
+				continue
+			}
+
+			start, end := prefixRange(prefix)
+			allows = append(allows, nftables.SetElement{
+				Key:    concat(start.AsSlice(), taskIP.AsSlice(), pad([]byte{proto}), port(fwd.TaskPort)),
+				KeyEnd: concat(end.AsSlice(), taskIP.AsSlice(), pad([]byte{proto}), port(fwd.TaskPort)),
+			})
+		}
+
+		if taskIP.Is4() {
+			result.dnat4 = append(result.dnat4, dnat)
+			result.allow4 = append(result.allow4, allows...)
+			if restricted {
+				result.restricted4 = append(result.restricted4, forward)
+			} else {
+				result.forward4 = append(result.forward4, forward)
+			}
+		} else {
+			result.dnat6 = append(result.dnat6, dnat)
+			result.allow6 = append(result.allow6, allows...)
+			if restricted {
+				result.restricted6 = append(result.restricted6, forward)
+			} else {
+				result.forward6 = append(result.forward6, forward)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// addElements adds the set elements which implement the egress policy to the
+// passed elements.
+func (e *Egress) addElements(result *elements) error {
+	taskIP, err := netip.ParseAddr(e.TaskIP)
+	if err != nil {
+		return fmt.Errorf("failed to parse task IP address: %w", err)
+	}
+	taskIP = taskIP.Unmap()
+
+	var allows []nftables.SetElement
+	for _, rule := range e.Allow {
+		prefix, err := netip.ParsePrefix(rule.CIDR)
+		if err != nil {
+			return fmt.Errorf("failed to parse egress CIDR: %w", err)
+		}
+
+		if prefix.Addr().Unmap().Is4() != taskIP.Is4() {
+			continue
+		}
+
+		protoStart, protoEnd := byte(0), byte(255)
+		if rule.Protocol != "" {
+			proto, ok := protocolNumbers[virtnet.PortProtocol(rule.Protocol)]
+			if !ok {
+				return fmt.Errorf("unsupported protocol %q", rule.Protocol)
+			}
+			protoStart, protoEnd = proto, proto
+		}
+
+		portStart, portEnd := 0, 65535
+		if rule.Port > 0 {
+			portStart, portEnd = rule.Port, rule.Port
+		}
+
+		start, end := prefixRange(prefix)
+		allows = append(allows, nftables.SetElement{
+			Key:    concat(taskIP.AsSlice(), start.AsSlice(), pad([]byte{protoStart}), port(portStart)),
+			KeyEnd: concat(taskIP.AsSlice(), end.AsSlice(), pad([]byte{protoEnd}), port(portEnd)),
+		})
+	}
+
+	isolated := nftables.SetElement{Key: taskIP.AsSlice()}
+
+	if taskIP.Is4() {
+		result.egress4 = append(result.egress4, allows...)
+		result.isolated4 = append(result.isolated4, isolated)
+	} else {
+		result.egress6 = append(result.egress6, allows...)
+		result.isolated6 = append(result.isolated6, isolated)
+	}
+
+	return nil
+}
+
+// addElements adds the map element which translates the source of the task
+// connections to the passed elements.
+func (s *SNAT) addElements(result *elements) error {
+	taskIP, err := netip.ParseAddr(s.TaskIP)
+	if err != nil {
+		return fmt.Errorf("failed to parse task IP address: %w", err)
+	}
+
+	egressIP, err := netip.ParseAddr(s.EgressIP)
+	if err != nil {
+		return fmt.Errorf("failed to parse egress IP address: %w", err)
+	}
+
+	taskIP, egressIP = taskIP.Unmap(), egressIP.Unmap()
+	if taskIP.Is4() != egressIP.Is4() {
+		return fmt.Errorf("task IP %q and egress IP %q have mismatched address families",
+			s.TaskIP, s.EgressIP)
+	}
+
+	if s.Interface == "" || len(s.Interface) >= unix.IFNAMSIZ {
+		return fmt.Errorf("invalid egress interface name %q", s.Interface)
+	}
+
+	snat := nftables.SetElement{
+		Key: concat(taskIP.AsSlice(), ifname(s.Interface)),
+		Val: egressIP.AsSlice(),
+	}
+
+	if taskIP.Is4() {
+		result.snat4 = append(result.snat4, snat)
+	} else {
+		result.snat6 = append(result.snat6, snat)
+	}
+
+	return nil
+}
+
+// decodeConfig converts the data of a FilterRemoval into a configuration.
+// Task state written before the ingress and egress policies were supported
+// only contains the forwards.
+func decodeConfig(data any) (*Config, error) {
+	switch cfg := data.(type) {
+	case Config:
+		return &cfg, nil
+	case *Config:
+		return cfg, nil
+	}
+
+	if reflect.ValueOf(data).Kind() == reflect.Slice {
+		fwds, err := decodeForwards(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Config{Forwards: fwds}, nil
+	}
+
+	var cfg Config
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &cfg,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dec.Decode(data); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// decodeForwards converts the data of a FilterRemoval into forwards. When
+// the task state has been restored, the data will have been decoded into
+// generic types and requires conversion.
+func decodeForwards(data any) (Forwards, error) {
+	if fwds, ok := data.(Forwards); ok {
+		return fwds, nil
+	}
+
+	var fwds Forwards
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &fwds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dec.Decode(data); err != nil {
+		return nil, err
+	}
+
+	return fwds, nil
+}
+
+// prefixRange returns the first and last addresses within the prefix.
+func prefixRange(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	start := prefix.Masked().Addr().Unmap()
+
+	b := start.AsSlice()
+	for i := prefix.Bits() - (prefix.Addr().BitLen() - start.BitLen()); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	end, _ := netip.AddrFromSlice(b)
+
+	return start, end
+}
+
+// port encodes the port in network byte order, padded to the register size.
+func port(p int) []byte {
+	b := make([]byte, registerSize)
+	binary.BigEndian.PutUint16(b, uint16(p))
+	return b
+}
+
+// ifname encodes the interface name, padded to the size of the interface
+// name type.
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
+
+// pad pads the value to a multiple of the register size.
+func pad(b []byte) []byte {
+	if rem := len(b) % registerSize; rem != 0 {
+		b = append(b, make([]byte, registerSize-rem)...)
+	}
+	return b
+}
+
+// concat joins the passed values into a single slice.
+func concat(parts ...[]byte) []byte {
+	var result []byte
+	for _, p := range parts {
+		result = append(result, p...)
+	}
+	return result
+}
@@ -0,0 +1,312 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/shoenig/test/must"
+)
+
+func TestForwards_elements(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+			{Protocol: "udp", HostIP: "192.168.1.2", HostPort: 22223, TaskIP: "10.0.0.2", TaskPort: 53},
+		}
+
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+		must.Eq(t, []nftables.SetElement{
+			{
+				Key: []byte{6, 0, 0, 0, 192, 168, 1, 2, 0x56, 0xce, 0, 0},
+				Val: []byte{10, 0, 0, 2, 0x1f, 0x40, 0, 0},
+			},
+			{
+				Key: []byte{17, 0, 0, 0, 192, 168, 1, 2, 0x56, 0xcf, 0, 0},
+				Val: []byte{10, 0, 0, 2, 0, 53, 0, 0},
+			},
+		}, elems.dnat4)
+		must.Eq(t, []nftables.SetElement{
+			{Key: []byte{10, 0, 0, 2, 6, 0, 0, 0, 0x1f, 0x40, 0, 0}},
+			{Key: []byte{10, 0, 0, 2, 17, 0, 0, 0, 0, 53, 0, 0}},
+		}, elems.forward4)
+		must.SliceEmpty(t, elems.dnat6)
+		must.SliceEmpty(t, elems.forward6)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "sctp", HostIP: "fd00::1", HostPort: 22222, TaskIP: "fd00::2", TaskPort: 8000},
+		}
+
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+		must.SliceEmpty(t, elems.dnat4)
+		must.SliceEmpty(t, elems.forward4)
+		must.Eq(t, []nftables.SetElement{
+			{
+				Key: []byte{132, 0, 0, 0, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x56, 0xce, 0, 0},
+				Val: []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0x1f, 0x40, 0, 0},
+			},
+		}, elems.dnat6)
+		must.Eq(t, []nftables.SetElement{
+			{Key: []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 132, 0, 0, 0, 0x1f, 0x40, 0, 0}},
+		}, elems.forward6)
+	})
+
+	t.Run("source cidrs", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 22,
+				SourceCIDRs: []string{"172.16.0.0/12", "fd00::/8"}},
+		}
+
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+		must.Len(t, 1, elems.dnat4)
+		must.SliceEmpty(t, elems.forward4)
+		must.Eq(t, []nftables.SetElement{
+			{Key: []byte{10, 0, 0, 2, 6, 0, 0, 0, 0, 22, 0, 0}},
+		}, elems.restricted4)
+		must.Eq(t, []nftables.SetElement{
+			{
+				Key:    []byte{172, 16, 0, 0, 10, 0, 0, 2, 6, 0, 0, 0, 0, 22, 0, 0},
+				KeyEnd: []byte{172, 31, 255, 255, 10, 0, 0, 2, 6, 0, 0, 0, 0, 22, 0, 0},
+			},
+		}, elems.allow4)
+		must.SliceEmpty(t, elems.allow6)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		elems, err := Forwards{}.elements()
+		must.NoError(t, err)
+		must.True(t, elems.empty())
+	})
+
+	t.Run("invalid protocol", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "icmp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+		}
+
+		_, err := fwds.elements()
+		must.ErrorContains(t, err, "unsupported protocol")
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: "invalid", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+		}
+
+		_, err := fwds.elements()
+		must.ErrorContains(t, err, "failed to parse host IP address")
+	})
+
+	t.Run("mismatched families", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: "fd00::1", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+		}
+
+		_, err := fwds.elements()
+		must.ErrorContains(t, err, "mismatched address families")
+	})
+}
+
+func TestEgress_addElements(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		egress := &Egress{
+			TaskIP: "10.0.0.2",
+			Allow: []EgressRule{
+				{CIDR: "192.168.1.0/24"},
+				{CIDR: "10.1.0.0/16", Protocol: "udp", Port: 53},
+				{CIDR: "fd00::/8"},
+			},
+		}
+
+		elems := &elements{}
+		must.NoError(t, egress.addElements(elems))
+		must.Eq(t, []nftables.SetElement{{Key: []byte{10, 0, 0, 2}}}, elems.isolated4)
+		must.Eq(t, []nftables.SetElement{
+			{
+				Key:    []byte{10, 0, 0, 2, 192, 168, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+				KeyEnd: []byte{10, 0, 0, 2, 192, 168, 1, 255, 255, 0, 0, 0, 0xff, 0xff, 0, 0},
+			},
+			{
+				Key:    []byte{10, 0, 0, 2, 10, 1, 0, 0, 17, 0, 0, 0, 0, 53, 0, 0},
+				KeyEnd: []byte{10, 0, 0, 2, 10, 1, 255, 255, 17, 0, 0, 0, 0, 53, 0, 0},
+			},
+		}, elems.egress4)
+		must.SliceEmpty(t, elems.allow4)
+		must.SliceEmpty(t, elems.isolated6)
+		must.SliceEmpty(t, elems.egress6)
+	})
+
+	t.Run("deny all", func(t *testing.T) {
+		elems := &elements{}
+		must.NoError(t, (&Egress{TaskIP: "fd00::2"}).addElements(elems))
+		must.Eq(t, []nftables.SetElement{
+			{Key: []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}},
+		}, elems.isolated6)
+		must.SliceEmpty(t, elems.egress6)
+	})
+
+	t.Run("invalid protocol", func(t *testing.T) {
+		egress := &Egress{TaskIP: "10.0.0.2", Allow: []EgressRule{{CIDR: "10.0.0.0/8", Protocol: "icmp"}}}
+		must.ErrorContains(t, egress.addElements(&elements{}), "unsupported protocol")
+	})
+}
+
+func TestSNAT_addElements(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		elems := &elements{}
+		snat := &SNAT{TaskIP: "10.0.0.2", EgressIP: "192.168.1.3", Interface: "eth1"}
+		must.NoError(t, snat.addElements(elems))
+
+		must.Eq(t, []nftables.SetElement{{
+			Key: []byte{10, 0, 0, 2, 'e', 't', 'h', '1', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			Val: []byte{192, 168, 1, 3},
+		}}, elems.snat4)
+		must.SliceEmpty(t, elems.snat6)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		elems := &elements{}
+		snat := &SNAT{TaskIP: "fd00::2", EgressIP: "2001:db8::3", Interface: "eth1"}
+		must.NoError(t, snat.addElements(elems))
+
+		must.Len(t, 1, elems.snat6)
+		must.Eq(t, append(netip.MustParseAddr("fd00::2").AsSlice(), ifname("eth1")...), elems.snat6[0].Key)
+		must.Eq(t, netip.MustParseAddr("2001:db8::3").AsSlice(), elems.snat6[0].Val)
+		must.SliceEmpty(t, elems.snat4)
+	})
+
+	t.Run("mismatched families", func(t *testing.T) {
+		snat := &SNAT{TaskIP: "10.0.0.2", EgressIP: "2001:db8::3", Interface: "eth1"}
+		must.ErrorContains(t, snat.addElements(&elements{}), "mismatched address families")
+	})
+
+	t.Run("invalid interface", func(t *testing.T) {
+		snat := &SNAT{TaskIP: "10.0.0.2", EgressIP: "192.168.1.3"}
+		must.ErrorContains(t, snat.addElements(&elements{}), "invalid egress interface name")
+	})
+}
+
+func Test_prefixRange(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, start, end string
+	}{
+		{"10.1.2.3/16", "10.1.0.0", "10.1.255.255"},
+		{"10.1.2.3/32", "10.1.2.3", "10.1.2.3"},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255"},
+		{"fd00::/120", "fd00::", "fd00::ff"},
+	} {
+		start, end := prefixRange(netip.MustParsePrefix(tc.prefix))
+		must.Eq(t, tc.start, start.String())
+		must.Eq(t, tc.end, end.String())
+	}
+}
+
+func Test_decodeConfig(t *testing.T) {
+	expected := &Config{
+		Forwards: Forwards{
+			{Protocol: "tcp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000,
+				SourceCIDRs: []string{"10.0.0.0/8"}},
+		},
+		Egress: &Egress{
+			TaskIP: "10.0.0.2",
+			Allow:  []EgressRule{{CIDR: "192.168.0.0/16", Protocol: "udp", Port: 53}},
+		},
+	}
+
+	t.Run("config", func(t *testing.T) {
+		config, err := decodeConfig(*expected)
+		must.NoError(t, err)
+		must.Eq(t, expected, config)
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		data := map[string]any{
+			"Forwards": []any{
+				map[string]any{
+					"Protocol":    "tcp",
+					"HostIP":      "192.168.1.2",
+					"HostPort":    int64(22222),
+					"TaskIP":      "10.0.0.2",
+					"TaskPort":    uint64(8000),
+					"SourceCIDRs": []any{"10.0.0.0/8"},
+				},
+			},
+			"Egress": map[string]any{
+				"TaskIP": "10.0.0.2",
+				"Allow": []any{
+					map[string]any{"CIDR": "192.168.0.0/16", "Protocol": "udp", "Port": int64(53)},
+				},
+			},
+		}
+
+		config, err := decodeConfig(data)
+		must.NoError(t, err)
+		must.Eq(t, expected, config)
+	})
+
+	t.Run("restored forwards", func(t *testing.T) {
+		// Task state written before the ingress and egress policies were
+		// supported only contains the forwards.
+		data := []any{
+			map[string]any{
+				"Protocol": "tcp",
+				"HostIP":   "192.168.1.2",
+				"HostPort": int64(22222),
+				"TaskIP":   "10.0.0.2",
+				"TaskPort": uint64(8000),
+			},
+		}
+
+		config, err := decodeConfig(data)
+		must.NoError(t, err)
+		must.Eq(t, &Config{Forwards: Forwards{
+			{Protocol: "tcp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+		}}, config)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := decodeConfig("invalid")
+		must.Error(t, err)
+	})
+}
+
+func Test_decodeForwards(t *testing.T) {
+	expected := Forwards{
+		{Protocol: "tcp", HostIP: "192.168.1.2", HostPort: 22222, TaskIP: "10.0.0.2", TaskPort: 8000},
+	}
+
+	t.Run("forwards", func(t *testing.T) {
+		fwds, err := decodeForwards(expected)
+		must.NoError(t, err)
+		must.Eq(t, expected, fwds)
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		// Task state restored from msgpack results in generic types.
+		data := []any{
+			map[string]any{
+				"Protocol": "tcp",
+				"HostIP":   "192.168.1.2",
+				"HostPort": int64(22222),
+				"TaskIP":   "10.0.0.2",
+				"TaskPort": uint64(8000),
+			},
+		}
+
+		fwds, err := decodeForwards(data)
+		must.NoError(t, err)
+		must.Eq(t, expected, fwds)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := decodeForwards("invalid")
+		must.Error(t, err)
+	})
+}
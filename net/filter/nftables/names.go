@@ -0,0 +1,164 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+const (
+	// defaultTableName is the name of the nftables table used by the driver.
+	// The table is of the inet family, so it handles both IPv4 and IPv6.
+	defaultTableName = "nomad_vt"
+
+	// defaultChainNamePrerouting is the name of the chain used for
+	// translating host ports to the task address.
+	defaultChainNamePrerouting = "prerouting"
+
+	// defaultChainNameOutput is the name of the chain used for translating
+	// host ports to the task address for locally generated traffic.
+	defaultChainNameOutput = "output"
+
+	// defaultChainNamePostrouting is the name of the chain used to masquerade
+	// loopback traffic which has been translated to the task address.
+	defaultChainNamePostrouting = "postrouting"
+
+	// defaultChainNameSNAT is the name of the chain used for translating the
+	// source of connections initiated by tasks to their egress address. It
+	// has a higher priority than the source translation of other tables, so
+	// the network masquerade rules do not apply to the connections.
+	defaultChainNameSNAT = "snat"
+
+	// defaultChainNameForward is the name of the chain used to accept new
+	// connections to forwarded task ports.
+	defaultChainNameForward = "forward"
+
+	// defaultMapNameDNAT4 is the name of the map containing the IPv4 port
+	// forwards. It is keyed by protocol, host address and host port, and
+	// contains the task address and port.
+	defaultMapNameDNAT4 = "dnat4"
+
+	// defaultMapNameDNAT6 is the name of the map containing the IPv6 port
+	// forwards.
+	defaultMapNameDNAT6 = "dnat6"
+
+	// defaultMapNameSNAT4 is the name of the map containing the IPv4 egress
+	// addresses. It is keyed by task address and output interface, and
+	// contains the egress address.
+	defaultMapNameSNAT4 = "snat4"
+
+	// defaultMapNameSNAT6 is the name of the map containing the IPv6 egress
+	// addresses.
+	defaultMapNameSNAT6 = "snat6"
+
+	// defaultSetNameForward4 is the name of the set containing the IPv4 task
+	// address, protocol and port combinations which are accepted.
+	defaultSetNameForward4 = "forward4"
+
+	// defaultSetNameForward6 is the name of the set containing the IPv6 task
+	// address, protocol and port combinations which are accepted.
+	defaultSetNameForward6 = "forward6"
+
+	// defaultSetNameAllow4 is the name of the interval set containing the
+	// IPv4 source and destination address, protocol and port ranges of
+	// connections to restricted task ports which are accepted. It is used
+	// for the ingress policies of tasks.
+	defaultSetNameAllow4 = "allow4"
+
+	// defaultSetNameAllow6 is the name of the interval set containing the
+	// IPv6 source and destination address, protocol and port ranges of
+	// connections which are accepted.
+	defaultSetNameAllow6 = "allow6"
+
+	// defaultSetNameEgress4 is the name of the interval set containing the
+	// IPv4 task address and the destination address, protocol and port
+	// ranges of the connections isolated tasks may initiate. It is used for
+	// the egress policies of tasks.
+	defaultSetNameEgress4 = "egress4"
+
+	// defaultSetNameEgress6 is the name of the interval set containing the
+	// IPv6 task address and the destination address, protocol and port
+	// ranges of the connections isolated tasks may initiate.
+	defaultSetNameEgress6 = "egress6"
+
+	// defaultSetNameRestricted4 is the name of the set containing the IPv4
+	// task address, protocol and port combinations which only accept
+	// connections from the sources within the allow set.
+	defaultSetNameRestricted4 = "restricted4"
+
+	// defaultSetNameRestricted6 is the name of the set containing the IPv6
+	// task address, protocol and port combinations which only accept
+	// connections from the sources within the allow set.
+	defaultSetNameRestricted6 = "restricted6"
+
+	// defaultSetNameIsolated4 is the name of the set containing the IPv4 task
+	// addresses which can only initiate connections to the destinations
+	// within the egress set.
+	defaultSetNameIsolated4 = "isolated4"
+
+	// defaultSetNameIsolated6 is the name of the set containing the IPv6 task
+	// addresses which can only initiate connections to the destinations
+	// within the egress set.
+	defaultSetNameIsolated6 = "isolated6"
+)
+
+// names holds the names of the table, chains and sets used in nftables.
+type names struct {
+	Table  string
+	Chains *ChainNames
+	Sets   *SetNames
+}
+
+// ChainNames holds the names of the chains used in nftables.
+type ChainNames struct {
+	Forward     string
+	Output      string
+	Postrouting string
+	Prerouting  string
+	SNAT        string
+}
+
+// SetNames holds the names of the sets and maps used in nftables.
+type SetNames struct {
+	DNAT4       string
+	DNAT6       string
+	SNAT4       string
+	SNAT6       string
+	Forward4    string
+	Forward6    string
+	Allow4      string
+	Allow6      string
+	Egress4     string
+	Egress6     string
+	Restricted4 string
+	Restricted6 string
+	Isolated4   string
+	Isolated6   string
+}
+
+// NewNames creates a new instance with all values set to defaults.
+func NewNames() *names {
+	return &names{
+		Table: defaultTableName,
+		Chains: &ChainNames{
+			Forward:     defaultChainNameForward,
+			Output:      defaultChainNameOutput,
+			Postrouting: defaultChainNamePostrouting,
+			Prerouting:  defaultChainNamePrerouting,
+			SNAT:        defaultChainNameSNAT,
+		},
+		Sets: &SetNames{
+			DNAT4:       defaultMapNameDNAT4,
+			DNAT6:       defaultMapNameDNAT6,
+			SNAT4:       defaultMapNameSNAT4,
+			SNAT6:       defaultMapNameSNAT6,
+			Forward4:    defaultSetNameForward4,
+			Forward6:    defaultSetNameForward6,
+			Allow4:      defaultSetNameAllow4,
+			Allow6:      defaultSetNameAllow6,
+			Egress4:     defaultSetNameEgress4,
+			Egress6:     defaultSetNameEgress6,
+			Restricted4: defaultSetNameRestricted4,
+			Restricted6: defaultSetNameRestricted6,
+			Isolated4:   defaultSetNameIsolated4,
+			Isolated6:   defaultSetNameIsolated6,
+		},
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+)
+
+var (
+	// loadLock is used to synchronize creation and setup of the singleton.
+	loadLock sync.Mutex
+
+	// singleton is the single instance of the filter.Filter interface.
+	singleton *virtNFT
+)
+
+const (
+	// removalName is the name set in the FilterRemoval
+	removalName = "nftables"
+)
+
+// NFTables is the interface for nftables which defines the subset of
+// functions that are currently used. This allows for easily swapping out
+// implementations for testing. Changes are queued until Flush is called,
+// at which point they are applied atomically in a single batch.
+type NFTables interface {
+	AddTable(t *nftables.Table) *nftables.Table
+	AddChain(c *nftables.Chain) *nftables.Chain
+	AddSet(s *nftables.Set, vals []nftables.SetElement) error
+	AddRule(r *nftables.Rule) *nftables.Rule
+	FlushChain(c *nftables.Chain)
+	SetAddElements(s *nftables.Set, vals []nftables.SetElement) error
+	SetDeleteElements(s *nftables.Set, vals []nftables.SetElement) error
+	Flush() error
+}
+
+// New returns the filter.Filter interface instance. If the singleton instance
+// does not yet exist it will create the instance and run setup. Otherwise it
+// will return the existing instance.
+func New() (*virtNFT, error) {
+	loadLock.Lock()
+	defer loadLock.Unlock()
+
+	if singleton != nil {
+		return singleton, nil
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	nt := &virtNFT{
+		conn:                       conn,
+		names:                      NewNames(),
+		interfaceByIPGetter:        filter.InterfaceByIP,
+		routingInterfaceByIPGetter: filter.RoutingInterfaceByIP,
+		logger:                     hclog.Default().Named("nftables"),
+	}
+
+	if err := nt.setup(); err != nil {
+		return nil, err
+	}
+
+	singleton = nt
+	return singleton, nil
+}
@@ -0,0 +1,805 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errLoopbackNotEnabled   = filter.ErrLoopbackNotEnabled
+	errLoopbackNotSupported = errors.New("loopback port forwarding not supported for IPv6")
+)
+
+// ctStatusDNAT is the conntrack status bit set on connections which have had
+// their destination translated.
+const ctStatusDNAT = 0x20
+
+// virtNFT implements the filter.Filter interface using nftables.
+//
+// All the configuration lives within a dedicated inet table. The chains and
+// rules within the table are static, and only reference maps and sets. Port
+// forwards for a task are added and removed as elements of those maps and
+// sets, which allows each task to be configured and removed atomically within
+// a single netlink batch.
+type virtNFT struct {
+	logger hclog.Logger
+	conn   NFTables
+	names  *names
+	m      sync.Mutex
+
+	// Everything below is used for testing.
+
+	// routeLocalnetTemplate is a template for creating the path to the kernel
+	// runtime configuration for device localnet routing.
+	routeLocalnetPathTemplate string
+
+	// interfaceByIPGetter is the function that queries the host using the
+	// passed IP address and identifies the interface it is assigned to.
+	interfaceByIPGetter
+
+	// routingIngerfaceByIPGetter is the function that queries the host using
+	// the passed IP address and identifies the interface used to reach it.
+	routingInterfaceByIPGetter
+}
+
+// SetLogger sets the logger used.
+func (n *virtNFT) SetLogger(logger hclog.Logger) {
+	n.logger = logger
+}
+
+// Configure adds elements to the nftables maps and sets to enable port
+// forwards based on the passed resources, and to apply the ingress and egress
+// policies and the egress address of the bridge config. It returns the
+// configuration that can be used to remove the elements with the Teardown
+// function. Ports with a host IP, and an egress address, of a different
+// address family to the passed IP are skipped, as they cannot be translated
+// to the destination.
+func (n *virtNFT) Configure(res *drivers.Resources, cfg *virtnet.NetworkInterfaceBridgeConfig, ip string) (*virtnet.FilterRemoval, error) {
+	// Check that received values are suitable for configuration.
+	if res == nil {
+		return nil, errors.New("cannot configure nftables, resources not provided")
+	}
+
+	if cfg == nil {
+		return nil, errors.New("cannot configure nftables, bridge config not provided")
+	}
+
+	taskIP, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task IP address: %w", err)
+	}
+	ipv6 := taskIP.Unmap().Is6()
+
+	// If the ports are nil, egress is unrestricted and the egress address is
+	// not set, there's nothing to do.
+	if res.Ports == nil && !cfg.Egress.Restricted() && cfg.EgressIP == "" {
+		return &virtnet.FilterRemoval{Name: removalName}, nil
+	}
+
+	var allocatedPorts structs.AllocatedPorts
+	if res.Ports != nil {
+		allocatedPorts = *res.Ports
+	}
+
+	fwds := Forwards{}
+
+	// Ports placed on a Nomad host network are validated against the host
+	// interfaces, as the address may have been removed since the client
+	// fingerprinted the host network.
+	hostNetworks := virtnet.HostNetworks(res)
+
+	// Iterate the ports configured within the network interface and pull these
+	// from the task allocated ports.
+	for _, port := range cfg.Ports {
+		mapping, err := virtnet.ParsePortMapping(port)
+		if err != nil {
+			return nil, err
+		}
+
+		reservedPort, ok := allocatedPorts.Get(mapping.Label)
+		if !ok {
+			n.logger.Error("failed to find reserved port", "port", mapping.Label)
+			continue
+		}
+
+		hostIP, err := netip.ParseAddr(reservedPort.HostIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host IP address: %w", err)
+		}
+
+		if hostIP.Unmap().Is6() != ipv6 {
+			n.logger.Debug("skipping port with mismatched address family", "port", port,
+				"host_ip", reservedPort.HostIP, "task_ip", ip)
+			continue
+		}
+
+		if hostNetwork, ok := hostNetworks[mapping.Label]; ok && !hostIP.IsLoopback() {
+			if _, err := n.interfaceByIPGetter(net.ParseIP(reservedPort.HostIP)); err != nil {
+				return nil, fmt.Errorf("%w: port %q placed on host_network %q with address %s",
+					filter.ErrHostNetworkUnavailable, mapping.Label, hostNetwork, reservedPort.HostIP)
+			}
+		}
+
+		// The ports of a range are not translated, so each port is
+		// forwarded to the same port of the task.
+		hostPorts := []int{reservedPort.Value}
+		taskPorts := []int{virtnet.GuestPort(reservedPort)}
+		if mapping.IsRange() {
+			first, last, ok, err := mapping.Range(allocatedPorts)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				n.logger.Error("failed to find reserved port", "port", mapping.LastLabel)
+				continue
+			}
+
+			hostPorts = hostPorts[:0]
+			for value := first.Value; value <= last.Value; value++ {
+				hostPorts = append(hostPorts, value)
+			}
+			taskPorts = hostPorts
+		}
+
+		// If the host IP provided is a loopback, the traffic is translated
+		// within the output chain and then masqueraded. This is a special
+		// case which requires the host to be properly configured.
+		if hostIP.IsLoopback() {
+			if ipv6 {
+				return nil, fmt.Errorf("%w - %s", errLoopbackNotSupported, reservedPort.HostIP)
+			}
+
+			dstIface, err := n.routingInterfaceByIPGetter(ip)
+			if err != nil {
+				return nil, fmt.Errorf("failed to identify IP interface: %w", err)
+			}
+
+			if !n.loopbackPortForwardsSupported(dstIface) {
+				n.logger.Error(fmt.Sprintf("loopback port forwarding requires kernel runtime configuration - net.ipv4.conf.%s.route_localnet=1", dstIface))
+				return nil, fmt.Errorf("%w for device - %s", errLoopbackNotEnabled, dstIface)
+			}
+		}
+
+		for i, hostPort := range hostPorts {
+			fwds = append(fwds, Forward{
+				Protocol:    string(mapping.Protocol),
+				HostIP:      reservedPort.HostIP,
+				HostPort:    hostPort,
+				TaskIP:      ip,
+				TaskPort:    taskPorts[i],
+				SourceCIDRs: cfg.IngressCIDRs(mapping, allocatedPorts),
+			})
+		}
+	}
+
+	config := Config{Forwards: fwds}
+	if cfg.Egress.Restricted() {
+		config.Egress = &Egress{TaskIP: ip}
+		for _, allow := range cfg.Egress.Allow {
+			config.Egress.Allow = append(config.Egress.Allow, EgressRule{
+				CIDR:     allow.CIDR,
+				Protocol: string(allow.Protocol),
+				Port:     allow.Port,
+			})
+		}
+	}
+
+	egressIP, err := cfg.EgressAddress(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if egressIP != "" {
+		egressAddr, err := netip.ParseAddr(egressIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse egress IP address: %w", err)
+		}
+
+		if egressAddr.Unmap().Is6() != ipv6 {
+			n.logger.Debug("skipping egress address with mismatched address family",
+				"egress_ip", egressIP, "task_ip", ip)
+		} else {
+			iface, err := n.interfaceByIPGetter(net.ParseIP(egressIP))
+			if err != nil {
+				return nil, fmt.Errorf("failed to identify egress IP interface: %w", err)
+			}
+
+			config.SNAT = &SNAT{TaskIP: ip, EgressIP: egressIP, Interface: iface}
+		}
+	}
+
+	elems, err := config.elements()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.add(elems); err != nil {
+		return nil, err
+	}
+
+	return &virtnet.FilterRemoval{
+		Name: removalName,
+		Data: config,
+	}, nil
+}
+
+// Teardown removes the port forwards and policies from the nftables maps and
+// sets.
+func (n *virtNFT) Teardown(removal *virtnet.FilterRemoval) error {
+	// If there is no removal information then there
+	// is nothing to do.
+	if removal == nil || removal.Data == nil {
+		return nil
+	}
+
+	// Removal information generated by a different filter implementation
+	// cannot be handled. The configuration of the iptables filter is removed
+	// when migrating to this implementation, so there is nothing left to do.
+	if removal.Name != removalName {
+		n.logger.Debug("skipping teardown of configuration from other filter", "name", removal.Name)
+		return nil
+	}
+
+	config, err := decodeConfig(removal.Data)
+	if err != nil {
+		n.logger.Error("invalid teardown data received", "name", removal.Name,
+			"type", hclog.Fmt("%T", removal.Data), "error", err)
+		return fmt.Errorf("invalid teardown data, cannot remove nftables elements")
+	}
+
+	elems, err := config.elements()
+	if err != nil {
+		return err
+	}
+
+	return n.remove(elems)
+}
+
+// setup is responsible for ensuring the nftables table, chains, maps, sets
+// and rules used by the driver exist. The chains are flushed before the rules
+// are added, so running setup multiple times does not duplicate rules. The
+// elements of the maps and sets are untouched, which ensures port forwards of
+// running tasks persist across driver restarts.
+func (n *virtNFT) setup() error {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	table := n.table()
+	n.conn.AddTable(table)
+
+	sets := n.sets()
+	for _, set := range []*nftables.Set{
+		sets.dnat4, sets.dnat6, sets.snat4, sets.snat6, sets.forward4, sets.forward6,
+		sets.allow4, sets.allow6, sets.egress4, sets.egress6, sets.restricted4, sets.restricted6,
+		sets.isolated4, sets.isolated6,
+	} {
+		if err := n.conn.AddSet(set, nil); err != nil {
+			return fmt.Errorf("setup failure: failed to add set %q: %w", set.Name, err)
+		}
+	}
+
+	prerouting := n.conn.AddChain(&nftables.Chain{
+		Name:     n.names.Chains.Prerouting,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPrerouting,
+		Priority: nftables.ChainPriorityNATDest,
+	})
+	output := n.conn.AddChain(&nftables.Chain{
+		Name:     n.names.Chains.Output,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityNATDest,
+	})
+	postrouting := n.conn.AddChain(&nftables.Chain{
+		Name:     n.names.Chains.Postrouting,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+	snat := n.conn.AddChain(&nftables.Chain{
+		Name:     n.names.Chains.SNAT,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityRef(*nftables.ChainPriorityNATSource - 1),
+	})
+	forward := n.conn.AddChain(&nftables.Chain{
+		Name:     n.names.Chains.Forward,
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	for _, chain := range []*nftables.Chain{prerouting, output, postrouting, snat, forward} {
+		n.conn.FlushChain(chain)
+	}
+
+	// Translate the destination of traffic arriving at, or generated for, a
+	// forwarded host port.
+	for _, chain := range []*nftables.Chain{prerouting, output} {
+		n.conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: dnatExprs(sets.dnat4, false)})
+		n.conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: dnatExprs(sets.dnat6, true)})
+	}
+
+	// Masquerade loopback traffic which has been translated, so the task can
+	// respond to it.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: postrouting, Exprs: loopbackMasqExprs()})
+
+	// Translate the source of connections initiated by a task to its egress
+	// address.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: snat, Exprs: snatExprs(sets.snat4, false)})
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: snat, Exprs: snatExprs(sets.snat6, true)})
+
+	// Drop new connections initiated by isolated tasks which are not allowed
+	// by their egress policy. This is evaluated first, so the connections
+	// accepted below can not bypass the policy.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: isolatedExprs(sets.isolated4, sets.egress4, false)})
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: isolatedExprs(sets.isolated6, sets.egress6, true)})
+
+	// Accept new connections allowed by the ingress policies.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward, Exprs: allowExprs(sets.allow4, false)})
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward, Exprs: allowExprs(sets.allow6, true)})
+
+	// Accept new connections to forwarded task ports. The accept does not
+	// override a reject by the base chains of other tables, such as the
+	// firewall rules libvirt adds for networks using NAT, so the network
+	// controller does not forward ports to those networks.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: forwardExprs(sets.forward4, false, expr.VerdictAccept)})
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: forwardExprs(sets.forward6, true, expr.VerdictAccept)})
+
+	// Drop new connections to restricted task ports which were not allowed.
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: forwardExprs(sets.restricted4, false, expr.VerdictDrop)})
+	n.conn.AddRule(&nftables.Rule{Table: table, Chain: forward,
+		Exprs: forwardExprs(sets.restricted6, true, expr.VerdictDrop)})
+
+	if err := n.conn.Flush(); err != nil {
+		return fmt.Errorf("setup failure: %w", err)
+	}
+
+	return nil
+}
+
+// add adds the elements to their sets within a single batch.
+func (n *virtNFT) add(elems *elements) error {
+	if elems.empty() {
+		return nil
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	for _, g := range n.groups(elems) {
+		if err := n.conn.SetAddElements(g.set, g.vals); err != nil {
+			return fmt.Errorf("failed to add elements to %q: %w", g.set.Name, err)
+		}
+	}
+
+	if err := n.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to add port forwards: %w", err)
+	}
+
+	return nil
+}
+
+// remove removes the elements from their sets within a single batch. If the
+// batch fails, each element is removed individually, so elements which no
+// longer exist do not prevent the removal of the others.
+// NOTE: Removal errors are _not_ immediately fatal allowing as much to
+// be removed as possible. The errors will be collected and returned as
+// a multierror.
+func (n *virtNFT) remove(elems *elements) error {
+	if elems.empty() {
+		return nil
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	groups := n.groups(elems)
+	for _, g := range groups {
+		if err := n.conn.SetDeleteElements(g.set, g.vals); err != nil {
+			return fmt.Errorf("failed to remove elements from %q: %w", g.set.Name, err)
+		}
+	}
+
+	err := n.conn.Flush()
+	if err == nil {
+		return nil
+	}
+	n.logger.Debug("batch removal of port forwards failed, removing individually", "error", err)
+
+	var mErr *multierror.Error
+	for _, g := range groups {
+		for _, val := range g.vals {
+			if err := n.conn.SetDeleteElements(g.set, []nftables.SetElement{val}); err != nil {
+				mErr = multierror.Append(mErr, err)
+				continue
+			}
+
+			if err := n.conn.Flush(); err != nil && !errors.Is(err, unix.ENOENT) {
+				n.logger.Error("failed to delete nftables element", "set", g.set.Name, "error", err)
+				mErr = multierror.Append(mErr, err)
+			}
+		}
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// setElements holds elements and the set they belong to.
+type setElements struct {
+	set  *nftables.Set
+	vals []nftables.SetElement
+}
+
+// groups returns the non-empty elements grouped by the set they belong to.
+func (n *virtNFT) groups(elems *elements) []setElements {
+	sets := n.sets()
+	groups := []setElements{}
+	for _, g := range []setElements{
+		{set: sets.dnat4, vals: elems.dnat4},
+		{set: sets.dnat6, vals: elems.dnat6},
+		{set: sets.snat4, vals: elems.snat4},
+		{set: sets.snat6, vals: elems.snat6},
+		{set: sets.forward4, vals: elems.forward4},
+		{set: sets.forward6, vals: elems.forward6},
+		{set: sets.allow4, vals: elems.allow4},
+		{set: sets.allow6, vals: elems.allow6},
+		{set: sets.egress4, vals: elems.egress4},
+		{set: sets.egress6, vals: elems.egress6},
+		{set: sets.restricted4, vals: elems.restricted4},
+		{set: sets.restricted6, vals: elems.restricted6},
+		{set: sets.isolated4, vals: elems.isolated4},
+		{set: sets.isolated6, vals: elems.isolated6},
+	} {
+		if len(g.vals) > 0 {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// table returns the table used by the driver.
+func (n *virtNFT) table() *nftables.Table {
+	return &nftables.Table{
+		Name:   n.names.Table,
+		Family: nftables.TableFamilyINet,
+	}
+}
+
+// driverSets holds the maps and sets used by the driver.
+type driverSets struct {
+	dnat4, dnat6             *nftables.Set
+	snat4, snat6             *nftables.Set
+	forward4, forward6       *nftables.Set
+	allow4, allow6           *nftables.Set
+	egress4, egress6         *nftables.Set
+	restricted4, restricted6 *nftables.Set
+	isolated4, isolated6     *nftables.Set
+}
+
+// sets returns the maps and sets used by the driver.
+func (n *virtNFT) sets() *driverSets {
+	table := n.table()
+
+	return &driverSets{
+		dnat4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.DNAT4,
+			IsMap:         true,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeInetProto, nftables.TypeIPAddr, nftables.TypeInetService),
+			DataType:      nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeInetService),
+		},
+		dnat6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.DNAT6,
+			IsMap:         true,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeInetProto, nftables.TypeIP6Addr, nftables.TypeInetService),
+			DataType:      nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeInetService),
+		},
+		snat4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.SNAT4,
+			IsMap:         true,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeIFName),
+			DataType:      nftables.TypeIPAddr,
+		},
+		snat6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.SNAT6,
+			IsMap:         true,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeIFName),
+			DataType:      nftables.TypeIP6Addr,
+		},
+		forward4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Forward4,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		forward6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Forward6,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		allow4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Allow4,
+			Concatenation: true,
+			Interval:      true,
+			KeyType: nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeIPAddr,
+				nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		allow6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Allow6,
+			Concatenation: true,
+			Interval:      true,
+			KeyType: nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeIP6Addr,
+				nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		egress4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Egress4,
+			Concatenation: true,
+			Interval:      true,
+			KeyType: nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeIPAddr,
+				nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		egress6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Egress6,
+			Concatenation: true,
+			Interval:      true,
+			KeyType: nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeIP6Addr,
+				nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		restricted4: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Restricted4,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		restricted6: &nftables.Set{
+			Table:         table,
+			Name:          n.names.Sets.Restricted6,
+			Concatenation: true,
+			KeyType:       nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeInetProto, nftables.TypeInetService),
+		},
+		isolated4: &nftables.Set{
+			Table:   table,
+			Name:    n.names.Sets.Isolated4,
+			KeyType: nftables.TypeIPAddr,
+		},
+		isolated6: &nftables.Set{
+			Table:   table,
+			Name:    n.names.Sets.Isolated6,
+			KeyType: nftables.TypeIP6Addr,
+		},
+	}
+}
+
+// addrOffsets returns the network header offset of the source and
+// destination addresses, and the address length for the address family.
+func addrOffsets(ipv6 bool) (src, dst, length uint32) {
+	if ipv6 {
+		return 8, 24, 16
+	}
+	return 12, 16, 4
+}
+
+// nfprotoExprs returns the expressions which match the address family.
+func nfprotoExprs(ipv6 bool) []expr.Any {
+	proto := byte(unix.NFPROTO_IPV4)
+	if ipv6 {
+		proto = unix.NFPROTO_IPV6
+	}
+
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+	}
+}
+
+// dnatExprs returns the expressions which translate the destination of
+// traffic matching an element of the map. This is the equivalent of:
+//
+//	dnat ip to meta l4proto . ip daddr . th dport map @dnat4
+func dnatExprs(set *nftables.Set, ipv6 bool) []expr.Any {
+	_, dst, length := addrOffsets(ipv6)
+	family := uint32(unix.NFPROTO_IPV4)
+	if ipv6 {
+		family = unix.NFPROTO_IPV6
+	}
+
+	// The key is loaded into the 32-bit registers starting at 8, with the
+	// address following the protocol and the port following the address.
+	addrReg := uint32(9)
+	portReg := addrReg + length/registerSize
+
+	// The map data is written into the registers starting at 8, with the
+	// port following the address.
+	dataPortReg := 8 + length/registerSize
+
+	return append(nfprotoExprs(ipv6),
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 8},
+		&expr.Payload{DestRegister: addrReg, Base: expr.PayloadBaseNetworkHeader, Offset: dst, Len: length},
+		&expr.Payload{DestRegister: portReg, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Lookup{SourceRegister: 8, DestRegister: 1, IsDestRegSet: true, SetName: set.Name, SetID: set.ID},
+		&expr.NAT{
+			Type:        expr.NATTypeDestNAT,
+			Family:      family,
+			RegAddrMin:  1,
+			RegProtoMin: dataPortReg,
+			Specified:   true,
+		},
+	)
+}
+
+// snatExprs returns the expressions which translate the source of traffic
+// matching an element of the map. This is the equivalent of:
+//
+//	snat ip to ip saddr . meta oifname map @snat4
+func snatExprs(set *nftables.Set, ipv6 bool) []expr.Any {
+	src, _, length := addrOffsets(ipv6)
+	family := uint32(unix.NFPROTO_IPV4)
+	if ipv6 {
+		family = unix.NFPROTO_IPV6
+	}
+
+	return append(nfprotoExprs(ipv6),
+		&expr.Payload{DestRegister: 8, Base: expr.PayloadBaseNetworkHeader, Offset: src, Len: length},
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 8 + length/registerSize},
+		&expr.Lookup{SourceRegister: 8, DestRegister: 1, IsDestRegSet: true, SetName: set.Name, SetID: set.ID},
+		&expr.NAT{
+			Type:       expr.NATTypeSourceNAT,
+			Family:     family,
+			RegAddrMin: 1,
+		},
+	)
+}
+
+// ctStateNewExprs returns the expressions which match new connections.
+func ctStateNewExprs() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitNEW),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+	}
+}
+
+// forwardExprs returns the expressions which apply the verdict to new
+// connections to a task address and port within the set. This is the
+// equivalent of:
+//
+//	ct state new ip daddr . meta l4proto . th dport @forward4 accept
+func forwardExprs(set *nftables.Set, ipv6 bool, verdict expr.VerdictKind) []expr.Any {
+	_, dst, length := addrOffsets(ipv6)
+	protoReg := 8 + length/registerSize
+
+	exprs := append(nfprotoExprs(ipv6), ctStateNewExprs()...)
+	return append(exprs,
+		&expr.Payload{DestRegister: 8, Base: expr.PayloadBaseNetworkHeader, Offset: dst, Len: length},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: protoReg},
+		&expr.Payload{DestRegister: protoReg + 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Lookup{SourceRegister: 8, SetName: set.Name, SetID: set.ID},
+		&expr.Verdict{Kind: verdict},
+	)
+}
+
+// allowExprs returns the expressions which accept new connections with a
+// source address, destination address, protocol and port within the ranges
+// of the set. This is the equivalent of:
+//
+//	ct state new ip saddr . ip daddr . meta l4proto . th dport @allow4 accept
+func allowExprs(set *nftables.Set, ipv6 bool) []expr.Any {
+	src, dst, length := addrOffsets(ipv6)
+	dstReg := 8 + length/registerSize
+	protoReg := dstReg + length/registerSize
+
+	exprs := append(nfprotoExprs(ipv6), ctStateNewExprs()...)
+	return append(exprs,
+		&expr.Payload{DestRegister: 8, Base: expr.PayloadBaseNetworkHeader, Offset: src, Len: length},
+		&expr.Payload{DestRegister: dstReg, Base: expr.PayloadBaseNetworkHeader, Offset: dst, Len: length},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: protoReg},
+		&expr.Payload{DestRegister: protoReg + 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Lookup{SourceRegister: 8, SetName: set.Name, SetID: set.ID},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	)
+}
+
+// isolatedExprs returns the expressions which drop new connections initiated
+// by a task address within the isolated set, unless the source address,
+// destination address, protocol and port are within the ranges of the egress
+// set. This is the equivalent of:
+//
+//	ct state new ip saddr @isolated4 ip saddr . ip daddr . meta l4proto . th dport != @egress4 drop
+func isolatedExprs(isolated, egress *nftables.Set, ipv6 bool) []expr.Any {
+	src, dst, length := addrOffsets(ipv6)
+	dstReg := 8 + length/registerSize
+	protoReg := dstReg + length/registerSize
+
+	exprs := append(nfprotoExprs(ipv6), ctStateNewExprs()...)
+	return append(exprs,
+		&expr.Payload{DestRegister: 8, Base: expr.PayloadBaseNetworkHeader, Offset: src, Len: length},
+		&expr.Payload{DestRegister: dstReg, Base: expr.PayloadBaseNetworkHeader, Offset: dst, Len: length},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: protoReg},
+		&expr.Payload{DestRegister: protoReg + 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Lookup{SourceRegister: 8, SetName: isolated.Name, SetID: isolated.ID},
+		&expr.Lookup{SourceRegister: 8, SetName: egress.Name, SetID: egress.ID, Invert: true},
+		&expr.Verdict{Kind: expr.VerdictDrop},
+	)
+}
+
+// loopbackMasqExprs returns the expressions which masquerade loopback
+// traffic that has been translated to a task. This is the equivalent of:
+//
+//	ct status dnat ip saddr 127.0.0.0/8 masquerade
+func loopbackMasqExprs() []expr.Any {
+	src, _, length := addrOffsets(false)
+
+	return append(nfprotoExprs(false),
+		&expr.Ct{Key: expr.CtKeySTATUS, Register: 1},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(ctStatusDNAT),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: src, Len: length},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            length,
+			Mask:           net.IPv4Mask(255, 0, 0, 0),
+			Xor:            make([]byte, length),
+		},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: net.IPv4(127, 0, 0, 0).To4()},
+		&expr.Masq{},
+	)
+}
+
+// loopbackPortForwardsSupported returns if the host has been configured for routing localnet packets.
+func (n *virtNFT) loopbackPortForwardsSupported(device string) bool {
+	return filter.LoopbackPortForwardsSupported(n.logger, n.routeLocalnetPathTemplate, device)
+}
@@ -0,0 +1,706 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	mock_nftables "github.com/hashicorp/nomad-driver-virt/testutil/mock/nftables"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/shoenig/test/must"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	_ filter.Filter = (*virtNFT)(nil)
+)
+
+func Test_virtNFT_Configure(t *testing.T) {
+	hostIP := "192.168.44.22"
+	taskIP := "10.0.22.33"
+
+	resources := &drivers.Resources{
+		Ports: &structs.AllocatedPorts{
+			{
+				Label:  "http",
+				To:     8000,
+				HostIP: hostIP,
+				Value:  22222,
+			},
+			{
+				Label:  "dns",
+				To:     53,
+				HostIP: hostIP,
+				Value:  22223,
+			},
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: hostIP, HostPort: 22222, TaskIP: taskIP, TaskPort: 8000},
+			{Protocol: "udp", HostIP: hostIP, HostPort: 22223, TaskIP: taskIP, TaskPort: 53},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		n := NewNames()
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: n.Sets.DNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: n.Sets.Forward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http", "dns/udp"},
+			},
+		}
+
+		removal, err := vt.Configure(resources, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, removalName, removal.Name)
+		must.Eq(t, Config{Forwards: fwds}, removal.Data.(Config))
+	})
+
+	t.Run("unmapped", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: hostIP, HostPort: 22224, TaskIP: taskIP, TaskPort: 22224},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		n := NewNames()
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: n.Sets.DNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: n.Sets.Forward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"metrics"},
+			},
+		}
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "metrics", HostIP: hostIP, Value: 22224},
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, Config{Forwards: fwds}, removal.Data.(Config))
+	})
+
+	t.Run("mismatched family", func(t *testing.T) {
+		nft := mock_nftables.New(t)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		removal, err := vt.Configure(resources, cfg, "fd00::2")
+		must.NoError(t, err)
+		must.SliceEmpty(t, removal.Data.(Config).Forwards)
+	})
+
+	t.Run("flush error", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: hostIP, HostPort: 22222, TaskIP: taskIP, TaskPort: 8000},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{Err: mock.MockTestErr},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		_, err = vt.Configure(resources, cfg, taskIP)
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("missing resources", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		_, err := vt.Configure(nil, &virtnet.NetworkInterfaceBridgeConfig{}, taskIP)
+		must.ErrorContains(t, err, "resources not provided")
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		_, err := vt.Configure(resources, nil, taskIP)
+		must.ErrorContains(t, err, "bridge config not provided")
+	})
+
+	t.Run("loopback", func(t *testing.T) {
+		tmpl := filepath.Join(t.TempDir(), "%s_route_localnet")
+		must.NoError(t, os.WriteFile(fmt.Sprintf(tmpl, "testbr0"), []byte("1"), 0644))
+
+		fwds := Forwards{
+			{Protocol: "tcp", HostIP: "127.0.0.1", HostPort: 22222, TaskIP: taskIP, TaskPort: 8000},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t,
+			WithNFTables(nft),
+			WithRoutingLocalnetPathTemplate(tmpl),
+			WithRoutingInterfaceByIPGetter(func(string) (string, error) { return "testbr0", nil }),
+		)
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "http", To: 8000, HostIP: "127.0.0.1", Value: 22222},
+			},
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, Config{Forwards: fwds}, removal.Data.(Config))
+	})
+
+	t.Run("loopback not enabled", func(t *testing.T) {
+		tmpl := filepath.Join(t.TempDir(), "%s_route_localnet")
+
+		vt := TestNew(t,
+			WithNFTables(mock_nftables.New(t)),
+			WithRoutingLocalnetPathTemplate(tmpl),
+			WithRoutingInterfaceByIPGetter(func(string) (string, error) { return "testbr0", nil }),
+		)
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "http", To: 8000, HostIP: "127.0.0.1", Value: 22222},
+			},
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		_, err := vt.Configure(res, cfg, taskIP)
+		must.ErrorIs(t, err, errLoopbackNotEnabled)
+	})
+
+	t.Run("ipv6 loopback", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "http", To: 8000, HostIP: "::1", Value: 22222},
+			},
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		_, err := vt.Configure(res, cfg, "fd00::2")
+		must.ErrorIs(t, err, errLoopbackNotSupported)
+	})
+
+	t.Run("ingress and egress", func(t *testing.T) {
+		config := Config{
+			Forwards: Forwards{
+				{Protocol: "tcp", HostIP: hostIP, HostPort: 22222, TaskIP: taskIP, TaskPort: 8000,
+					SourceCIDRs: []string{"10.0.0.0/8"}},
+			},
+			Egress: &Egress{
+				TaskIP: taskIP,
+				Allow:  []EgressRule{{CIDR: "192.168.0.0/16", Protocol: "udp", Port: 53}},
+			},
+		}
+		elems, err := config.elements()
+		must.NoError(t, err)
+
+		n := NewNames()
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: n.Sets.DNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: n.Sets.Allow4, Elements: elems.allow4},
+			mock_nftables.SetAddElements{Set: n.Sets.Egress4, Elements: elems.egress4},
+			mock_nftables.SetAddElements{Set: n.Sets.Restricted4, Elements: elems.restricted4},
+			mock_nftables.SetAddElements{Set: n.Sets.Isolated4, Elements: elems.isolated4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+				Ingress: []*virtnet.NetworkInterfaceIngressConfig{
+					{Port: "http", CIDRs: []string{"10.0.0.0/8"}},
+				},
+				Egress: &virtnet.NetworkInterfaceEgressConfig{
+					Policy: virtnet.EgressPolicyAllowList,
+					Allow: []*virtnet.NetworkInterfaceEgressRule{
+						{CIDR: "192.168.0.0/16", Port: 53, Protocol: virtnet.PortProtocolUDP},
+					},
+				},
+			},
+		}
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "http", To: 8000, HostIP: hostIP, Value: 22222},
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, config, removal.Data.(Config))
+	})
+
+	t.Run("egress without ports", func(t *testing.T) {
+		config := Config{Forwards: Forwards{}, Egress: &Egress{TaskIP: taskIP}}
+		elems, err := config.elements()
+		must.NoError(t, err)
+
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: defaultSetNameIsolated4, Elements: elems.isolated4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Egress: &virtnet.NetworkInterfaceEgressConfig{Policy: virtnet.EgressPolicyDenyAll},
+			},
+		}
+
+		removal, err := vt.Configure(&drivers.Resources{}, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, config, removal.Data.(Config))
+	})
+
+	t.Run("egress address", func(t *testing.T) {
+		config := Config{
+			Forwards: Forwards{},
+			SNAT:     &SNAT{TaskIP: taskIP, EgressIP: "192.168.44.23", Interface: "eth1"},
+		}
+		elems, err := config.elements()
+		must.NoError(t, err)
+
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: defaultMapNameSNAT4, Elements: elems.snat4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t,
+			WithNFTables(nft),
+			WithInterfaceByIPGetter(func(net.IP) (string, error) { return "eth1", nil }),
+		)
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				EgressIP: "192.168.44.23",
+			},
+		}
+
+		removal, err := vt.Configure(&drivers.Resources{}, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, config, removal.Data.(Config))
+	})
+
+	t.Run("egress host network", func(t *testing.T) {
+		config := Config{
+			Forwards: Forwards{},
+			SNAT:     &SNAT{TaskIP: taskIP, EgressIP: hostIP, Interface: "eth1"},
+		}
+		elems, err := config.elements()
+		must.NoError(t, err)
+
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: defaultMapNameSNAT4, Elements: elems.snat4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t,
+			WithNFTables(nft),
+			WithInterfaceByIPGetter(func(net.IP) (string, error) { return "eth1", nil }),
+		)
+		res := &drivers.Resources{
+			NomadResources: &structs.AllocatedTaskResources{
+				Networks: structs.Networks{
+					{DynamicPorts: []structs.Port{{Label: "http", HostNetwork: "public"}}},
+				},
+			},
+			Ports: resources.Ports,
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				EgressIP: "public",
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, config, removal.Data.(Config))
+	})
+
+	t.Run("egress address mismatched family", func(t *testing.T) {
+		nft := mock_nftables.New(t)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				EgressIP: "192.168.44.23",
+			},
+		}
+
+		removal, err := vt.Configure(&drivers.Resources{}, cfg, "fd00::2")
+		must.NoError(t, err)
+		must.Nil(t, removal.Data.(Config).SNAT)
+	})
+
+	t.Run("port range", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30000, TaskIP: taskIP, TaskPort: 30000},
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30001, TaskIP: taskIP, TaskPort: 30001},
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30002, TaskIP: taskIP, TaskPort: 30002},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		n := NewNames()
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: n.Sets.DNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: n.Sets.Forward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "rtp0", Value: 30000, HostIP: hostIP},
+				{Label: "rtp1", Value: 30001, HostIP: hostIP},
+				{Label: "rtp2", Value: 30002, HostIP: hostIP},
+			},
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"rtp0..rtp2/udp"},
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, Config{Forwards: fwds}, removal.Data.(Config))
+	})
+
+	t.Run("port range ingress", func(t *testing.T) {
+		fwds := Forwards{
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30000, TaskIP: taskIP, TaskPort: 30000,
+				SourceCIDRs: []string{"10.0.0.0/8"}},
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30001, TaskIP: taskIP, TaskPort: 30001,
+				SourceCIDRs: []string{"10.0.0.0/8"}},
+			{Protocol: "udp", HostIP: hostIP, HostPort: 30002, TaskIP: taskIP, TaskPort: 30002,
+				SourceCIDRs: []string{"10.0.0.0/8"}},
+		}
+		elems, err := fwds.elements()
+		must.NoError(t, err)
+
+		n := NewNames()
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetAddElements{Set: n.Sets.DNAT4, Elements: elems.dnat4},
+			mock_nftables.SetAddElements{Set: n.Sets.Allow4, Elements: elems.allow4},
+			mock_nftables.SetAddElements{Set: n.Sets.Restricted4, Elements: elems.restricted4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "rtp0", Value: 30000, HostIP: hostIP},
+				{Label: "rtp1", Value: 30001, HostIP: hostIP},
+				{Label: "rtp2", Value: 30002, HostIP: hostIP},
+			},
+		}
+
+		// The ingress entry refers to a port within the range, and applies
+		// to every port of the range.
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"rtp0..rtp2/udp"},
+				Ingress: []*virtnet.NetworkInterfaceIngressConfig{
+					{Port: "rtp1", CIDRs: []string{"10.0.0.0/8"}},
+				},
+			},
+		}
+
+		removal, err := vt.Configure(res, cfg, taskIP)
+		must.NoError(t, err)
+		must.Eq(t, Config{Forwards: fwds}, removal.Data.(Config))
+	})
+
+	t.Run("invalid port range", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		res := &drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "rtp0", Value: 30000, HostIP: hostIP},
+				{Label: "rtp1", Value: 30001, To: 8000, HostIP: hostIP},
+				{Label: "rtp2", Value: 30002, HostIP: hostIP},
+			},
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"rtp0..rtp2"},
+			},
+		}
+
+		_, err := vt.Configure(res, cfg, taskIP)
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, "includes port 30001 which is mapped to a different port")
+	})
+
+	t.Run("host network unavailable", func(t *testing.T) {
+		vt := TestNew(t,
+			WithNFTables(mock_nftables.New(t)),
+			WithInterfaceByIPGetter(func(net.IP) (string, error) { return "", errors.New("not found") }),
+		)
+		res := &drivers.Resources{
+			NomadResources: &structs.AllocatedTaskResources{
+				Networks: structs.Networks{
+					{DynamicPorts: []structs.Port{{Label: "http", HostNetwork: "public"}}},
+				},
+			},
+			Ports: resources.Ports,
+		}
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http"},
+			},
+		}
+
+		_, err := vt.Configure(res, cfg, taskIP)
+		must.ErrorIs(t, err, filter.ErrHostNetworkUnavailable)
+		must.ErrorContains(t, err, `port "http" placed on host_network "public"`)
+	})
+
+	t.Run("invalid protocol", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		cfg := &virtnet.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: virtnet.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"http/icmp"},
+			},
+		}
+
+		_, err := vt.Configure(resources, cfg, taskIP)
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+	})
+}
+
+func Test_virtNFT_Teardown(t *testing.T) {
+	fwds := Forwards{
+		{Protocol: "tcp", HostIP: "192.168.44.22", HostPort: 22222, TaskIP: "10.0.22.33", TaskPort: 8000},
+	}
+	elems, err := fwds.elements()
+	must.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: fwds}))
+	})
+
+	t.Run("missing elements", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{Err: unix.ENOENT},
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: []nftables.SetElement{elems.dnat4[0]}},
+			mock_nftables.Flush{Err: unix.ENOENT},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: []nftables.SetElement{elems.forward4[0]}},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: fwds}))
+	})
+
+	t.Run("individual error", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{Err: mock.MockTestErr},
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: []nftables.SetElement{elems.dnat4[0]}},
+			mock_nftables.Flush{Err: mock.MockTestErr},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: []nftables.SetElement{elems.forward4[0]}},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		err := vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: fwds})
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(
+			mock_nftables.SetDeleteElements{Set: defaultMapNameDNAT4, Elements: elems.dnat4},
+			mock_nftables.SetDeleteElements{Set: defaultSetNameForward4, Elements: elems.forward4},
+			mock_nftables.Flush{},
+		)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		data := []any{
+			map[string]any{
+				"Protocol": "tcp",
+				"HostIP":   "192.168.44.22",
+				"HostPort": int64(22222),
+				"TaskIP":   "10.0.22.33",
+				"TaskPort": int64(8000),
+			},
+		}
+		must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: data}))
+	})
+
+	t.Run("nil removal", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		must.NoError(t, vt.Teardown(nil))
+	})
+
+	t.Run("other filter", func(t *testing.T) {
+		nft := mock_nftables.New(t)
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		must.NoError(t, vt.Teardown(&virtnet.FilterRemoval{Name: "iptables", Data: [][]string{{"nat"}}}))
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		vt := TestNew(t, WithNFTables(mock_nftables.New(t)))
+		err := vt.Teardown(&virtnet.FilterRemoval{Name: removalName, Data: "invalid"})
+		must.ErrorContains(t, err, "invalid teardown data")
+	})
+}
+
+func Test_virtNFT_setup(t *testing.T) {
+	n := NewNames()
+	expectations := []any{
+		mock_nftables.AddTable{Name: n.Table},
+		mock_nftables.AddSet{Name: n.Sets.DNAT4},
+		mock_nftables.AddSet{Name: n.Sets.DNAT6},
+		mock_nftables.AddSet{Name: n.Sets.SNAT4},
+		mock_nftables.AddSet{Name: n.Sets.SNAT6},
+		mock_nftables.AddSet{Name: n.Sets.Forward4},
+		mock_nftables.AddSet{Name: n.Sets.Forward6},
+		mock_nftables.AddSet{Name: n.Sets.Allow4},
+		mock_nftables.AddSet{Name: n.Sets.Allow6},
+		mock_nftables.AddSet{Name: n.Sets.Egress4},
+		mock_nftables.AddSet{Name: n.Sets.Egress6},
+		mock_nftables.AddSet{Name: n.Sets.Restricted4},
+		mock_nftables.AddSet{Name: n.Sets.Restricted6},
+		mock_nftables.AddSet{Name: n.Sets.Isolated4},
+		mock_nftables.AddSet{Name: n.Sets.Isolated6},
+		mock_nftables.AddChain{Name: n.Chains.Prerouting},
+		mock_nftables.AddChain{Name: n.Chains.Output},
+		mock_nftables.AddChain{Name: n.Chains.Postrouting},
+		mock_nftables.AddChain{Name: n.Chains.SNAT},
+		mock_nftables.AddChain{Name: n.Chains.Forward},
+		mock_nftables.FlushChain{Chain: n.Chains.Prerouting},
+		mock_nftables.FlushChain{Chain: n.Chains.Output},
+		mock_nftables.FlushChain{Chain: n.Chains.Postrouting},
+		mock_nftables.FlushChain{Chain: n.Chains.SNAT},
+		mock_nftables.FlushChain{Chain: n.Chains.Forward},
+		mock_nftables.AddRule{Chain: n.Chains.Prerouting},
+		mock_nftables.AddRule{Chain: n.Chains.Prerouting},
+		mock_nftables.AddRule{Chain: n.Chains.Output},
+		mock_nftables.AddRule{Chain: n.Chains.Output},
+		mock_nftables.AddRule{Chain: n.Chains.Postrouting},
+		mock_nftables.AddRule{Chain: n.Chains.SNAT},
+		mock_nftables.AddRule{Chain: n.Chains.SNAT},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Isolated4, n.Sets.Egress4}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Isolated6, n.Sets.Egress6}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Allow4}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Allow6}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Forward4}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Forward6}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Restricted4}},
+		mock_nftables.AddRule{Chain: n.Chains.Forward, Sets: []string{n.Sets.Restricted6}},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(expectations...).Expect(mock_nftables.Flush{})
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		must.NoError(t, vt.setup())
+	})
+
+	t.Run("already setup", func(t *testing.T) {
+		nft := mock_nftables.New(t).
+			Expect(expectations...).Expect(mock_nftables.Flush{}).
+			Expect(expectations...).Expect(mock_nftables.Flush{})
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+
+		// Run an initial setup to ensure configuration exists.
+		must.NoError(t, vt.setup())
+
+		// Run the setup again.
+		must.NoError(t, vt.setup())
+	})
+
+	t.Run("flush error", func(t *testing.T) {
+		nft := mock_nftables.New(t).Expect(expectations...).Expect(mock_nftables.Flush{Err: mock.MockTestErr})
+		defer nft.AssertExpectations()
+
+		vt := TestNew(t, WithNFTables(nft))
+		must.ErrorIs(t, vt.setup(), mock.MockTestErr)
+	})
+}
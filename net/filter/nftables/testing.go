@@ -0,0 +1,81 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"net"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/shoenig/test/must"
+)
+
+type testOption func(*virtNFT)
+
+// interfaceByIPGetter is the function signature used to identify the host's
+// interface for an IP address. This is primarily used for testing, where we
+// don't know the host, and we want to ensure stability and consistency when
+// this is called.
+type interfaceByIPGetter func(ip net.IP) (string, error)
+
+// routingInterfaceByIPGetter is the function signature used to identify
+// the host interface used for an IP address. This is primarily used for
+// testing, where we don't know the host, and we want to ensure stability and
+// consistency when this is called.
+type routingInterfaceByIPGetter func(ip string) (string, error)
+
+// WithNFTables sets a custom NFTables implementation.
+func WithNFTables(conn NFTables) testOption {
+	return func(n *virtNFT) {
+		n.conn = conn
+	}
+}
+
+// WithInterfaceByIPGetter sets a custom interfaceByIPGetter.
+func WithInterfaceByIPGetter(fn interfaceByIPGetter) testOption {
+	return func(n *virtNFT) {
+		n.interfaceByIPGetter = fn
+	}
+}
+
+// WithRoutingInterfaceByIPGetter sets a custom routingInterfaceByIPGetter.
+func WithRoutingInterfaceByIPGetter(fn routingInterfaceByIPGetter) testOption {
+	return func(n *virtNFT) {
+		n.routingInterfaceByIPGetter = fn
+	}
+}
+
+// WithRoutingLocalnetPathTemplate sets a custom routeLocalnetPathTemplate.
+func WithRoutingLocalnetPathTemplate(tmpl string) testOption {
+	return func(n *virtNFT) {
+		n.routeLocalnetPathTemplate = tmpl
+	}
+}
+
+// WithLogger sets a custom logger.
+func WithLogger(logger hclog.Logger) testOption {
+	return func(n *virtNFT) {
+		n.logger = logger
+	}
+}
+
+// TestNew creates a new virtNFT test instance. An NFTables implementation
+// must be provided using the WithNFTables option.
+func TestNew(t must.T, opts ...testOption) *virtNFT {
+	t.Helper()
+	nt := &virtNFT{
+		names:                      NewNames(),
+		interfaceByIPGetter:        filter.InterfaceByIP,
+		routingInterfaceByIPGetter: filter.RoutingInterfaceByIP,
+		logger:                     hclog.NewNullLogger(),
+	}
+
+	for _, optFn := range opts {
+		optFn(nt)
+	}
+
+	must.NotNil(t, nt.conn, must.Sprint("nftables implementation must be provided"))
+
+	return nt
+}
@@ -0,0 +1,21 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package leases
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type Leases interface {
+	// Watch returns a channel which receives a value when the DHCP leases
+	// served on the bridge change, for the life of the context. Changes
+	// occurring while a value is pending are coalesced. The channel is
+	// closed if the changes can no longer be watched.
+	Watch(ctx context.Context, bridge string) (<-chan struct{}, error)
+
+	// SetLogger sets a custom logger.
+	SetLogger(hclog.Logger)
+}
@@ -0,0 +1,220 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package leases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// defaultStatusDir is the directory where libvirt maintains the status
+	// file of the DHCP server of each network. The lease helper invoked by
+	// dnsmasq rewrites the file of the network bridge each time a lease is
+	// added, renewed or released.
+	defaultStatusDir = "/var/lib/libvirt/dnsmasq"
+
+	// statusFileSuffix is appended to the bridge name to form the name of the
+	// status file of the network.
+	statusFileSuffix = ".status"
+)
+
+var (
+	// loaderMu is used to synchronize singleton creation.
+	loaderMu sync.Mutex
+
+	// singleton is the single instance of the Leases interface.
+	singleton *watcher
+)
+
+// New returns the Leases interface, creating the singleton if it does not
+// already exist.
+func New() *watcher {
+	loaderMu.Lock()
+	defer loaderMu.Unlock()
+
+	if singleton != nil {
+		return singleton
+	}
+
+	singleton = newWatcher(defaultStatusDir)
+	return singleton
+}
+
+// newWatcher returns a watcher of the status files within the directory.
+func newWatcher(dir string) *watcher {
+	return &watcher{
+		dir:    dir,
+		logger: hclog.Default().Named("leases"),
+		subs:   map[*subscription]struct{}{},
+	}
+}
+
+// subscription is an active watch of the status file of a bridge.
+type subscription struct {
+	file string        // status file name to match.
+	ch   chan struct{} // channel to signal changes.
+}
+
+// notify signals a change without blocking, as a pending signal already
+// informs the subscriber of the change.
+func (s *subscription) notify() {
+	select {
+	case s.ch <- struct{}{}:
+	default:
+	}
+}
+
+// watcher implements the Leases interface. A single inotify watch of the
+// status directory is shared by all subscriptions. It is added with the first
+// subscription and removed once none remain.
+type watcher struct {
+	dir     string                     // directory of the status files.
+	fsw     *fsnotify.Watcher          // active directory watcher.
+	subs    map[*subscription]struct{} // active subscriptions.
+	logger  hclog.Logger
+	watchMu sync.Mutex
+}
+
+// Watch returns a channel which receives a value each time the status file
+// of the bridge is written.
+func (w *watcher) Watch(ctx context.Context, bridge string) (<-chan struct{}, error) {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	// The directory is only watched while there are active subscriptions. If
+	// it is not being watched, start watching it.
+	if w.fsw == nil {
+		fsw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lease watcher: %w", err)
+		}
+
+		if err := fsw.Add(w.dir); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("failed to watch lease directory %q: %w", w.dir, err)
+		}
+
+		w.fsw = fsw
+		go w.run(fsw)
+	}
+
+	sub := &subscription{
+		file: bridge + statusFileSuffix,
+		ch:   make(chan struct{}, 1),
+	}
+	w.subs[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		w.unsubscribe(sub)
+	}()
+
+	return sub.ch, nil
+}
+
+// SetLogger sets a custom logger.
+func (w *watcher) SetLogger(logger hclog.Logger) {
+	w.logger = logger
+}
+
+// unsubscribe removes the subscription and stops watching the directory if
+// there are no remaining subscriptions.
+func (w *watcher) unsubscribe(sub *subscription) {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	if _, ok := w.subs[sub]; !ok {
+		return
+	}
+	delete(w.subs, sub)
+
+	if len(w.subs) == 0 && w.fsw != nil {
+		w.fsw.Close()
+		w.fsw = nil
+	}
+}
+
+// run dispatches the events of the directory watcher to the subscriptions
+// until the watcher is closed.
+func (w *watcher) run(fsw *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return
+			}
+
+			// The status file is replaced by renaming a temporary file, which
+			// is reported as its creation.
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			w.dispatch(fsw, filepath.Base(event.Name))
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return
+			}
+
+			// Events may have been dropped when the queue overflows, so
+			// notify every subscription to have it check for changes.
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.logger.Debug("lease watcher event queue overflowed")
+				w.dispatch(fsw, "")
+				continue
+			}
+
+			w.logger.Warn("lease watcher failed", "directory", w.dir, "error", err)
+			w.fail(fsw)
+			return
+		}
+	}
+}
+
+// dispatch notifies the subscriptions watching the status file. An empty
+// file name notifies all subscriptions.
+func (w *watcher) dispatch(fsw *fsnotify.Watcher, file string) {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	// Ignore the events of a watcher which has since been replaced.
+	if w.fsw != fsw {
+		return
+	}
+
+	for sub := range w.subs {
+		if file == "" || sub.file == file {
+			sub.notify()
+		}
+	}
+}
+
+// fail closes the directory watcher and the channels of its subscriptions,
+// so the subscribers stop waiting on changes.
+func (w *watcher) fail(fsw *fsnotify.Watcher) {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	if w.fsw != fsw {
+		return
+	}
+
+	for sub := range w.subs {
+		close(sub.ch)
+		delete(w.subs, sub)
+	}
+
+	w.fsw.Close()
+	w.fsw = nil
+}
@@ -0,0 +1,171 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package leases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+)
+
+const testTimeout = 2 * time.Second
+
+// isWatching returns if the directory is currently watched.
+func (w *watcher) isWatching() bool {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	return w.fsw != nil
+}
+
+// mkTestWatcher returns a watcher of a temporary status directory.
+func mkTestWatcher(t *testing.T) (*watcher, string) {
+	dir := t.TempDir()
+	w := newWatcher(dir)
+	w.SetLogger(hclog.NewNullLogger())
+	return w, dir
+}
+
+// writeStatus replaces the status file of the bridge the same way the
+// libvirt lease helper does.
+func writeStatus(t *testing.T, dir, bridge string) {
+	path := filepath.Join(dir, bridge+statusFileSuffix)
+	must.NoError(t, os.WriteFile(path+".new", []byte("[]"), 0o644))
+	must.NoError(t, os.Rename(path+".new", path))
+}
+
+// mustNotify asserts the channel receives a change before the timeout.
+func mustNotify(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case _, ok := <-ch:
+		must.True(t, ok, must.Sprint("channel closed"))
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for lease change")
+	}
+}
+
+// mustNotNotify asserts the channel does not receive a change.
+func mustNotNotify(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatal("unexpected lease change")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcher_Watch(t *testing.T) {
+	t.Run("notifies changes", func(t *testing.T) {
+		w, dir := mkTestWatcher(t)
+
+		ch, err := w.Watch(t.Context(), "virbr0")
+		must.NoError(t, err)
+
+		writeStatus(t, dir, "virbr0")
+		mustNotify(t, ch)
+
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "virbr0.status"), []byte("[{}]"), 0o644))
+		mustNotify(t, ch)
+	})
+
+	t.Run("ignores other bridges", func(t *testing.T) {
+		w, dir := mkTestWatcher(t)
+
+		ch, err := w.Watch(t.Context(), "virbr0")
+		must.NoError(t, err)
+
+		writeStatus(t, dir, "virbr1")
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "virbr0.conf"), nil, 0o644))
+		mustNotNotify(t, ch)
+	})
+
+	t.Run("coalesces changes", func(t *testing.T) {
+		w, dir := mkTestWatcher(t)
+
+		ch, err := w.Watch(t.Context(), "virbr0")
+		must.NoError(t, err)
+
+		writeStatus(t, dir, "virbr0")
+		writeStatus(t, dir, "virbr0")
+		writeStatus(t, dir, "virbr0")
+		mustNotify(t, ch)
+
+		// Wait for the remaining events to be dispatched, which must not
+		// queue more than a single change.
+		time.Sleep(100 * time.Millisecond)
+		must.LessEq(t, 1, len(ch))
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		w, dir := mkTestWatcher(t)
+
+		ctx1, cancel1 := context.WithCancel(t.Context())
+		ch1, err := w.Watch(ctx1, "virbr0")
+		must.NoError(t, err)
+		fsw := w.fsw
+
+		ctx2, cancel2 := context.WithCancel(t.Context())
+		ch2, err := w.Watch(ctx2, "virbr0")
+		must.NoError(t, err)
+		must.Eq(t, fsw, w.fsw, must.Sprint("expected the directory watcher to be shared"))
+
+		writeStatus(t, dir, "virbr0")
+		mustNotify(t, ch1)
+		mustNotify(t, ch2)
+
+		// The directory is watched until the last subscription is done.
+		cancel1()
+		time.Sleep(50 * time.Millisecond)
+		must.True(t, w.isWatching())
+
+		writeStatus(t, dir, "virbr0")
+		mustNotify(t, ch2)
+
+		cancel2()
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool { return !w.isWatching() }),
+			wait.Timeout(testTimeout),
+			wait.Gap(10*time.Millisecond),
+		))
+	})
+
+	t.Run("restarts", func(t *testing.T) {
+		w, dir := mkTestWatcher(t)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		_, err := w.Watch(ctx, "virbr0")
+		must.NoError(t, err)
+		cancel()
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool { return !w.isWatching() }),
+			wait.Timeout(testTimeout),
+			wait.Gap(10*time.Millisecond),
+		))
+
+		ch, err := w.Watch(t.Context(), "virbr0")
+		must.NoError(t, err)
+
+		writeStatus(t, dir, "virbr0")
+		mustNotify(t, ch)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		w := newWatcher(filepath.Join(t.TempDir(), "missing"))
+
+		_, err := w.Watch(t.Context(), "virbr0")
+		must.ErrorContains(t, err, "failed to watch lease directory")
+		must.False(t, w.isWatching())
+	})
+}
@@ -0,0 +1,26 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package leases
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+)
+
+func New() *watcher {
+	return &watcher{}
+}
+
+type watcher struct{}
+
+func (w *watcher) Watch(context.Context, string) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("lease watching is %w on this platform", errs.ErrNotImplemented)
+}
+
+func (w *watcher) SetLogger(hclog.Logger) {}
@@ -0,0 +1,99 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package netns
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/netip"
+)
+
+const (
+	// hostDevicePrefix is the prefix of the name of the host end of the link
+	// created between the host and an allocation network namespace.
+	hostDevicePrefix = "nvt"
+
+	// namespaceDevice is the name of the namespace end of the link created
+	// between the host and an allocation network namespace.
+	namespaceDevice = "nvt0"
+)
+
+var (
+	// gatewayPrefix is the address assigned to the namespace end of the link.
+	// Virtual machines use it as their default gateway.
+	gatewayPrefix = netip.MustParsePrefix("169.254.1.1/30")
+
+	// guestPrefix is the address the virtual machine is expected to configure
+	// on the interface attached to the link.
+	guestPrefix = netip.MustParsePrefix("169.254.1.2/30")
+)
+
+// NetNS is the interface for managing allocation network namespaces and
+// attaching virtual machines to them.
+type NetNS interface {
+	// Create creates the network namespace for the allocation and returns
+	// its path. If the namespace already exists, its path is returned and
+	// created will be false.
+	Create(allocID string) (path string, created bool, err error)
+
+	// Destroy removes the network namespace at the path. Removing a network
+	// namespace which does not exist is not an error.
+	Destroy(path string) error
+
+	// Attach creates a link between the host and the network namespace in
+	// the request and configures the namespace to route traffic to and from
+	// the virtual machine.
+	Attach(*AttachRequest) (*Attachment, error)
+
+	// Detach removes the link created by Attach using the name of its host
+	// device. Detaching a link which does not exist is not an error.
+	Detach(hostDevice string) error
+}
+
+// AttachRequest is the request object used when attaching a virtual machine
+// to a network namespace.
+type AttachRequest struct {
+	// Name is the name of the virtual machine.
+	Name string
+
+	// Path is the path of the network namespace.
+	Path string
+
+	// Ports is the list of ports which are forwarded from the network
+	// namespace to the virtual machine.
+	Ports []Port
+}
+
+// Port is a port forwarded from the network namespace to the virtual machine.
+type Port struct {
+	// Port is the port number, which is the same within the network
+	// namespace and the virtual machine.
+	Port int
+
+	// Protocol is the transport protocol forwarded, such as "tcp" or "udp".
+	Protocol string
+}
+
+// Attachment describes the link created between the host and a network
+// namespace.
+type Attachment struct {
+	// HostDevice is the name of the host end of the link. The virtual
+	// machine network interface uses it as its lower device.
+	HostDevice string
+
+	// Address is the address, including prefix length, which the virtual
+	// machine must configure on its network interface.
+	Address netip.Prefix
+
+	// Gateway is the address the virtual machine must use as its default
+	// gateway.
+	Gateway netip.Addr
+}
+
+// HostDeviceName returns the name of the host end of the link for the named
+// virtual machine. The name is derived from a checksum of the virtual
+// machine name, as device names are limited to 15 characters.
+func HostDeviceName(name string) string {
+	return fmt.Sprintf("%s%08x", hostDevicePrefix, crc32.ChecksumIEEE([]byte(name)))
+}
@@ -0,0 +1,345 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad/client/lib/nsutil"
+	"github.com/jsimonetti/rtnetlink/v2"
+	"github.com/jsimonetti/rtnetlink/v2/driver"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// loopbackDevice is the name of the loopback device within a network
+	// namespace.
+	loopbackDevice = "lo"
+
+	// natTable is the iptables table the namespace rules are added to.
+	natTable = "nat"
+)
+
+// sysctls are the kernel settings applied within the network namespace. IP
+// forwarding allows traffic to be routed to and from the virtual machine,
+// and localnet routing allows traffic to be translated between the loopback
+// address of the namespace and the virtual machine.
+var sysctls = map[string]string{
+	"/proc/sys/net/ipv4/ip_forward":                                  "1",
+	"/proc/sys/net/ipv4/conf/" + namespaceDevice + "/route_localnet": "1",
+}
+
+// New returns the NetNS interface.
+func New(logger hclog.Logger) *manager {
+	return &manager{
+		logger: logger.Named("netns"),
+	}
+}
+
+type manager struct {
+	logger hclog.Logger
+}
+
+func (m *manager) Create(allocID string) (string, bool, error) {
+	path := filepath.Join(nsutil.NetNSRunDir, allocID)
+
+	// The network namespace will already exist when the driver is asked to
+	// create the network for an allocation which is being restored.
+	if ns, err := nsutil.GetNS(path); err == nil {
+		ns.Close()
+		return path, false, nil
+	}
+
+	ns, err := nsutil.NewNS(allocID)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	// The loopback device of a new network namespace is down, but it is
+	// required by any task listening on the loopback address.
+	if err := m.setLinkUp(ns, loopbackDevice); err != nil {
+		if unmountErr := nsutil.UnmountNS(ns.Path()); unmountErr != nil {
+			m.logger.Error("failed to remove network namespace", "path", ns.Path(), "error", unmountErr)
+		}
+		return "", false, err
+	}
+
+	return ns.Path(), true, nil
+}
+
+func (m *manager) Destroy(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		m.logger.Debug("network namespace not found", "path", path)
+		return nil
+	}
+
+	if err := nsutil.UnmountNS(path); err != nil {
+		return fmt.Errorf("failed to remove network namespace: %w", err)
+	}
+
+	return nil
+}
+
+func (m *manager) Attach(req *AttachRequest) (*Attachment, error) {
+	if req == nil {
+		return nil, errors.New("netns: no request provided")
+	}
+
+	ns, err := nsutil.GetNS(req.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	conn, err := rtnetlink.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to netlink: %w", err)
+	}
+	defer conn.Close()
+
+	hostDevice := HostDeviceName(req.Name)
+
+	// The link can be left behind if the driver fails while starting the
+	// virtual machine, so ensure it is removed before creating it.
+	if err := deleteLink(conn, hostDevice); err != nil {
+		return nil, err
+	}
+
+	err = conn.Link.New(&rtnetlink.LinkMessage{
+		Family: unix.AF_UNSPEC,
+		Flags:  unix.IFF_UP,
+		Change: unix.IFF_UP,
+		Attributes: &rtnetlink.LinkAttributes{
+			Name: hostDevice,
+			Info: &rtnetlink.LinkInfo{
+				Kind: "veth",
+				Data: &driver.Veth{
+					PeerInfo: &rtnetlink.LinkMessage{
+						Family: unix.AF_UNSPEC,
+						Attributes: &rtnetlink.LinkAttributes{
+							Name:  namespaceDevice,
+							NetNS: rtnetlink.NetNSForFD(uint32(ns.Fd())),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		// The host end was removed above, so the namespace end must exist
+		// which means another virtual machine has been attached.
+		if errors.Is(err, unix.EEXIST) {
+			return nil, fmt.Errorf("network namespace %q already has a virtual machine attached", req.Path)
+		}
+		return nil, fmt.Errorf("failed to create link: %w", err)
+	}
+
+	if err := m.configure(ns, req.Ports); err != nil {
+		if deleteErr := deleteLink(conn, hostDevice); deleteErr != nil {
+			m.logger.Error("failed to remove link", "device", hostDevice, "error", deleteErr)
+		}
+		return nil, err
+	}
+
+	return &Attachment{
+		HostDevice: hostDevice,
+		Address:    guestPrefix,
+		Gateway:    gatewayPrefix.Addr(),
+	}, nil
+}
+
+func (m *manager) Detach(hostDevice string) error {
+	conn, err := rtnetlink.Dial(nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to netlink: %w", err)
+	}
+	defer conn.Close()
+
+	// Removing the host end of the link also removes the namespace end.
+	return deleteLink(conn, hostDevice)
+}
+
+// configure configures the namespace end of the link and applies the kernel
+// settings and packet filter rules required for routing traffic to and from
+// the virtual machine.
+func (m *manager) configure(ns nsutil.NetNS, ports []Port) error {
+	conn, err := rtnetlink.Dial(&netlink.Config{NetNS: int(ns.Fd())})
+	if err != nil {
+		return fmt.Errorf("failed to connect to netlink in network namespace: %w", err)
+	}
+	defer conn.Close()
+
+	link, err := findLink(conn, namespaceDevice)
+	if err != nil {
+		return err
+	}
+	if link == nil {
+		return fmt.Errorf("failed to find device %q in network namespace", namespaceDevice)
+	}
+
+	err = conn.Address.New(&rtnetlink.AddressMessage{
+		Family:       unix.AF_INET,
+		PrefixLength: uint8(gatewayPrefix.Bits()),
+		Index:        link.Index,
+		Attributes: &rtnetlink.AddressAttributes{
+			Address: net.IP(gatewayPrefix.Addr().AsSlice()),
+			Local:   net.IP(gatewayPrefix.Addr().AsSlice()),
+		},
+	})
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add address to device %q: %w", namespaceDevice, err)
+	}
+
+	if err := conn.Link.Set(&rtnetlink.LinkMessage{
+		Family: unix.AF_UNSPEC,
+		Index:  link.Index,
+		Flags:  unix.IFF_UP,
+		Change: unix.IFF_UP,
+	}); err != nil {
+		return fmt.Errorf("failed to set device %q up: %w", namespaceDevice, err)
+	}
+
+	// The kernel settings and iptables rules apply to the network namespace
+	// of the calling thread, so they must be applied from within it.
+	return ns.Do(func(nsutil.NetNS) error {
+		for path, value := range sysctls {
+			if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+				return fmt.Errorf("failed to write %q: %w", path, err)
+			}
+		}
+
+		ipt, err := iptables.New()
+		if err != nil {
+			return fmt.Errorf("failed to create iptables client: %w", err)
+		}
+
+		var mErr *multierror.Error
+		for _, r := range namespaceRules(ports) {
+			if err := ipt.AppendUnique(natTable, r.chain, r.spec...); err != nil {
+				mErr = multierror.Append(mErr, fmt.Errorf("failed to add rule to %s chain: %w", r.chain, err))
+			}
+		}
+
+		return mErr.ErrorOrNil()
+	})
+}
+
+// setLinkUp sets the named device within the network namespace up.
+func (m *manager) setLinkUp(ns nsutil.NetNS, name string) error {
+	conn, err := rtnetlink.Dial(&netlink.Config{NetNS: int(ns.Fd())})
+	if err != nil {
+		return fmt.Errorf("failed to connect to netlink in network namespace: %w", err)
+	}
+	defer conn.Close()
+
+	link, err := findLink(conn, name)
+	if err != nil {
+		return err
+	}
+	if link == nil {
+		return fmt.Errorf("failed to find device %q in network namespace", name)
+	}
+
+	if err := conn.Link.Set(&rtnetlink.LinkMessage{
+		Family: unix.AF_UNSPEC,
+		Index:  link.Index,
+		Flags:  unix.IFF_UP,
+		Change: unix.IFF_UP,
+	}); err != nil {
+		return fmt.Errorf("failed to set device %q up: %w", name, err)
+	}
+
+	return nil
+}
+
+// rule is an iptables rule within the nat table.
+type rule struct {
+	chain string
+	spec  []string
+}
+
+// namespaceRules returns the iptables rules applied within the network
+// namespace. Traffic for the forwarded ports, whether it arrives from outside
+// the namespace or from a task using the loopback address, is sent to the
+// virtual machine. Traffic the virtual machine sends to its gateway is sent
+// to the loopback address, so it can reach tasks, such as sidecar proxies,
+// which listen there.
+func namespaceRules(ports []Port) []rule {
+	guest := guestPrefix.Addr().String()
+	gateway := gatewayPrefix.Addr().String()
+
+	rules := []rule{}
+	for _, port := range ports {
+		dport := strconv.Itoa(port.Port)
+		dest := net.JoinHostPort(guest, dport)
+
+		rules = append(rules,
+			rule{
+				chain: "PREROUTING",
+				spec:  []string{"!", "-i", namespaceDevice, "-p", port.Protocol, "--dport", dport, "-j", "DNAT", "--to-destination", dest},
+			},
+			rule{
+				chain: "OUTPUT",
+				spec:  []string{"-d", "127.0.0.1/32", "-p", port.Protocol, "--dport", dport, "-j", "DNAT", "--to-destination", dest},
+			},
+		)
+	}
+
+	return append(rules,
+		rule{
+			chain: "PREROUTING",
+			spec:  []string{"-i", namespaceDevice, "-d", gateway + "/32", "-p", "tcp", "-j", "DNAT", "--to-destination", "127.0.0.1"},
+		},
+		rule{
+			chain: "POSTROUTING",
+			spec:  []string{"-o", namespaceDevice, "-s", "127.0.0.0/8", "-j", "MASQUERADE"},
+		},
+		rule{
+			chain: "POSTROUTING",
+			spec:  []string{"-s", guest + "/32", "!", "-o", namespaceDevice, "-j", "MASQUERADE"},
+		},
+	)
+}
+
+// findLink returns the named link, or nil if it does not exist.
+func findLink(conn *rtnetlink.Conn, name string) (*rtnetlink.LinkMessage, error) {
+	links, err := conn.Link.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+
+	for _, link := range links {
+		if link.Attributes != nil && link.Attributes.Name == name {
+			return &link, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// deleteLink removes the named link if it exists.
+func deleteLink(conn *rtnetlink.Conn, name string) error {
+	link, err := findLink(conn, name)
+	if err != nil || link == nil {
+		return err
+	}
+
+	if err := conn.Link.Delete(link.Index); err != nil && !errors.Is(err, unix.ENODEV) {
+		return fmt.Errorf("failed to remove link %q: %w", name, err)
+	}
+
+	return nil
+}
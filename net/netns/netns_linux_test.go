@@ -0,0 +1,31 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package netns
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func Test_namespaceRules(t *testing.T) {
+	t.Run("no ports", func(t *testing.T) {
+		must.Eq(t, []rule{
+			{chain: "PREROUTING", spec: []string{"-i", "nvt0", "-d", "169.254.1.1/32", "-p", "tcp", "-j", "DNAT", "--to-destination", "127.0.0.1"}},
+			{chain: "POSTROUTING", spec: []string{"-o", "nvt0", "-s", "127.0.0.0/8", "-j", "MASQUERADE"}},
+			{chain: "POSTROUTING", spec: []string{"-s", "169.254.1.2/32", "!", "-o", "nvt0", "-j", "MASQUERADE"}},
+		}, namespaceRules(nil))
+	})
+
+	t.Run("ports", func(t *testing.T) {
+		rules := namespaceRules([]Port{{Port: 8080, Protocol: "tcp"}, {Port: 53, Protocol: "udp"}})
+		must.Len(t, 7, rules)
+		must.Eq(t, []rule{
+			{chain: "PREROUTING", spec: []string{"!", "-i", "nvt0", "-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "169.254.1.2:8080"}},
+			{chain: "OUTPUT", spec: []string{"-d", "127.0.0.1/32", "-p", "tcp", "--dport", "8080", "-j", "DNAT", "--to-destination", "169.254.1.2:8080"}},
+			{chain: "PREROUTING", spec: []string{"!", "-i", "nvt0", "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", "169.254.1.2:53"}},
+			{chain: "OUTPUT", spec: []string{"-d", "127.0.0.1/32", "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", "169.254.1.2:53"}},
+		}, rules[:4])
+	})
+}
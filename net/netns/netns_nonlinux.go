@@ -0,0 +1,35 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package netns
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+)
+
+func New(hclog.Logger) *manager {
+	return &manager{}
+}
+
+type manager struct{}
+
+func (m *manager) Create(string) (string, bool, error) {
+	return "", false, fmt.Errorf("network namespaces are %w on this platform", errs.ErrNotImplemented)
+}
+
+func (m *manager) Destroy(string) error {
+	return fmt.Errorf("network namespaces are %w on this platform", errs.ErrNotImplemented)
+}
+
+func (m *manager) Attach(*AttachRequest) (*Attachment, error) {
+	return nil, fmt.Errorf("network namespaces are %w on this platform", errs.ErrNotImplemented)
+}
+
+func (m *manager) Detach(string) error {
+	return nil
+}
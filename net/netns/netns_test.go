@@ -0,0 +1,23 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package netns
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestHostDeviceName(t *testing.T) {
+	t.Run("length", func(t *testing.T) {
+		name := HostDeviceName("a-very-long-virtual-machine-name-which-exceeds-the-device-limit")
+		must.StrHasPrefix(t, hostDevicePrefix, name)
+		must.Len(t, 11, []rune(name))
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		must.Eq(t, HostDeviceName("vm-1"), HostDeviceName("vm-1"))
+		must.NotEq(t, HostDeviceName("vm-1"), HostDeviceName("vm-2"))
+	})
+}
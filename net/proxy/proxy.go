@@ -0,0 +1,435 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+)
+
+const (
+	// defaultDialTimeout is the timeout used when connecting to the target
+	// of a TCP port forward.
+	defaultDialTimeout = 5 * time.Second
+
+	// defaultUDPIdleTimeout is the period after which a UDP session without
+	// any traffic is removed.
+	defaultUDPIdleTimeout = 60 * time.Second
+
+	// maxDatagramSize is the largest UDP payload relayed by the proxy.
+	maxDatagramSize = 65535
+)
+
+// errUnsupportedProtocol is returned when starting a port forward using a
+// protocol which cannot be relayed by the proxy.
+var errUnsupportedProtocol = errors.New("protocol not supported by the proxy")
+
+// Proxy is the interface for relaying port forwards from addresses of the
+// host to virtual machines within the driver process. It is used when the
+// packet filter cannot forward the traffic, such as from loopback addresses
+// when the host does not route localnet packets.
+type Proxy interface {
+	// Start starts relaying the port forward. Starting a port forward which
+	// is already relayed to the same target is a no-op.
+	Start(*virtnet.ProxyForward) error
+
+	// Stop stops relaying the port forward and closes its connections.
+	// Stopping a port forward which is not relayed is a no-op.
+	Stop(*virtnet.ProxyForward) error
+
+	// SetLogger sets a custom logger.
+	SetLogger(hclog.Logger)
+}
+
+// relay is a running port forward.
+type relay interface {
+	target() string
+	close() error
+}
+
+// New returns a new proxy with no running port forwards.
+func New() *proxy {
+	return &proxy{
+		logger:         hclog.Default().Named("proxy"),
+		relays:         make(map[string]relay),
+		dialTimeout:    defaultDialTimeout,
+		udpIdleTimeout: defaultUDPIdleTimeout,
+	}
+}
+
+type proxy struct {
+	logger hclog.Logger
+	relays map[string]relay
+	m      sync.Mutex
+
+	dialTimeout    time.Duration
+	udpIdleTimeout time.Duration
+}
+
+// SetLogger sets the logger used.
+func (p *proxy) SetLogger(logger hclog.Logger) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.logger = logger
+}
+
+// Start starts relaying the port forward, replacing any port forward on the
+// same address relayed to a different target.
+func (p *proxy) Start(forward *virtnet.ProxyForward) error {
+	if forward == nil {
+		return nil
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	key := relayKey(forward)
+	if r, ok := p.relays[key]; ok {
+		if r.target() == forward.Target {
+			return nil
+		}
+
+		if err := r.close(); err != nil {
+			p.logger.Warn("failed to stop replaced port forward", "listen", forward.Listen, "error", err)
+		}
+		delete(p.relays, key)
+	}
+
+	logger := p.logger.With("protocol", forward.Protocol, "listen", forward.Listen, "target", forward.Target)
+
+	var (
+		r   relay
+		err error
+	)
+	switch forward.Protocol {
+	case virtnet.PortProtocolTCP:
+		r, err = newTCPRelay(logger, forward.Listen, forward.Target, p.dialTimeout)
+	case virtnet.PortProtocolUDP:
+		r, err = newUDPRelay(logger, forward.Listen, forward.Target, p.udpIdleTimeout)
+	default:
+		return fmt.Errorf("failed to proxy %q: %w - %s", forward.Listen, errUnsupportedProtocol, forward.Protocol)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to proxy %q: %w", forward.Listen, err)
+	}
+
+	p.relays[key] = r
+	logger.Debug("started port forward proxy")
+
+	return nil
+}
+
+// Stop stops relaying the port forward.
+func (p *proxy) Stop(forward *virtnet.ProxyForward) error {
+	if forward == nil {
+		return nil
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	key := relayKey(forward)
+	r, ok := p.relays[key]
+	if !ok {
+		return nil
+	}
+	delete(p.relays, key)
+
+	if err := r.close(); err != nil {
+		return fmt.Errorf("failed to stop proxy %q: %w", forward.Listen, err)
+	}
+
+	p.logger.Debug("stopped port forward proxy", "protocol", forward.Protocol, "listen", forward.Listen)
+
+	return nil
+}
+
+// relayKey returns the key identifying the relay of a port forward. Only a
+// single relay can listen on an address for each protocol.
+func relayKey(forward *virtnet.ProxyForward) string {
+	return string(forward.Protocol) + "/" + forward.Listen
+}
+
+// tcpRelay relays the connections accepted on a host address to the target.
+type tcpRelay struct {
+	logger      hclog.Logger
+	listener    net.Listener
+	targetAddr  string
+	dialTimeout time.Duration
+
+	conns  map[net.Conn]struct{}
+	closed bool
+	m      sync.Mutex
+	wg     sync.WaitGroup
+}
+
+func newTCPRelay(logger hclog.Logger, listen, target string, dialTimeout time.Duration) (*tcpRelay, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &tcpRelay{
+		logger:      logger,
+		listener:    listener,
+		targetAddr:  target,
+		dialTimeout: dialTimeout,
+		conns:       make(map[net.Conn]struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.serve()
+
+	return r, nil
+}
+
+func (r *tcpRelay) target() string { return r.targetAddr }
+
+func (r *tcpRelay) serve() {
+	defer r.wg.Done()
+
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			r.logger.Warn("failed to accept connection", "error", err)
+			continue
+		}
+
+		if !r.track(conn) {
+			_ = conn.Close()
+			return
+		}
+
+		r.wg.Add(1)
+		go r.handle(conn)
+	}
+}
+
+// handle relays the connection to the target until either side closes it.
+func (r *tcpRelay) handle(conn net.Conn) {
+	defer r.wg.Done()
+	defer r.untrack(conn)
+
+	upstream, err := net.DialTimeout("tcp", r.targetAddr, r.dialTimeout)
+	if err != nil {
+		r.logger.Debug("failed to connect to target", "client", conn.RemoteAddr(), "error", err)
+		return
+	}
+
+	if !r.track(upstream) {
+		_ = upstream.Close()
+		return
+	}
+	defer r.untrack(upstream)
+
+	done := make(chan struct{}, 2)
+	go copyConn(upstream, conn, done)
+	go copyConn(conn, upstream, done)
+	<-done
+	<-done
+}
+
+// copyConn copies from the source to the destination, closing the write side
+// of the destination once the source has been closed.
+func copyConn(dst, src net.Conn, done chan<- struct{}) {
+	_, _ = io.Copy(dst, src)
+
+	if tcpConn, ok := dst.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+
+	done <- struct{}{}
+}
+
+// track records an open connection, so it can be closed when the relay is
+// stopped. False is returned when the relay has already been stopped.
+func (r *tcpRelay) track(conn net.Conn) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (r *tcpRelay) untrack(conn net.Conn) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_ = conn.Close()
+	delete(r.conns, conn)
+}
+
+func (r *tcpRelay) close() error {
+	r.m.Lock()
+	r.closed = true
+	err := r.listener.Close()
+	for conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.m.Unlock()
+
+	r.wg.Wait()
+
+	return err
+}
+
+// udpRelay relays the datagrams received on a host address to the target,
+// using a session for each client so replies can be returned.
+type udpRelay struct {
+	logger      hclog.Logger
+	conn        *net.UDPConn
+	targetAddr  *net.UDPAddr
+	targetStr   string
+	idleTimeout time.Duration
+
+	sessions map[string]*net.UDPConn
+	closed   bool
+	m        sync.Mutex
+	wg       sync.WaitGroup
+}
+
+func newUDPRelay(logger hclog.Logger, listen, target string, idleTimeout time.Duration) (*udpRelay, error) {
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+
+	listenAddr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &udpRelay{
+		logger:      logger,
+		conn:        conn,
+		targetAddr:  targetAddr,
+		targetStr:   target,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*net.UDPConn),
+	}
+
+	r.wg.Add(1)
+	go r.serve()
+
+	return r, nil
+}
+
+func (r *udpRelay) target() string { return r.targetStr }
+
+func (r *udpRelay) serve() {
+	defer r.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			r.logger.Warn("failed to read datagram", "error", err)
+			continue
+		}
+
+		upstream, err := r.session(client)
+		if err != nil {
+			r.logger.Debug("failed to connect to target", "client", client, "error", err)
+			continue
+		}
+
+		_ = upstream.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		if _, err := upstream.Write(buf[:n]); err != nil {
+			r.logger.Debug("failed to relay datagram", "client", client, "error", err)
+		}
+	}
+}
+
+// session returns the connection to the target used for the client, creating
+// it when the client has no session.
+func (r *udpRelay) session(client *net.UDPAddr) (*net.UDPConn, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return nil, net.ErrClosed
+	}
+
+	if upstream, ok := r.sessions[client.String()]; ok {
+		return upstream, nil
+	}
+
+	upstream, err := net.DialUDP("udp", nil, r.targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	r.sessions[client.String()] = upstream
+
+	r.wg.Add(1)
+	go r.reply(client, upstream)
+
+	return upstream, nil
+}
+
+// reply relays the datagrams received from the target to the client, until
+// the session has been idle for the timeout period.
+func (r *udpRelay) reply(client *net.UDPAddr, upstream *net.UDPConn) {
+	defer r.wg.Done()
+	defer func() {
+		r.m.Lock()
+		defer r.m.Unlock()
+
+		_ = upstream.Close()
+		delete(r.sessions, client.String())
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := upstream.Read(buf)
+		if err != nil {
+			return
+		}
+
+		_ = upstream.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		if _, err := r.conn.WriteToUDP(buf[:n], client); err != nil {
+			r.logger.Debug("failed to relay reply", "client", client, "error", err)
+		}
+	}
+}
+
+func (r *udpRelay) close() error {
+	r.m.Lock()
+	r.closed = true
+	err := r.conn.Close()
+	for _, upstream := range r.sessions {
+		_ = upstream.Close()
+	}
+	r.m.Unlock()
+
+	r.wg.Wait()
+
+	return err
+}
@@ -0,0 +1,207 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+)
+
+// testProxy returns a proxy using a null logger.
+func testProxy(t *testing.T) *proxy {
+	t.Helper()
+
+	p := New()
+	p.SetLogger(hclog.NewNullLogger())
+	return p
+}
+
+// freeAddr returns a loopback address with a port which is not in use for
+// the passed network.
+func freeAddr(t *testing.T, network string) string {
+	t.Helper()
+
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		must.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	default:
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		must.NoError(t, err)
+		defer listener.Close()
+		return listener.Addr().String()
+	}
+}
+
+// tcpEchoServer starts a TCP server which echoes each line it receives.
+func tcpEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if _, err := conn.Write(append(scanner.Bytes(), '\n')); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// udpEchoServer starts a UDP server which echoes each datagram it receives.
+func udpEchoServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestProxy_tcp(t *testing.T) {
+	p := testProxy(t)
+	forward := &virtnet.ProxyForward{
+		Protocol: virtnet.PortProtocolTCP,
+		Listen:   freeAddr(t, "tcp"),
+		Target:   tcpEchoServer(t),
+	}
+
+	must.NoError(t, p.Start(forward))
+
+	// Starting the same port forward again is a no-op.
+	must.NoError(t, p.Start(forward))
+	must.MapLen(t, 1, p.relays)
+
+	conn, err := net.DialTimeout("tcp", forward.Listen, time.Second)
+	must.NoError(t, err)
+	defer conn.Close()
+	must.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte("hello\n"))
+	must.NoError(t, err)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	must.NoError(t, err)
+	must.Eq(t, "hello\n", line)
+
+	// Stopping the port forward closes the established connection and the
+	// listener.
+	must.NoError(t, p.Stop(forward))
+	must.MapEmpty(t, p.relays)
+
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	must.Error(t, err)
+
+	_, err = net.DialTimeout("tcp", forward.Listen, time.Second)
+	must.Error(t, err)
+
+	// Stopping a port forward which is not relayed is a no-op.
+	must.NoError(t, p.Stop(forward))
+}
+
+func TestProxy_udp(t *testing.T) {
+	p := testProxy(t)
+	forward := &virtnet.ProxyForward{
+		Protocol: virtnet.PortProtocolUDP,
+		Listen:   freeAddr(t, "udp"),
+		Target:   udpEchoServer(t),
+	}
+
+	must.NoError(t, p.Start(forward))
+	defer p.Stop(forward)
+
+	conn, err := net.Dial("udp", forward.Listen)
+	must.NoError(t, err)
+	defer conn.Close()
+	must.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	for _, msg := range []string{"ping", "pong"} {
+		_, err = conn.Write([]byte(msg))
+		must.NoError(t, err)
+
+		buf := make([]byte, 16)
+		n, err := conn.Read(buf)
+		must.NoError(t, err)
+		must.Eq(t, msg, string(buf[:n]))
+	}
+}
+
+func TestProxy_Start(t *testing.T) {
+	t.Run("replaced target", func(t *testing.T) {
+		p := testProxy(t)
+		forward := &virtnet.ProxyForward{
+			Protocol: virtnet.PortProtocolTCP,
+			Listen:   freeAddr(t, "tcp"),
+			Target:   "127.0.0.1:1",
+		}
+		must.NoError(t, p.Start(forward))
+
+		replaced := *forward
+		replaced.Target = tcpEchoServer(t)
+		must.NoError(t, p.Start(&replaced))
+		defer p.Stop(&replaced)
+
+		must.MapLen(t, 1, p.relays)
+		must.Eq(t, replaced.Target, p.relays[relayKey(forward)].target())
+	})
+
+	t.Run("unsupported protocol", func(t *testing.T) {
+		err := testProxy(t).Start(&virtnet.ProxyForward{
+			Protocol: virtnet.PortProtocolSCTP,
+			Listen:   "127.0.0.1:2222",
+			Target:   "192.168.122.10:22",
+		})
+		must.ErrorIs(t, err, errUnsupportedProtocol)
+	})
+
+	t.Run("address in use", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		must.NoError(t, err)
+		defer listener.Close()
+
+		p := testProxy(t)
+		must.Error(t, p.Start(&virtnet.ProxyForward{
+			Protocol: virtnet.PortProtocolTCP,
+			Listen:   listener.Addr().String(),
+			Target:   "192.168.122.10:22",
+		}))
+		must.MapEmpty(t, p.relays)
+	})
+}
@@ -12,22 +12,27 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/nomad-driver-virt/cloudinit"
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	vm "github.com/hashicorp/nomad-driver-virt/internal/shared"
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
 	"github.com/hashicorp/nomad-driver-virt/providers"
 	"github.com/hashicorp/nomad-driver-virt/storage"
+	"github.com/hashicorp/nomad-driver-virt/storage/image_cache"
 	"github.com/hashicorp/nomad-driver-virt/virt"
 	"github.com/hashicorp/nomad-driver-virt/virt/disks"
 	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/client/lib/idset"
 
 	"github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/nomad/client/lib/numalib/hw"
 	"github.com/hashicorp/nomad/client/taskenv"
 	"github.com/hashicorp/nomad/drivers/shared/eventer"
+	nomadstructs "github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/base"
 	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/drivers/fsisolation"
@@ -46,6 +51,18 @@ const (
 	// fingerprint responses
 	fingerprintPeriod = 30 * time.Second
 
+	// filterReconcileInterval is the interval at which the packet filter
+	// configuration of the running tasks is checked and repaired
+	filterReconcileInterval = time.Minute
+
+	// defaultFilterPruneDelay is the default duration without any task being
+	// recovered after which stray packet filter configuration is removed
+	defaultFilterPruneDelay = 5 * time.Minute
+
+	// imageFetchTimeout is the maximum time to download the source images of
+	// a task, including the images pulled from OCI registries
+	imageFetchTimeout = 30 * time.Minute
+
 	// taskHandleVersion is the version of task handle which this plugin sets
 	// and understands how to decode
 	// this is used to allow modification and migration of the task schema
@@ -79,15 +96,21 @@ var (
 		DisableLogCollection: true,
 		FSIsolation:          fsisolation.Image,
 
-		// NetIsolationModes details that this driver only supports the network
-		// isolation of host.
+		// NetIsolationModes details that this driver supports the network
+		// isolation of host and group. In group mode, the VM is attached to
+		// the network namespace of the allocation, which allows the use of
+		// bridge and CNI networking along with Consul Connect sidecars.
 		NetIsolationModes: []drivers.NetIsolationMode{
 			drivers.NetIsolationModeHost,
+			drivers.NetIsolationModeGroup,
 		},
 
-		// MustInitiateNetwork is set to false, indicating the driver does not
-		// implement and thus satisfy the Nomad drivers.DriverNetworkManager
-		// interface.
+		// MustInitiateNetwork is set to false even though the driver
+		// implements the Nomad drivers.DriverNetworkManager interface. Nomad
+		// only allows a single driver within a task group to initiate the
+		// network, and the Docker driver, which runs Connect sidecars, must
+		// initiate it. When no other driver initiates the network, Nomad
+		// creates the network namespace itself.
 		MustInitiateNetwork: false,
 
 		// MountConfigs is currently not supported, although the plumbing is
@@ -113,9 +136,23 @@ type TaskState struct {
 	TaskConfig *drivers.TaskConfig
 	StartedAt  time.Time
 
-	// NetTeardown is the specification used to delete all the network
-	// configuration associated to a VM.
+	// NetTeardowns are the specifications used to delete all the network
+	// configuration associated to a VM. One specification exists for each
+	// network interface configured by the network sub-system.
+	NetTeardowns []*net.TeardownSpec
+
+	// NetTeardown is the specification used by tasks started with a driver
+	// which only supported a single network interface. It is only read when
+	// recovering a task.
 	NetTeardown *net.TeardownSpec
+
+	// DriverNetwork is the network returned to Nomad when the task was
+	// started, which is reported again once the task has been recovered.
+	DriverNetwork *drivers.DriverNetwork
+
+	// NetAddresses are the addresses of each VM network interface, which are
+	// exposed as driver attributes.
+	NetAddresses []*net.InterfaceAddresses
 }
 
 type VirtDriverPlugin struct {
@@ -128,7 +165,27 @@ type VirtDriverPlugin struct {
 	logger         hclog.Logger
 	dataDir        string
 	ci             cloudinit.CloudInit
+	images         disks.ImageFetcher
+	netns          netns.NetNS
 	signalShutdown context.CancelFunc
+
+	// filterMu is read locked while tasks configure or remove their packet
+	// filter configuration, and write locked while reconciling it, so the
+	// configuration of a task is not pruned while it is being changed.
+	filterMu sync.RWMutex
+
+	// reconcileOnce ensures a single filter reconcile loop is started.
+	reconcileOnce sync.Once
+
+	// recoveries counts the tasks recovered since the filter reconcile loop
+	// last checked, and recoveryIdleSince is when the loop started or last
+	// found recovered tasks. The loop sets filterPrune once no task has been
+	// recovered for filterPruneDelay, so the configuration of tasks yet to be
+	// recovered is not pruned.
+	recoveries        atomic.Int64
+	recoveryIdleSince time.Time
+	filterPruneDelay  time.Duration
+	filterPrune       bool
 }
 
 // NewPlugin returns a new driver plugin
@@ -152,6 +209,7 @@ func NewPlugin(ctx context.Context, logger hclog.Logger) drivers.DriverPlugin {
 		eventer:        eventer.NewEventer(ctx, logger),
 		config:         &virt.Config{},
 		tasks:          newTaskStore(),
+		netns:          netns.New(logger),
 		logger:         logger,
 	}
 }
@@ -185,11 +243,19 @@ func (d *VirtDriverPlugin) SetConfig(cfg *base.Config) error {
 	// Save the configuration to the plugin
 	d.config = &config
 
-	// Validate the configuration
+	// Set the default values and validate the configuration
+	if err := d.config.SetDefaults(); err != nil {
+		return err
+	}
 	if err := d.config.Validate(); err != nil {
 		return err
 	}
 
+	d.filterPruneDelay = defaultFilterPruneDelay
+	if delay, err := time.ParseDuration(d.config.FilterPruneDelay); err == nil {
+		d.filterPruneDelay = delay
+	}
+
 	// Save the Nomad agent configuration
 	if cfg.AgentConfig != nil {
 		d.nomadConfig = cfg.AgentConfig.Driver
@@ -206,6 +272,19 @@ func (d *VirtDriverPlugin) SetConfig(cfg *base.Config) error {
 		}
 	}
 
+	if d.images == nil {
+		images := image_cache.New(d.config.ImageCacheDir, d.logger)
+		images.SetRegistryAuthFile(d.config.RegistryAuthFile)
+		d.images = images
+	}
+
+	// Repair the packet filter configuration, which may have been modified
+	// outside of the driver, and keep repairing it periodically. The repair
+	// queries the host, so it is performed in the background.
+	d.reconcileOnce.Do(func() {
+		go d.handleFilterReconcile()
+	})
+
 	return nil
 }
 
@@ -355,15 +434,20 @@ func (d *VirtDriverPlugin) DestroyTask(taskID string, force bool) error {
 		return fmt.Errorf("virt: unable to destroy task %s: %w", taskID, err)
 	}
 
+	// Prevent the packet filter from being reconciled until the task has
+	// been removed, so its configuration is not restored once removed.
+	d.filterMu.RLock()
+	defer d.filterMu.RUnlock()
+
 	// Build our network request to send now that the VM has been destroyed.
 	netTeardownReq := net.VMTerminatedTeardownRequest{
-		TeardownSpec: handle.netTeardown,
+		TeardownSpecs: handle.netTeardowns,
 	}
 	if _, err := network.VMTerminatedTeardown(&netTeardownReq); err != nil {
 		return fmt.Errorf("virt: failed to destroy task network: %w", err)
 	}
 
-	d.tasks.Delete(vmname)
+	d.tasks.Delete(taskID)
 
 	return nil
 }
@@ -446,6 +530,30 @@ func vmNameFromTaskID(taskID string) string {
 	return strings.Join(ids[1:], "-")
 }
 
+// allocatedMBits returns the network bandwidth in megabits per second
+// allocated to the task by Nomad.
+func allocatedMBits(res *drivers.Resources) int {
+	if res == nil || res.NomadResources == nil {
+		return 0
+	}
+
+	var mbits int
+	for _, network := range res.NomadResources.Networks {
+		mbits += network.MBits
+	}
+
+	return mbits
+}
+
+// allocatedPorts returns the ports reserved for the task by Nomad.
+func allocatedPorts(res *drivers.Resources) nomadstructs.AllocatedPorts {
+	if res == nil || res.Ports == nil {
+		return nil
+	}
+
+	return *res.Ports
+}
+
 // createAllocFileMounts creates the mount configurations for the
 // alloc related directories on the host to make available within
 // the guest machine.
@@ -491,8 +599,11 @@ func createEnvsFile(envs map[string]string) vm.File {
 	}
 }
 
-func buildHostname(taskName string) string {
-	return fmt.Sprintf("nomad-%s", taskName)
+// buildHostname returns the default hostname of the VM. The VM name includes
+// the task invocation ID, so the hostname is unique to the VM and is not
+// shared between allocations of the same task.
+func buildHostname(vmName string) string {
+	return fmt.Sprintf("nomad-%s", vmName)
 }
 
 // StartTask returns a task handle and a driver network if necessary.
@@ -561,6 +672,10 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 		Files:             []vm.File{createEnvsFile(cfg.Env)},
 		NetworkInterfaces: driverConfig.NetworkInterfacesConfig,
 		Timezone:          driverConfig.Timezone,
+		DNS:               cfg.DNS,
+		AllocID:           cfg.AllocID,
+		TaskName:          cfg.Name,
+		MBits:             allocatedMBits(cfg.Resources),
 	}
 
 	// Run validation
@@ -568,6 +683,12 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 		return nil, nil, fmt.Errorf("virt: invalid configuration %s: %w", cfg.AllocID, err)
 	}
 
+	// The ports mapped by port ranges are only known from the ports reserved
+	// by Nomad, so overlapping port mappings are validated separately.
+	if err := dc.NetworkInterfaces.ValidatePorts(allocatedPorts(cfg.Resources)); err != nil {
+		return nil, nil, fmt.Errorf("virt: invalid configuration %s: %w", cfg.AllocID, err)
+	}
+
 	// Setup the disks.
 	vdisks := driverConfig.Disks
 
@@ -608,9 +729,153 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 	dc.Mounts = mounts
 	dc.BOOTCMDs = bootCMDs
 
+	networking, err := virtualizer.Networking()
+	if err != nil {
+		return nil, nil, fmt.Errorf("virt: failed to start task %s: %w", cfg.AllocID, err)
+	}
+
+	// When the task group uses group network isolation, attach the VM to the
+	// network namespace of the allocation. This is performed before the
+	// cloud-init configuration is generated, as the interface is statically
+	// addressed.
+	var isolationTeardown *net.TeardownSpec
+	if cfg.NetworkIsolation != nil && cfg.NetworkIsolation.Mode == drivers.NetIsolationModeGroup {
+		isolationResp, isolationErr := networking.VMIsolationBuild(&net.VMIsolationBuildRequest{
+			VMName:    taskName,
+			Isolation: cfg.NetworkIsolation,
+			Resources: cfg.Resources,
+		})
+		if isolationErr != nil {
+			return nil, nil, fmt.Errorf("virt: failed to attach task network %s: %w", cfg.AllocID, isolationErr)
+		}
+
+		isolationTeardown = isolationResp.TeardownSpec
+		// If the task fails to start, remove the attachment.
+		defer func() {
+			if err != nil {
+				if _, teardownErr := networking.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+					TeardownSpecs: []*net.TeardownSpec{isolationTeardown},
+				}); teardownErr != nil {
+					d.logger.Error("virt: failed to teardown task network, manual cleanup needed",
+						"task_name", taskName, "error", teardownErr)
+				}
+			}
+		}()
+
+		dc.NetworkInterfaces = append(net.NetworkInterfacesConfig{isolationResp.Interface}, dc.NetworkInterfaces...)
+	}
+
+	// Assign stable hardware addresses to the interfaces, so the VM keeps
+	// the same addresses, and therefore the same DHCP leases, when the task
+	// is restarted. The cloud-init network configuration and driver IPAM both
+	// match interfaces using their hardware address.
+	for i, iface := range dc.NetworkInterfaces {
+		iface.MAC = dc.InterfaceHwaddr(i)
+	}
+
+	// Resolve the port forwards of the user-mode interfaces from the ports
+	// reserved by Nomad, as they are part of the VM definition.
+	for _, iface := range dc.NetworkInterfaces {
+		if iface.User != nil {
+			iface.User.ResolveForwards(cfg.Resources)
+		}
+	}
+
+	// When any interface attaches to a named network, resolve the network as
+	// it may be created for the task by the network sub-system. This is
+	// performed before the addresses are assigned, as driver IPAM reserves
+	// the addresses within the resolved network. When the guest network
+	// configuration is generated, the networks providing IPv6 are also
+	// resolved, so DHCPv6 is only enabled where it is available.
+	var networksTeardowns []*net.TeardownSpec
+	resolveIPv6 := dc.RequiresNetworkConfig()
+	if dc.NetworkInterfaces.Networks() || resolveIPv6 {
+		networksResp, networksErr := networking.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName:      taskName,
+			Namespace:   cfg.Namespace,
+			JobID:       cfg.JobID,
+			NetConfig:   dc.NetworkInterfaces,
+			ResolveIPv6: resolveIPv6,
+		})
+		if networksErr != nil {
+			return nil, nil, fmt.Errorf("virt: failed to build task networks %s: %w", cfg.AllocID, networksErr)
+		}
+
+		networksTeardowns = networksResp.TeardownSpecs
+		// If the task fails to start, release the networks.
+		defer func() {
+			if err != nil {
+				if _, teardownErr := networking.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+					TeardownSpecs: networksTeardowns,
+				}); teardownErr != nil {
+					d.logger.Error("virt: failed to teardown task networks, manual cleanup needed",
+						"task_name", taskName, "error", teardownErr)
+				}
+			}
+		}()
+
+		for i, name := range networksResp.Networks {
+			if name == "" || i >= len(dc.NetworkInterfaces) || dc.NetworkInterfaces[i].Network == nil {
+				continue
+			}
+			dc.NetworkInterfaces[i].Network.Name = name
+		}
+
+		for i, ipv6 := range networksResp.IPv6 {
+			if i < len(dc.NetworkInterfaces) {
+				dc.NetworkInterfaces[i].IPv6 = ipv6
+			}
+		}
+	}
+
+	// When any interface uses driver IPAM, assign and reserve its address
+	// before the VM is created so no discovery is required once started.
+	var addressingTeardowns []*net.TeardownSpec
+	if dc.NetworkInterfaces.IPAM() {
+		addressingResp, addressingErr := networking.VMAddressingBuild(&net.VMAddressingBuildRequest{
+			VMName:         taskName,
+			Hostname:       hostname,
+			UniqueHostname: driverConfig.Hostname == "",
+			NetConfig:      dc.NetworkInterfaces,
+		})
+		if addressingErr != nil {
+			return nil, nil, fmt.Errorf("virt: failed to assign task addresses %s: %w", cfg.AllocID, addressingErr)
+		}
+
+		addressingTeardowns = addressingResp.TeardownSpecs
+		// If the task fails to start, remove the reservations.
+		defer func() {
+			if err != nil {
+				if _, teardownErr := networking.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+					TeardownSpecs: addressingTeardowns,
+				}); teardownErr != nil {
+					d.logger.Error("virt: failed to teardown task addresses, manual cleanup needed",
+						"task_name", taskName, "error", teardownErr)
+				}
+			}
+		}()
+
+		for i, assignment := range addressingResp.Assignments {
+			if assignment == nil || i >= len(dc.NetworkInterfaces) {
+				continue
+			}
+			dc.NetworkInterfaces[i].MAC = assignment.MAC
+			dc.NetworkInterfaces[i].AssignedAddresses = assignment.Addresses
+		}
+	}
+
 	// Fix up the image paths
 	vdisks.ResolveImages(imagePaths)
 
+	// Download any source images defined by a URL or OCI reference into the
+	// image cache.
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, imageFetchTimeout)
+	err = vdisks.FetchImages(fetchCtx, d.images)
+	fetchCancel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("virt: failed to fetch disk images %s: %w", cfg.AllocID, err)
+	}
+
 	// If cloudinit configuration is available, add it
 	if virtualizer.UseCloudInit() && dc.CloudInitConfig() != nil {
 		isoPath := filepath.Join(cfg.AllocDir, "cloudinit.iso")
@@ -631,7 +896,10 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 	}
 
 	// Validate the disks
-	if err := vdisks.Validate(virtualizer.Storage(), disks.ValidationOptions{AllowedPaths: allowedPaths}); err != nil {
+	if err := vdisks.Validate(virtualizer.Storage(), disks.ValidationOptions{
+		AllowedPaths:  allowedPaths,
+		ImageCacheDir: d.config.ImageCacheDir,
+	}); err != nil {
 		return nil, nil, fmt.Errorf("virt: invalid disks configuration %s: %w", cfg.AllocID, err)
 	}
 
@@ -649,11 +917,6 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 		}
 	}()
 
-	networking, err := virtualizer.Networking()
-	if err != nil {
-		return nil, nil, fmt.Errorf("virt: failed to start task %s: %w", cfg.AllocID, err)
-	}
-
 	if err := virtualizer.CreateVM(dc); err != nil {
 		return nil, nil, fmt.Errorf("virt: failed to start task %s: %w", cfg.AllocID, err)
 	}
@@ -667,23 +930,26 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 		hwaddrs[i] = iface.MAC
 	}
 
-	// Macvtap interfaces own their own IP on the physical network and are
-	// invisible to Nomad's port mapping machinery. Only bridge interfaces
-	// participate in host-side port allocation, so we filter the config down
-	// to those before handing it to the networking layer.
-	bridgeOnlyConfig := driverConfig.NetworkInterfacesConfig.ConfigurableOnly()
-
 	// Build our network request to send now that the VM has been started. The
-	// response will contain our teardown spec, which gets stored in the task
-	// handle, so we can easily perform deletions.
+	// response will contain our teardown specs, which get stored in the task
+	// handle, so we can easily perform deletions. The interfaces are returned
+	// in the order they were defined, so the hardware addresses line up with
+	// the network interface configuration.
 	netBuildReq := net.VMStartedBuildRequest{
 		VMName:    taskName,
 		Hostname:  hostname,
-		NetConfig: bridgeOnlyConfig,
+		NetConfig: dc.NetworkInterfaces,
 		Resources: cfg.Resources,
 		Hwaddrs:   hwaddrs,
+
+		UniqueHostname: driverConfig.Hostname == "",
 	}
 
+	// Prevent the packet filter from being reconciled until the task handle
+	// holds the teardown specs, so the configuration is not pruned.
+	d.filterMu.RLock()
+	defer d.filterMu.RUnlock()
+
 	// Build out the network now that the VM has been started.
 	//
 	// In the event of an error, we need to try and destroy the already running
@@ -708,14 +974,16 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 	// The rest of the startup process is performed in a goroutine which
 	// allows it to be stopped/destroyed while being started.
 	h := &taskHandle{
-		taskConfig: cfg,
-		procState:  drivers.TaskStateRunning,
-		startedAt:  time.Now().Round(time.Millisecond),
-		logger:     d.logger.Named("handle").With("alloc-id", cfg.AllocID),
-		taskGetter: d.providers,
-		name:       taskName,
-		ctx:        ctx,
-		cancelFn:   cancel,
+		taskConfig:    cfg,
+		procState:     drivers.TaskStateRunning,
+		startedAt:     time.Now().Round(time.Millisecond),
+		logger:        d.logger.Named("handle").With("alloc-id", cfg.AllocID),
+		taskGetter:    d.providers,
+		name:          taskName,
+		ctx:           ctx,
+		cancelFn:      cancel,
+		driverNetwork: netBuildResp.DriverNetwork,
+		netAddresses:  netBuildResp.Addresses,
 	}
 
 	d.tasks.Set(cfg.ID, h)
@@ -724,15 +992,22 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 	// information the driver will need to recover from failure and reattach
 	// to running VMs.
 	driverState := TaskState{
-		StartedAt:  h.startedAt,
-		TaskConfig: cfg,
+		StartedAt:     h.startedAt,
+		TaskConfig:    cfg,
+		DriverNetwork: netBuildResp.DriverNetwork,
+		NetAddresses:  netBuildResp.Addresses,
 	}
 
-	// If the VM did not include any network configuration, there will not be a
-	// teardown spec.
-	if netBuildResp.TeardownSpec != nil {
-		driverState.NetTeardown = netBuildResp.TeardownSpec
-		h.netTeardown = netBuildResp.TeardownSpec
+	// If the VM did not include any network configuration, there will not be
+	// any teardown specs.
+	netTeardowns := append(addressingTeardowns, netBuildResp.TeardownSpecs...)
+	netTeardowns = append(netTeardowns, networksTeardowns...)
+	if isolationTeardown != nil {
+		netTeardowns = append([]*net.TeardownSpec{isolationTeardown}, netTeardowns...)
+	}
+	if len(netTeardowns) > 0 {
+		driverState.NetTeardowns = netTeardowns
+		h.netTeardowns = netTeardowns
 	}
 
 	handle := drivers.NewTaskHandle(taskHandleVersion)
@@ -746,7 +1021,38 @@ func (d *VirtDriverPlugin) StartTask(cfg *drivers.TaskConfig) (_ *drivers.TaskHa
 
 	d.logger.Info("task started successfully", "task_name", taskName)
 
-	return handle, nil, nil
+	return handle, netBuildResp.DriverNetwork, nil
+}
+
+// CreateNetwork creates the network namespace for the allocation. It
+// implements the drivers.DriverNetworkManager interface.
+func (d *VirtDriverPlugin) CreateNetwork(allocID string, _ *drivers.NetworkCreateRequest) (*drivers.NetworkIsolationSpec, bool, error) {
+	path, created, err := d.netns.Create(allocID)
+	if err != nil {
+		return nil, false, fmt.Errorf("virt: failed to create network %s: %w", allocID, err)
+	}
+
+	spec := &drivers.NetworkIsolationSpec{
+		Mode:   drivers.NetIsolationModeGroup,
+		Path:   path,
+		Labels: make(map[string]string),
+	}
+
+	return spec, created, nil
+}
+
+// DestroyNetwork removes the network namespace of the allocation. It
+// implements the drivers.DriverNetworkManager interface.
+func (d *VirtDriverPlugin) DestroyNetwork(allocID string, spec *drivers.NetworkIsolationSpec) error {
+	if spec == nil {
+		return nil
+	}
+
+	if err := d.netns.Destroy(spec.Path); err != nil {
+		return fmt.Errorf("virt: failed to destroy network %s: %w", allocID, err)
+	}
+
+	return nil
 }
 
 // RecoverTask recreates the in-memory state of a task from a TaskHandle.
@@ -759,22 +1065,34 @@ func (d *VirtDriverPlugin) RecoverTask(handle *drivers.TaskHandle) error {
 		return nil
 	}
 
+	// Delay pruning the packet filter until the tasks have been recovered.
+	d.recoveries.Add(1)
+
 	var taskState TaskState
 	if err := handle.GetDriverState(&taskState); err != nil {
 		return fmt.Errorf("virt: failed to decode task state from handle %s: %v",
 			handle.Config.ID, err)
 	}
 
+	// Tasks started before multiple network interfaces were supported will
+	// only have a single teardown spec.
+	netTeardowns := taskState.NetTeardowns
+	if len(netTeardowns) == 0 && taskState.NetTeardown != nil {
+		netTeardowns = []*net.TeardownSpec{taskState.NetTeardown}
+	}
+
 	ctx, cancel := context.WithCancel(d.ctx)
 	h := &taskHandle{
-		name:        vmNameFromTaskID(handle.Config.ID),
-		logger:      d.logger.Named("handle").With("alloc-id", handle.Config.AllocID),
-		taskConfig:  taskState.TaskConfig,
-		startedAt:   taskState.StartedAt,
-		taskGetter:  d.providers,
-		netTeardown: taskState.NetTeardown,
-		ctx:         ctx,
-		cancelFn:    cancel,
+		name:          vmNameFromTaskID(handle.Config.ID),
+		logger:        d.logger.Named("handle").With("alloc-id", handle.Config.AllocID),
+		taskConfig:    taskState.TaskConfig,
+		startedAt:     taskState.StartedAt,
+		taskGetter:    d.providers,
+		netTeardowns:  netTeardowns,
+		driverNetwork: taskState.DriverNetwork,
+		netAddresses:  taskState.NetAddresses,
+		ctx:           ctx,
+		cancelFn:      cancel,
 	}
 
 	taskVm, err := h.taskGetter.GetVM(h.name)
@@ -790,11 +1108,176 @@ func (d *VirtDriverPlugin) RecoverTask(handle *drivers.TaskHandle) error {
 
 	h.procState = taskVm.State.ToTaskState()
 
+	// Restore the network configuration held in-process, such as proxied
+	// port forwards, and any packet filter configuration which has been
+	// lost. Failures do not prevent the recovery of the task, as the VM
+	// itself is unaffected.
+	d.filterMu.RLock()
+	defer d.filterMu.RUnlock()
+
+	if len(netTeardowns) > 0 {
+		d.recoverTaskNetwork(ctx, h)
+	}
+
 	d.tasks.Set(handle.Config.ID, h)
 
 	return nil
 }
 
+// recoverTaskNetwork restores the network configuration held in-process for
+// a recovered task. Errors are logged, as they are not terminal to the
+// recovery of the task.
+func (d *VirtDriverPlugin) recoverTaskNetwork(ctx context.Context, h *taskHandle) {
+	virtualizer, err := d.providers.GetProviderForVM(ctx, h.name)
+	if err != nil {
+		h.logger.Warn("failed to recover task network", "task_name", h.name, "error", err)
+		return
+	}
+
+	network, err := virtualizer.Networking()
+	if err != nil {
+		h.logger.Warn("failed to recover task network", "task_name", h.name, "error", err)
+		return
+	}
+
+	if _, err := network.VMRecoveredBuild(&net.VMRecoveredBuildRequest{
+		VMName:        h.name,
+		TeardownSpecs: h.netTeardowns,
+	}); err != nil {
+		h.logger.Warn("failed to recover task network", "task_name", h.name, "error", err)
+	}
+
+	d.reconcileFilter(network, []*taskHandle{h}, false)
+}
+
+// handleFilterReconcile reconciles the packet filter configuration of the
+// tasks, and then periodically until the plugin is shutdown. The tasks have
+// not been recovered when the first reconcile is performed, so nothing is
+// pruned.
+func (d *VirtDriverPlugin) handleFilterReconcile() {
+	d.recoveryIdleSince = time.Now()
+	d.reconcileTasksFilter(false)
+
+	ticker := time.NewTicker(filterReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.reconcileTasksFilter(d.recoveryFinished(now))
+		}
+	}
+}
+
+// recoveryFinished returns if the recovery of the tasks has finished. Nomad
+// does not signal when all tasks have been recovered, and may not start
+// recovering them until well after the plugin has started, so recovery is
+// considered finished once no task has been recovered for the prune delay,
+// and no task was recovered since the previous call. It is only called by
+// the filter reconcile loop.
+func (d *VirtDriverPlugin) recoveryFinished(now time.Time) bool {
+	if d.filterPrune {
+		return true
+	}
+
+	if d.recoveries.Swap(0) > 0 {
+		d.recoveryIdleSince = now
+		return false
+	}
+
+	d.filterPrune = now.Sub(d.recoveryIdleSince) >= d.filterPruneDelay
+	return d.filterPrune
+}
+
+// reconcileTasksFilter repairs the packet filter configuration of all tasks
+// and, when prune is set, removes the configuration which does not belong to
+// any task. It is skipped when a task is changing its configuration, and
+// performed on the next interval instead.
+func (d *VirtDriverPlugin) reconcileTasksFilter(prune bool) {
+	if !d.filterMu.TryLock() {
+		d.logger.Debug("tasks are being updated, skipping packet filter reconcile")
+		return
+	}
+	defer d.filterMu.Unlock()
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	virtualizer, err := d.providers.Default(ctx)
+	if err != nil {
+		d.logger.Warn("failed to reconcile packet filter", "error", err)
+		return
+	}
+
+	network, err := virtualizer.Networking()
+	if err != nil {
+		d.logger.Warn("failed to reconcile packet filter", "error", err)
+		return
+	}
+
+	d.reconcileFilter(network, d.tasks.List(), prune)
+}
+
+// reconcileFilter repairs the packet filter configuration of the tasks,
+// emitting metrics and task events for any drift which was found. Errors are
+// logged, as the configuration will be reconciled again.
+func (d *VirtDriverPlugin) reconcileFilter(network net.Net, handles []*taskHandle, prune bool) {
+	handlesByName := make(map[string]*taskHandle, len(handles))
+	req := &net.FilterReconcileRequest{
+		TeardownSpecs: make(map[string][]*net.TeardownSpec, len(handles)),
+		Prune:         prune,
+	}
+	for _, h := range handles {
+		handlesByName[h.name] = h
+		req.TeardownSpecs[h.name] = h.netTeardowns
+	}
+
+	resp, err := network.FilterReconcile(req)
+	if err != nil {
+		d.logger.Warn("failed to reconcile packet filter", "error", err)
+	}
+	if resp == nil {
+		return
+	}
+
+	if resp.BaseRestored > 0 {
+		d.logger.Warn("restored missing packet filter configuration", "count", resp.BaseRestored)
+		metrics.IncrCounter([]string{"virt", "filter", "reconcile", "restored"}, float32(resp.BaseRestored))
+	}
+
+	if resp.Removed > 0 {
+		d.logger.Warn("removed stray packet filter configuration", "count", resp.Removed)
+		metrics.IncrCounter([]string{"virt", "filter", "reconcile", "removed"}, float32(resp.Removed))
+	}
+
+	for name, restored := range resp.Restored {
+		h, ok := handlesByName[name]
+		if !ok || h.taskConfig == nil {
+			continue
+		}
+
+		h.logger.Warn("restored missing task packet filter configuration",
+			"task_name", h.name, "count", restored)
+		metrics.IncrCounterWithLabels([]string{"virt", "filter", "reconcile", "restored"}, float32(restored),
+			[]metrics.Label{
+				{Name: "task", Value: h.taskConfig.Name},
+				{Name: "alloc_id", Value: h.taskConfig.AllocID},
+			})
+
+		if err := d.eventer.EmitEvent(&drivers.TaskEvent{
+			TaskID:    h.taskConfig.ID,
+			TaskName:  h.taskConfig.Name,
+			AllocID:   h.taskConfig.AllocID,
+			Timestamp: time.Now(),
+			Message:   fmt.Sprintf("Restored %d missing packet filter rules", restored),
+		}); err != nil {
+			h.logger.Warn("failed to emit task event", "error", err)
+		}
+	}
+}
+
 // volumeCleanup is a helper used to cleanup storage volumes when a task
 // fails to start.
 func (d *VirtDriverPlugin) volumeCleanup(s storage.Storage, vols []storage.Volume) {
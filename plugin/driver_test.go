@@ -23,6 +23,7 @@ import (
 	"github.com/hashicorp/nomad-driver-virt/storage"
 	"github.com/hashicorp/nomad-driver-virt/testutil"
 	mock_cloudinit "github.com/hashicorp/nomad-driver-virt/testutil/mock/cloudinit"
+	mock_netns "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/netns"
 	mock_providers "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers"
 	mock_storage "github.com/hashicorp/nomad-driver-virt/testutil/mock/storage"
 	mock_image_tools "github.com/hashicorp/nomad-driver-virt/testutil/mock/storage/image_tools"
@@ -30,6 +31,7 @@ import (
 	mock_virt_net "github.com/hashicorp/nomad-driver-virt/testutil/mock/virt/net"
 	"github.com/hashicorp/nomad-driver-virt/virt"
 	"github.com/hashicorp/nomad-driver-virt/virt/disks"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/ci"
 	"github.com/hashicorp/nomad/helper/testlog"
 	"github.com/hashicorp/nomad/helper/uuid"
@@ -192,6 +194,7 @@ func TestVirtDriver(t *testing.T) {
 			mock_virt.Init{},
 			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
 			mock_virt.GenerateMountCommands{
 				Result: []string{
 					"mkdir -p /alloc",
@@ -230,7 +233,12 @@ func TestVirtDriver(t *testing.T) {
 			mock_virt.Storage{Result: st},
 			mock_virt.Storage{Result: st},
 			mock_virt.Storage{Result: st},
-			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.Networking{Result: &mock_virt_net.StaticNet{
+				VMStartedBuildResult: &net.VMStartedBuildResponse{
+					DriverNetwork: &drivers.DriverNetwork{IP: "10.0.1.50"},
+					Addresses:     []*net.InterfaceAddresses{{IPv4: "10.0.1.50", IPv6: "fd00::50"}},
+				},
+			}},
 			mock_virt.CreateVM{
 				Config: &vm.Config{
 					RemoveConfigFiles: true,
@@ -240,6 +248,7 @@ func TestVirtDriver(t *testing.T) {
 					CPUs:              3,
 					OsVariant:         &vm.OSVariant{Arch: testOsArch, Machine: testOsMachine},
 					HostName:          "nomad-" + vmName,
+					AllocID:           task.AllocID,
 					Mounts: []vm.MountFileConfig{
 						{
 							Source:      filepath.Join(task.AllocDir, "alloc"),
@@ -344,9 +353,10 @@ func TestVirtDriver(t *testing.T) {
 		)
 
 		// start the task
-		taskHandle, _, err := driver.StartTask(task)
+		taskHandle, driverNetwork, err := driver.StartTask(task)
 		must.NoError(t, err)
 		must.One(t, taskHandle.Version)
+		must.Eq(t, &drivers.DriverNetwork{PortMap: map[string]int{}, IP: "10.0.1.50"}, driverNetwork)
 
 		waitCh, err := driver.WaitTask(t.Context(), task.ID)
 		must.NoError(t, err)
@@ -382,6 +392,11 @@ func TestVirtDriver(t *testing.T) {
 		must.NoError(t, err)
 		must.Eq(t, drivers.TaskStateRunning, ts.State)
 		must.StrContains(t, task.ID, ts.ID)
+		must.Eq(t, &drivers.DriverNetwork{PortMap: map[string]int{}, IP: "10.0.1.50"}, ts.NetworkOverride)
+		must.Eq(t, map[string]string{
+			"network_interface.0.ipv4": "10.0.1.50",
+			"network_interface.0.ipv6": "fd00::50",
+		}, ts.DriverAttributes)
 
 		// force destroy the task
 		must.NoError(t, driver.DestroyTask(task.ID, true))
@@ -405,13 +420,15 @@ func TestVirtDriver(t *testing.T) {
 		pv := mock_providers.NewStatic(vt)
 		ci := mock_cloudinit.NewStaticCloudInit()
 
+		recoveredNet := mock_virt_net.NewStatic()
+
 		driverCfg := driverConfig(dir)
 		// Load initialization expectations
 		vt.Expect(
 			mock_virt.Init{},
 			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
-			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
 			mock_virt.GenerateMountCommands{
 				Result: []string{
@@ -444,7 +461,29 @@ func TestVirtDriver(t *testing.T) {
 			mock_virt.Storage{Result: st},
 			mock_virt.Storage{Result: st},
 			mock_virt.Storage{Result: st},
+			mock_virt.Networking{Result: &mock_virt_net.StaticNet{
+				VMStartedBuildResult: &net.VMStartedBuildResponse{
+					DriverNetwork: &drivers.DriverNetwork{
+						PortMap: map[string]int{"ssh": 22},
+						IP:      "192.168.122.58",
+					},
+					Addresses: []*net.InterfaceAddresses{{IPv4: "192.168.122.58"}},
+					TeardownSpecs: []*net.TeardownSpec{
+						{
+							Proxies: []*net.ProxyForward{
+								{Protocol: net.PortProtocolTCP, Listen: "127.0.0.1:27494", Target: "192.168.122.58:22"},
+							},
+						},
+					},
+				},
+			}},
+			// Networking is initialized by the recovering driver once the
+			// task has been started, and used to reconcile the packet filter.
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			// Networking is used to restore the task network when the task
+			// is recovered.
+			mock_virt.Networking{Result: recoveredNet},
 			mock_virt.CreateVM{
 				Config: &vm.Config{
 					RemoveConfigFiles: true,
@@ -454,6 +493,7 @@ func TestVirtDriver(t *testing.T) {
 					CPUs:              3,
 					OsVariant:         &vm.OSVariant{Arch: testOsArch, Machine: testOsMachine},
 					HostName:          "nomad-" + vmName,
+					AllocID:           task.AllocID,
 					Mounts: []vm.MountFileConfig{
 						{
 							Source:      filepath.Join(task.AllocDir, "alloc"),
@@ -544,6 +584,14 @@ func TestVirtDriver(t *testing.T) {
 		driver = testHarness(t, driverCfg, pv, ci, task, 1*time.Second)
 		err = driver.RecoverTask(taskHandle)
 		must.NoError(t, err)
+		must.Eq(t, 1, recoveredNet.CallCount("VMRecoveredBuild"))
+
+		// inspect the recovered task to verify the network is still reported
+		ts, err = driver.InspectTask(task.ID)
+		must.NoError(t, err)
+		must.Eq(t, &drivers.DriverNetwork{PortMap: map[string]int{"ssh": 22}, IP: "192.168.122.58"},
+			ts.NetworkOverride)
+		must.Eq(t, map[string]string{"network_interface.0.ipv4": "192.168.122.58"}, ts.DriverAttributes)
 
 		// force destroy the task
 		must.NoError(t, driver.DestroyTask(task.ID, true))
@@ -573,6 +621,7 @@ func TestVirtDriver(t *testing.T) {
 			mock_virt.Init{},
 			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
 			mock_virt.GenerateMountCommands{
 				Result: []string{
 					"mkdir -p /alloc",
@@ -613,6 +662,7 @@ func TestVirtDriver(t *testing.T) {
 					CPUs:              3,
 					OsVariant:         &vm.OSVariant{Arch: testOsArch, Machine: testOsMachine},
 					HostName:          "nomad-" + vmName,
+					AllocID:           task.AllocID,
 					Mounts: []vm.MountFileConfig{
 						{
 							Source:      filepath.Join(task.AllocDir, "alloc"),
@@ -738,6 +788,7 @@ func TestVirtDriver(t *testing.T) {
 			mock_virt.Init{},
 			mock_virt.SetupStorage{Config: driverCfg.StoragePools},
 			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
+			mock_virt.Networking{Result: mock_virt_net.NewStatic()},
 			mock_virt.GenerateMountCommands{
 				Result: []string{
 					"mkdir -p /alloc",
@@ -779,6 +830,7 @@ func TestVirtDriver(t *testing.T) {
 					CPUs:              3,
 					OsVariant:         &vm.OSVariant{Arch: testOsArch, Machine: testOsMachine},
 					HostName:          "nomad-" + vmName,
+					AllocID:           task.AllocID,
 					Mounts: []vm.MountFileConfig{
 						{
 							Source:      filepath.Join(task.AllocDir, "alloc"),
@@ -860,6 +912,352 @@ func TestVirtDriver(t *testing.T) {
 	})
 }
 
+func TestVirtDriver_DestroyTask(t *testing.T) {
+	ci.Parallel(t)
+
+	task := testTaskConfig()
+	vmName := vmNameFromTaskID(task.ID)
+	teardowns := []*net.TeardownSpec{
+		{FilterRemoval: &net.FilterRemoval{Name: "testing", Data: task.ID}},
+	}
+
+	d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+	d.tasks.Set(task.ID, &taskHandle{
+		name:         vmName,
+		logger:       hclog.NewNullLogger(),
+		taskConfig:   task,
+		procState:    drivers.TaskStateExited,
+		cancelFn:     func() {},
+		netTeardowns: teardowns,
+	})
+
+	network := mock_virt_net.NewMock(t).Expect(
+		mock_virt_net.VMTerminatedTeardown{
+			Request: &net.VMTerminatedTeardownRequest{TeardownSpecs: teardowns},
+			Result:  &net.VMTerminatedTeardownResponse{},
+		},
+	)
+	vt := mock_virt.NewMock(t).Expect(
+		mock_virt.Networking{Result: network},
+		mock_virt.DestroyVM{Name: vmName},
+	)
+	defer vt.AssertExpectations()
+	d.providers = mock_providers.NewStatic(vt)
+
+	must.NoError(t, d.DestroyTask(task.ID, false))
+
+	// The task is stored by its ID, not the name of its VM, so it must be
+	// removed by its ID once destroyed.
+	_, ok := d.tasks.Get(task.ID)
+	must.False(t, ok)
+	must.SliceEmpty(t, d.tasks.List())
+}
+
+func TestVirtDriver_CreateNetwork(t *testing.T) {
+	ci.Parallel(t)
+
+	allocID := uuid.Generate()
+	path := filepath.Join("/var/run/netns", allocID)
+
+	t.Run("ok", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		ns := mock_netns.New(t).Expect(
+			mock_netns.Create{AllocID: allocID, Path: path, Created: true},
+		)
+		d.netns = ns
+
+		spec, created, err := d.CreateNetwork(allocID, &drivers.NetworkCreateRequest{})
+		must.NoError(t, err)
+		must.True(t, created)
+		must.Eq(t, &drivers.NetworkIsolationSpec{
+			Mode:   drivers.NetIsolationModeGroup,
+			Path:   path,
+			Labels: map[string]string{},
+		}, spec)
+		ns.AssertExpectations()
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		ns := mock_netns.New(t).Expect(
+			mock_netns.Create{AllocID: allocID, Path: path},
+		)
+		d.netns = ns
+
+		spec, created, err := d.CreateNetwork(allocID, &drivers.NetworkCreateRequest{})
+		must.NoError(t, err)
+		must.False(t, created)
+		must.Eq(t, path, spec.Path)
+		ns.AssertExpectations()
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		testErr := errors.New("test error")
+		ns := mock_netns.New(t).Expect(
+			mock_netns.Create{AllocID: allocID, Err: testErr},
+		)
+		d.netns = ns
+
+		_, _, err := d.CreateNetwork(allocID, &drivers.NetworkCreateRequest{})
+		must.ErrorIs(t, err, testErr)
+		ns.AssertExpectations()
+	})
+}
+
+func TestVirtDriver_DestroyNetwork(t *testing.T) {
+	ci.Parallel(t)
+
+	allocID := uuid.Generate()
+	spec := &drivers.NetworkIsolationSpec{
+		Mode: drivers.NetIsolationModeGroup,
+		Path: filepath.Join("/var/run/netns", allocID),
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		ns := mock_netns.New(t).Expect(
+			mock_netns.Destroy{Path: spec.Path},
+		)
+		d.netns = ns
+
+		must.NoError(t, d.DestroyNetwork(allocID, spec))
+		ns.AssertExpectations()
+	})
+
+	t.Run("no spec", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		d.netns = mock_netns.New(t)
+
+		must.NoError(t, d.DestroyNetwork(allocID, nil))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		testErr := errors.New("test error")
+		ns := mock_netns.New(t).Expect(
+			mock_netns.Destroy{Path: spec.Path, Err: testErr},
+		)
+		d.netns = ns
+
+		must.ErrorIs(t, d.DestroyNetwork(allocID, spec), testErr)
+		ns.AssertExpectations()
+	})
+}
+
+func TestVirtDriver_reconcileFilter(t *testing.T) {
+	ci.Parallel(t)
+
+	testHandle := func() *taskHandle {
+		task := testTaskConfig()
+		task.Name = "test-task"
+		return &taskHandle{
+			name:       vmNameFromTaskID(task.ID),
+			logger:     hclog.NewNullLogger(),
+			taskConfig: task,
+			netTeardowns: []*net.TeardownSpec{
+				{FilterRemoval: &net.FilterRemoval{Name: "testing", Data: task.ID}},
+			},
+		}
+	}
+
+	t.Run("drift", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		h1, h2 := testHandle(), testHandle()
+
+		network := mock_virt_net.NewMock(t).Expect(
+			mock_virt_net.FilterReconcile{
+				Request: &net.FilterReconcileRequest{
+					TeardownSpecs: map[string][]*net.TeardownSpec{
+						h1.name: h1.netTeardowns,
+						h2.name: h2.netTeardowns,
+					},
+				},
+				Result: &net.FilterReconcileResponse{
+					Restored:     map[string]int{h2.name: 2},
+					BaseRestored: 1,
+				},
+			},
+		)
+
+		events, err := d.TaskEvents(t.Context())
+		must.NoError(t, err)
+
+		d.reconcileFilter(network, []*taskHandle{h1, h2}, false)
+
+		// Only the task with restored configuration receives an event.
+		select {
+		case event := <-events:
+			must.Eq(t, h2.taskConfig.ID, event.TaskID)
+			must.Eq(t, h2.taskConfig.AllocID, event.AllocID)
+			must.Eq(t, "test-task", event.TaskName)
+			must.Eq(t, "Restored 2 missing packet filter rules", event.Message)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for task event")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		h := testHandle()
+
+		network := mock_virt_net.NewMock(t).Expect(
+			mock_virt_net.FilterReconcile{Err: errors.New("test error")},
+		)
+
+		d.reconcileFilter(network, []*taskHandle{h}, true)
+	})
+
+	t.Run("all tasks", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		h := testHandle()
+		d.tasks.Set(h.taskConfig.ID, h)
+
+		network := mock_virt_net.NewMock(t).Expect(
+			mock_virt_net.FilterReconcile{
+				Request: &net.FilterReconcileRequest{
+					TeardownSpecs: map[string][]*net.TeardownSpec{h.name: h.netTeardowns},
+					Prune:         true,
+				},
+				Result: &net.FilterReconcileResponse{},
+			},
+		)
+		vt := mock_virt.NewMock(t).Expect(
+			mock_virt.Networking{Result: network},
+		)
+		defer vt.AssertExpectations()
+		d.providers = mock_providers.NewStatic(vt)
+
+		d.reconcileTasksFilter(true)
+	})
+
+	t.Run("without pruning", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+
+		// The filter is reconciled before any task has been recovered, so
+		// the configuration of the tasks must not be pruned.
+		network := mock_virt_net.NewMock(t).Expect(
+			mock_virt_net.FilterReconcile{
+				Request: &net.FilterReconcileRequest{
+					TeardownSpecs: map[string][]*net.TeardownSpec{},
+				},
+				Result: &net.FilterReconcileResponse{},
+			},
+		)
+		vt := mock_virt.NewMock(t).Expect(
+			mock_virt.Networking{Result: network},
+		)
+		defer vt.AssertExpectations()
+		d.providers = mock_providers.NewStatic(vt)
+
+		d.reconcileTasksFilter(false)
+	})
+
+	t.Run("reconcile loop", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		d := NewPlugin(ctx, hclog.NewNullLogger()).(*VirtDriverPlugin)
+
+		// The loop reconciles the filter once it starts, rather than when the
+		// plugin is configured, and without pruning.
+		network := mock_virt_net.NewMock(t).Expect(
+			mock_virt_net.FilterReconcile{
+				Request: &net.FilterReconcileRequest{
+					TeardownSpecs: map[string][]*net.TeardownSpec{},
+				},
+				Result: &net.FilterReconcileResponse{},
+			},
+		)
+		vt := mock_virt.NewMock(t).Expect(
+			mock_virt.Networking{Result: network},
+		)
+		defer vt.AssertExpectations()
+		d.providers = mock_providers.NewStatic(vt)
+
+		// The plugin is shutdown, so the loop returns after the first
+		// reconcile.
+		cancel()
+		d.handleFilterReconcile()
+		must.False(t, d.recoveryIdleSince.IsZero())
+	})
+
+	t.Run("tasks updating", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		vt := mock_virt.NewMock(t)
+		defer vt.AssertExpectations()
+		d.providers = mock_providers.NewStatic(vt)
+
+		// The reconcile is skipped while a task is being updated.
+		d.filterMu.RLock()
+		defer d.filterMu.RUnlock()
+
+		d.reconcileTasksFilter(true)
+	})
+
+	t.Run("recovery finished", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		d.filterPruneDelay = 5 * time.Minute
+		start := time.Now()
+		d.recoveryIdleSince = start
+
+		// Nothing is pruned until no task has been recovered for the delay.
+		must.False(t, d.recoveryFinished(start.Add(time.Minute)))
+		must.True(t, d.recoveryFinished(start.Add(5*time.Minute)))
+
+		// Pruning stays enabled once recovery has finished.
+		d.recoveries.Add(1)
+		must.True(t, d.recoveryFinished(start.Add(6*time.Minute)))
+	})
+
+	t.Run("recovery in progress", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		start := time.Now()
+		d.recoveryIdleSince = start
+
+		// Pruning is enabled once no task was recovered since the previous
+		// check, even without a delay.
+		d.recoveries.Add(2)
+		must.False(t, d.recoveryFinished(start.Add(time.Minute)))
+		d.recoveries.Add(1)
+		must.False(t, d.recoveryFinished(start.Add(2*time.Minute)))
+		must.True(t, d.recoveryFinished(start.Add(3*time.Minute)))
+	})
+
+	t.Run("recovery after first check", func(t *testing.T) {
+		d := NewPlugin(t.Context(), hclog.NewNullLogger()).(*VirtDriverPlugin)
+		d.filterPruneDelay = 5 * time.Minute
+		start := time.Now()
+		d.recoveryIdleSince = start
+
+		// Nomad has not started recovering the tasks by the first check.
+		must.False(t, d.recoveryFinished(start.Add(time.Minute)))
+
+		// The delay restarts from the check which found the first recovered
+		// task.
+		d.recoveries.Add(1)
+		must.False(t, d.recoveryFinished(start.Add(2*time.Minute)))
+		must.False(t, d.recoveryFinished(start.Add(6*time.Minute)))
+		must.True(t, d.recoveryFinished(start.Add(7*time.Minute)))
+	})
+}
+
+func Test_allocatedMBits(t *testing.T) {
+	must.Zero(t, allocatedMBits(nil))
+	must.Zero(t, allocatedMBits(&drivers.Resources{}))
+	must.Eq(t, 150, allocatedMBits(&drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Networks: structs.Networks{{MBits: 100}, {MBits: 50}},
+		},
+	}))
+}
+
+func Test_allocatedPorts(t *testing.T) {
+	must.Nil(t, allocatedPorts(nil))
+	must.Nil(t, allocatedPorts(&drivers.Resources{}))
+
+	ports := structs.AllocatedPorts{{Label: "http", Value: 8080}}
+	must.Eq(t, ports, allocatedPorts(&drivers.Resources{Ports: &ports}))
+}
+
 func TestVirtDriver_Libvirt(t *testing.T) {
 	ci.Parallel(t)
 	testutil.RequireQemuImg(t)
@@ -42,9 +42,17 @@ type taskHandle struct {
 
 	taskGetter virt.VMGetter
 
-	// netTeardown is the specification used to delete all the network
+	// netTeardowns are the specifications used to delete all the network
 	// configuration associated to a VM.
-	netTeardown *net.TeardownSpec
+	netTeardowns []*net.TeardownSpec
+
+	// driverNetwork is the network returned to Nomad when the task was
+	// started, which is nil if no address was discovered.
+	driverNetwork *drivers.DriverNetwork
+
+	// netAddresses are the addresses of each VM network interface, ordered
+	// to match the interfaces of the VM.
+	netAddresses []*net.InterfaceAddresses
 
 	// context associated to the task
 	ctx      context.Context
@@ -61,11 +69,33 @@ func (h *taskHandle) TaskStatus() *drivers.TaskStatus {
 		State:            h.procState,
 		StartedAt:        h.startedAt,
 		CompletedAt:      h.completedAt,
-		DriverAttributes: map[string]string{},
+		DriverAttributes: h.driverAttributes(),
+		NetworkOverride:  h.driverNetwork.Copy(),
 		ExitResult:       h.exitResult.Copy(),
 	}
 }
 
+// driverAttributes returns the attributes reported when the task is
+// inspected, which include the address of each VM network interface.
+func (h *taskHandle) driverAttributes() map[string]string {
+	attrs := map[string]string{}
+
+	for i, addrs := range h.netAddresses {
+		if addrs == nil {
+			continue
+		}
+
+		if addrs.IPv4 != "" {
+			attrs[fmt.Sprintf("network_interface.%d.ipv4", i)] = addrs.IPv4
+		}
+		if addrs.IPv6 != "" {
+			attrs[fmt.Sprintf("network_interface.%d.ipv6", i)] = addrs.IPv6
+		}
+	}
+
+	return attrs
+}
+
 func (h *taskHandle) GetStats() (*drivers.TaskResourceUsage, error) {
 	virtvm, err := h.taskGetter.GetVM(h.name)
 	if err != nil {
@@ -4,6 +4,8 @@
 package plugin
 
 import (
+	"maps"
+	"slices"
 	"sync"
 )
 
@@ -37,3 +39,10 @@ func (ts *taskStore) Delete(id string) {
 	defer ts.lock.Unlock()
 	delete(ts.store, id)
 }
+
+// List returns the stored task handles.
+func (ts *taskStore) List() []*taskHandle {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+	return slices.Collect(maps.Values(ts.store))
+}
@@ -35,10 +35,17 @@ func Test_taskStore(t *testing.T) {
 	must.NotNil(t, task2Handle)
 	must.Eq(t, "task_id_2", task2Handle.name)
 
+	// List both handles.
+	handles := testStore.List()
+	must.Len(t, 2, handles)
+	must.SliceContainsFunc(t, handles, "task_id_1", func(h *taskHandle, name string) bool { return h.name == name })
+	must.SliceContainsFunc(t, handles, "task_id_2", func(h *taskHandle, name string) bool { return h.name == name })
+
 	// Delete both, then ensure the map is empty.
 	testStore.Delete("task_id_1")
 	testStore.Delete("task_id_2")
 	must.MapEmpty(t, testStore.store)
+	must.SliceEmpty(t, testStore.List())
 
 	// Ensure deletes of entries that do not exist do not cause adverse
 	// behaviour.
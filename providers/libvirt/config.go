@@ -5,7 +5,17 @@ package libvirt
 
 import (
 	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
 )
 
@@ -18,6 +28,14 @@ var configSpec = hclspec.NewBlock("libvirt", false, hclspec.NewObject(map[string
 	"user":                           hclspec.NewAttr("user", "string", false),
 	"password":                       hclspec.NewAttr("password", "string", false),
 	"allow_insecure_readonly_mounts": hclspec.NewAttr("allow_insecure_readonly_mounts", "bool", false),
+	"network_filter": hclspec.NewDefault(
+		hclspec.NewAttr("network_filter", "string", false),
+		hclspec.NewLiteral(fmt.Sprintf("%q", filter.BackendIPTables)),
+	),
+	"discovery":            hclspec.NewAttr("discovery", "list(string)", false),
+	"discovery_timeout":    hclspec.NewAttr("discovery_timeout", "string", false),
+	"bandwidth_from_mbits": hclspec.NewAttr("bandwidth_from_mbits", "bool", false),
+	"managed_network":      net.ManagedNetworkHCLSpec(),
 }))
 
 var taskSpec = hclspec.NewBlock("libvirt", false, hclspec.NewObject(map[string]*hclspec.Spec{
@@ -38,10 +56,96 @@ type Config struct {
 	User                string `codec:"user"`
 	Password            string `codec:"password"`
 	AllowInsecureMounts bool   `codec:"allow_insecure_readonly_mounts"`
+	NetworkFilter       string `codec:"network_filter"`
+
+	// Discovery is the ordered list of strategies used to discover the
+	// addresses of network interfaces which do not set their own.
+	Discovery []net.DiscoveryStrategy `codec:"discovery"`
+
+	// DiscoveryTimeout is the duration within which the addresses of a
+	// network interface must be discovered.
+	DiscoveryTimeout string `codec:"discovery_timeout"`
+
+	// BandwidthFromMBits limits the traffic of network interfaces which do
+	// not set their own bandwidth to the network bandwidth allocated to the
+	// task.
+	BandwidthFromMBits bool `codec:"bandwidth_from_mbits"`
+
+	// ManagedNetworks are the templates of the networks created on demand
+	// for the tasks which attach to them, keyed by template name.
+	ManagedNetworks map[string]net.ManagedNetworkConfig `codec:"managed_network"`
+}
+
+// Session returns if the configured URI connects to the per-user session
+// daemon, such as "qemu:///session", rather than the system daemon.
+func (c *Config) Session() bool {
+	return c != nil && isSessionURI(c.URI)
+}
+
+// isSessionURI returns if the URI connects to the per-user session daemon.
+func isSessionURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	return u.Path == sessionURIPath
+}
+
+// SessionImagesPath returns the directory used for directory storage pools
+// which do not set a path when connected to the session daemon. It matches
+// the default images directory of the libvirt session daemon, which is
+// within the data directory of the user.
+func SessionImagesPath() (string, error) {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine session images path: %w", err)
+		}
+		dataDir = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dataDir, "libvirt", "images"), nil
 }
 
 // Validate validates the libvirt configuration.
 func (c *Config) Validate() error {
-	// NOTE: Nothing to validate currently.
+	if c.NetworkFilter != "" && !slices.Contains(filter.Backends, c.NetworkFilter) {
+		return fmt.Errorf("%w: unknown network filter %q (supported: %s)",
+			errs.ErrInvalidConfiguration, c.NetworkFilter, strings.Join(filter.Backends, ", "))
+	}
+
+	if err := net.ValidateDiscoveryStrategies(c.Discovery); err != nil {
+		return err
+	}
+
+	// The static strategy can only discover the addresses configured for an
+	// interface, so the interfaces without addresses require another.
+	if len(c.Discovery) > 0 && !slices.ContainsFunc(c.Discovery, func(s net.DiscoveryStrategy) bool {
+		return s != net.DiscoveryStrategyStatic
+	}) {
+		return fmt.Errorf("%w: discovery requires a strategy other than %q",
+			errs.ErrInvalidConfiguration, net.DiscoveryStrategyStatic)
+	}
+
+	if c.DiscoveryTimeout != "" {
+		timeout, err := time.ParseDuration(c.DiscoveryTimeout)
+		if err != nil {
+			return fmt.Errorf("%w: invalid discovery_timeout %q: %w",
+				errs.ErrInvalidConfiguration, c.DiscoveryTimeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("%w: discovery_timeout %q must be positive",
+				errs.ErrInvalidConfiguration, c.DiscoveryTimeout)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.ManagedNetworks)) {
+		if err := c.ManagedNetworks[name].Validate(name); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
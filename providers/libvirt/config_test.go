@@ -0,0 +1,53 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package libvirt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestConfig_Session(t *testing.T) {
+	testCases := []struct {
+		uri     string
+		session bool
+	}{
+		{uri: "qemu:///system"},
+		{uri: "qemu:///session", session: true},
+		{uri: "qemu+ssh://user@host/session", session: true},
+		{uri: "qemu+unix:///session?socket=/run/user/1000/libvirt/virtqemud-sock", session: true},
+		{uri: "test:///default"},
+		{uri: "%"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uri, func(t *testing.T) {
+			must.Eq(t, tc.session, (&Config{URI: tc.uri}).Session())
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var c *Config
+		must.False(t, c.Session())
+	})
+}
+
+func TestSessionImagesPath(t *testing.T) {
+	t.Run("data home", func(t *testing.T) {
+		t.Setenv("XDG_DATA_HOME", "/data")
+		path, err := SessionImagesPath()
+		must.NoError(t, err)
+		must.Eq(t, "/data/libvirt/images", path)
+	})
+
+	t.Run("home", func(t *testing.T) {
+		t.Setenv("XDG_DATA_HOME", "")
+		t.Setenv("HOME", "/home/nomad")
+		path, err := SessionImagesPath()
+		must.NoError(t, err)
+		must.Eq(t, filepath.Join("/home/nomad", ".local", "share", "libvirt", "images"), path)
+	})
+}
@@ -5,8 +5,11 @@ package libvirt
 
 import (
 	"fmt"
+	"slices"
 
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	vm "github.com/hashicorp/nomad-driver-virt/internal/shared"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"libvirt.org/go/libvirtxml"
 )
 
@@ -256,6 +259,12 @@ func (p *provider) generateDomainDeviceInterfaces(config *vm.Config, dom *libvir
 	ifaces := config.NetworkInterfaces
 	result := make([]libvirtxml.DomainInterface, len(ifaces))
 	for i, iface := range ifaces {
+		if p.session {
+			if err := sessionInterfaceSupported(iface); err != nil {
+				return fmt.Errorf("network_interface[%d]: %w", i+1, err)
+			}
+		}
+
 		if iface.Bridge != nil {
 			result[i] = libvirtxml.DomainInterface{
 				Source: &libvirtxml.DomainInterfaceSource{
@@ -268,7 +277,29 @@ func (p *provider) generateDomainDeviceInterfaces(config *vm.Config, dom *libvir
 				},
 			}
 
-			continue
+			if ovs := iface.Bridge.OpenVSwitch; ovs != nil {
+				result[i].VirtualPort = &libvirtxml.DomainInterfaceVirtualPort{
+					Params: &libvirtxml.DomainInterfaceVirtualPortParams{
+						OpenVSwitch: &libvirtxml.DomainInterfaceVirtualPortParamsOpenVSwitch{
+							InterfaceID: ovs.InterfaceID,
+						},
+					},
+				}
+				result[i].VLan = interfaceVLAN(ovs)
+			}
+		}
+
+		if iface.Network != nil {
+			result[i] = libvirtxml.DomainInterface{
+				Source: &libvirtxml.DomainInterfaceSource{
+					Network: &libvirtxml.DomainInterfaceSourceNetwork{
+						Network: iface.Network.Name,
+					},
+				},
+				Model: &libvirtxml.DomainInterfaceModel{
+					Type: defaultInterfaceModel,
+				},
+			}
 		}
 
 		if iface.Macvtap != nil {
@@ -283,9 +314,51 @@ func (p *provider) generateDomainDeviceInterfaces(config *vm.Config, dom *libvir
 					Type: defaultInterfaceModel,
 				},
 			}
+		}
 
-			continue
+		if iface.User != nil {
+			result[i] = libvirtxml.DomainInterface{
+				Source: &libvirtxml.DomainInterfaceSource{
+					User: &libvirtxml.DomainInterfaceSourceUser{},
+				},
+				Model: &libvirtxml.DomainInterfaceModel{
+					Type: defaultInterfaceModel,
+				},
+				PortForward: userPortForwards(iface.User.Forwards),
+			}
+
+			// The slirp backend is built into QEMU and is used when no
+			// backend is set.
+			if iface.User.Backend == net.UserBackendPasst {
+				result[i].Backend = &libvirtxml.DomainInterfaceBackend{
+					Type: string(net.UserBackendPasst),
+				}
+			}
+		}
+
+		// The interface attached to the allocation network namespace is a
+		// macvtap on the host end of the link to the namespace.
+		if iface.Isolation != nil {
+			result[i] = libvirtxml.DomainInterface{
+				Source: &libvirtxml.DomainInterfaceSource{
+					Direct: &libvirtxml.DomainInterfaceSourceDirect{
+						Dev:  iface.Isolation.Device,
+						Mode: string(net.MacvtapModeBridge),
+					},
+				},
+				Model: &libvirtxml.DomainInterfaceModel{
+					Type: defaultInterfaceModel,
+				},
+			}
 		}
+
+		if mac := config.InterfaceHwaddr(i); mac != "" {
+			result[i].MAC = &libvirtxml.DomainInterfaceMAC{
+				Address: mac,
+			}
+		}
+
+		result[i].Bandwidth = p.interfaceBandwidth(config, iface)
 	}
 
 	dom.Devices.Interfaces = result
@@ -293,6 +366,170 @@ func (p *provider) generateDomainDeviceInterfaces(config *vm.Config, dom *libvir
 	return nil
 }
 
+// sessionInterfaceSupported returns an error when the network interface uses
+// a feature which requires privileges on the host, so cannot be used when
+// connected to the session daemon.
+func sessionInterfaceSupported(iface *net.NetworkInterfaceConfig) error {
+	switch {
+	case iface.Macvtap != nil:
+		return fmt.Errorf("macvtap %w in session mode", errs.ErrNotSupported)
+	case iface.Isolation != nil:
+		return fmt.Errorf("network isolation %w in session mode", errs.ErrNotSupported)
+	case iface.Network != nil:
+		return fmt.Errorf("network %w in session mode", errs.ErrNotSupported)
+	}
+
+	if inbound, outbound := iface.Bandwidth(); inbound != nil || outbound != nil {
+		return fmt.Errorf("bandwidth limits %w in session mode", errs.ErrNotSupported)
+	}
+
+	if bridge := iface.Bridge; bridge != nil {
+		switch {
+		case bridge.OpenVSwitch != nil:
+			return fmt.Errorf("openvswitch %w in session mode", errs.ErrNotSupported)
+		case len(bridge.Ports) > 0, len(bridge.Ingress) > 0, bridge.Egress.Restricted():
+			return fmt.Errorf("bridge ports and policies %w in session mode", errs.ErrNotSupported)
+		case bridge.EgressIP != "":
+			return fmt.Errorf("bridge egress_ip %w in session mode", errs.ErrNotSupported)
+		case bridge.IPAM:
+			return fmt.Errorf("bridge ipam %w in session mode", errs.ErrNotSupported)
+		case len(bridge.DNSAliases) > 0:
+			return fmt.Errorf("bridge dns_aliases %w in session mode", errs.ErrNotSupported)
+		case slices.Contains(iface.Discovery, net.DiscoveryStrategyDHCPLease):
+			return fmt.Errorf("%q discovery %w in session mode", net.DiscoveryStrategyDHCPLease, errs.ErrNotSupported)
+		}
+	}
+
+	return nil
+}
+
+// userPortForwards converts the port forwards of a user interface into the
+// libvirt representation. A nil value is returned when no ports are
+// forwarded.
+func userPortForwards(forwards []*net.UserPortForward) []libvirtxml.DomainInterfaceSourcePortForward {
+	if len(forwards) == 0 {
+		return nil
+	}
+
+	result := make([]libvirtxml.DomainInterfaceSourcePortForward, len(forwards))
+	for i, forward := range forwards {
+		result[i] = libvirtxml.DomainInterfaceSourcePortForward{
+			Proto:   string(forward.Protocol),
+			Address: forward.Address,
+			Ranges: []libvirtxml.DomainInterfaceSourcePortForwardRange{{
+				Start: uint(forward.HostPort),
+				To:    uint(forward.GuestPort),
+			}},
+		}
+	}
+
+	return result
+}
+
+// interfaceVLAN returns the VLAN configuration of an Open vSwitch port. When
+// the port is a trunk, the VLAN tag of the port is sent untagged to the VM. A
+// nil value is returned when the port is not tagged.
+func interfaceVLAN(ovs *net.NetworkInterfaceOpenVSwitchConfig) *libvirtxml.DomainInterfaceVLan {
+	if ovs.VLAN == 0 && len(ovs.Trunk) == 0 {
+		return nil
+	}
+
+	vlan := &libvirtxml.DomainInterfaceVLan{}
+	if ovs.VLAN != 0 {
+		vlan.Tags = append(vlan.Tags, libvirtxml.DomainInterfaceVLanTag{ID: uint(ovs.VLAN)})
+	}
+
+	if len(ovs.Trunk) > 0 {
+		vlan.Trunk = "yes"
+		if len(vlan.Tags) > 0 {
+			vlan.Tags[0].NativeMode = "untagged"
+		}
+
+		for _, id := range ovs.Trunk {
+			vlan.Tags = append(vlan.Tags, libvirtxml.DomainInterfaceVLanTag{ID: uint(id)})
+		}
+	}
+
+	return vlan
+}
+
+// interfaceBandwidth returns the bandwidth configuration of a network
+// interface. When enabled, directions which are not limited by the interface
+// are limited to an equal share of the network bandwidth allocated to the
+// task. A nil value is returned when the interface traffic is not limited.
+func (p *provider) interfaceBandwidth(config *vm.Config, iface *net.NetworkInterfaceConfig) *libvirtxml.DomainInterfaceBandwidth {
+	// Libvirt does not support bandwidth limits on user-mode interfaces.
+	if iface.User != nil {
+		return nil
+	}
+
+	inbound, outbound := iface.Bandwidth()
+
+	// Bandwidth limits require privileges on the host, so the allocated
+	// bandwidth is not applied when connected to the session daemon.
+	if p.bandwidthFromMBits && config.MBits > 0 && !p.session {
+		if inbound == nil {
+			inbound = allocatedBandwidth(config, true)
+		}
+		if outbound == nil {
+			outbound = allocatedBandwidth(config, false)
+		}
+	}
+
+	if inbound == nil && outbound == nil {
+		return nil
+	}
+
+	return &libvirtxml.DomainInterfaceBandwidth{
+		Inbound:  bandwidthParams(inbound),
+		Outbound: bandwidthParams(outbound),
+	}
+}
+
+// allocatedBandwidth returns the share of the network bandwidth allocated to
+// the task for each interface which does not limit the direction itself, so
+// the combined traffic of the interfaces stays within the allocation.
+func allocatedBandwidth(config *vm.Config, inbound bool) *net.NetworkInterfaceBandwidthConfig {
+	shares := 0
+	for _, iface := range config.NetworkInterfaces {
+		if iface.User != nil {
+			continue
+		}
+
+		in, out := iface.Bandwidth()
+		if (inbound && in == nil) || (!inbound && out == nil) {
+			shares++
+		}
+	}
+
+	return &net.NetworkInterfaceBandwidthConfig{Average: max(mbitsToKBps(config.MBits)/max(shares, 1), 1)}
+}
+
+// bandwidthParams converts the bandwidth configuration of a single direction
+// into the libvirt representation, omitting any unset values.
+func bandwidthParams(bandwidth *net.NetworkInterfaceBandwidthConfig) *libvirtxml.DomainInterfaceBandwidthParams {
+	if bandwidth == nil {
+		return nil
+	}
+
+	average, peak, burst := bandwidth.Average, bandwidth.Peak, bandwidth.Burst
+	params := &libvirtxml.DomainInterfaceBandwidthParams{Average: &average}
+	if peak > 0 {
+		params.Peak = &peak
+	}
+	if burst > 0 {
+		params.Burst = &burst
+	}
+
+	return params
+}
+
+// mbitsToKBps converts a rate in megabits per second to kilobytes per second,
+// which is the unit used by libvirt for bandwidth rates.
+func mbitsToKBps(mbits int) int {
+	return mbits * 1000 / 8
+}
+
 // generateDomainDeviceFilesystems configures filesystem entries from mount file configs.
 func (p *provider) generateDomainDeviceFilesystems(config *vm.Config, dom *libvirtxml.Domain) error {
 	if dom.Devices == nil {
@@ -6,6 +6,7 @@ package libvirt
 import (
 	"testing"
 
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	vm "github.com/hashicorp/nomad-driver-virt/internal/shared"
 	"github.com/hashicorp/nomad-driver-virt/storage"
 	"github.com/hashicorp/nomad-driver-virt/virt/net"
@@ -96,6 +97,7 @@ func Test_generateDomainDeviceDisks(t *testing.T) {
 func Test_generateDomainDeviceInterfaces(t *testing.T) {
 	testCases := []struct {
 		desc    string
+		allocID string
 		configs net.NetworkInterfacesConfig
 		result  []libvirtxml.DomainInterface
 	}{
@@ -145,12 +147,267 @@ func Test_generateDomainDeviceInterfaces(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "isolation",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Isolation: &net.NetworkInterfaceIsolationConfig{
+						Device:  "nvt12345678",
+						Address: "169.254.1.2/30",
+						Gateway: "169.254.1.1",
+					},
+					MAC: "52:54:00:12:34:56",
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					MAC: &libvirtxml.DomainInterfaceMAC{
+						Address: "52:54:00:12:34:56",
+					},
+					Source: &libvirtxml.DomainInterfaceSource{
+						Direct: &libvirtxml.DomainInterfaceSourceDirect{
+							Dev:  "nvt12345678",
+							Mode: "bridge",
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "bridge with hardware address",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+					},
+					MAC: "52:54:00:65:43:21",
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					MAC: &libvirtxml.DomainInterfaceMAC{
+						Address: "52:54:00:65:43:21",
+					},
+					Source: &libvirtxml.DomainInterfaceSource{
+						Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+							Bridge: "virbr0",
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "openvswitch access port",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{
+						Name: "ovsbr0",
+						OpenVSwitch: &net.NetworkInterfaceOpenVSwitchConfig{
+							VLAN:        10,
+							InterfaceID: "09b11c53-8b5c-4eeb-8f00-d84eaa0aaa4f",
+						},
+					},
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+							Bridge: "ovsbr0",
+						},
+					},
+					VirtualPort: &libvirtxml.DomainInterfaceVirtualPort{
+						Params: &libvirtxml.DomainInterfaceVirtualPortParams{
+							OpenVSwitch: &libvirtxml.DomainInterfaceVirtualPortParamsOpenVSwitch{
+								InterfaceID: "09b11c53-8b5c-4eeb-8f00-d84eaa0aaa4f",
+							},
+						},
+					},
+					VLan: &libvirtxml.DomainInterfaceVLan{
+						Tags: []libvirtxml.DomainInterfaceVLanTag{{ID: 10}},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "openvswitch trunk port",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{
+						Name: "ovsbr0",
+						OpenVSwitch: &net.NetworkInterfaceOpenVSwitchConfig{
+							VLAN:  10,
+							Trunk: []int{20, 30},
+						},
+					},
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+							Bridge: "ovsbr0",
+						},
+					},
+					VirtualPort: &libvirtxml.DomainInterfaceVirtualPort{
+						Params: &libvirtxml.DomainInterfaceVirtualPortParams{
+							OpenVSwitch: &libvirtxml.DomainInterfaceVirtualPortParamsOpenVSwitch{},
+						},
+					},
+					VLan: &libvirtxml.DomainInterfaceVLan{
+						Trunk: "yes",
+						Tags: []libvirtxml.DomainInterfaceVLanTag{
+							{ID: 10, NativeMode: "untagged"},
+							{ID: 20},
+							{ID: 30},
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "network",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Network: &net.NetworkInterfaceVirtualNetworkConfig{
+						Name: "routed",
+					},
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						Network: &libvirtxml.DomainInterfaceSourceNetwork{
+							Network: "routed",
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "user passt",
+			configs: net.NetworkInterfacesConfig{
+				{
+					User: &net.NetworkInterfaceUserConfig{
+						Backend: net.UserBackendPasst,
+						Forwards: []*net.UserPortForward{
+							{Protocol: net.PortProtocolTCP, Address: "192.168.1.10", HostPort: 25022, GuestPort: 22},
+							{Protocol: net.PortProtocolUDP, HostPort: 25053, GuestPort: 53},
+						},
+					},
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						User: &libvirtxml.DomainInterfaceSourceUser{},
+					},
+					Backend: &libvirtxml.DomainInterfaceBackend{
+						Type: "passt",
+					},
+					PortForward: []libvirtxml.DomainInterfaceSourcePortForward{
+						{
+							Proto:   "tcp",
+							Address: "192.168.1.10",
+							Ranges:  []libvirtxml.DomainInterfaceSourcePortForwardRange{{Start: 25022, To: 22}},
+						},
+						{
+							Proto:  "udp",
+							Ranges: []libvirtxml.DomainInterfaceSourcePortForwardRange{{Start: 25053, To: 53}},
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc: "user slirp",
+			configs: net.NetworkInterfacesConfig{
+				{
+					User: &net.NetworkInterfaceUserConfig{
+						Backend: net.UserBackendSlirp,
+					},
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						User: &libvirtxml.DomainInterfaceSourceUser{},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
+		{
+			desc:    "derived hardware address",
+			allocID: "0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11",
+			configs: net.NetworkInterfacesConfig{
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+					},
+				},
+				{
+					Bridge: &net.NetworkInterfaceBridgeConfig{
+						Name: "virbr1",
+					},
+					MAC: "52:54:00:65:43:21",
+				},
+			},
+			result: []libvirtxml.DomainInterface{
+				{
+					MAC: &libvirtxml.DomainInterfaceMAC{
+						Address: net.DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", 0),
+					},
+					Source: &libvirtxml.DomainInterfaceSource{
+						Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+							Bridge: "virbr0",
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+				{
+					MAC: &libvirtxml.DomainInterfaceMAC{
+						Address: "52:54:00:65:43:21",
+					},
+					Source: &libvirtxml.DomainInterfaceSource{
+						Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+							Bridge: "virbr1",
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: defaultInterfaceModel,
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
 			p, _ := testNew(t, overrideFs(defaultArch, MountFs9p))
-			config := &vm.Config{NetworkInterfaces: tc.configs}
+			config := &vm.Config{NetworkInterfaces: tc.configs, AllocID: tc.allocID, TaskName: "web"}
 			dom := &libvirtxml.Domain{}
 			must.NoError(t, p.generateDomainDeviceInterfaces(config, dom))
 			must.Eq(t, tc.result, dom.Devices.Interfaces)
@@ -158,6 +415,105 @@ func Test_generateDomainDeviceInterfaces(t *testing.T) {
 	}
 }
 
+func Test_generateDomainDeviceInterfaces_session(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		iface  *net.NetworkInterfaceConfig
+		errMsg string
+	}{
+		{
+			desc:  "user",
+			iface: &net.NetworkInterfaceConfig{User: &net.NetworkInterfaceUserConfig{Backend: net.UserBackendPasst}},
+		},
+		{
+			desc:  "bridge",
+			iface: &net.NetworkInterfaceConfig{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"}},
+		},
+		{
+			desc:   "macvtap",
+			iface:  &net.NetworkInterfaceConfig{Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0"}},
+			errMsg: "macvtap not supported in session mode",
+		},
+		{
+			desc:   "network",
+			iface:  &net.NetworkInterfaceConfig{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "default"}},
+			errMsg: "network not supported in session mode",
+		},
+		{
+			desc: "bridge ports",
+			iface: &net.NetworkInterfaceConfig{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+					},
+				},
+			},
+			errMsg: "bridge ports and policies not supported in session mode",
+		},
+		{
+			desc: "bridge egress ip",
+			iface: &net.NetworkInterfaceConfig{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						EgressIP: "192.168.1.10",
+					},
+				},
+			},
+			errMsg: "bridge egress_ip not supported in session mode",
+		},
+		{
+			desc: "bridge openvswitch",
+			iface: &net.NetworkInterfaceConfig{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name:        "ovsbr0",
+					OpenVSwitch: &net.NetworkInterfaceOpenVSwitchConfig{},
+				},
+			},
+			errMsg: "openvswitch not supported in session mode",
+		},
+		{
+			desc: "bandwidth",
+			iface: &net.NetworkInterfaceConfig{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Inbound: &net.NetworkInterfaceBandwidthConfig{Average: 1000},
+					},
+				},
+			},
+			errMsg: "bandwidth limits not supported in session mode",
+		},
+		{
+			desc: "dhcp lease discovery",
+			iface: &net.NetworkInterfaceConfig{
+				Bridge:    &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+				Discovery: []net.DiscoveryStrategy{net.DiscoveryStrategyDHCPLease},
+			},
+			errMsg: `"dhcp_lease" discovery not supported in session mode`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := &provider{session: true, bandwidthFromMBits: true}
+			config := &vm.Config{NetworkInterfaces: net.NetworkInterfacesConfig{tc.iface}, MBits: 100}
+			dom := &libvirtxml.Domain{}
+
+			err := p.generateDomainDeviceInterfaces(config, dom)
+			if tc.errMsg == "" {
+				must.NoError(t, err)
+				must.Nil(t, dom.Devices.Interfaces[0].Bandwidth)
+				return
+			}
+
+			must.ErrorIs(t, err, errs.ErrNotSupported)
+			must.ErrorContains(t, err, "network_interface[1]: "+tc.errMsg)
+		})
+	}
+}
+
 func Test_generateDomainDeviceFilesystems(t *testing.T) {
 	testCases := []struct {
 		desc    string
@@ -316,3 +672,85 @@ func Test_generateDomainDeviceFilesystems(t *testing.T) {
 		})
 	}
 }
+
+func Test_interfaceBandwidth(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	limited := &net.NetworkInterfaceConfig{
+		Bridge: &net.NetworkInterfaceBridgeConfig{
+			Name: "virbr0",
+			NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+				Inbound: &net.NetworkInterfaceBandwidthConfig{Average: 1000, Peak: 2000, Burst: 512},
+			},
+		},
+	}
+	unlimited := &net.NetworkInterfaceConfig{
+		Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0"},
+	}
+
+	t.Run("interface limits", func(t *testing.T) {
+		p := &provider{}
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Inbound: &libvirtxml.DomainInterfaceBandwidthParams{
+				Average: intPtr(1000),
+				Peak:    intPtr(2000),
+				Burst:   intPtr(512),
+			},
+		}, p.interfaceBandwidth(&vm.Config{MBits: 100}, limited))
+		must.Nil(t, p.interfaceBandwidth(&vm.Config{MBits: 100}, unlimited))
+
+		network := &net.NetworkInterfaceConfig{
+			Network: &net.NetworkInterfaceVirtualNetworkConfig{
+				Name: "default",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					Outbound: &net.NetworkInterfaceBandwidthConfig{Average: 500},
+				},
+			},
+		}
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Outbound: &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(500)},
+		}, p.interfaceBandwidth(&vm.Config{MBits: 100}, network))
+	})
+
+	t.Run("allocated bandwidth", func(t *testing.T) {
+		p := &provider{bandwidthFromMBits: true}
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Inbound: &libvirtxml.DomainInterfaceBandwidthParams{
+				Average: intPtr(1000),
+				Peak:    intPtr(2000),
+				Burst:   intPtr(512),
+			},
+			Outbound: &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(12500)},
+		}, p.interfaceBandwidth(&vm.Config{MBits: 100}, limited))
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Inbound:  &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(12500)},
+			Outbound: &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(12500)},
+		}, p.interfaceBandwidth(&vm.Config{MBits: 100}, unlimited))
+		must.Nil(t, p.interfaceBandwidth(&vm.Config{}, unlimited))
+
+		user := &net.NetworkInterfaceConfig{User: &net.NetworkInterfaceUserConfig{}}
+		must.Nil(t, p.interfaceBandwidth(&vm.Config{MBits: 100}, user))
+	})
+
+	t.Run("allocated bandwidth split", func(t *testing.T) {
+		p := &provider{bandwidthFromMBits: true}
+		user := &net.NetworkInterfaceConfig{User: &net.NetworkInterfaceUserConfig{}}
+		config := &vm.Config{
+			MBits:             100,
+			NetworkInterfaces: net.NetworkInterfacesConfig{limited, unlimited, user},
+		}
+
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Inbound: &libvirtxml.DomainInterfaceBandwidthParams{
+				Average: intPtr(1000),
+				Peak:    intPtr(2000),
+				Burst:   intPtr(512),
+			},
+			Outbound: &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(6250)},
+		}, p.interfaceBandwidth(config, limited))
+		must.Eq(t, &libvirtxml.DomainInterfaceBandwidth{
+			Inbound:  &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(12500)},
+			Outbound: &libvirtxml.DomainInterfaceBandwidthParams{Average: intPtr(6250)},
+		}, p.interfaceBandwidth(config, unlimited))
+	})
+}
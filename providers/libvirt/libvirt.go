@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	vm "github.com/hashicorp/nomad-driver-virt/internal/shared"
@@ -43,6 +44,10 @@ const (
 	// URI for running in test mode
 	TestURI = "test:///default"
 
+	// sessionURIPath is the path of the URIs connecting to the per-user
+	// session daemon, such as "qemu:///session".
+	sessionURIPath = "/session"
+
 	// Known domain states
 	DomainRunning     = "running"
 	DomainNoState     = "unknown"
@@ -87,6 +92,19 @@ var (
 	}
 )
 
+// sessionUnavailableFeatures are the features which require privileges on
+// the host, so are not available when connected to the session daemon.
+var sessionUnavailableFeatures = []string{
+	"bandwidth",
+	"dhcp_lease",
+	"dns_aliases",
+	"ipam",
+	"isolation",
+	"macvtap",
+	"network_filter",
+	"openvswitch",
+}
+
 type provider struct {
 	ctx              context.Context
 	uri              string
@@ -110,6 +128,14 @@ type provider struct {
 	// and remove the read-only option.
 	insecureReadonlyMounts bool
 
+	// bandwidthFromMBits limits the traffic of network interfaces which do not
+	// set their own bandwidth to the network bandwidth allocated to the task.
+	bandwidthFromMBits bool
+
+	// session is set when connected to the per-user session daemon, where
+	// the features requiring privileges on the host are not available.
+	session bool
+
 	availableMountFsOverride map[string]struct{} // used for testing
 }
 
@@ -130,6 +156,8 @@ func (p *provider) Copy(ctx context.Context) *provider {
 		password:               p.password,
 		libvirtVersion:         p.libvirtVersion,
 		insecureReadonlyMounts: p.insecureReadonlyMounts,
+		bandwidthFromMBits:     p.bandwidthFromMBits,
+		session:                p.session,
 	}
 	dCopy.storage = p.storage.Copy(ctx, dCopy)
 	dCopy.networking = p.networking.Copy(dCopy)
@@ -161,6 +189,21 @@ func WithConfig(c *Config) Option {
 		if c.AllowInsecureMounts {
 			p.insecureReadonlyMounts = true
 		}
+		if c.NetworkFilter != "" {
+			p.networking.SetFilterBackend(c.NetworkFilter)
+		}
+		if len(c.Discovery) > 0 {
+			p.networking.SetDiscoveryStrategies(c.Discovery)
+		}
+		if timeout, err := time.ParseDuration(c.DiscoveryTimeout); err == nil && timeout > 0 {
+			p.networking.SetDiscoveryTimeout(timeout)
+		}
+		if c.BandwidthFromMBits {
+			p.bandwidthFromMBits = true
+		}
+		if len(c.ManagedNetworks) > 0 {
+			p.networking.SetManagedNetworks(c.ManagedNetworks)
+		}
 	}
 }
 
@@ -246,6 +289,9 @@ func New(ctx context.Context, logger hclog.Logger, opt ...Option) *provider {
 		opt(p)
 	}
 
+	p.session = isSessionURI(p.uri)
+	p.networking.SetSession(p.session)
+
 	go p.monitorCtx()
 
 	return p
@@ -628,7 +674,11 @@ func (p *provider) GetNetworkInterfaces(name string) ([]vm.NetworkInterface, err
 	for i, iface := range dxml.Devices.Interfaces {
 		interfaces[i] = vm.NetworkInterface{}
 
-		if iface.Source != nil && iface.Source.Bridge != nil {
+		switch {
+		case iface.Source == nil:
+		case iface.Source.Network != nil:
+			interfaces[i].NetworkName = iface.Source.Network.Network
+		case iface.Source.Bridge != nil:
 			if netName, ok := bridgeNetworks[iface.Source.Bridge.Bridge]; ok {
 				interfaces[i].NetworkName = netName
 			} else {
@@ -719,6 +769,13 @@ func (p *provider) Fingerprint() (map[string]*structs.Attribute, error) {
 		"driver":                  structs.NewStringAttribute(driver),
 		"driver.version":          structs.NewIntAttribute(int64(driverVersion), ""),
 		"driver.version.readable": structs.NewStringAttribute(computeVersion(driverVersion)),
+		"session":                 structs.NewBoolAttribute(p.session),
+	}
+
+	// Populate the attributes mapping with the features which require
+	// privileges on the host, so are not available in session mode.
+	if p.session {
+		attrs["session.unavailable"] = structs.NewStringAttribute(strings.Join(sessionUnavailableFeatures, ","))
 	}
 
 	// Add any fingerprint information from the networking subsystem
@@ -929,6 +986,28 @@ func (p *provider) LookupNetworkByName(name string) (shims.ConnectNetwork, error
 	return conn.LookupNetworkByName(name)
 }
 
+// NetworkCreateXML creates and starts a transient network
+// NOTE: caller is responsible to free result
+func (p *provider) NetworkCreateXML(xmlConfig string) (shims.ConnectNetwork, error) {
+	conn, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.NetworkCreateXML(xmlConfig)
+}
+
+// LookupDomainByName looks up a domain by its name
+// NOTE: caller is responsible to free result
+func (p *provider) LookupDomainByName(name string) (shims.ConnectDomain, error) {
+	conn, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.LookupDomainByName(name)
+}
+
 // GetAllDomains returns the list of all active domains.
 func (p *provider) GetAllDomains() ([]string, error) {
 	conn, err := p.connection()
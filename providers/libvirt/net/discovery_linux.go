@@ -0,0 +1,560 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-set"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	lv "libvirt.org/go/libvirt"
+)
+
+// errNoDiscoveryStrategy is returned when none of the discovery strategies
+// configured for an interface can be used to discover its addresses.
+var errNoDiscoveryStrategy = errors.New("no usable address discovery strategy")
+
+// discoveryTarget describes the VM network interface whose addresses are
+// being discovered.
+type discoveryTarget struct {
+	vmName   string
+	hostname string
+
+	// uniqueHostname is set when the hostname is not shared with any other
+	// VM, so it can be used to attribute DHCPv6 leases.
+	uniqueHostname bool
+
+	// device is the host device the interface is attached to, which is the
+	// bridge or the lower device of a macvtap interface.
+	device string
+
+	// hostDevices are the other host devices the interface can be reached
+	// through, such as the macvlan devices sharing the lower device of a
+	// macvtap interface.
+	hostDevices []string
+
+	// hwaddrs are the hardware addresses which may belong to the interface.
+	// Strategies which require the exact address of the interface are only
+	// used when a single address is provided.
+	hwaddrs []string
+
+	// network is the libvirt network providing the bridge. It is nil when
+	// the bridge is not managed by libvirt.
+	network     shims.ConnectNetwork
+	networkName string
+
+	netConfig *net.NetworkInterfaceNetworkConfig
+}
+
+// discoveryResult contains the addresses discovered for an interface. The
+// DHCP leases are only set when the addresses were discovered using the DHCP
+// server of a libvirt network, so they can be reserved.
+type discoveryResult struct {
+	addrs     *net.InterfaceAddresses
+	ipv4Lease *lv.NetworkDHCPLease
+	ipv6Lease *lv.NetworkDHCPLease
+}
+
+// discoveryStrategy is a method of discovering the addresses of a single VM
+// network interface. Strategies are polled in order until one reports its
+// discovery as complete, or the discovery timeout is reached.
+type discoveryStrategy interface {
+	// name returns the name of the strategy.
+	name() net.DiscoveryStrategy
+
+	// discover performs a single discovery attempt. The returned result may
+	// be partial, such as when the address of only a single address family
+	// has been discovered, in which case complete is false.
+	discover() (result *discoveryResult, complete bool)
+}
+
+// interfaceDiscovery returns the ordered list of discovery strategies of the
+// network interface. The strategies of the interface take precedence over
+// those configured for the controller, which take precedence over the
+// defaults for the interface type.
+func (c *Controller) interfaceDiscovery(netInterface *net.NetworkInterfaceConfig) []net.DiscoveryStrategy {
+	switch {
+	case len(netInterface.Discovery) > 0:
+		return netInterface.Discovery
+	case len(c.discovery) > 0:
+		return c.discovery
+	case netInterface.Network != nil,
+		netInterface.Bridge != nil && netInterface.Bridge.OpenVSwitch == nil && !c.session:
+		return defaultBridgeDiscoveryStrategies
+	default:
+		return defaultDiscoveryStrategies
+	}
+}
+
+// discoveryStrategies builds the named strategies which can be used to
+// discover the addresses of the target. Strategies which can not be used,
+// such as DHCP lease discovery on a bridge not managed by libvirt, are
+// skipped.
+func (c *Controller) discoveryStrategies(ctx context.Context, target *discoveryTarget,
+	names []net.DiscoveryStrategy) []discoveryStrategy {
+
+	var mac stdnet.HardwareAddr
+	if len(target.hwaddrs) == 1 {
+		mac, _ = stdnet.ParseMAC(target.hwaddrs[0])
+	}
+
+	strategies := make([]discoveryStrategy, 0, len(names))
+	for _, name := range names {
+		skip := func(reason string) {
+			c.logger.Debug("skipping address discovery strategy", "domain", target.vmName, "device", target.device,
+				"strategy", name, "reason", reason)
+		}
+
+		switch name {
+		case net.DiscoveryStrategyStatic:
+			addrs := target.netConfig.StaticAddresses()
+			if addrs == nil {
+				skip("no static addresses configured")
+				continue
+			}
+			strategies = append(strategies, &staticStrategy{addrs: addrs})
+
+		case net.DiscoveryStrategyDHCPLease:
+			if target.network == nil {
+				skip("bridge is not managed by libvirt")
+				continue
+			}
+
+			wantIPv4, wantIPv6 := dhcpFamilies(target.network)
+			strategies = append(strategies, &dhcpLeaseStrategy{
+				logger:      c.logger,
+				network:     target.network,
+				networkName: target.networkName,
+				hostname:    target.hostname,
+				hwaddrs:     target.hwaddrs,
+				wantIPv4:    wantIPv4,
+				wantIPv6:    wantIPv6,
+
+				uniqueHostname: target.uniqueHostname,
+			})
+
+		case net.DiscoveryStrategyGuestAgent:
+			if mac == nil {
+				skip("unable to correlate hardware address")
+				continue
+			}
+			strategies = append(strategies, &guestAgentStrategy{
+				logger:  c.logger,
+				netConn: c.netConn,
+				vmName:  target.vmName,
+				mac:     mac,
+			})
+
+		case net.DiscoveryStrategyARP:
+			if mac == nil {
+				skip("unable to correlate hardware address")
+				continue
+			}
+			if c.arp == nil {
+				skip("ARP discoverer unavailable")
+				continue
+			}
+
+			// The ARP discoverer only uses the name of the interface to match
+			// neighbor entries, so the interfaces do not need to be looked
+			// up.
+			var (
+				arpChs []<-chan stdnet.IP
+				arpErr error
+			)
+			for _, device := range append([]string{target.device}, target.hostDevices...) {
+				arpCh, err := c.arp.Discover(ctx, &stdnet.Interface{Name: device}, mac)
+				if err != nil {
+					arpErr = err
+					continue
+				}
+				arpChs = append(arpChs, arpCh)
+			}
+			if len(arpChs) == 0 {
+				skip(arpErr.Error())
+				continue
+			}
+			strategies = append(strategies, &arpStrategy{chs: arpChs})
+
+		default:
+			skip("unknown strategy")
+		}
+	}
+
+	return strategies
+}
+
+// discoverAddresses discovers the addresses of the target using the named
+// strategies. The strategies are polled in order until one completes its
+// discovery. If the timeout is reached, the partial result of the first
+// strategy which discovered any address is returned, as a guest may not
+// configure every address family.
+func (c *Controller) discoverAddresses(target *discoveryTarget, names []net.DiscoveryStrategy) (*discoveryResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.discoveryTimeout)
+	defer cancel()
+
+	strategies := c.discoveryStrategies(ctx, target, names)
+	if len(strategies) == 0 {
+		return nil, errNoDiscoveryStrategy
+	}
+
+	// When only the DHCP leases of a libvirt network are waited on, discovery
+	// is attempted as soon as the leases change rather than polling libvirt
+	// for them. The watch is shared by all discoveries on the network.
+	interval := c.dhcpLeaseDiscoveryInterval
+	leaseCh := c.watchLeases(ctx, target, strategies)
+	if leaseCh != nil {
+		interval = c.dhcpLeaseFallbackInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var partial *discoveryResult
+	for {
+		// If we do not log, the driver and Nomad seem to stall from the user
+		// perspective, which might be off-putting. Providing some debug entry
+		// while performing this "long-lived" process should help operators
+		// understand what is happening.
+		c.logger.Debug("attempting address discovery", "domain", target.vmName, "device", target.device,
+			"hwaddrs", target.hwaddrs)
+
+		var roundPartial *discoveryResult
+		for _, strategy := range strategies {
+			result, complete := strategy.discover()
+			if result == nil {
+				continue
+			}
+
+			if complete {
+				c.logger.Debug("discovered interface address", "domain", target.vmName, "device", target.device,
+					"strategy", strategy.name(), "ipv4", result.addrs.IPv4, "ipv6", result.addrs.IPv6)
+				return result, nil
+			}
+
+			if roundPartial == nil {
+				roundPartial = result
+			}
+		}
+		if roundPartial != nil {
+			partial = roundPartial
+		}
+
+		select {
+		case <-ticker.C:
+		case _, ok := <-leaseCh:
+			// The leases can no longer be watched, so revert to polling.
+			if !ok {
+				leaseCh = nil
+				ticker.Reset(c.dhcpLeaseDiscoveryInterval)
+			}
+		case <-ctx.Done():
+			if partial != nil {
+				c.logger.Warn("timeout reached discovering addresses for all address families",
+					"domain", target.vmName, "device", target.device,
+					"ipv4_found", partial.addrs.IPv4 != "", "ipv6_found", partial.addrs.IPv6 != "")
+				return partial, nil
+			}
+			return nil, fmt.Errorf("timeout reached discovering address for %q", target.hostname)
+		}
+	}
+}
+
+// watchLeases returns a channel signaling the changes of the DHCP leases of
+// the target network when the DHCP lease strategy is the only strategy used.
+// A nil channel is returned when the leases
+// can not be watched, in which case the strategies are polled.
+func (c *Controller) watchLeases(ctx context.Context, target *discoveryTarget,
+	strategies []discoveryStrategy) <-chan struct{} {
+
+	if c.leases == nil || target.network == nil {
+		return nil
+	}
+
+	for _, strategy := range strategies {
+		if strategy.name() != net.DiscoveryStrategyDHCPLease {
+			return nil
+		}
+	}
+
+	ch, err := c.leases.Watch(ctx, target.device)
+	if err != nil {
+		c.logger.Debug("unable to watch DHCP leases, polling instead", "domain", target.vmName,
+			"device", target.device, "error", err)
+		return nil
+	}
+
+	return ch
+}
+
+// staticStrategy uses the addresses of the interface network configuration.
+type staticStrategy struct {
+	addrs *net.InterfaceAddresses
+}
+
+func (s *staticStrategy) name() net.DiscoveryStrategy { return net.DiscoveryStrategyStatic }
+
+func (s *staticStrategy) discover() (*discoveryResult, bool) {
+	return &discoveryResult{addrs: s.addrs}, true
+}
+
+// dhcpLeaseStrategy identifies the leases assigned to the VM on a libvirt
+// network for each address family the network provides DHCP for. DHCPv6
+// leases do not always include the MAC address of the client, in which case
+// the lease is attributed using the client DUID or a unique hostname.
+type dhcpLeaseStrategy struct {
+	logger      hclog.Logger
+	network     shims.ConnectNetwork
+	networkName string
+	hostname    string
+	hwaddrs     []string
+	wantIPv4    bool
+	wantIPv6    bool
+
+	uniqueHostname bool
+}
+
+func (d *dhcpLeaseStrategy) name() net.DiscoveryStrategy { return net.DiscoveryStrategyDHCPLease }
+
+func (d *dhcpLeaseStrategy) discover() (*discoveryResult, bool) {
+	// Lookup the DHCP leases of the network. If we receive any error, log and
+	// try again. If it is transient error, we will find the information on
+	// the next try, otherwise the timeout acts as our retry cutoff.
+	dhcpLeases, err := d.network.GetDHCPLeases()
+	if err != nil {
+		d.logger.Warn("failed to lookup DHCP leases", "network_name", d.networkName, "error", err)
+		return nil, false
+	}
+
+	macs := set.From(d.hwaddrs)
+	ipv4Matches := []lv.NetworkDHCPLease{}
+	ipv6Matches := []lv.NetworkDHCPLease{}
+
+	// Gather all matching leases
+	for _, lease := range dhcpLeases {
+		// Check if lease matches any available interfaces on the domain.
+		if !d.ownsLease(macs, lease) {
+			continue
+		}
+
+		// Check if the hostname is set, and matches
+		if lease.Hostname != "" && lease.Hostname != d.hostname {
+			continue
+		}
+
+		// Only want to add leases that are still valid.
+		if lease.ExpiryTime.Before(time.Now()) {
+			continue
+		}
+
+		d.logger.Debug("DHCP lease detected", "hostname", d.hostname, "network_name", d.networkName,
+			"hwaddrs", d.hwaddrs, "lease", lease)
+
+		if lease.Type == lv.IP_ADDR_TYPE_IPV6 {
+			ipv6Matches = append(ipv6Matches, lease)
+		} else {
+			ipv4Matches = append(ipv4Matches, lease)
+		}
+	}
+
+	ipv4Lease, ipv6Lease := latestLease(ipv4Matches), latestLease(ipv6Matches)
+	if ipv4Lease == nil && ipv6Lease == nil {
+		return nil, false
+	}
+
+	result := &discoveryResult{
+		addrs:     &net.InterfaceAddresses{},
+		ipv4Lease: ipv4Lease,
+		ipv6Lease: ipv6Lease,
+	}
+	if ipv4Lease != nil {
+		result.addrs.IPv4 = ipv4Lease.IPaddr
+	}
+	if ipv6Lease != nil {
+		result.addrs.IPv6 = ipv6Lease.IPaddr
+	}
+
+	return result, (ipv4Lease != nil || !d.wantIPv4) && (ipv6Lease != nil || !d.wantIPv6)
+}
+
+// ownsLease returns if the lease was assigned to one of the VM interfaces.
+// DHCPv6 leases without a MAC address are attributed using the link-layer
+// address within the client DUID. When the DUID does not include one, the
+// lease is only attributed using the hostname if it is unique to the VM, as
+// otherwise the lease may belong to another VM using the same hostname.
+func (d *dhcpLeaseStrategy) ownsLease(macs *set.Set[string], lease lv.NetworkDHCPLease) bool {
+	if lease.Mac != "" || lease.Type != lv.IP_ADDR_TYPE_IPV6 {
+		return macs.Contains(lease.Mac)
+	}
+
+	if hwaddr := duidHardwareAddr(lease.Clientid); hwaddr != "" {
+		return macs.Contains(hwaddr)
+	}
+
+	return d.uniqueHostname && lease.Hostname != "" && lease.Hostname == d.hostname
+}
+
+// DHCPv6 DUID types which include the link-layer address of the client, and
+// the hardware type of ethernet addresses, as defined in RFC 8415.
+const (
+	duidTypeLLT = 1
+	duidTypeLL  = 3
+
+	duidHardwareTypeEthernet = 1
+)
+
+// duidHardwareAddr returns the ethernet address included within the DHCPv6
+// client DUID, formatted as a colon separated hex string. An empty string is
+// returned when the DUID does not include one, such as DUID-EN and DUID-UUID.
+func duidHardwareAddr(duid string) string {
+	raw, err := hex.DecodeString(strings.ReplaceAll(duid, ":", ""))
+	if err != nil || len(raw) < 4 {
+		return ""
+	}
+
+	var lladdr []byte
+	switch binary.BigEndian.Uint16(raw[0:2]) {
+	case duidTypeLLT:
+		// The hardware type is followed by a 4 byte timestamp.
+		if len(raw) < 8 {
+			return ""
+		}
+		lladdr = raw[8:]
+	case duidTypeLL:
+		lladdr = raw[4:]
+	default:
+		return ""
+	}
+
+	if binary.BigEndian.Uint16(raw[2:4]) != duidHardwareTypeEthernet || len(lladdr) != 6 {
+		return ""
+	}
+
+	return stdnet.HardwareAddr(lladdr).String()
+}
+
+// guestAgentStrategy discovers the addresses the guest agent reports for the
+// interface with the hardware address.
+type guestAgentStrategy struct {
+	logger  hclog.Logger
+	netConn shims.Connect
+	vmName  string
+	mac     stdnet.HardwareAddr
+}
+
+func (g *guestAgentStrategy) name() net.DiscoveryStrategy { return net.DiscoveryStrategyGuestAgent }
+
+func (g *guestAgentStrategy) discover() (*discoveryResult, bool) {
+	dom, err := g.netConn.LookupDomainByName(g.vmName)
+	if err != nil {
+		g.logger.Debug("failed to lookup domain", "domain", g.vmName, "error", err)
+		return nil, false
+	}
+	defer dom.Free()
+
+	// An error is expected until the guest agent has started, or if it is
+	// not installed within the guest.
+	ifaces, err := dom.ListAllInterfaceAddresses(lv.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT)
+	if err != nil {
+		g.logger.Trace("guest agent addresses unavailable", "domain", g.vmName, "error", err)
+		return nil, false
+	}
+
+	for _, iface := range ifaces {
+		hwaddr, err := stdnet.ParseMAC(iface.Hwaddr)
+		if err != nil || !bytes.Equal(hwaddr, g.mac) {
+			continue
+		}
+
+		ips := make([]stdnet.IP, 0, len(iface.Addrs))
+		for _, addr := range iface.Addrs {
+			if ip := stdnet.ParseIP(addr.Addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+
+		if addrs := interfaceAddresses(ips...); addrs != nil {
+			return &discoveryResult{addrs: addrs}, true
+		}
+	}
+
+	return nil, false
+}
+
+// arpStrategy discovers the address of an interface using the neighbor
+// entries of the host devices which match the interface hardware address.
+// Each channel receives the addresses found on a single host device.
+type arpStrategy struct {
+	chs []<-chan stdnet.IP
+}
+
+func (a *arpStrategy) name() net.DiscoveryStrategy { return net.DiscoveryStrategyARP }
+
+func (a *arpStrategy) discover() (*discoveryResult, bool) {
+	for i := range a.chs {
+		if result := a.receive(i); result != nil {
+			return result, true
+		}
+	}
+
+	return nil, false
+}
+
+// receive returns the first usable address received on the channel at the
+// passed index without blocking. Closed channels are no longer received from.
+func (a *arpStrategy) receive(i int) *discoveryResult {
+	for {
+		select {
+		case ip, ok := <-a.chs[i]:
+			if !ok {
+				a.chs[i] = nil
+				return nil
+			}
+
+			if addrs := interfaceAddresses(ip); addrs != nil {
+				return &discoveryResult{addrs: addrs}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// interfaceAddresses returns the first usable address of each address family
+// within the passed list. Loopback, link-local and unspecified addresses are
+// ignored, as they can not be used to reach the VM. A nil value is returned
+// when no usable address is found.
+func interfaceAddresses(ips ...stdnet.IP) *net.InterfaceAddresses {
+	addrs := &net.InterfaceAddresses{}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+
+		switch {
+		case ip.To4() != nil && addrs.IPv4 == "":
+			addrs.IPv4 = ip.String()
+		case ip.To4() == nil && addrs.IPv6 == "":
+			addrs.IPv6 = ip.String()
+		}
+	}
+
+	if addrs.IPv4 == "" && addrs.IPv6 == "" {
+		return nil
+	}
+
+	return addrs
+}
@@ -0,0 +1,610 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"errors"
+	stdnet "net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/arp"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	arp_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/arp"
+	leases_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/leases"
+	libvirt_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers/libvirt"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+	"libvirt.org/go/libvirt"
+)
+
+func TestController_interfaceDiscovery(t *testing.T) {
+	controller := &Controller{}
+
+	must.Eq(t, defaultBridgeDiscoveryStrategies, controller.interfaceDiscovery(&net.NetworkInterfaceConfig{
+		Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+	}))
+	must.Eq(t, defaultDiscoveryStrategies, controller.interfaceDiscovery(&net.NetworkInterfaceConfig{
+		Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0"},
+	}))
+
+	// The strategies of the controller are used when the interface does not
+	// configure its own.
+	controller.SetDiscoveryStrategies([]net.DiscoveryStrategy{net.DiscoveryStrategyGuestAgent})
+	must.Eq(t, []net.DiscoveryStrategy{net.DiscoveryStrategyGuestAgent},
+		controller.interfaceDiscovery(&net.NetworkInterfaceConfig{
+			Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+		}))
+	must.Eq(t, []net.DiscoveryStrategy{net.DiscoveryStrategyARP},
+		controller.interfaceDiscovery(&net.NetworkInterfaceConfig{
+			Bridge:    &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+			Discovery: []net.DiscoveryStrategy{net.DiscoveryStrategyARP},
+		}))
+}
+
+func TestController_discoverAddresses(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		Active:     true,
+		BridgeName: "virbr0",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr0",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "192.168.122.58",
+				Hostname:   "nomad-0ea818bc",
+			},
+		},
+	}
+
+	newController := func(t *testing.T) *Controller {
+		return &Controller{
+			logger:                     hclog.NewNullLogger(),
+			netConn:                    &libvirt_mock.StaticConnect{},
+			arp:                        arp_mock.New(t),
+			dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+			discoveryTimeout:           100 * time.Millisecond,
+		}
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		target := &discoveryTarget{
+			vmName:      "nomad-0ea818bc",
+			hostname:    "nomad-0ea818bc",
+			device:      "virbr0",
+			hwaddrs:     []string{"52:54:00:1c:7c:14"},
+			network:     defaultNet,
+			networkName: "default",
+			netConfig:   &net.NetworkInterfaceNetworkConfig{Addresses: []string{"192.168.122.10/24"}},
+		}
+
+		result, err := newController(t).discoverAddresses(target, []net.DiscoveryStrategy{
+			net.DiscoveryStrategyDHCPLease,
+			net.DiscoveryStrategyStatic,
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.58"}, result.addrs)
+		must.NotNil(t, result.ipv4Lease)
+
+		result, err = newController(t).discoverAddresses(target, []net.DiscoveryStrategy{
+			net.DiscoveryStrategyStatic,
+			net.DiscoveryStrategyDHCPLease,
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.10"}, result.addrs)
+		must.Nil(t, result.ipv4Lease)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		controller := newController(t)
+		arpMock := arp_mock.New(t).Expect(arp_mock.Discover{
+			Device: "br0",
+			Hwaddr: "52:54:00:1c:7c:14",
+			Result: []stdnet.IP{stdnet.ParseIP("10.0.1.50")},
+		})
+		defer arpMock.AssertExpectations()
+		controller.arp = arpMock
+
+		// The bridge is not managed by libvirt, so DHCP lease discovery is
+		// skipped, and the guest agent is not available.
+		result, err := controller.discoverAddresses(&discoveryTarget{
+			vmName:   "nomad-0ea818bc",
+			hostname: "nomad-0ea818bc",
+			device:   "br0",
+			hwaddrs:  []string{"52:54:00:1c:7c:14"},
+		}, []net.DiscoveryStrategy{
+			net.DiscoveryStrategyDHCPLease,
+			net.DiscoveryStrategyGuestAgent,
+			net.DiscoveryStrategyARP,
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "10.0.1.50"}, result.addrs)
+	})
+
+	t.Run("watched leases", func(t *testing.T) {
+		controller := newController(t)
+		controller.dhcpLeaseDiscoveryInterval = time.Hour
+		controller.dhcpLeaseFallbackInterval = time.Hour
+		controller.discoveryTimeout = 5 * time.Second
+
+		leasesMock := leases_mock.New(t).Expect(leases_mock.Watch{Bridge: "virbr0", Changes: 1})
+		defer leasesMock.AssertExpectations()
+		controller.leases = leasesMock
+
+		// The leases are only looked up again once they have changed.
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Err: mock.MockTestErr},
+			libvirt_mock.GetDHCPLeases{},
+			libvirt_mock.GetDHCPLeases{Result: defaultNet.DhcpLeases},
+		)
+		defer network.AssertExpectations()
+
+		result, err := controller.discoverAddresses(&discoveryTarget{
+			vmName:      "nomad-0ea818bc",
+			hostname:    "nomad-0ea818bc",
+			device:      "virbr0",
+			hwaddrs:     []string{"52:54:00:1c:7c:14"},
+			network:     network,
+			networkName: "default",
+		}, []net.DiscoveryStrategy{net.DiscoveryStrategyDHCPLease})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.58"}, result.addrs)
+	})
+
+	t.Run("unwatched leases", func(t *testing.T) {
+		controller := newController(t)
+
+		leasesMock := leases_mock.New(t).Expect(leases_mock.Watch{Bridge: "virbr0", Err: mock.MockTestErr})
+		defer leasesMock.AssertExpectations()
+		controller.leases = leasesMock
+
+		// The leases are polled when they can not be watched.
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Err: mock.MockTestErr},
+			libvirt_mock.GetDHCPLeases{},
+			libvirt_mock.GetDHCPLeases{Result: defaultNet.DhcpLeases},
+		)
+		defer network.AssertExpectations()
+
+		result, err := controller.discoverAddresses(&discoveryTarget{
+			vmName:      "nomad-0ea818bc",
+			hostname:    "nomad-0ea818bc",
+			device:      "virbr0",
+			hwaddrs:     []string{"52:54:00:1c:7c:14"},
+			network:     network,
+			networkName: "default",
+		}, []net.DiscoveryStrategy{net.DiscoveryStrategyDHCPLease})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.58"}, result.addrs)
+	})
+
+	t.Run("no usable strategy", func(t *testing.T) {
+		_, err := newController(t).discoverAddresses(&discoveryTarget{
+			vmName:   "nomad-0ea818bc",
+			hostname: "nomad-0ea818bc",
+			device:   "br0",
+			hwaddrs:  []string{"52:54:00:1c:7c:14", "52:54:00:1c:7c:15"},
+		}, []net.DiscoveryStrategy{
+			net.DiscoveryStrategyStatic,
+			net.DiscoveryStrategyDHCPLease,
+			net.DiscoveryStrategyGuestAgent,
+			net.DiscoveryStrategyARP,
+		})
+		must.True(t, errors.Is(err, errNoDiscoveryStrategy))
+	})
+}
+
+func Test_guestAgentStrategy(t *testing.T) {
+	mac, err := stdnet.ParseMAC("aa:bb:cc:dd:ee:ff")
+	must.NoError(t, err)
+
+	strategy := &guestAgentStrategy{
+		logger:  hclog.NewNullLogger(),
+		netConn: &libvirt_mock.StaticConnect{},
+		vmName:  "nomad-0ea818bc",
+		mac:     mac,
+	}
+
+	result, complete := strategy.discover()
+	must.Nil(t, result)
+	must.False(t, complete)
+}
+
+func Test_arpStrategy(t *testing.T) {
+	ch := make(chan stdnet.IP, 2)
+	strategy := &arpStrategy{chs: []<-chan stdnet.IP{ch}}
+
+	result, complete := strategy.discover()
+	must.Nil(t, result)
+	must.False(t, complete)
+
+	// Unusable addresses are ignored.
+	ch <- stdnet.ParseIP("fe80::1")
+	ch <- stdnet.ParseIP("10.0.1.50")
+
+	result, complete = strategy.discover()
+	must.True(t, complete)
+	must.Eq(t, &net.InterfaceAddresses{IPv4: "10.0.1.50"}, result.addrs)
+
+	close(ch)
+	result, complete = strategy.discover()
+	must.Nil(t, result)
+	must.False(t, complete)
+
+	t.Run("host devices", func(t *testing.T) {
+		lowerCh, macvlanCh := make(chan stdnet.IP), make(chan stdnet.IP, 1)
+		strategy := &arpStrategy{chs: []<-chan stdnet.IP{lowerCh, macvlanCh}}
+
+		// The address is discovered on any of the host devices.
+		macvlanCh <- stdnet.ParseIP("10.0.1.50")
+		result, complete := strategy.discover()
+		must.True(t, complete)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "10.0.1.50"}, result.addrs)
+	})
+}
+
+func TestController_discoverAddresses_macvtap(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to create network devices")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("requires the ip utility")
+	}
+
+	// The lower device is one end of a veth pair. The guest is a macvlan
+	// device in bridge mode within its own network namespace, which is
+	// isolated from the lower device in the same way as a macvtap interface,
+	// and the host reaches it through a macvlan device of its own.
+	const (
+		lowerDevice  = "nvtlower0"
+		peerDevice   = "nvtpeer0"
+		hostDevice   = "nvtmvhost0"
+		guestDevice  = "nvtmvguest0"
+		guestNS      = "nvtguest0"
+		guestHwaddr  = "52:54:00:1c:7c:14"
+		guestAddress = "10.211.0.2"
+	)
+
+	ipCmd := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("ip", args...).CombinedOutput()
+		must.NoError(t, err, must.Sprintf("ip %v: %s", args, out))
+	}
+
+	t.Cleanup(func() {
+		_ = exec.Command("ip", "link", "del", lowerDevice).Run()
+		_ = exec.Command("ip", "netns", "del", guestNS).Run()
+	})
+
+	ipCmd("link", "add", lowerDevice, "type", "veth", "peer", "name", peerDevice)
+	ipCmd("link", "set", peerDevice, "up")
+	ipCmd("link", "set", lowerDevice, "up")
+	ipCmd("netns", "add", guestNS)
+	ipCmd("link", "add", guestDevice, "link", lowerDevice, "address", guestHwaddr, "type", "macvlan", "mode", "bridge")
+	ipCmd("link", "set", guestDevice, "netns", guestNS)
+	ipCmd("-n", guestNS, "addr", "add", guestAddress+"/24", "dev", guestDevice)
+	ipCmd("-n", guestNS, "link", "set", guestDevice, "up")
+	ipCmd("link", "add", hostDevice, "link", lowerDevice, "type", "macvlan", "mode", "bridge")
+	ipCmd("addr", "add", "10.211.0.1/24", "dev", hostDevice)
+	ipCmd("link", "set", hostDevice, "up")
+
+	devices, err := macvlanDevices(lowerDevice)
+	must.NoError(t, err)
+	must.Eq(t, []string{hostDevice}, devices)
+
+	// Traffic sent to the guest resolves its neighbor entry, which the host
+	// only holds on its macvlan device.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			if conn, err := stdnet.Dial("udp", guestAddress+":9"); err == nil {
+				_, _ = conn.Write([]byte("discover"))
+				conn.Close()
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	controller := &Controller{
+		logger:                     hclog.NewNullLogger(),
+		arp:                        arp.New(),
+		dhcpLeaseDiscoveryInterval: 100 * time.Millisecond,
+		discoveryTimeout:           3 * time.Second,
+	}
+
+	target := &discoveryTarget{
+		vmName:   "nomad-0ea818bc",
+		hostname: "nomad-0ea818bc",
+		device:   lowerDevice,
+		hwaddrs:  []string{guestHwaddr},
+	}
+
+	t.Run("lower device", func(t *testing.T) {
+		_, err := controller.discoverAddresses(target, []net.DiscoveryStrategy{net.DiscoveryStrategyARP})
+		must.Error(t, err)
+	})
+
+	t.Run("host macvlan device", func(t *testing.T) {
+		target.hostDevices = devices
+
+		result, err := controller.discoverAddresses(target, []net.DiscoveryStrategy{net.DiscoveryStrategyARP})
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: guestAddress}, result.addrs)
+	})
+}
+
+// discoverDHCPLeases discovers the leases of an interface using only the DHCP
+// lease strategy.
+func discoverDHCPLeases(t *testing.T, controller *Controller, network shims.ConnectNetwork, hostname, netName string,
+	hwaddrs []string) (*libvirt.NetworkDHCPLease, *libvirt.NetworkDHCPLease, error) {
+	t.Helper()
+
+	result, err := controller.discoverAddresses(&discoveryTarget{
+		vmName:         hostname,
+		hostname:       hostname,
+		uniqueHostname: true,
+		hwaddrs:        hwaddrs,
+		network:        network,
+		networkName:    netName,
+	}, []net.DiscoveryStrategy{net.DiscoveryStrategyDHCPLease})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result.ipv4Lease, result.ipv6Lease, nil
+}
+
+func Test_dhcpLeaseStrategy(t *testing.T) {
+	// Create out controller which has a mocked connection with identified
+	// networks and low discovery time durations, so the tests do not take ages
+	// to run.
+	controller := &Controller{
+		logger:                     hclog.NewNullLogger(),
+		netConn:                    &libvirt_mock.StaticConnect{},
+		dhcpLeaseDiscoveryInterval: 1 * time.Nanosecond,
+		discoveryTimeout:           100 * time.Microsecond,
+	}
+
+	defaultNet, lookupErr := controller.netConn.LookupNetworkByName("default")
+	must.NoError(t, lookupErr)
+	must.NotNil(t, defaultNet)
+	defer defaultNet.Free()
+
+	// Query for a domain that does not have a lease entry and ensure the
+	// timeout is triggered.
+	ipv4Lease, ipv6Lease, err := discoverDHCPLeases(t, controller, defaultNet, "non-existent-domain",
+		"default", []string{"00:00:00:00:00:00"})
+	must.ErrorContains(t, err, "timeout reached discovering address")
+	must.Nil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+
+	// Query for a domain which does have a lease.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "nomad-0ea818bc",
+		"default", []string{"52:54:00:1c:7c:14"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+	must.Eq(t, "192.168.122.58", ipv4Lease.IPaddr)
+	must.Eq(t, "52:54:00:1c:7c:14", ipv4Lease.Mac)
+
+	// Query for a domain which does have a lease using multiple MAC addresses.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "nomad-0ea818bc",
+		"default", []string{"11:11:11:11:11:11", "52:54:00:1c:7c:14", "22:22:22:22:22:22"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+	must.Eq(t, "192.168.122.58", ipv4Lease.IPaddr)
+	must.Eq(t, "52:54:00:1c:7c:14", ipv4Lease.Mac)
+
+	// Query for a domain with several matching leases.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "nomad-3edc43aa",
+		"default", []string{"11:22:33:44:55:66"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+	must.Eq(t, "192.168.122.65", ipv4Lease.IPaddr)
+	must.Eq(t, "11:22:33:44:55:66", ipv4Lease.Mac)
+
+	// Query for domain with matching expired lease.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "nomad-eabba892",
+		"default", []string{"66:55:44:33:22:11"})
+	must.ErrorContains(t, err, "timeout reached discovering address")
+	must.Nil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+
+	// Query for domain with matching MAC address only.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "different-hostname",
+		"default", []string{"52:54:00:1c:7c:14"})
+	must.ErrorContains(t, err, "timeout reached discovering address")
+	must.Nil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+
+	// Query for domain with matching MAC address and empty hostname on lease.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, defaultNet, "custom-hostname",
+		"default", []string{"11:22:11:22:11:22"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+	must.Eq(t, "192.168.122.99", ipv4Lease.IPaddr)
+	must.Eq(t, "11:22:11:22:11:22", ipv4Lease.Mac)
+}
+
+func Test_dhcpLeaseStrategy_dualStack(t *testing.T) {
+	controller := &Controller{
+		logger:                     hclog.NewNullLogger(),
+		dhcpLeaseDiscoveryInterval: 1 * time.Nanosecond,
+		discoveryTimeout:           100 * time.Microsecond,
+	}
+
+	dualStackNet := &libvirt_mock.StaticNetwork{
+		Name:       "dual",
+		Active:     true,
+		BridgeName: "virbr1",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "192.168.100.58",
+				Hostname:   "nomad-0ea818bc",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV6,
+				IPaddr:     "fd00:100::58",
+				Hostname:   "nomad-0ea818bc",
+				Clientid:   "00:04:c4:bb:51:75:73:7e:4e:0a:a1:1c:04:2f:57:f3:1c:1b",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "11:22:33:44:55:66",
+				IPaddr:     "192.168.100.65",
+				Hostname:   "nomad-3edc43aa",
+			},
+		},
+		XmlDesc: dualStackNetworkXML,
+	}
+
+	// Query for a domain which has a lease for both address families.
+	ipv4Lease, ipv6Lease, err := discoverDHCPLeases(t, controller, dualStackNet, "nomad-0ea818bc",
+		"dual", []string{"52:54:00:1c:7c:14"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.NotNil(t, ipv6Lease)
+	must.Eq(t, "192.168.100.58", ipv4Lease.IPaddr)
+	must.Eq(t, "fd00:100::58", ipv6Lease.IPaddr)
+
+	// Query for a domain which only has an IPv4 lease, which should be
+	// returned once the timeout has been reached.
+	ipv4Lease, ipv6Lease, err = discoverDHCPLeases(t, controller, dualStackNet, "nomad-3edc43aa",
+		"dual", []string{"11:22:33:44:55:66"})
+	must.NoError(t, err)
+	must.NotNil(t, ipv4Lease)
+	must.Nil(t, ipv6Lease)
+	must.Eq(t, "192.168.100.65", ipv4Lease.IPaddr)
+}
+
+func Test_dhcpLeaseStrategy_sharedHostname(t *testing.T) {
+	controller := &Controller{
+		logger:                     hclog.NewNullLogger(),
+		dhcpLeaseDiscoveryInterval: 1 * time.Nanosecond,
+		discoveryTimeout:           100 * time.Microsecond,
+	}
+
+	// Two VMs of the same task use the hostname configured within the task,
+	// and their DHCPv6 leases do not include a MAC address. The first VM
+	// uses a DUID-UUID while the second uses a DUID-LL which includes its
+	// MAC address.
+	dualStackNet := &libvirt_mock.StaticNetwork{
+		Name:       "dual",
+		Active:     true,
+		BridgeName: "virbr1",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "192.168.100.58",
+				Hostname:   "web",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV6,
+				IPaddr:     "fd00:100::58",
+				Hostname:   "web",
+				Clientid:   "00:04:c4:bb:51:75:73:7e:4e:0a:a1:1c:04:2f:57:f3:1c:1b",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:aa:bb:cc",
+				IPaddr:     "192.168.100.65",
+				Hostname:   "web",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(2 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV6,
+				IPaddr:     "fd00:100::65",
+				Hostname:   "web",
+				Clientid:   "00:03:00:01:52:54:00:aa:bb:cc",
+			},
+		},
+		XmlDesc: dualStackNetworkXML,
+	}
+
+	discover := func(vmName, hwaddr string) *discoveryResult {
+		result, err := controller.discoverAddresses(&discoveryTarget{
+			vmName:      vmName,
+			hostname:    "web",
+			hwaddrs:     []string{hwaddr},
+			network:     dualStackNet,
+			networkName: "dual",
+		}, []net.DiscoveryStrategy{net.DiscoveryStrategyDHCPLease})
+		must.NoError(t, err)
+		return result
+	}
+
+	// The DUID of the first VM does not identify it and the hostname is
+	// shared, so the IPv6 lease can not be attributed.
+	result := discover("web-0ea818bc", "52:54:00:1c:7c:14")
+	must.Eq(t, "192.168.100.58", result.ipv4Lease.IPaddr)
+	must.Nil(t, result.ipv6Lease)
+
+	// The second VM is identified using the MAC address within its DUID.
+	result = discover("web-3edc43aa", "52:54:00:aa:bb:cc")
+	must.Eq(t, "192.168.100.65", result.ipv4Lease.IPaddr)
+	must.NotNil(t, result.ipv6Lease)
+	must.Eq(t, "fd00:100::65", result.ipv6Lease.IPaddr)
+}
+
+func Test_duidHardwareAddr(t *testing.T) {
+	cases := []struct {
+		name string
+		duid string
+		exp  string
+	}{
+		{name: "llt", duid: "00:01:00:01:2c:8e:5b:1a:52:54:00:1c:7c:14", exp: "52:54:00:1c:7c:14"},
+		{name: "ll", duid: "00:03:00:01:52:54:00:1c:7c:14", exp: "52:54:00:1c:7c:14"},
+		{name: "uuid", duid: "00:04:c4:bb:51:75:73:7e:4e:0a:a1:1c:04:2f:57:f3:1c:1b"},
+		{name: "en", duid: "00:02:00:00:ab:11:9d:2a:1f:4e:61:2b:78:d3"},
+		{name: "non-ethernet", duid: "00:03:00:06:52:54:00:1c:7c:14"},
+		{name: "truncated", duid: "00:01:00:01"},
+		{name: "invalid", duid: "not-a-duid"},
+		{name: "empty"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			must.Eq(t, tc.exp, duidHardwareAddr(tc.duid))
+		})
+	}
+}
+
+func Test_interfaceAddresses(t *testing.T) {
+	must.Nil(t, interfaceAddresses())
+	must.Nil(t, interfaceAddresses(stdnet.ParseIP("127.0.0.1"), stdnet.ParseIP("fe80::1"), stdnet.IPv4zero))
+	must.Eq(t, &net.InterfaceAddresses{IPv4: "10.0.0.5", IPv6: "fd00::5"},
+		interfaceAddresses(stdnet.ParseIP("fd00::5"), stdnet.ParseIP("10.0.0.5"), stdnet.ParseIP("10.0.0.6")))
+}
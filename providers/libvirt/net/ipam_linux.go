@@ -0,0 +1,293 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	lv "libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+// ipamLock serializes the selection and reservation of addresses, so VMs
+// started concurrently are never assigned the same address. It is package
+// level as every controller copy shares the same libvirt networks.
+var ipamLock sync.Mutex
+
+func (c *Controller) VMAddressingBuild(req *net.VMAddressingBuildRequest) (*net.VMAddressingBuildResponse, error) {
+	if req == nil {
+		return nil, errors.New("net controller: no addressing request provided")
+	}
+
+	// Driver IPAM reserves addresses within the libvirt networks, which are
+	// not available in session mode.
+	if c.session && req.NetConfig.IPAM() {
+		return nil, fmt.Errorf("net controller: driver IPAM is %w in session mode", errs.ErrNotSupported)
+	}
+
+	resp := &net.VMAddressingBuildResponse{
+		Assignments: make([]*net.InterfaceAssignment, len(req.NetConfig)),
+	}
+
+	// The names of the DHCP host entries must be unique within a network, so
+	// only the first interface is reserved using the hostname of the VM,
+	// which is then handed to the VM by the DHCP server.
+	var named bool
+
+	for i, netInterface := range req.NetConfig {
+		if bridge := netInterface.Bridged(); bridge == nil || !bridge.IPAM {
+			continue
+		}
+
+		name := req.Hostname
+		if named {
+			name = fmt.Sprintf("%s-%d", req.Hostname, i+1)
+		}
+		named = true
+
+		assignment, teardownSpec, err := c.assignAddress(req, netInterface, name)
+		if err != nil {
+			// Remove any reservations already created, so a failed start does
+			// not leave anything behind on the host.
+			if _, teardownErr := c.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+				TeardownSpecs: resp.TeardownSpecs,
+			}); teardownErr != nil {
+				c.logger.Error("failed to teardown network configuration", "domain", req.VMName,
+					"error", teardownErr)
+			}
+			return nil, fmt.Errorf("network_interface[%d]: %w", i+1, err)
+		}
+
+		resp.Assignments[i] = assignment
+		resp.TeardownSpecs = append(resp.TeardownSpecs, teardownSpec)
+	}
+
+	return resp, nil
+}
+
+// assignAddress selects a free address from the DHCP range of the libvirt
+// network backing the bridged interface and reserves it for the hardware
+// address of the interface, using a DHCP host entry with the passed name.
+func (c *Controller) assignAddress(req *net.VMAddressingBuildRequest,
+	netInterface *net.NetworkInterfaceConfig, name string) (*net.InterfaceAssignment, *net.TeardownSpec, error) {
+
+	bridge := netInterface.Bridged()
+
+	// Interfaces attached by network name already identify the network, so
+	// it only needs to be discovered for interfaces attached by bridge name.
+	var (
+		networkName string
+		err         error
+	)
+	if netInterface.Network != nil {
+		networkName = netInterface.Network.Name
+	} else if networkName, err = c.networkNameFromBridgeName(bridge.Name); err != nil {
+		return nil, nil, fmt.Errorf("failed to discover network: %w", err)
+	}
+
+	network, err := c.netConn.LookupNetworkByName(networkName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup network: %w", err)
+	}
+	defer network.Free()
+
+	hwaddr := netInterface.MAC
+	if hwaddr == "" {
+		if hwaddr, err = net.GenerateHwaddr(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ipamLock.Lock()
+	defer ipamLock.Unlock()
+
+	addr, reservation, err := c.reuseReservation(network, hwaddr, name, req.UniqueHostname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if reservation == "" {
+		if !addr.IsValid() {
+			if addr, err = availableAddress(network); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		reservation, err = c.reserveIP(network, libvirtxml.NetworkDHCPHost{
+			IP:   addr.String(),
+			MAC:  hwaddr,
+			Name: name,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to reserve IP address %s: %w", addr, err)
+		}
+	}
+
+	addrs := &net.InterfaceAddresses{IPv4: addr.String()}
+	dnsHosts := c.registerDNSHosts(network, addrs, dnsHostnames(req.Hostname, bridge.DNSAliases))
+
+	c.logger.Debug("assigned interface address", "domain", req.VMName, "network", networkName,
+		"address", addr, "mac", hwaddr)
+
+	return &net.InterfaceAssignment{
+		MAC:       hwaddr,
+		Addresses: addrs,
+	}, &net.TeardownSpec{
+		Network:         networkName,
+		DHCPReservation: reservation,
+		DNSHosts:        dnsHosts,
+	}, nil
+}
+
+// reuseReservation handles the DHCP host entries of the network which were
+// left behind for the interface, for example when the client crashed before
+// the task was torn down. The hardware address of the interface is stable, so
+// such an entry would otherwise fail every attempt to start the task.
+//
+// An entry matching both the hardware address and name is reused as it is,
+// and is returned along with its address. Any other entry matching either is
+// removed, and the address of an entry matching the hardware address is
+// returned so it can be reserved again. Entries matching only the name are
+// only removed when the hostname is unique to the VM, as they may otherwise
+// belong to another VM using the same hostname.
+func (c *Controller) reuseReservation(network shims.ConnectNetwork, hwaddr, name string,
+	uniqueHostname bool) (netip.Addr, string, error) {
+
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		return netip.Addr{}, "", fmt.Errorf("failed to read network definition: %w", err)
+	}
+
+	var (
+		addr  netip.Addr
+		stale []libvirtxml.NetworkDHCPHost
+	)
+	for _, ip := range networkCfg.IPs {
+		if ip.Family == "ipv6" || ip.DHCP == nil {
+			continue
+		}
+
+		for _, host := range ip.DHCP.Hosts {
+			sameHwaddr := strings.EqualFold(host.MAC, hwaddr)
+			if !sameHwaddr && host.Name != name {
+				continue
+			}
+
+			hostAddr, err := netip.ParseAddr(host.IP)
+			if sameHwaddr && host.Name == name && err == nil {
+				entry, err := host.Marshal()
+				if err != nil {
+					return netip.Addr{}, "", err
+				}
+
+				c.logger.Debug("reusing dhcp reservation", "reservation", entry)
+				return hostAddr, entry, nil
+			}
+
+			if !sameHwaddr && !uniqueHostname {
+				return netip.Addr{}, "", fmt.Errorf("DHCP host entry %q is already reserved for %s",
+					name, host.MAC)
+			}
+
+			if sameHwaddr && err == nil {
+				addr = hostAddr
+			}
+			stale = append(stale, host)
+		}
+	}
+
+	for _, host := range stale {
+		entry, err := host.Marshal()
+		if err != nil {
+			return netip.Addr{}, "", err
+		}
+
+		c.logger.Debug("removing stale dhcp reservation", "reservation", entry)
+
+		if err := network.Update(lv.NETWORK_UPDATE_COMMAND_DELETE, lv.NETWORK_SECTION_IP_DHCP_HOST,
+			c.dhcpParentIndex(network, host.IP), entry,
+			lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG); err != nil {
+			return netip.Addr{}, "", fmt.Errorf("failed to remove stale DHCP host entry: %w", err)
+		}
+	}
+
+	return addr, "", nil
+}
+
+// availableAddress returns the first address within the IPv4 DHCP ranges of
+// the network which is not used by the network itself, a DHCP host entry or
+// an unexpired DHCP lease.
+func availableAddress(network shims.ConnectNetwork) (netip.Addr, error) {
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to read network definition: %w", err)
+	}
+
+	used := map[netip.Addr]struct{}{}
+	markUsed := func(s string) {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			used[addr] = struct{}{}
+		}
+	}
+
+	var ranges []libvirtxml.NetworkDHCPRange
+	for _, ip := range networkCfg.IPs {
+		if ip.Family == "ipv6" {
+			continue
+		}
+
+		markUsed(ip.Address)
+		if ip.DHCP == nil {
+			continue
+		}
+
+		ranges = append(ranges, ip.DHCP.Ranges...)
+		for _, host := range ip.DHCP.Hosts {
+			markUsed(host.IP)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return netip.Addr{}, errors.New("network has no IPv4 DHCP range")
+	}
+
+	leases, err := network.GetDHCPLeases()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to lookup DHCP leases: %w", err)
+	}
+	for _, lease := range leases {
+		if lease.ExpiryTime.After(time.Now()) {
+			markUsed(lease.IPaddr)
+		}
+	}
+
+	for _, r := range ranges {
+		start, err := netip.ParseAddr(r.Start)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to parse DHCP range start %q: %w", r.Start, err)
+		}
+		end, err := netip.ParseAddr(r.End)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to parse DHCP range end %q: %w", r.End, err)
+		}
+
+		for addr := start; addr.IsValid() && addr.Compare(end) <= 0; addr = addr.Next() {
+			if _, ok := used[addr]; !ok {
+				return addr, nil
+			}
+		}
+	}
+
+	return netip.Addr{}, errors.New("no available address within the network DHCP range")
+}
@@ -0,0 +1,466 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	filter_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/filter"
+	libvirt_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers/libvirt"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	nomadstructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/shoenig/test/must"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+const ipamNetworkXML = `<network>
+  <name>default</name>
+  <forward mode='nat'/>
+  <bridge name='virbr0' stp='on' delay='0'/>
+  <ip address='192.168.122.1' netmask='255.255.255.0'>
+    <dhcp>
+      <range start='192.168.122.1' end='192.168.122.5'/>
+      <host mac='52:54:00:aa:bb:cc' name='other' ip='192.168.122.2'/>
+    </dhcp>
+  </ip>
+</network>`
+
+func TestController_VMAddressingBuild(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		Active:     true,
+		BridgeName: "virbr0",
+		XmlDesc:    ipamNetworkXML,
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{IPaddr: "192.168.122.3", ExpiryTime: time.Now().Add(time.Hour)},
+			{IPaddr: "192.168.122.4", ExpiryTime: time.Now().Add(-time.Hour)},
+		},
+	}
+
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.ListNetworks{Result: []string{"default"}},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+	)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: mockConnect,
+	}
+
+	resp, err := controller.VMAddressingBuild(&net.VMAddressingBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0"},
+			},
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						IPAM:       true,
+						DNSAliases: []string{"web.example.virt"},
+					},
+				},
+				MAC: "52:54:00:1c:7c:14",
+			},
+		},
+	})
+	must.NoError(t, err)
+
+	// The network address, the host entry and the active lease are skipped,
+	// while the address of the expired lease is reused.
+	must.Eq(t, []*net.InterfaceAssignment{
+		nil,
+		{MAC: "52:54:00:1c:7c:14", Addresses: &net.InterfaceAddresses{IPv4: "192.168.122.4"}},
+	}, resp.Assignments)
+
+	reservation, err := (&libvirtxml.NetworkDHCPHost{
+		IP:   "192.168.122.4",
+		MAC:  "52:54:00:1c:7c:14",
+		Name: "nomad-0ea818bc",
+	}).Marshal()
+	must.NoError(t, err)
+	must.Eq(t, []*net.TeardownSpec{
+		{
+			Network:         "default",
+			DHCPReservation: reservation,
+			DNSHosts: []string{
+				"<host ip=\"192.168.122.4\">\n  <hostname>nomad-0ea818bc</hostname>\n  <hostname>web.example.virt</hostname>\n</host>",
+			},
+		},
+	}, resp.TeardownSpecs)
+}
+
+func TestController_VMAddressingBuild_generatedHwaddr(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		BridgeName: "virbr0",
+		XmlDesc:    ipamNetworkXML,
+	}
+
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.ListNetworks{Result: []string{"default"}},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+	)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: mockConnect,
+	}
+
+	resp, err := controller.VMAddressingBuild(&net.VMAddressingBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		NetConfig: net.NetworkInterfacesConfig{
+			{Bridge: &net.NetworkInterfaceBridgeConfig{
+				Name: "virbr0",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					IPAM: true,
+				},
+			}},
+		},
+	})
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Assignments)
+	must.StrHasPrefix(t, "52:54:00:", resp.Assignments[0].MAC)
+	must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.3"}, resp.Assignments[0].Addresses)
+}
+
+func TestController_VMAddressingBuild_network(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		BridgeName: "virbr0",
+		XmlDesc:    ipamNetworkXML,
+	}
+
+	// The network is looked up by its name, so the networks are not listed
+	// to find the network of the bridge.
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+	)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: mockConnect,
+	}
+
+	resp, err := controller.VMAddressingBuild(&net.VMAddressingBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Network: &net.NetworkInterfaceVirtualNetworkConfig{
+					Name: "default",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						IPAM: true,
+					},
+				},
+				MAC: "52:54:00:1c:7c:14",
+			},
+		},
+	})
+	must.NoError(t, err)
+	must.Eq(t, []*net.InterfaceAssignment{
+		{MAC: "52:54:00:1c:7c:14", Addresses: &net.InterfaceAddresses{IPv4: "192.168.122.3"}},
+	}, resp.Assignments)
+	must.Len(t, 1, resp.TeardownSpecs)
+	must.Eq(t, "default", resp.TeardownSpecs[0].Network)
+}
+
+func TestController_VMAddressingBuild_multipleInterfaces(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		BridgeName: "virbr0",
+		XmlDesc:    ipamNetworkXML,
+	}
+
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+	)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: mockConnect,
+	}
+
+	network := func(mac string) *net.NetworkInterfaceConfig {
+		return &net.NetworkInterfaceConfig{
+			Network: &net.NetworkInterfaceVirtualNetworkConfig{
+				Name: "default",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					IPAM: true,
+				},
+			},
+			MAC: mac,
+		}
+	}
+
+	resp, err := controller.VMAddressingBuild(&net.VMAddressingBuildRequest{
+		VMName:    "nomad-0ea818bc",
+		Hostname:  "nomad-0ea818bc",
+		NetConfig: net.NetworkInterfacesConfig{network("52:54:00:1c:7c:14"), network("52:54:00:1c:7c:15")},
+	})
+	must.NoError(t, err)
+	must.Len(t, 2, resp.TeardownSpecs)
+
+	// The host entries of the interfaces use different names, as libvirt
+	// rejects host entries with the name of an existing entry.
+	for i, name := range []string{"nomad-0ea818bc", "nomad-0ea818bc-2"} {
+		host := &libvirtxml.NetworkDHCPHost{}
+		must.NoError(t, host.Unmarshal(resp.TeardownSpecs[i].DHCPReservation))
+		must.Eq(t, name, host.Name)
+	}
+}
+
+func TestController_VMAddressingBuild_existingReservation(t *testing.T) {
+	const staleXML = `<network>
+  <name>default</name>
+  <bridge name='virbr0'/>
+  <ip address='192.168.122.1' netmask='255.255.255.0'>
+    <dhcp>
+      <range start='192.168.122.2' end='192.168.122.5'/>
+      <host mac='52:54:00:1c:7c:14' name='%s' ip='192.168.122.5'/>
+    </dhcp>
+  </ip>
+</network>`
+
+	req := func(unique bool) *net.VMAddressingBuildRequest {
+		return &net.VMAddressingBuildRequest{
+			VMName:         "nomad-0ea818bc",
+			Hostname:       "nomad-0ea818bc",
+			UniqueHostname: unique,
+			NetConfig: net.NetworkInterfacesConfig{
+				{
+					Network: &net.NetworkInterfaceVirtualNetworkConfig{
+						Name: "default",
+						NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+							IPAM: true,
+						},
+					},
+					MAC: "52:54:00:1c:7c:14",
+				},
+			},
+		}
+	}
+
+	t.Run("reused", func(t *testing.T) {
+		defaultNet := &libvirt_mock.StaticNetwork{
+			Name:    "default",
+			XmlDesc: fmt.Sprintf(staleXML, "nomad-0ea818bc"),
+		}
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: mockConnect}
+
+		resp, err := controller.VMAddressingBuild(req(true))
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.5"}, resp.Assignments[0].Addresses)
+
+		reservation, err := (&libvirtxml.NetworkDHCPHost{
+			MAC:  "52:54:00:1c:7c:14",
+			Name: "nomad-0ea818bc",
+			IP:   "192.168.122.5",
+		}).Marshal()
+		must.NoError(t, err)
+		must.Eq(t, reservation, resp.TeardownSpecs[0].DHCPReservation)
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		networkXML := fmt.Sprintf(staleXML, "nomad-7c2d1b0a")
+
+		stale, err := (&libvirtxml.NetworkDHCPHost{
+			MAC:  "52:54:00:1c:7c:14",
+			Name: "nomad-7c2d1b0a",
+			IP:   "192.168.122.5",
+		}).Marshal()
+		must.NoError(t, err)
+
+		reservation, err := (&libvirtxml.NetworkDHCPHost{
+			MAC:  "52:54:00:1c:7c:14",
+			Name: "nomad-0ea818bc",
+			IP:   "192.168.122.5",
+		}).Marshal()
+		must.NoError(t, err)
+
+		dnsHost, err := (&libvirtxml.NetworkDNSHost{
+			IP:        "192.168.122.5",
+			Hostnames: []libvirtxml.NetworkDNSHostHostname{{Hostname: "nomad-0ea818bc"}},
+		}).Marshal()
+		must.NoError(t, err)
+
+		flags := libvirt.NETWORK_UPDATE_AFFECT_LIVE | libvirt.NETWORK_UPDATE_AFFECT_CONFIG
+
+		// The entry left behind by a previous run of the task is removed,
+		// and its address is reserved again using the name of the VM.
+		defaultNet := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: networkXML},
+			libvirt_mock.GetXMLDesc{Result: networkXML},
+			libvirt_mock.Update{
+				Cmd:     libvirt.NETWORK_UPDATE_COMMAND_DELETE,
+				Section: libvirt.NETWORK_SECTION_IP_DHCP_HOST,
+				Xml:     stale,
+				Flags:   flags,
+			},
+			libvirt_mock.GetXMLDesc{Result: networkXML},
+			libvirt_mock.Update{
+				Cmd:     libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST,
+				Section: libvirt.NETWORK_SECTION_IP_DHCP_HOST,
+				Xml:     reservation,
+				Flags:   flags,
+			},
+			libvirt_mock.Update{
+				Cmd:         libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST,
+				Section:     libvirt.NETWORK_SECTION_DNS_HOST,
+				ParentIndex: automaticParentIndex,
+				Xml:         dnsHost,
+				Flags:       flags,
+			},
+			libvirt_mock.Free{},
+		)
+		defer defaultNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: mockConnect}
+
+		resp, err := controller.VMAddressingBuild(req(true))
+		must.NoError(t, err)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "192.168.122.5"}, resp.Assignments[0].Addresses)
+		must.Eq(t, reservation, resp.TeardownSpecs[0].DHCPReservation)
+	})
+
+	t.Run("name of another vm", func(t *testing.T) {
+		defaultNet := &libvirt_mock.StaticNetwork{
+			Name: "default",
+			XmlDesc: strings.Replace(fmt.Sprintf(staleXML, "nomad-0ea818bc"),
+				"52:54:00:1c:7c:14", "52:54:00:aa:bb:cc", 1),
+		}
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: mockConnect}
+
+		// The hostname was set within the task configuration, so the entry
+		// may belong to another VM using the same hostname.
+		_, err := controller.VMAddressingBuild(req(false))
+		must.ErrorContains(t, err, `DHCP host entry "nomad-0ea818bc" is already reserved for 52:54:00:aa:bb:cc`)
+	})
+}
+
+func Test_availableAddress(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		network := &libvirt_mock.StaticNetwork{
+			XmlDesc: ipamNetworkXML,
+			DhcpLeases: []libvirt.NetworkDHCPLease{
+				{IPaddr: "192.168.122.3", ExpiryTime: time.Now().Add(time.Hour)},
+				{IPaddr: "192.168.122.4", ExpiryTime: time.Now().Add(time.Hour)},
+				{IPaddr: "192.168.122.5", ExpiryTime: time.Now().Add(time.Hour)},
+			},
+		}
+
+		_, err := availableAddress(network)
+		must.ErrorContains(t, err, "no available address")
+	})
+
+	t.Run("no dhcp range", func(t *testing.T) {
+		network := &libvirt_mock.StaticNetwork{
+			XmlDesc: `<network><ip address='192.168.122.1' netmask='255.255.255.0'/></network>`,
+		}
+
+		_, err := availableAddress(network)
+		must.ErrorContains(t, err, "no IPv4 DHCP range")
+	})
+
+	t.Run("ipv6 range ignored", func(t *testing.T) {
+		network := &libvirt_mock.StaticNetwork{XmlDesc: dualStackNetworkXML}
+
+		addr, err := availableAddress(network)
+		must.NoError(t, err)
+		must.Eq(t, "192.168.100.2", addr.String())
+	})
+}
+
+func TestController_VMStartedBuild_assigned(t *testing.T) {
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "ssh", Value: 27494, To: 22},
+		},
+	}
+
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+						IPAM:  true,
+					},
+				},
+				AssignedAddresses: &net.InterfaceAddresses{IPv4: "192.168.122.4"},
+			},
+		},
+		Resources: resources,
+	}
+
+	mockFilter := filter_mock.NewMock(t).Expect(
+		filter_mock.Configure{
+			Resources:     resources,
+			NetworkConfig: req.NetConfig[0].Bridge,
+			IP:            "192.168.122.4",
+			Result:        &net.FilterRemoval{Name: "testing"},
+		},
+	)
+	defer mockFilter.AssertExpectations()
+
+	// The address is already reserved, so no network lookups are expected.
+	mockConnect := libvirt_mock.NewConnect(t)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: mockConnect,
+		filter:  mockFilter,
+	}
+
+	resp, err := controller.VMStartedBuild(req)
+	must.NoError(t, err)
+	must.Eq(t, &drivers.DriverNetwork{
+		PortMap: map[string]int{"ssh": 22},
+		IP:      "192.168.122.4",
+	}, resp.DriverNetwork)
+	must.Eq(t, []*net.InterfaceAddresses{{IPv4: "192.168.122.4"}}, resp.Addresses)
+	must.Eq(t, []*net.TeardownSpec{
+		{FilterRemoval: &net.FilterRemoval{Name: "testing"}},
+	}, resp.TeardownSpecs)
+}
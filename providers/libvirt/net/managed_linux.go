@@ -0,0 +1,328 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	"libvirt.org/go/libvirtxml"
+)
+
+func (c *Controller) VMNetworksBuild(req *net.VMNetworksBuildRequest) (_ *net.VMNetworksBuildResponse, err error) {
+	// We can't be exactly sure what the caller will give us, so make sure we
+	// don't panic the driver.
+	if req == nil {
+		return &net.VMNetworksBuildResponse{}, nil
+	}
+
+	resp := &net.VMNetworksBuildResponse{Networks: make([]string, len(req.NetConfig))}
+
+	// If a later network can not be acquired, release those already
+	// acquired, so the networks are not leaked.
+	defer func() {
+		if err != nil {
+			for _, spec := range resp.TeardownSpecs {
+				if releaseErr := c.releaseManagedNetwork(spec.ManagedNetwork); releaseErr != nil {
+					c.logger.Error("failed to release managed network", "network", spec.ManagedNetwork.Network,
+						"error", releaseErr)
+				}
+			}
+		}
+	}()
+
+	for i, iface := range req.NetConfig {
+		if iface.Network == nil {
+			continue
+		}
+
+		tmpl := c.managed.template(iface.Network.Name)
+		if tmpl == nil {
+			continue
+		}
+
+		// The session daemon can not create networks, as they require
+		// privileges on the host.
+		if c.session {
+			return nil, fmt.Errorf("managed network %q %w in session mode", iface.Network.Name, errs.ErrNotSupported)
+		}
+
+		var networkName string
+		networkName, err = tmpl.NetworkName(iface.Network.Name, req.Namespace, req.JobID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to name managed network %q for %s: %w", iface.Network.Name, req.VMName, err)
+		}
+
+		release := &net.ManagedNetworkRelease{
+			Network: networkName,
+			VMName:  req.VMName,
+		}
+		if err = c.acquireManagedNetwork(release, tmpl); err != nil {
+			return nil, fmt.Errorf("failed to acquire managed network %q for %s: %w", release.Network, req.VMName, err)
+		}
+
+		resp.Networks[i] = release.Network
+		resp.TeardownSpecs = append(resp.TeardownSpecs, &net.TeardownSpec{ManagedNetwork: release})
+	}
+
+	if req.ResolveIPv6 {
+		resp.IPv6 = make([]bool, len(req.NetConfig))
+		for i, iface := range req.NetConfig {
+			resp.IPv6[i] = c.interfaceIPv6(iface, resp.Networks[i])
+		}
+	}
+
+	return resp, nil
+}
+
+// interfaceIPv6 returns if the libvirt network the interface attaches to
+// provides IPv6 addressing. libvirt sends router advertisements on networks
+// with an IPv6 address, and provides DHCPv6 when the address has a DHCP
+// configuration. Interfaces which are not attached to a libvirt network are
+// reported as not providing IPv6, as their network can not be inspected.
+func (c *Controller) interfaceIPv6(iface *net.NetworkInterfaceConfig, networkName string) bool {
+	switch {
+	case networkName != "":
+	case iface.Network != nil:
+		networkName = iface.Network.Name
+	case iface.Bridge != nil && iface.Bridge.OpenVSwitch == nil:
+		name, err := c.networkNameFromBridgeName(iface.Bridge.Name)
+		if err != nil {
+			c.logger.Debug("bridge is not managed by libvirt", "bridge", iface.Bridge.Name, "error", err)
+			return false
+		}
+		networkName = name
+	default:
+		return false
+	}
+
+	network, err := c.netConn.LookupNetworkByName(networkName)
+	if err != nil {
+		c.logger.Warn("failed to lookup network", "network", networkName, "error", err)
+		return false
+	}
+	defer network.Free()
+
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		c.logger.Warn("failed to read network definition", "network", networkName, "error", err)
+		return false
+	}
+
+	return slices.ContainsFunc(networkCfg.IPs, func(ip libvirtxml.NetworkIP) bool {
+		return ip.Family == "ipv6"
+	})
+}
+
+// acquireManagedNetwork adds the reference of the VM to the managed network,
+// creating the network from the template if it does not exist.
+func (c *Controller) acquireManagedNetwork(ref *net.ManagedNetworkRelease, tmpl *net.ManagedNetworkConfig) error {
+	c.managed.m.Lock()
+	defer c.managed.m.Unlock()
+
+	// The network may exist without any reference when it was created before
+	// the driver was restarted and its tasks have not yet been recovered.
+	if len(c.managed.holders[ref.Network]) == 0 {
+		if network, err := c.netConn.LookupNetworkByName(ref.Network); err == nil {
+			network.Free()
+		} else if err := c.createManagedNetwork(ref.Network, tmpl); err != nil {
+			return err
+		}
+	}
+
+	c.addManagedNetworkHolder(ref)
+	return nil
+}
+
+// releaseManagedNetwork removes the reference of the VM to the managed
+// network, destroying the network once no reference remains. Releasing a
+// reference which is not held is a no-op, so teardown can be retried. The
+// reference is removed even when the network can not be destroyed, so it is
+// not held forever.
+func (c *Controller) releaseManagedNetwork(ref *net.ManagedNetworkRelease) error {
+	c.managed.m.Lock()
+	defer c.managed.m.Unlock()
+
+	holders := c.managed.holders[ref.Network]
+	if _, ok := holders[ref.VMName]; !ok {
+		return nil
+	}
+
+	if len(holders) > 1 {
+		delete(holders, ref.VMName)
+		return nil
+	}
+	delete(c.managed.holders, ref.Network)
+
+	network, err := c.netConn.LookupNetworkByName(ref.Network)
+	if err != nil {
+		return fmt.Errorf("failed to find managed network %q: %w", ref.Network, err)
+	}
+	defer network.Free()
+
+	// Tasks which have not been recovered since the driver was restarted do
+	// not hold a reference, so the network is kept while any domain is
+	// attached. The network is destroyed once the recovered tasks release
+	// it.
+	connections, err := networkConnections(network)
+	if err != nil {
+		return fmt.Errorf("failed to check managed network %q connections: %w", ref.Network, err)
+	}
+	if connections > 0 {
+		c.logger.Info("keeping managed network with attached domains", "network", ref.Network,
+			"connections", connections)
+		return nil
+	}
+
+	c.logger.Info("destroying unused managed network", "network", ref.Network)
+	if err := network.Destroy(); err != nil {
+		return fmt.Errorf("failed to destroy managed network %q: %w", ref.Network, err)
+	}
+
+	return nil
+}
+
+// networkConnections returns the number of domain interfaces attached to the
+// network, which is only reported within the live definition of the network.
+func networkConnections(network shims.ConnectNetwork) (int, error) {
+	networkDoc, err := network.GetXMLDesc(0)
+	if err != nil {
+		return 0, err
+	}
+
+	var networkCfg struct {
+		Connections int `xml:"connections,attr"`
+	}
+	if err := xml.Unmarshal([]byte(networkDoc), &networkCfg); err != nil {
+		return 0, err
+	}
+
+	return networkCfg.Connections, nil
+}
+
+// recoverManagedNetwork restores the reference of a recovered VM to the
+// managed network.
+func (c *Controller) recoverManagedNetwork(ref *net.ManagedNetworkRelease) {
+	c.managed.m.Lock()
+	defer c.managed.m.Unlock()
+
+	c.addManagedNetworkHolder(ref)
+}
+
+// addManagedNetworkHolder adds the VM to the holders of the managed network.
+// The caller must hold the lock of the managed networks.
+func (c *Controller) addManagedNetworkHolder(ref *net.ManagedNetworkRelease) {
+	if c.managed.holders[ref.Network] == nil {
+		c.managed.holders[ref.Network] = map[string]struct{}{}
+	}
+
+	c.managed.holders[ref.Network][ref.VMName] = struct{}{}
+}
+
+// createManagedNetwork creates and starts a transient network with the passed
+// name from the template. The subnet of the network is allocated from the
+// subnet pool of the template, avoiding the subnets of the existing networks.
+func (c *Controller) createManagedNetwork(name string, tmpl *net.ManagedNetworkConfig) error {
+	used, err := c.networkSubnets()
+	if err != nil {
+		return fmt.Errorf("failed to list network subnets: %w", err)
+	}
+
+	subnet, err := tmpl.AllocateSubnet(used)
+	if err != nil {
+		return err
+	}
+
+	networkDoc, err := managedNetworkDefinition(name, tmpl.ForwardMode(), subnet).Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to generate network definition: %w", err)
+	}
+
+	c.logger.Info("creating managed network", "network", name, "subnet", subnet.String())
+	network, err := c.netConn.NetworkCreateXML(networkDoc)
+	if err != nil {
+		return fmt.Errorf("failed to create network: %w", err)
+	}
+
+	return network.Free()
+}
+
+// networkSubnets returns the IPv4 subnets of all the libvirt networks.
+func (c *Controller) networkSubnets() ([]netip.Prefix, error) {
+	networkNames, err := c.netConn.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var mErr *multierror.Error
+	var subnets []netip.Prefix
+	for _, networkName := range networkNames {
+		network, err := c.netConn.LookupNetworkByName(networkName)
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+			continue
+		}
+
+		networkCfg, err := networkDefinition(network)
+		network.Free()
+		if err != nil {
+			mErr = multierror.Append(mErr, err)
+			continue
+		}
+
+		for _, ip := range networkCfg.IPs {
+			subnet, err := networkSubnet(ip)
+			if err != nil || !subnet.Addr().Is4() {
+				continue
+			}
+			subnets = append(subnets, subnet.Masked())
+		}
+	}
+
+	return subnets, mErr.ErrorOrNil()
+}
+
+// managedNetworkDefinition returns the definition of a managed network with
+// the passed name, forwarding mode and subnet. The first address of the
+// subnet is used by the host and the remaining addresses are leased by the
+// DHCP server of the network.
+func managedNetworkDefinition(name string, mode net.ManagedNetworkMode, subnet netip.Prefix) *libvirtxml.Network {
+	gateway := subnet.Addr().Next()
+
+	networkCfg := &libvirtxml.Network{
+		Name: name,
+		Bridge: &libvirtxml.NetworkBridge{
+			STP:   "on",
+			Delay: "0",
+		},
+		IPs: []libvirtxml.NetworkIP{
+			{
+				Address: gateway.String(),
+				Prefix:  uint(subnet.Bits()),
+				DHCP: &libvirtxml.NetworkDHCP{
+					Ranges: []libvirtxml.NetworkDHCPRange{
+						{
+							Start: gateway.Next().String(),
+							End:   net.LastAddr(subnet).Prev().String(),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if mode != net.ManagedNetworkModeIsolated {
+		networkCfg.Forward = &libvirtxml.NetworkForward{Mode: string(mode)}
+	}
+
+	return networkCfg
+}
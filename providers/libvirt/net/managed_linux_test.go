@@ -0,0 +1,431 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build linux
+
+package net
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	libvirt_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers/libvirt"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+	"libvirt.org/go/libvirtxml"
+)
+
+const managedDefaultNetworkXML = `<network>
+  <name>default</name>
+  <forward mode='nat'/>
+  <bridge name='virbr0' stp='on' delay='0'/>
+  <ip address='10.100.0.1' prefix='24'>
+    <dhcp>
+      <range start='10.100.0.2' end='10.100.0.254'/>
+    </dhcp>
+  </ip>
+</network>`
+
+// managedTenantNetworkXML is the live definition of a managed network which
+// has no attached domains.
+const managedTenantNetworkXML = `<network>
+  <name>nomad-tenant-prod</name>
+  <forward mode='nat'/>
+  <bridge name='virbr1' stp='on' delay='0'/>
+  <ip address='10.100.1.1' prefix='24'/>
+</network>`
+
+// testManagedController returns a controller using the passed connection and
+// the managed network templates used by the tests.
+func testManagedController(conn shims.Connect) *Controller {
+	return &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: conn,
+		managed: &managedNetworks{
+			templates: map[string]net.ManagedNetworkConfig{
+				"tenant": {
+					Mode:       net.ManagedNetworkModeNAT,
+					Scope:      net.ManagedNetworkScopeNamespace,
+					SubnetPool: "10.100.0.0/16",
+					SubnetSize: 24,
+				},
+				"private": {
+					Mode:       net.ManagedNetworkModeIsolated,
+					Scope:      net.ManagedNetworkScopeJob,
+					SubnetPool: "10.200.0.0/16",
+					SubnetSize: 28,
+				},
+			},
+			holders: map[string]map[string]struct{}{},
+		},
+	}
+}
+
+// managedNetworkXML returns the definition used to create a managed network.
+func managedNetworkXML(t *testing.T, name string, mode net.ManagedNetworkMode, subnet string) string {
+	t.Helper()
+
+	networkDoc, err := managedNetworkDefinition(name, mode, netip.MustParsePrefix(subnet)).Marshal()
+	must.NoError(t, err)
+
+	return networkDoc
+}
+
+func TestController_VMNetworksBuild(t *testing.T) {
+	t.Run("nil request", func(t *testing.T) {
+		controller := testManagedController(libvirt_mock.NewConnect(t))
+
+		resp, err := controller.VMNetworksBuild(nil)
+		must.NoError(t, err)
+		must.Eq(t, &net.VMNetworksBuildResponse{}, resp)
+	})
+
+	t.Run("creates network", func(t *testing.T) {
+		createdNet := libvirt_mock.NewNetwork(t).Expect(libvirt_mock.Free{})
+		defer createdNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Err: mock.MockTestErr},
+			libvirt_mock.ListNetworks{Result: []string{"default"}},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: &libvirt_mock.StaticNetwork{
+				Name:    "default",
+				XmlDesc: managedDefaultNetworkXML,
+			}},
+			libvirt_mock.NetworkCreateXML{
+				XML:    managedNetworkXML(t, "nomad-tenant-prod", net.ManagedNetworkModeNAT, "10.100.1.0/24"),
+				Result: createdNet,
+			},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		resp, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName:    "nomad-0ea818bc",
+			Namespace: "prod",
+			JobID:     "web",
+			NetConfig: net.NetworkInterfacesConfig{
+				{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"}},
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "tenant"}},
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "default"}},
+			},
+		})
+		must.NoError(t, err)
+		must.Eq(t, []string{"", "nomad-tenant-prod", ""}, resp.Networks)
+		must.Eq(t, []*net.TeardownSpec{
+			{ManagedNetwork: &net.ManagedNetworkRelease{Network: "nomad-tenant-prod", VMName: "nomad-0ea818bc"}},
+		}, resp.TeardownSpecs)
+	})
+
+	t.Run("existing network", func(t *testing.T) {
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-private-prod-web", Result: &libvirt_mock.StaticNetwork{}},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		resp, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName:    "nomad-0ea818bc",
+			Namespace: "prod",
+			JobID:     "web",
+			NetConfig: net.NetworkInterfacesConfig{
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "private"}},
+			},
+		})
+		must.NoError(t, err)
+		must.Eq(t, []string{"nomad-private-prod-web"}, resp.Networks)
+	})
+
+	t.Run("shared network", func(t *testing.T) {
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: &libvirt_mock.StaticNetwork{}},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		for _, vmName := range []string{"nomad-0ea818bc", "nomad-7c2d1b0a"} {
+			resp, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+				VMName:    vmName,
+				Namespace: "prod",
+				JobID:     vmName,
+				NetConfig: net.NetworkInterfacesConfig{
+					{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "tenant"}},
+				},
+			})
+			must.NoError(t, err)
+			must.Eq(t, []string{"nomad-tenant-prod"}, resp.Networks)
+		}
+
+		must.MapLen(t, 2, controller.managed.holders["nomad-tenant-prod"])
+	})
+
+	t.Run("resolves ipv6", func(t *testing.T) {
+		defaultNet := &libvirt_mock.StaticNetwork{
+			Name:       "default",
+			BridgeName: "virbr0",
+			XmlDesc:    managedDefaultNetworkXML,
+		}
+		dualStackNet := &libvirt_mock.StaticNetwork{
+			Name:       "dual",
+			BridgeName: "virbr1",
+			XmlDesc:    dualStackNetworkXML,
+		}
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.ListNetworks{Result: []string{"default", "dual"}},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.LookupNetworkByName{Name: "dual", Result: dualStackNet},
+			libvirt_mock.LookupNetworkByName{Name: "dual", Result: dualStackNet},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.ListNetworks{Result: []string{"default", "dual"}},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.LookupNetworkByName{Name: "dual", Result: dualStackNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		resp, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName: "nomad-0ea818bc",
+			NetConfig: net.NetworkInterfacesConfig{
+				{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr1"}},
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "default"}},
+				{Bridge: &net.NetworkInterfaceBridgeConfig{Name: "br0"}},
+				{Macvtap: &net.NetworkInterfaceMacvtapConfig{Device: "eth0"}},
+			},
+			ResolveIPv6: true,
+		})
+		must.NoError(t, err)
+		must.Eq(t, []bool{true, false, false, false}, resp.IPv6)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		controller := testManagedController(libvirt_mock.NewConnect(t))
+		controller.session = true
+
+		_, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName: "nomad-0ea818bc",
+			NetConfig: net.NetworkInterfacesConfig{
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "tenant"}},
+			},
+		})
+		must.ErrorIs(t, err, errs.ErrNotSupported)
+	})
+
+	t.Run("create error releases acquired networks", func(t *testing.T) {
+		tenantNet := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: managedTenantNetworkXML},
+			libvirt_mock.Destroy{},
+			libvirt_mock.Free{},
+		)
+		defer tenantNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: &libvirt_mock.StaticNetwork{}},
+			libvirt_mock.LookupNetworkByName{Name: "nomad-private-prod-web", Err: mock.MockTestErr},
+			libvirt_mock.ListNetworks{Result: []string{}},
+			libvirt_mock.NetworkCreateXML{
+				XML: managedNetworkXML(t, "nomad-private-prod-web", net.ManagedNetworkModeIsolated, "10.200.0.0/28"),
+				Err: mock.MockTestErr,
+			},
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: tenantNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		_, err := controller.VMNetworksBuild(&net.VMNetworksBuildRequest{
+			VMName:    "nomad-0ea818bc",
+			Namespace: "prod",
+			JobID:     "web",
+			NetConfig: net.NetworkInterfacesConfig{
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "tenant"}},
+				{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "private"}},
+			},
+		})
+		must.ErrorIs(t, err, mock.MockTestErr)
+		must.MapEmpty(t, controller.managed.holders)
+	})
+}
+
+func TestController_VMTerminatedTeardown_managedNetwork(t *testing.T) {
+	first := &net.TeardownSpec{
+		ManagedNetwork: &net.ManagedNetworkRelease{Network: "nomad-tenant-prod", VMName: "nomad-0ea818bc"},
+	}
+	second := &net.TeardownSpec{
+		ManagedNetwork: &net.ManagedNetworkRelease{Network: "nomad-tenant-prod", VMName: "nomad-7c2d1b0a"},
+	}
+
+	t.Run("destroyed once unused", func(t *testing.T) {
+		tenantNet := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: managedTenantNetworkXML},
+			libvirt_mock.Destroy{},
+			libvirt_mock.Free{},
+		)
+		defer tenantNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		_, err := controller.VMRecoveredBuild(&net.VMRecoveredBuildRequest{
+			VMName:        "nomad-0ea818bc",
+			TeardownSpecs: []*net.TeardownSpec{first},
+		})
+		must.NoError(t, err)
+		_, err = controller.VMRecoveredBuild(&net.VMRecoveredBuildRequest{
+			VMName:        "nomad-7c2d1b0a",
+			TeardownSpecs: []*net.TeardownSpec{second},
+		})
+		must.NoError(t, err)
+
+		// The network is still in use by the second VM, so releasing the
+		// first reference, including retries, leaves it in place.
+		for range 2 {
+			_, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+				TeardownSpecs: []*net.TeardownSpec{first},
+			})
+			must.NoError(t, err)
+		}
+
+		mockConnect.Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: tenantNet},
+		)
+		_, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{second},
+		})
+		must.NoError(t, err)
+		must.MapEmpty(t, controller.managed.holders)
+
+		// A retry does not attempt to destroy the network again.
+		_, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{second},
+		})
+		must.NoError(t, err)
+	})
+
+	t.Run("destroy error", func(t *testing.T) {
+		tenantNet := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: managedTenantNetworkXML},
+			libvirt_mock.Destroy{Err: mock.MockTestErr},
+			libvirt_mock.Free{},
+		)
+		defer tenantNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: tenantNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := testManagedController(mockConnect)
+		controller.recoverManagedNetwork(first.ManagedNetwork)
+
+		// The reference is removed even though the network could not be
+		// destroyed, so a retry does not attempt to destroy it again.
+		_, err := controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{first},
+		})
+		must.ErrorIs(t, err, mock.MockTestErr)
+		must.MapEmpty(t, controller.managed.holders)
+
+		_, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{first},
+		})
+		must.NoError(t, err)
+	})
+
+	t.Run("attached domains", func(t *testing.T) {
+		tenantNet := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: `<network connections='1'><name>nomad-tenant-prod</name></network>`},
+			libvirt_mock.Free{},
+		)
+		defer tenantNet.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "nomad-tenant-prod", Result: tenantNet},
+		)
+		defer mockConnect.AssertExpectations()
+
+		// The network is reused after a restart before the task of the
+		// other attached domain has been recovered, so the released
+		// reference is the only one held.
+		controller := testManagedController(mockConnect)
+		controller.recoverManagedNetwork(first.ManagedNetwork)
+
+		_, err := controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{first},
+		})
+		must.NoError(t, err)
+		must.MapEmpty(t, controller.managed.holders)
+	})
+}
+
+func Test_networkConnections(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: managedTenantNetworkXML},
+		)
+		defer network.AssertExpectations()
+
+		connections, err := networkConnections(network)
+		must.NoError(t, err)
+		must.Zero(t, connections)
+	})
+
+	t.Run("attached", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: `<network connections='3'><name>nomad-tenant-prod</name></network>`},
+		)
+		defer network.AssertExpectations()
+
+		connections, err := networkConnections(network)
+		must.NoError(t, err)
+		must.Eq(t, 3, connections)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Err: mock.MockTestErr},
+		)
+		defer network.AssertExpectations()
+
+		_, err := networkConnections(network)
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+}
+
+func Test_managedNetworkDefinition(t *testing.T) {
+	t.Run("nat", func(t *testing.T) {
+		must.Eq(t, &libvirtxml.Network{
+			Name:    "nomad-tenant-prod",
+			Forward: &libvirtxml.NetworkForward{Mode: "nat"},
+			Bridge:  &libvirtxml.NetworkBridge{STP: "on", Delay: "0"},
+			IPs: []libvirtxml.NetworkIP{
+				{
+					Address: "10.100.1.1",
+					Prefix:  24,
+					DHCP: &libvirtxml.NetworkDHCP{
+						Ranges: []libvirtxml.NetworkDHCPRange{
+							{Start: "10.100.1.2", End: "10.100.1.254"},
+						},
+					},
+				},
+			},
+		}, managedNetworkDefinition("nomad-tenant-prod", net.ManagedNetworkModeNAT, netip.MustParsePrefix("10.100.1.0/24")))
+	})
+
+	t.Run("route", func(t *testing.T) {
+		networkCfg := managedNetworkDefinition("nomad-tenant-prod", net.ManagedNetworkModeRoute, netip.MustParsePrefix("10.100.1.0/24"))
+		must.Eq(t, &libvirtxml.NetworkForward{Mode: "route"}, networkCfg.Forward)
+	})
+
+	t.Run("isolated", func(t *testing.T) {
+		networkCfg := managedNetworkDefinition("nomad-private-prod-web", net.ManagedNetworkModeIsolated, netip.MustParsePrefix("10.200.0.16/28"))
+		must.Nil(t, networkCfg.Forward)
+		must.Eq(t, "10.200.0.17", networkCfg.IPs[0].Address)
+		must.Eq(t, []libvirtxml.NetworkDHCPRange{
+			{Start: "10.200.0.18", End: "10.200.0.30"},
+		}, networkCfg.IPs[0].DHCP.Ranges)
+	})
+}
@@ -5,11 +5,17 @@ package net
 
 import (
 	stdnet "net"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/net/arp"
 	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/hashicorp/nomad-driver-virt/net/leases"
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
+	"github.com/hashicorp/nomad-driver-virt/net/proxy"
 	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
 )
 
 var (
@@ -17,10 +23,33 @@ var (
 	// when performing DHCP lease discovery.
 	defaultDHCPLeaseDiscoveryInterval = 2 * time.Second
 
-	// defaultDHCPLeaseDiscoveryTimeout is the default timeout period used when
-	// performing DHCP lease discovery. In the future we may want to make this
-	// configurable, but for now, it's a good default.
-	defaultDHCPLeaseDiscoveryTimeout = 30 * time.Second
+	// defaultDHCPLeaseFallbackInterval is the default retry interval used
+	// when performing DHCP lease discovery while the changes of the leases
+	// are watched. Discovery is attempted as soon as the leases change, so
+	// the interval only guards against missed changes.
+	defaultDHCPLeaseFallbackInterval = 10 * time.Second
+
+	// defaultDiscoveryTimeout is the default timeout period used when
+	// discovering the addresses of an interface, regardless of the discovery
+	// strategies used.
+	defaultDiscoveryTimeout = 30 * time.Second
+
+	// defaultBridgeDiscoveryStrategies are the strategies used to discover
+	// the addresses of bridged interfaces when none have been configured.
+	defaultBridgeDiscoveryStrategies = []net.DiscoveryStrategy{
+		net.DiscoveryStrategyStatic,
+		net.DiscoveryStrategyDHCPLease,
+	}
+
+	// defaultDiscoveryStrategies are the strategies used to discover the
+	// addresses of interfaces which are not managed by the controller, such
+	// as macvtap interfaces and interfaces on Open vSwitch bridges, when none
+	// have been configured.
+	defaultDiscoveryStrategies = []net.DiscoveryStrategy{
+		net.DiscoveryStrategyStatic,
+		net.DiscoveryStrategyGuestAgent,
+		net.DiscoveryStrategyARP,
+	}
 )
 
 // Controller implements to Net interface and is the main/only way in which the
@@ -29,28 +58,218 @@ type Controller struct {
 	logger  hclog.Logger
 	netConn shims.Connect
 	filter  filter.Filter
+	netns   netns.NetNS
+
+	// arp is used to discover the addresses of interfaces which are not
+	// managed by the controller, such as macvtap interfaces.
+	arp arp.ARP
+
+	// leases is used to watch the changes of the DHCP leases of libvirt
+	// networks, so discovery does not need to poll libvirt for them.
+	leases leases.Leases
+
+	// proxy relays the port forwards which cannot be configured using the
+	// packet filter, such as loopback port forwards when the host does not
+	// route localnet packets.
+	proxy proxy.Proxy
+
+	// discovery is the ordered list of strategies used to discover the
+	// addresses of interfaces which do not configure their own. When empty,
+	// the defaults of the interface type are used.
+	discovery []net.DiscoveryStrategy
+
+	// filterBackend is the name of the filter implementation to use when
+	// the filter is unset.
+	filterBackend string
 
 	dhcpLeaseDiscoveryInterval time.Duration
-	dhcpLeaseDiscoveryTimeout  time.Duration
+	dhcpLeaseFallbackInterval  time.Duration
+
+	// discoveryTimeout is the period within which the addresses of an
+	// interface must be discovered.
+	discoveryTimeout time.Duration
 
 	// ipByInterfaceGetter is the function that queries the host using the
 	// passed interface name and identifies the IP address assigned to it.
 	ipByInterfaceGetter
+
+	// tcAvailable reports if the traffic control utility, which libvirt uses
+	// to apply interface bandwidth limits, can be used on the host. The
+	// result is cached, as it is reported on every fingerprint.
+	tcAvailable func() bool
+
+	// ovsBridges lists the Open vSwitch bridges of the host.
+	ovsBridges func() ([]string, error)
+
+	// macvlanDevices lists the macvlan devices of the host using the passed
+	// lower device.
+	macvlanDevices func(lower string) ([]string, error)
+
+	// session is set when the provider is connected to the per-user session
+	// daemon. No packet filter is used and the libvirt networks of the system
+	// daemon are not available.
+	session bool
+
+	// managed tracks the networks created from the managed network
+	// templates. It is shared by all copies of the controller, so the
+	// references are counted across them.
+	managed *managedNetworks
+
+	// migration holds the configuration left by a previously used filter
+	// implementation. It is shared by all copies of the controller.
+	migration *filterMigration
+}
+
+// filterMigration tracks the configuration of the filter implementation used
+// before the current one was selected. Tasks which were started using the
+// previous filter keep their configuration until they are stopped, and the
+// remaining configuration is only removed once no task uses it.
+type filterMigration struct {
+	previous func() (filter.Filter, error) // returns the previous filter
+	owns     func(*net.FilterRemoval) bool // reports if the previous filter created the removal
+	cleanup  func(hclog.Logger) error      // removes all the previous filter configuration
+	filter   filter.Filter                 // previous filter, once created
+	done     bool                          // cleanup has completed
+	m        sync.Mutex
+}
+
+// owned returns if the removal was created by the previous filter.
+func (f *filterMigration) owned(removal *net.FilterRemoval) bool {
+	return f != nil && removal != nil && f.owns(removal)
+}
+
+// teardown removes the configuration described by the removal using the
+// previous filter. Nothing is removed once all the configuration of the
+// previous filter has been removed.
+func (f *filterMigration) teardown(removal *net.FilterRemoval) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.done {
+		return nil
+	}
+
+	if f.filter == nil {
+		previous, err := f.previous()
+		if err != nil {
+			return err
+		}
+		f.filter = previous
+	}
+
+	return f.filter.Teardown(removal)
+}
+
+// complete removes the configuration of the previous filter when no task
+// uses it anymore.
+func (f *filterMigration) complete(logger hclog.Logger, inUse bool) {
+	if f == nil {
+		return
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.done || inUse {
+		return
+	}
+
+	if err := f.cleanup(logger); err != nil {
+		logger.Warn("failed to remove previous packet filter configuration", "error", err)
+		return
+	}
+
+	f.done = true
+}
+
+// managedNetworks contains the managed network templates and the names of the
+// VMs holding a reference to each network created from them. References are
+// tracked by VM name, so acquiring and releasing them can be retried.
+type managedNetworks struct {
+	templates map[string]net.ManagedNetworkConfig
+	holders   map[string]map[string]struct{}
+	m         sync.Mutex
+}
+
+// template returns the managed network template with the passed name, or nil
+// if no template exists.
+func (m *managedNetworks) template(name string) *net.ManagedNetworkConfig {
+	if m == nil {
+		return nil
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	tmpl, ok := m.templates[name]
+	if !ok {
+		return nil
+	}
+
+	return &tmpl
 }
 
 // NewController returns a Controller which implements the net.Net interface
 // and has a named logger, to ensure log messages can be easily tied to the
 // network system.
 func NewController(logger hclog.Logger, conn shims.Connect) *Controller {
+	discoverer := arp.New()
+	discoverer.SetLogger(logger.Named("arp"))
+
+	watcher := leases.New()
+	watcher.SetLogger(logger.Named("leases"))
+
+	forwarder := proxy.New()
+	forwarder.SetLogger(logger.Named("proxy"))
+
 	return &Controller{
+		arp:                        discoverer,
 		dhcpLeaseDiscoveryInterval: defaultDHCPLeaseDiscoveryInterval,
-		dhcpLeaseDiscoveryTimeout:  defaultDHCPLeaseDiscoveryTimeout,
+		discoveryTimeout:           defaultDiscoveryTimeout,
+		dhcpLeaseFallbackInterval:  defaultDHCPLeaseFallbackInterval,
+		filterBackend:              filter.BackendIPTables,
 		ipByInterfaceGetter:        getIPByInterface,
+		leases:                     watcher,
 		logger:                     logger.Named("net"),
 		netConn:                    conn,
+		netns:                      netns.New(logger),
+		proxy:                      forwarder,
+		tcAvailable:                sync.OnceValue(tcAvailable),
+		ovsBridges:                 ovsBridges,
+		macvlanDevices:             macvlanDevices,
+		managed:                    &managedNetworks{holders: map[string]map[string]struct{}{}},
 	}
 }
 
+// SetDiscoveryStrategies sets the ordered list of strategies used to
+// discover the addresses of interfaces which do not configure their own.
+func (c *Controller) SetDiscoveryStrategies(strategies []net.DiscoveryStrategy) {
+	c.discovery = strategies
+}
+
+// SetDiscoveryTimeout sets the period within which the addresses of an
+// interface must be discovered.
+func (c *Controller) SetDiscoveryTimeout(timeout time.Duration) {
+	c.discoveryTimeout = timeout
+}
+
+// SetManagedNetworks sets the templates of the networks the controller
+// creates on demand, keyed by the name network interfaces use to attach to
+// them.
+func (c *Controller) SetManagedNetworks(templates map[string]net.ManagedNetworkConfig) {
+	c.managed.m.Lock()
+	defer c.managed.m.Unlock()
+
+	c.managed.templates = templates
+}
+
+// SetSession sets whether the provider is connected to the per-user session
+// daemon, where the features requiring privileges on the host are not
+// available.
+func (c *Controller) SetSession(session bool) {
+	c.session = session
+}
+
 // ipByInterfaceGetter is the function that queries the host using the
 // passed interface name and identifies the IP address assigned to it.
 type ipByInterfaceGetter func(name string) (stdnet.IP, error)
@@ -6,8 +6,10 @@
 package net
 
 import (
+	"fmt"
 	stdnet "net"
 
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
 )
@@ -16,12 +18,48 @@ func (c *Controller) Fingerprint(_ map[string]*structs.Attribute) {}
 
 func (c *Controller) Init() error { return nil }
 
+func (c *Controller) VMIsolationBuild(_ *net.VMIsolationBuildRequest) (*net.VMIsolationBuildResponse, error) {
+	return nil, fmt.Errorf("network isolation is %w on this platform", errs.ErrNotImplemented)
+}
+
+func (c *Controller) VMNetworksBuild(req *net.VMNetworksBuildRequest) (*net.VMNetworksBuildResponse, error) {
+	if req == nil {
+		return &net.VMNetworksBuildResponse{}, nil
+	}
+
+	for _, iface := range req.NetConfig {
+		if iface.Network != nil && c.managed.template(iface.Network.Name) != nil {
+			return nil, fmt.Errorf("managed networks are %w on this platform", errs.ErrNotImplemented)
+		}
+	}
+
+	return &net.VMNetworksBuildResponse{}, nil
+}
+
+func (c *Controller) VMAddressingBuild(_ *net.VMAddressingBuildRequest) (*net.VMAddressingBuildResponse, error) {
+	return nil, fmt.Errorf("driver IPAM is %w on this platform", errs.ErrNotImplemented)
+}
+
 func (c *Controller) VMStartedBuild(_ *net.VMStartedBuildRequest) (*net.VMStartedBuildResponse, error) {
 	return &net.VMStartedBuildResponse{}, nil
 }
 
+func (c *Controller) VMRecoveredBuild(_ *net.VMRecoveredBuildRequest) (*net.VMRecoveredBuildResponse, error) {
+	return &net.VMRecoveredBuildResponse{}, nil
+}
+
 func (c *Controller) VMTerminatedTeardown(_ *net.VMTerminatedTeardownRequest) (*net.VMTerminatedTeardownResponse, error) {
 	return &net.VMTerminatedTeardownResponse{}, nil
 }
 
+func (c *Controller) FilterReconcile(_ *net.FilterReconcileRequest) (*net.FilterReconcileResponse, error) {
+	return &net.FilterReconcileResponse{}, nil
+}
+
 func getInterfaceByIP(_ stdnet.IP) (string, error) { return "", nil }
+
+func tcAvailable() bool { return false }
+
+func ovsBridges() ([]string, error) { return nil, nil }
+
+func macvlanDevices(string) ([]string, error) { return nil, nil }
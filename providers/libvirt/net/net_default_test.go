@@ -9,7 +9,9 @@ import (
 	"testing"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	libvirt_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers/libvirt"
+	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
 	"github.com/shoenig/test/must"
 )
@@ -26,12 +28,40 @@ func TestController_Init(t *testing.T) {
 	must.NoError(t, mockController.Init())
 }
 
+func TestController_VMIsolationBuild(t *testing.T) {
+	mockController := NewController(hclog.NewNullLogger(), &libvirt_mock.StaticConnect{})
+	_, err := mockController.VMIsolationBuild(nil)
+	must.ErrorIs(t, err, errs.ErrNotImplemented)
+}
+
+func TestController_VMNetworksBuild(t *testing.T) {
+	mockController := NewController(hclog.NewNullLogger(), &libvirt_mock.StaticConnect{})
+	mockController.SetManagedNetworks(map[string]net.ManagedNetworkConfig{
+		"tenant": {SubnetPool: "10.100.0.0/16"},
+	})
+
+	resp, err := mockController.VMNetworksBuild(&net.VMNetworksBuildRequest{
+		NetConfig: net.NetworkInterfacesConfig{
+			{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "default"}},
+		},
+	})
+	must.NoError(t, err)
+	must.NotNil(t, resp)
+
+	_, err = mockController.VMNetworksBuild(&net.VMNetworksBuildRequest{
+		NetConfig: net.NetworkInterfacesConfig{
+			{Network: &net.NetworkInterfaceVirtualNetworkConfig{Name: "tenant"}},
+		},
+	})
+	must.ErrorIs(t, err, errs.ErrNotImplemented)
+}
+
 func TestController_VMStartedBuild(t *testing.T) {
 	mockController := NewController(hclog.NewNullLogger(), &libvirt_mock.StaticConnect{})
 	resp, err := mockController.VMStartedBuild(nil)
 	must.NoError(t, err)
 	must.NotNil(t, resp)
-	must.Nil(t, resp.TeardownSpec)
+	must.SliceEmpty(t, resp.TeardownSpecs)
 }
 
 func TestController_VMTerminatedTeardown(t *testing.T) {
@@ -8,21 +8,32 @@ package net
 import (
 	"errors"
 	"fmt"
+	"maps"
 	stdnet "net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 	"github.com/hashicorp/go-multierror"
-	"github.com/hashicorp/go-set"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/net/filter"
 	"github.com/hashicorp/nomad-driver-virt/net/filter/iptables"
+	"github.com/hashicorp/nomad-driver-virt/net/filter/nftables"
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
 	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
 	"github.com/hashicorp/nomad-driver-virt/virt/net"
+	nomadstructs "github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/shared/structs"
+	"github.com/jsimonetti/rtnetlink/v2"
 	lv "libvirt.org/go/libvirt"
 	"libvirt.org/go/libvirtxml"
 )
@@ -34,29 +45,91 @@ const (
 
 	// dhcpServerPort is the port the DHCP server is listening on.
 	dhcpServerPort = "67"
+
+	// sessionFilterBackend is the filter name fingerprinted in session mode,
+	// where no packet filter is used.
+	sessionFilterBackend = "none"
+
+	// defaultForwardMode is the forward mode of a network whose forward
+	// element does not set a mode, and isolatedForwardMode is fingerprinted
+	// for networks without a forward element.
+	defaultForwardMode  = "nat"
+	isolatedForwardMode = "isolated"
+
+	// sysClassNetPath is the sysfs directory of the host network devices.
+	sysClassNetPath = "/sys/class/net"
 )
 
+// errOpenVSwitchBridge is returned when looking up the libvirt network of an
+// Open vSwitch bridge, which is never managed by a libvirt network.
+var errOpenVSwitchBridge = errors.New("open vswitch bridges are not managed by a libvirt network")
+
+// errSessionBridge is returned when looking up the libvirt network of a
+// bridge in session mode, as the networks of the system daemon are not
+// available to the session daemon.
+var errSessionBridge = errors.New("libvirt networks are not available in session mode")
+
 // Copy returns a new copy of the controller.
 func (c *Controller) Copy(conn shims.Connect) *Controller {
 	return &Controller{
 		dhcpLeaseDiscoveryInterval: c.dhcpLeaseDiscoveryInterval,
-		dhcpLeaseDiscoveryTimeout:  c.dhcpLeaseDiscoveryTimeout,
+		discoveryTimeout:           c.discoveryTimeout,
+		dhcpLeaseFallbackInterval:  c.dhcpLeaseFallbackInterval,
 		ipByInterfaceGetter:        c.ipByInterfaceGetter,
 		filter:                     c.filter,
+		filterBackend:              c.filterBackend,
+		discovery:                  c.discovery,
 		logger:                     c.logger,
 		netConn:                    conn,
+		netns:                      c.netns,
+		arp:                        c.arp,
+		leases:                     c.leases,
+		proxy:                      c.proxy,
+		tcAvailable:                c.tcAvailable,
+		ovsBridges:                 c.ovsBridges,
+		macvlanDevices:             c.macvlanDevices,
+		session:                    c.session,
+		managed:                    c.managed,
+		migration:                  c.migration,
 	}
 }
 
 // Init initializes the network controller.
 func (c *Controller) Init() error {
+	// The packet filter requires privileges on the host, so port forwarding
+	// is performed by the user-mode networking in session mode.
+	if c.session {
+		c.logger.Debug("session mode enabled, skipping network filter setup")
+		return nil
+	}
+
 	// Set the filter if unset.
 	if c.filter == nil {
-		f, err := iptables.New()
-		if err != nil {
-			return err
+		switch c.filterBackend {
+		case filter.BackendNFTables:
+			f, err := nftables.New()
+			if err != nil {
+				return err
+			}
+			c.filter = f
+
+			// Any configuration left by the iptables filter is removed
+			// once no recovered task uses it, so traffic is not handled by
+			// both implementations.
+			if c.migration == nil {
+				c.migration = &filterMigration{
+					previous: func() (filter.Filter, error) { return iptables.New() },
+					owns:     iptables.OwnsRemoval,
+					cleanup:  iptables.Cleanup,
+				}
+			}
+		default:
+			f, err := iptables.New()
+			if err != nil {
+				return err
+			}
+			c.filter = f
 		}
-		c.filter = f
 	}
 
 	return nil
@@ -67,7 +140,43 @@ func (c *Controller) SetFilter(f filter.Filter) {
 	c.filter = f
 }
 
+// SetFilterBackend sets the name of the filter implementation the controller
+// uses when no custom network filter has been set.
+func (c *Controller) SetFilterBackend(name string) {
+	c.filterBackend = name
+}
+
 func (c *Controller) Fingerprint(attr map[string]*structs.Attribute) {
+	// In session mode, no packet filter is used and bandwidth limits can not
+	// be applied, as both require privileges on the host.
+	if c.session {
+		attr[net.FingerprintAttributeKeyPrefix+"filter"] = structs.NewStringAttribute(sessionFilterBackend)
+		attr[net.FingerprintAttributeKeyPrefix+"bandwidth"] = structs.NewBoolAttribute(false)
+	} else {
+		// Populate the attributes mapping with the packet filter
+		// implementation used for port forwarding.
+		attr[net.FingerprintAttributeKeyPrefix+"filter"] = structs.NewStringAttribute(c.filterBackend)
+
+		// Populate the attributes mapping with whether interface bandwidth
+		// limits can be applied.
+		if c.tcAvailable != nil {
+			attr[net.FingerprintAttributeKeyPrefix+"bandwidth"] = structs.NewBoolAttribute(c.tcAvailable())
+		}
+	}
+
+	// Populate the attributes mapping with the Open vSwitch bridges, which
+	// are not managed by libvirt networks. A host without Open vSwitch has no
+	// bridges, so the error is only logged at debug level. Attaching to an
+	// Open vSwitch bridge requires privileges, so none are listed in session
+	// mode.
+	if c.ovsBridges != nil && !c.session {
+		if bridges, err := c.ovsBridges(); err != nil {
+			c.logger.Debug("failed to list open vswitch bridges", "error", err)
+		} else if len(bridges) > 0 {
+			attr[net.FingerprintAttributeKeyPrefix+"openvswitch.bridges"] = structs.NewStringAttribute(strings.Join(bridges, ","))
+		}
+	}
+
 	// List the network names. This is terminal to the fingerprint process, as
 	// without this, we have nothing to query.
 	networkNames, err := c.netConn.ListNetworks()
@@ -101,6 +210,8 @@ func (c *Controller) Fingerprint(attr map[string]*structs.Attribute) {
 		netStateKey := net.FingerprintAttributeKeyPrefix + networkName + ".state"
 		attr[netStateKey] = structs.NewStringAttribute(net.IsActiveString(active))
 
+		c.fingerprintNetworkDefinition(attr, networkName, networkInfo)
+
 		bridgeName, err := networkInfo.GetBridgeName()
 		if err != nil {
 			c.logger.Error("failed to get network bridge name",
@@ -116,6 +227,143 @@ func (c *Controller) Fingerprint(attr map[string]*structs.Attribute) {
 	}
 }
 
+// fingerprintNetworkDefinition populates the attributes mapping with the
+// forward mode of the network, along with the subnet and DHCP range of the
+// first address of each family, so jobs can target networks which are
+// attached by name.
+func (c *Controller) fingerprintNetworkDefinition(attr map[string]*structs.Attribute,
+	networkName string, network shims.ConnectNetwork) {
+
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		c.logger.Error("failed to read network definition",
+			"network", networkName, "error", err)
+		return
+	}
+
+	keyPrefix := net.FingerprintAttributeKeyPrefix + networkName
+
+	forwardMode := isolatedForwardMode
+	if networkCfg.Forward != nil {
+		forwardMode = networkCfg.Forward.Mode
+		if forwardMode == "" {
+			forwardMode = defaultForwardMode
+		}
+	}
+	attr[keyPrefix+".forward_mode"] = structs.NewStringAttribute(forwardMode)
+
+	seen := make(map[string]struct{}, 2)
+	for _, ip := range networkCfg.IPs {
+		// The IPv6 attributes are suffixed, so they can be distinguished
+		// from the IPv4 attributes.
+		var suffix string
+		if ip.Family == "ipv6" {
+			suffix = "6"
+		}
+		if _, ok := seen[suffix]; ok {
+			continue
+		}
+		seen[suffix] = struct{}{}
+
+		subnet, err := networkSubnet(ip)
+		if err != nil {
+			c.logger.Warn("failed to parse network subnet",
+				"network", networkName, "address", ip.Address, "error", err)
+			continue
+		}
+		attr[keyPrefix+".subnet"+suffix] = structs.NewStringAttribute(subnet.String())
+
+		if ip.DHCP != nil && len(ip.DHCP.Ranges) > 0 {
+			dhcpRange := ip.DHCP.Ranges[0]
+			attr[keyPrefix+".dhcp_range"+suffix] = structs.NewStringAttribute(dhcpRange.Start + "-" + dhcpRange.End)
+		}
+	}
+}
+
+func (c *Controller) VMIsolationBuild(req *net.VMIsolationBuildRequest) (*net.VMIsolationBuildResponse, error) {
+	if req == nil || req.Isolation == nil {
+		return nil, errors.New("net controller: no isolation request provided")
+	}
+
+	if c.session {
+		return nil, fmt.Errorf("net controller: network isolation is %w in session mode", errs.ErrNotSupported)
+	}
+
+	if req.Isolation.Mode != drivers.NetIsolationModeGroup {
+		return nil, fmt.Errorf("net controller: network isolation mode %q is %w",
+			req.Isolation.Mode, errs.ErrNotSupported)
+	}
+
+	hwaddr, err := net.GenerateHwaddr()
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := c.netns.Attach(&netns.AttachRequest{
+		Name:  req.VMName,
+		Path:  req.Isolation.Path,
+		Ports: isolationPorts(req.Resources),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to network namespace: %w", err)
+	}
+
+	c.logger.Debug("attached to network namespace", "domain", req.VMName,
+		"path", req.Isolation.Path, "device", attachment.HostDevice)
+
+	return &net.VMIsolationBuildResponse{
+		Interface: &net.NetworkInterfaceConfig{
+			Isolation: &net.NetworkInterfaceIsolationConfig{
+				Device:  attachment.HostDevice,
+				Address: attachment.Address.String(),
+				Gateway: attachment.Gateway.String(),
+			},
+			MAC: hwaddr,
+		},
+		TeardownSpec: &net.TeardownSpec{
+			IsolationDevice: attachment.HostDevice,
+		},
+	}, nil
+}
+
+// isolationPorts returns the ports of the allocation which are forwarded from
+// the network namespace to the VM. Ports belonging to Connect sidecar proxies
+// are skipped, as the proxies run within the network namespace. A port label
+// with a protocol suffix, such as "dns/udp", only forwards that protocol,
+// otherwise both TCP and UDP are forwarded.
+func isolationPorts(res *drivers.Resources) []netns.Port {
+	if res == nil || res.Ports == nil {
+		return nil
+	}
+
+	ports := []netns.Port{}
+	for _, p := range *res.Ports {
+		if strings.HasPrefix(p.Label, nomadstructs.ConnectProxyPrefix) {
+			continue
+		}
+
+		// The port within the network namespace is the same as the port
+		// within the VM.
+		port := net.GuestPort(p)
+
+		protocols := []net.PortProtocol{net.PortProtocolTCP, net.PortProtocolUDP}
+		if strings.Contains(p.Label, "/") {
+			if mapping, err := net.ParsePortMapping(p.Label); err == nil {
+				protocols = []net.PortProtocol{mapping.Protocol}
+			}
+		}
+
+		for _, protocol := range protocols {
+			forward := netns.Port{Port: port, Protocol: string(protocol)}
+			if !slices.Contains(ports, forward) {
+				ports = append(ports, forward)
+			}
+		}
+	}
+
+	return ports
+}
+
 func (c *Controller) VMStartedBuild(req *net.VMStartedBuildRequest) (*net.VMStartedBuildResponse, error) {
 	if req == nil {
 		return nil, errors.New("net controller: no request provided")
@@ -124,9 +372,6 @@ func (c *Controller) VMStartedBuild(req *net.VMStartedBuildRequest) (*net.VMStar
 		return &net.VMStartedBuildResponse{}, nil
 	}
 
-	// Dereference the network config and pull out the interface detail. The
-	// driver only supports a single interface currently, so this is safe to
-	// do, but when multi-interface support is added, this will need to change.
 	netConfig := req.NetConfig
 
 	// Protect against VMs with no network interface. The log is useful for
@@ -135,52 +380,527 @@ func (c *Controller) VMStartedBuild(req *net.VMStartedBuildRequest) (*net.VMStar
 		c.logger.Debug("no network interface configured", "domain", req.VMName)
 		return &net.VMStartedBuildResponse{}, nil
 	}
-	netInterface := netConfig[0]
 
-	networkName, err := c.networkNameFromBridgeName(netInterface.Bridge.Name)
+	primary := netConfig.Primary()
+	resp := &net.VMStartedBuildResponse{
+		Addresses: make([]*net.InterfaceAddresses, len(netConfig)),
+	}
+
+	// Track the macvtap interfaces whose addresses must be discovered, so
+	// the discovery can be performed for all of them at once.
+	var discover []int
+
+	for i, netInterface := range netConfig {
+		// Only interfaces attached to a bridge, either by bridge name or by
+		// network name, are configured by the controller. Other interface
+		// types, such as macvtap, manage their own network identity
+		// and have no interaction with the host-side port mapping. The
+		// address of a statically addressed interface is known, and the
+		// address of a macvtap interface can be discovered, so they can still
+		// be returned.
+		if netInterface.Bridged() == nil {
+			if netInterface.Macvtap != nil {
+				discover = append(discover, i)
+			} else if addrs := netInterface.NetworkConfig.StaticAddresses(); addrs != nil {
+				resp.Addresses[i] = addrs
+
+				// The addresses of a user-mode interface are private to the
+				// VM and its ports are forwarded from the host addresses, so
+				// no driver network is returned.
+				if netInterface == primary && netInterface.User == nil {
+					resp.DriverNetwork = &drivers.DriverNetwork{
+						IP: advertiseAddress(false, addrs),
+					}
+				}
+			}
+			continue
+		}
+
+		addrs, teardownSpec, err := c.buildBridgeInterface(req, netInterface, interfaceHwaddrs(req, i))
+		if teardownSpec != nil {
+			resp.TeardownSpecs = append(resp.TeardownSpecs, teardownSpec)
+		}
+		if err != nil {
+			// Remove any configuration already applied, so a failed start does
+			// not leave anything behind on the host.
+			if _, teardownErr := c.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+				TeardownSpecs: resp.TeardownSpecs,
+			}); teardownErr != nil {
+				c.logger.Error("failed to teardown network configuration", "domain", req.VMName,
+					"error", teardownErr)
+			}
+			return nil, fmt.Errorf("network_interface[%d]: %w", i+1, err)
+		}
+
+		resp.Addresses[i] = addrs
+
+		if netInterface == primary {
+			bridge := netInterface.Bridged()
+			resp.DriverNetwork = &drivers.DriverNetwork{
+				PortMap: bridgePortMap(req.Resources, bridge),
+				IP:      advertiseAddress(bridge.AdvertiseIPv6, addrs),
+			}
+		}
+	}
+
+	// Discover the addresses of the macvtap interfaces concurrently, so the
+	// start of the VM is delayed by at most a single discovery timeout.
+	var wg sync.WaitGroup
+	for _, i := range discover {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			target := &discoveryTarget{
+				vmName:         req.VMName,
+				hostname:       req.Hostname,
+				uniqueHostname: req.UniqueHostname,
+				device:         netConfig[i].Macvtap.Device,
+				hwaddrs:        interfaceHwaddrs(req, i),
+				netConfig:      netConfig[i].NetworkConfig,
+			}
+
+			// The host does not reach a macvtap interface through its lower
+			// device, only through a macvlan device sharing the lower device,
+			// so the neighbor entries of the interface are found there.
+			if c.macvlanDevices != nil {
+				devices, err := c.macvlanDevices(target.device)
+				if err != nil {
+					c.logger.Debug("failed to list macvlan devices", "device", target.device, "error", err)
+				}
+				target.hostDevices = devices
+			}
+
+			result, err := c.discoverAddresses(target, c.interfaceDiscovery(netConfig[i]))
+			if err != nil {
+				c.logger.Warn("failed to discover interface address", "domain", req.VMName,
+					"interface", i+1, "device", target.device, "error", err)
+				return
+			}
+			resp.Addresses[i] = result.addrs
+		}()
+	}
+	wg.Wait()
+
+	for _, i := range discover {
+		if netConfig[i] == primary && resp.Addresses[i] != nil {
+			resp.DriverNetwork = &drivers.DriverNetwork{
+				IP: advertiseAddress(false, resp.Addresses[i]),
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// buildBridgeInterface discovers the addresses of a bridged interface,
+// reserves any DHCP leases with the DHCP server and configures any port
+// mappings. The returned teardown specification will be populated with
+// whatever configuration was applied, even when an error is returned.
+func (c *Controller) buildBridgeInterface(req *net.VMStartedBuildRequest,
+	netInterface *net.NetworkInterfaceConfig, hwaddrs []string) (*net.InterfaceAddresses, *net.TeardownSpec, error) {
+
+	bridge := netInterface.Bridge
+
+	// Interfaces attached by network name use the bridge of the network,
+	// which is only known once the network has been looked up.
+	var networkName string
+	if netInterface.Network != nil {
+		networkName = netInterface.Network.Name
+
+		bridgeName, err := c.bridgeNameFromNetworkName(networkName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to lookup network: %w", err)
+		}
+		bridge = netInterface.Network.BridgeConfig(bridgeName)
+	}
+
+	if err := c.checkNATPortForwards(bridge, networkName); err != nil {
+		return nil, nil, err
+	}
+
+	// Addresses assigned before the VM was created are already reserved, so
+	// only the port mappings need to be configured.
+	if netInterface.AssignedAddresses != nil {
+		addrs := netInterface.AssignedAddresses
+		teardownSpec, err := c.configureFilter(req.Resources, bridge, addrs, &net.TeardownSpec{})
+		return addrs, teardownSpec, err
+	}
+
+	target := &discoveryTarget{
+		vmName:         req.VMName,
+		hostname:       req.Hostname,
+		uniqueHostname: req.UniqueHostname,
+		device:         bridge.Name,
+		hwaddrs:        hwaddrs,
+		netConfig:      netInterface.NetworkConfig,
+	}
+
+	// The libvirt network is only required to discover and reserve DHCP
+	// leases, so bridges which are not managed by libvirt can still be used
+	// with the other discovery strategies. Open vSwitch bridges are never
+	// managed by a libvirt network, and the libvirt networks are not
+	// available in session mode, so the lookup is skipped.
+	var lookupErr error
+	switch {
+	case netInterface.Network != nil:
+		// The network has already been looked up by its name.
+	case bridge.OpenVSwitch != nil:
+		lookupErr = errOpenVSwitchBridge
+	case c.session:
+		lookupErr = errSessionBridge
+	default:
+		networkName, lookupErr = c.networkNameFromBridgeName(bridge.Name)
+	}
+	if lookupErr == nil {
+		network, err := c.netConn.LookupNetworkByName(networkName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to lookup network: %w", err)
+		}
+		defer network.Free()
+
+		target.network = network
+		target.networkName = networkName
+	}
+
+	// Statically addressed interfaces do not acquire a DHCP lease, so when
+	// the static strategy is preferred the configured addresses are used
+	// without discovery and there is nothing to reserve.
+	strategies := c.interfaceDiscovery(netInterface)
+	if addrs := netInterface.NetworkConfig.StaticAddresses(); addrs != nil &&
+		len(strategies) > 0 && strategies[0] == net.DiscoveryStrategyStatic {
+		return c.buildStaticBridgeInterface(req, bridge, target.network, networkName, addrs)
+	}
+
+	result, err := c.discoverAddresses(target, strategies)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover network: %w", err)
+		if errors.Is(err, errNoDiscoveryStrategy) && lookupErr != nil {
+			return nil, nil, fmt.Errorf("failed to discover network: %w", lookupErr)
+		}
+		return nil, nil, fmt.Errorf("failed to discover IP address: %w", err)
+	}
+
+	addrs := result.addrs
+	teardownSpec := &net.TeardownSpec{
+		Network: networkName,
+	}
+
+	if result.ipv4Lease != nil {
+		// Register the IP to the domain to ensure it does not change.
+		teardownSpec.DHCPReservation, err = c.reserveIP(target.network, libvirtxml.NetworkDHCPHost{
+			IP:   result.ipv4Lease.IPaddr,
+			MAC:  result.ipv4Lease.Mac,
+			Name: req.Hostname,
+		})
+		if err != nil {
+			c.logger.Warn("failed to reserve IP address", "network", networkName, "address", result.ipv4Lease.IPaddr,
+				"hostname", req.Hostname, "mac", result.ipv4Lease.Mac, "error", err)
+		}
+	}
+
+	if result.ipv6Lease != nil {
+		// DHCPv6 host entries are identified using the client DUID rather
+		// than the MAC address, which libvirt rejects for IPv6 entries.
+		teardownSpec.IPv6DHCPReservation, err = c.reserveIP(target.network, libvirtxml.NetworkDHCPHost{
+			ID:   result.ipv6Lease.Clientid,
+			IP:   result.ipv6Lease.IPaddr,
+			Name: req.Hostname,
+		})
+		if err != nil {
+			c.logger.Warn("failed to reserve IPv6 address", "network", networkName, "address", result.ipv6Lease.IPaddr,
+				"hostname", req.Hostname, "duid", result.ipv6Lease.Clientid, "error", err)
+		}
+	}
+
+	if target.network != nil {
+		teardownSpec.DNSHosts = c.registerDNSHosts(target.network, addrs,
+			dnsHostnames(req.Hostname, bridge.DNSAliases))
+	}
+
+	teardownSpec, err = c.configureFilter(req.Resources, bridge, addrs, teardownSpec)
+	return addrs, teardownSpec, err
+}
+
+// checkNATPortForwards returns an error when the ports of the bridged
+// interface would be forwarded by the nftables filter to a libvirt network
+// using NAT. Libvirt rejects forwarded connections to a NAT network which were
+// not initiated by the guest, and an accept within the nftables filter does
+// not override a reject within the firewall rules of libvirt, so the ports
+// would not be reachable. The network name is empty when the interface is
+// attached by bridge name.
+func (c *Controller) checkNATPortForwards(bridge *net.NetworkInterfaceBridgeConfig, networkName string) error {
+	if c.filterBackend != filter.BackendNFTables || c.session || bridge.OpenVSwitch != nil || len(bridge.Ports) == 0 {
+		return nil
+	}
+
+	// Bridges which are not managed by libvirt have no libvirt firewall
+	// rules, so the ports can be forwarded.
+	if networkName == "" {
+		var err error
+		if networkName, err = c.networkNameFromBridgeName(bridge.Name); err != nil {
+			return nil
+		}
 	}
 
 	network, err := c.netConn.LookupNetworkByName(networkName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup network: %w", err)
+		return fmt.Errorf("failed to lookup network: %w", err)
 	}
 	defer network.Free()
 
-	ipAddr, macAddr, err := c.discoverDHCPLeaseIP(network, req.Hostname, networkName, req.Hwaddrs)
+	networkCfg, err := networkDefinition(network)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover IP address: %w", err)
+		return fmt.Errorf("failed to get network %q definition: %w", networkName, err)
 	}
 
-	// Register the IP to the domain to ensure it does not change.
-	dhcpEntry, err := c.reserveIP(network, ipAddr, req.Hostname, macAddr)
-	if err != nil {
-		c.logger.Warn("failed to reserve IP address", "network", network, "address", ipAddr, "hostname",
-			req.Hostname, "mac", macAddr, "error", err)
+	if networkCfg.Forward != nil && (networkCfg.Forward.Mode == "" || networkCfg.Forward.Mode == defaultForwardMode) {
+		return fmt.Errorf("port forwarding to network %q using NAT is %w by the %s network filter",
+			networkName, errs.ErrNotSupported, filter.BackendNFTables)
 	}
 
-	teardownRules, err := c.filter.Configure(req.Resources, netInterface.Bridge, ipAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to configure port mapping: %w", err)
+	return nil
+}
+
+// buildStaticBridgeInterface configures the port mappings of a statically
+// addressed bridged interface. The network is nil when the bridge is not
+// managed by libvirt. The returned teardown specification will be populated
+// with whatever configuration was applied, even when an error is returned.
+func (c *Controller) buildStaticBridgeInterface(req *net.VMStartedBuildRequest, bridge *net.NetworkInterfaceBridgeConfig,
+	network shims.ConnectNetwork, networkName string, addrs *net.InterfaceAddresses) (*net.InterfaceAddresses, *net.TeardownSpec, error) {
+
+	c.logger.Debug("using static addresses", "domain", req.VMName, "network", networkName,
+		"ipv4", addrs.IPv4, "ipv6", addrs.IPv6)
+
+	teardownSpec := &net.TeardownSpec{
+		Network: networkName,
 	}
 
-	return &net.VMStartedBuildResponse{
-		DriverNetwork: &drivers.DriverNetwork{
-			IP: ipAddr,
-		},
-		TeardownSpec: &net.TeardownSpec{
-			FilterRemoval:   teardownRules,
-			DHCPReservation: dhcpEntry,
-			Network:         networkName,
-		},
-	}, nil
+	if network != nil {
+		teardownSpec.DNSHosts = c.registerDNSHosts(network, addrs,
+			dnsHostnames(req.Hostname, bridge.DNSAliases))
+	}
+
+	teardownSpec, err := c.configureFilter(req.Resources, bridge, addrs, teardownSpec)
+	return addrs, teardownSpec, err
+}
+
+// configureFilter configures the port mappings of the bridged interface for
+// each address family, recording the applied rules within the passed teardown
+// specification.
+func (c *Controller) configureFilter(res *drivers.Resources, bridge *net.NetworkInterfaceBridgeConfig,
+	addrs *net.InterfaceAddresses, teardownSpec *net.TeardownSpec) (*net.TeardownSpec, error) {
+
+	// No packet filter is used in session mode, so only bridges without port
+	// mappings or policies can be configured.
+	if c.filter == nil && c.session {
+		if len(bridge.Ports) > 0 || len(bridge.Ingress) > 0 || bridge.Egress.Restricted() || bridge.EgressIP != "" {
+			return teardownSpec, fmt.Errorf("bridge ports and policies are %w in session mode", errs.ErrNotSupported)
+		}
+		return teardownSpec, nil
+	}
+
+	var err error
+	if addrs.IPv4 != "" {
+		teardownSpec.FilterRemoval, err = c.filter.Configure(res, bridge, addrs.IPv4)
+
+		// When the host does not route localnet packets, the loopback port
+		// forwards are relayed by the proxy and the remaining port forwards
+		// are configured using the packet filter.
+		if errors.Is(err, filter.ErrLoopbackNotEnabled) && c.proxy != nil {
+			c.logger.Info("loopback port forwarding not enabled, using proxy for loopback ports",
+				"address", addrs.IPv4)
+
+			filtered, forwards := loopbackProxyForwards(res, bridge, addrs.IPv4)
+			teardownSpec.FilterRemoval, err = c.filter.Configure(res, filtered, addrs.IPv4)
+			if err == nil {
+				err = c.startProxies(forwards, teardownSpec)
+			}
+		}
+		if err != nil {
+			return teardownSpec, fmt.Errorf("failed to configure port mapping: %w", err)
+		}
+	}
+
+	if addrs.IPv6 != "" {
+		teardownSpec.IPv6FilterRemoval, err = c.filter.Configure(res, bridge, addrs.IPv6)
+		if err != nil {
+			return teardownSpec, fmt.Errorf("failed to configure IPv6 port mapping: %w", err)
+		}
+	}
+
+	return teardownSpec, nil
+}
+
+// loopbackProxyForwards splits the ports of the bridged interface into the
+// port forwards from loopback addresses, which are relayed to the passed
+// address by the proxy, and a copy of the bridge config containing the
+// remaining ports, which are configured using the packet filter.
+func loopbackProxyForwards(res *drivers.Resources, bridge *net.NetworkInterfaceBridgeConfig,
+	ip string) (*net.NetworkInterfaceBridgeConfig, []*net.ProxyForward) {
+
+	filtered := *bridge
+	filtered.Ports = nil
+
+	var forwards []*net.ProxyForward
+	for _, entry := range bridge.Ports {
+		mapping, err := net.ParsePortMapping(entry)
+		if err != nil || res.Ports == nil {
+			filtered.Ports = append(filtered.Ports, entry)
+			continue
+		}
+
+		reservedPort, ok := res.Ports.Get(mapping.Label)
+		if !ok {
+			filtered.Ports = append(filtered.Ports, entry)
+			continue
+		}
+
+		hostIP, err := netip.ParseAddr(reservedPort.HostIP)
+		if err != nil || !hostIP.IsLoopback() || hostIP.Unmap().Is6() {
+			filtered.Ports = append(filtered.Ports, entry)
+			continue
+		}
+
+		// The proxy relays each port of a range individually. Ranges which
+		// are not valid are left to the packet filter to report.
+		if mapping.IsRange() {
+			first, last, ok, err := mapping.Range(*res.Ports)
+			if err != nil || !ok {
+				filtered.Ports = append(filtered.Ports, entry)
+				continue
+			}
+
+			for value := first.Value; value <= last.Value; value++ {
+				forwards = append(forwards, &net.ProxyForward{
+					Protocol: mapping.Protocol,
+					Listen:   stdnet.JoinHostPort(reservedPort.HostIP, strconv.Itoa(value)),
+					Target:   stdnet.JoinHostPort(ip, strconv.Itoa(value)),
+				})
+			}
+			continue
+		}
+
+		forwards = append(forwards, &net.ProxyForward{
+			Protocol: mapping.Protocol,
+			Listen:   stdnet.JoinHostPort(reservedPort.HostIP, strconv.Itoa(reservedPort.Value)),
+			Target:   stdnet.JoinHostPort(ip, strconv.Itoa(net.GuestPort(reservedPort))),
+		})
+	}
+
+	return &filtered, forwards
+}
+
+// startProxies starts relaying the port forwards using the proxy, recording
+// each started port forward within the passed teardown specification.
+func (c *Controller) startProxies(forwards []*net.ProxyForward, teardownSpec *net.TeardownSpec) error {
+	for _, forward := range forwards {
+		if err := c.proxy.Start(forward); err != nil {
+			return err
+		}
+		teardownSpec.Proxies = append(teardownSpec.Proxies, forward)
+	}
+
+	return nil
+}
+
+func (c *Controller) VMRecoveredBuild(req *net.VMRecoveredBuildRequest) (*net.VMRecoveredBuildResponse, error) {
+	if req == nil {
+		return &net.VMRecoveredBuildResponse{}, nil
+	}
+
+	var mErr *multierror.Error
+	for _, spec := range req.TeardownSpecs {
+		if spec == nil {
+			continue
+		}
+
+		// Restore the reference to the managed network, so it is not
+		// destroyed while the VM is attached.
+		if spec.ManagedNetwork != nil && c.managed != nil {
+			c.recoverManagedNetwork(spec.ManagedNetwork)
+		}
+
+		// Restart the port forwards relayed by the proxy, as they do not
+		// survive a restart of the driver.
+		if c.proxy == nil {
+			continue
+		}
+		for _, forward := range spec.Proxies {
+			if err := c.proxy.Start(forward); err != nil {
+				mErr = multierror.Append(mErr, err)
+			}
+		}
+	}
+
+	if err := mErr.ErrorOrNil(); err != nil {
+		return nil, fmt.Errorf("failed to recover port forward proxies for %s: %w", req.VMName, err)
+	}
+
+	return &net.VMRecoveredBuildResponse{}, nil
+}
+
+// bridgePortMap returns the mapping of port labels to the ports within the
+// VM for the ports exposed by the bridged interface. Ports without a mapped
+// value are exposed within the VM using the host port. Nil is returned when
+// the interface does not expose any ports.
+func bridgePortMap(res *drivers.Resources, bridge *net.NetworkInterfaceBridgeConfig) map[string]int {
+	if res == nil || res.Ports == nil || len(bridge.Ports) == 0 {
+		return nil
+	}
+
+	portMap := map[string]int{}
+	for _, entry := range bridge.Ports {
+		mapping, err := net.ParsePortMapping(entry)
+		if err != nil {
+			continue
+		}
+
+		// Only the first and last ports of a range are known by their
+		// label, and the ports of a range are not translated.
+		for _, label := range []string{mapping.Label, mapping.LastLabel} {
+			reservedPort, ok := res.Ports.Get(label)
+			if !ok {
+				continue
+			}
+
+			portMap[label] = net.GuestPort(reservedPort)
+		}
+	}
+
+	if len(portMap) == 0 {
+		return nil
+	}
+
+	return portMap
+}
+
+// advertiseAddress returns the address of the interface which should be
+// advertised to Nomad. The IPv4 address is preferred unless the interface
+// has been configured to advertise IPv6, or no IPv4 address is available.
+func advertiseAddress(advertiseIPv6 bool, addrs *net.InterfaceAddresses) string {
+	if addrs.IPv6 != "" && (advertiseIPv6 || addrs.IPv4 == "") {
+		return addrs.IPv6
+	}
+
+	return addrs.IPv4
+}
+
+// interfaceHwaddrs returns the hardware addresses which should be used when
+// discovering the address of the interface at the passed index. When the
+// hardware addresses cannot be correlated to the configured interfaces, all
+// addresses are returned.
+func interfaceHwaddrs(req *net.VMStartedBuildRequest, idx int) []string {
+	if len(req.Hwaddrs) != len(req.NetConfig) {
+		return req.Hwaddrs
+	}
+
+	return req.Hwaddrs[idx : idx+1]
 }
 
 func (c *Controller) VMTerminatedTeardown(req *net.VMTerminatedTeardownRequest) (*net.VMTerminatedTeardownResponse, error) {
 	// We can't be exactly sure what the caller will give us, so make sure we
 	// don't panic the driver.
-	if req == nil || req.TeardownSpec == nil {
+	if req == nil {
 		return &net.VMTerminatedTeardownResponse{}, nil
 	}
 
@@ -188,36 +908,144 @@ func (c *Controller) VMTerminatedTeardown(req *net.VMTerminatedTeardownRequest)
 	// information to manually tidy if needed.
 	var mErr *multierror.Error
 
-	// Teardown any filter rules.
-	if req.TeardownSpec.FilterRemoval != nil {
-		mErr = multierror.Append(mErr,
-			c.filter.Teardown(req.TeardownSpec.FilterRemoval))
-	}
+	for _, spec := range req.TeardownSpecs {
+		if spec == nil {
+			continue
+		}
 
-	// Remove the DHCP IP reservation.
-	if req.TeardownSpec.Network != "" && req.TeardownSpec.DHCPReservation != "" {
-		mErr = multierror.Append(mErr,
-			c.removeIPReservation(req.TeardownSpec.Network, req.TeardownSpec.DHCPReservation))
+		// Teardown any filter rules.
+		for _, removal := range []*net.FilterRemoval{spec.FilterRemoval, spec.IPv6FilterRemoval} {
+			if removal != nil {
+				mErr = multierror.Append(mErr, c.teardownFilter(removal))
+			}
+		}
 
-		// Release the DHCP lease. This is best effort only, so any errors encountered
-		// are simply logged.
-		if err := c.releaseDHCPLease(req.TeardownSpec.Network, req.TeardownSpec.DHCPReservation); err != nil {
-			c.logger.Error("failed to release DHCP lease", "error", err)
+		// Stop the port forwards relayed by the proxy.
+		if c.proxy != nil {
+			for _, forward := range spec.Proxies {
+				mErr = multierror.Append(mErr, c.proxy.Stop(forward))
+			}
+		}
+
+		// Remove the link to the network namespace.
+		if spec.IsolationDevice != "" {
+			mErr = multierror.Append(mErr, c.netns.Detach(spec.IsolationDevice))
+		}
+
+		// Remove the DHCP IP reservation.
+		if spec.Network != "" && spec.DHCPReservation != "" {
+			mErr = multierror.Append(mErr,
+				c.removeIPReservation(spec.Network, spec.DHCPReservation))
+
+			// Release the DHCP lease. This is best effort only, so any errors encountered
+			// are simply logged.
+			if err := c.releaseDHCPLease(spec.Network, spec.DHCPReservation); err != nil {
+				c.logger.Error("failed to release DHCP lease", "error", err)
+			}
+		}
+
+		// Remove the DHCPv6 IP reservation. The lease is left to expire, as
+		// releasing requires the client DUID to be used by the sender.
+		if spec.Network != "" && spec.IPv6DHCPReservation != "" {
+			mErr = multierror.Append(mErr,
+				c.removeIPReservation(spec.Network, spec.IPv6DHCPReservation))
+		}
+
+		// Remove the DNS host entries.
+		if spec.Network != "" {
+			for _, entry := range spec.DNSHosts {
+				mErr = multierror.Append(mErr, c.removeDNSHost(spec.Network, entry))
+			}
+		}
+	}
+
+	// Release the managed networks once the configuration within them has
+	// been removed, as the last release destroys the network.
+	for _, spec := range req.TeardownSpecs {
+		if spec != nil && spec.ManagedNetwork != nil && c.managed != nil {
+			mErr = multierror.Append(mErr, c.releaseManagedNetwork(spec.ManagedNetwork))
 		}
 	}
 
 	return &net.VMTerminatedTeardownResponse{}, mErr.ErrorOrNil()
 }
 
-// reserveIP reserves an IP address with the DHCP server for a specific domain
-// based on MAC address and hostname.
-func (c *Controller) reserveIP(network shims.ConnectNetwork, ipAddr, hostname, mac string) (string, error) {
-	reservation := libvirtxml.NetworkDHCPHost{
-		IP:   ipAddr,
-		MAC:  mac,
-		Name: hostname,
+func (c *Controller) FilterReconcile(req *net.FilterReconcileRequest) (*net.FilterReconcileResponse, error) {
+	resp := &net.FilterReconcileResponse{Restored: map[string]int{}}
+	if req == nil {
+		return resp, nil
 	}
 
+	// Flatten the filter removals, recording the VM each belongs to so the
+	// drift can be reported per VM. The VM names are sorted so the removals
+	// are reconciled in a stable order. Removals created by the previous
+	// filter implementation are not reconciled by the current one.
+	var (
+		removals []*net.FilterRemoval
+		owners   []string
+		previous bool
+	)
+	for _, vmName := range slices.Sorted(maps.Keys(req.TeardownSpecs)) {
+		for _, spec := range req.TeardownSpecs[vmName] {
+			if spec == nil {
+				continue
+			}
+
+			for _, removal := range []*net.FilterRemoval{spec.FilterRemoval, spec.IPv6FilterRemoval} {
+				if c.migration.owned(removal) {
+					previous = true
+				} else if removal != nil {
+					removals = append(removals, removal)
+					owners = append(owners, vmName)
+				}
+			}
+		}
+	}
+
+	// When pruning, all the tasks are known, so the configuration of the
+	// previous filter can be removed once no task uses it.
+	if req.Prune {
+		c.migration.complete(c.logger, previous)
+	}
+
+	// Only some filter implementations can repair their configuration, and
+	// no filter is used in session mode.
+	reconciler, ok := c.filter.(filter.Reconciler)
+	if !ok {
+		return resp, nil
+	}
+
+	drift, err := reconciler.Reconcile(removals, req.Prune)
+	if drift != nil {
+		for i, restored := range drift.Restored {
+			if restored > 0 {
+				resp.Restored[owners[i]] += restored
+			}
+		}
+		resp.BaseRestored = drift.BaseRestored
+		resp.Removed = drift.Removed
+	}
+
+	if err != nil {
+		return resp, fmt.Errorf("failed to reconcile packet filter: %w", err)
+	}
+
+	return resp, nil
+}
+
+// teardownFilter removes the filter configuration described by the removal,
+// using the previous filter implementation when it created the removal.
+func (c *Controller) teardownFilter(removal *net.FilterRemoval) error {
+	if c.migration.owned(removal) {
+		return c.migration.teardown(removal)
+	}
+
+	return c.filter.Teardown(removal)
+}
+
+// reserveIP reserves an IP address with the DHCP server for a specific domain
+// using the passed host entry.
+func (c *Controller) reserveIP(network shims.ConnectNetwork, reservation libvirtxml.NetworkDHCPHost) (string, error) {
 	c.logger.Debug("adding dhcp reservation", "reservation", reservation)
 
 	entry, err := reservation.Marshal()
@@ -226,7 +1054,8 @@ func (c *Controller) reserveIP(network shims.ConnectNetwork, ipAddr, hostname, m
 	}
 
 	err = network.Update(lv.NETWORK_UPDATE_COMMAND_ADD_LAST, lv.NETWORK_SECTION_IP_DHCP_HOST,
-		automaticParentIndex, entry, lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG)
+		c.dhcpParentIndex(network, reservation.IP), entry,
+		lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to update network: %w", err)
@@ -235,6 +1064,93 @@ func (c *Controller) reserveIP(network shims.ConnectNetwork, ipAddr, hostname, m
 	return entry, nil
 }
 
+// registerDNSHosts adds a DNS host entry to the network for each address of
+// an interface, so other VMs on the network can resolve the VM using its
+// hostname and aliases. The added entries are returned, so they can be
+// removed on teardown. Failures are only logged, as name resolution is not
+// required for the VM to run.
+func (c *Controller) registerDNSHosts(network shims.ConnectNetwork, addrs *net.InterfaceAddresses, hostnames []string) []string {
+	if len(hostnames) == 0 || addrs == nil {
+		return nil
+	}
+
+	var entries []string
+	for _, addr := range []string{addrs.IPv4, addrs.IPv6} {
+		if addr == "" {
+			continue
+		}
+
+		host := libvirtxml.NetworkDNSHost{IP: addr}
+		for _, hostname := range hostnames {
+			host.Hostnames = append(host.Hostnames, libvirtxml.NetworkDNSHostHostname{Hostname: hostname})
+		}
+
+		entry, err := host.Marshal()
+		if err != nil {
+			c.logger.Warn("failed to generate DNS host entry", "address", addr, "error", err)
+			continue
+		}
+
+		c.logger.Debug("adding dns host", "host", entry)
+
+		if err := network.Update(lv.NETWORK_UPDATE_COMMAND_ADD_LAST, lv.NETWORK_SECTION_DNS_HOST,
+			automaticParentIndex, entry, lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG); err != nil {
+			c.logger.Warn("failed to add DNS host entry", "address", addr, "hostnames", hostnames, "error", err)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// dnsHostnames returns the names registered within the network DNS for an
+// interface, starting with the hostname of the VM.
+func dnsHostnames(hostname string, aliases []string) []string {
+	var names []string
+	if hostname != "" {
+		names = append(names, hostname)
+	}
+
+	for _, alias := range aliases {
+		if !slices.Contains(names, alias) {
+			names = append(names, alias)
+		}
+	}
+
+	return names
+}
+
+// removeDNSHost removes the DNS host entry from the network if it exists.
+func (c *Controller) removeDNSHost(networkName, entry string) error {
+	host := &libvirtxml.NetworkDNSHost{}
+	if err := host.Unmarshal(entry); err != nil {
+		return fmt.Errorf("could not parse DNS host entry: %w", err)
+	}
+
+	network, err := c.netConn.LookupNetworkByName(networkName)
+	if err != nil {
+		return fmt.Errorf("failed to find network %q: %w", networkName, err)
+	}
+	defer network.Free()
+
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		return err
+	}
+
+	if networkCfg.DNS == nil || !slices.ContainsFunc(networkCfg.DNS.Host, func(h libvirtxml.NetworkDNSHost) bool {
+		return h.IP == host.IP && slices.Equal(h.Hostnames, host.Hostnames)
+	}) {
+		c.logger.Debug("dns host not found", "host", entry)
+		return nil
+	}
+
+	return network.Update(lv.NETWORK_UPDATE_COMMAND_DELETE, lv.NETWORK_SECTION_DNS_HOST,
+		automaticParentIndex, entry, lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG)
+}
+
 // networkNameFromBridgeName translates the name of a bridge network interface
 // to a libvirt network name. Operators only need to specify the interface name
 // when creating VMs, but we need the network name.
@@ -266,88 +1182,203 @@ func (c *Controller) networkNameFromBridgeName(name string) (string, error) {
 	return "", fmt.Errorf("failed to find network with bridge %q", name)
 }
 
-// discoverDHCPLeaseIP identifies the IP assigned to the named VM on the named
-// network. The function includes a ticker in order to poll for the information
-// as this can take several seconds to become available.
-func (c *Controller) discoverDHCPLeaseIP(
-	network shims.ConnectNetwork, hostname, netName string, hwaddrs []string) (ipAddr string, macAddr string, err error) {
-
-	ticker := time.NewTicker(c.dhcpLeaseDiscoveryInterval)
-	defer ticker.Stop()
-
-	timeout := time.NewTimer(c.dhcpLeaseDiscoveryTimeout)
-	defer timeout.Stop()
-
-	macs := set.From(hwaddrs)
-	for {
-		select {
-		case <-ticker.C:
-
-			// If we do not log, the driver and Nomad seem to stall from the
-			// user perspective, which might be off-putting. Providing some
-			// debug entry while performing this "long-lived" process should
-			// help operators understand what is happening.
-			c.logger.Debug("attempting DHCP lease discovery",
-				"hostname", hostname, "network_name", netName,
-				"hwaddrs", hwaddrs)
-
-			// Lookup the DHCP leases of the network. If we receive any error,
-			// log and try again. If it is transient error, we will find the
-			// information on the next try, otherwise the timeout acts as our
-			// retry cutoff.
-			dhcpLeases, err := network.GetDHCPLeases()
-			if err != nil {
-				c.logger.Warn("failed to lookup DHCP leases",
-					"network_name", netName, "error", err)
-				continue
-			}
+// bridgeNameFromNetworkName returns the name of the bridge of the libvirt
+// network with the passed name. An error is returned when the network does
+// not use a bridge, such as a network in passthrough mode.
+func (c *Controller) bridgeNameFromNetworkName(name string) (string, error) {
+	network, err := c.netConn.LookupNetworkByName(name)
+	if err != nil {
+		return "", err
+	}
+	defer network.Free()
 
-			matches := []lv.NetworkDHCPLease{}
+	bridgeName, err := network.GetBridgeName()
+	if err != nil {
+		return "", fmt.Errorf("failed to get bridge of network %q: %w", name, err)
+	}
 
-			// Gather all matching leases
-			for _, lease := range dhcpLeases {
-				// Check if lease matches any available interfaces on the domain.
-				if !macs.Contains(lease.Mac) {
-					continue
-				}
+	return bridgeName, nil
+}
 
-				// Check if the hostname is set, and matches
-				if lease.Hostname != "" && lease.Hostname != hostname {
-					continue
-				}
+// latestLease returns the lease which should be used from the matching
+// leases. When multiple leases match, they are sorted in descending order by
+// the lease expiry date. This is done to handle situations where an
+// interface's MAC address is being set and the instance has been destroyed
+// and created again resulting in multiple leases for the same MAC.
+func latestLease(matches []lv.NetworkDHCPLease) *lv.NetworkDHCPLease {
+	if len(matches) == 0 {
+		return nil
+	}
 
-				// Only want to add leases that are still valid.
-				if lease.ExpiryTime.Before(time.Now()) {
-					continue
-				}
+	slices.SortFunc(matches, func(a, b lv.NetworkDHCPLease) int {
+		return b.ExpiryTime.Compare(a.ExpiryTime)
+	})
 
-				c.logger.Debug("DHCP lease detected", "hostname", hostname, "network_name", netName,
-					"hwaddrs", hwaddrs, "lease", lease)
-				matches = append(matches, lease)
-			}
+	return &matches[0]
+}
 
-			if len(matches) == 0 {
-				continue
-			}
+// dhcpFamilies returns the address families which the network provides DHCP
+// for. If the network definition cannot be read, only IPv4 is assumed which
+// matches the behaviour of libvirt's default network.
+func dhcpFamilies(network shims.ConnectNetwork) (ipv4, ipv6 bool) {
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		return true, false
+	}
 
-			// If any matches were found, sort them in descending order
-			// by the lease expiry date and return the address with the
-			// expiry furthest in the future. This is done to handle situations
-			// where an interface's MAC address is being set and the instance
-			// has been destroyed and created again resulting in multiple
-			// leases for the same MAC.
-			slices.SortFunc(matches, func(a, b lv.NetworkDHCPLease) int {
-				return b.ExpiryTime.Compare(a.ExpiryTime)
-			})
+	for _, ip := range networkCfg.IPs {
+		if ip.DHCP == nil {
+			continue
+		}
 
-			lease := matches[len(matches)-1]
+		if ip.Family == "ipv6" {
+			ipv6 = true
+		} else {
+			ipv4 = true
+		}
+	}
+
+	// Networks without any DHCP configuration can still have leases
+	// discovered, so fallback to IPv4 as with an unreadable definition.
+	if !ipv4 && !ipv6 {
+		return true, false
+	}
 
-			return lease.IPaddr, lease.Mac, nil
+	return ipv4, ipv6
+}
 
-		case <-timeout.C:
-			return "", "", fmt.Errorf("timeout reached discovering DHCP lease for %q", hostname)
+// dhcpParentIndex returns the index of the network IP element which contains
+// the DHCP configuration for the address family of the passed address. When
+// the index cannot be determined, the automatic index is returned so libvirt
+// can select the element.
+func (c *Controller) dhcpParentIndex(network shims.ConnectNetwork, addr string) int {
+	ip := stdnet.ParseIP(addr)
+	if ip == nil {
+		return automaticParentIndex
+	}
+
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
+		c.logger.Debug("failed to read network definition", "error", err)
+		return automaticParentIndex
+	}
+
+	family := "ipv4"
+	if ip.To4() == nil {
+		family = "ipv6"
+	}
+
+	for i, netIP := range networkCfg.IPs {
+		ipFamily := netIP.Family
+		if ipFamily == "" {
+			ipFamily = "ipv4"
+		}
+
+		if netIP.DHCP != nil && ipFamily == family {
+			return i
 		}
 	}
+
+	return automaticParentIndex
+}
+
+// networkDefinition returns the parsed XML definition of the network.
+func networkDefinition(network shims.ConnectNetwork) (*libvirtxml.Network, error) {
+	networkDoc, err := network.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+
+	networkCfg := &libvirtxml.Network{}
+	if err := networkCfg.Unmarshal(networkDoc); err != nil {
+		return nil, err
+	}
+
+	return networkCfg, nil
+}
+
+// networkSubnet returns the subnet of an address of a libvirt network, which
+// is defined using either a prefix length or a netmask.
+func networkSubnet(ip libvirtxml.NetworkIP) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(ip.Address)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	bits := int(ip.Prefix)
+	if ip.Netmask != "" {
+		mask, err := netip.ParseAddr(ip.Netmask)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid netmask %q: %w", ip.Netmask, err)
+		}
+		bits, _ = stdnet.IPMask(mask.AsSlice()).Size()
+	}
+
+	if bits == 0 {
+		return netip.Prefix{}, errors.New("address has no prefix length")
+	}
+
+	return addr.Prefix(bits)
+}
+
+// tcAvailable returns if the traffic control utility is installed and can
+// query the queueing disciplines of the host, which libvirt requires to apply
+// interface bandwidth limits.
+func tcAvailable() bool {
+	path, err := exec.LookPath("tc")
+	if err != nil {
+		return false
+	}
+
+	return exec.Command(path, "qdisc", "show").Run() == nil
+}
+
+// ovsBridges returns the names of the Open vSwitch bridges of the host. An
+// error is returned when the Open vSwitch utility is not installed or can not
+// query the bridges.
+func ovsBridges() ([]string, error) {
+	path, err := exec.LookPath("ovs-vsctl")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command(path, "list-br").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(out)), nil
+}
+
+// macvlanDevices returns the names of the macvlan devices of the host whose
+// lower device is the passed device.
+func macvlanDevices(lower string) ([]string, error) {
+	conn, err := rtnetlink.Dial(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	links, err := conn.Link.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, link := range links {
+		if link.Attributes == nil || link.Attributes.Info == nil || link.Attributes.Info.Kind != "macvlan" {
+			continue
+		}
+
+		// The lower device is read from sysfs, as the link attributes which
+		// follow the macvlan driver data may not be decoded.
+		lowerPath := filepath.Join(sysClassNetPath, link.Attributes.Name, "lower_"+lower)
+		if _, err := os.Lstat(lowerPath); err == nil {
+			devices = append(devices, link.Attributes.Name)
+		}
+	}
+
+	return devices, nil
 }
 
 // getIPByInterface is a helper function which returns the IP address
@@ -401,8 +1432,14 @@ func (c *Controller) removeIPReservation(networkName, reservation string) error
 		return nil
 	}
 
+	res := &libvirtxml.NetworkDHCPHost{}
+	if err := res.Unmarshal(reservation); err != nil {
+		return fmt.Errorf("could not parse IP reservation: %w", err)
+	}
+
 	err = network.Update(lv.NETWORK_UPDATE_COMMAND_DELETE, lv.NETWORK_SECTION_IP_DHCP_HOST,
-		automaticParentIndex, reservation, lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG)
+		c.dhcpParentIndex(network, res.IP), reservation,
+		lv.NETWORK_UPDATE_AFFECT_LIVE|lv.NETWORK_UPDATE_AFFECT_CONFIG)
 
 	return err
 }
@@ -414,15 +1451,18 @@ func (c *Controller) ipReservationExists(network shims.ConnectNetwork, reservati
 		return false, fmt.Errorf("could not parse IP reservation: %w", err)
 	}
 
-	networkCfg := &libvirtxml.Network{}
-	networkDoc, err := network.GetXMLDesc(0)
-	if err = networkCfg.Unmarshal(networkDoc); err != nil {
+	networkCfg, err := networkDefinition(network)
+	if err != nil {
 		return false, err
 	}
 
 	for _, ip := range networkCfg.IPs {
+		if ip.DHCP == nil {
+			continue
+		}
+
 		for _, host := range ip.DHCP.Hosts {
-			if host.IP == res.IP && host.MAC == res.MAC && host.Name == res.Name {
+			if host.IP == res.IP && host.MAC == res.MAC && host.ID == res.ID && host.Name == res.Name {
 				return true, nil
 			}
 		}
@@ -6,12 +6,23 @@
 package net
 
 import (
+	"errors"
+	"fmt"
 	stdnet "net"
+	"net/netip"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/net/arp"
+	"github.com/hashicorp/nomad-driver-virt/net/filter"
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
+	"github.com/hashicorp/nomad-driver-virt/providers/libvirt/shims"
+	arp_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/arp"
 	filter_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/filter"
+	netns_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/netns"
+	proxy_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/net/proxy"
 	libvirt_mock "github.com/hashicorp/nomad-driver-virt/testutil/mock/providers/libvirt"
 	"github.com/hashicorp/nomad-driver-virt/virt/net"
 	nomadstructs "github.com/hashicorp/nomad/nomad/structs"
@@ -26,15 +37,27 @@ func TestController_Fingerprint(t *testing.T) {
 	// Use a populated mock shim to test that we query and correctly populate
 	// the passed attributes.
 	controller := NewController(hclog.NewNullLogger(), &libvirt_mock.StaticConnect{})
+	controller.tcAvailable = func() bool { return true }
+	controller.ovsBridges = func() ([]string, error) { return []string{"ovsbr0", "ovsbr1"}, nil }
 
 	controllerAttrs := map[string]*structs.Attribute{}
 	controller.Fingerprint(controllerAttrs)
 
 	expectedOutput := map[string]*structs.Attribute{
-		"driver.virt.network.default.state":       structs.NewStringAttribute("active"),
-		"driver.virt.network.default.bridge_name": structs.NewStringAttribute("virbr0"),
-		"driver.virt.network.routed.state":        structs.NewStringAttribute("inactive"),
-		"driver.virt.network.routed.bridge_name":  structs.NewStringAttribute("br0"),
+		"driver.virt.network.default.state":        structs.NewStringAttribute("active"),
+		"driver.virt.network.default.bridge_name":  structs.NewStringAttribute("virbr0"),
+		"driver.virt.network.default.forward_mode": structs.NewStringAttribute("nat"),
+		"driver.virt.network.default.subnet":       structs.NewStringAttribute("192.168.122.0/24"),
+		"driver.virt.network.default.dhcp_range":   structs.NewStringAttribute("192.168.122.2-192.168.122.254"),
+		"driver.virt.network.routed.state":         structs.NewStringAttribute("inactive"),
+		"driver.virt.network.routed.bridge_name":   structs.NewStringAttribute("br0"),
+		"driver.virt.network.routed.forward_mode":  structs.NewStringAttribute("route"),
+		"driver.virt.network.routed.subnet":        structs.NewStringAttribute("10.10.0.0/24"),
+		"driver.virt.network.routed.subnet6":       structs.NewStringAttribute("fd00:10::/64"),
+		"driver.virt.network.routed.dhcp_range6":   structs.NewStringAttribute("fd00:10::100-fd00:10::1ff"),
+		"driver.virt.network.filter":               structs.NewStringAttribute("iptables"),
+		"driver.virt.network.bandwidth":            structs.NewBoolAttribute(true),
+		"driver.virt.network.openvswitch.bridges":  structs.NewStringAttribute("ovsbr0,ovsbr1"),
 	}
 	must.Eq(t, expectedOutput, controllerAttrs)
 
@@ -42,10 +65,50 @@ func TestController_Fingerprint(t *testing.T) {
 	// other undesired outcome when the process does not find any networks
 	// available on the host.
 	emptyController := NewController(hclog.NewNullLogger(), &libvirt_mock.ConnectEmpty{})
+	emptyController.tcAvailable = func() bool { return false }
+	emptyController.ovsBridges = func() ([]string, error) { return nil, errors.New("ovs-vsctl not found") }
 
 	emptyControllerAttrs := map[string]*structs.Attribute{}
 	emptyController.Fingerprint(emptyControllerAttrs)
-	must.Eq(t, map[string]*structs.Attribute{}, emptyControllerAttrs)
+	must.Eq(t, map[string]*structs.Attribute{
+		"driver.virt.network.filter":    structs.NewStringAttribute("iptables"),
+		"driver.virt.network.bandwidth": structs.NewBoolAttribute(false),
+	}, emptyControllerAttrs)
+
+	// Ensure the selected filter implementation is reflected.
+	nftController := NewController(hclog.NewNullLogger(), &libvirt_mock.ConnectEmpty{})
+	nftController.SetFilterBackend(filter.BackendNFTables)
+	nftController.tcAvailable = func() bool { return true }
+	nftController.ovsBridges = func() ([]string, error) { return nil, nil }
+
+	nftControllerAttrs := map[string]*structs.Attribute{}
+	nftController.Fingerprint(nftControllerAttrs)
+	must.Eq(t, map[string]*structs.Attribute{
+		"driver.virt.network.filter":    structs.NewStringAttribute("nftables"),
+		"driver.virt.network.bandwidth": structs.NewBoolAttribute(true),
+	}, nftControllerAttrs)
+
+	// Ensure no filter, bandwidth limits or Open vSwitch bridges are
+	// reported in session mode.
+	sessionController := NewController(hclog.NewNullLogger(), &libvirt_mock.ConnectEmpty{})
+	sessionController.SetSession(true)
+	sessionController.tcAvailable = func() bool { return true }
+	sessionController.ovsBridges = func() ([]string, error) { return []string{"ovsbr0"}, nil }
+
+	sessionControllerAttrs := map[string]*structs.Attribute{}
+	sessionController.Fingerprint(sessionControllerAttrs)
+	must.Eq(t, map[string]*structs.Attribute{
+		"driver.virt.network.filter":    structs.NewStringAttribute("none"),
+		"driver.virt.network.bandwidth": structs.NewBoolAttribute(false),
+	}, sessionControllerAttrs)
+}
+
+func TestController_Init_session(t *testing.T) {
+	controller := NewController(hclog.NewNullLogger(), &libvirt_mock.ConnectEmpty{})
+	controller.SetSession(true)
+
+	must.NoError(t, controller.Init())
+	must.Nil(t, controller.filter)
 }
 
 func TestController_VMStartedBuild(t *testing.T) {
@@ -87,8 +150,10 @@ func TestController_VMStartedBuild(t *testing.T) {
 				},
 			}},
 			NetworkConfig: &net.NetworkInterfaceBridgeConfig{
-				Name:  "virbr0",
-				Ports: []string{"ssh", "nomad"},
+				Name: "virbr0",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"ssh", "nomad"},
+				},
 			},
 			IP: "192.168.122.58",
 		},
@@ -105,7 +170,7 @@ func TestController_VMStartedBuild(t *testing.T) {
 
 	controller := &Controller{
 		dhcpLeaseDiscoveryInterval: 100 * time.Millisecond,
-		dhcpLeaseDiscoveryTimeout:  500 * time.Millisecond,
+		discoveryTimeout:           500 * time.Millisecond,
 		logger:                     hclog.NewNullLogger(),
 		netConn:                    mockConnect,
 		filter:                     mockFilter,
@@ -123,7 +188,7 @@ func TestController_VMStartedBuild(t *testing.T) {
 	nilRequestResp, err = controller.VMStartedBuild(&net.VMStartedBuildRequest{})
 	must.NoError(t, err)
 	must.NotNil(t, nilRequestResp)
-	must.Nil(t, nilRequestResp.TeardownSpec)
+	must.SliceEmpty(t, nilRequestResp.TeardownSpecs)
 
 	// Pass a request that doesn't contain any configured networks to ensure we
 	// correctly handle that.
@@ -133,7 +198,7 @@ func TestController_VMStartedBuild(t *testing.T) {
 	})
 	must.NoError(t, err)
 	must.NotNil(t, emptyNetworkRequestResp)
-	must.Nil(t, emptyNetworkRequestResp.TeardownSpec)
+	must.SliceEmpty(t, emptyNetworkRequestResp.TeardownSpecs)
 
 	// Test a correct and full request.
 	fullReq := net.VMStartedBuildRequest{
@@ -143,8 +208,10 @@ func TestController_VMStartedBuild(t *testing.T) {
 		NetConfig: net.NetworkInterfacesConfig{
 			{
 				Bridge: &net.NetworkInterfaceBridgeConfig{
-					Name:  "virbr0",
-					Ports: []string{"ssh", "nomad"},
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh", "nomad"},
+					},
 				},
 			},
 		},
@@ -170,201 +237,1758 @@ func TestController_VMStartedBuild(t *testing.T) {
 	must.NoError(t, err)
 	must.NotNil(t, fullReqResp)
 	must.NotNil(t, fullReqResp.DriverNetwork)
-	must.NotNil(t, fullReqResp.TeardownSpec)
+	must.Len(t, 1, fullReqResp.TeardownSpecs)
 
-	must.Eq(t, &drivers.DriverNetwork{IP: "192.168.122.58"}, fullReqResp.DriverNetwork)
+	must.Eq(t, &drivers.DriverNetwork{
+		PortMap: map[string]int{"ssh": 22, "nomad": 4646},
+		IP:      "192.168.122.58",
+	}, fullReqResp.DriverNetwork)
 }
 
-func TestController_VMTerminatedTeardown(t *testing.T) {
-	t.Run("nil", func(t *testing.T) {
-		controller := &Controller{
-			logger:  hclog.NewNullLogger(),
-			netConn: &libvirt_mock.StaticConnect{},
-			filter:  filter_mock.NewStatic(),
-		}
+func TestController_VMStartedBuild_multipleInterfaces(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		Active:     true,
+		BridgeName: "virbr0",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr0",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "192.168.122.58",
+				Hostname:   "nomad-0ea818bc",
+			},
+		},
+	}
+	storageNet := &libvirt_mock.StaticNetwork{
+		Name:       "storage",
+		Active:     true,
+		BridgeName: "virbr1",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:15",
+				IPaddr:     "10.10.0.12",
+				Hostname:   "nomad-0ea818bc",
+			},
+		},
+	}
 
-		resp, err := controller.VMTerminatedTeardown(nil)
-		must.NoError(t, err)
-		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "10.0.1.161",
+			},
+			{
+				Label:  "iscsi",
+				Value:  27512,
+				To:     3260,
+				HostIP: "10.0.1.161",
+			},
+		},
+	}
 
-		resp, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{})
-		must.NoError(t, err)
-		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
-	})
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14", "aa:bb:cc:dd:ee:ff", "52:54:00:1c:7c:15"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+					},
+				},
+			},
+			{
+				Macvtap: &net.NetworkInterfaceMacvtapConfig{
+					Device: "eth0",
+					Mode:   net.MacvtapModeBridge,
+				},
+			},
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr1",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"iscsi"},
+					},
+				},
+				Primary: true,
+			},
+		},
+		Resources: resources,
+	}
 
 	t.Run("ok", func(t *testing.T) {
-		req := &net.VMTerminatedTeardownRequest{
-			TeardownSpec: &net.TeardownSpec{
-				FilterRemoval: &net.FilterRemoval{
-					Name: "testing",
-					Data: "test-data",
-				},
+		mockFilter := filter_mock.NewMock(t).Expect(
+			filter_mock.Configure{
+				Resources:     resources,
+				NetworkConfig: req.NetConfig[0].Bridge,
+				IP:            "192.168.122.58",
+				Result:        &net.FilterRemoval{Name: "testing", Data: "default"},
+			},
+			filter_mock.Configure{
+				Resources:     resources,
+				NetworkConfig: req.NetConfig[2].Bridge,
+				IP:            "10.10.0.12",
+				Result:        &net.FilterRemoval{Name: "testing", Data: "storage"},
+			},
+		)
+		defer mockFilter.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.ListNetworks{Result: []string{"default", "storage"}},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.ListNetworks{Result: []string{"default", "storage"}},
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+			libvirt_mock.LookupNetworkByName{Name: "storage", Result: storageNet},
+			libvirt_mock.LookupNetworkByName{Name: "storage", Result: storageNet},
+			libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Err: errors.New("guest agent unavailable")},
+		)
+		defer mockConnect.AssertExpectations()
+
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{
+				Device: "eth0",
+				Hwaddr: "aa:bb:cc:dd:ee:ff",
+				Result: []stdnet.IP{stdnet.ParseIP("10.0.1.50")},
 			},
+		)
+		defer mockARP.AssertExpectations()
+
+		controller := &Controller{
+			dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+			discoveryTimeout:           500 * time.Millisecond,
+			logger:                     hclog.NewNullLogger(),
+			netConn:                    mockConnect,
+			filter:                     mockFilter,
+			arp:                        mockARP,
 		}
 
+		resp, err := controller.VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, &drivers.DriverNetwork{
+			PortMap: map[string]int{"iscsi": 3260},
+			IP:      "10.10.0.12",
+		}, resp.DriverNetwork)
+		must.Eq(t, &net.InterfaceAddresses{IPv4: "10.0.1.50"}, resp.Addresses[1])
+		must.Len(t, 2, resp.TeardownSpecs)
+		must.Eq(t, "default", resp.TeardownSpecs[0].Network)
+		must.Eq(t, "default", resp.TeardownSpecs[0].FilterRemoval.Data)
+		must.StrContains(t, resp.TeardownSpecs[0].DHCPReservation, "192.168.122.58")
+		must.Eq(t, "storage", resp.TeardownSpecs[1].Network)
+		must.Eq(t, "storage", resp.TeardownSpecs[1].FilterRemoval.Data)
+		must.StrContains(t, resp.TeardownSpecs[1].DHCPReservation, "10.10.0.12")
+	})
+
+	t.Run("failure removes applied configuration", func(t *testing.T) {
 		mockFilter := filter_mock.NewMock(t).Expect(
+			filter_mock.Configure{
+				Resources:     resources,
+				NetworkConfig: req.NetConfig[0].Bridge,
+				IP:            "192.168.122.58",
+				Result:        &net.FilterRemoval{Name: "testing", Data: "default"},
+			},
+			filter_mock.Configure{
+				Resources:     resources,
+				NetworkConfig: req.NetConfig[2].Bridge,
+				IP:            "10.10.0.12",
+				Err:           errors.New("filter failure"),
+			},
 			filter_mock.Teardown{
-				Removal: &net.FilterRemoval{
-					Name: "testing",
-					Data: "test-data",
-				},
+				Removal: &net.FilterRemoval{Name: "testing", Data: "default"},
 			},
 		)
 		defer mockFilter.AssertExpectations()
 
 		controller := &Controller{
-			logger:  hclog.NewNullLogger(),
-			netConn: &libvirt_mock.StaticConnect{},
-			filter:  mockFilter,
+			dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+			discoveryTimeout:           500 * time.Millisecond,
+			logger:                     hclog.NewNullLogger(),
+			netConn:                    &multiNetworkConnect{networks: []*libvirt_mock.StaticNetwork{defaultNet, storageNet}},
+			filter:                     mockFilter,
+			ipByInterfaceGetter:        func(string) (stdnet.IP, error) { return nil, errors.New("no address") },
 		}
 
-		resp, err := controller.VMTerminatedTeardown(req)
-		must.NoError(t, err)
-		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+		resp, err := controller.VMStartedBuild(req)
+		must.ErrorContains(t, err, "network_interface[3]: failed to configure port mapping")
+		must.Nil(t, resp)
 	})
 }
 
-func TestController_networkNameFromBridgeName(t *testing.T) {
-	// Create out controller which has a mocked connection with identified
-	// networks.
-	controller := &Controller{
-		logger:  hclog.NewNullLogger(),
-		netConn: &libvirt_mock.StaticConnect{},
+func TestController_VMStartedBuild_static(t *testing.T) {
+	defaultNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		Active:     true,
+		BridgeName: "virbr0",
 	}
 
-	// Query a non-existent network.
-	nonExistentResp, err := controller.networkNameFromBridgeName("non-existent-bridge")
-	must.ErrorContains(t, err, "failed to find network with bridge")
-	must.Eq(t, nonExistentResp, "")
-
-	// Query a network which does exist.
-	virbr0Resp, err := controller.networkNameFromBridgeName("virbr0")
-	must.NoError(t, err)
-	must.Eq(t, virbr0Resp, "default")
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "10.0.1.161",
+			},
+		},
+	}
 
-	// Create a controller with a connection that does not have any identified
-	// networks. This allows us to ensure the behaviour is the same on hosts
-	// which have no networks, as one that do.
-	mockEmptyController := &Controller{
-		logger:  hclog.NewNullLogger(),
-		netConn: &libvirt_mock.ConnectEmpty{},
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14", "52:54:00:1c:7c:15"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+					},
+				},
+				NetworkConfig: &net.NetworkInterfaceNetworkConfig{
+					Addresses: []string{"192.168.122.10/24", "fd00::10/64"},
+				},
+			},
+			{
+				Macvtap: &net.NetworkInterfaceMacvtapConfig{
+					Device: "eth0",
+					Mode:   net.MacvtapModeBridge,
+				},
+				NetworkConfig: &net.NetworkInterfaceNetworkConfig{
+					Addresses: []string{"10.0.1.50/24"},
+					Gateway:   "10.0.1.1",
+				},
+				Primary: true,
+			},
+		},
+		Resources: resources,
 	}
 
-	mockEmptyResp, err := mockEmptyController.networkNameFromBridgeName("virbr0")
-	must.ErrorContains(t, err, "failed to find network with bridge")
-	must.Eq(t, mockEmptyResp, "")
-}
+	mockFilter := filter_mock.NewMock(t).Expect(
+		filter_mock.Configure{
+			Resources:     resources,
+			NetworkConfig: req.NetConfig[0].Bridge,
+			IP:            "192.168.122.10",
+			Result:        &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+		},
+		filter_mock.Configure{
+			Resources:     resources,
+			NetworkConfig: req.NetConfig[0].Bridge,
+			IP:            "fd00::10",
+			Result:        &net.FilterRemoval{Name: "testing", Data: "ipv6"},
+		},
+	)
+	defer mockFilter.AssertExpectations()
+
+	// No DHCP leases are available, so the build only succeeds when the
+	// static addresses are used before lease discovery.
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.ListNetworks{Result: []string{"default"}},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+		libvirt_mock.LookupNetworkByName{Name: "default", Result: defaultNet},
+	)
+	defer mockConnect.AssertExpectations()
 
-func TestController_discoverDHCPLeaseIP(t *testing.T) {
-	// Create out controller which has a mocked connection with identified
-	// networks and low discovery time durations, so the tests do not take ages
-	// to run.
 	controller := &Controller{
+		dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+		discoveryTimeout:           100 * time.Millisecond,
 		logger:                     hclog.NewNullLogger(),
-		netConn:                    &libvirt_mock.StaticConnect{},
-		dhcpLeaseDiscoveryInterval: 1 * time.Nanosecond,
-		dhcpLeaseDiscoveryTimeout:  100 * time.Microsecond,
+		netConn:                    mockConnect,
+		filter:                     mockFilter,
 	}
 
-	defaultNet, err := controller.netConn.LookupNetworkByName("default")
+	resp, err := controller.VMStartedBuild(req)
 	must.NoError(t, err)
-	must.NotNil(t, defaultNet)
-	defer defaultNet.Free()
-
-	// Query for a domain that does not have a lease entry and ensure the
-	// timeout is triggered.
-	nonExistentResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "non-existent-domain",
-		"default", []string{"00:00:00:00:00:00"})
-	must.ErrorContains(t, err, "timeout reached discovering DHCP lease")
-	must.Eq(t, nonExistentResp, "")
-	must.Eq(t, mac, "")
+	must.Eq(t, &drivers.DriverNetwork{IP: "10.0.1.50"}, resp.DriverNetwork)
+	must.Eq(t, []*net.InterfaceAddresses{
+		{IPv4: "192.168.122.10", IPv6: "fd00::10"},
+		{IPv4: "10.0.1.50"},
+	}, resp.Addresses)
+	must.Eq(t, []*net.TeardownSpec{
+		{
+			Network:           "default",
+			FilterRemoval:     &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+			IPv6FilterRemoval: &net.FilterRemoval{Name: "testing", Data: "ipv6"},
+			DNSHosts: []string{
+				"<host ip=\"192.168.122.10\">\n  <hostname>nomad-0ea818bc</hostname>\n</host>",
+				"<host ip=\"fd00::10\">\n  <hostname>nomad-0ea818bc</hostname>\n</host>",
+			},
+		},
+	}, resp.TeardownSpecs)
+}
 
-	// Query for a domain which does have a lease.
-	existentResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "nomad-0ea818bc",
-		"default", []string{"52:54:00:1c:7c:14"})
-	must.NoError(t, err)
-	must.Eq(t, existentResp, "192.168.122.58")
-	must.Eq(t, mac, "52:54:00:1c:7c:14")
+func TestController_VMStartedBuild_macvtap(t *testing.T) {
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Macvtap: &net.NetworkInterfaceMacvtapConfig{
+					Device: "eth0",
+					Mode:   net.MacvtapModeBridge,
+				},
+			},
+		},
+		Resources: &drivers.Resources{},
+	}
 
-	// Query for a domain which does have a lease using multiple MAC addresses.
-	existentResp, mac, err = controller.discoverDHCPLeaseIP(defaultNet, "nomad-0ea818bc",
-		"default", []string{"11:11:11:11:11:11", "52:54:00:1c:7c:14", "22:22:22:22:22:22"})
-	must.NoError(t, err)
-	must.Eq(t, existentResp, "192.168.122.58")
-	must.Eq(t, mac, "52:54:00:1c:7c:14")
+	newController := func(conn shims.Connect, discoverer arp.ARP) *Controller {
+		return &Controller{
+			dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+			discoveryTimeout:           100 * time.Millisecond,
+			logger:                     hclog.NewNullLogger(),
+			netConn:                    conn,
+			arp:                        discoverer,
+		}
+	}
 
-	// Query for a domain with several matching leases.
-	multiResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "nomad-3edc43aa",
-		"default", []string{"11:22:33:44:55:66"})
-	must.NoError(t, err)
-	must.Eq(t, multiResp, "192.168.122.65")
-	must.Eq(t, mac, "11:22:33:44:55:66")
-
-	// Query for domain with matching expired lease.
-	expiredResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "nomad-eabba892",
-		"default", []string{"66:55:44:33:22:11"})
-	must.ErrorContains(t, err, "timeout reached discovering DHCP lease")
-	must.Eq(t, expiredResp, "")
-	must.Eq(t, mac, "")
-
-	// Query for domain with matching MAC address only.
-	macOnlyResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "different-hostname",
-		"default", []string{"52:54:00:1c:7c:14"})
-	must.ErrorContains(t, err, "timeout reached discovering DHCP lease")
-	must.Eq(t, macOnlyResp, "")
-	must.Eq(t, mac, "")
-
-	// Query for domain with matching MAC address and empty hostname on lease.
-	macOnlyNoHostnameResp, mac, err := controller.discoverDHCPLeaseIP(defaultNet, "custom-hostname",
-		"default", []string{"11:22:11:22:11:22"})
-	must.NoError(t, err)
-	must.Eq(t, macOnlyNoHostnameResp, "192.168.122.99")
-	must.Eq(t, mac, "11:22:11:22:11:22")
-}
+	t.Run("guest agent", func(t *testing.T) {
+		domain := &libvirt_mock.StaticDomain{
+			Interfaces: []libvirt.DomainInterface{
+				{
+					Name:   "lo",
+					Hwaddr: "00:00:00:00:00:00",
+					Addrs:  []libvirt.DomainIPAddress{{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: "127.0.0.1", Prefix: 8}},
+				},
+				{
+					Name:   "eth0",
+					Hwaddr: "52:54:00:1c:7c:14",
+					Addrs: []libvirt.DomainIPAddress{
+						{Type: libvirt.IP_ADDR_TYPE_IPV6, Addr: "fe80::5054:ff:fe1c:7c14", Prefix: 64},
+						{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: "10.0.1.50", Prefix: 24},
+						{Type: libvirt.IP_ADDR_TYPE_IPV6, Addr: "fd00::50", Prefix: 64},
+					},
+				},
+			},
+		}
 
-func TestController_removeIPReservation(t *testing.T) {
-	controller := &Controller{
-		logger:  hclog.NewNullLogger(),
-		netConn: &libvirt_mock.StaticConnect{},
-	}
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Result: domain},
+		)
+		defer mockConnect.AssertExpectations()
 
-	testCases := []struct {
-		desc        string
-		network     string
-		reservation *libvirtxml.NetworkDHCPHost
-		err         string
-	}{
-		{
-			desc:    "reservation does not exist",
-			network: "default",
-			reservation: &libvirtxml.NetworkDHCPHost{
-				IP:   "127.0.0.1",
-				MAC:  "00:00:00:11:11:11",
-				Name: "testing",
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{Device: "eth0", Hwaddr: "52:54:00:1c:7c:14"},
+		)
+		defer mockARP.AssertExpectations()
+
+		resp, err := newController(mockConnect, mockARP).VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, &drivers.DriverNetwork{IP: "10.0.1.50"}, resp.DriverNetwork)
+		must.Eq(t, []*net.InterfaceAddresses{{IPv4: "10.0.1.50", IPv6: "fd00::50"}}, resp.Addresses)
+		must.SliceEmpty(t, resp.TeardownSpecs)
+	})
+
+	t.Run("arp", func(t *testing.T) {
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Result: &libvirt_mock.StaticDomain{Err: errors.New("agent not responding")}},
+		)
+		defer mockConnect.AssertExpectations()
+
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{
+				Device: "eth0",
+				Hwaddr: "52:54:00:1c:7c:14",
+				Result: []stdnet.IP{stdnet.ParseIP("10.0.1.50")},
 			},
-		},
-		{
-			desc:    "reservation exists",
-			network: "default",
-			reservation: &libvirtxml.NetworkDHCPHost{
-				IP:   "192.168.122.45",
-				MAC:  "00:11:22:33:44:55",
-				Name: "test-hostname",
+		)
+		defer mockARP.AssertExpectations()
+
+		resp, err := newController(mockConnect, mockARP).VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, &drivers.DriverNetwork{IP: "10.0.1.50"}, resp.DriverNetwork)
+		must.Eq(t, []*net.InterfaceAddresses{{IPv4: "10.0.1.50"}}, resp.Addresses)
+	})
+
+	t.Run("arp host macvlan", func(t *testing.T) {
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Result: &libvirt_mock.StaticDomain{Err: errors.New("agent not responding")}},
+		)
+		defer mockConnect.AssertExpectations()
+
+		// The neighbor entry of the interface is held by the macvlan device
+		// of the host rather than the lower device.
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{Device: "eth0", Hwaddr: "52:54:00:1c:7c:14"},
+			arp_mock.Discover{
+				Device: "macvlan0",
+				Hwaddr: "52:54:00:1c:7c:14",
+				Result: []stdnet.IP{stdnet.ParseIP("10.0.1.50")},
 			},
-		},
-		{
-			desc:    "network does not exist",
-			network: "does-not-exist",
-			reservation: &libvirtxml.NetworkDHCPHost{
-				IP:   "192.168.122.45",
-				MAC:  "00:11:22:33:44:55",
-				Name: "test-hostname",
+		)
+		defer mockARP.AssertExpectations()
+
+		controller := newController(mockConnect, mockARP)
+		controller.macvlanDevices = func(lower string) ([]string, error) {
+			must.Eq(t, "eth0", lower)
+			return []string{"macvlan0"}, nil
+		}
+
+		resp, err := controller.VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, []*net.InterfaceAddresses{{IPv4: "10.0.1.50"}}, resp.Addresses)
+	})
+
+	t.Run("not discovered", func(t *testing.T) {
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{Device: "eth0", Hwaddr: "52:54:00:1c:7c:14", Err: errors.New("arp failure")},
+		)
+		defer mockARP.AssertExpectations()
+
+		resp, err := newController(&libvirt_mock.ConnectEmpty{}, mockARP).VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Nil(t, resp.DriverNetwork)
+		must.Eq(t, []*net.InterfaceAddresses{nil}, resp.Addresses)
+	})
+
+	t.Run("uncorrelated hardware addresses", func(t *testing.T) {
+		req := *req
+		req.Hwaddrs = nil
+
+		resp, err := newController(libvirt_mock.NewConnect(t), arp_mock.New(t)).VMStartedBuild(&req)
+		must.NoError(t, err)
+		must.Nil(t, resp.DriverNetwork)
+	})
+}
+
+func TestController_VMStartedBuild_openvswitch(t *testing.T) {
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "10.0.1.161",
 			},
-			err: "failed to find network",
 		},
 	}
 
-	for _, tc := range testCases {
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name: "ovsbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+					},
+					OpenVSwitch: &net.NetworkInterfaceOpenVSwitchConfig{VLAN: 10},
+				},
+			},
+		},
+		Resources: resources,
+	}
+
+	mockFilter := filter_mock.NewMock(t).Expect(
+		filter_mock.Configure{
+			Resources:     resources,
+			NetworkConfig: req.NetConfig[0].Bridge,
+			IP:            "10.0.2.50",
+			Result:        &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+		},
+	)
+	defer mockFilter.AssertExpectations()
+
+	// The bridge is not managed by a libvirt network, so no networks are
+	// looked up and the address is discovered without DHCP leases.
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Result: &libvirt_mock.StaticDomain{Err: errors.New("agent not responding")}},
+	)
+	defer mockConnect.AssertExpectations()
+
+	mockARP := arp_mock.New(t).Expect(
+		arp_mock.Discover{
+			Device: "ovsbr0",
+			Hwaddr: "52:54:00:1c:7c:14",
+			Result: []stdnet.IP{stdnet.ParseIP("10.0.2.50")},
+		},
+	)
+	defer mockARP.AssertExpectations()
+
+	controller := &Controller{
+		dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+		discoveryTimeout:           100 * time.Millisecond,
+		logger:                     hclog.NewNullLogger(),
+		netConn:                    mockConnect,
+		filter:                     mockFilter,
+		arp:                        mockARP,
+	}
+
+	resp, err := controller.VMStartedBuild(req)
+	must.NoError(t, err)
+	must.Eq(t, []*net.InterfaceAddresses{{IPv4: "10.0.2.50"}}, resp.Addresses)
+	must.Eq(t, "10.0.2.50", resp.DriverNetwork.IP)
+	must.Eq(t, []*net.TeardownSpec{
+		{FilterRemoval: &net.FilterRemoval{Name: "testing", Data: "ipv4"}},
+	}, resp.TeardownSpecs)
+}
+
+func TestController_VMStartedBuild_network(t *testing.T) {
+	routedNet := &libvirt_mock.StaticNetwork{
+		Name:       "routed",
+		Active:     true,
+		BridgeName: "br0",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "br0",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "10.10.0.58",
+				Hostname:   "nomad-0ea818bc",
+			},
+		},
+	}
+
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "10.0.1.161",
+			},
+		},
+	}
+
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Network: &net.NetworkInterfaceVirtualNetworkConfig{
+					Name: "routed",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"ssh"},
+					},
+				},
+			},
+		},
+		Resources: resources,
+	}
+
+	// The port mappings are configured on the bridge of the network.
+	mockFilter := filter_mock.NewMock(t).Expect(
+		filter_mock.Configure{
+			Resources: resources,
+			NetworkConfig: &net.NetworkInterfaceBridgeConfig{
+				Name: "br0",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"ssh"},
+				},
+			},
+			IP:     "10.10.0.58",
+			Result: &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+		},
+	)
+	defer mockFilter.AssertExpectations()
+
+	// The network is looked up by its name, so the networks are not listed
+	// to find the network of the bridge.
+	mockConnect := libvirt_mock.NewConnect(t).Expect(
+		libvirt_mock.LookupNetworkByName{Name: "routed", Result: routedNet},
+		libvirt_mock.LookupNetworkByName{Name: "routed", Result: routedNet},
+	)
+	defer mockConnect.AssertExpectations()
+
+	controller := &Controller{
+		dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+		discoveryTimeout:           500 * time.Millisecond,
+		logger:                     hclog.NewNullLogger(),
+		netConn:                    mockConnect,
+		filter:                     mockFilter,
+	}
+
+	resp, err := controller.VMStartedBuild(req)
+	must.NoError(t, err)
+	must.Eq(t, []*net.InterfaceAddresses{{IPv4: "10.10.0.58"}}, resp.Addresses)
+	must.Eq(t, &drivers.DriverNetwork{
+		PortMap: map[string]int{"ssh": 22},
+		IP:      "10.10.0.58",
+	}, resp.DriverNetwork)
+	must.Len(t, 1, resp.TeardownSpecs)
+	must.Eq(t, "routed", resp.TeardownSpecs[0].Network)
+	must.StrContains(t, resp.TeardownSpecs[0].DHCPReservation, "10.10.0.58")
+	must.Eq(t, "ipv4", resp.TeardownSpecs[0].FilterRemoval.Data)
+
+	t.Run("unknown network", func(t *testing.T) {
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: &libvirt_mock.ConnectEmpty{},
+		}
+
+		resp, err := controller.VMStartedBuild(req)
+		must.ErrorContains(t, err, `network_interface[1]: failed to lookup network: unknown network: "routed"`)
+		must.Nil(t, resp)
+	})
+}
+
+func TestController_VMStartedBuild_nftablesNAT(t *testing.T) {
+	natNet := &libvirt_mock.StaticNetwork{
+		Name:       "default",
+		Active:     true,
+		BridgeName: "virbr0",
+		XmlDesc:    natNetworkXML,
+	}
+
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "10.0.1.161",
+			},
+		},
+	}
+
+	attachment := net.NetworkInterfaceAttachmentConfig{
+		Ports: []string{"ssh"},
+	}
+
+	testCases := []struct {
+		name      string
+		netConfig *net.NetworkInterfaceConfig
+		expect    []any
+	}{
+		{
+			name: "network",
+			netConfig: &net.NetworkInterfaceConfig{
+				Network: &net.NetworkInterfaceVirtualNetworkConfig{
+					Name:                             "default",
+					NetworkInterfaceAttachmentConfig: attachment,
+				},
+			},
+			expect: []any{
+				libvirt_mock.LookupNetworkByName{Name: "default", Result: natNet},
+				libvirt_mock.LookupNetworkByName{Name: "default", Result: natNet},
+			},
+		},
+		{
+			name: "bridge",
+			netConfig: &net.NetworkInterfaceConfig{
+				Bridge: &net.NetworkInterfaceBridgeConfig{
+					Name:                             "virbr0",
+					NetworkInterfaceAttachmentConfig: attachment,
+				},
+			},
+			expect: []any{
+				libvirt_mock.ListNetworks{Result: []string{"default"}},
+				libvirt_mock.LookupNetworkByName{Name: "default", Result: natNet},
+				libvirt_mock.LookupNetworkByName{Name: "default", Result: natNet},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// No port forwards are configured, as the forwarded connections
+			// would be rejected by the firewall rules of the NAT network.
+			mockFilter := filter_mock.NewMock(t)
+			defer mockFilter.AssertExpectations()
+
+			mockConnect := libvirt_mock.NewConnect(t).Expect(tc.expect...)
+			defer mockConnect.AssertExpectations()
+
+			controller := &Controller{
+				logger:        hclog.NewNullLogger(),
+				netConn:       mockConnect,
+				filter:        mockFilter,
+				filterBackend: filter.BackendNFTables,
+			}
+
+			resp, err := controller.VMStartedBuild(&net.VMStartedBuildRequest{
+				VMName:    "nomad-0ea818bc",
+				Hostname:  "nomad-0ea818bc",
+				Hwaddrs:   []string{"52:54:00:1c:7c:14"},
+				NetConfig: net.NetworkInterfacesConfig{tc.netConfig},
+				Resources: resources,
+			})
+			must.ErrorIs(t, err, errs.ErrNotSupported)
+			must.ErrorContains(t, err, `port forwarding to network "default" using NAT`)
+			must.Nil(t, resp)
+		})
+	}
+}
+
+func TestController_VMStartedBuild_session(t *testing.T) {
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		Hwaddrs:  []string{"52:54:00:1c:7c:14", "52:54:00:1c:7c:15"},
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				User: &net.NetworkInterfaceUserConfig{
+					Backend: net.UserBackendPasst,
+					Ports:   []string{"ssh"},
+				},
+				NetworkConfig: &net.NetworkInterfaceNetworkConfig{
+					Addresses: []string{"10.0.2.15/24"},
+				},
+			},
+			{
+				Bridge: &net.NetworkInterfaceBridgeConfig{Name: "virbr0"},
+			},
+		},
+		Resources: &drivers.Resources{},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		// The libvirt networks are not available in session mode, so no
+		// networks are looked up and the address is discovered without DHCP
+		// leases.
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupDomainByName{Name: "nomad-0ea818bc", Result: &libvirt_mock.StaticDomain{Err: errors.New("agent not responding")}},
+		)
+		defer mockConnect.AssertExpectations()
+
+		mockARP := arp_mock.New(t).Expect(
+			arp_mock.Discover{
+				Device: "virbr0",
+				Hwaddr: "52:54:00:1c:7c:15",
+				Result: []stdnet.IP{stdnet.ParseIP("192.168.122.50")},
+			},
+		)
+		defer mockARP.AssertExpectations()
+
+		controller := &Controller{
+			dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+			discoveryTimeout:           100 * time.Millisecond,
+			logger:                     hclog.NewNullLogger(),
+			netConn:                    mockConnect,
+			arp:                        mockARP,
+			session:                    true,
+		}
+
+		resp, err := controller.VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, []*net.InterfaceAddresses{
+			{IPv4: "10.0.2.15"},
+			{IPv4: "192.168.122.50"},
+		}, resp.Addresses)
+		must.Nil(t, resp.DriverNetwork)
+		must.Eq(t, []*net.TeardownSpec{{}}, resp.TeardownSpecs)
+	})
+
+	t.Run("bridge ports", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger(), session: true}
+
+		_, err := controller.configureFilter(&drivers.Resources{},
+			&net.NetworkInterfaceBridgeConfig{
+				Name: "virbr0",
+				NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+					Ports: []string{"ssh"},
+				},
+			},
+			&net.InterfaceAddresses{IPv4: "192.168.122.50"}, &net.TeardownSpec{})
+		must.ErrorIs(t, err, errs.ErrNotSupported)
+	})
+}
+
+func TestController_VMStartedBuild_proxy(t *testing.T) {
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "ssh",
+				Value:  27494,
+				To:     22,
+				HostIP: "127.0.0.1",
+			},
+			{
+				Label:  "http",
+				Value:  27512,
+				To:     80,
+				HostIP: "10.0.1.161",
+			},
+		},
+	}
+
+	bridge := &net.NetworkInterfaceBridgeConfig{
+		Name: "virbr0",
+		NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+			Ports: []string{"ssh", "http"},
+		},
+	}
+
+	req := &net.VMStartedBuildRequest{
+		VMName:   "nomad-0ea818bc",
+		Hostname: "nomad-0ea818bc",
+		NetConfig: net.NetworkInterfacesConfig{
+			{
+				Bridge:            bridge,
+				AssignedAddresses: &net.InterfaceAddresses{IPv4: "192.168.122.58"},
+			},
+		},
+		Resources: resources,
+	}
+
+	sshForward := &net.ProxyForward{
+		Protocol: net.PortProtocolTCP,
+		Listen:   "127.0.0.1:27494",
+		Target:   "192.168.122.58:22",
+	}
+
+	// The loopback port is removed from the ports configured using the
+	// packet filter once loopback port forwarding is found to be disabled.
+	newFilter := func(t *testing.T) *filter_mock.MockFilter {
+		return filter_mock.NewMock(t).Expect(
+			filter_mock.Configure{
+				Resources:     resources,
+				NetworkConfig: bridge,
+				IP:            "192.168.122.58",
+				Err:           fmt.Errorf("%w for device - virbr0", filter.ErrLoopbackNotEnabled),
+			},
+			filter_mock.Configure{
+				Resources: resources,
+				NetworkConfig: &net.NetworkInterfaceBridgeConfig{
+					Name: "virbr0",
+					NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+						Ports: []string{"http"},
+					},
+				},
+				IP:     "192.168.122.58",
+				Result: &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+			},
+		)
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		mockFilter := newFilter(t)
+		defer mockFilter.AssertExpectations()
+
+		mockProxy := proxy_mock.New(t).Expect(
+			proxy_mock.Start{Forward: sshForward},
+		)
+		defer mockProxy.AssertExpectations()
+
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: libvirt_mock.NewConnect(t),
+			filter:  mockFilter,
+			proxy:   mockProxy,
+		}
+
+		resp, err := controller.VMStartedBuild(req)
+		must.NoError(t, err)
+		must.Eq(t, []*net.TeardownSpec{
+			{
+				FilterRemoval: &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+				Proxies:       []*net.ProxyForward{sshForward},
+			},
+		}, resp.TeardownSpecs)
+	})
+
+	t.Run("proxy failure", func(t *testing.T) {
+		mockFilter := newFilter(t).Expect(
+			filter_mock.Teardown{Removal: &net.FilterRemoval{Name: "testing", Data: "ipv4"}},
+		)
+		defer mockFilter.AssertExpectations()
+
+		mockProxy := proxy_mock.New(t).Expect(
+			proxy_mock.Start{Forward: sshForward, Err: errors.New("address already in use")},
+		)
+		defer mockProxy.AssertExpectations()
+
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: libvirt_mock.NewConnect(t),
+			filter:  mockFilter,
+			proxy:   mockProxy,
+		}
+
+		_, err := controller.VMStartedBuild(req)
+		must.ErrorContains(t, err, "address already in use")
+	})
+}
+
+func Test_loopbackProxyForwards(t *testing.T) {
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "ssh", Value: 27494, To: 22, HostIP: "127.0.0.1"},
+			{Label: "dns", Value: 27500, To: 53, HostIP: "127.0.0.1"},
+			{Label: "http", Value: 27512, To: 80, HostIP: "10.0.1.161"},
+			{Label: "admin", Value: 27520, To: 8080, HostIP: "::1"},
+			{Label: "rtp0", Value: 27530, HostIP: "127.0.0.1"},
+			{Label: "rtp1", Value: 27531, HostIP: "127.0.0.1"},
+		},
+	}
+
+	bridge := &net.NetworkInterfaceBridgeConfig{
+		Name: "virbr0",
+		NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+			Ports: []string{"ssh", "dns/udp", "http", "admin", "missing", "rtp0..rtp1/udp", "ssh..dns"},
+		},
+	}
+
+	filtered, forwards := loopbackProxyForwards(resources, bridge, "192.168.122.58")
+	must.Eq(t, []string{"http", "admin", "missing", "ssh..dns"}, filtered.Ports)
+	must.Eq(t, []*net.ProxyForward{
+		{Protocol: net.PortProtocolTCP, Listen: "127.0.0.1:27494", Target: "192.168.122.58:22"},
+		{Protocol: net.PortProtocolUDP, Listen: "127.0.0.1:27500", Target: "192.168.122.58:53"},
+		{Protocol: net.PortProtocolUDP, Listen: "127.0.0.1:27530", Target: "192.168.122.58:27530"},
+		{Protocol: net.PortProtocolUDP, Listen: "127.0.0.1:27531", Target: "192.168.122.58:27531"},
+	}, forwards)
+
+	// The passed bridge config is not modified.
+	must.Eq(t, []string{"ssh", "dns/udp", "http", "admin", "missing", "rtp0..rtp1/udp", "ssh..dns"}, bridge.Ports)
+}
+
+func TestController_VMRecoveredBuild(t *testing.T) {
+	forward := &net.ProxyForward{
+		Protocol: net.PortProtocolTCP,
+		Listen:   "127.0.0.1:27494",
+		Target:   "192.168.122.58:22",
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger(), proxy: proxy_mock.New(t)}
+
+		resp, err := controller.VMRecoveredBuild(nil)
+		must.NoError(t, err)
+		must.Eq(t, &net.VMRecoveredBuildResponse{}, resp)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		mockProxy := proxy_mock.New(t).Expect(
+			proxy_mock.Start{Forward: forward},
+		)
+		defer mockProxy.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), proxy: mockProxy}
+
+		resp, err := controller.VMRecoveredBuild(&net.VMRecoveredBuildRequest{
+			VMName:        "nomad-0ea818bc",
+			TeardownSpecs: []*net.TeardownSpec{nil, {Network: "default"}, {Proxies: []*net.ProxyForward{forward}}},
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.VMRecoveredBuildResponse{}, resp)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockProxy := proxy_mock.New(t).Expect(
+			proxy_mock.Start{Forward: forward, Err: errors.New("address already in use")},
+		)
+		defer mockProxy.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), proxy: mockProxy}
+
+		_, err := controller.VMRecoveredBuild(&net.VMRecoveredBuildRequest{
+			VMName:        "nomad-0ea818bc",
+			TeardownSpecs: []*net.TeardownSpec{{Proxies: []*net.ProxyForward{forward}}},
+		})
+		must.ErrorContains(t, err, "failed to recover port forward proxies for nomad-0ea818bc")
+	})
+}
+
+func TestController_VMStartedBuild_dualStack(t *testing.T) {
+	dualStackNet := &libvirt_mock.StaticNetwork{
+		Name:       "dual",
+		Active:     true,
+		BridgeName: "virbr1",
+		DhcpLeases: []libvirt.NetworkDHCPLease{
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV4,
+				Mac:        "52:54:00:1c:7c:14",
+				IPaddr:     "192.168.100.58",
+				Hostname:   "nomad-0ea818bc",
+			},
+			{
+				Iface:      "virbr1",
+				ExpiryTime: time.Now().Add(1 * time.Hour),
+				Type:       libvirt.IP_ADDR_TYPE_IPV6,
+				IPaddr:     "fd00:100::58",
+				Hostname:   "nomad-0ea818bc",
+				Clientid:   "00:04:c4:bb:51:75:73:7e:4e:0a:a1:1c:04:2f:57:f3:1c:1b",
+			},
+		},
+		XmlDesc: dualStackNetworkXML,
+	}
+
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{
+				Label:  "http",
+				Value:  27494,
+				To:     80,
+				HostIP: "fd00:1::161",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		advertiseIPv6 bool
+		expectedIP    string
+	}{
+		{
+			name:       "advertise ipv4",
+			expectedIP: "192.168.100.58",
+		},
+		{
+			name:          "advertise ipv6",
+			advertiseIPv6: true,
+			expectedIP:    "fd00:100::58",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &net.VMStartedBuildRequest{
+				VMName:         "nomad-0ea818bc",
+				Hostname:       "nomad-0ea818bc",
+				UniqueHostname: true,
+				Hwaddrs:        []string{"52:54:00:1c:7c:14"},
+				NetConfig: net.NetworkInterfacesConfig{
+					{
+						Bridge: &net.NetworkInterfaceBridgeConfig{
+							Name: "virbr1",
+							NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+								Ports:         []string{"http"},
+								AdvertiseIPv6: tc.advertiseIPv6,
+							},
+						},
+					},
+				},
+				Resources: resources,
+			}
+
+			mockFilter := filter_mock.NewMock(t).Expect(
+				filter_mock.Configure{
+					Resources:     resources,
+					NetworkConfig: req.NetConfig[0].Bridge,
+					IP:            "192.168.100.58",
+					Result:        &net.FilterRemoval{Name: "testing", Data: "ipv4"},
+				},
+				filter_mock.Configure{
+					Resources:     resources,
+					NetworkConfig: req.NetConfig[0].Bridge,
+					IP:            "fd00:100::58",
+					Result:        &net.FilterRemoval{Name: "testing", Data: "ipv6"},
+				},
+			)
+			defer mockFilter.AssertExpectations()
+
+			controller := &Controller{
+				dhcpLeaseDiscoveryInterval: 10 * time.Millisecond,
+				discoveryTimeout:           500 * time.Millisecond,
+				logger:                     hclog.NewNullLogger(),
+				netConn:                    &multiNetworkConnect{networks: []*libvirt_mock.StaticNetwork{dualStackNet}},
+				filter:                     mockFilter,
+			}
+
+			resp, err := controller.VMStartedBuild(req)
+			must.NoError(t, err)
+			must.Eq(t, &drivers.DriverNetwork{
+				PortMap: map[string]int{"http": 80},
+				IP:      tc.expectedIP,
+			}, resp.DriverNetwork)
+			must.Eq(t, []*net.InterfaceAddresses{{IPv4: "192.168.100.58", IPv6: "fd00:100::58"}}, resp.Addresses)
+			must.Len(t, 1, resp.TeardownSpecs)
+
+			spec := resp.TeardownSpecs[0]
+			must.Eq(t, "ipv4", spec.FilterRemoval.Data)
+			must.Eq(t, "ipv6", spec.IPv6FilterRemoval.Data)
+			must.StrContains(t, spec.DHCPReservation, "192.168.100.58")
+			must.StrContains(t, spec.IPv6DHCPReservation, "fd00:100::58")
+			must.StrNotContains(t, spec.IPv6DHCPReservation, "mac=")
+		})
+	}
+}
+
+// multiNetworkConnect implements the shims.Connect interface using a list of
+// static networks.
+type multiNetworkConnect struct {
+	networks []*libvirt_mock.StaticNetwork
+}
+
+func (m *multiNetworkConnect) ListNetworks() ([]string, error) {
+	names := make([]string, len(m.networks))
+	for i, n := range m.networks {
+		names[i] = n.Name
+	}
+	return names, nil
+}
+
+func (m *multiNetworkConnect) LookupNetworkByName(name string) (shims.ConnectNetwork, error) {
+	for _, n := range m.networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network: %q", name)
+}
+
+func (m *multiNetworkConnect) NetworkCreateXML(xmlConfig string) (shims.ConnectNetwork, error) {
+	return nil, errors.New("network creation not supported")
+}
+
+func (m *multiNetworkConnect) LookupDomainByName(name string) (shims.ConnectDomain, error) {
+	return nil, fmt.Errorf("unknown domain: %q", name)
+}
+
+func TestController_VMIsolationBuild(t *testing.T) {
+	isolation := &drivers.NetworkIsolationSpec{
+		Mode: drivers.NetIsolationModeGroup,
+		Path: "/var/run/netns/test-alloc",
+	}
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "http", Value: 25000, To: 8080},
+			{Label: "metrics", Value: 25001},
+			{Label: nomadstructs.ConnectProxyPrefix + "-api", Value: 25002, To: -1},
+		},
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger()}
+
+		_, err := controller.VMIsolationBuild(nil)
+		must.ErrorContains(t, err, "no isolation request provided")
+
+		_, err = controller.VMIsolationBuild(&net.VMIsolationBuildRequest{VMName: "test-vm"})
+		must.ErrorContains(t, err, "no isolation request provided")
+	})
+
+	t.Run("session", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger(), session: true}
+
+		_, err := controller.VMIsolationBuild(&net.VMIsolationBuildRequest{
+			VMName:    "test-vm",
+			Isolation: isolation,
+		})
+		must.ErrorIs(t, err, errs.ErrNotSupported)
+	})
+
+	t.Run("unsupported mode", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger()}
+
+		_, err := controller.VMIsolationBuild(&net.VMIsolationBuildRequest{
+			VMName:    "test-vm",
+			Isolation: &drivers.NetworkIsolationSpec{Mode: drivers.NetIsolationModeTask},
+		})
+		must.ErrorIs(t, err, errs.ErrNotSupported)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		mockNetNS := netns_mock.New(t).Expect(
+			netns_mock.Attach{
+				Request: &netns.AttachRequest{
+					Name: "test-vm",
+					Path: "/var/run/netns/test-alloc",
+					Ports: []netns.Port{
+						{Port: 8080, Protocol: "tcp"},
+						{Port: 8080, Protocol: "udp"},
+						{Port: 25001, Protocol: "tcp"},
+						{Port: 25001, Protocol: "udp"},
+					},
+				},
+				Result: &netns.Attachment{
+					HostDevice: "nvt12345678",
+					Address:    netip.MustParsePrefix("169.254.1.2/30"),
+					Gateway:    netip.MustParseAddr("169.254.1.1"),
+				},
+			},
+		)
+		defer mockNetNS.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netns: mockNetNS}
+
+		resp, err := controller.VMIsolationBuild(&net.VMIsolationBuildRequest{
+			VMName:    "test-vm",
+			Isolation: isolation,
+			Resources: resources,
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.NetworkInterfaceIsolationConfig{
+			Device:  "nvt12345678",
+			Address: "169.254.1.2/30",
+			Gateway: "169.254.1.1",
+		}, resp.Interface.Isolation)
+		must.StrHasPrefix(t, "52:54:00:", resp.Interface.MAC)
+		must.Eq(t, &net.TeardownSpec{IsolationDevice: "nvt12345678"}, resp.TeardownSpec)
+	})
+
+	t.Run("attach error", func(t *testing.T) {
+		mockNetNS := netns_mock.New(t).Expect(
+			netns_mock.Attach{Err: errors.New("attach failed")},
+		)
+		defer mockNetNS.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netns: mockNetNS}
+
+		_, err := controller.VMIsolationBuild(&net.VMIsolationBuildRequest{
+			VMName:    "test-vm",
+			Isolation: isolation,
+			Resources: resources,
+		})
+		must.ErrorContains(t, err, "attach failed")
+	})
+}
+
+func Test_isolationPorts(t *testing.T) {
+	must.Nil(t, isolationPorts(nil))
+	must.Nil(t, isolationPorts(&drivers.Resources{}))
+
+	must.Eq(t, []netns.Port{
+		{Port: 8080, Protocol: "tcp"},
+		{Port: 8080, Protocol: "udp"},
+		{Port: 25001, Protocol: "tcp"},
+		{Port: 25001, Protocol: "udp"},
+	}, isolationPorts(&drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "http", Value: 25000, To: 8080},
+			{Label: "http-alt", Value: 25003, To: 8080},
+			{Label: "metrics", Value: 25001},
+			{Label: nomadstructs.ConnectProxyPrefix + "-api", Value: 25002, To: -1},
+		},
+	}))
+
+	must.Eq(t, []netns.Port{
+		{Port: 53, Protocol: "udp"},
+		{Port: 8080, Protocol: "tcp"},
+	}, isolationPorts(&drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "dns/udp", Value: 25000, To: 53},
+			{Label: "http/tcp", Value: 25001, To: 8080},
+		},
+	}))
+}
+
+func Test_bridgePortMap(t *testing.T) {
+	resources := &drivers.Resources{
+		Ports: &nomadstructs.AllocatedPorts{
+			{Label: "ssh", Value: 25000, To: 22},
+			{Label: "dns", Value: 25001, To: 53},
+			{Label: "metrics", Value: 25002},
+			{Label: "rtp0", Value: 25010},
+			{Label: "rtp1", Value: 25011},
+		},
+	}
+
+	must.Nil(t, bridgePortMap(nil, &net.NetworkInterfaceBridgeConfig{
+		NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+			Ports: []string{"ssh"},
+		},
+	}))
+	must.Nil(t, bridgePortMap(resources, &net.NetworkInterfaceBridgeConfig{}))
+	must.Nil(t, bridgePortMap(resources, &net.NetworkInterfaceBridgeConfig{
+		NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+			Ports: []string{"unknown"},
+		},
+	}))
+
+	must.Eq(t, map[string]int{"ssh": 22, "dns": 53, "metrics": 25002}, bridgePortMap(resources,
+		&net.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"ssh", "dns/udp", "metrics", "unknown"},
+			},
+		}))
+
+	must.Eq(t, map[string]int{"rtp0": 25010, "rtp1": 25011}, bridgePortMap(resources,
+		&net.NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+				Ports: []string{"rtp0..rtp1/udp"},
+			},
+		}))
+}
+
+func TestController_VMTerminatedTeardown(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: &libvirt_mock.StaticConnect{},
+			filter:  filter_mock.NewStatic(),
+		}
+
+		resp, err := controller.VMTerminatedTeardown(nil)
+		must.NoError(t, err)
+		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+
+		resp, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{})
+		must.NoError(t, err)
+		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		req := &net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{
+				{
+					FilterRemoval: &net.FilterRemoval{
+						Name: "testing",
+						Data: "test-data",
+					},
+				},
+				nil,
+				{
+					FilterRemoval: &net.FilterRemoval{
+						Name: "testing",
+						Data: "test-data-2",
+					},
+				},
+			},
+		}
+
+		mockFilter := filter_mock.NewMock(t).Expect(
+			filter_mock.Teardown{
+				Removal: &net.FilterRemoval{
+					Name: "testing",
+					Data: "test-data",
+				},
+			},
+			filter_mock.Teardown{
+				Removal: &net.FilterRemoval{
+					Name: "testing",
+					Data: "test-data-2",
+				},
+			},
+		)
+		defer mockFilter.AssertExpectations()
+
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: &libvirt_mock.StaticConnect{},
+			filter:  mockFilter,
+		}
+
+		resp, err := controller.VMTerminatedTeardown(req)
+		must.NoError(t, err)
+		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+	})
+
+	t.Run("proxies", func(t *testing.T) {
+		forward := &net.ProxyForward{
+			Protocol: net.PortProtocolTCP,
+			Listen:   "127.0.0.1:27494",
+			Target:   "192.168.122.58:22",
+		}
+
+		mockProxy := proxy_mock.New(t).Expect(
+			proxy_mock.Stop{Forward: forward},
+		)
+		defer mockProxy.AssertExpectations()
+
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: &libvirt_mock.StaticConnect{},
+			filter:  filter_mock.NewStatic(),
+			proxy:   mockProxy,
+		}
+
+		resp, err := controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{{Proxies: []*net.ProxyForward{forward}}},
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		mockNetNS := netns_mock.New(t).Expect(
+			netns_mock.Detach{HostDevice: "nvt12345678"},
+		)
+		defer mockNetNS.AssertExpectations()
+
+		controller := &Controller{
+			logger:  hclog.NewNullLogger(),
+			netConn: &libvirt_mock.StaticConnect{},
+			filter:  filter_mock.NewStatic(),
+			netns:   mockNetNS,
+		}
+
+		resp, err := controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+			TeardownSpecs: []*net.TeardownSpec{{IsolationDevice: "nvt12345678"}},
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.VMTerminatedTeardownResponse{}, resp)
+	})
+}
+
+func TestController_FilterReconcile(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		controller := &Controller{
+			logger: hclog.NewNullLogger(),
+			filter: filter_mock.NewMock(t),
+		}
+
+		resp, err := controller.FilterReconcile(nil)
+		must.NoError(t, err)
+		must.Eq(t, &net.FilterReconcileResponse{Restored: map[string]int{}}, resp)
+	})
+
+	t.Run("unsupported filter", func(t *testing.T) {
+		for _, f := range []filter.Filter{nil, filter_mock.NewStatic()} {
+			controller := &Controller{
+				logger: hclog.NewNullLogger(),
+				filter: f,
+			}
+
+			resp, err := controller.FilterReconcile(&net.FilterReconcileRequest{
+				TeardownSpecs: map[string][]*net.TeardownSpec{
+					"vm1": {{FilterRemoval: &net.FilterRemoval{Name: "testing"}}},
+				},
+			})
+			must.NoError(t, err)
+			must.Eq(t, &net.FilterReconcileResponse{Restored: map[string]int{}}, resp)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		vm1 := &net.FilterRemoval{Name: "testing", Data: "vm1"}
+		vm1IPv6 := &net.FilterRemoval{Name: "testing-ipv6", Data: "vm1"}
+		vm2 := &net.FilterRemoval{Name: "testing", Data: "vm2"}
+		vm3 := &net.FilterRemoval{Name: "testing", Data: "vm3"}
+
+		mockFilter := filter_mock.NewMock(t).Expect(
+			filter_mock.Reconcile{
+				Removals: []*net.FilterRemoval{vm1, vm1IPv6, vm2, vm3},
+				Prune:    true,
+				Result: &filter.Drift{
+					Restored:     []int{1, 2, 0, 3},
+					BaseRestored: 1,
+					Removed:      4,
+				},
+			},
+		)
+		defer mockFilter.AssertExpectations()
+
+		controller := &Controller{
+			logger: hclog.NewNullLogger(),
+			filter: mockFilter,
+		}
+
+		resp, err := controller.FilterReconcile(&net.FilterReconcileRequest{
+			TeardownSpecs: map[string][]*net.TeardownSpec{
+				"vm3": {{FilterRemoval: vm3}},
+				"vm1": {{FilterRemoval: vm1, IPv6FilterRemoval: vm1IPv6}, nil},
+				"vm2": {{FilterRemoval: vm2}, {DHCPReservation: "reservation"}},
+			},
+			Prune: true,
+		})
+		must.NoError(t, err)
+		must.Eq(t, &net.FilterReconcileResponse{
+			Restored:     map[string]int{"vm1": 3, "vm3": 3},
+			BaseRestored: 1,
+			Removed:      4,
+		}, resp)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		vm1 := &net.FilterRemoval{Name: "testing", Data: "vm1"}
+		mockFilter := filter_mock.NewMock(t).Expect(
+			filter_mock.Reconcile{
+				Removals: []*net.FilterRemoval{vm1},
+				Result:   &filter.Drift{Restored: []int{1}},
+				Err:      errors.New("test error"),
+			},
+		)
+		defer mockFilter.AssertExpectations()
+
+		controller := &Controller{
+			logger: hclog.NewNullLogger(),
+			filter: mockFilter,
+		}
+
+		// The drift which was repaired is still reported.
+		resp, err := controller.FilterReconcile(&net.FilterReconcileRequest{
+			TeardownSpecs: map[string][]*net.TeardownSpec{"vm1": {{FilterRemoval: vm1}}},
+		})
+		must.ErrorContains(t, err, "test error")
+		must.Eq(t, &net.FilterReconcileResponse{Restored: map[string]int{"vm1": 1}}, resp)
+	})
+}
+
+func TestController_filterMigration(t *testing.T) {
+	previousRemoval := &net.FilterRemoval{Name: "previous", Data: "vm1"}
+	currentRemoval := &net.FilterRemoval{Name: "testing", Data: "vm2"}
+
+	previousFilter := filter_mock.NewMock(t).Expect(filter_mock.Teardown{Removal: previousRemoval})
+	defer previousFilter.AssertExpectations()
+	currentFilter := filter_mock.NewMock(t).Expect(
+		filter_mock.Reconcile{Removals: []*net.FilterRemoval{currentRemoval}, Prune: true},
+		filter_mock.Teardown{Removal: currentRemoval},
+		filter_mock.Reconcile{Prune: true},
+		filter_mock.Reconcile{Prune: true},
+	)
+	defer currentFilter.AssertExpectations()
+
+	var cleanups int
+	controller := &Controller{
+		logger: hclog.NewNullLogger(),
+		filter: currentFilter,
+		migration: &filterMigration{
+			previous: func() (filter.Filter, error) { return previousFilter, nil },
+			owns:     func(r *net.FilterRemoval) bool { return r.Name == "previous" },
+			cleanup: func(hclog.Logger) error {
+				cleanups++
+				return nil
+			},
+		},
+	}
+
+	// The configuration of the previous filter is kept while a task uses it,
+	// and its removals are not reconciled by the current filter.
+	_, err := controller.FilterReconcile(&net.FilterReconcileRequest{
+		TeardownSpecs: map[string][]*net.TeardownSpec{
+			"vm1": {{FilterRemoval: previousRemoval}},
+			"vm2": {{FilterRemoval: currentRemoval}},
+		},
+		Prune: true,
+	})
+	must.NoError(t, err)
+	must.Zero(t, cleanups)
+
+	// Each removal is torn down by the filter which created it.
+	_, err = controller.VMTerminatedTeardown(&net.VMTerminatedTeardownRequest{
+		TeardownSpecs: []*net.TeardownSpec{{FilterRemoval: previousRemoval}, {FilterRemoval: currentRemoval}},
+	})
+	must.NoError(t, err)
+
+	// Once no task uses the previous filter, its configuration is removed.
+	_, err = controller.FilterReconcile(&net.FilterReconcileRequest{Prune: true})
+	must.NoError(t, err)
+	must.Eq(t, 1, cleanups)
+
+	_, err = controller.FilterReconcile(&net.FilterReconcileRequest{Prune: true})
+	must.NoError(t, err)
+	must.Eq(t, 1, cleanups)
+}
+
+func TestController_networkNameFromBridgeName(t *testing.T) {
+	// Create out controller which has a mocked connection with identified
+	// networks.
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: &libvirt_mock.StaticConnect{},
+	}
+
+	// Query a non-existent network.
+	nonExistentResp, err := controller.networkNameFromBridgeName("non-existent-bridge")
+	must.ErrorContains(t, err, "failed to find network with bridge")
+	must.Eq(t, nonExistentResp, "")
+
+	// Query a network which does exist.
+	virbr0Resp, err := controller.networkNameFromBridgeName("virbr0")
+	must.NoError(t, err)
+	must.Eq(t, virbr0Resp, "default")
+
+	// Create a controller with a connection that does not have any identified
+	// networks. This allows us to ensure the behaviour is the same on hosts
+	// which have no networks, as one that do.
+	mockEmptyController := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: &libvirt_mock.ConnectEmpty{},
+	}
+
+	mockEmptyResp, err := mockEmptyController.networkNameFromBridgeName("virbr0")
+	must.ErrorContains(t, err, "failed to find network with bridge")
+	must.Eq(t, mockEmptyResp, "")
+}
+
+func TestController_bridgeNameFromNetworkName(t *testing.T) {
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: &libvirt_mock.StaticConnect{},
+	}
+
+	bridgeName, err := controller.bridgeNameFromNetworkName("routed")
+	must.NoError(t, err)
+	must.Eq(t, "br0", bridgeName)
+
+	bridgeName, err = controller.bridgeNameFromNetworkName("non-existent-network")
+	must.ErrorContains(t, err, "unknown network")
+	must.Eq(t, "", bridgeName)
+}
+
+func Test_networkSubnet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ip       libvirtxml.NetworkIP
+		expected string
+		errMsg   string
+	}{
+		{
+			name:     "netmask",
+			ip:       libvirtxml.NetworkIP{Address: "192.168.122.1", Netmask: "255.255.255.0"},
+			expected: "192.168.122.0/24",
+		},
+		{
+			name:     "prefix",
+			ip:       libvirtxml.NetworkIP{Family: "ipv6", Address: "fd00:10::1", Prefix: 64},
+			expected: "fd00:10::/64",
+		},
+		{
+			name:   "no prefix",
+			ip:     libvirtxml.NetworkIP{Address: "192.168.122.1"},
+			errMsg: "address has no prefix length",
+		},
+		{
+			name:   "invalid netmask",
+			ip:     libvirtxml.NetworkIP{Address: "192.168.122.1", Netmask: "255.255.0"},
+			errMsg: "invalid netmask",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subnet, err := networkSubnet(tc.ip)
+			if tc.errMsg != "" {
+				must.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			must.NoError(t, err)
+			must.Eq(t, tc.expected, subnet.String())
+		})
+	}
+}
+
+func Test_latestLease(t *testing.T) {
+	must.Nil(t, latestLease(nil))
+
+	// An instance destroyed and created again has a lease for the same MAC
+	// address, and the lease expiring furthest in the future is used.
+	lease := latestLease([]libvirt.NetworkDHCPLease{
+		{Mac: "52:54:00:1c:7c:14", IPaddr: "192.168.122.58", ExpiryTime: time.Now().Add(10 * time.Minute)},
+		{Mac: "52:54:00:1c:7c:14", IPaddr: "192.168.122.65", ExpiryTime: time.Now().Add(time.Hour)},
+		{Mac: "52:54:00:1c:7c:14", IPaddr: "192.168.122.70", ExpiryTime: time.Now().Add(30 * time.Minute)},
+	})
+	must.NotNil(t, lease)
+	must.Eq(t, "192.168.122.65", lease.IPaddr)
+}
+
+func TestController_dhcpParentIndex(t *testing.T) {
+	controller := &Controller{
+		logger: hclog.NewNullLogger(),
+	}
+
+	testCases := []struct {
+		name     string
+		xml      string
+		addr     string
+		expected int
+	}{
+		{
+			name:     "ipv4",
+			xml:      dualStackNetworkXML,
+			addr:     "192.168.100.58",
+			expected: 0,
+		},
+		{
+			name:     "ipv6",
+			xml:      dualStackNetworkXML,
+			addr:     "fd00:100::58",
+			expected: 1,
+		},
+		{
+			name:     "no matching family",
+			xml:      dualStackNetworkXML,
+			addr:     "not-an-address",
+			expected: automaticParentIndex,
+		},
+		{
+			name:     "invalid network definition",
+			xml:      "",
+			addr:     "192.168.100.58",
+			expected: automaticParentIndex,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			network := &libvirt_mock.StaticNetwork{XmlDesc: tc.xml}
+			must.Eq(t, tc.expected, controller.dhcpParentIndex(network, tc.addr))
+		})
+	}
+}
+
+// natNetworkXML is a network definition using NAT, which is the forward mode
+// of a network whose forward element does not set a mode.
+const natNetworkXML = `<network>
+  <name>default</name>
+  <forward/>
+  <bridge name='virbr0' stp='on' delay='0'/>
+  <ip address='192.168.122.1' netmask='255.255.255.0'>
+    <dhcp>
+      <range start='192.168.122.2' end='192.168.122.254'/>
+    </dhcp>
+  </ip>
+</network>`
+
+// dualStackNetworkXML is a network definition providing DHCP for both IPv4
+// and IPv6.
+const dualStackNetworkXML = `<network>
+  <name>dual</name>
+  <forward mode='nat'/>
+  <bridge name='virbr1' stp='on' delay='0'/>
+  <ip address='192.168.100.1' netmask='255.255.255.0'>
+    <dhcp>
+      <range start='192.168.100.2' end='192.168.100.254'/>
+    </dhcp>
+  </ip>
+  <ip family='ipv6' address='fd00:100::1' prefix='64'>
+    <dhcp>
+      <range start='fd00:100::2' end='fd00:100::ff'/>
+    </dhcp>
+  </ip>
+</network>`
+
+func TestController_removeIPReservation(t *testing.T) {
+	controller := &Controller{
+		logger:  hclog.NewNullLogger(),
+		netConn: &libvirt_mock.StaticConnect{},
+	}
+
+	testCases := []struct {
+		desc        string
+		network     string
+		reservation *libvirtxml.NetworkDHCPHost
+		err         string
+	}{
+		{
+			desc:    "reservation does not exist",
+			network: "default",
+			reservation: &libvirtxml.NetworkDHCPHost{
+				IP:   "127.0.0.1",
+				MAC:  "00:00:00:11:11:11",
+				Name: "testing",
+			},
+		},
+		{
+			desc:    "reservation exists",
+			network: "default",
+			reservation: &libvirtxml.NetworkDHCPHost{
+				IP:   "192.168.122.45",
+				MAC:  "00:11:22:33:44:55",
+				Name: "test-hostname",
+			},
+		},
+		{
+			desc:    "network does not exist",
+			network: "does-not-exist",
+			reservation: &libvirtxml.NetworkDHCPHost{
+				IP:   "192.168.122.45",
+				MAC:  "00:11:22:33:44:55",
+				Name: "test-hostname",
+			},
+			err: "failed to find network",
+		},
+	}
+
+	for _, tc := range testCases {
 		entry, err := tc.reservation.Marshal()
 		must.NoError(t, err)
 
@@ -377,6 +2001,108 @@ func TestController_removeIPReservation(t *testing.T) {
 	}
 }
 
+func TestController_registerDNSHosts(t *testing.T) {
+	ipv4Entry := "<host ip=\"192.168.122.10\">\n  <hostname>nomad-0ea818bc</hostname>\n  <hostname>web.example.virt</hostname>\n</host>"
+	ipv6Entry := "<host ip=\"fd00::10\">\n  <hostname>nomad-0ea818bc</hostname>\n  <hostname>web.example.virt</hostname>\n</host>"
+	flags := libvirt.NETWORK_UPDATE_AFFECT_LIVE | libvirt.NETWORK_UPDATE_AFFECT_CONFIG
+
+	controller := &Controller{logger: hclog.NewNullLogger()}
+
+	t.Run("ok", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.Update{Cmd: libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, Section: libvirt.NETWORK_SECTION_DNS_HOST,
+				ParentIndex: automaticParentIndex, Xml: ipv4Entry, Flags: flags},
+			libvirt_mock.Update{Cmd: libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, Section: libvirt.NETWORK_SECTION_DNS_HOST,
+				ParentIndex: automaticParentIndex, Xml: ipv6Entry, Flags: flags},
+		)
+		defer network.AssertExpectations()
+
+		entries := controller.registerDNSHosts(network,
+			&net.InterfaceAddresses{IPv4: "192.168.122.10", IPv6: "fd00::10"},
+			[]string{"nomad-0ea818bc", "web.example.virt"})
+		must.Eq(t, []string{ipv4Entry, ipv6Entry}, entries)
+	})
+
+	t.Run("update failure", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.Update{Cmd: libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, Section: libvirt.NETWORK_SECTION_DNS_HOST,
+				ParentIndex: automaticParentIndex, Xml: ipv4Entry, Flags: flags, Err: errors.New("update failed")},
+		)
+		defer network.AssertExpectations()
+
+		entries := controller.registerDNSHosts(network, &net.InterfaceAddresses{IPv4: "192.168.122.10"},
+			[]string{"nomad-0ea818bc", "web.example.virt"})
+		must.SliceEmpty(t, entries)
+	})
+
+	t.Run("no hostnames", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t)
+		defer network.AssertExpectations()
+
+		must.SliceEmpty(t, controller.registerDNSHosts(network, &net.InterfaceAddresses{IPv4: "192.168.122.10"}, nil))
+	})
+}
+
+func Test_dnsHostnames(t *testing.T) {
+	must.SliceEmpty(t, dnsHostnames("", nil))
+	must.Eq(t, []string{"nomad-0ea818bc"}, dnsHostnames("nomad-0ea818bc", nil))
+	must.Eq(t, []string{"nomad-0ea818bc", "web.example.virt"},
+		dnsHostnames("nomad-0ea818bc", []string{"web.example.virt", "nomad-0ea818bc"}))
+	must.Eq(t, []string{"web.example.virt"}, dnsHostnames("", []string{"web.example.virt"}))
+}
+
+func TestController_removeDNSHost(t *testing.T) {
+	entry := "<host ip=\"192.168.122.10\">\n  <hostname>nomad-0ea818bc</hostname>\n</host>"
+	networkXML := `<network>
+  <name>default</name>
+  <dns>
+    <host ip='192.168.122.10'>
+      <hostname>nomad-0ea818bc</hostname>
+    </host>
+  </dns>
+</network>`
+
+	t.Run("exists", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: networkXML},
+			libvirt_mock.Update{Cmd: libvirt.NETWORK_UPDATE_COMMAND_DELETE, Section: libvirt.NETWORK_SECTION_DNS_HOST,
+				ParentIndex: automaticParentIndex, Xml: entry,
+				Flags: libvirt.NETWORK_UPDATE_AFFECT_LIVE | libvirt.NETWORK_UPDATE_AFFECT_CONFIG},
+			libvirt_mock.Free{},
+		)
+		defer network.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: network},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: mockConnect}
+		must.NoError(t, controller.removeDNSHost("default", entry))
+	})
+
+	t.Run("does not exist", func(t *testing.T) {
+		network := libvirt_mock.NewNetwork(t).Expect(
+			libvirt_mock.GetXMLDesc{Result: `<network><name>default</name></network>`},
+			libvirt_mock.Free{},
+		)
+		defer network.AssertExpectations()
+
+		mockConnect := libvirt_mock.NewConnect(t).Expect(
+			libvirt_mock.LookupNetworkByName{Name: "default", Result: network},
+		)
+		defer mockConnect.AssertExpectations()
+
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: mockConnect}
+		must.NoError(t, controller.removeDNSHost("default", entry))
+	})
+
+	t.Run("network does not exist", func(t *testing.T) {
+		controller := &Controller{logger: hclog.NewNullLogger(), netConn: &libvirt_mock.ConnectEmpty{}}
+		must.ErrorContains(t, controller.removeDNSHost("default", entry), "failed to find network")
+	})
+}
+
 func TestController_ipReservationExists(t *testing.T) {
 	controller := &Controller{
 		logger:  hclog.NewNullLogger(),
@@ -18,5 +18,8 @@ var _ shims.ConnectNetwork = &libvirt.Network{}
 func Test_NewController(t *testing.T) {
 	c := NewController(hclog.NewNullLogger(), nil)
 	must.Eq(t, c.dhcpLeaseDiscoveryInterval, defaultDHCPLeaseDiscoveryInterval)
-	must.Eq(t, c.dhcpLeaseDiscoveryTimeout, defaultDHCPLeaseDiscoveryTimeout)
+	must.Eq(t, c.discoveryTimeout, defaultDiscoveryTimeout)
+
+	// The traffic control check is cached, so it is only run once.
+	must.Eq(t, c.tcAvailable(), c.tcAvailable())
 }
@@ -29,6 +29,42 @@ type Connect interface {
 	// Also see:
 	// https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkLookupByName
 	LookupNetworkByName(name string) (ConnectNetwork, error)
+
+	// NetworkCreateXML creates and starts a transient network from the
+	// passed XML definition. The network is removed once it is destroyed.
+	//
+	// Also see:
+	// https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkCreateXML
+	NetworkCreateXML(xmlConfig string) (ConnectNetwork, error)
+
+	// LookupDomainByName returns a handle to the domain object as defined by
+	// the name argument. If the domain is not found, an error will be
+	// returned.
+	//
+	// Also see:
+	// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainLookupByName
+	LookupDomainByName(name string) (ConnectDomain, error)
+}
+
+// ConnectDomain is the shim interface that wraps libvirt connectivity
+// specific to a named domain. This allows us to create a mock implementation
+// for testing, as we cannot assume we will always have expensive bare-metal
+// hosts to run CI, especially on a public repository. Functions should be
+// added as required and match only those provided by libvirt.Domain.
+type ConnectDomain interface {
+
+	// ListAllInterfaceAddresses returns the network interfaces of the domain
+	// and their addresses, as reported by the passed source.
+	//
+	// Also see:
+	// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainInterfaceAddresses
+	ListAllInterfaceAddresses(src libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error)
+
+	// Free the resources associated to this instance
+	//
+	// Also see:
+	// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainFree
+	Free() error
 }
 
 // ConnectNetwork is the shim interface that wraps libvirt connectivity
@@ -69,6 +105,12 @@ type ConnectNetwork interface {
 	// https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkGetXMLDesc
 	GetXMLDesc(flags libvirt.NetworkXMLFlags) (string, error)
 
+	// Destroy stops the network. A transient network is also removed.
+	//
+	// Also see:
+	// https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkDestroy
+	Destroy() error
+
 	// Free the resources associated to this instance
 	//
 	// Also see:
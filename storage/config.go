@@ -15,7 +15,9 @@ import (
 var configSpec = hclspec.NewObject(map[string]*hclspec.Spec{
 	"default": hclspec.NewAttr("default", "string", false),
 	"directory": hclspec.NewBlockMap("directory", []string{"name"}, hclspec.NewObject(map[string]*hclspec.Spec{
-		"path": hclspec.NewAttr("path", "string", true),
+		// The path is defaulted in session mode, and is otherwise required
+		// by the directory validation.
+		"path": hclspec.NewAttr("path", "string", false),
 	})),
 	"ceph": hclspec.NewBlockMap("ceph", []string{"name"}, hclspec.NewObject(map[string]*hclspec.Spec{
 		"pool":  hclspec.NewAttr("pool", "string", true),
@@ -80,7 +82,7 @@ func (c *Config) Validate() error {
 
 // Directory provides configuration for local directory storage pools
 type Directory struct {
-	Path string `codec:"path"` // Local path of the storage pool
+	Path string `codec:"path"` // Local path of the storage pool, optional in session mode
 }
 
 // Validate validates the directory pool configuration.
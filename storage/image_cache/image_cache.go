@@ -0,0 +1,620 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package image_cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+)
+
+const (
+	// imageSuffix is appended to the cache key to form the name of the
+	// cached image file.
+	imageSuffix = ".img"
+
+	// metadataSuffix is appended to the name of an image file, complete or
+	// partial, to form the name of the file holding its metadata.
+	metadataSuffix = ".json"
+
+	// partialSuffix is appended to the name of the image file while it is
+	// being downloaded.
+	partialSuffix = ".part"
+
+	dirPermissions  = 0o755
+	filePermissions = 0o644
+
+	// defaultResponseHeaderTimeout is the time to wait for the headers of a
+	// response once the request has been sent.
+	defaultResponseHeaderTimeout = 30 * time.Second
+
+	// defaultReadTimeout is the time to wait for data while reading the
+	// body of a response, after which the request is abandoned.
+	defaultReadTimeout = time.Minute
+)
+
+var (
+	ErrChecksumMismatch = errors.New("image checksum mismatch")
+	ErrDownloadFailed   = errors.New("image download failed")
+
+	// checksumAlgorithms are the supported checksum algorithms.
+	checksumAlgorithms = map[string]func() hash.Hash{
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+)
+
+// Checksum is the expected checksum of an image.
+type Checksum struct {
+	Algorithm string // Name of the hash algorithm.
+	Value     string // Lowercase hex encoded value.
+}
+
+// ParseChecksum parses a checksum in the form of "algorithm:value". An empty
+// string returns a nil checksum.
+func ParseChecksum(s string) (*Checksum, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	algorithm, value, found := strings.Cut(s, ":")
+	if !found {
+		return nil, fmt.Errorf("%w: checksum %q must be in the form of algorithm:value",
+			errs.ErrInvalidConfiguration, s)
+	}
+
+	newHash, ok := checksumAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("%w: checksum %q has unsupported algorithm %q (supported: sha256, sha512)",
+			errs.ErrInvalidConfiguration, s, algorithm)
+	}
+
+	decoded, err := hex.DecodeString(value)
+	if err != nil || len(decoded) != newHash().Size() {
+		return nil, fmt.Errorf("%w: checksum %q has invalid %s value",
+			errs.ErrInvalidConfiguration, s, algorithm)
+	}
+
+	return &Checksum{Algorithm: strings.ToLower(algorithm), Value: hex.EncodeToString(decoded)}, nil
+}
+
+// String returns the string representation of the checksum.
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + c.Value
+}
+
+// metadata describes a cached image and the response it was downloaded from.
+type metadata struct {
+	URL          string            `json:"url"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"last_modified,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"` // Computed checksums by algorithm.
+}
+
+// validator returns the value used to make conditional requests for the
+// image. It is empty when the response did not include a validator.
+func (m *metadata) validator() string {
+	if m.ETag != "" {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// entry tracks the requests for an image. Requests hold the lock of the
+// entry while the image is fetched and verified, so a single download of the
+// image is performed at a time.
+type entry struct {
+	lock    chan struct{} // held while the image is fetched or verified.
+	refs    int           // number of active requests.
+	fetches int           // number of completed fetches.
+	err     error         // result of the last fetch.
+}
+
+// Cache downloads images from HTTP(S) sources into a local directory. Images
+// are revalidated with the source on each request, interrupted downloads are
+// resumed, and concurrent requests for the same image share a single
+// download.
+type Cache struct {
+	dir         string
+	client      *http.Client
+	readTimeout time.Duration
+	logger      hclog.Logger
+	entries     map[string]*entry
+	mu          sync.Mutex
+
+	// registryAuthFile is the path of the Docker client configuration file
+	// holding registry credentials.
+	registryAuthFile string
+}
+
+// New returns a new cache storing images within the directory.
+func New(dir string, logger hclog.Logger) *Cache {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+
+	return &Cache{
+		dir:         dir,
+		client:      &http.Client{Transport: transport},
+		readTimeout: defaultReadTimeout,
+		logger:      logger.Named("image_cache"),
+		entries:     make(map[string]*entry),
+	}
+}
+
+// Dir returns the directory where images are cached.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Fetch returns the path of the cached copy of the image at the URL,
+// downloading it if it is not cached or has changed. When a checksum is
+// provided, the image is verified against it, and a cached image matching the
+// checksum is used without contacting the source.
+func (c *Cache) Fetch(ctx context.Context, url, checksum string) (string, error) {
+	sum, err := ParseChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+
+	key := cacheKey(url)
+	e, fetches, unlock, err := c.lock(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// A fetch which completed while waiting for the lock has already
+	// downloaded or revalidated the image, so it only needs verifying.
+	if e.fetches != fetches && e.err == nil {
+		err = c.verify(key, sum)
+	} else {
+		err = c.fetch(ctx, key, url, sum)
+
+		c.mu.Lock()
+		e.fetches++
+		e.err = err
+		c.mu.Unlock()
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.imagePath(key), nil
+}
+
+// lock waits for the lock of the entry of the image, returning the entry
+// along with the number of fetches completed when the request was made. The
+// returned function releases the lock.
+func (c *Cache) lock(ctx context.Context, key string) (*entry, int, func(), error) {
+	e, fetches := c.acquire(key)
+
+	select {
+	case e.lock <- struct{}{}:
+	case <-ctx.Done():
+		c.release(key)
+		return nil, 0, nil, ctx.Err()
+	}
+
+	return e, fetches, func() {
+		<-e.lock
+		c.release(key)
+	}, nil
+}
+
+// acquire returns the entry of the image, along with the number of fetches
+// completed when the request was made.
+func (c *Cache) acquire(key string) (*entry, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{lock: make(chan struct{}, 1)}
+		c.entries[key] = e
+	}
+	e.refs++
+
+	return e, e.fetches
+}
+
+// release removes the entry of the image once it has no active requests.
+func (c *Cache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[key]
+	e.refs--
+	if e.refs == 0 {
+		delete(c.entries, key)
+	}
+}
+
+// fetch downloads the image if it is not cached or has changed, and verifies
+// the cached image.
+func (c *Cache) fetch(ctx context.Context, key, url string, sum *Checksum) error {
+	if err := os.MkdirAll(c.dir, dirPermissions); err != nil {
+		return fmt.Errorf("failed to create image cache directory: %w", err)
+	}
+
+	path := c.imagePath(key)
+	meta, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	// A cached image is only used if it was downloaded from the same URL.
+	if meta != nil && (meta.URL != url || !fileExists(path)) {
+		meta = nil
+	}
+
+	// An image matching the checksum is not downloaded again, as it can
+	// not have changed.
+	if meta != nil && sum != nil {
+		if match, err := c.matches(path, meta, sum); err == nil && match {
+			c.logger.Debug("using cached image matching checksum", "url", url)
+			return nil
+		}
+	}
+
+	err = c.download(ctx, key, url, meta)
+	if err != nil {
+		if meta == nil || errors.Is(err, context.Canceled) {
+			return err
+		}
+
+		// The source being unavailable should not prevent the use of the
+		// image previously downloaded.
+		c.logger.Warn("failed to revalidate cached image, using cached copy", "url", url, "error", err)
+	}
+
+	return c.verify(key, sum)
+}
+
+// download requests the image from the source and stores it in the cache,
+// unless the cached image is still current. A partial download of the same
+// image is resumed.
+func (c *Cache) download(ctx context.Context, key, url string, cached *metadata) error {
+	path := c.imagePath(key)
+	partPath := path + partialSuffix
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	// Resume the partial download if it has a validator, which ensures the
+	// remainder is only sent if the image has not changed.
+	partMeta, err := readMetadata(partPath)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if partMeta != nil && partMeta.URL == url && partMeta.validator() != "" {
+		if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+			offset = info.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", partMeta.validator())
+		}
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer resp.Body.Close()
+
+	var flags int
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cached == nil {
+			return fmt.Errorf("%w: unexpected status %q", ErrDownloadFailed, resp.Status)
+		}
+		c.logger.Debug("cached image is current", "url", url)
+		return nil
+	case http.StatusOK:
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		offset = 0
+		partMeta = &metadata{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := writeMetadata(partPath, partMeta); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return fmt.Errorf("%w: unexpected content range %q", ErrDownloadFailed, resp.Header.Get("Content-Range"))
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		c.logger.Debug("resuming image download", "url", url, "offset", offset)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download is no longer valid for the image, so it is
+		// removed to be restarted by the next request.
+		removeImage(partPath)
+		return fmt.Errorf("%w: partial download is not valid for %s", ErrDownloadFailed, url)
+	default:
+		return fmt.Errorf("%w: unexpected status %q", ErrDownloadFailed, resp.Status)
+	}
+
+	c.logger.Info("downloading image", "url", url)
+
+	f, err := os.OpenFile(partPath, flags, filePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+
+	written, err := io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("%w: received %d of %d bytes", ErrDownloadFailed, written, resp.ContentLength)
+	}
+
+	// Move the completed image into place, followed by its metadata. Any
+	// checksums of the previous image are not carried over.
+	if err := os.Rename(partPath, path); err != nil {
+		return fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := os.Rename(partPath+metadataSuffix, path+metadataSuffix); err != nil {
+		return fmt.Errorf("failed to store image metadata: %w", err)
+	}
+
+	c.logger.Info("downloaded image", "url", url, "size", offset+written)
+	return nil
+}
+
+// do sends the request. Reading the body of the response fails with
+// errReadTimeout when no data is received within the read timeout, so a
+// stalled transfer does not block the request indefinitely.
+func (c *Cache) do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+
+	resp.Body = &timeoutBody{
+		body:    resp.Body,
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: c.readTimeout,
+		timer:   time.AfterFunc(c.readTimeout, func() { cancel(errReadTimeout) }),
+	}
+
+	return resp, nil
+}
+
+// errReadTimeout is the error returned when no data of a response body has
+// been received within the read timeout.
+var errReadTimeout = errors.New("timed out waiting for response data")
+
+// timeoutBody is a response body which cancels the request when no data is
+// read within the timeout.
+type timeoutBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+
+	if err != nil && err != io.EOF && errors.Is(context.Cause(b.ctx), errReadTimeout) {
+		err = errReadTimeout
+	}
+
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel(nil)
+	return b.body.Close()
+}
+
+// verify checks the cached image matches the checksum. An image which does
+// not match is removed, so it is downloaded again by the next request.
+func (c *Cache) verify(key string, sum *Checksum) error {
+	path := c.imagePath(key)
+	meta, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+	if meta == nil || !fileExists(path) {
+		return fmt.Errorf("%w: image is not cached", ErrDownloadFailed)
+	}
+
+	if sum == nil {
+		return nil
+	}
+
+	match, err := c.matches(path, meta, sum)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		removeImage(path)
+		return fmt.Errorf("%w: %s does not match %s", ErrChecksumMismatch, meta.URL, sum)
+	}
+
+	return nil
+}
+
+// matches returns if the image matches the checksum. The computed checksum
+// is stored in the metadata, so the image is only hashed once per algorithm.
+func (c *Cache) matches(path string, meta *metadata, sum *Checksum) (bool, error) {
+	value, ok := meta.Checksums[sum.Algorithm]
+	if !ok {
+		var err error
+		if value, err = computeChecksum(path, sum.Algorithm); err != nil {
+			return false, err
+		}
+
+		if meta.Checksums == nil {
+			meta.Checksums = make(map[string]string)
+		}
+		meta.Checksums[sum.Algorithm] = value
+		if err := writeMetadata(path, meta); err != nil {
+			c.logger.Warn("failed to store image checksum", "path", path, "error", err)
+		}
+	}
+
+	return value == sum.Value, nil
+}
+
+// imagePath returns the path of the cached image for the key.
+func (c *Cache) imagePath(key string) string {
+	return filepath.Join(c.dir, key+imageSuffix)
+}
+
+// cacheKey returns the key of the image at the URL.
+func cacheKey(url string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+}
+
+// computeChecksum returns the hex encoded checksum of the file.
+func computeChecksum(path, algorithm string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := checksumAlgorithms[algorithm]()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to compute image checksum: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rangeStart returns the first byte position of a Content-Range header.
+func rangeStart(contentRange string) (int64, bool) {
+	spec, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, false
+	}
+
+	start, _, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, false
+	}
+
+	value, err := strconv.ParseInt(start, 10, 64)
+	return value, err == nil
+}
+
+// readMetadata reads the metadata of the image file. Nil is returned when
+// the metadata does not exist.
+func readMetadata(path string) (*metadata, error) {
+	content, err := os.ReadFile(path + metadataSuffix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+
+	var meta metadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		// Metadata which can not be read is treated as missing, so the
+		// image is downloaded again.
+		return nil, nil
+	}
+
+	return &meta, nil
+}
+
+// writeMetadata writes the metadata of the image file.
+func writeMetadata(path string, meta *metadata) error {
+	return writeJSON(path+metadataSuffix, meta)
+}
+
+// writeJSON atomically replaces the file with the JSON encoding of the value.
+func writeJSON(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// A unique temporary file is used, as the metadata of an image may be
+	// written by concurrent requests.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write image metadata: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), filePermissions)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write image metadata: %w", err)
+	}
+
+	return nil
+}
+
+// removeImage removes the image file and its metadata.
+func removeImage(path string) {
+	os.Remove(path)
+	os.Remove(path + metadataSuffix)
+}
+
+// fileExists checks if a file exists at the path
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return !info.IsDir()
+}
@@ -0,0 +1,322 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package image_cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+)
+
+// testServer serves an image, counting the requests and full downloads.
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	requests atomic.Int32
+	fulls    atomic.Int32
+	ranges   []string
+	block    chan struct{}
+}
+
+func newTestServer(t *testing.T, content []byte) *testServer {
+	ts := &testServer{content: content, etag: `"v1"`}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.requests.Add(1)
+		if ts.block != nil {
+			<-ts.block
+		}
+
+		ts.mu.Lock()
+		content, etag := ts.content, ts.etag
+		if rng := r.Header.Get("Range"); rng != "" {
+			ts.ranges = append(ts.ranges, rng)
+		}
+		ts.mu.Unlock()
+
+		if r.Header.Get("Range") == "" && r.Header.Get("If-None-Match") != etag {
+			ts.fulls.Add(1)
+		}
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "image.qcow2", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// update changes the image served.
+func (ts *testServer) update(content []byte, etag string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.content, ts.etag = content, etag
+}
+
+func mkTestCache(t *testing.T) *Cache {
+	return New(filepath.Join(t.TempDir(), "images"), hclog.NewNullLogger())
+}
+
+func checksumOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum, err := ParseChecksum("")
+	must.NoError(t, err)
+	must.Nil(t, sum)
+
+	value := fmt.Sprintf("%X", sha256.Sum256([]byte("image")))
+	sum, err = ParseChecksum("SHA256:" + value)
+	must.NoError(t, err)
+	must.Eq(t, &Checksum{Algorithm: "sha256", Value: fmt.Sprintf("%x", sha256.Sum256([]byte("image")))}, sum)
+
+	for _, tc := range []struct {
+		checksum string
+		err      string
+	}{
+		{checksum: value, err: "must be in the form of algorithm:value"},
+		{checksum: "md5:" + value, err: `unsupported algorithm "md5"`},
+		{checksum: "sha256:zz", err: "invalid sha256 value"},
+		{checksum: "sha512:" + value, err: "invalid sha512 value"},
+	} {
+		_, err := ParseChecksum(tc.checksum)
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestCache_Fetch(t *testing.T) {
+	content := []byte("image content")
+
+	t.Run("download", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		path, err := c.Fetch(t.Context(), ts.URL+"/image.qcow2", checksumOf(content))
+		must.NoError(t, err)
+		must.StrHasPrefix(t, c.Dir(), path)
+
+		data, err := os.ReadFile(path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+		must.FileNotExists(t, path+partialSuffix)
+	})
+
+	t.Run("revalidate", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		path, err := c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+
+		again, err := c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+		must.Eq(t, path, again)
+		must.Eq(t, 2, ts.requests.Load())
+		must.Eq(t, 1, ts.fulls.Load())
+
+		// A changed image is downloaded again.
+		ts.update([]byte("new image content"), `"v2"`)
+		_, err = c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+		must.Eq(t, 2, ts.fulls.Load())
+
+		data, err := os.ReadFile(path)
+		must.NoError(t, err)
+		must.Eq(t, "new image content", string(data))
+	})
+
+	t.Run("checksum match", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		_, err := c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+
+		// The cached image matching the checksum is not revalidated.
+		_, err = c.Fetch(t.Context(), ts.URL, checksumOf(content))
+		must.NoError(t, err)
+		_, err = c.Fetch(t.Context(), ts.URL, checksumOf(content))
+		must.NoError(t, err)
+		must.Eq(t, 1, ts.requests.Load())
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		_, err := c.Fetch(t.Context(), ts.URL, checksumOf([]byte("other")))
+		must.ErrorIs(t, err, ErrChecksumMismatch)
+		must.FileNotExists(t, c.imagePath(cacheKey(ts.URL)))
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		// Create a partial download of the image.
+		partPath := c.imagePath(cacheKey(ts.URL)) + partialSuffix
+		must.NoError(t, os.MkdirAll(c.Dir(), dirPermissions))
+		must.NoError(t, os.WriteFile(partPath, content[:5], filePermissions))
+		must.NoError(t, writeMetadata(partPath, &metadata{URL: ts.URL, ETag: `"v1"`}))
+
+		path, err := c.Fetch(t.Context(), ts.URL, checksumOf(content))
+		must.NoError(t, err)
+		must.Eq(t, []string{"bytes=5-"}, ts.ranges)
+
+		data, err := os.ReadFile(path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("resume changed", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		partPath := c.imagePath(cacheKey(ts.URL)) + partialSuffix
+		must.NoError(t, os.MkdirAll(c.Dir(), dirPermissions))
+		must.NoError(t, os.WriteFile(partPath, []byte("stale"), filePermissions))
+		must.NoError(t, writeMetadata(partPath, &metadata{URL: ts.URL, ETag: `"v0"`}))
+
+		// The validator no longer matches, so the full image is sent.
+		path, err := c.Fetch(t.Context(), ts.URL, checksumOf(content))
+		must.NoError(t, err)
+
+		data, err := os.ReadFile(path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("source unavailable", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		c := mkTestCache(t)
+
+		path, err := c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+
+		ts.Close()
+		again, err := c.Fetch(t.Context(), ts.URL, "")
+		must.NoError(t, err)
+		must.Eq(t, path, again)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+
+		_, err := mkTestCache(t).Fetch(t.Context(), ts.URL, "")
+		must.ErrorIs(t, err, ErrDownloadFailed)
+		must.ErrorContains(t, err, "404")
+	})
+
+	t.Run("invalid checksum", func(t *testing.T) {
+		_, err := mkTestCache(t).Fetch(t.Context(), "http://localhost", "sha1:abc")
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		ts.block = make(chan struct{})
+		c := mkTestCache(t)
+
+		var wg sync.WaitGroup
+		errCh := make(chan error, 3)
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := c.Fetch(t.Context(), ts.URL, checksumOf(content))
+				errCh <- err
+			}()
+		}
+
+		// Wait for the first request to reach the server before allowing
+		// it to respond.
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool { return ts.requests.Load() == 1 }),
+			wait.Timeout(time.Second),
+			wait.Gap(10*time.Millisecond),
+		))
+		close(ts.block)
+		wg.Wait()
+		close(errCh)
+
+		for err := range errCh {
+			must.NoError(t, err)
+		}
+		must.Eq(t, 1, ts.requests.Load())
+	})
+
+	t.Run("response header timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer ts.Close()
+		defer close(done)
+
+		c := mkTestCache(t)
+		c.client.Transport.(*http.Transport).ResponseHeaderTimeout = 50 * time.Millisecond
+
+		_, err := c.Fetch(t.Context(), ts.URL, "")
+		must.ErrorIs(t, err, ErrDownloadFailed)
+		must.ErrorContains(t, err, "timeout awaiting response headers")
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.Write(content[:4])
+			w.(http.Flusher).Flush()
+			<-done
+		}))
+		defer ts.Close()
+		defer close(done)
+
+		c := mkTestCache(t)
+		c.readTimeout = 50 * time.Millisecond
+
+		_, err := c.Fetch(t.Context(), ts.URL, "")
+		must.ErrorIs(t, err, ErrDownloadFailed)
+		must.ErrorIs(t, err, errReadTimeout)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ts := newTestServer(t, content)
+		ts.block = make(chan struct{})
+		defer close(ts.block)
+		c := mkTestCache(t)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		go func() {
+			for ts.requests.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		_, err := c.Fetch(ctx, ts.URL, "")
+		must.ErrorIs(t, err, context.Canceled)
+		must.MapEmpty(t, c.entries)
+	})
+}
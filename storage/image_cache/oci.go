@@ -0,0 +1,523 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package image_cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/storage"
+)
+
+const (
+	// MediaTypeDiskQcow2 is the media type of an image layer holding a
+	// qcow2 disk image.
+	MediaTypeDiskQcow2 = "application/vnd.hashicorp.virt.disk.v1.qcow2"
+
+	// MediaTypeDiskRaw is the media type of an image layer holding a raw
+	// disk image.
+	MediaTypeDiskRaw = "application/vnd.hashicorp.virt.disk.v1.raw"
+
+	// mediaTypeManifest is the media type of an OCI image manifest.
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// maxManifestSize is the largest manifest which is read from a registry.
+	maxManifestSize = 4 << 20
+
+	// defaultTag is the tag pulled when a reference has no tag or digest.
+	defaultTag = "latest"
+
+	// blobPrefix is prepended to the hex value of a layer digest to form the
+	// name of the cached disk image.
+	blobPrefix = "sha256-"
+)
+
+var (
+	ErrPullFailed = errors.New("image pull failed")
+
+	// diskMediaTypes maps the media types of disk image layers to the disk
+	// format of the layer.
+	diskMediaTypes = map[string]string{
+		MediaTypeDiskQcow2: storage.DiskFormatQcow2,
+		MediaTypeDiskRaw:   storage.DiskFormatRaw,
+	}
+
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRegexp     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	authParamRegexp  = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// Reference identifies an image within an OCI registry.
+type Reference struct {
+	Registry   string // Host, and optional port, of the registry.
+	Repository string // Repository within the registry.
+	Tag        string // Tag of the image. Empty when pinned by digest only.
+	Digest     string // Digest of the image manifest, if pinned.
+}
+
+// ParseReference parses an image reference in the form of
+// "registry/repository[:tag][@digest]". The registry must be included, and
+// the tag defaults to latest when neither a tag nor a digest is provided.
+func ParseReference(s string) (*Reference, error) {
+	name, digest, pinned := strings.Cut(s, "@")
+	ref := &Reference{Digest: digest}
+
+	if pinned && !digestRegexp.MatchString(digest) {
+		return nil, fmt.Errorf("%w: oci reference %q has invalid digest %q (only sha256 is supported)",
+			errs.ErrInvalidConfiguration, s, digest)
+	}
+
+	// A tag follows the last colon after the registry, as the registry may
+	// include a port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("%w: oci reference %q has invalid tag %q",
+				errs.ErrInvalidConfiguration, s, ref.Tag)
+		}
+	}
+
+	registry, repository, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		return nil, fmt.Errorf("%w: oci reference %q must include the registry host",
+			errs.ErrInvalidConfiguration, s)
+	}
+
+	if !repositoryRegexp.MatchString(repository) {
+		return nil, fmt.Errorf("%w: oci reference %q has invalid repository %q",
+			errs.ErrInvalidConfiguration, s, repository)
+	}
+
+	ref.Registry, ref.Repository = registry, repository
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+
+	return ref, nil
+}
+
+// String returns the string representation of the reference.
+func (r *Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// manifestReference returns the tag or digest used to request the manifest.
+// A digest is preferred, so a tag which has since moved is not pulled.
+func (r *Reference) manifestReference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// baseURL returns the URL of the repository within the registry API. Plain
+// HTTP is only used for registries on the loopback address.
+func (r *Reference) baseURL() string {
+	scheme := "https"
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(r.Registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+
+	return scheme + "://" + r.Registry + "/v2/" + r.Repository
+}
+
+// OCIImage is a disk image pulled from an OCI registry.
+type OCIImage struct {
+	Path   string // Path of the cached disk image.
+	Format string // Format of the disk image, from the layer media type.
+	Digest string // Digest of the image manifest.
+}
+
+// descriptor describes content referenced by a manifest.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// manifest is an OCI image manifest.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// pulled is the resolution of a reference stored within the cache, so the
+// image can be used while the registry is unavailable.
+type pulled struct {
+	Reference string     `json:"reference"`
+	Digest    string     `json:"digest"`
+	Layer     descriptor `json:"layer"`
+}
+
+// Pull returns the cached copy of the disk image at the reference, pulling
+// it from the registry if it is not cached. The manifest must include a
+// single disk image layer, which is cached by its digest.
+func (c *Cache) Pull(ctx context.Context, reference string) (*OCIImage, error) {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(c.dir, dirPermissions); err != nil {
+		return nil, fmt.Errorf("failed to create image cache directory: %w", err)
+	}
+
+	pulledPath := filepath.Join(c.dir, cacheKey("oci://"+ref.String())+metadataSuffix)
+	result, err := c.resolve(ctx, ref)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, ErrChecksumMismatch) {
+			return nil, err
+		}
+
+		// The registry being unavailable should not prevent the use of the
+		// image previously pulled.
+		previous, readErr := readPulled(pulledPath)
+		if readErr != nil || previous == nil || !fileExists(c.blobPath(previous.Layer.Digest)) {
+			return nil, err
+		}
+
+		c.logger.Warn("failed to resolve image, using cached copy", "reference", ref, "error", err)
+		result = previous
+	}
+
+	path, err := c.pullBlob(ctx, ref, result.Layer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeJSON(pulledPath, result); err != nil {
+		c.logger.Warn("failed to store image reference", "reference", ref, "error", err)
+	}
+
+	return &OCIImage{
+		Path:   path,
+		Format: diskMediaTypes[result.Layer.MediaType],
+		Digest: result.Digest,
+	}, nil
+}
+
+// resolve requests the manifest of the reference and returns the disk image
+// layer it includes.
+func (c *Cache) resolve(ctx context.Context, ref *Reference) (*pulled, error) {
+	resp, err := c.registryGet(ctx, ref, "/manifests/"+ref.manifestReference(),
+		http.Header{"Accept": {mediaTypeManifest}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: manifest request for %s returned %q", ErrPullFailed, ref, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+	if len(content) > maxManifestSize {
+		return nil, fmt.Errorf("%w: manifest of %s exceeds %d bytes", ErrPullFailed, ref, maxManifestSize)
+	}
+
+	// The manifest must match the digest it was requested with, and the
+	// digest reported by the registry.
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	if ref.Digest != "" && ref.Digest != digest {
+		return nil, fmt.Errorf("%w: manifest of %s has digest %s", ErrChecksumMismatch, ref, digest)
+	}
+	if reported := resp.Header.Get("Docker-Content-Digest"); reported != "" && reported != digest {
+		return nil, fmt.Errorf("%w: manifest of %s has digest %s but registry reported %s",
+			ErrChecksumMismatch, ref, digest, reported)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode manifest of %s: %w", ErrPullFailed, ref, err)
+	}
+	if m.SchemaVersion != 2 || (m.MediaType != "" && m.MediaType != mediaTypeManifest) {
+		return nil, fmt.Errorf("%w: %s is not an OCI image manifest", ErrPullFailed, ref)
+	}
+
+	var layers []descriptor
+	for _, layer := range m.Layers {
+		if _, ok := diskMediaTypes[layer.MediaType]; ok {
+			layers = append(layers, layer)
+		}
+	}
+
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("%w: %s must include a single layer of media type %s or %s, found %d",
+			ErrPullFailed, ref, MediaTypeDiskQcow2, MediaTypeDiskRaw, len(layers))
+	}
+	if !digestRegexp.MatchString(layers[0].Digest) {
+		return nil, fmt.Errorf("%w: %s has disk layer with unsupported digest %q",
+			ErrPullFailed, ref, layers[0].Digest)
+	}
+
+	return &pulled{Reference: ref.String(), Digest: digest, Layer: layers[0]}, nil
+}
+
+// pullBlob returns the path of the cached disk image layer, downloading it
+// if it is not cached. Requests for the same layer share a single download.
+func (c *Cache) pullBlob(ctx context.Context, ref *Reference, layer descriptor) (string, error) {
+	path := c.blobPath(layer.Digest)
+
+	_, _, unlock, err := c.lock(ctx, blobPrefix+layer.Digest)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Layers are only moved into place once verified, and can not change
+	// as they are addressed by their digest.
+	if fileExists(path) {
+		return path, nil
+	}
+
+	partPath := path + partialSuffix
+	var offset int64
+	header := http.Header{}
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 && info.Size() < layer.Size {
+		offset = info.Size()
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.registryGet(ctx, ref, "/blobs/"+layer.Digest, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return "", fmt.Errorf("%w: unexpected content range %q", ErrPullFailed, resp.Header.Get("Content-Range"))
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		c.logger.Debug("resuming image layer download", "reference", ref, "offset", offset)
+	default:
+		return "", fmt.Errorf("%w: layer request for %s returned %q", ErrPullFailed, ref, resp.Status)
+	}
+
+	c.logger.Info("pulling image", "reference", ref, "layer", layer.Digest)
+
+	f, err := os.OpenFile(partPath, flags, filePermissions)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image file: %w", err)
+	}
+
+	// Never read past the size of the layer described by the manifest.
+	written, err := io.Copy(f, io.LimitReader(resp.Body, layer.Size-offset+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+
+	if size := offset + written; size != layer.Size {
+		if size > layer.Size {
+			os.Remove(partPath)
+		}
+		return "", fmt.Errorf("%w: received %d of %d bytes of layer %s", ErrPullFailed, size, layer.Size, layer.Digest)
+	}
+
+	sum, err := computeChecksum(partPath, "sha256")
+	if err != nil {
+		return "", err
+	}
+	if "sha256:"+sum != layer.Digest {
+		os.Remove(partPath)
+		return "", fmt.Errorf("%w: layer of %s has digest sha256:%s, expected %s",
+			ErrChecksumMismatch, ref, sum, layer.Digest)
+	}
+
+	if err := os.Rename(partPath, path); err != nil {
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+
+	c.logger.Info("pulled image", "reference", ref, "size", layer.Size)
+	return path, nil
+}
+
+// registryGet sends a request to the registry API of the repository. When
+// the registry requires a bearer token, a token is requested, using the
+// credentials of the registry when available, and the request is sent again.
+// When the registry requires basic authentication, the request is sent again
+// using the credentials of the registry.
+func (c *Cache) registryGet(ctx context.Context, ref *Reference, path string, header http.Header) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref.baseURL()+path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPullFailed, err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	scheme, _, _ := strings.Cut(strings.ToLower(challenge), " ")
+	if resp.StatusCode != http.StatusUnauthorized || (scheme != "bearer" && scheme != "basic") {
+		return resp, nil
+	}
+
+	creds, err := c.registryCredentials(ref.Registry)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// Basic authentication can only be performed with credentials, so
+	// the unauthorized response is returned.
+	if scheme == "basic" && creds == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+
+	if scheme == "basic" {
+		req.SetBasicAuth(creds.username, creds.password)
+	} else {
+		token, err := c.registryToken(ctx, challenge, creds)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err = c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+
+	return resp, nil
+}
+
+// registryToken requests a bearer token using the parameters of the
+// authentication challenge returned by the registry. The token is requested
+// anonymously when no credentials are provided.
+func (c *Cache) registryToken(ctx context.Context, challenge string, creds *registryCredentials) (string, error) {
+	params := map[string]string{}
+	for _, match := range authParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("%w: registry authentication challenge has invalid realm %q", ErrPullFailed, params["realm"])
+	}
+
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if value, ok := params[name]; ok {
+			query.Set(name, value)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.username, creds.password)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPullFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: registry token request returned %q", ErrPullFailed, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: failed to decode registry token: %w", ErrPullFailed, err)
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("%w: registry token response did not include a token", ErrPullFailed)
+}
+
+// blobPath returns the path of the cached layer with the digest.
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.dir, blobPrefix+strings.TrimPrefix(digest, "sha256:")+imageSuffix)
+}
+
+// readPulled reads the stored resolution of a reference. Nil is returned
+// when it does not exist.
+func readPulled(path string) (*pulled, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var p pulled
+	if err := json.Unmarshal(content, &p); err != nil {
+		return nil, err
+	}
+
+	if !digestRegexp.MatchString(p.Layer.Digest) {
+		return nil, fmt.Errorf("invalid layer digest %q", p.Layer.Digest)
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,456 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package image_cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad-driver-virt/storage"
+	"github.com/shoenig/test/must"
+)
+
+// testRegistry is a registry stand-in serving a single repository.
+type testRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string][]byte // manifests by tag and digest.
+	blobs     map[string][]byte // blobs by digest.
+	token     string            // bearer token required, if set.
+	username  string            // username required by the token endpoint, if set.
+	password  string            // password required by the token endpoint.
+	basic     bool              // when set, basic authentication is required instead of a token.
+	ranges    []string
+	blobGets  atomic.Int32
+	stall     chan struct{} // when set, blob responses stall until closed.
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("scope") != "repository:images/ubuntu:pull" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if username, password, _ := req.BasicAuth(); username != r.username || password != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+	})
+	mux.HandleFunc("/v2/images/ubuntu/", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if username, password, ok := req.BasicAuth(); r.basic && (!ok || username != r.username || password != r.password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry",scope="repository:images/ubuntu:pull"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		kind, name, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/images/ubuntu/"), "/")
+		switch kind {
+		case "manifests":
+			content, ok := r.manifests[name]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeManifest)
+			w.Header().Set("Docker-Content-Digest", checksumOf(content))
+			w.Write(content)
+		case "blobs":
+			content, ok := r.blobs[name]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			r.blobGets.Add(1)
+			if rng := req.Header.Get("Range"); rng != "" {
+				r.ranges = append(r.ranges, rng)
+			}
+			if r.stall != nil {
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				w.Write(content[:1])
+				w.(http.Flusher).Flush()
+				<-r.stall
+				return
+			}
+			http.ServeContent(w, req, "", time.Time{}, strings.NewReader(string(content)))
+		default:
+			http.NotFound(w, req)
+		}
+	})
+
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+// push adds an image with the layers to the registry, tagging the manifest.
+func (r *testRegistry) push(t *testing.T, tag string, layers ...descriptor) string {
+	content, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeManifest,
+		"config": descriptor{
+			MediaType: "application/vnd.oci.empty.v1+json",
+			Digest:    checksumOf([]byte("{}")),
+			Size:      2,
+		},
+		"layers": layers,
+	})
+	must.NoError(t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := checksumOf(content)
+	r.manifests[tag] = content
+	r.manifests[digest] = content
+
+	return digest
+}
+
+// layer adds the content as a blob and returns its descriptor.
+func (r *testRegistry) layer(mediaType string, content []byte) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := checksumOf(content)
+	r.blobs[digest] = content
+
+	return descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+// authFile writes a Docker client configuration file holding the passed
+// credentials for the registry and returns its path.
+func (r *testRegistry) authFile(t *testing.T, username, password string) string {
+	content, err := json.Marshal(map[string]any{
+		"auths": map[string]any{
+			"https://example.com/v1/": map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte("other:creds"))},
+			r.URL: map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	})
+	must.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	must.NoError(t, os.WriteFile(path, content, filePermissions))
+
+	return path
+}
+
+// reference returns the reference of the tag within the registry.
+func (r *testRegistry) reference(tag string) string {
+	return strings.TrimPrefix(r.URL, "http://") + "/images/ubuntu:" + tag
+}
+
+func TestParseReference(t *testing.T) {
+	digest := checksumOf([]byte("manifest"))
+
+	testCases := []struct {
+		name     string
+		ref      string
+		expected *Reference
+		err      string
+	}{
+		{
+			name:     "tag",
+			ref:      "registry.example.com/images/ubuntu:24.04",
+			expected: &Reference{Registry: "registry.example.com", Repository: "images/ubuntu", Tag: "24.04"},
+		},
+		{
+			name:     "default tag",
+			ref:      "localhost:5000/ubuntu",
+			expected: &Reference{Registry: "localhost:5000", Repository: "ubuntu", Tag: "latest"},
+		},
+		{
+			name:     "digest",
+			ref:      "registry.example.com/ubuntu@" + digest,
+			expected: &Reference{Registry: "registry.example.com", Repository: "ubuntu", Digest: digest},
+		},
+		{
+			name: "tag and digest",
+			ref:  "registry.example.com:443/ubuntu:noble@" + digest,
+			expected: &Reference{Registry: "registry.example.com:443", Repository: "ubuntu", Tag: "noble",
+				Digest: digest},
+		},
+		{
+			name: "missing registry",
+			ref:  "images/ubuntu:24.04",
+			err:  "must include the registry host",
+		},
+		{
+			name: "invalid repository",
+			ref:  "registry.example.com/Ubuntu",
+			err:  `invalid repository "Ubuntu"`,
+		},
+		{
+			name: "invalid tag",
+			ref:  "registry.example.com/ubuntu:.noble",
+			err:  `invalid tag ".noble"`,
+		},
+		{
+			name: "invalid digest",
+			ref:  "registry.example.com/ubuntu@md5:abc",
+			err:  `invalid digest "md5:abc"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseReference(tc.ref)
+			if tc.err != "" {
+				must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+				must.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			must.NoError(t, err)
+			must.Eq(t, tc.expected, ref)
+		})
+	}
+}
+
+func TestCache_Pull(t *testing.T) {
+	content := []byte("qcow2 disk image")
+
+	t.Run("ok", func(t *testing.T) {
+		r := newTestRegistry(t)
+		digest := r.push(t, "24.04",
+			r.layer("application/vnd.oci.image.layer.v1.tar", []byte("other")),
+			r.layer(MediaTypeDiskQcow2, content),
+		)
+		c := mkTestCache(t)
+
+		img, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+		must.Eq(t, storage.DiskFormatQcow2, img.Format)
+		must.Eq(t, digest, img.Digest)
+		must.StrHasPrefix(t, c.Dir(), img.Path)
+
+		data, err := os.ReadFile(img.Path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+
+		// The cached layer is not downloaded again.
+		again, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+		must.Eq(t, img, again)
+		must.Eq(t, 1, r.blobGets.Load())
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.stall = make(chan struct{})
+		t.Cleanup(func() { close(r.stall) })
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+
+		c := mkTestCache(t)
+		c.readTimeout = 50 * time.Millisecond
+
+		_, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorIs(t, err, errReadTimeout)
+	})
+
+	t.Run("digest", func(t *testing.T) {
+		r := newTestRegistry(t)
+		digest := r.push(t, "24.04", r.layer(MediaTypeDiskRaw, content))
+		c := mkTestCache(t)
+
+		img, err := c.Pull(t.Context(), strings.TrimSuffix(r.reference(""), ":")+"@"+digest)
+		must.NoError(t, err)
+		must.Eq(t, storage.DiskFormatRaw, img.Format)
+		must.Eq(t, digest, img.Digest)
+	})
+
+	t.Run("moved tag with digest", func(t *testing.T) {
+		r := newTestRegistry(t)
+		digest := r.push(t, "24.04", r.layer(MediaTypeDiskRaw, content))
+		r.push(t, "24.04", r.layer(MediaTypeDiskRaw, []byte("new content")))
+
+		// The digest is pulled rather than the tag.
+		img, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04")+"@"+digest)
+		must.NoError(t, err)
+		must.Eq(t, digest, img.Digest)
+	})
+
+	t.Run("manifest digest mismatch", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.push(t, "24.04", r.layer(MediaTypeDiskRaw, content))
+
+		// Serve a different manifest for the digest.
+		digest := checksumOf([]byte("manifest"))
+		r.manifests[digest] = r.manifests["24.04"]
+
+		_, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04")+"@"+digest)
+		must.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("layer digest mismatch", func(t *testing.T) {
+		r := newTestRegistry(t)
+		layer := r.layer(MediaTypeDiskQcow2, content)
+		r.blobs[layer.Digest] = []byte("qcow2 disk imagf")
+		r.push(t, "24.04", layer)
+		c := mkTestCache(t)
+
+		_, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrChecksumMismatch)
+		must.FileNotExists(t, c.blobPath(layer.Digest))
+		must.FileNotExists(t, c.blobPath(layer.Digest)+partialSuffix)
+	})
+
+	t.Run("no disk layer", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.push(t, "24.04", r.layer("application/vnd.oci.image.layer.v1.tar", content))
+
+		_, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "must include a single layer")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := newTestRegistry(t)
+
+		_, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "404")
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.token = "secret"
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+
+		img, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+
+		data, err := os.ReadFile(img.Path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("bearer token with credentials", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.token, r.username, r.password = "secret", "nomad", "hunter2"
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+
+		// The token can not be requested anonymously.
+		_, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "401")
+
+		c := mkTestCache(t)
+		c.SetRegistryAuthFile(r.authFile(t, "nomad", "wrong"))
+		_, err = c.Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "401")
+
+		c.SetRegistryAuthFile(r.authFile(t, "nomad", "hunter2"))
+		img, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+
+		data, err := os.ReadFile(img.Path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.basic, r.username, r.password = true, "nomad", "hunter2"
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+
+		_, err := mkTestCache(t).Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "401")
+
+		c := mkTestCache(t)
+		c.SetRegistryAuthFile(r.authFile(t, "nomad", "hunter2"))
+		img, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+
+		data, err := os.ReadFile(img.Path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("invalid auth file", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.token = "secret"
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+
+		path := filepath.Join(t.TempDir(), "config.json")
+		must.NoError(t, os.WriteFile(path, []byte("{"), filePermissions))
+
+		c := mkTestCache(t)
+		c.SetRegistryAuthFile(path)
+		_, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.ErrorIs(t, err, ErrPullFailed)
+		must.ErrorContains(t, err, "failed to decode registry auth file")
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		r := newTestRegistry(t)
+		layer := r.layer(MediaTypeDiskQcow2, content)
+		r.push(t, "24.04", layer)
+		c := mkTestCache(t)
+
+		must.NoError(t, os.MkdirAll(c.Dir(), dirPermissions))
+		must.NoError(t, os.WriteFile(c.blobPath(layer.Digest)+partialSuffix, content[:6], filePermissions))
+
+		img, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+		must.Eq(t, []string{"bytes=6-"}, r.ranges)
+
+		data, err := os.ReadFile(img.Path)
+		must.NoError(t, err)
+		must.Eq(t, content, data)
+	})
+
+	t.Run("registry unavailable", func(t *testing.T) {
+		r := newTestRegistry(t)
+		r.push(t, "24.04", r.layer(MediaTypeDiskQcow2, content))
+		c := mkTestCache(t)
+
+		img, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+
+		r.Close()
+		again, err := c.Pull(t.Context(), r.reference("24.04"))
+		must.NoError(t, err)
+		must.Eq(t, img, again)
+	})
+
+	t.Run("invalid reference", func(t *testing.T) {
+		_, err := mkTestCache(t).Pull(t.Context(), "ubuntu:24.04")
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+	})
+}
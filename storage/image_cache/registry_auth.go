@@ -0,0 +1,86 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package image_cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// registryCredentials are the credentials used to authenticate with an OCI
+// registry.
+type registryCredentials struct {
+	username string
+	password string
+}
+
+// dockerConfig is the subset of a Docker client configuration file which
+// holds the credentials of registries.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// SetRegistryAuthFile sets the path of the Docker client configuration file
+// holding the credentials used to authenticate with OCI registries. When
+// empty, registries are accessed anonymously.
+func (c *Cache) SetRegistryAuthFile(path string) {
+	c.registryAuthFile = path
+}
+
+// registryCredentials returns the credentials of the registry from the
+// registry auth file. The file is read on each call, so updated credentials
+// are used without restarting the driver. Nil is returned when no file is
+// configured or it does not hold credentials for the registry.
+func (c *Cache) registryCredentials(registry string) (*registryCredentials, error) {
+	if c.registryAuthFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(c.registryAuthFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read registry auth file: %w", ErrPullFailed, err)
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode registry auth file %q: %w", ErrPullFailed, c.registryAuthFile, err)
+	}
+
+	for key, auth := range config.Auths {
+		// Entries may be keyed by a URL rather than the registry host, such
+		// as "https://registry.example.com/v1/".
+		host := key
+		if _, rest, found := strings.Cut(host, "://"); found {
+			host = rest
+		}
+		host, _, _ = strings.Cut(host, "/")
+		if host != registry {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return &registryCredentials{username: auth.Username, password: auth.Password}, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("%w: registry auth file has invalid credentials for %q: %w", ErrPullFailed, key, err)
+		}
+		username, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return nil, fmt.Errorf("%w: registry auth file has invalid credentials for %q", ErrPullFailed, key)
+		}
+
+		return &registryCredentials{username: username, password: password}, nil
+	}
+
+	return nil, nil
+}
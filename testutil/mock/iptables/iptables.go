@@ -60,6 +60,13 @@ type DeleteIfExists struct {
 	Err          error
 }
 
+type Exists struct {
+	Table, Chain string
+	RuleSpec     []string
+	Result       bool
+	Err          error
+}
+
 type Insert struct {
 	Table, Chain string
 	Pos          int
@@ -101,6 +108,7 @@ type mockIPTables struct {
 	deletes              []Delete
 	deleteChains         []DeleteChain
 	deleteIfExists       []DeleteIfExists
+	exists               []Exists
 	inserts              []Insert
 	insertUniques        []InsertUnique
 	lists                []List
@@ -130,6 +138,8 @@ func (m *mockIPTables) Expect(calls ...any) *mockIPTables {
 			m.ExpectDeleteChain(c)
 		case DeleteIfExists:
 			m.ExpectDeleteIfExists(c)
+		case Exists:
+			m.ExpectExists(c)
 		case Insert:
 			m.ExpectInsert(c)
 		case InsertUnique:
@@ -220,6 +230,15 @@ func (m *mockIPTables) ExpectDeleteIfExists(del DeleteIfExists) *mockIPTables {
 	return m
 }
 
+// ExpectExists adds an expected Exists call.
+func (m *mockIPTables) ExpectExists(e Exists) *mockIPTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.exists = append(m.exists, e)
+	return m
+}
+
 // ExpectInsert adds an expected Insert call.
 func (m *mockIPTables) ExpectInsert(ins Insert) *mockIPTables {
 	m.m.Lock()
@@ -438,6 +457,29 @@ func (m *mockIPTables) DeleteIfExists(table, chain string, rulespec ...string) e
 	return call.Err
 }
 
+func (m *mockIPTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.exists,
+		must.Sprintf("Unexpected call to Exists - Exists(%q, %q, %q)", table, chain, rulespec))
+	call := m.exists[0]
+	m.exists = m.exists[1:]
+	received := Exists{
+		Table:    table,
+		Chain:    chain,
+		RuleSpec: rulespec,
+		Result:   call.Result,
+		Err:      call.Err,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("Exists received incorrect arguments"))
+
+	return call.Result, call.Err
+}
+
 func (m *mockIPTables) Insert(table, chain string, pos int, rulespec ...string) error {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -567,6 +609,8 @@ func (m *mockIPTables) AssertExpectations() {
 		must.Sprintf("DeleteChain expecting %d more invocations", len(m.deleteChains)))
 	must.SliceEmpty(m.t, m.deleteIfExists,
 		must.Sprintf("DeleteIfExists expecting %d more invocations", len(m.deleteIfExists)))
+	must.SliceEmpty(m.t, m.exists,
+		must.Sprintf("Exists expecting %d more invocations", len(m.exists)))
 	must.SliceEmpty(m.t, m.inserts,
 		must.Sprintf("Insert expecting %d more invocations", len(m.inserts)))
 	must.SliceEmpty(m.t, m.insertUniques,
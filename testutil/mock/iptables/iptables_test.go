@@ -371,6 +371,52 @@ func TestIPTables_DeleteIfExists(t *testing.T) {
 	})
 }
 
+func TestIPTables_Exists(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		iptables := New(t)
+		iptables.ExpectExists(Exists{
+			Table:    "default",
+			Chain:    "default",
+			RuleSpec: []string{"RULE1"},
+			Result:   true,
+		})
+
+		result, err := iptables.Exists("default", "default", "RULE1")
+		must.True(t, result)
+		must.NoError(t, err)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		iptables := New(t)
+		iptables.ExpectExists(Exists{
+			Table: "default",
+			Chain: "default",
+			Err:   mock.MockTestErr,
+		})
+
+		_, err := iptables.Exists("default", "default")
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		iptables := New(mock.MockT())
+		iptables.ExpectExists(Exists{
+			Table: "default",
+			Chain: "default",
+		})
+		defer mock.AssertIncorrectArguments(t, "Exists")
+
+		iptables.Exists("default", "non-default")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		iptables := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Exists")
+
+		iptables.Exists("default", "default")
+	})
+}
+
 func TestIPTables_Insert(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		iptables := New(t)
@@ -600,6 +646,14 @@ func TestIPTables_AssertExpectations(t *testing.T) {
 		iptables.AssertExpectations()
 	})
 
+	t.Run("missing Exists", func(t *testing.T) {
+		iptables := New(mock.MockT())
+		iptables.ExpectExists(Exists{})
+		defer mock.AssertExpectations(t, "Exists")
+
+		iptables.AssertExpectations()
+	})
+
 	t.Run("missing Insert", func(t *testing.T) {
 		iptables := New(mock.MockT())
 		iptables.ExpectInsert(Insert{})
@@ -0,0 +1,104 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package arp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+// New returns a new mock compatible with arp.ARP
+func New(t must.T) *mockARP {
+	return &mockARP{t: t}
+}
+
+type Discover struct {
+	Device string
+	Hwaddr string
+	Result []net.IP
+	Err    error
+}
+
+type mockARP struct {
+	discovers []Discover
+	t         must.T
+	m         sync.Mutex
+}
+
+// Expect adds a list of expected calls.
+func (m *mockARP) Expect(calls ...any) *mockARP {
+	for _, call := range calls {
+		switch c := call.(type) {
+		case Discover:
+			m.ExpectDiscover(c)
+		default:
+			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
+		}
+	}
+
+	return m
+}
+
+// ExpectDiscover adds an expected Discover call.
+func (m *mockARP) ExpectDiscover(d Discover) *mockARP {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.discovers = append(m.discovers, d)
+	return m
+}
+
+// Discover sends the expected results to the returned channel, which is
+// closed once the context is done.
+func (m *mockARP) Discover(ctx context.Context, iface *net.Interface, hwaddr net.HardwareAddr) (<-chan net.IP, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.discovers,
+		must.Sprintf("Unexpected call to Discover - Discover(%q, %q)", iface.Name, hwaddr))
+	call := m.discovers[0]
+	m.discovers = m.discovers[1:]
+	must.Eq(m.t, struct{ Device, Hwaddr string }{call.Device, call.Hwaddr},
+		struct{ Device, Hwaddr string }{iface.Name, hwaddr.String()},
+		must.Sprint("Discover received incorrect arguments"))
+
+	if call.Err != nil {
+		return nil, call.Err
+	}
+
+	ch := make(chan net.IP, len(call.Result))
+	for _, ip := range call.Result {
+		ch <- ip
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (m *mockARP) SetLogger(hclog.Logger) {}
+
+func (m *mockARP) SetContext(context.Context) {}
+
+// AssertExpectations verifies that all expected invocations
+// have been called.
+func (m *mockARP) AssertExpectations() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceEmpty(m.t, m.discovers,
+		must.Sprintf("Discover expecting %d more invocations", len(m.discovers)))
+}
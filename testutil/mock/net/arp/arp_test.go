@@ -0,0 +1,84 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package arp
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/net/arp"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	"github.com/shoenig/test/must"
+)
+
+var (
+	_ arp.ARP = (*mockARP)(nil)
+)
+
+func TestARP_Discover(t *testing.T) {
+	iface := &net.Interface{Name: "eth0"}
+	hwaddr, err := net.ParseMAC("52:54:00:12:34:56")
+	must.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		a := New(t)
+		a.ExpectDiscover(Discover{
+			Device: "eth0",
+			Hwaddr: "52:54:00:12:34:56",
+			Result: []net.IP{net.ParseIP("10.0.0.5")},
+		})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		ch, err := a.Discover(ctx, iface, hwaddr)
+		must.NoError(t, err)
+		must.Eq(t, net.ParseIP("10.0.0.5"), <-ch)
+
+		cancel()
+		_, ok := <-ch
+		must.False(t, ok)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		a := New(t)
+		a.ExpectDiscover(Discover{
+			Device: "eth0",
+			Hwaddr: "52:54:00:12:34:56",
+			Err:    mock.MockTestErr,
+		})
+
+		_, err := a.Discover(t.Context(), iface, hwaddr)
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		a := New(mock.MockT())
+		a.ExpectDiscover(Discover{Device: "eth1", Hwaddr: "52:54:00:12:34:56"})
+		defer mock.AssertIncorrectArguments(t, "Discover")
+
+		a.Discover(t.Context(), iface, hwaddr)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		a := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Discover")
+
+		a.Discover(t.Context(), iface, hwaddr)
+	})
+}
+
+func TestARP_AssertExpectations(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		a := New(t)
+		a.AssertExpectations()
+	})
+
+	t.Run("missing Discover", func(t *testing.T) {
+		a := New(mock.MockT())
+		a.ExpectDiscover(Discover{})
+		defer mock.AssertExpectations(t, "Discover")
+
+		a.AssertExpectations()
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/hashicorp/go-hclog"
+	netfilter "github.com/hashicorp/nomad-driver-virt/net/filter"
 	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
 	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/shoenig/test/must"
@@ -95,9 +96,17 @@ type Teardown struct {
 	Err     error
 }
 
+type Reconcile struct {
+	Removals []*virtnet.FilterRemoval
+	Prune    bool
+	Result   *netfilter.Drift
+	Err      error
+}
+
 type MockFilter struct {
 	configures []Configure
 	teardowns  []Teardown
+	reconciles []Reconcile
 	setLoggers []SetLogger
 	t          must.T
 	m          sync.Mutex
@@ -112,6 +121,8 @@ func (m *MockFilter) Expect(calls ...any) *MockFilter {
 			m.ExpectConfigure(c)
 		case Teardown:
 			m.ExpectTeardown(c)
+		case Reconcile:
+			m.ExpectReconcile(c)
 		case SetLogger:
 			m.ExpectSetLogger(c)
 		default:
@@ -138,6 +149,14 @@ func (m *MockFilter) ExpectTeardown(c Teardown) *MockFilter {
 	return m
 }
 
+func (m *MockFilter) ExpectReconcile(c Reconcile) *MockFilter {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.reconciles = append(m.reconciles, c)
+	return m
+}
+
 func (m *MockFilter) ExpectSetLogger(c SetLogger) *MockFilter {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -153,7 +172,7 @@ func (m *MockFilter) Configure(resources *drivers.Resources, config *virtnet.Net
 	m.t.Helper()
 
 	must.SliceNotEmpty(m.t, m.configures,
-		must.Sprintf("Unexpected call to Configure - Configure(%v, %q, %q)", resources, config, ip))
+		must.Sprintf("Unexpected call to Configure - Configure(%v, %v, %q)", resources, config, ip))
 	call := m.configures[0]
 	m.configures = m.configures[1:]
 
@@ -196,6 +215,28 @@ func (m *MockFilter) Teardown(removal *virtnet.FilterRemoval) error {
 	return call.Err
 }
 
+func (m *MockFilter) Reconcile(removals []*virtnet.FilterRemoval, prune bool) (*netfilter.Drift, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.reconciles,
+		must.Sprintf("Unexpected call to Reconcile - Reconcile(%v, %t)", removals, prune))
+	call := m.reconciles[0]
+	m.reconciles = m.reconciles[1:]
+	received := Reconcile{
+		Removals: removals,
+		Prune:    prune,
+		Result:   call.Result,
+		Err:      call.Err,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("Reconcile received incorrect arguments"))
+
+	return call.Result, call.Err
+}
+
 func (m *MockFilter) SetLogger(hclog.Logger) {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -217,6 +258,8 @@ func (m *MockFilter) AssertExpectations() {
 		must.Sprintf("Configure expecting %d more invocations", len(m.configures)))
 	must.SliceEmpty(m.t, m.teardowns,
 		must.Sprintf("Teardown expecting %d more invocations", len(m.teardowns)))
+	must.SliceEmpty(m.t, m.reconciles,
+		must.Sprintf("Reconcile expecting %d more invocations", len(m.reconciles)))
 	must.SliceEmpty(m.t, m.setLoggers,
 		must.Sprintf("SetLogger expecting %d more invocations", len(m.setLoggers)))
 }
@@ -0,0 +1,92 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package leases
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/shoenig/test/must"
+)
+
+// New returns a new mock compatible with leases.Leases
+func New(t must.T) *mockLeases {
+	return &mockLeases{t: t}
+}
+
+type Watch struct {
+	Bridge  string
+	Changes int
+	Err     error
+}
+
+type mockLeases struct {
+	watches []Watch
+	t       must.T
+	m       sync.Mutex
+}
+
+// Expect adds a list of expected calls.
+func (m *mockLeases) Expect(calls ...any) *mockLeases {
+	for _, call := range calls {
+		switch c := call.(type) {
+		case Watch:
+			m.ExpectWatch(c)
+		default:
+			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
+		}
+	}
+
+	return m
+}
+
+// ExpectWatch adds an expected Watch call.
+func (m *mockLeases) ExpectWatch(w Watch) *mockLeases {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.watches = append(m.watches, w)
+	return m
+}
+
+// Watch returns a channel with the expected number of changes pending.
+func (m *mockLeases) Watch(ctx context.Context, bridge string) (<-chan struct{}, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.watches,
+		must.Sprintf("Unexpected call to Watch - Watch(%q)", bridge))
+	call := m.watches[0]
+	m.watches = m.watches[1:]
+	must.Eq(m.t, call.Bridge, bridge, must.Sprint("Watch received incorrect arguments"))
+
+	if call.Err != nil {
+		return nil, call.Err
+	}
+
+	ch := make(chan struct{}, call.Changes)
+	for range call.Changes {
+		ch <- struct{}{}
+	}
+
+	return ch, nil
+}
+
+func (m *mockLeases) SetLogger(hclog.Logger) {}
+
+// AssertExpectations verifies that all expected invocations
+// have been called.
+func (m *mockLeases) AssertExpectations() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceEmpty(m.t, m.watches,
+		must.Sprintf("Watch expecting %d more invocations", len(m.watches)))
+}
@@ -0,0 +1,65 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package leases
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/net/leases"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	"github.com/shoenig/test/must"
+)
+
+var (
+	_ leases.Leases = (*mockLeases)(nil)
+)
+
+func TestLeases_Watch(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		l := New(t)
+		l.ExpectWatch(Watch{Bridge: "virbr0", Changes: 2})
+
+		ch, err := l.Watch(t.Context(), "virbr0")
+		must.NoError(t, err)
+		must.Eq(t, 2, len(ch))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		l := New(t)
+		l.ExpectWatch(Watch{Bridge: "virbr0", Err: mock.MockTestErr})
+
+		_, err := l.Watch(t.Context(), "virbr0")
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		l := New(mock.MockT())
+		l.ExpectWatch(Watch{Bridge: "virbr1"})
+		defer mock.AssertIncorrectArguments(t, "Watch")
+
+		l.Watch(t.Context(), "virbr0")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		l := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Watch")
+
+		l.Watch(t.Context(), "virbr0")
+	})
+}
+
+func TestLeases_AssertExpectations(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		l := New(t)
+		l.AssertExpectations()
+	})
+
+	t.Run("missing Watch", func(t *testing.T) {
+		l := New(mock.MockT())
+		l.ExpectWatch(Watch{})
+		defer mock.AssertExpectations(t, "Watch")
+
+		l.AssertExpectations()
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package netns
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
+	"github.com/shoenig/test/must"
+)
+
+// New returns a new mock compatible with netns.NetNS
+func New(t must.T) *mockNetNS {
+	return &mockNetNS{t: t}
+}
+
+type Create struct {
+	AllocID string
+	Path    string
+	Created bool
+	Err     error
+}
+
+type Destroy struct {
+	Path string
+	Err  error
+}
+
+type Attach struct {
+	Request *netns.AttachRequest
+	Result  *netns.Attachment
+	Err     error
+}
+
+type Detach struct {
+	HostDevice string
+	Err        error
+}
+
+type mockNetNS struct {
+	creates  []Create
+	destroys []Destroy
+	attaches []Attach
+	detaches []Detach
+	t        must.T
+	m        sync.Mutex
+}
+
+// Expect adds a list of expected calls.
+func (m *mockNetNS) Expect(calls ...any) *mockNetNS {
+	for _, call := range calls {
+		switch c := call.(type) {
+		case Create:
+			m.ExpectCreate(c)
+		case Destroy:
+			m.ExpectDestroy(c)
+		case Attach:
+			m.ExpectAttach(c)
+		case Detach:
+			m.ExpectDetach(c)
+		default:
+			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
+		}
+	}
+
+	return m
+}
+
+// ExpectCreate adds an expected Create call.
+func (m *mockNetNS) ExpectCreate(c Create) *mockNetNS {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.creates = append(m.creates, c)
+	return m
+}
+
+// ExpectDestroy adds an expected Destroy call.
+func (m *mockNetNS) ExpectDestroy(d Destroy) *mockNetNS {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.destroys = append(m.destroys, d)
+	return m
+}
+
+// ExpectAttach adds an expected Attach call.
+func (m *mockNetNS) ExpectAttach(a Attach) *mockNetNS {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.attaches = append(m.attaches, a)
+	return m
+}
+
+// ExpectDetach adds an expected Detach call.
+func (m *mockNetNS) ExpectDetach(d Detach) *mockNetNS {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.detaches = append(m.detaches, d)
+	return m
+}
+
+func (m *mockNetNS) Create(allocID string) (string, bool, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.creates,
+		must.Sprintf("Unexpected call to Create - Create(%q)", allocID))
+	call := m.creates[0]
+	m.creates = m.creates[1:]
+	must.Eq(m.t, call.AllocID, allocID,
+		must.Sprint("Create received incorrect arguments"))
+
+	return call.Path, call.Created, call.Err
+}
+
+func (m *mockNetNS) Destroy(path string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.destroys,
+		must.Sprintf("Unexpected call to Destroy - Destroy(%q)", path))
+	call := m.destroys[0]
+	m.destroys = m.destroys[1:]
+	must.Eq(m.t, call.Path, path,
+		must.Sprint("Destroy received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockNetNS) Attach(req *netns.AttachRequest) (*netns.Attachment, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.attaches,
+		must.Sprintf("Unexpected call to Attach - Attach(%v)", req))
+	call := m.attaches[0]
+	m.attaches = m.attaches[1:]
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, req,
+			must.Sprint("Attach received incorrect arguments"))
+	}
+
+	return call.Result, call.Err
+}
+
+func (m *mockNetNS) Detach(hostDevice string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.detaches,
+		must.Sprintf("Unexpected call to Detach - Detach(%q)", hostDevice))
+	call := m.detaches[0]
+	m.detaches = m.detaches[1:]
+	must.Eq(m.t, call.HostDevice, hostDevice,
+		must.Sprint("Detach received incorrect arguments"))
+
+	return call.Err
+}
+
+// AssertExpectations verifies that all expected invocations
+// have been called.
+func (m *mockNetNS) AssertExpectations() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceEmpty(m.t, m.creates,
+		must.Sprintf("Create expecting %d more invocations", len(m.creates)))
+	must.SliceEmpty(m.t, m.destroys,
+		must.Sprintf("Destroy expecting %d more invocations", len(m.destroys)))
+	must.SliceEmpty(m.t, m.attaches,
+		must.Sprintf("Attach expecting %d more invocations", len(m.attaches)))
+	must.SliceEmpty(m.t, m.detaches,
+		must.Sprintf("Detach expecting %d more invocations", len(m.detaches)))
+}
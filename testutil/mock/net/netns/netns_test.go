@@ -0,0 +1,94 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package netns
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/net/netns"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	"github.com/shoenig/test/must"
+)
+
+var (
+	_ netns.NetNS = (*mockNetNS)(nil)
+)
+
+func TestNetNS_Create(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		n := New(t)
+		n.ExpectCreate(Create{AllocID: "alloc", Path: "/var/run/netns/alloc", Created: true})
+
+		path, created, err := n.Create("alloc")
+		must.NoError(t, err)
+		must.Eq(t, "/var/run/netns/alloc", path)
+		must.True(t, created)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		n := New(t)
+		n.ExpectCreate(Create{AllocID: "alloc", Err: mock.MockTestErr})
+
+		_, _, err := n.Create("alloc")
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		n := New(mock.MockT())
+		n.ExpectCreate(Create{AllocID: "other"})
+		defer mock.AssertIncorrectArguments(t, "Create")
+
+		n.Create("alloc")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		n := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Create")
+
+		n.Create("alloc")
+	})
+}
+
+func TestNetNS_Attach(t *testing.T) {
+	req := &netns.AttachRequest{Name: "vm", Path: "/var/run/netns/alloc", Ports: []netns.Port{{Port: 8080, Protocol: "tcp"}}}
+
+	t.Run("ok", func(t *testing.T) {
+		n := New(t)
+		n.ExpectAttach(Attach{Request: req, Result: &netns.Attachment{HostDevice: "nvt12345678"}})
+
+		result, err := n.Attach(req)
+		must.NoError(t, err)
+		must.Eq(t, "nvt12345678", result.HostDevice)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		n := New(mock.MockT())
+		n.ExpectAttach(Attach{Request: &netns.AttachRequest{Name: "other"}})
+		defer mock.AssertIncorrectArguments(t, "Attach")
+
+		n.Attach(req)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		n := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Attach")
+
+		n.Attach(req)
+	})
+}
+
+func TestNetNS_AssertExpectations(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		n := New(t)
+		n.AssertExpectations()
+	})
+
+	t.Run("not called", func(t *testing.T) {
+		n := New(mock.MockT())
+		n.Expect(Detach{HostDevice: "nvt12345678"})
+		defer mock.AssertExpectations(t, "Detach")
+
+		n.AssertExpectations()
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+)
+
+// New returns a new mock compatible with proxy.Proxy
+func New(t must.T) *mockProxy {
+	return &mockProxy{t: t}
+}
+
+type Start struct {
+	Forward *virtnet.ProxyForward
+	Err     error
+}
+
+type Stop struct {
+	Forward *virtnet.ProxyForward
+	Err     error
+}
+
+type mockProxy struct {
+	starts []Start
+	stops  []Stop
+	t      must.T
+	m      sync.Mutex
+}
+
+// Expect adds a list of expected calls.
+func (m *mockProxy) Expect(calls ...any) *mockProxy {
+	for _, call := range calls {
+		switch c := call.(type) {
+		case Start:
+			m.ExpectStart(c)
+		case Stop:
+			m.ExpectStop(c)
+		default:
+			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
+		}
+	}
+
+	return m
+}
+
+// ExpectStart adds an expected Start call.
+func (m *mockProxy) ExpectStart(s Start) *mockProxy {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.starts = append(m.starts, s)
+	return m
+}
+
+// ExpectStop adds an expected Stop call.
+func (m *mockProxy) ExpectStop(s Stop) *mockProxy {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.stops = append(m.stops, s)
+	return m
+}
+
+func (m *mockProxy) Start(forward *virtnet.ProxyForward) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.starts,
+		must.Sprintf("Unexpected call to Start - Start(%v)", forward))
+	call := m.starts[0]
+	m.starts = m.starts[1:]
+	must.Eq(m.t, call.Forward, forward, must.Sprint("Start received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockProxy) Stop(forward *virtnet.ProxyForward) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.stops,
+		must.Sprintf("Unexpected call to Stop - Stop(%v)", forward))
+	call := m.stops[0]
+	m.stops = m.stops[1:]
+	must.Eq(m.t, call.Forward, forward, must.Sprint("Stop received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockProxy) SetLogger(hclog.Logger) {}
+
+// AssertExpectations verifies that all expected invocations
+// have been called.
+func (m *mockProxy) AssertExpectations() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceEmpty(m.t, m.starts,
+		must.Sprintf("Start expecting %d more invocations", len(m.starts)))
+	must.SliceEmpty(m.t, m.stops,
+		must.Sprintf("Stop expecting %d more invocations", len(m.stops)))
+}
@@ -0,0 +1,106 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/net/proxy"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	virtnet "github.com/hashicorp/nomad-driver-virt/virt/net"
+	"github.com/shoenig/test/must"
+)
+
+var (
+	_ proxy.Proxy = (*mockProxy)(nil)
+)
+
+func testForward() *virtnet.ProxyForward {
+	return &virtnet.ProxyForward{
+		Protocol: virtnet.PortProtocolTCP,
+		Listen:   "127.0.0.1:27494",
+		Target:   "192.168.122.58:22",
+	}
+}
+
+func TestProxy_Start(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		p := New(t)
+		p.ExpectStart(Start{Forward: testForward()})
+		must.NoError(t, p.Start(testForward()))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		p := New(t)
+		p.ExpectStart(Start{Forward: testForward(), Err: mock.MockTestErr})
+		must.ErrorIs(t, p.Start(testForward()), mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		p := New(mock.MockT())
+		p.ExpectStart(Start{Forward: &virtnet.ProxyForward{Listen: "127.0.0.1:1"}})
+		defer mock.AssertIncorrectArguments(t, "Start")
+
+		p.Start(testForward())
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		p := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Start")
+
+		p.Start(testForward())
+	})
+}
+
+func TestProxy_Stop(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		p := New(t)
+		p.ExpectStop(Stop{Forward: testForward()})
+		must.NoError(t, p.Stop(testForward()))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		p := New(t)
+		p.ExpectStop(Stop{Forward: testForward(), Err: mock.MockTestErr})
+		must.ErrorIs(t, p.Stop(testForward()), mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		p := New(mock.MockT())
+		p.ExpectStop(Stop{Forward: &virtnet.ProxyForward{Listen: "127.0.0.1:1"}})
+		defer mock.AssertIncorrectArguments(t, "Stop")
+
+		p.Stop(testForward())
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		p := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Stop")
+
+		p.Stop(testForward())
+	})
+}
+
+func TestProxy_AssertExpectations(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		p := New(t)
+		p.AssertExpectations()
+	})
+
+	t.Run("missing Start", func(t *testing.T) {
+		p := New(mock.MockT())
+		p.ExpectStart(Start{})
+		defer mock.AssertExpectations(t, "Start")
+
+		p.AssertExpectations()
+	})
+
+	t.Run("missing Stop", func(t *testing.T) {
+		p := New(mock.MockT())
+		p.ExpectStop(Stop{})
+		defer mock.AssertExpectations(t, "Stop")
+
+		p.AssertExpectations()
+	})
+}
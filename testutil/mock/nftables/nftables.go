@@ -0,0 +1,356 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/shoenig/test/must"
+)
+
+// New returns a new mock compatible with nftables.NFTables
+func New(t must.T) *mockNFTables {
+	return &mockNFTables{t: t}
+}
+
+type AddTable struct {
+	Name string
+}
+
+type AddChain struct {
+	Name string
+}
+
+type AddSet struct {
+	Name string
+	Err  error
+}
+
+type AddRule struct {
+	Chain string
+
+	// Sets are the names of the sets looked up by the rule, in order. They
+	// are only compared when set.
+	Sets []string
+}
+
+type FlushChain struct {
+	Chain string
+}
+
+type SetAddElements struct {
+	Set      string
+	Elements []nftables.SetElement
+	Err      error
+}
+
+type SetDeleteElements struct {
+	Set      string
+	Elements []nftables.SetElement
+	Err      error
+}
+
+type Flush struct {
+	Err error
+}
+
+type mockNFTables struct {
+	addTables         []AddTable
+	addChains         []AddChain
+	addSets           []AddSet
+	addRules          []AddRule
+	flushChains       []FlushChain
+	setAddElements    []SetAddElements
+	setDeleteElements []SetDeleteElements
+	flushes           []Flush
+	t                 must.T
+	m                 sync.Mutex
+}
+
+// Expect adds a list of expected calls.
+func (m *mockNFTables) Expect(calls ...any) *mockNFTables {
+	for _, call := range calls {
+		switch c := call.(type) {
+		case AddTable:
+			m.ExpectAddTable(c)
+		case AddChain:
+			m.ExpectAddChain(c)
+		case AddSet:
+			m.ExpectAddSet(c)
+		case AddRule:
+			m.ExpectAddRule(c)
+		case FlushChain:
+			m.ExpectFlushChain(c)
+		case SetAddElements:
+			m.ExpectSetAddElements(c)
+		case SetDeleteElements:
+			m.ExpectSetDeleteElements(c)
+		case Flush:
+			m.ExpectFlush(c)
+		default:
+			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
+		}
+	}
+
+	return m
+}
+
+// ExpectAddTable adds an expected AddTable call.
+func (m *mockNFTables) ExpectAddTable(add AddTable) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.addTables = append(m.addTables, add)
+	return m
+}
+
+// ExpectAddChain adds an expected AddChain call.
+func (m *mockNFTables) ExpectAddChain(add AddChain) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.addChains = append(m.addChains, add)
+	return m
+}
+
+// ExpectAddSet adds an expected AddSet call.
+func (m *mockNFTables) ExpectAddSet(add AddSet) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.addSets = append(m.addSets, add)
+	return m
+}
+
+// ExpectAddRule adds an expected AddRule call.
+func (m *mockNFTables) ExpectAddRule(add AddRule) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.addRules = append(m.addRules, add)
+	return m
+}
+
+// ExpectFlushChain adds an expected FlushChain call.
+func (m *mockNFTables) ExpectFlushChain(flush FlushChain) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.flushChains = append(m.flushChains, flush)
+	return m
+}
+
+// ExpectSetAddElements adds an expected SetAddElements call.
+func (m *mockNFTables) ExpectSetAddElements(add SetAddElements) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.setAddElements = append(m.setAddElements, add)
+	return m
+}
+
+// ExpectSetDeleteElements adds an expected SetDeleteElements call.
+func (m *mockNFTables) ExpectSetDeleteElements(del SetDeleteElements) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.setDeleteElements = append(m.setDeleteElements, del)
+	return m
+}
+
+// ExpectFlush adds an expected Flush call.
+func (m *mockNFTables) ExpectFlush(flush Flush) *mockNFTables {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.flushes = append(m.flushes, flush)
+	return m
+}
+
+func (m *mockNFTables) AddTable(t *nftables.Table) *nftables.Table {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.addTables,
+		must.Sprintf("Unexpected call to AddTable - AddTable(%q)", t.Name))
+	call := m.addTables[0]
+	m.addTables = m.addTables[1:]
+	received := AddTable{
+		Name: t.Name,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("AddTable received incorrect arguments"))
+
+	return t
+}
+
+func (m *mockNFTables) AddChain(c *nftables.Chain) *nftables.Chain {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.addChains,
+		must.Sprintf("Unexpected call to AddChain - AddChain(%q)", c.Name))
+	call := m.addChains[0]
+	m.addChains = m.addChains[1:]
+	received := AddChain{
+		Name: c.Name,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("AddChain received incorrect arguments"))
+
+	return c
+}
+
+func (m *mockNFTables) AddSet(s *nftables.Set, vals []nftables.SetElement) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.addSets,
+		must.Sprintf("Unexpected call to AddSet - AddSet(%q)", s.Name))
+	call := m.addSets[0]
+	m.addSets = m.addSets[1:]
+	received := AddSet{
+		Name: s.Name,
+		Err:  call.Err,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("AddSet received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockNFTables) AddRule(r *nftables.Rule) *nftables.Rule {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.addRules,
+		must.Sprintf("Unexpected call to AddRule - AddRule(%q)", r.Chain.Name))
+	call := m.addRules[0]
+	m.addRules = m.addRules[1:]
+	received := AddRule{
+		Chain: r.Chain.Name,
+	}
+	if call.Sets != nil {
+		received.Sets = []string{}
+		for _, e := range r.Exprs {
+			if lookup, ok := e.(*expr.Lookup); ok {
+				received.Sets = append(received.Sets, lookup.SetName)
+			}
+		}
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("AddRule received incorrect arguments"))
+
+	return r
+}
+
+func (m *mockNFTables) FlushChain(c *nftables.Chain) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.flushChains,
+		must.Sprintf("Unexpected call to FlushChain - FlushChain(%q)", c.Name))
+	call := m.flushChains[0]
+	m.flushChains = m.flushChains[1:]
+	received := FlushChain{
+		Chain: c.Name,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("FlushChain received incorrect arguments"))
+}
+
+func (m *mockNFTables) SetAddElements(s *nftables.Set, vals []nftables.SetElement) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.setAddElements,
+		must.Sprintf("Unexpected call to SetAddElements - SetAddElements(%q, %v)", s.Name, vals))
+	call := m.setAddElements[0]
+	m.setAddElements = m.setAddElements[1:]
+	received := SetAddElements{
+		Set:      s.Name,
+		Elements: vals,
+		Err:      call.Err,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("SetAddElements received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockNFTables) SetDeleteElements(s *nftables.Set, vals []nftables.SetElement) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.setDeleteElements,
+		must.Sprintf("Unexpected call to SetDeleteElements - SetDeleteElements(%q, %v)", s.Name, vals))
+	call := m.setDeleteElements[0]
+	m.setDeleteElements = m.setDeleteElements[1:]
+	received := SetDeleteElements{
+		Set:      s.Name,
+		Elements: vals,
+		Err:      call.Err,
+	}
+	must.Eq(m.t, call, received,
+		must.Sprint("SetDeleteElements received incorrect arguments"))
+
+	return call.Err
+}
+
+func (m *mockNFTables) Flush() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.flushes,
+		must.Sprint("Unexpected call to Flush - Flush()"))
+	call := m.flushes[0]
+	m.flushes = m.flushes[1:]
+
+	return call.Err
+}
+
+// AssertExpectations verifies that all expected invocations
+// have been called.
+func (m *mockNFTables) AssertExpectations() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceEmpty(m.t, m.addTables,
+		must.Sprintf("AddTable expecting %d more invocations", len(m.addTables)))
+	must.SliceEmpty(m.t, m.addChains,
+		must.Sprintf("AddChain expecting %d more invocations", len(m.addChains)))
+	must.SliceEmpty(m.t, m.addSets,
+		must.Sprintf("AddSet expecting %d more invocations", len(m.addSets)))
+	must.SliceEmpty(m.t, m.addRules,
+		must.Sprintf("AddRule expecting %d more invocations", len(m.addRules)))
+	must.SliceEmpty(m.t, m.flushChains,
+		must.Sprintf("FlushChain expecting %d more invocations", len(m.flushChains)))
+	must.SliceEmpty(m.t, m.setAddElements,
+		must.Sprintf("SetAddElements expecting %d more invocations", len(m.setAddElements)))
+	must.SliceEmpty(m.t, m.setDeleteElements,
+		must.Sprintf("SetDeleteElements expecting %d more invocations", len(m.setDeleteElements)))
+	must.SliceEmpty(m.t, m.flushes,
+		must.Sprintf("Flush expecting %d more invocations", len(m.flushes)))
+}
@@ -0,0 +1,119 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package nftables
+
+import (
+	"testing"
+
+	gnft "github.com/google/nftables"
+	"github.com/hashicorp/nomad-driver-virt/net/filter/nftables"
+	"github.com/hashicorp/nomad-driver-virt/testutil/mock"
+	"github.com/shoenig/test/must"
+)
+
+var (
+	_ nftables.NFTables = (*mockNFTables)(nil)
+)
+
+func TestNFTables_SetAddElements(t *testing.T) {
+	set := &gnft.Set{Name: "test"}
+	elems := []gnft.SetElement{{Key: []byte{1, 2, 3, 4}}}
+
+	t.Run("ok", func(t *testing.T) {
+		nft := New(t)
+		nft.ExpectSetAddElements(SetAddElements{Set: "test", Elements: elems})
+
+		err := nft.SetAddElements(set, elems)
+		must.NoError(t, err)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		nft := New(t)
+		nft.ExpectSetAddElements(SetAddElements{Set: "test", Elements: elems, Err: mock.MockTestErr})
+
+		err := nft.SetAddElements(set, elems)
+		must.ErrorIs(t, err, mock.MockTestErr)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		nft := New(mock.MockT())
+		nft.ExpectSetAddElements(SetAddElements{Set: "other", Elements: elems})
+		defer mock.AssertIncorrectArguments(t, "SetAddElements")
+
+		nft.SetAddElements(set, elems)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		nft := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "SetAddElements")
+
+		nft.SetAddElements(set, elems)
+	})
+}
+
+func TestNFTables_SetDeleteElements(t *testing.T) {
+	set := &gnft.Set{Name: "test"}
+	elems := []gnft.SetElement{{Key: []byte{1, 2, 3, 4}}}
+
+	t.Run("ok", func(t *testing.T) {
+		nft := New(t)
+		nft.ExpectSetDeleteElements(SetDeleteElements{Set: "test", Elements: elems})
+
+		err := nft.SetDeleteElements(set, elems)
+		must.NoError(t, err)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		nft := New(mock.MockT())
+		nft.ExpectSetDeleteElements(SetDeleteElements{Set: "other", Elements: elems})
+		defer mock.AssertIncorrectArguments(t, "SetDeleteElements")
+
+		nft.SetDeleteElements(set, elems)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		nft := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "SetDeleteElements")
+
+		nft.SetDeleteElements(set, elems)
+	})
+}
+
+func TestNFTables_Flush(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		nft := New(t)
+		nft.ExpectFlush(Flush{})
+
+		must.NoError(t, nft.Flush())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		nft := New(t)
+		nft.ExpectFlush(Flush{Err: mock.MockTestErr})
+
+		must.ErrorIs(t, nft.Flush(), mock.MockTestErr)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		nft := New(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Flush")
+
+		nft.Flush()
+	})
+}
+
+func TestNFTables_AssertExpectations(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		nft := New(t)
+		nft.AssertExpectations()
+	})
+
+	t.Run("not called", func(t *testing.T) {
+		nft := New(mock.MockT())
+		nft.Expect(Flush{})
+		defer mock.AssertExpectations(t, "Flush")
+
+		nft.AssertExpectations()
+	})
+}
@@ -22,9 +22,23 @@ type LookupNetworkByName struct {
 	Err    error
 }
 
+type NetworkCreateXML struct {
+	XML    string
+	Result shims.ConnectNetwork
+	Err    error
+}
+
+type LookupDomainByName struct {
+	Name   string
+	Result shims.ConnectDomain
+	Err    error
+}
+
 type MockConnect struct {
 	listNetworks         []ListNetworks
 	lookupNetworkByNames []LookupNetworkByName
+	networkCreateXMLs    []NetworkCreateXML
+	lookupDomainByNames  []LookupDomainByName
 	t                    must.T
 	m                    sync.Mutex
 }
@@ -42,6 +56,10 @@ func (m *MockConnect) Expect(calls ...any) *MockConnect {
 			m.ExpectListNetworks(c)
 		case LookupNetworkByName:
 			m.ExpectLookupNetworkByName(c)
+		case NetworkCreateXML:
+			m.ExpectNetworkCreateXML(c)
+		case LookupDomainByName:
+			m.ExpectLookupDomainByName(c)
 		default:
 			panic(fmt.Sprintf("unsupported type for mock expectation: %T", c))
 		}
@@ -68,6 +86,24 @@ func (m *MockConnect) ExpectLookupNetworkByName(lookup LookupNetworkByName) *Moc
 	return m
 }
 
+// ExpectNetworkCreateXML adds an expected NetworkCreateXML call.
+func (m *MockConnect) ExpectNetworkCreateXML(create NetworkCreateXML) *MockConnect {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.networkCreateXMLs = append(m.networkCreateXMLs, create)
+	return m
+}
+
+// ExpectLookupDomainByName adds an expected LookupDomainByName call.
+func (m *MockConnect) ExpectLookupDomainByName(lookup LookupDomainByName) *MockConnect {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.lookupDomainByNames = append(m.lookupDomainByNames, lookup)
+	return m
+}
+
 func (m *MockConnect) ListNetworks() ([]string, error) {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -99,6 +135,40 @@ func (m *MockConnect) LookupNetworkByName(name string) (shims.ConnectNetwork, er
 	return call.Result, call.Err
 }
 
+func (m *MockConnect) NetworkCreateXML(xmlConfig string) (shims.ConnectNetwork, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.networkCreateXMLs,
+		must.Sprintf("Unexpected call to NetworkCreateXML - NetworkCreateXML(%q)", xmlConfig))
+	call := m.networkCreateXMLs[0]
+	m.networkCreateXMLs = m.networkCreateXMLs[1:]
+
+	must.Eq(m.t, struct{ XML string }{call.XML}, struct{ XML string }{xmlConfig},
+		must.Sprint("NetworkCreateXML received incorrect arguments"))
+
+	return call.Result, call.Err
+}
+
+func (m *MockConnect) LookupDomainByName(name string) (shims.ConnectDomain, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.lookupDomainByNames,
+		must.Sprintf("Unexpected call to LookupDomainByName - LookupDomainByName(%q)", name))
+	call := m.lookupDomainByNames[0]
+	m.lookupDomainByNames = m.lookupDomainByNames[1:]
+
+	must.Eq(m.t, struct{ Name string }{call.Name}, struct{ Name string }{name},
+		must.Sprint("LookupDomainByName received incorrect arguments"))
+
+	return call.Result, call.Err
+}
+
 // AssertExpectations verifies that all expected invocations
 // have been called.
 func (m *MockConnect) AssertExpectations() {
@@ -111,6 +181,10 @@ func (m *MockConnect) AssertExpectations() {
 		must.Sprintf("ListNetworks expecting %d more invocations", len(m.listNetworks)))
 	must.SliceEmpty(m.t, m.lookupNetworkByNames,
 		must.Sprintf("LookupNetworkByName expecting %d more invocations", len(m.lookupNetworkByNames)))
+	must.SliceEmpty(m.t, m.networkCreateXMLs,
+		must.Sprintf("NetworkCreateXML expecting %d more invocations", len(m.networkCreateXMLs)))
+	must.SliceEmpty(m.t, m.lookupDomainByNames,
+		must.Sprintf("LookupDomainByName expecting %d more invocations", len(m.lookupDomainByNames)))
 }
 
 var _ shims.Connect = (*MockConnect)(nil)
@@ -84,6 +84,94 @@ func TestConnect_LookupNetworkByName(t *testing.T) {
 	})
 }
 
+func TestConnect_NetworkCreateXML(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		network := NewNetwork(t)
+		connect := NewConnect(t)
+		connect.ExpectNetworkCreateXML(NetworkCreateXML{
+			XML:    "<network/>",
+			Result: network,
+		})
+
+		net, err := connect.NetworkCreateXML("<network/>")
+		must.NoError(t, err)
+		must.Eq(t, shims.ConnectNetwork(network), net)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		connect := NewConnect(t)
+		connect.ExpectNetworkCreateXML(NetworkCreateXML{
+			XML: "<network/>",
+			Err: mock.MockTestErr,
+		})
+
+		net, err := connect.NetworkCreateXML("<network/>")
+		must.ErrorIs(t, err, mock.MockTestErr)
+		must.Nil(t, net)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		connect.ExpectNetworkCreateXML(NetworkCreateXML{
+			XML: "<network/>",
+		})
+		defer mock.AssertIncorrectArguments(t, "NetworkCreateXML")
+
+		connect.NetworkCreateXML("<domain/>")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "NetworkCreateXML")
+
+		connect.NetworkCreateXML("<network/>")
+	})
+}
+
+func TestConnect_LookupDomainByName(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		domain := &StaticDomain{}
+		connect := NewConnect(t)
+		connect.ExpectLookupDomainByName(LookupDomainByName{
+			Name:   "test-vm",
+			Result: domain,
+		})
+
+		dom, err := connect.LookupDomainByName("test-vm")
+		must.NoError(t, err)
+		must.Eq(t, shims.ConnectDomain(domain), dom)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		connect := NewConnect(t)
+		connect.ExpectLookupDomainByName(LookupDomainByName{
+			Name: "test-vm",
+			Err:  mock.MockTestErr,
+		})
+
+		dom, err := connect.LookupDomainByName("test-vm")
+		must.ErrorIs(t, err, mock.MockTestErr)
+		must.Nil(t, dom)
+	})
+
+	t.Run("incorrect arguments", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		connect.ExpectLookupDomainByName(LookupDomainByName{
+			Name: "test-vm",
+		})
+		defer mock.AssertIncorrectArguments(t, "LookupDomainByName")
+
+		connect.LookupDomainByName("not-test-vm")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "LookupDomainByName")
+
+		connect.LookupDomainByName("test-vm")
+	})
+}
+
 func TestConnect_AssertExpectations(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		net := NewConnect(t)
@@ -105,4 +193,20 @@ func TestConnect_AssertExpectations(t *testing.T) {
 
 		connect.AssertExpectations()
 	})
+
+	t.Run("missing NetworkCreateXML", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		connect.ExpectNetworkCreateXML(NetworkCreateXML{})
+		defer mock.AssertExpectations(t, "NetworkCreateXML")
+
+		connect.AssertExpectations()
+	})
+
+	t.Run("missing LookupDomainByName", func(t *testing.T) {
+		connect := NewConnect(mock.MockT())
+		connect.ExpectLookupDomainByName(LookupDomainByName{})
+		defer mock.AssertExpectations(t, "LookupDomainByName")
+
+		connect.AssertExpectations()
+	})
 }
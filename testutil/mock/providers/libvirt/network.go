@@ -45,6 +45,10 @@ type GetXMLDesc struct {
 	Err    error
 }
 
+type Destroy struct {
+	Err error
+}
+
 type Free struct {
 	Err error
 }
@@ -55,6 +59,7 @@ type MockNetwork struct {
 	getDHCPLeases  []GetDHCPLeases
 	updates        []Update
 	getXMLDescs    []GetXMLDesc
+	destroys       []Destroy
 	free           []Free
 	t              must.T
 	m              sync.Mutex
@@ -79,6 +84,8 @@ func (m *MockNetwork) Expect(calls ...any) *MockNetwork {
 			m.ExpectUpdate(c)
 		case GetXMLDesc:
 			m.ExpectGetXMLDesc(c)
+		case Destroy:
+			m.ExpectDestroy(c)
 		case Free:
 			m.ExpectFree(c)
 		default:
@@ -134,6 +141,15 @@ func (m *MockNetwork) ExpectGetXMLDesc(get GetXMLDesc) *MockNetwork {
 	return m
 }
 
+// ExpectDestroy adds an expected Destroy call.
+func (m *MockNetwork) ExpectDestroy(destroy Destroy) *MockNetwork {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.destroys = append(m.destroys, destroy)
+	return m
+}
+
 func (m *MockNetwork) ExpectFree(free Free) *MockNetwork {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -224,6 +240,20 @@ func (m *MockNetwork) GetXMLDesc(flags libvirt.NetworkXMLFlags) (string, error)
 	return call.Result, call.Err
 }
 
+func (m *MockNetwork) Destroy() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.destroys,
+		must.Sprint("Unexpected call to Destroy"))
+	call := m.destroys[0]
+	m.destroys = m.destroys[1:]
+
+	return call.Err
+}
+
 func (m *MockNetwork) Free() error {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -256,6 +286,8 @@ func (m *MockNetwork) AssertExpectations() {
 		must.Sprintf("Update expecting %d more invocations", len(m.updates)))
 	must.SliceEmpty(m.t, m.getXMLDescs,
 		must.Sprintf("GetXMLDesc expecting %d more invocations", len(m.getXMLDescs)))
+	must.SliceEmpty(m.t, m.destroys,
+		must.Sprintf("Destroy expecting %d more invocations", len(m.destroys)))
 	must.SliceEmpty(m.t, m.free,
 		must.Sprintf("Free expecting %d more invocations", len(m.free)))
 }
@@ -177,6 +177,29 @@ func TestNetwork_GetXMLDesc(t *testing.T) {
 	})
 }
 
+func TestNetwork_Destroy(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		net := NewNetwork(t)
+		net.ExpectDestroy(Destroy{})
+
+		must.NoError(t, net.Destroy())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		net := NewNetwork(t)
+		net.ExpectDestroy(Destroy{Err: mock.MockTestErr})
+
+		must.ErrorIs(t, net.Destroy(), mock.MockTestErr)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		net := NewNetwork(mock.MockT())
+		defer mock.AssertUnexpectedCall(t, "Destroy")
+
+		net.Destroy()
+	})
+}
+
 func TestNetwork_AssertExpectations(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		net := NewNetwork(t)
@@ -222,4 +245,12 @@ func TestNetwork_AssertExpectations(t *testing.T) {
 
 		net.AssertExpectations()
 	})
+
+	t.Run("missing Destroy", func(t *testing.T) {
+		net := NewNetwork(mock.MockT())
+		net.ExpectDestroy(Destroy{})
+		defer mock.AssertExpectations(t, "Destroy")
+
+		net.AssertExpectations()
+	})
 }
@@ -4,6 +4,7 @@
 package libvirt
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -101,12 +102,32 @@ func (cm *StaticConnect) LookupNetworkByName(name string) (shims.ConnectNetwork,
 			Active:     false,
 			BridgeName: "br0",
 			DhcpLeases: []libvirt.NetworkDHCPLease{},
+			XmlDesc: `<network>
+  <name>routed</name>
+  <uuid>5c9e4e2b-0b5f-4a3c-9f0e-2f4d0c6a8b71</uuid>
+  <forward mode='route'/>
+  <bridge name='br0' stp='on' delay='0'/>
+  <ip address='10.10.0.1' prefix='24'/>
+  <ip family='ipv6' address='fd00:10::1' prefix='64'>
+    <dhcp>
+      <range start='fd00:10::100' end='fd00:10::1ff'/>
+    </dhcp>
+  </ip>
+</network>`,
 		}, nil
 	default:
 		return nil, fmt.Errorf("unknown network: %q", name)
 	}
 }
 
+func (cm *StaticConnect) NetworkCreateXML(xmlConfig string) (shims.ConnectNetwork, error) {
+	return nil, errors.New("network creation not supported")
+}
+
+func (cm *StaticConnect) LookupDomainByName(name string) (shims.ConnectDomain, error) {
+	return nil, fmt.Errorf("unknown domain: %q", name)
+}
+
 // ConnectEmpty is a secondary mock that can be used to mimic a host where
 // no libvirt networks or other resources are available. It implements the
 // ConnectShim interface.
@@ -120,6 +141,14 @@ func (cme *ConnectEmpty) LookupNetworkByName(name string) (shims.ConnectNetwork,
 	return nil, fmt.Errorf("unknown network: %q", name)
 }
 
+func (cme *ConnectEmpty) NetworkCreateXML(xmlConfig string) (shims.ConnectNetwork, error) {
+	return nil, errors.New("network creation not supported")
+}
+
+func (cme *ConnectEmpty) LookupDomainByName(name string) (shims.ConnectDomain, error) {
+	return nil, fmt.Errorf("unknown domain: %q", name)
+}
+
 // StaticNetwork implements the shim.Network interface for testing.
 type StaticNetwork struct {
 	Name       string
@@ -145,8 +174,28 @@ func (cnm *StaticNetwork) Update(cmd libvirt.NetworkUpdateCommand, section libvi
 	return nil
 }
 
+func (cnm *StaticNetwork) Destroy() error {
+	return nil
+}
+
 func (cnm *StaticNetwork) Free() error {
 	return nil
 }
 
 var _ shims.ConnectNetwork = (*StaticNetwork)(nil)
+
+// StaticDomain implements the shim.ConnectDomain interface for testing.
+type StaticDomain struct {
+	Interfaces []libvirt.DomainInterface
+	Err        error
+}
+
+func (cdm *StaticDomain) ListAllInterfaceAddresses(src libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error) {
+	return cdm.Interfaces, cdm.Err
+}
+
+func (cdm *StaticDomain) Free() error {
+	return nil
+}
+
+var _ shims.ConnectDomain = (*StaticDomain)(nil)
@@ -26,24 +26,59 @@ type Fingerprint struct {
 	AttrsFn func(map[string]*structs.Attribute) // Allows for modifications
 }
 
+type VMIsolationBuild struct {
+	Request *net.VMIsolationBuildRequest
+	Result  *net.VMIsolationBuildResponse
+	Err     error
+}
+
+type VMNetworksBuild struct {
+	Request *net.VMNetworksBuildRequest
+	Result  *net.VMNetworksBuildResponse
+	Err     error
+}
+
+type VMAddressingBuild struct {
+	Request *net.VMAddressingBuildRequest
+	Result  *net.VMAddressingBuildResponse
+	Err     error
+}
+
 type VMStartedBuild struct {
 	Request *net.VMStartedBuildRequest
 	Result  *net.VMStartedBuildResponse
 	Err     error
 }
 
+type VMRecoveredBuild struct {
+	Request *net.VMRecoveredBuildRequest
+	Result  *net.VMRecoveredBuildResponse
+	Err     error
+}
+
 type VMTerminatedTeardown struct {
 	Request *net.VMTerminatedTeardownRequest
 	Result  *net.VMTerminatedTeardownResponse
 	Err     error
 }
 
+type FilterReconcile struct {
+	Request *net.FilterReconcileRequest
+	Result  *net.FilterReconcileResponse
+	Err     error
+}
+
 type MockNet struct {
 	t                    must.T
 	init                 []Init
 	fingerprint          []Fingerprint
+	vmIsolationBuild     []VMIsolationBuild
+	vmNetworksBuild      []VMNetworksBuild
+	vmAddressingBuild    []VMAddressingBuild
 	vmStartedBuild       []VMStartedBuild
+	vmRecoveredBuild     []VMRecoveredBuild
 	vmTerminatedTeardown []VMTerminatedTeardown
+	filterReconcile      []FilterReconcile
 	m                    sync.Mutex
 }
 
@@ -54,10 +89,20 @@ func (m *MockNet) Expect(calls ...any) *MockNet {
 			m.ExpectInit(c)
 		case Fingerprint:
 			m.ExpectFingerprint(c)
+		case VMIsolationBuild:
+			m.ExpectVMIsolationBuild(c)
+		case VMNetworksBuild:
+			m.ExpectVMNetworksBuild(c)
+		case VMAddressingBuild:
+			m.ExpectVMAddressingBuild(c)
 		case VMStartedBuild:
 			m.ExpectVMStartedBuild(c)
+		case VMRecoveredBuild:
+			m.ExpectVMRecoveredBuild(c)
 		case VMTerminatedTeardown:
 			m.ExpectVMTerminatedTeardown(c)
+		case FilterReconcile:
+			m.ExpectFilterReconcile(c)
 		default:
 			m.t.Fatalf("unsupported type for mock expectation: %T", c)
 		}
@@ -82,6 +127,30 @@ func (m *MockNet) ExpectFingerprint(c Fingerprint) *MockNet {
 	return m
 }
 
+func (m *MockNet) ExpectVMIsolationBuild(c VMIsolationBuild) *MockNet {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.vmIsolationBuild = append(m.vmIsolationBuild, c)
+	return m
+}
+
+func (m *MockNet) ExpectVMNetworksBuild(c VMNetworksBuild) *MockNet {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.vmNetworksBuild = append(m.vmNetworksBuild, c)
+	return m
+}
+
+func (m *MockNet) ExpectVMAddressingBuild(c VMAddressingBuild) *MockNet {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.vmAddressingBuild = append(m.vmAddressingBuild, c)
+	return m
+}
+
 func (m *MockNet) ExpectVMStartedBuild(c VMStartedBuild) *MockNet {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -90,6 +159,14 @@ func (m *MockNet) ExpectVMStartedBuild(c VMStartedBuild) *MockNet {
 	return m
 }
 
+func (m *MockNet) ExpectVMRecoveredBuild(c VMRecoveredBuild) *MockNet {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.vmRecoveredBuild = append(m.vmRecoveredBuild, c)
+	return m
+}
+
 func (m *MockNet) ExpectVMTerminatedTeardown(c VMTerminatedTeardown) *MockNet {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -98,6 +175,14 @@ func (m *MockNet) ExpectVMTerminatedTeardown(c VMTerminatedTeardown) *MockNet {
 	return m
 }
 
+func (m *MockNet) ExpectFilterReconcile(c FilterReconcile) *MockNet {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.filterReconcile = append(m.filterReconcile, c)
+	return m
+}
+
 func (m *MockNet) Init() error {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -147,6 +232,66 @@ func (m *MockNet) Fingerprint(attrs map[string]*structs.Attribute) {
 	}
 }
 
+func (m *MockNet) VMIsolationBuild(request *net.VMIsolationBuildRequest) (*net.VMIsolationBuildResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.vmIsolationBuild,
+		must.Sprint("Unexpected call to VMIsolationBuild"))
+	call := m.vmIsolationBuild[0]
+	m.vmIsolationBuild = m.vmIsolationBuild[1:]
+
+	must.NotNil(m.t, request, must.Sprint("VMIsolationBuild received incorrect argument"))
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, request,
+			must.Sprint("VMIsolationBuild request does not match expected"))
+	}
+
+	return call.Result, call.Err
+}
+
+func (m *MockNet) VMNetworksBuild(request *net.VMNetworksBuildRequest) (*net.VMNetworksBuildResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.vmNetworksBuild,
+		must.Sprint("Unexpected call to VMNetworksBuild"))
+	call := m.vmNetworksBuild[0]
+	m.vmNetworksBuild = m.vmNetworksBuild[1:]
+
+	must.NotNil(m.t, request, must.Sprint("VMNetworksBuild received incorrect argument"))
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, request,
+			must.Sprint("VMNetworksBuild request does not match expected"))
+	}
+
+	return call.Result, call.Err
+}
+
+func (m *MockNet) VMAddressingBuild(request *net.VMAddressingBuildRequest) (*net.VMAddressingBuildResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.vmAddressingBuild,
+		must.Sprint("Unexpected call to VMAddressingBuild"))
+	call := m.vmAddressingBuild[0]
+	m.vmAddressingBuild = m.vmAddressingBuild[1:]
+
+	must.NotNil(m.t, request, must.Sprint("VMAddressingBuild received incorrect argument"))
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, request,
+			must.Sprint("VMAddressingBuild request does not match expected"))
+	}
+
+	return call.Result, call.Err
+}
+
 func (m *MockNet) VMStartedBuild(request *net.VMStartedBuildRequest) (*net.VMStartedBuildResponse, error) {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -167,6 +312,26 @@ func (m *MockNet) VMStartedBuild(request *net.VMStartedBuildRequest) (*net.VMSta
 	return call.Result, call.Err
 }
 
+func (m *MockNet) VMRecoveredBuild(request *net.VMRecoveredBuildRequest) (*net.VMRecoveredBuildResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.vmRecoveredBuild,
+		must.Sprint("Unexpected call to VMRecoveredBuild"))
+	call := m.vmRecoveredBuild[0]
+	m.vmRecoveredBuild = m.vmRecoveredBuild[1:]
+
+	must.NotNil(m.t, request, must.Sprint("VMRecoveredBuild received incorrect argument"))
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, request,
+			must.Sprint("VMRecoveredBuild request does not match expected"))
+	}
+
+	return call.Result, call.Err
+}
+
 func (m *MockNet) VMTerminatedTeardown(request *net.VMTerminatedTeardownRequest) (*net.VMTerminatedTeardownResponse, error) {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -186,3 +351,23 @@ func (m *MockNet) VMTerminatedTeardown(request *net.VMTerminatedTeardownRequest)
 
 	return call.Result, call.Err
 }
+
+func (m *MockNet) FilterReconcile(request *net.FilterReconcileRequest) (*net.FilterReconcileResponse, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.t.Helper()
+
+	must.SliceNotEmpty(m.t, m.filterReconcile,
+		must.Sprint("Unexpected call to FilterReconcile"))
+	call := m.filterReconcile[0]
+	m.filterReconcile = m.filterReconcile[1:]
+
+	must.NotNil(m.t, request, must.Sprint("FilterReconcile received incorrect argument"))
+	if call.Request != nil {
+		must.Eq(m.t, call.Request, request,
+			must.Sprint("FilterReconcile request does not match expected"))
+	}
+
+	return call.Result, call.Err
+}
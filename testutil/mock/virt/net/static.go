@@ -19,8 +19,13 @@ func NewStatic() *StaticNet {
 
 type StaticNet struct {
 	FingerprintResult          map[string]*structs.Attribute // This value will be copied into received attrs
+	VMIsolationBuildResult     *net.VMIsolationBuildResponse
+	VMNetworksBuildResult      *net.VMNetworksBuildResponse
+	VMAddressingBuildResult    *net.VMAddressingBuildResponse
 	VMStartedBuildResult       *net.VMStartedBuildResponse
+	VMRecoveredBuildResult     *net.VMRecoveredBuildResponse
 	VMTerminatedTeardownResult *net.VMTerminatedTeardownResponse
+	FilterReconcileResult      *net.FilterReconcileResponse
 
 	counts map[string]int
 	m      sync.Mutex
@@ -76,6 +81,42 @@ func (s *StaticNet) Fingerprint(attrs map[string]*structs.Attribute) {
 	maps.Copy(attrs, s.FingerprintResult)
 }
 
+func (s *StaticNet) VMIsolationBuild(*net.VMIsolationBuildRequest) (*net.VMIsolationBuildResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.incrCount()
+
+	if s.VMIsolationBuildResult != nil {
+		return s.VMIsolationBuildResult, nil
+	}
+
+	return &net.VMIsolationBuildResponse{}, nil
+}
+
+func (s *StaticNet) VMNetworksBuild(*net.VMNetworksBuildRequest) (*net.VMNetworksBuildResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.incrCount()
+
+	if s.VMNetworksBuildResult != nil {
+		return s.VMNetworksBuildResult, nil
+	}
+
+	return &net.VMNetworksBuildResponse{}, nil
+}
+
+func (s *StaticNet) VMAddressingBuild(*net.VMAddressingBuildRequest) (*net.VMAddressingBuildResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.incrCount()
+
+	if s.VMAddressingBuildResult != nil {
+		return s.VMAddressingBuildResult, nil
+	}
+
+	return &net.VMAddressingBuildResponse{}, nil
+}
+
 func (s *StaticNet) VMStartedBuild(*net.VMStartedBuildRequest) (*net.VMStartedBuildResponse, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
@@ -88,6 +129,18 @@ func (s *StaticNet) VMStartedBuild(*net.VMStartedBuildRequest) (*net.VMStartedBu
 	return &net.VMStartedBuildResponse{}, nil
 }
 
+func (s *StaticNet) VMRecoveredBuild(*net.VMRecoveredBuildRequest) (*net.VMRecoveredBuildResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.incrCount()
+
+	if s.VMRecoveredBuildResult != nil {
+		return s.VMRecoveredBuildResult, nil
+	}
+
+	return &net.VMRecoveredBuildResponse{}, nil
+}
+
 func (s *StaticNet) VMTerminatedTeardown(*net.VMTerminatedTeardownRequest) (*net.VMTerminatedTeardownResponse, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
@@ -99,3 +152,15 @@ func (s *StaticNet) VMTerminatedTeardown(*net.VMTerminatedTeardownRequest) (*net
 
 	return &net.VMTerminatedTeardownResponse{}, nil
 }
+
+func (s *StaticNet) FilterReconcile(*net.FilterReconcileRequest) (*net.FilterReconcileResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.incrCount()
+
+	if s.FilterReconcileResult != nil {
+		return s.FilterReconcileResult, nil
+	}
+
+	return &net.FilterReconcileResponse{}, nil
+}
@@ -5,8 +5,11 @@ package virt
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
@@ -22,8 +25,11 @@ var (
 		"provider": hclspec.NewBlock("provider", false, hclspec.NewObject(map[string]*hclspec.Spec{
 			"libvirt": libvirt.ConfigSpec(),
 		})),
-		"image_paths":   hclspec.NewAttr("image_paths", "list(string)", false),
-		"storage_pools": hclspec.NewBlock("storage_pools", false, storage.ConfigSpec()),
+		"image_paths":        hclspec.NewAttr("image_paths", "list(string)", false),
+		"image_cache_dir":    hclspec.NewAttr("image_cache_dir", "string", false),
+		"registry_auth_file": hclspec.NewAttr("registry_auth_file", "string", false),
+		"filter_prune_delay": hclspec.NewAttr("filter_prune_delay", "string", false),
+		"storage_pools":      hclspec.NewBlock("storage_pools", false, storage.ConfigSpec()),
 	})
 
 	// taskConfigSpec is the specification of the plugin's configuration for
@@ -45,6 +51,15 @@ var (
 		})),
 	})
 
+	// sessionStoragePool is the name of the directory storage pool used when
+	// none are configured in session mode. It matches the name of the pool
+	// created by the libvirt session daemon, so the existing pool is reused.
+	sessionStoragePool = "default"
+
+	// defaultImageCacheDir is the directory where images downloaded from a
+	// URL are cached.
+	defaultImageCacheDir = "/var/lib/virt/images"
+
 	// validProviders is a list of valid provider names.
 	validProviders = []string{
 		libvirt.Name,
@@ -81,13 +96,24 @@ type OS struct {
 
 // Config contains configuration information for the plugin
 type Config struct {
-	Provider     *Provider       `codec:"provider"`
-	ImagePaths   []string        `codec:"image_paths"` // allow-list of host paths to load
-	StoragePools *storage.Config `codec:"storage_pools"`
+	Provider      *Provider       `codec:"provider"`
+	ImagePaths    []string        `codec:"image_paths"`     // allow-list of host paths to load
+	ImageCacheDir string          `codec:"image_cache_dir"` // host path to cache downloaded images
+	StoragePools  *storage.Config `codec:"storage_pools"`
+
+	// RegistryAuthFile is the host path of a Docker client configuration
+	// file holding the credentials of OCI registries.
+	RegistryAuthFile string `codec:"registry_auth_file"`
+
+	// FilterPruneDelay is the duration without any task being recovered
+	// after which the packet filter configuration not belonging to a running
+	// task is removed.
+	FilterPruneDelay string `codec:"filter_prune_delay"`
 }
 
-// Validate validates the configuration and sets default values.
-func (c *Config) Validate() error {
+// SetDefaults sets the default values of the configuration. It must be
+// called before Validate.
+func (c *Config) SetDefaults() error {
 	// If no provider configuration is set, default the libvirt provider.
 	if c.Provider == nil {
 		c.Provider = &Provider{Libvirt: &libvirt.Config{}}
@@ -95,6 +121,46 @@ func (c *Config) Validate() error {
 
 	var mErr *multierror.Error
 
+	// In session mode, the storage pools are within the data directory of
+	// the user, so directory pools can be used without setting a path.
+	if c.Provider.Libvirt.Session() {
+		mErr = multierror.Append(mErr, c.setSessionStoragePools())
+	}
+
+	mErr = multierror.Append(mErr, c.setImageCacheDir())
+
+	return mErr.ErrorOrNil()
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.Provider == nil {
+		return fmt.Errorf("%w: provider configuration is missing", errs.ErrInvalidConfiguration)
+	}
+
+	var mErr *multierror.Error
+
+	if c.ImageCacheDir != "" && !filepath.IsAbs(c.ImageCacheDir) {
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: image_cache_dir %q must be an absolute path",
+			errs.ErrInvalidConfiguration, c.ImageCacheDir))
+	}
+
+	if c.RegistryAuthFile != "" && !filepath.IsAbs(c.RegistryAuthFile) {
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: registry_auth_file %q must be an absolute path",
+			errs.ErrInvalidConfiguration, c.RegistryAuthFile))
+	}
+
+	if c.FilterPruneDelay != "" {
+		delay, err := time.ParseDuration(c.FilterPruneDelay)
+		if err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("%w: invalid filter_prune_delay %q: %w",
+				errs.ErrInvalidConfiguration, c.FilterPruneDelay, err))
+		} else if delay < 0 {
+			mErr = multierror.Append(mErr, fmt.Errorf("%w: filter_prune_delay %q must not be negative",
+				errs.ErrInvalidConfiguration, c.FilterPruneDelay))
+		}
+	}
+
 	mErr = multierror.Append(mErr,
 		c.Provider.Validate(),
 		c.StoragePools.Validate(),
@@ -103,6 +169,58 @@ func (c *Config) Validate() error {
 	return mErr.ErrorOrNil()
 }
 
+// setSessionStoragePools defaults the storage pools used in session mode.
+// Directory pools without a path use the images directory of the session
+// daemon, and a directory pool using it is added when no pools are defined.
+func (c *Config) setSessionStoragePools() error {
+	path, err := libvirt.SessionImagesPath()
+	if err != nil {
+		return err
+	}
+
+	if c.StoragePools == nil {
+		c.StoragePools = storage.NewConfig()
+	}
+	if c.StoragePools.Directory == nil {
+		c.StoragePools.Directory = make(map[string]storage.Directory)
+	}
+
+	if len(c.StoragePools.Directory) == 0 && len(c.StoragePools.Ceph) == 0 {
+		c.StoragePools.Directory[sessionStoragePool] = storage.Directory{Path: path}
+		return nil
+	}
+
+	for name, dir := range c.StoragePools.Directory {
+		if dir.Path == "" {
+			dir.Path = path
+			c.StoragePools.Directory[name] = dir
+		}
+	}
+
+	return nil
+}
+
+// setImageCacheDir defaults the image cache directory. In session mode, the
+// cache directory of the user is used by default.
+func (c *Config) setImageCacheDir() error {
+	if c.ImageCacheDir != "" {
+		return nil
+	}
+
+	if !c.Provider.Libvirt.Session() {
+		c.ImageCacheDir = defaultImageCacheDir
+		return nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return fmt.Errorf("failed to determine image cache directory: %w", err)
+	}
+	c.ImageCacheDir = filepath.Join(dir, "nomad-driver-virt", "images")
+
+	return nil
+}
+
 // Provider contains provider specific configuration
 type Provider struct {
 	Default string          `codec:"default"`
@@ -4,8 +4,10 @@
 package virt
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/providers/libvirt"
 	"github.com/hashicorp/nomad-driver-virt/storage"
 	"github.com/hashicorp/nomad-driver-virt/virt/disks"
@@ -61,12 +63,17 @@ func TestConfig_Plugin(t *testing.T) {
 		expected := &Config{
 			Provider: &Provider{
 				Libvirt: &libvirt.Config{
-					URI:      "qemu:///user",
-					User:     "test-user",
-					Password: "test-password",
+					URI:             "qemu:///user",
+					User:            "test-user",
+					Password:        "test-password",
+					NetworkFilter:   "iptables",
+					ManagedNetworks: map[string]net.ManagedNetworkConfig{},
 				},
 			},
-			ImagePaths: []string{"/path/one", "/path/two"},
+			ImagePaths:       []string{"/path/one", "/path/two"},
+			ImageCacheDir:    "/path/cache",
+			RegistryAuthFile: "/path/auth.json",
+			FilterPruneDelay: "10m",
 			StoragePools: &storage.Config{
 				Default: "test-pool",
 				Directory: map[string]storage.Directory{
@@ -88,6 +95,9 @@ func TestConfig_Plugin(t *testing.T) {
 		validHCL := `
 config {
 	image_paths = ["/path/one", "/path/two"]
+	image_cache_dir = "/path/cache"
+	registry_auth_file = "/path/auth.json"
+	filter_prune_delay = "10m"
 	provider "libvirt" {
 		uri = "qemu:///user"
 		user = "test-user"
@@ -114,6 +124,303 @@ config {
 		parser.ParseHCL(t, validHCL, &result)
 		must.Eq(t, expected, result)
 	})
+
+	t.Run("network filter", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		network_filter = "nftables"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.Eq(t, "nftables", result.Provider.Libvirt.NetworkFilter)
+		must.NoError(t, result.Provider.Validate())
+	})
+
+	t.Run("invalid network filter", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		network_filter = "ebtables"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.ErrorContains(t, result.Provider.Validate(), "unknown network filter")
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		discovery = ["guest_agent", "dhcp_lease"]
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.Eq(t, []net.DiscoveryStrategy{"guest_agent", "dhcp_lease"}, result.Provider.Libvirt.Discovery)
+		must.NoError(t, result.Provider.Validate())
+	})
+
+	t.Run("bandwidth from mbits", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		bandwidth_from_mbits = true
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.True(t, result.Provider.Libvirt.BandwidthFromMBits)
+	})
+
+	t.Run("managed network", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		managed_network "tenant" {
+			subnet_pool = "10.100.0.0/16"
+		}
+		managed_network "job-private" {
+			mode = "isolated"
+			scope = "job"
+			subnet_pool = "10.200.0.0/16"
+			subnet_size = 28
+		}
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.Eq(t, map[string]net.ManagedNetworkConfig{
+			"tenant": {
+				Mode:       net.ManagedNetworkModeNAT,
+				Scope:      net.ManagedNetworkScopeNamespace,
+				SubnetPool: "10.100.0.0/16",
+				SubnetSize: 24,
+			},
+			"job-private": {
+				Mode:       net.ManagedNetworkModeIsolated,
+				Scope:      net.ManagedNetworkScopeJob,
+				SubnetPool: "10.200.0.0/16",
+				SubnetSize: 28,
+			},
+		}, result.Provider.Libvirt.ManagedNetworks)
+		must.NoError(t, result.Provider.Validate())
+	})
+
+	t.Run("invalid managed network", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		managed_network "tenant" {
+			subnet_pool = "fd00::/48"
+		}
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.ErrorContains(t, result.Provider.Validate(), "must be an IPv4 CIDR")
+	})
+
+	t.Run("invalid discovery", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		discovery = ["mdns"]
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.ErrorContains(t, result.Provider.Validate(), "unknown discovery strategy")
+	})
+
+	t.Run("static discovery only", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		discovery = ["static"]
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.ErrorContains(t, result.Provider.Validate(), "requires a strategy other than")
+	})
+
+	t.Run("discovery timeout", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		discovery_timeout = "2m"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.Eq(t, "2m", result.Provider.Libvirt.DiscoveryTimeout)
+		must.NoError(t, result.Provider.Validate())
+	})
+
+	t.Run("invalid discovery timeout", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		discovery_timeout = "soon"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.ErrorContains(t, result.Provider.Validate(), "invalid discovery_timeout")
+	})
+}
+
+func TestConfig_SetDefaults_session(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataDir)
+	imagesPath := filepath.Join(dataDir, "libvirt", "images")
+
+	parser := hclutils.NewConfigParser(configSpec)
+
+	t.Run("default pool", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		uri = "qemu:///session"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.NoError(t, result.SetDefaults())
+		must.NoError(t, result.Validate())
+		must.Eq(t, map[string]storage.Directory{
+			"default": {Path: imagesPath},
+		}, result.StoragePools.Directory)
+	})
+
+	t.Run("directory without path", func(t *testing.T) {
+		validHCL := `
+config {
+	provider "libvirt" {
+		uri = "qemu:///session"
+	}
+	storage_pools {
+		directory "images" {}
+		directory "scratch" {
+			path = "/tmp/scratch"
+		}
+		default = "images"
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.NoError(t, result.SetDefaults())
+		must.NoError(t, result.Validate())
+		must.Eq(t, map[string]storage.Directory{
+			"images":  {Path: imagesPath},
+			"scratch": {Path: "/tmp/scratch"},
+		}, result.StoragePools.Directory)
+	})
+
+	t.Run("system directory without path", func(t *testing.T) {
+		validHCL := `
+config {
+	storage_pools {
+		directory "images" {}
+	}
+}
+`
+		var result *Config
+		parser.ParseHCL(t, validHCL, &result)
+		must.NoError(t, result.SetDefaults())
+		err := result.Validate()
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, "storage_pool.directory.path")
+		must.Eq(t, map[string]storage.Directory{"images": {}}, result.StoragePools.Directory)
+	})
+}
+
+func TestConfig_SetDefaults_imageCacheDir(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		config := &Config{Provider: &Provider{Libvirt: &libvirt.Config{}}}
+		must.NoError(t, config.SetDefaults())
+		must.Eq(t, defaultImageCacheDir, config.ImageCacheDir)
+	})
+
+	t.Run("session default", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		t.Setenv("XDG_CACHE_HOME", cacheDir)
+		t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+		config := &Config{Provider: &Provider{Libvirt: &libvirt.Config{URI: "qemu:///session"}}}
+		must.NoError(t, config.SetDefaults())
+		must.Eq(t, filepath.Join(cacheDir, "nomad-driver-virt", "images"), config.ImageCacheDir)
+	})
+
+	t.Run("relative", func(t *testing.T) {
+		config := &Config{
+			Provider:      &Provider{Libvirt: &libvirt.Config{}},
+			ImageCacheDir: "images",
+			StoragePools: &storage.Config{
+				Directory: map[string]storage.Directory{"images": {Path: "/tmp/images"}},
+			},
+		}
+		must.NoError(t, config.SetDefaults())
+		must.Eq(t, "images", config.ImageCacheDir)
+
+		err := config.Validate()
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, `image_cache_dir "images" must be an absolute path`)
+	})
+}
+
+func TestConfig_Validate_registryAuthFile(t *testing.T) {
+	config := &Config{
+		Provider:         &Provider{Libvirt: &libvirt.Config{}},
+		RegistryAuthFile: "auth.json",
+		StoragePools: &storage.Config{
+			Directory: map[string]storage.Directory{"images": {Path: "/tmp/images"}},
+		},
+	}
+	must.NoError(t, config.SetDefaults())
+
+	err := config.Validate()
+	must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+	must.ErrorContains(t, err, `registry_auth_file "auth.json" must be an absolute path`)
+
+	config.RegistryAuthFile = "/etc/nomad/auth.json"
+	must.NoError(t, config.Validate())
+}
+
+func TestConfig_Validate_filterPruneDelay(t *testing.T) {
+	config := &Config{
+		Provider:         &Provider{Libvirt: &libvirt.Config{}},
+		FilterPruneDelay: "soon",
+		StoragePools: &storage.Config{
+			Directory: map[string]storage.Directory{"images": {Path: "/tmp/images"}},
+		},
+	}
+	must.NoError(t, config.SetDefaults())
+
+	err := config.Validate()
+	must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+	must.ErrorContains(t, err, `invalid filter_prune_delay "soon"`)
+
+	config.FilterPruneDelay = "-1m"
+	must.ErrorContains(t, config.Validate(), `filter_prune_delay "-1m" must not be negative`)
+
+	config.FilterPruneDelay = "10m"
+	must.NoError(t, config.Validate())
 }
 
 func Test_taskConfigSpec(t *testing.T) {
@@ -148,14 +455,73 @@ config {
 				NetworkInterfacesConfig: []*net.NetworkInterfaceConfig{
 					{
 						Bridge: &net.NetworkInterfaceBridgeConfig{
-							Name:  "virbr0",
-							Ports: []string{"ssh"},
+							Name: "virbr0",
+							NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+								Ports: []string{"ssh"},
+							},
 						},
 					},
 				},
 				Timezone: "America/New_York",
 			},
 		},
+		{
+			name: "network interface with discovery",
+			inputConfig: `
+config {
+	network_interface {
+		bridge {
+			name = "br0"
+		}
+		discovery = ["guest_agent", "arp"]
+	}
+}
+`,
+			expectedOutput: TaskConfig{
+				Disks: disks.NewDisks(),
+				NetworkInterfacesConfig: []*net.NetworkInterfaceConfig{
+					{
+						Bridge: &net.NetworkInterfaceBridgeConfig{
+							Name: "br0",
+						},
+						Discovery: []net.DiscoveryStrategy{
+							net.DiscoveryStrategyGuestAgent,
+							net.DiscoveryStrategyARP,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "network interface attached by network name",
+			inputConfig: `
+config {
+	network_interface {
+		network {
+			name        = "isolated"
+			ports       = ["http"]
+			ipam        = true
+			dns_aliases = ["web"]
+		}
+	}
+}
+`,
+			expectedOutput: TaskConfig{
+				Disks: disks.NewDisks(),
+				NetworkInterfacesConfig: []*net.NetworkInterfaceConfig{
+					{
+						Network: &net.NetworkInterfaceVirtualNetworkConfig{
+							Name: "isolated",
+							NetworkInterfaceAttachmentConfig: net.NetworkInterfaceAttachmentConfig{
+								Ports:      []string{"http"},
+								IPAM:       true,
+								DNSAliases: []string{"web"},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
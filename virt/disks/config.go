@@ -4,10 +4,12 @@
 package disks
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +19,7 @@ import (
 	"github.com/hashicorp/nomad-driver-virt/internal/convert"
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/storage"
+	"github.com/hashicorp/nomad-driver-virt/storage/image_cache"
 	"github.com/hashicorp/nomad-driver-virt/storage/image_tools"
 	"github.com/hashicorp/nomad/helper/pointer"
 	"github.com/hashicorp/nomad/plugins/drivers"
@@ -43,9 +46,12 @@ var (
 		"read_only": hclspec.NewAttr("read_only", "bool", false),
 		"sparse":    hclspec.NewAttr("sparse", "bool", false),
 		"source": hclspec.NewBlock("source", false, hclspec.NewObject(map[string]*hclspec.Spec{
-			"format": hclspec.NewAttr("format", "string", false),
-			"image":  hclspec.NewAttr("image", "string", false),
-			"volume": hclspec.NewAttr("volume", "string", false),
+			"format":   hclspec.NewAttr("format", "string", false),
+			"image":    hclspec.NewAttr("image", "string", false),
+			"volume":   hclspec.NewAttr("volume", "string", false),
+			"url":      hclspec.NewAttr("url", "string", false),
+			"checksum": hclspec.NewAttr("checksum", "string", false),
+			"oci":      hclspec.NewAttr("oci", "string", false),
 		})),
 	}))
 )
@@ -63,6 +69,16 @@ type DiskValidator interface {
 	ValidateDisk(disk *Disk) error
 }
 
+// ImageFetcher retrieves source images from remote locations.
+type ImageFetcher interface {
+	// Fetch returns the path of a local copy of the image at the URL. The
+	// image is verified against the checksum when it is provided.
+	Fetch(ctx context.Context, url, checksum string) (string, error)
+
+	// Pull returns the local copy of the disk image at the OCI reference.
+	Pull(ctx context.Context, reference string) (*image_cache.OCIImage, error)
+}
+
 // NewDisks returns a new disk collection.
 func NewDisks() Disks {
 	return make(Disks, 0)
@@ -70,7 +86,8 @@ func NewDisks() Disks {
 
 // ValidationOptions are used when validating the disks configuration.
 type ValidationOptions struct {
-	AllowedPaths []string // Absolute paths on host allowed for image files
+	AllowedPaths  []string // Absolute paths on host allowed for image files
+	ImageCacheDir string   // Image cache directory, whose images are only allowed when fetched for the disk
 }
 
 // AllowedPath checks if the provided path is within the defined allowed paths
@@ -83,6 +100,14 @@ func (v ValidationOptions) AllowedPath(path string) bool {
 		}
 	}
 
+	// Cached images are only used through the source which fetched them, so
+	// they are not allowed even when the cache is within an allowed path.
+	if v.ImageCacheDir != "" {
+		if rel, err := filepath.Rel(v.ImageCacheDir, path); err == nil && filepath.IsLocal(rel) {
+			return false
+		}
+	}
+
 	for _, dir := range v.AllowedPaths {
 		root, err := os.OpenRoot(dir)
 		if err != nil {
@@ -186,11 +211,16 @@ func (d *Disk) ValidateNomadVolume() error {
 
 // Source describes the source of the disk
 type Source struct {
-	Image  string `codec:"image"`  // Image file from which to generate new volume or readonly disk.
-	Format string `codec:"format"` // Format of the source image. Setting this will prevent inspecting the file.
-	Volume string `codec:"volume"` // Existing volume from which to generate new volume.
+	Image    string `codec:"image"`    // Image file from which to generate new volume or readonly disk.
+	Format   string `codec:"format"`   // Format of the source image. Setting this will prevent inspecting the file.
+	Volume   string `codec:"volume"`   // Existing volume from which to generate new volume.
+	URL      string `codec:"url"`      // HTTP(S) location of the image to download as the source image.
+	Checksum string `codec:"checksum"` // Checksum of the image downloaded from the URL (sha256:<value>).
+	OCI      string `codec:"oci"`      // OCI reference of the image to pull as the source image.
 
 	identifier string // Unique source identifier generated internally.
+	digest     string // Manifest digest of the image pulled from an OCI registry.
+	fetched    string // Path of the image downloaded or pulled for the source.
 }
 
 func (s *Source) Equal(rhs *Source) bool {
@@ -204,6 +234,55 @@ func (s *Source) Equal(rhs *Source) bool {
 	return *s == *rhs
 }
 
+// isFetched returns if the source image is the image fetched for the source,
+// rather than a local image defined by the configuration. An image pulled
+// from an OCI registry must also have the digest of the pulled manifest.
+func (s *Source) isFetched() bool {
+	if s.OCI != "" && s.digest == "" {
+		return false
+	}
+
+	return s.fetched != "" && s.Image == s.fetched
+}
+
+// validateURL validates the URL source of the disk.
+func (s *Source) validateURL() error {
+	var mErr *multierror.Error
+
+	if s.Image != "" || s.Volume != "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%w: source.url can not be combined with source.image or source.volume", errs.ErrInvalidConfiguration))
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%w: source.url %q must be an http or https URL", errs.ErrInvalidConfiguration, s.URL))
+	}
+
+	if _, err := image_cache.ParseChecksum(s.Checksum); err != nil {
+		mErr = multierror.Append(mErr, err)
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// validateOCI validates the OCI source of the disk.
+func (s *Source) validateOCI() error {
+	var mErr *multierror.Error
+
+	if s.Image != "" || s.Volume != "" || s.URL != "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%w: source.oci can not be combined with source.image, source.volume, or source.url", errs.ErrInvalidConfiguration))
+	}
+
+	if _, err := image_cache.ParseReference(s.OCI); err != nil {
+		mErr = multierror.Append(mErr, err)
+	}
+
+	return mErr.ErrorOrNil()
+}
+
 // ApplyCloudInit will add a disk entry as a cdrom for cloud-init
 func (d Disks) ApplyCloudInit(isoPath string) Disks {
 	if d == nil {
@@ -249,6 +328,67 @@ func (d Disks) CompatAddImage(image string, size int64, thinCopy bool) Disks {
 	return append(d, newDisk)
 }
 
+// FetchImages downloads the source images of the disks which define a URL
+// or an OCI reference, and sets the source image of each disk to the
+// downloaded copy.
+func (d Disks) FetchImages(ctx context.Context, f ImageFetcher) error {
+	var mErr *multierror.Error
+
+	for i, disk := range d {
+		if disk.Source == nil {
+			continue
+		}
+
+		errPrefix := fmt.Sprintf("disk[%d] -", i+1)
+		if disk.Source.OCI != "" {
+			if err := disk.Source.pull(ctx, f); err != nil {
+				mErr = multierror.Append(mErr, prefixError(errPrefix, err))
+			}
+			continue
+		}
+
+		if disk.Source.URL == "" {
+			continue
+		}
+
+		if err := disk.Source.validateURL(); err != nil {
+			mErr = multierror.Append(mErr, prefixError(errPrefix, err))
+			continue
+		}
+
+		path, err := f.Fetch(ctx, disk.Source.URL, disk.Source.Checksum)
+		if err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s failed to download source image %s: %w", errPrefix, disk.Source.URL, err))
+			continue
+		}
+		disk.Source.Image, disk.Source.fetched = path, path
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// pull pulls the OCI source image and sets the source image to the pulled
+// copy. The format of the source is provided by the image layer.
+func (s *Source) pull(ctx context.Context, f ImageFetcher) error {
+	if err := s.validateOCI(); err != nil {
+		return err
+	}
+
+	img, err := f.Pull(ctx, s.OCI)
+	if err != nil {
+		return fmt.Errorf("failed to pull source image %s: %w", s.OCI, err)
+	}
+
+	if s.Format != "" && s.Format != img.Format {
+		return fmt.Errorf("%w: source.format %q does not match format %q of source image %s",
+			errs.ErrInvalidConfiguration, s.Format, img.Format, s.OCI)
+	}
+
+	s.Image, s.Format, s.digest, s.fetched = img.Path, img.Format, img.Digest, img.Path
+	return nil
+}
+
 // ResolveImages normalizes paths in the file disk configurations
 func (d Disks) ResolveImages(dirs []string) {
 	if d == nil {
@@ -423,7 +563,11 @@ func (d Disks) Prepare(s storage.Storage) error {
 		// create the parent volume if it does not exist.
 		if disk.Source != nil && disk.Source.Image != "" {
 			if disk.Chained {
-				if disk.Source.identifier == "" {
+				if disk.Source.identifier == "" && disk.Source.digest != "" {
+					// Images pulled from a registry are already identified
+					// by their manifest digest.
+					disk.Source.identifier = digestIdentifier(disk.Source.digest, disk.Format)
+				} else if disk.Source.identifier == "" {
 					var err error
 					disk.Source.identifier, err = generateIdentifier(disk.Source.Image, disk.Format)
 					if err != nil {
@@ -543,13 +687,14 @@ func (d Disks) Validate(s storage.Storage, opts ValidationOptions) error {
 		)
 
 		// If a source image has been set, check that it exists and it's
-		// located at an accessible location.
+		// located at an accessible location. Images fetched for the disk
+		// are not required to be within the allowed paths.
 		if disk.Source != nil && disk.Source.Image != "" {
 			if !fileExists(disk.Source.Image) {
 				mErr = multierror.Append(mErr,
 					fmt.Errorf("%s %w: %s", errPrefix, ErrPathNotFound, disk.Source.Image))
 			}
-			if !opts.AllowedPath(disk.Source.Image) {
+			if !disk.Source.isFetched() && !opts.AllowedPath(disk.Source.Image) {
 				mErr = multierror.Append(mErr,
 					fmt.Errorf("%s %w: %s", errPrefix, ErrDisallowedPath, disk.Source.Image))
 			}
@@ -562,6 +707,12 @@ func (d Disks) Validate(s storage.Storage, opts ValidationOptions) error {
 				errs.MissingAttribute("source.format", disk.Source.Format, errs.WithPrefix(errPrefix)))
 		}
 
+		// A checksum is only used to verify an image downloaded from a URL.
+		if disk.Source != nil && disk.Source.Checksum != "" && disk.Source.URL == "" {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: source.checksum requires source.url", errPrefix, errs.ErrInvalidConfiguration))
+		}
+
 		// If the disk is backed by a Nomad volume, validate that attributes which
 		// are not applicable have not been set.
 		if disk.IsNomadVolume() {
@@ -802,6 +953,12 @@ func generateIdentifier(path, format string) (string, error) {
 	return fmt.Sprintf("%s-%s-%x.img", identifierPrefix, format, hash.Sum(nil)), nil
 }
 
+// digestIdentifier generates an identifier for a source image pulled from
+// an OCI registry based on the digest of its manifest
+func digestIdentifier(digest, format string) string {
+	return fmt.Sprintf("%s-%s-oci-%s.img", identifierPrefix, format, strings.TrimPrefix(digest, "sha256:"))
+}
+
 // fileExists checks if a file exists at the path
 func fileExists(path string) bool {
 	info, err := os.Stat(path)
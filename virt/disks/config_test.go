@@ -4,16 +4,18 @@
 package disks
 
 import (
-	//	"errors"
-
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad-driver-virt/storage"
+	"github.com/hashicorp/nomad-driver-virt/storage/image_cache"
 	mock_storage "github.com/hashicorp/nomad-driver-virt/testutil/mock/storage"
 	mock_image_tools "github.com/hashicorp/nomad-driver-virt/testutil/mock/storage/image_tools"
 	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
@@ -58,6 +60,18 @@ func TestValidationOptions(t *testing.T) {
 		t.Run("path does not exist", func(t *testing.T) {
 			must.False(t, vOpts.AllowedPath(filepath.Join(root, "fake-file")))
 		})
+
+		t.Run("path within image cache", func(t *testing.T) {
+			cacheDir := filepath.Join(root, "images")
+			must.NoError(t, os.MkdirAll(cacheDir, 0755))
+			path := filepath.Join(cacheDir, "blob-image.img")
+			f, err := os.OpenFile(path, os.O_CREATE, 0666)
+			must.NoError(t, err)
+			f.Close()
+
+			must.True(t, vOpts.AllowedPath(path))
+			must.False(t, ValidationOptions{AllowedPaths: dirList, ImageCacheDir: cacheDir}.AllowedPath(path))
+		})
 	})
 }
 
@@ -189,6 +203,100 @@ func TestDisk(t *testing.T) {
 		})
 	})
 
+	t.Run("FetchImages", func(t *testing.T) {
+		checksum := "sha256:" + strings.Repeat("0", 64)
+
+		t.Run("ok", func(t *testing.T) {
+			var requested []string
+			fetcher := &testFetcher{fetch: func(_ context.Context, url, sum string) (string, error) {
+				requested = append(requested, url+"#"+sum)
+				return "/cache/image.img", nil
+			}}
+
+			d := Disks{
+				{Source: &Source{URL: "https://example.com/image.qcow2", Checksum: checksum}},
+				{Source: &Source{Image: "local.img"}},
+				{},
+			}
+			must.NoError(t, d.FetchImages(t.Context(), fetcher))
+			must.Eq(t, []string{"https://example.com/image.qcow2#" + checksum}, requested)
+			must.Eq(t, "/cache/image.img", d[0].Source.Image)
+			must.True(t, d[0].Source.isFetched())
+			must.Eq(t, "local.img", d[1].Source.Image)
+			must.False(t, d[1].Source.isFetched())
+		})
+
+		t.Run("invalid", func(t *testing.T) {
+			fetcher := &testFetcher{}
+
+			d := Disks{
+				{Source: &Source{URL: "ftp://example.com/image.qcow2"}},
+				{Source: &Source{URL: "https://example.com/image.qcow2", Image: "local.img"}},
+				{Source: &Source{URL: "https://example.com/image.qcow2", Checksum: "md5:abc"}},
+			}
+			err := d.FetchImages(t.Context(), fetcher)
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, `disk[1] - invalid configuration: source.url "ftp://example.com/image.qcow2" must be an http or https URL`)
+			must.ErrorContains(t, err, "disk[2] - invalid configuration: source.url can not be combined with source.image or source.volume")
+			must.ErrorContains(t, err, `disk[3] - invalid configuration: checksum "md5:abc" has unsupported algorithm`)
+		})
+
+		t.Run("failed", func(t *testing.T) {
+			fetcher := &testFetcher{fetch: func(context.Context, string, string) (string, error) {
+				return "", errors.New("connection refused")
+			}}
+
+			d := Disks{{Source: &Source{URL: "https://example.com/image.qcow2"}}}
+			err := d.FetchImages(t.Context(), fetcher)
+			must.ErrorContains(t, err, "disk[1] - failed to download source image https://example.com/image.qcow2: connection refused")
+			must.Eq(t, "", d[0].Source.Image)
+		})
+
+		t.Run("oci", func(t *testing.T) {
+			digest := "sha256:" + strings.Repeat("1", 64)
+			fetcher := &testFetcher{pull: func(_ context.Context, ref string) (*image_cache.OCIImage, error) {
+				must.Eq(t, "registry.example.com/images/ubuntu:24.04", ref)
+				return &image_cache.OCIImage{Path: "/cache/image.img", Format: storage.DiskFormatQcow2, Digest: digest}, nil
+			}}
+
+			d := Disks{{Source: &Source{OCI: "registry.example.com/images/ubuntu:24.04"}}}
+			must.NoError(t, d.FetchImages(t.Context(), fetcher))
+			must.Eq(t, "/cache/image.img", d[0].Source.Image)
+			must.Eq(t, storage.DiskFormatQcow2, d[0].Source.Format)
+			must.Eq(t, digest, d[0].Source.digest)
+			must.True(t, d[0].Source.isFetched())
+		})
+
+		t.Run("oci invalid", func(t *testing.T) {
+			fetcher := &testFetcher{pull: func(context.Context, string) (*image_cache.OCIImage, error) {
+				return &image_cache.OCIImage{Path: "/cache/image.img", Format: storage.DiskFormatQcow2}, nil
+			}}
+
+			d := Disks{
+				{Source: &Source{OCI: "ubuntu:24.04"}},
+				{Source: &Source{OCI: "registry.example.com/ubuntu", URL: "https://example.com/image.qcow2"}},
+				{Source: &Source{OCI: "registry.example.com/ubuntu", Format: storage.DiskFormatRaw}},
+			}
+			err := d.FetchImages(t.Context(), fetcher)
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, `disk[1] - invalid configuration: oci reference "ubuntu:24.04" must include the registry host`)
+			must.ErrorContains(t, err, "disk[2] - invalid configuration: source.oci can not be combined with source.image, source.volume, or source.url")
+			must.ErrorContains(t, err, `disk[3] - invalid configuration: source.format "raw" does not match format "qcow2"`)
+			must.Eq(t, "", d[2].Source.Image)
+		})
+
+		t.Run("oci failed", func(t *testing.T) {
+			fetcher := &testFetcher{pull: func(context.Context, string) (*image_cache.OCIImage, error) {
+				return nil, image_cache.ErrPullFailed
+			}}
+
+			d := Disks{{Source: &Source{OCI: "registry.example.com/ubuntu"}}}
+			err := d.FetchImages(t.Context(), fetcher)
+			must.ErrorIs(t, err, image_cache.ErrPullFailed)
+			must.ErrorContains(t, err, "disk[1] - failed to pull source image registry.example.com/ubuntu")
+		})
+	})
+
 	t.Run("ResolveImages", func(t *testing.T) {
 		validDir := filepath.Join(t.TempDir(), "images")
 		must.NoError(t, os.MkdirAll(validDir, 0755))
@@ -397,6 +505,22 @@ func TestDisk(t *testing.T) {
 					must.Eq(t, volName, disk.Source.Volume)
 					must.Eq(t, "", disk.Source.Image)
 				})
+
+				t.Run("uses manifest digest of pulled image", func(t *testing.T) {
+					volName := "nmdsrc-testing-format-oci-" + strings.Repeat("1", 64) + ".img"
+					d := Disks{{Format: "testing-format", Chained: true, Source: &Source{
+						Image: srcPath, Format: "testing-format", digest: "sha256:" + strings.Repeat("1", 64),
+					}}}
+					p := mock_storage.NewMockPool(t)
+					defer p.AssertExpectations()
+					p.Expect(mock_storage.GetVolume{Name: volName, Result: &storage.Volume{Name: volName}})
+					s := &mock_storage.StaticStorage{
+						DefaultPoolResult: p,
+					}
+					must.NoError(t, d.Prepare(s))
+
+					must.Eq(t, volName, d[0].Source.Volume)
+				})
 			})
 		})
 	})
@@ -670,6 +794,15 @@ func TestDisk(t *testing.T) {
 					must.ErrorContains(t, err, "format")
 				})
 
+				t.Run("checksum without url", func(t *testing.T) {
+					d := Disks{{Format: "raw", Size: "100", Devname: "sda", Kind: storage.DiskKindDisk,
+						BusType: storage.BusTypeVirtio, Primary: true, Source: &Source{Image: imagePath, Format: "raw",
+							Checksum: "sha256:" + strings.Repeat("0", 64)}}}
+					err := d.Validate(mock_storage.NewStaticStorage(), opts)
+					must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+					must.ErrorContains(t, err, "source.checksum requires source.url")
+				})
+
 				t.Run("path does not exist", func(t *testing.T) {
 					d := Disks{{Format: "raw", Size: "100", Devname: "sda", Kind: storage.DiskKindDisk,
 						BusType: storage.BusTypeVirtio, Primary: true, Source: &Source{Image: filepath.Join(t.TempDir(), "image"), Format: "raw"}}}
@@ -684,6 +817,21 @@ func TestDisk(t *testing.T) {
 					must.ErrorIs(t, err, ErrDisallowedPath)
 				})
 
+				t.Run("fetched path is allowed", func(t *testing.T) {
+					d := Disks{{Format: "raw", Size: "100", Devname: "sda", Kind: storage.DiskKindDisk,
+						BusType: storage.BusTypeVirtio, Primary: true, Source: &Source{Image: imagePath, Format: "raw",
+							URL: "https://example.com/image.img", fetched: imagePath}}}
+					must.NoError(t, d.Validate(mock_storage.NewStaticStorage(), ValidationOptions{}))
+				})
+
+				t.Run("pulled path without digest is not allowed", func(t *testing.T) {
+					d := Disks{{Format: "raw", Size: "100", Devname: "sda", Kind: storage.DiskKindDisk,
+						BusType: storage.BusTypeVirtio, Primary: true, Source: &Source{Image: imagePath, Format: "raw",
+							OCI: "registry.example.com/ubuntu", fetched: imagePath}}}
+					err := d.Validate(mock_storage.NewStaticStorage(), ValidationOptions{})
+					must.ErrorIs(t, err, ErrDisallowedPath)
+				})
+
 				t.Run("disk is too small", func(t *testing.T) {
 					d := Disks{{Format: "raw", Size: "1", Devname: "sda", Kind: storage.DiskKindDisk,
 						BusType: storage.BusTypeVirtio, Primary: true, Source: &Source{Image: imagePath, Format: "raw"}}}
@@ -855,6 +1003,55 @@ config {
       image = "http://example.com/cd.img"
     }
   }
+}`
+		var disks Disks
+		parser.ParseHCL(t, validHcl, &disks)
+		must.Eq(t, expected, disks)
+	})
+
+	t.Run("valid - url", func(t *testing.T) {
+		expected := Disks{
+			{
+				Chained: true,
+				Source: &Source{
+					URL:      "https://example.com/task.qcow2",
+					Checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				},
+			},
+		}
+		parser := hclutils.NewConfigParser(configSpec)
+		validHcl := `
+config {
+  disk {
+    chained = true
+    source {
+      url      = "https://example.com/task.qcow2"
+      checksum = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+    }
+  }
+}`
+		var disks Disks
+		parser.ParseHCL(t, validHcl, &disks)
+		must.Eq(t, expected, disks)
+	})
+	t.Run("valid - oci", func(t *testing.T) {
+		expected := Disks{
+			{
+				Chained: true,
+				Source: &Source{
+					OCI: "registry.example.com/images/ubuntu:24.04",
+				},
+			},
+		}
+		parser := hclutils.NewConfigParser(configSpec)
+		validHcl := `
+config {
+  disk {
+    chained = true
+    source {
+      oci = "registry.example.com/images/ubuntu:24.04"
+    }
+  }
 }`
 		var disks Disks
 		parser.ParseHCL(t, validHcl, &disks)
@@ -870,3 +1067,24 @@ type poolValidator struct {
 func (p *poolValidator) ValidateDisk(*Disk) error {
 	return p.ValidateDiskResult
 }
+
+// testFetcher implements ImageFetcher using functions. Calls are unexpected
+// when the function is unset.
+type testFetcher struct {
+	fetch func(ctx context.Context, url, checksum string) (string, error)
+	pull  func(ctx context.Context, reference string) (*image_cache.OCIImage, error)
+}
+
+func (f *testFetcher) Fetch(ctx context.Context, url, checksum string) (string, error) {
+	if f.fetch == nil {
+		return "", errors.New("unexpected fetch")
+	}
+	return f.fetch(ctx, url, checksum)
+}
+
+func (f *testFetcher) Pull(ctx context.Context, reference string) (*image_cache.OCIImage, error) {
+	if f.pull == nil {
+		return nil, errors.New("unexpected pull")
+	}
+	return f.pull(ctx, reference)
+}
@@ -5,11 +5,16 @@ package net
 
 import (
 	"fmt"
+	"maps"
+	"net/netip"
+	"regexp"
 	"slices"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
 )
 
@@ -37,6 +42,321 @@ const (
 	MacvtapModePassthrough MacvtapMode = "passthrough"
 )
 
+// PortProtocol represents the transport protocol of a port mapped to a
+// network interface.
+type PortProtocol string
+
+const (
+	// PortProtocolTCP is the protocol used for port mappings when none is
+	// specified.
+	PortProtocolTCP PortProtocol = "tcp"
+
+	// PortProtocolUDP is used for port mappings carrying UDP traffic.
+	PortProtocolUDP PortProtocol = "udp"
+
+	// PortProtocolSCTP is used for port mappings carrying SCTP traffic.
+	PortProtocolSCTP PortProtocol = "sctp"
+)
+
+// portRangeSeparator separates the labels of the first and last ports of a
+// port range within a bridge ports entry.
+const portRangeSeparator = ".."
+
+// validPortProtocols is the set of accepted PortProtocol values.
+var validPortProtocols = []PortProtocol{
+	PortProtocolTCP,
+	PortProtocolUDP,
+	PortProtocolSCTP,
+}
+
+// UserBackend represents the implementation providing the user-mode
+// networking of a user interface.
+type UserBackend string
+
+const (
+	// UserBackendPasst uses passt, which runs as a separate unprivileged
+	// process and supports port forwarding. This is the backend used when
+	// none is specified.
+	UserBackendPasst UserBackend = "passt"
+
+	// UserBackendSlirp uses the slirp implementation built into QEMU. It
+	// does not support port forwarding.
+	UserBackendSlirp UserBackend = "slirp"
+)
+
+// validUserBackends is the set of accepted UserBackend values.
+var validUserBackends = []UserBackend{
+	UserBackendPasst,
+	UserBackendSlirp,
+}
+
+// PortMapping is the parsed representation of an entry within the bridge
+// ports configuration.
+type PortMapping struct {
+	// Label is the port label as defined within the job specification
+	// network block. For a port range, it is the label of the first port.
+	Label string
+
+	// LastLabel is the label of the last port of a port range. It is empty
+	// when the entry maps a single port.
+	LastLabel string
+
+	// Protocol is the transport protocol which is forwarded for the port.
+	Protocol PortProtocol
+}
+
+// IsRange returns if the mapping is a range of ports.
+func (p PortMapping) IsRange() bool {
+	return p.LastLabel != ""
+}
+
+// String returns the string representation of the port mapping.
+func (p PortMapping) String() string {
+	if p.IsRange() {
+		return p.Label + portRangeSeparator + p.LastLabel + "/" + string(p.Protocol)
+	}
+	return p.Label + "/" + string(p.Protocol)
+}
+
+// Range returns the reserved ports of the first and last label of a port
+// range. Every port within the range must be reserved by the task on the
+// same host address, and be forwarded to the same port within the VM, so the
+// range can be forwarded using a single rule without exposing ports which
+// Nomad has not allocated to the task. The returned bool is false when either
+// label has not been reserved.
+func (p PortMapping) Range(ports structs.AllocatedPorts) (first, last structs.AllocatedPortMapping, ok bool, err error) {
+	first, ok = ports.Get(p.Label)
+	if !ok {
+		return first, last, false, nil
+	}
+
+	last, ok = ports.Get(p.LastLabel)
+	if !ok {
+		return first, last, false, nil
+	}
+
+	if first.HostIP != last.HostIP {
+		return first, last, true, fmt.Errorf("%w: port range %q spans host addresses %s and %s",
+			errs.ErrInvalidConfiguration, p.String(), first.HostIP, last.HostIP)
+	}
+
+	if last.Value <= first.Value {
+		return first, last, true, fmt.Errorf("%w: port range %q ends at port %d which is not after port %d",
+			errs.ErrInvalidConfiguration, p.String(), last.Value, first.Value)
+	}
+
+	reserved := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port.HostIP != first.HostIP {
+			continue
+		}
+
+		// Ports must not be translated, as a single rule can only forward
+		// the range to the same ports.
+		reserved[port.Value] = port.To <= 0 || port.To == port.Value
+	}
+
+	for value := first.Value; value <= last.Value; value++ {
+		preserved, found := reserved[value]
+		if !found {
+			return first, last, true, fmt.Errorf("%w: port range %q includes port %d which is not reserved by the task",
+				errs.ErrInvalidConfiguration, p.String(), value)
+		}
+
+		if !preserved {
+			return first, last, true, fmt.Errorf("%w: port range %q includes port %d which is mapped to a different port",
+				errs.ErrInvalidConfiguration, p.String(), value)
+		}
+	}
+
+	return first, last, true, nil
+}
+
+// Contains returns if the port with the passed label is mapped by the entry.
+// A port is within a port range when it is reserved on the same host address
+// as the range, with a value between the first and last ports of the range.
+func (p PortMapping) Contains(label string, ports structs.AllocatedPorts) bool {
+	if label == p.Label {
+		return true
+	}
+
+	if !p.IsRange() {
+		return false
+	}
+
+	if label == p.LastLabel {
+		return true
+	}
+
+	port, ok := ports.Get(label)
+	if !ok {
+		return false
+	}
+
+	first, last, ok, err := p.Range(ports)
+	if !ok || err != nil {
+		return false
+	}
+
+	return port.HostIP == first.HostIP && port.Value >= first.Value && port.Value <= last.Value
+}
+
+// ParsePortMapping parses a bridge ports entry, which is in the form of
+// "label" or "label/protocol". A range of ports is mapped using the labels of
+// the first and last ports, in the form of "first..last". The protocol
+// defaults to TCP when it is not specified.
+func ParsePortMapping(entry string) (PortMapping, error) {
+	label, protocol, found := strings.Cut(entry, "/")
+
+	mapping := PortMapping{Label: label, Protocol: PortProtocolTCP}
+	if found {
+		mapping.Protocol = PortProtocol(strings.ToLower(protocol))
+	}
+
+	if first, last, isRange := strings.Cut(label, portRangeSeparator); isRange {
+		mapping.Label, mapping.LastLabel = first, last
+
+		if first == "" || last == "" || first == last {
+			return mapping, fmt.Errorf("%w: port range %q requires the labels of two different ports",
+				errs.ErrInvalidConfiguration, entry)
+		}
+	}
+
+	if mapping.Label == "" {
+		return mapping, fmt.Errorf("%w: port %q has no label", errs.ErrInvalidConfiguration, entry)
+	}
+
+	if !slices.Contains(validPortProtocols, mapping.Protocol) {
+		validProtocols := make([]string, len(validPortProtocols))
+		for i, v := range validPortProtocols {
+			validProtocols[i] = string(v)
+		}
+		return mapping, fmt.Errorf("%w: port %q has invalid protocol %q; must be one of: %s",
+			errs.ErrInvalidConfiguration, entry, protocol, strings.Join(validProtocols, ", "))
+	}
+
+	return mapping, nil
+}
+
+// GuestPort returns the port within the VM which the allocated port is
+// forwarded to. The port within the VM is the same as the host port, unless
+// it has been mapped.
+func GuestPort(port structs.AllocatedPortMapping) int {
+	if port.To > 0 {
+		return port.To
+	}
+
+	return port.Value
+}
+
+// HostNetworks returns the name of the Nomad host network each port of the
+// task networks has been placed on, keyed by the port label. Ports placed on
+// the default network of the host are not included.
+func HostNetworks(res *drivers.Resources) map[string]string {
+	hostNetworks := map[string]string{}
+	if res == nil || res.NomadResources == nil {
+		return hostNetworks
+	}
+
+	for _, network := range res.NomadResources.Networks {
+		for _, ports := range [][]structs.Port{network.ReservedPorts, network.DynamicPorts} {
+			for _, port := range ports {
+				if port.HostNetwork != "" && port.HostNetwork != "default" {
+					hostNetworks[port.Label] = port.HostNetwork
+				}
+			}
+		}
+	}
+
+	return hostNetworks
+}
+
+// EgressPolicy is the policy applied to traffic sent by a bridged network
+// interface.
+type EgressPolicy string
+
+const (
+	// EgressPolicyAllowAll allows all traffic sent by the interface. This is
+	// the policy used when none is specified.
+	EgressPolicyAllowAll EgressPolicy = "allow_all"
+
+	// EgressPolicyDenyAll drops all new connections initiated by the
+	// interface.
+	EgressPolicyDenyAll EgressPolicy = "deny_all"
+
+	// EgressPolicyAllowList drops all new connections initiated by the
+	// interface, except those to the destinations within the allow list.
+	EgressPolicyAllowList EgressPolicy = "allow_list"
+)
+
+// validEgressPolicies is the set of accepted EgressPolicy values.
+var validEgressPolicies = []EgressPolicy{
+	EgressPolicyAllowAll,
+	EgressPolicyDenyAll,
+	EgressPolicyAllowList,
+}
+
+// DiscoveryStrategy is a method used to discover the addresses of a VM
+// network interface once the VM has been started.
+type DiscoveryStrategy string
+
+const (
+	// DiscoveryStrategyDHCPLease discovers addresses using the leases of the
+	// DHCP server of a libvirt managed network.
+	DiscoveryStrategyDHCPLease DiscoveryStrategy = "dhcp_lease"
+
+	// DiscoveryStrategyGuestAgent discovers addresses using the interface
+	// addresses reported by the guest agent running within the VM.
+	DiscoveryStrategyGuestAgent DiscoveryStrategy = "guest_agent"
+
+	// DiscoveryStrategyARP discovers addresses by watching the neighbor table
+	// of the host device for entries matching the interface hardware address.
+	DiscoveryStrategyARP DiscoveryStrategy = "arp"
+
+	// DiscoveryStrategyStatic uses the addresses of the interface network
+	// configuration.
+	DiscoveryStrategyStatic DiscoveryStrategy = "static"
+)
+
+// validDiscoveryStrategies is the set of accepted DiscoveryStrategy values.
+var validDiscoveryStrategies = []DiscoveryStrategy{
+	DiscoveryStrategyDHCPLease,
+	DiscoveryStrategyGuestAgent,
+	DiscoveryStrategyARP,
+	DiscoveryStrategyStatic,
+}
+
+// ValidateDiscoveryStrategies ensures the ordered list of discovery
+// strategies only contains supported strategies, each listed once.
+func ValidateDiscoveryStrategies(strategies []DiscoveryStrategy) error {
+	for i, strategy := range strategies {
+		if !slices.Contains(validDiscoveryStrategies, strategy) {
+			validStrategies := make([]string, len(validDiscoveryStrategies))
+			for i, v := range validDiscoveryStrategies {
+				validStrategies[i] = string(v)
+			}
+			return fmt.Errorf("%w: unknown discovery strategy %q; must be one of: %s",
+				errs.ErrInvalidConfiguration, strategy, strings.Join(validStrategies, ", "))
+		}
+
+		if slices.Contains(strategies[:i], strategy) {
+			return fmt.Errorf("%w: discovery strategy %q is listed more than once",
+				errs.ErrInvalidConfiguration, strategy)
+		}
+	}
+
+	return nil
+}
+
+const (
+	// minMTU is the minimum MTU of an IPv4 interface.
+	minMTU = 68
+
+	// maxMTU is the maximum MTU of an interface, which matches the largest
+	// jumbo frames supported by common network devices.
+	maxMTU = 65535
+)
+
 // validMacvtapModes is the set of accepted MacvtapMode values.
 var validMacvtapModes = []MacvtapMode{
 	MacvtapModeBridge,
@@ -45,122 +365,1111 @@ var validMacvtapModes = []MacvtapMode{
 	MacvtapModePassthrough,
 }
 
+// dnsLabel matches a valid RFC 1123 DNS label.
+var dnsLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// interfaceIDPattern matches a UUID, which Open vSwitch requires as the
+// interface ID of a port.
+var interfaceIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+const (
+	// minVLANID and maxVLANID are the bounds of the usable 802.1Q VLAN IDs.
+	minVLANID = 1
+	maxVLANID = 4094
+)
+
 // NetworkInterfacesConfig is the list of network interfaces that should be
-// added to a VM. Currently, the driver only supports a single entry which is
-// validated within the Validate function.
+// added to a VM. The order of the entries is preserved when generating the VM
+// definition, so the index of an entry can be used to correlate it with the
+// interfaces reported by the virtualization provider.
 //
 // Due to its type, callers will need to dereference the object before
 // performing iteration.
 type NetworkInterfacesConfig []*NetworkInterfaceConfig
 
-// Equal returns if the given NetworkInterfacesConfig is equal.
-func (n NetworkInterfacesConfig) Equal(rhs NetworkInterfacesConfig) bool {
-	if len(n) != len(rhs) {
+// Equal returns if the given NetworkInterfacesConfig is equal.
+func (n NetworkInterfacesConfig) Equal(rhs NetworkInterfacesConfig) bool {
+	if len(n) != len(rhs) {
+		return false
+	}
+
+	for i, lhs := range n {
+		if !lhs.Equal(rhs[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NetworkInterfaceConfig contains all the possible network interface options
+// that a VM currently supports via the Nomad driver.
+type NetworkInterfaceConfig struct {
+	Bridge  *NetworkInterfaceBridgeConfig         `codec:"bridge"`
+	Network *NetworkInterfaceVirtualNetworkConfig `codec:"network"`
+	Macvtap *NetworkInterfaceMacvtapConfig        `codec:"macvtap"`
+	User    *NetworkInterfaceUserConfig           `codec:"user"`
+
+	// Primary marks the interface whose address is returned to Nomad and used
+	// for service registration. When no interface is marked, the first
+	// interface is considered the primary.
+	Primary bool `codec:"primary"`
+
+	// NetworkConfig is the guest network configuration of the interface,
+	// which is applied using the cloud-init network configuration. When nil,
+	// the interface is configured using DHCP.
+	NetworkConfig *NetworkInterfaceNetworkConfig `codec:"network_config"`
+
+	// Discovery is the ordered list of strategies used to discover the
+	// addresses of the interface. When empty, the strategies configured for
+	// the provider are used.
+	Discovery []DiscoveryStrategy `codec:"discovery"`
+
+	// Isolation is set on the interface which attaches the VM to the network
+	// namespace of its allocation. It is generated by the network sub-system
+	// and cannot be set within the job specification.
+	Isolation *NetworkInterfaceIsolationConfig `codec:"-"`
+
+	// MAC is the hardware address assigned to the interface. When empty, a
+	// stable address is derived from the identity of the task, so the
+	// interface keeps the same address when the task is restarted.
+	MAC string `codec:"mac"`
+
+	// AssignedAddresses are the addresses assigned to the interface by the
+	// network sub-system before the VM is created. It cannot be set within
+	// the job specification.
+	AssignedAddresses *InterfaceAddresses `codec:"-"`
+
+	// IPv6 is set when the network the interface attaches to provides IPv6
+	// addressing, using DHCPv6 or router advertisements. It is resolved by
+	// the network sub-system and cannot be set within the job specification.
+	IPv6 bool `codec:"-"`
+}
+
+// Equal returns if the given NetworkInterfaceConfig is equal.
+func (n *NetworkInterfaceConfig) Equal(rhs *NetworkInterfaceConfig) bool {
+	if n == nil || rhs == nil {
+		return false
+	}
+
+	if !n.Bridge.Equal(rhs.Bridge) {
+		return false
+	}
+
+	if !n.Network.Equal(rhs.Network) {
+		return false
+	}
+
+	if !n.Macvtap.Equal(rhs.Macvtap) {
+		return false
+	}
+
+	if !n.User.Equal(rhs.User) {
+		return false
+	}
+
+	if n.Primary != rhs.Primary {
+		return false
+	}
+
+	if !n.NetworkConfig.Equal(rhs.NetworkConfig) {
+		return false
+	}
+
+	if !slices.Equal(n.Discovery, rhs.Discovery) {
+		return false
+	}
+
+	if !n.Isolation.Equal(rhs.Isolation) {
+		return false
+	}
+
+	if n.MAC != rhs.MAC {
+		return false
+	}
+
+	if !n.AssignedAddresses.Equal(rhs.AssignedAddresses) {
+		return false
+	}
+
+	if n.IPv6 != rhs.IPv6 {
+		return false
+	}
+
+	return true
+}
+
+// Bandwidth returns the inbound and outbound traffic limits of the interface.
+// Nil values are returned for directions which are not limited.
+func (n *NetworkInterfaceConfig) Bandwidth() (inbound, outbound *NetworkInterfaceBandwidthConfig) {
+	if attachment := n.Attachment(); attachment != nil {
+		return attachment.Inbound, attachment.Outbound
+	}
+
+	if n.Macvtap != nil {
+		return n.Macvtap.Inbound, n.Macvtap.Outbound
+	}
+
+	return nil, nil
+}
+
+// Bridged returns the bridge configuration of an interface attached to a
+// libvirt network, either by its bridge name or by its network name. For an
+// interface attached by network name, the returned configuration has no
+// bridge name, as it is only known once the network has been looked up. A
+// nil value is returned for all other interface types.
+func (n *NetworkInterfaceConfig) Bridged() *NetworkInterfaceBridgeConfig {
+	switch {
+	case n.Bridge != nil:
+		return n.Bridge
+	case n.Network != nil:
+		return n.Network.BridgeConfig("")
+	}
+
+	return nil
+}
+
+// Attachment returns the configuration shared by interfaces attached to a
+// libvirt network, either by their bridge name or by their network name. A
+// nil value is returned for all other interface types.
+func (n *NetworkInterfaceConfig) Attachment() *NetworkInterfaceAttachmentConfig {
+	switch {
+	case n.Bridge != nil:
+		return &n.Bridge.NetworkInterfaceAttachmentConfig
+	case n.Network != nil:
+		return &n.Network.NetworkInterfaceAttachmentConfig
+	}
+
+	return nil
+}
+
+// NetworkInterfaceBridgeConfig is the network object when a VM is attached to
+// a bridged network interface.
+type NetworkInterfaceBridgeConfig struct {
+
+	// Name is the name of the bridge interface to use. This relates to the
+	// output seen from commands such as "ip addr show" or "virsh net-info".
+	Name string `codec:"name"`
+
+	NetworkInterfaceAttachmentConfig
+
+	// OpenVSwitch indicates the bridge is an Open vSwitch bridge rather than
+	// a Linux bridge managed by a libvirt network. When nil, the bridge is
+	// a Linux bridge.
+	OpenVSwitch *NetworkInterfaceOpenVSwitchConfig `codec:"openvswitch"`
+}
+
+// Equal returns if the given NetworkInterfaceBridgeConfig is equal.
+func (n *NetworkInterfaceBridgeConfig) Equal(rhs *NetworkInterfaceBridgeConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return n.Name == rhs.Name &&
+		n.NetworkInterfaceAttachmentConfig.Equal(&rhs.NetworkInterfaceAttachmentConfig) &&
+		n.OpenVSwitch.Equal(rhs.OpenVSwitch)
+}
+
+// NetworkInterfaceAttachmentConfig contains the options of an interface
+// attached to a libvirt network, which are shared by interfaces attached by
+// bridge name and by network name.
+type NetworkInterfaceAttachmentConfig struct {
+
+	// Ports contains a list of port labels which will be exposed on the host
+	// via mapping to the network interface. These labels must exist within the
+	// job specification network block. Each entry can optionally include the
+	// protocol to forward in the form of "label/protocol", which defaults to
+	// TCP.
+	Ports []string `codec:"ports"`
+
+	// AdvertiseIPv6 indicates the IPv6 address of the interface should be
+	// returned to Nomad for service registration instead of the IPv4 address.
+	// This only has an effect when the interface is the primary interface.
+	AdvertiseIPv6 bool `codec:"advertise_ipv6"`
+
+	// IPAM indicates the driver assigns the IPv4 address of the interface
+	// from the DHCP range of the libvirt network, and reserves it before the
+	// VM is created, instead of discovering the address leased to the VM.
+	IPAM bool `codec:"ipam"`
+
+	// DNSAliases are additional names registered within the DNS of the
+	// libvirt network for the interface addresses, along with the hostname
+	// of the VM.
+	DNSAliases []string `codec:"dns_aliases"`
+
+	// Inbound and Outbound limit the traffic received and sent by the
+	// interface. When nil, the traffic is not limited.
+	Inbound  *NetworkInterfaceBandwidthConfig `codec:"inbound"`
+	Outbound *NetworkInterfaceBandwidthConfig `codec:"outbound"`
+
+	// Ingress restricts the source addresses which can connect to the
+	// forwarded ports. Ports without an entry can be reached from any source.
+	Ingress []*NetworkInterfaceIngressConfig `codec:"ingress"`
+
+	// Egress is the policy applied to connections initiated by the
+	// interface. When nil, all connections are allowed.
+	Egress *NetworkInterfaceEgressConfig `codec:"egress"`
+
+	// EgressIP is the host address used as the source of connections
+	// initiated by the interface, in place of the address chosen when the
+	// network masquerades the traffic. It is either an address assigned to
+	// the host, or the name of a Nomad host network the task has a port
+	// allocated within.
+	EgressIP string `codec:"egress_ip"`
+}
+
+// Equal returns if the given NetworkInterfaceAttachmentConfig is equal.
+func (n *NetworkInterfaceAttachmentConfig) Equal(rhs *NetworkInterfaceAttachmentConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	if slices.Compare(n.Ports, rhs.Ports) != 0 {
+		return false
+	}
+
+	if n.AdvertiseIPv6 != rhs.AdvertiseIPv6 {
+		return false
+	}
+
+	if n.IPAM != rhs.IPAM {
+		return false
+	}
+
+	if !slices.Equal(n.DNSAliases, rhs.DNSAliases) {
+		return false
+	}
+
+	if !n.Inbound.Equal(rhs.Inbound) || !n.Outbound.Equal(rhs.Outbound) {
+		return false
+	}
+
+	if !slices.EqualFunc(n.Ingress, rhs.Ingress, (*NetworkInterfaceIngressConfig).Equal) {
+		return false
+	}
+
+	if !n.Egress.Equal(rhs.Egress) {
+		return false
+	}
+
+	return n.EgressIP == rhs.EgressIP
+}
+
+// validate validates the attachment options, defaulting the egress policy
+// when it has not been set. The kind is the name of the interface block the
+// options belong to, and the network configuration is the guest network
+// configuration of the interface.
+func (n *NetworkInterfaceAttachmentConfig) validate(errPrefix, kind string,
+	netConfig *NetworkInterfaceNetworkConfig) error {
+
+	var mErr *multierror.Error
+
+	mErr = multierror.Append(mErr,
+		n.Inbound.validate(errPrefix, "inbound"),
+		n.Outbound.validate(errPrefix, "outbound"))
+
+	for _, alias := range n.DNSAliases {
+		if !validDNSName(alias) {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: invalid dns alias %q", errPrefix, errs.ErrInvalidConfiguration, alias))
+		}
+	}
+
+	if n.IPAM && netConfig.Static() {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: %s ipam can not be combined with network_config addresses",
+				errPrefix, errs.ErrInvalidConfiguration, kind))
+	}
+
+	// Only IPv4 addresses are assigned by the driver, so the address
+	// advertised must be the assigned address.
+	if n.IPAM && n.AdvertiseIPv6 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: %s ipam can not be combined with advertise_ipv6",
+				errPrefix, errs.ErrInvalidConfiguration, kind))
+	}
+
+	mErr = multierror.Append(mErr, n.Egress.validate(errPrefix))
+
+	// A value which is not an address is the name of a host network, which
+	// is resolved when the task starts.
+	if n.EgressIP != "" {
+		if addr, err := netip.ParseAddr(n.EgressIP); err == nil {
+			if addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: %s egress_ip %q must be a unicast host address",
+						errPrefix, errs.ErrInvalidConfiguration, kind, n.EgressIP))
+			}
+		} else if strings.ContainsAny(n.EgressIP, ":/ ") {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: %s egress_ip %q is not an address or host network name",
+					errPrefix, errs.ErrInvalidConfiguration, kind, n.EgressIP))
+		}
+	}
+
+	// The labels of the ports within a port range are only known once the
+	// ports have been reserved, so ingress entries which may refer to one
+	// are checked by ValidatePorts.
+	var hasRange bool
+	labels := make(map[string]struct{})
+	for _, entry := range n.Ports {
+		mapping, err := ParsePortMapping(entry)
+		if err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("%s %w", errPrefix, err))
+			continue
+		}
+		labels[mapping.Label] = struct{}{}
+
+		if mapping.IsRange() {
+			labels[mapping.LastLabel] = struct{}{}
+			hasRange = true
+		}
+	}
+
+	ingressPorts := make(map[string]struct{})
+	for _, ingress := range n.Ingress {
+		if _, ok := labels[ingress.Port]; !ok && !hasRange {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: ingress port %q is not within the %s ports",
+					errPrefix, errs.ErrInvalidConfiguration, ingress.Port, kind))
+		}
+
+		if _, ok := ingressPorts[ingress.Port]; ok {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: ingress for port %q is defined more than once",
+					errPrefix, errs.ErrInvalidConfiguration, ingress.Port))
+		}
+		ingressPorts[ingress.Port] = struct{}{}
+
+		if len(ingress.CIDRs) == 0 {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: ingress for port %q requires at least one cidr",
+					errPrefix, errs.ErrInvalidConfiguration, ingress.Port))
+		}
+
+		for _, cidr := range ingress.CIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: ingress for port %q has invalid cidr %q",
+						errPrefix, errs.ErrInvalidConfiguration, ingress.Port, cidr))
+			}
+		}
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// IngressCIDRs returns the source address ranges which can connect to the
+// ports forwarded by the passed mapping. A port range is forwarded as a
+// whole, so an ingress entry for any port within the range applies to every
+// port of the range. A nil value is returned when the ports can be reached
+// from any source.
+func (n *NetworkInterfaceAttachmentConfig) IngressCIDRs(mapping PortMapping, ports structs.AllocatedPorts) []string {
+	for _, ingress := range n.Ingress {
+		if mapping.Contains(ingress.Port, ports) {
+			return ingress.CIDRs
+		}
+	}
+
+	return nil
+}
+
+// EgressAddress returns the host address used as the source of connections
+// initiated by the interface. When the configured value is not an address, it
+// is resolved to the host address of the ports allocated to the task within
+// the host network of that name. An empty value is returned when no egress
+// address is configured.
+func (n *NetworkInterfaceAttachmentConfig) EgressAddress(res *drivers.Resources) (string, error) {
+	if n.EgressIP == "" {
+		return "", nil
+	}
+
+	if addr, err := netip.ParseAddr(n.EgressIP); err == nil {
+		return addr.Unmap().String(), nil
+	}
+
+	if res != nil && res.Ports != nil {
+		hostNetworks := HostNetworks(res)
+		for _, label := range slices.Sorted(maps.Keys(hostNetworks)) {
+			if hostNetworks[label] != n.EgressIP {
+				continue
+			}
+
+			if mapping, ok := res.Ports.Get(label); ok && mapping.HostIP != "" {
+				return mapping.HostIP, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w: egress_ip host network %q has no ports allocated to the task",
+		errs.ErrInvalidConfiguration, n.EgressIP)
+}
+
+// NetworkInterfaceIngressConfig restricts the source addresses which can
+// connect to a forwarded port of a bridged network interface.
+type NetworkInterfaceIngressConfig struct {
+
+	// Port is the label of the port, which must be within the bridge ports.
+	Port string `codec:"port"`
+
+	// CIDRs are the source address ranges, including prefix length, which
+	// can connect to the port.
+	CIDRs []string `codec:"cidrs"`
+}
+
+// Equal returns if the given NetworkInterfaceIngressConfig is equal.
+func (n *NetworkInterfaceIngressConfig) Equal(rhs *NetworkInterfaceIngressConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return n.Port == rhs.Port && slices.Equal(n.CIDRs, rhs.CIDRs)
+}
+
+// NetworkInterfaceEgressConfig is the policy applied to connections initiated
+// by a bridged network interface. Responses to connections accepted by the
+// interface are always allowed.
+type NetworkInterfaceEgressConfig struct {
+
+	// Policy is the egress policy of the interface. Accepted values are:
+	// "allow_all", "deny_all", and "allow_list". Defaults to "allow_all" when
+	// not specified.
+	Policy EgressPolicy `codec:"policy"`
+
+	// Allow is the list of destinations which can be reached when the policy
+	// is "allow_list".
+	Allow []*NetworkInterfaceEgressRule `codec:"allow"`
+}
+
+// Equal returns if the given NetworkInterfaceEgressConfig is equal.
+func (n *NetworkInterfaceEgressConfig) Equal(rhs *NetworkInterfaceEgressConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	if n.Policy != rhs.Policy {
+		return false
+	}
+
+	return slices.EqualFunc(n.Allow, rhs.Allow, (*NetworkInterfaceEgressRule).Equal)
+}
+
+// Restricted returns if the policy restricts the connections initiated by
+// the interface.
+func (n *NetworkInterfaceEgressConfig) Restricted() bool {
+	return n != nil && n.Policy != "" && n.Policy != EgressPolicyAllowAll
+}
+
+// validate validates the egress configuration, defaulting the policy when it
+// has not been set.
+func (n *NetworkInterfaceEgressConfig) validate(errPrefix string) error {
+	if n == nil {
+		return nil
+	}
+
+	if n.Policy == "" {
+		n.Policy = EgressPolicyAllowAll
+	}
+
+	if !slices.Contains(validEgressPolicies, n.Policy) {
+		validPolicies := make([]string, len(validEgressPolicies))
+		for i, v := range validEgressPolicies {
+			validPolicies[i] = string(v)
+		}
+		return fmt.Errorf("%s %w: egress has invalid policy %q; must be one of: %s",
+			errPrefix, errs.ErrInvalidConfiguration, n.Policy, strings.Join(validPolicies, ", "))
+	}
+
+	if n.Policy != EgressPolicyAllowList {
+		if len(n.Allow) > 0 {
+			return fmt.Errorf("%s %w: egress allow is only supported with the %q policy",
+				errPrefix, errs.ErrInvalidConfiguration, EgressPolicyAllowList)
+		}
+		return nil
+	}
+
+	if len(n.Allow) == 0 {
+		return fmt.Errorf("%s %w: egress %q policy requires at least one allow entry",
+			errPrefix, errs.ErrInvalidConfiguration, EgressPolicyAllowList)
+	}
+
+	var mErr *multierror.Error
+	for i, rule := range n.Allow {
+		mErr = multierror.Append(mErr, rule.validate(fmt.Sprintf("%s egress allow[%d]", errPrefix, i+1)))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// NetworkInterfaceEgressRule is a destination which a bridged network
+// interface is allowed to connect to.
+type NetworkInterfaceEgressRule struct {
+
+	// CIDR is the destination address range, including prefix length.
+	CIDR string `codec:"cidr"`
+
+	// Port is the destination port. When zero, all ports are allowed.
+	Port int `codec:"port"`
+
+	// Protocol is the transport protocol. When empty, all protocols are
+	// allowed, unless a port is set in which case it defaults to TCP.
+	Protocol PortProtocol `codec:"protocol"`
+}
+
+// Equal returns if the given NetworkInterfaceEgressRule is equal.
+func (n *NetworkInterfaceEgressRule) Equal(rhs *NetworkInterfaceEgressRule) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return *n == *rhs
+}
+
+// validate validates the egress rule, defaulting the protocol when a port has
+// been set.
+func (n *NetworkInterfaceEgressRule) validate(errPrefix string) error {
+	var mErr *multierror.Error
+
+	if _, err := netip.ParsePrefix(n.CIDR); err != nil {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: invalid cidr %q; must include the prefix length",
+				errPrefix, errs.ErrInvalidConfiguration, n.CIDR))
+	}
+
+	if n.Port < 0 || n.Port > 65535 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: invalid port %d", errPrefix, errs.ErrInvalidConfiguration, n.Port))
+	}
+
+	if n.Port > 0 && n.Protocol == "" {
+		n.Protocol = PortProtocolTCP
+	}
+
+	if n.Protocol != "" && !slices.Contains(validPortProtocols, n.Protocol) {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: invalid protocol %q", errPrefix, errs.ErrInvalidConfiguration, n.Protocol))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// NetworkInterfaceOpenVSwitchConfig is the configuration of the port added to
+// an Open vSwitch bridge for a bridged network interface.
+type NetworkInterfaceOpenVSwitchConfig struct {
+
+	// VLAN is the VLAN tag of the port. When the port is a trunk, traffic
+	// on this VLAN is sent untagged to the VM. When zero, the port is not
+	// tagged.
+	VLAN int `codec:"vlan"`
+
+	// Trunk is the list of VLANs which are sent tagged to the VM. When
+	// empty, the port is an access port.
+	Trunk []int `codec:"trunk"`
+
+	// InterfaceID is the UUID identifying the port to the Open vSwitch
+	// controller. When empty, libvirt generates one.
+	InterfaceID string `codec:"interface_id"`
+}
+
+// Equal returns if the given NetworkInterfaceOpenVSwitchConfig is equal.
+func (n *NetworkInterfaceOpenVSwitchConfig) Equal(rhs *NetworkInterfaceOpenVSwitchConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return n.VLAN == rhs.VLAN &&
+		slices.Equal(n.Trunk, rhs.Trunk) &&
+		n.InterfaceID == rhs.InterfaceID
+}
+
+// validate validates the Open vSwitch port configuration.
+func (n *NetworkInterfaceOpenVSwitchConfig) validate(errPrefix string) error {
+	if n == nil {
+		return nil
+	}
+
+	var mErr *multierror.Error
+
+	if n.VLAN != 0 && (n.VLAN < minVLANID || n.VLAN > maxVLANID) {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: openvswitch vlan %d must be between %d and %d",
+				errPrefix, errs.ErrInvalidConfiguration, n.VLAN, minVLANID, maxVLANID))
+	}
+
+	seen := make(map[int]struct{}, len(n.Trunk))
+	for _, id := range n.Trunk {
+		if id < minVLANID || id > maxVLANID {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: openvswitch trunk vlan %d must be between %d and %d",
+					errPrefix, errs.ErrInvalidConfiguration, id, minVLANID, maxVLANID))
+		}
+
+		if _, ok := seen[id]; ok || id == n.VLAN {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: openvswitch vlan %d is defined more than once",
+					errPrefix, errs.ErrInvalidConfiguration, id))
+		}
+		seen[id] = struct{}{}
+	}
+
+	if n.InterfaceID != "" && !interfaceIDPattern.MatchString(n.InterfaceID) {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: openvswitch interface_id %q must be a UUID",
+				errPrefix, errs.ErrInvalidConfiguration, n.InterfaceID))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// NetworkInterfaceVirtualNetworkConfig is the network object when a VM is
+// attached to a libvirt network by its name. Unlike the bridge configuration,
+// the network does not need a predictable bridge name, so networks in any
+// forward mode can be targeted.
+type NetworkInterfaceVirtualNetworkConfig struct {
+
+	// Name is the name of the libvirt network to use. This relates to the
+	// output seen from commands such as "virsh net-list".
+	Name string `codec:"name"`
+
+	NetworkInterfaceAttachmentConfig
+}
+
+// Equal returns if the given NetworkInterfaceVirtualNetworkConfig is equal.
+func (n *NetworkInterfaceVirtualNetworkConfig) Equal(rhs *NetworkInterfaceVirtualNetworkConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return n.Name == rhs.Name &&
+		n.NetworkInterfaceAttachmentConfig.Equal(&rhs.NetworkInterfaceAttachmentConfig)
+}
+
+// BridgeConfig returns the network configuration as the configuration of an
+// interface attached to the passed bridge, which is the bridge of the libvirt
+// network. This allows the interface to be handled in the same way as an
+// interface attached by bridge name.
+func (n *NetworkInterfaceVirtualNetworkConfig) BridgeConfig(bridgeName string) *NetworkInterfaceBridgeConfig {
+	return &NetworkInterfaceBridgeConfig{
+		Name:                             bridgeName,
+		NetworkInterfaceAttachmentConfig: n.NetworkInterfaceAttachmentConfig,
+	}
+}
+
+// NetworkInterfaceMacvtapConfig is the network object when a VM is attached to
+// a macvtap interface.
+type NetworkInterfaceMacvtapConfig struct {
+
+	// Device is the name of the lower (physical or virtual) network device
+	// that the macvtap interface will be created on top of. This should match
+	// an interface visible in "ip addr show" output (e.g. "eth0", "ens3").
+	Device string `codec:"device"`
+
+	// Mode controls the traffic isolation policy of the macvtap interface.
+	// Accepted values are: "bridge", "private", "vepa", and "passthrough".
+	// Defaults to "bridge" when not specified.
+	Mode MacvtapMode `codec:"mode"`
+
+	// Inbound and Outbound limit the traffic received and sent by the
+	// interface. When nil, the traffic is not limited.
+	Inbound  *NetworkInterfaceBandwidthConfig `codec:"inbound"`
+	Outbound *NetworkInterfaceBandwidthConfig `codec:"outbound"`
+}
+
+// Equal returns if the given NetworkInterfaceMacvtapConfig is equal.
+func (n *NetworkInterfaceMacvtapConfig) Equal(rhs *NetworkInterfaceMacvtapConfig) bool {
+	if n == nil && rhs == nil {
+		return true
+	}
+
+	if n == nil || rhs == nil {
+		return false
+	}
+
+	if n.Device != rhs.Device {
+		return false
+	}
+
+	if n.Mode != rhs.Mode {
+		return false
+	}
+
+	if !n.Inbound.Equal(rhs.Inbound) || !n.Outbound.Equal(rhs.Outbound) {
+		return false
+	}
+
+	return true
+}
+
+// NetworkInterfaceUserConfig is the network object when a VM is attached to a
+// user-mode network interface. User-mode networking requires no privileges on
+// the host, so it can be used when connected to the session daemon.
+type NetworkInterfaceUserConfig struct {
+
+	// Backend is the implementation providing the user-mode networking.
+	// Accepted values are: "passt" and "slirp". Defaults to "passt" when not
+	// specified.
+	Backend UserBackend `codec:"backend"`
+
+	// Ports contains a list of port labels which will be forwarded from the
+	// host to the interface. These labels must exist within the job
+	// specification network block. Each entry can optionally include the
+	// protocol to forward in the form of "label/protocol", which defaults to
+	// TCP. Port forwarding requires the passt backend.
+	Ports []string `codec:"ports"`
+
+	// Forwards are the port forwards of the interface, resolved from the
+	// ports reserved by Nomad before the VM is created. It cannot be set
+	// within the job specification.
+	Forwards []*UserPortForward `codec:"-"`
+}
+
+// Equal returns if the given NetworkInterfaceUserConfig is equal.
+func (n *NetworkInterfaceUserConfig) Equal(rhs *NetworkInterfaceUserConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	if n.Backend != rhs.Backend {
+		return false
+	}
+
+	if !slices.Equal(n.Ports, rhs.Ports) {
+		return false
+	}
+
+	return slices.EqualFunc(n.Forwards, rhs.Forwards, (*UserPortForward).Equal)
+}
+
+// validate validates the user configuration, defaulting the backend when it
+// has not been set.
+func (n *NetworkInterfaceUserConfig) validate(errPrefix string) error {
+	var mErr *multierror.Error
+
+	if n.Backend == "" {
+		n.Backend = UserBackendPasst
+	}
+
+	if !slices.Contains(validUserBackends, n.Backend) {
+		validBackends := make([]string, len(validUserBackends))
+		for i, v := range validUserBackends {
+			validBackends[i] = string(v)
+		}
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: user has invalid backend %q; must be one of: %s",
+				errPrefix, errs.ErrInvalidConfiguration, n.Backend, strings.Join(validBackends, ", ")))
+	}
+
+	if len(n.Ports) > 0 && n.Backend != UserBackendPasst {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: user ports are only supported with the %q backend",
+				errPrefix, errs.ErrInvalidConfiguration, UserBackendPasst))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// ResolveForwards sets the port forwards of the interface from the ports
+// reserved by Nomad. Ports without a reservation are skipped, as there is no
+// host port to forward.
+func (n *NetworkInterfaceUserConfig) ResolveForwards(res *drivers.Resources) {
+	n.Forwards = nil
+	if res == nil || res.Ports == nil {
+		return
+	}
+
+	for _, entry := range n.Ports {
+		mapping, err := ParsePortMapping(entry)
+		if err != nil {
+			continue
+		}
+
+		reservedPort, ok := res.Ports.Get(mapping.Label)
+		if !ok {
+			continue
+		}
+
+		n.Forwards = append(n.Forwards, &UserPortForward{
+			Protocol:  mapping.Protocol,
+			Address:   reservedPort.HostIP,
+			HostPort:  reservedPort.Value,
+			GuestPort: GuestPort(reservedPort),
+		})
+	}
+}
+
+// UserPortForward is a port forwarded from the host to a user interface.
+type UserPortForward struct {
+	Protocol PortProtocol
+
+	// Address is the host address the port is forwarded from. When empty,
+	// the port is forwarded from all the host addresses.
+	Address string
+
+	// HostPort is the port reserved on the host and GuestPort the port the
+	// traffic is forwarded to within the VM.
+	HostPort  int
+	GuestPort int
+}
+
+// Equal returns if the given UserPortForward is equal.
+func (u *UserPortForward) Equal(rhs *UserPortForward) bool {
+	if u == nil || rhs == nil {
+		return u == rhs
+	}
+
+	return *u == *rhs
+}
+
+// NetworkInterfaceBandwidthConfig limits the traffic of a network interface in
+// a single direction. Rates are in kilobytes per second and the burst size is
+// in kilobytes, matching the libvirt bandwidth configuration.
+type NetworkInterfaceBandwidthConfig struct {
+
+	// Average is the average rate the traffic is shaped to.
+	Average int `codec:"average"`
+
+	// Peak is the maximum rate the traffic can be sent at when bursting.
+	Peak int `codec:"peak"`
+
+	// Burst is the amount of data which can be sent at the peak rate.
+	Burst int `codec:"burst"`
+}
+
+// Equal returns if the given NetworkInterfaceBandwidthConfig is equal.
+func (n *NetworkInterfaceBandwidthConfig) Equal(rhs *NetworkInterfaceBandwidthConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	return *n == *rhs
+}
+
+// validate validates the bandwidth configuration of a single direction.
+func (n *NetworkInterfaceBandwidthConfig) validate(errPrefix, direction string) error {
+	if n == nil {
+		return nil
+	}
+
+	if n.Average <= 0 {
+		return fmt.Errorf("%s %w: %s average must be greater than zero",
+			errPrefix, errs.ErrInvalidConfiguration, direction)
+	}
+
+	if n.Peak < 0 || n.Burst < 0 {
+		return fmt.Errorf("%s %w: %s peak and burst can not be negative",
+			errPrefix, errs.ErrInvalidConfiguration, direction)
+	}
+
+	if n.Peak > 0 && n.Peak < n.Average {
+		return fmt.Errorf("%s %w: %s peak must not be less than average",
+			errPrefix, errs.ErrInvalidConfiguration, direction)
+	}
+
+	return nil
+}
+
+// NetworkInterfaceNetworkConfig is the guest network configuration of a VM
+// network interface.
+type NetworkInterfaceNetworkConfig struct {
+
+	// Addresses is the list of addresses, including prefix length, which are
+	// statically assigned to the interface. When set, DHCP is disabled on the
+	// interface and the driver does not perform lease discovery.
+	Addresses []string `codec:"addresses"`
+
+	// Gateway is the IPv4 address used as the default gateway.
+	Gateway string `codec:"gateway"`
+
+	// Gateway6 is the IPv6 address used as the default gateway.
+	Gateway6 string `codec:"gateway6"`
+
+	// Routes contains additional routes which are added to the interface.
+	Routes []*NetworkInterfaceRouteConfig `codec:"route"`
+
+	// MTU is the maximum transmission unit of the interface. When zero, the
+	// guest default is used.
+	MTU int `codec:"mtu"`
+
+	// Nameservers is the list of DNS server addresses used by the interface.
+	Nameservers []string `codec:"nameservers"`
+
+	// SearchDomains is the list of DNS search domains used by the interface.
+	SearchDomains []string `codec:"search_domains"`
+}
+
+// Equal returns if the given NetworkInterfaceNetworkConfig is equal.
+func (n *NetworkInterfaceNetworkConfig) Equal(rhs *NetworkInterfaceNetworkConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
+	}
+
+	if slices.Compare(n.Addresses, rhs.Addresses) != 0 {
+		return false
+	}
+
+	if n.Gateway != rhs.Gateway || n.Gateway6 != rhs.Gateway6 {
+		return false
+	}
+
+	if !slices.EqualFunc(n.Routes, rhs.Routes, (*NetworkInterfaceRouteConfig).Equal) {
+		return false
+	}
+
+	if n.MTU != rhs.MTU {
+		return false
+	}
+
+	if slices.Compare(n.Nameservers, rhs.Nameservers) != 0 {
 		return false
 	}
 
-	for i, lhs := range n {
-		if !lhs.Equal(rhs[i]) {
-			return false
-		}
+	if slices.Compare(n.SearchDomains, rhs.SearchDomains) != 0 {
+		return false
 	}
 
 	return true
 }
 
-// NetworkInterfaceConfig contains all the possible network interface options
-// that a VM currently supports via the Nomad driver.
-type NetworkInterfaceConfig struct {
-	Bridge  *NetworkInterfaceBridgeConfig  `codec:"bridge"`
-	Macvtap *NetworkInterfaceMacvtapConfig `codec:"macvtap"`
+// Static returns if the interface is statically addressed.
+func (n *NetworkInterfaceNetworkConfig) Static() bool {
+	return n != nil && len(n.Addresses) > 0
 }
 
-// Equal returns if the given NetworkInterfaceConfig is equal.
-func (n *NetworkInterfaceConfig) Equal(rhs *NetworkInterfaceConfig) bool {
-	if n == nil || rhs == nil {
-		return false
+// StaticAddresses returns the first statically assigned address of each
+// address family. A nil value is returned when the interface is not
+// statically addressed.
+func (n *NetworkInterfaceNetworkConfig) StaticAddresses() *InterfaceAddresses {
+	if !n.Static() {
+		return nil
 	}
 
-	if !n.Bridge.Equal(rhs.Bridge) {
-		return false
-	}
+	addrs := &InterfaceAddresses{}
+	for _, entry := range n.Addresses {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			continue
+		}
 
-	if !n.Macvtap.Equal(rhs.Macvtap) {
-		return false
+		switch {
+		case prefix.Addr().Is4() && addrs.IPv4 == "":
+			addrs.IPv4 = prefix.Addr().String()
+		case prefix.Addr().Is6() && addrs.IPv6 == "":
+			addrs.IPv6 = prefix.Addr().String()
+		}
 	}
 
-	return true
+	return addrs
 }
 
-// NetworkInterfaceBridgeConfig is the network object when a VM is attached to
-// a bridged network interface.
-type NetworkInterfaceBridgeConfig struct {
+// validate ensures the network configuration is valid. The passed prefix is
+// added to any errors returned.
+func (n *NetworkInterfaceNetworkConfig) validate(errPrefix string) error {
+	var mErr *multierror.Error
 
-	// Name is the name of the bridge interface to use. This relates to the
-	// output seen from commands such as "ip addr show" or "virsh net-info".
-	Name string `codec:"name"`
+	for _, entry := range n.Addresses {
+		if _, err := netip.ParsePrefix(entry); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config has invalid address %q; must include the prefix length",
+					errPrefix, errs.ErrInvalidConfiguration, entry))
+		}
+	}
 
-	// Ports contains a list of port labels which will be exposed on the host
-	// via mapping to the network interface. These labels must exist within the
-	// job specification network block.
-	Ports []string `codec:"ports"`
-}
+	if n.Gateway != "" {
+		if addr, err := netip.ParseAddr(n.Gateway); err != nil || !addr.Is4() {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config has invalid gateway %q; must be an IPv4 address",
+					errPrefix, errs.ErrInvalidConfiguration, n.Gateway))
+		}
+	}
 
-// Equal returns if the given NetworkInterfaceBridgeConfig is equal.
-func (n *NetworkInterfaceBridgeConfig) Equal(rhs *NetworkInterfaceBridgeConfig) bool {
-	if n == nil && rhs == nil {
-		return true
+	if n.Gateway6 != "" {
+		if addr, err := netip.ParseAddr(n.Gateway6); err != nil || !addr.Is6() {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config has invalid gateway6 %q; must be an IPv6 address",
+					errPrefix, errs.ErrInvalidConfiguration, n.Gateway6))
+		}
 	}
 
-	if n == nil || rhs == nil {
-		return false
+	for i, route := range n.Routes {
+		if _, err := netip.ParsePrefix(route.To); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config route[%d] has invalid destination %q",
+					errPrefix, errs.ErrInvalidConfiguration, i+1, route.To))
+		}
+		if _, err := netip.ParseAddr(route.Via); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config route[%d] has invalid gateway %q",
+					errPrefix, errs.ErrInvalidConfiguration, i+1, route.Via))
+		}
+		if route.Metric < 0 {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config route[%d] metric can not be negative",
+					errPrefix, errs.ErrInvalidConfiguration, i+1))
+		}
 	}
 
-	if n.Name != rhs.Name {
-		return false
+	if n.MTU != 0 && (n.MTU < minMTU || n.MTU > maxMTU) {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%s %w: network_config has invalid mtu %d; must be between %d and %d",
+				errPrefix, errs.ErrInvalidConfiguration, n.MTU, minMTU, maxMTU))
 	}
 
-	if slices.Compare(n.Ports, rhs.Ports) != 0 {
-		return false
+	for _, entry := range n.Nameservers {
+		if _, err := netip.ParseAddr(entry); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network_config has invalid nameserver %q",
+					errPrefix, errs.ErrInvalidConfiguration, entry))
+		}
 	}
 
-	return true
+	return mErr.ErrorOrNil()
 }
 
-// NetworkInterfaceMacvtapConfig is the network object when a VM is attached to
-// a macvtap interface.
-type NetworkInterfaceMacvtapConfig struct {
+// NetworkInterfaceRouteConfig is a route added to a VM network interface.
+type NetworkInterfaceRouteConfig struct {
 
-	// Device is the name of the lower (physical or virtual) network device
-	// that the macvtap interface will be created on top of. This should match
-	// an interface visible in "ip addr show" output (e.g. "eth0", "ens3").
-	Device string `codec:"device"`
+	// To is the destination of the route, including prefix length.
+	To string `codec:"to"`
 
-	// Mode controls the traffic isolation policy of the macvtap interface.
-	// Accepted values are: "bridge", "private", "vepa", and "passthrough".
-	// Defaults to "bridge" when not specified.
-	Mode MacvtapMode `codec:"mode"`
-}
+	// Via is the address of the gateway for the route.
+	Via string `codec:"via"`
 
-// Equal returns if the given NetworkInterfaceMacvtapConfig is equal.
-func (n *NetworkInterfaceMacvtapConfig) Equal(rhs *NetworkInterfaceMacvtapConfig) bool {
-	if n == nil && rhs == nil {
-		return true
-	}
+	// Metric is the metric of the route. When zero, the guest default is used.
+	Metric int `codec:"metric"`
+}
 
+// Equal returns if the given NetworkInterfaceRouteConfig is equal.
+func (n *NetworkInterfaceRouteConfig) Equal(rhs *NetworkInterfaceRouteConfig) bool {
 	if n == nil || rhs == nil {
-		return false
+		return n == rhs
 	}
 
-	if n.Device != rhs.Device {
-		return false
-	}
+	return *n == *rhs
+}
 
-	if n.Mode != rhs.Mode {
-		return false
+// NetworkInterfaceIsolationConfig is the network object when a VM is attached
+// to the network namespace of its allocation.
+type NetworkInterfaceIsolationConfig struct {
+
+	// Device is the name of the host device linked to the network namespace,
+	// which the VM interface is attached to.
+	Device string
+
+	// Address is the address, including prefix length, which the VM must
+	// configure on the interface.
+	Address string
+
+	// Gateway is the address the VM must use as its default gateway.
+	Gateway string
+}
+
+// Equal returns if the given NetworkInterfaceIsolationConfig is equal.
+func (n *NetworkInterfaceIsolationConfig) Equal(rhs *NetworkInterfaceIsolationConfig) bool {
+	if n == nil || rhs == nil {
+		return n == rhs
 	}
 
-	return true
+	return *n == *rhs
 }
 
 // Validate ensures the NetworkInterfaces is a valid object supported by the
@@ -173,34 +1482,167 @@ func (n *NetworkInterfacesConfig) Validate() error {
 
 	var mErr *multierror.Error
 
-	// The driver only currently supports a single network interface per VM due
-	// to constraints on Nomad's network mapping handling and the driver
-	// itself.
-	if len(*n) > 1 {
-		mErr = multierror.Append(mErr,
-			fmt.Errorf("%w: only one network interface can be configured", errs.ErrInvalidConfiguration))
-	}
+	// Track the primary interfaces and the port mappings which have been
+	// seen, so duplicates across interfaces can be detected. A port label and
+	// protocol can only be mapped to a single interface, as the host port can
+	// only be forwarded to a single destination. Hardware addresses must also
+	// be unique across the interfaces.
+	var primaries int
+	portLabels := make(portMappings)
+	hwaddrs := make(map[string]int)
 
 	// Iterate the network interfaces and validate each object to be correct
 	// according to their type.
-	// NOTE: only a single interface is allowed currently, but assume that will change.
 	for i, netInterface := range *n {
 		errPrefix := fmt.Sprintf("network_interface[%d] -", i+1)
+
+		if netInterface.Primary {
+			primaries++
+		}
+
 		if netInterface.Bridge != nil && netInterface.Macvtap != nil {
 			mErr = multierror.Append(mErr,
 				fmt.Errorf("%s %w: bridge and macvtap are mutually exclusive", errPrefix, errs.ErrInvalidConfiguration))
 			continue
 		}
 
-		if netInterface.Bridge != nil {
-			mErr = multierror.Append(mErr, errs.MissingAttribute("bridge.name",
-				netInterface.Bridge.Name, errs.WithPrefix(errPrefix)))
+		if netInterface.User != nil && (netInterface.Bridge != nil || netInterface.Macvtap != nil) {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: user can not be combined with bridge or macvtap", errPrefix, errs.ErrInvalidConfiguration))
+			continue
+		}
+
+		if netInterface.Network != nil &&
+			(netInterface.Bridge != nil || netInterface.Macvtap != nil || netInterface.User != nil) {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: network can not be combined with bridge, macvtap or user", errPrefix, errs.ErrInvalidConfiguration))
+			continue
+		}
+
+		if netInterface.Isolation != nil &&
+			(netInterface.Bridge != nil || netInterface.Network != nil || netInterface.Macvtap != nil) {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: isolation can not be combined with bridge, network or macvtap", errPrefix, errs.ErrInvalidConfiguration))
+			continue
+		}
+
+		if netInterface.MAC != "" {
+			if err := ValidateHwaddr(netInterface.MAC); err != nil {
+				mErr = multierror.Append(mErr, fmt.Errorf("%s %w", errPrefix, err))
+			} else if idx, ok := hwaddrs[strings.ToLower(netInterface.MAC)]; ok {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: mac %q is already used by network_interface[%d]",
+						errPrefix, errs.ErrInvalidConfiguration, netInterface.MAC, idx))
+			} else {
+				hwaddrs[strings.ToLower(netInterface.MAC)] = i + 1
+			}
+		}
+
+		if netInterface.NetworkConfig != nil {
+			mErr = multierror.Append(mErr, netInterface.NetworkConfig.validate(errPrefix))
+		}
+
+		if err := ValidateDiscoveryStrategies(netInterface.Discovery); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("%s %w", errPrefix, err))
+		}
+
+		// The static strategy can only discover the addresses which have
+		// been configured for the interface.
+		if slices.Contains(netInterface.Discovery, DiscoveryStrategyStatic) &&
+			netInterface.NetworkConfig.StaticAddresses() == nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: static discovery requires network_config addresses", errPrefix, errs.ErrInvalidConfiguration))
+		}
+
+		// Interfaces attached by network name support the same options as
+		// those attached by bridge name, other than Open vSwitch, so both are
+		// validated using the shared attachment configuration.
+		if attachment := netInterface.Attachment(); attachment != nil {
+			kind := "bridge"
+			if netInterface.Network != nil {
+				kind = "network"
+				mErr = multierror.Append(mErr, errs.MissingAttribute("network.name",
+					netInterface.Network.Name, errs.WithPrefix(errPrefix)))
+			} else {
+				mErr = multierror.Append(mErr, errs.MissingAttribute("bridge.name",
+					netInterface.Bridge.Name, errs.WithPrefix(errPrefix)))
+			}
+
+			mErr = multierror.Append(mErr,
+				attachment.validate(errPrefix, kind, netInterface.NetworkConfig),
+				portLabels.add(errPrefix, attachment.Ports, i+1))
+		}
+
+		// Open vSwitch bridges are not managed by a libvirt network, so the
+		// options which require the network are not supported.
+		if netInterface.Bridge != nil && netInterface.Bridge.OpenVSwitch != nil {
+			bridge := netInterface.Bridge
+			mErr = multierror.Append(mErr, bridge.OpenVSwitch.validate(errPrefix))
+
+			if bridge.IPAM {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: bridge ipam is not supported with openvswitch",
+						errPrefix, errs.ErrInvalidConfiguration))
+			}
+
+			if len(bridge.DNSAliases) > 0 {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: bridge dns_aliases are not supported with openvswitch",
+						errPrefix, errs.ErrInvalidConfiguration))
+			}
+
+			if slices.Contains(netInterface.Discovery, DiscoveryStrategyDHCPLease) {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: %q discovery is not supported with openvswitch",
+						errPrefix, errs.ErrInvalidConfiguration, DiscoveryStrategyDHCPLease))
+			}
+		}
+
+		// The addresses of a user-mode interface are private to the VM and
+		// there is no host device, so only static addresses can be reported.
+		if netInterface.User != nil {
+			mErr = multierror.Append(mErr, netInterface.User.validate(errPrefix))
+
+			for _, strategy := range netInterface.Discovery {
+				if strategy != DiscoveryStrategyStatic {
+					mErr = multierror.Append(mErr,
+						fmt.Errorf("%s %w: %q discovery is not supported with user",
+							errPrefix, errs.ErrInvalidConfiguration, strategy))
+				}
+			}
+
+			for _, entry := range netInterface.User.Ports {
+				mapping, err := ParsePortMapping(entry)
+				if err != nil {
+					mErr = multierror.Append(mErr, fmt.Errorf("%s %w", errPrefix, err))
+					continue
+				}
+
+				if mapping.Protocol == PortProtocolSCTP {
+					mErr = multierror.Append(mErr,
+						fmt.Errorf("%s %w: port %q uses protocol %q which is not supported with user",
+							errPrefix, errs.ErrInvalidConfiguration, entry, mapping.Protocol))
+					continue
+				}
+
+				if mapping.IsRange() {
+					mErr = multierror.Append(mErr,
+						fmt.Errorf("%s %w: port range %q is not supported with user",
+							errPrefix, errs.ErrInvalidConfiguration, entry))
+				}
+			}
+
+			mErr = multierror.Append(mErr, portLabels.add(errPrefix, netInterface.User.Ports, i+1))
 		}
 
 		if netInterface.Macvtap != nil {
 			mErr = multierror.Append(mErr, errs.MissingAttribute("macvtap.device",
 				netInterface.Macvtap.Device, errs.WithPrefix(errPrefix)))
 
+			mErr = multierror.Append(mErr,
+				netInterface.Macvtap.Inbound.validate(errPrefix, "inbound"),
+				netInterface.Macvtap.Outbound.validate(errPrefix, "outbound"))
+
 			// Default the mode to bridge when unset, matching common macvtap
 			// usage and libvirt's own default behaviour.
 			if netInterface.Macvtap.Mode == "" {
@@ -221,37 +1663,300 @@ func (n *NetworkInterfacesConfig) Validate() error {
 		}
 	}
 
+	if primaries > 1 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf("%w: only one network interface can be marked as primary", errs.ErrInvalidConfiguration))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// portMappings tracks the port mappings of the network interfaces, keyed by
+// the mapping, so a port label and protocol mapped by more than one
+// interface can be detected. The value is the index of the interface which
+// mapped the port, starting from one.
+type portMappings map[string]int
+
+// add records the port mappings of the interface with the passed index,
+// returning an error for each mapping already recorded by another interface.
+// Entries which can not be parsed are skipped, as they are reported when the
+// interface is validated.
+func (p portMappings) add(errPrefix string, entries []string, idx int) error {
+	var mErr *multierror.Error
+
+	for _, entry := range entries {
+		mapping, err := ParsePortMapping(entry)
+		if err != nil {
+			continue
+		}
+
+		if other, ok := p[mapping.String()]; ok {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf("%s %w: port %q is already mapped by network_interface[%d]",
+					errPrefix, errs.ErrInvalidConfiguration, entry, other))
+			continue
+		}
+		p[mapping.String()] = idx
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// ValidatePorts validates the port mappings of the network interfaces against
+// the ports reserved for the task. Each host port and protocol can only be
+// forwarded to a single destination, so the ports within a port range must
+// not overlap the ports mapped by any other entry. Ingress entries must refer
+// to a port mapped by their interface, and only a single entry can restrict
+// the ports of a port range.
+//
+// Entries which can not be parsed, and labels or port ranges which have not
+// been reserved, are skipped as they are reported elsewhere.
+func (n NetworkInterfacesConfig) ValidatePorts(ports structs.AllocatedPorts) error {
+	var mErr *multierror.Error
+
+	// Track the interface forwarding each host address, port and protocol.
+	forwarded := make(map[string]int)
+
+	for i, netInterface := range n {
+		errPrefix := fmt.Sprintf("network_interface[%d] -", i+1)
+
+		kind, entries := "bridge", []string(nil)
+		attachment := netInterface.Attachment()
+		switch {
+		case attachment != nil:
+			entries = attachment.Ports
+			if netInterface.Network != nil {
+				kind = "network"
+			}
+		case netInterface.User != nil:
+			entries = netInterface.User.Ports
+		}
+
+		mappings := make([]PortMapping, 0, len(entries))
+		for _, entry := range entries {
+			mapping, err := ParsePortMapping(entry)
+			if err != nil {
+				continue
+			}
+			mappings = append(mappings, mapping)
+
+			first, ok := ports.Get(mapping.Label)
+			if !ok {
+				continue
+			}
+
+			last := first
+			if mapping.IsRange() {
+				if first, last, ok, err = mapping.Range(ports); !ok || err != nil {
+					continue
+				}
+			}
+
+			for value := first.Value; value <= last.Value; value++ {
+				key := fmt.Sprintf("%s/%d/%s", first.HostIP, value, mapping.Protocol)
+				if idx, ok := forwarded[key]; ok {
+					mErr = multierror.Append(mErr,
+						fmt.Errorf("%s %w: port %q overlaps port %d/%s already mapped by network_interface[%d]",
+							errPrefix, errs.ErrInvalidConfiguration, entry, value, mapping.Protocol, idx))
+					break
+				}
+				forwarded[key] = i + 1
+			}
+		}
+
+		if attachment == nil {
+			continue
+		}
+
+		restricted := make(map[string]string)
+		for _, ingress := range attachment.Ingress {
+			idx := slices.IndexFunc(mappings, func(mapping PortMapping) bool {
+				return mapping.Contains(ingress.Port, ports)
+			})
+			if idx < 0 {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: ingress port %q is not within the %s ports",
+						errPrefix, errs.ErrInvalidConfiguration, ingress.Port, kind))
+				continue
+			}
+
+			mapping := mappings[idx].String()
+			if other, ok := restricted[mapping]; ok {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf("%s %w: ingress for ports %q and %q both restrict port range %q",
+						errPrefix, errs.ErrInvalidConfiguration, other, ingress.Port, mapping))
+				continue
+			}
+			restricted[mapping] = ingress.Port
+		}
+	}
+
 	return mErr.ErrorOrNil()
 }
 
+// validDNSName returns if the passed name is a valid DNS name, made up of
+// RFC 1123 labels separated by dots.
+func validDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if !dnsLabel.MatchString(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Isolated returns the network interface which attaches the VM to the network
+// namespace of its allocation. A nil value is returned when no such interface
+// is configured.
+func (n NetworkInterfacesConfig) Isolated() *NetworkInterfaceConfig {
+	for _, iface := range n {
+		if iface.Isolation != nil {
+			return iface
+		}
+	}
+
+	return nil
+}
+
+// IPAM returns if any network interface has its address assigned by the
+// network sub-system before the VM is created.
+func (n NetworkInterfacesConfig) IPAM() bool {
+	return slices.ContainsFunc(n, func(iface *NetworkInterfaceConfig) bool {
+		attachment := iface.Attachment()
+		return attachment != nil && attachment.IPAM
+	})
+}
+
+// Networks returns if any network interface attaches to a named network,
+// which may be created by the network sub-system.
+func (n NetworkInterfacesConfig) Networks() bool {
+	return slices.ContainsFunc(n, func(iface *NetworkInterfaceConfig) bool {
+		return iface.Network != nil
+	})
+}
+
+// Primary returns the network interface marked as primary. If no interface
+// has been marked, the first interface which does not attach to the network
+// namespace of the allocation is returned. A nil value is returned when no
+// such interfaces are configured.
+func (n NetworkInterfacesConfig) Primary() *NetworkInterfaceConfig {
+	for _, iface := range n {
+		if iface.Primary {
+			return iface
+		}
+	}
+
+	for _, iface := range n {
+		if iface.Isolation == nil {
+			return iface
+		}
+	}
+
+	return nil
+}
+
 // NetworkInterfaceHCLSpec returns the HCL specification for a virtual machines
 // network interface object.
 func NetworkInterfaceHCLSpec() *hclspec.Spec {
 	return hclspec.NewBlockList("network_interface", hclspec.NewObject(map[string]*hclspec.Spec{
-		"bridge": hclspec.NewBlock("bridge", false, hclspec.NewObject(map[string]*hclspec.Spec{
-			"name":  hclspec.NewAttr("name", "string", true),
-			"ports": hclspec.NewAttr("ports", "list(string)", false),
-		})),
+		"bridge": attachmentHCLSpec("bridge", map[string]*hclspec.Spec{
+			"openvswitch": hclspec.NewBlock("openvswitch", false, hclspec.NewObject(map[string]*hclspec.Spec{
+				"vlan":         hclspec.NewAttr("vlan", "number", false),
+				"trunk":        hclspec.NewAttr("trunk", "list(number)", false),
+				"interface_id": hclspec.NewAttr("interface_id", "string", false),
+			})),
+		}),
+		"network": attachmentHCLSpec("network", map[string]*hclspec.Spec{}),
 		"macvtap": hclspec.NewBlock("macvtap", false, hclspec.NewObject(map[string]*hclspec.Spec{
 			"device": hclspec.NewAttr("device", "string", true),
 			"mode": hclspec.NewDefault(
 				hclspec.NewAttr("mode", "string", false),
 				hclspec.NewLiteral(fmt.Sprintf("%q", MacvtapModeBridge)),
 			),
+			"inbound":  bandwidthHCLSpec("inbound"),
+			"outbound": bandwidthHCLSpec("outbound"),
+		})),
+		"user": hclspec.NewBlock("user", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"backend": hclspec.NewDefault(
+				hclspec.NewAttr("backend", "string", false),
+				hclspec.NewLiteral(fmt.Sprintf("%q", UserBackendPasst)),
+			),
+			"ports": hclspec.NewAttr("ports", "list(string)", false),
+		})),
+		"primary":   hclspec.NewAttr("primary", "bool", false),
+		"discovery": hclspec.NewAttr("discovery", "list(string)", false),
+		"mac":       hclspec.NewAttr("mac", "string", false),
+		"network_config": hclspec.NewBlock("network_config", false, hclspec.NewObject(map[string]*hclspec.Spec{
+			"addresses": hclspec.NewAttr("addresses", "list(string)", false),
+			"gateway":   hclspec.NewAttr("gateway", "string", false),
+			"gateway6":  hclspec.NewAttr("gateway6", "string", false),
+			"route": hclspec.NewBlockList("route", hclspec.NewObject(map[string]*hclspec.Spec{
+				"to":     hclspec.NewAttr("to", "string", true),
+				"via":    hclspec.NewAttr("via", "string", true),
+				"metric": hclspec.NewAttr("metric", "number", false),
+			})),
+			"mtu":            hclspec.NewAttr("mtu", "number", false),
+			"nameservers":    hclspec.NewAttr("nameservers", "list(string)", false),
+			"search_domains": hclspec.NewAttr("search_domains", "list(string)", false),
 		})),
 	}))
 }
 
-// ConfigurableOnly returns a new NetworkInterfacesConfig containing only configurable
-// interfaces. This is used to filter out interface types such as macvtap that manage
-// their own network identity and have no interaction with Nomad's host-side port
-// mapping machinery.
-func (n NetworkInterfacesConfig) ConfigurableOnly() NetworkInterfacesConfig {
-	var out NetworkInterfacesConfig
-	for _, iface := range n {
-		if iface.Bridge != nil {
-			out = append(out, iface)
-		}
-	}
-	return out
+// attachmentHCLSpec returns the HCL specification for the block of an
+// interface attached to a libvirt network. The block contains the name of the
+// bridge or network, the shared attachment options, and the passed options
+// which are specific to the block.
+func attachmentHCLSpec(name string, attrs map[string]*hclspec.Spec) *hclspec.Spec {
+	attrs["name"] = hclspec.NewAttr("name", "string", true)
+	attrs["ports"] = hclspec.NewAttr("ports", "list(string)", false)
+	attrs["advertise_ipv6"] = hclspec.NewAttr("advertise_ipv6", "bool", false)
+	attrs["ipam"] = hclspec.NewAttr("ipam", "bool", false)
+	attrs["dns_aliases"] = hclspec.NewAttr("dns_aliases", "list(string)", false)
+	attrs["inbound"] = bandwidthHCLSpec("inbound")
+	attrs["outbound"] = bandwidthHCLSpec("outbound")
+	attrs["ingress"] = ingressHCLSpec()
+	attrs["egress"] = egressHCLSpec()
+	attrs["egress_ip"] = hclspec.NewAttr("egress_ip", "string", false)
+
+	return hclspec.NewBlock(name, false, hclspec.NewObject(attrs))
+}
+
+// bandwidthHCLSpec returns the HCL specification for the bandwidth block of
+// a single traffic direction.
+func bandwidthHCLSpec(name string) *hclspec.Spec {
+	return hclspec.NewBlock(name, false, hclspec.NewObject(map[string]*hclspec.Spec{
+		"average": hclspec.NewAttr("average", "number", true),
+		"peak":    hclspec.NewAttr("peak", "number", false),
+		"burst":   hclspec.NewAttr("burst", "number", false),
+	}))
+}
+
+// ingressHCLSpec returns the HCL specification for the ingress blocks of an
+// interface attached to a libvirt network.
+func ingressHCLSpec() *hclspec.Spec {
+	return hclspec.NewBlockList("ingress", hclspec.NewObject(map[string]*hclspec.Spec{
+		"port":  hclspec.NewAttr("port", "string", true),
+		"cidrs": hclspec.NewAttr("cidrs", "list(string)", true),
+	}))
+}
+
+// egressHCLSpec returns the HCL specification for the egress block of an
+// interface attached to a libvirt network.
+func egressHCLSpec() *hclspec.Spec {
+	return hclspec.NewBlock("egress", false, hclspec.NewObject(map[string]*hclspec.Spec{
+		"policy": hclspec.NewDefault(
+			hclspec.NewAttr("policy", "string", false),
+			hclspec.NewLiteral(fmt.Sprintf("%q", EgressPolicyAllowAll)),
+		),
+		"allow": hclspec.NewBlockList("allow", hclspec.NewObject(map[string]*hclspec.Spec{
+			"cidr":     hclspec.NewAttr("cidr", "string", true),
+			"port":     hclspec.NewAttr("port", "number", false),
+			"protocol": hclspec.NewAttr("protocol", "string", false),
+		})),
+	}))
 }
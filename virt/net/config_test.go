@@ -9,6 +9,8 @@ import (
 
 	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
 	"github.com/hashicorp/nomad/plugins/shared/hclspec"
 	"github.com/shoenig/test/must"
 )
@@ -35,85 +37,925 @@ func TestNetworkInterfaces_Validate(t *testing.T) {
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
 					Bridge: &NetworkInterfaceBridgeConfig{
-						Name:  "virbr0",
-						Ports: []string{"ssh"},
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "two interfaces",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
+					},
+				},
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"http"},
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "isolation interface",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Isolation: &NetworkInterfaceIsolationConfig{
+						Device:  "nvt12345678",
+						Address: "169.254.1.2/30",
+						Gateway: "169.254.1.1",
+					},
+				},
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "isolation combined with bridge",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Isolation: &NetworkInterfaceIsolationConfig{
+						Device: "nvt12345678",
+					},
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New("network_interface[1] - invalid configuration: isolation can not be combined with bridge, network or macvtap"),
+		},
+		{
+			name: "multiple primary interfaces",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+					},
+					Primary: true,
+				},
+				{
+					Macvtap: &NetworkInterfaceMacvtapConfig{
+						Device: "eth0",
+						Mode:   MacvtapModeBridge,
+					},
+					Primary: true,
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New("only one network interface can be marked as primary"),
+		},
+		{
+			name: "port mapped by multiple interfaces",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
+					},
+				},
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"http", "ssh"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port "ssh" is already mapped by network_interface[1]`),
+		},
+		{
+			name: "port mapped with multiple protocols",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"dns", "dns/udp"},
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "port protocol mapped by multiple interfaces",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"dns/udp"},
+						},
+					},
+				},
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"dns/UDP"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port "dns/UDP" is already mapped by network_interface[1]`),
+		},
+		{
+			name: "invalid port protocol",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "virbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ping/icmp"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port "ping/icmp" has invalid protocol "icmp"; must be one of: tcp, udp, sctp`),
+		},
+		{
+			name: "no bridge name",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrMissingAttribute,
+			expectedOutput: errors.New("bridge.name"),
+		},
+		{
+			name: "no macvtap device",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Macvtap: &NetworkInterfaceMacvtapConfig{
+						Mode: "bridge",
+					},
+				},
+			},
+			errorTarget:    errs.ErrMissingAttribute,
+			expectedOutput: errors.New("macvtap.device"),
+		},
+		{
+			name: "invalid macvtap mode",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Macvtap: &NetworkInterfaceMacvtapConfig{
+						Mode:   "unknown",
+						Device: "eth0",
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`macvtap has invalid mode "unknown"; must be one of: bridge, private, vepa, passthrough`),
+		},
+		{
+			name: "static network config",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{
+						Addresses:   []string{"192.168.122.10/24", "fd00::10/64"},
+						Gateway:     "192.168.122.1",
+						Gateway6:    "fd00::1",
+						Routes:      []*NetworkInterfaceRouteConfig{{To: "10.0.0.0/8", Via: "192.168.122.254"}},
+						MTU:         9000,
+						Nameservers: []string{"192.168.122.1"},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "network config address without prefix length",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:        &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{Addresses: []string{"192.168.122.10"}},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network_config has invalid address "192.168.122.10"; must include the prefix length`),
+		},
+		{
+			name: "network config gateway of wrong family",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:        &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{Gateway: "fd00::1"},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network_config has invalid gateway "fd00::1"; must be an IPv4 address`),
+		},
+		{
+			name: "network config invalid route",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{
+						Routes: []*NetworkInterfaceRouteConfig{{To: "10.0.0.0/8", Via: "router"}},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network_config route[1] has invalid gateway "router"`),
+		},
+		{
+			name: "network config invalid mtu",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:        &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{MTU: 10},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network_config has invalid mtu 10; must be between 68 and 65535`),
+		},
+		{
+			name: "network config invalid nameserver",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:        &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{Nameservers: []string{"dns.example.com"}},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network_config has invalid nameserver "dns.example.com"`),
+		},
+		{
+			name: "discovery",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:        &NetworkInterfaceBridgeConfig{Name: "br0"},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{Addresses: []string{"192.168.1.10/24"}},
+					Discovery:     []DiscoveryStrategy{DiscoveryStrategyStatic, DiscoveryStrategyGuestAgent},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "unknown discovery strategy",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:    &NetworkInterfaceBridgeConfig{Name: "br0"},
+					Discovery: []DiscoveryStrategy{"mdns"},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`unknown discovery strategy "mdns"`),
+		},
+		{
+			name: "duplicate discovery strategy",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge:    &NetworkInterfaceBridgeConfig{Name: "br0"},
+					Discovery: []DiscoveryStrategy{DiscoveryStrategyARP, DiscoveryStrategyARP},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`discovery strategy "arp" is listed more than once`),
+		},
+		{
+			name: "static discovery without addresses",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Macvtap:   &NetworkInterfaceMacvtapConfig{Device: "eth0", Mode: MacvtapModeBridge},
+					Discovery: []DiscoveryStrategy{DiscoveryStrategyStatic},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`static discovery requires network_config addresses`),
+		},
+		{
+			name: "bridge ipam with static addresses",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							IPAM: true,
+						},
+					},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{
+						Addresses: []string{"192.168.122.10/24"},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`bridge ipam can not be combined with network_config addresses`),
+		},
+		{
+			name: "bridge ipam with advertise ipv6",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							IPAM:          true,
+							AdvertiseIPv6: true,
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`bridge ipam can not be combined with advertise_ipv6`),
+		},
+		{
+			name: "bandwidth without average",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Inbound: &NetworkInterfaceBandwidthConfig{Peak: 2000},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`inbound average must be greater than zero`),
+		},
+		{
+			name: "bandwidth peak below average",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Macvtap: &NetworkInterfaceMacvtapConfig{
+						Device:   "eth0",
+						Outbound: &NetworkInterfaceBandwidthConfig{Average: 2000, Peak: 1000},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`outbound peak must not be less than average`),
+		},
+		{
+			name: "invalid dns alias",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							DNSAliases: []string{"web.example.virt", "web_01..virt"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`invalid dns alias "web_01..virt"`),
+		},
+		{
+			name: "egress ip",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{Bridge: &NetworkInterfaceBridgeConfig{
+					Name: "br0",
+					NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+						EgressIP: "192.168.1.10",
+					},
+				}},
+				{Network: &NetworkInterfaceVirtualNetworkConfig{
+					Name: "default",
+					NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+						EgressIP: "public",
+					},
+				}},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "egress ip loopback",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{Bridge: &NetworkInterfaceBridgeConfig{
+					Name: "br0",
+					NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+						EgressIP: "127.0.0.1",
+					},
+				}},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`bridge egress_ip "127.0.0.1" must be a unicast host address`),
+		},
+		{
+			name: "egress ip invalid",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{Network: &NetworkInterfaceVirtualNetworkConfig{
+					Name: "default",
+					NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+						EgressIP: "10.0.0.0/8",
+					},
+				}},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network egress_ip "10.0.0.0/8" is not an address or host network name`),
+		},
+		{
+			name: "openvswitch",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "ovsbr0",
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{
+							VLAN:        10,
+							Trunk:       []int{20, 30},
+							InterfaceID: "09b11c53-8b5c-4eeb-8f00-d84eaa0aaa4f",
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "openvswitch invalid vlan",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name:        "ovsbr0",
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{VLAN: 4095},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`openvswitch vlan 4095 must be between 1 and 4094`),
+		},
+		{
+			name: "openvswitch duplicate trunk vlan",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name:        "ovsbr0",
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{VLAN: 10, Trunk: []int{10, 20}},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`openvswitch vlan 10 is defined more than once`),
+		},
+		{
+			name: "openvswitch invalid interface id",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name:        "ovsbr0",
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{InterfaceID: "port-1"},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`openvswitch interface_id "port-1" must be a UUID`),
+		},
+		{
+			name: "openvswitch ipam",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "ovsbr0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							IPAM: true,
+						},
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`bridge ipam is not supported with openvswitch`),
+		},
+		{
+			name: "openvswitch dhcp lease discovery",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Discovery: []DiscoveryStrategy{DiscoveryStrategyDHCPLease},
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name:        "ovsbr0",
+						OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`"dhcp_lease" discovery is not supported with openvswitch`),
+		},
+		{
+			name: "ingress and egress",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh", "dns/udp"},
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "ssh", CIDRs: []string{"10.0.0.0/8", "fd00::/8"}},
+							},
+							Egress: &NetworkInterfaceEgressConfig{
+								Policy: EgressPolicyAllowList,
+								Allow:  []*NetworkInterfaceEgressRule{{CIDR: "10.0.0.0/8", Port: 443}},
+							},
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "ingress for unknown port",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "http", CIDRs: []string{"10.0.0.0/8"}},
+							},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`ingress port "http" is not within the bridge ports`),
+		},
+		{
+			name: "ingress invalid cidr",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "ssh", CIDRs: []string{"10.0.0.1"}},
+							},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`ingress for port "ssh" has invalid cidr "10.0.0.1"`),
+		},
+		{
+			name: "ingress without cidrs",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "ssh"},
+							},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`ingress for port "ssh" requires at least one cidr`),
+		},
+		{
+			name: "invalid egress policy",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Egress: &NetworkInterfaceEgressConfig{Policy: "deny"},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`egress has invalid policy "deny"`),
+		},
+		{
+			name: "egress allow without allow list policy",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Egress: &NetworkInterfaceEgressConfig{
+								Policy: EgressPolicyDenyAll,
+								Allow:  []*NetworkInterfaceEgressRule{{CIDR: "10.0.0.0/8"}},
+							},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`egress allow is only supported with the "allow_list" policy`),
+		},
+		{
+			name: "egress allow list without entries",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Egress: &NetworkInterfaceEgressConfig{Policy: EgressPolicyAllowList},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`egress "allow_list" policy requires at least one allow entry`),
+		},
+		{
+			name: "egress allow invalid entry",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Egress: &NetworkInterfaceEgressConfig{
+								Policy: EgressPolicyAllowList,
+								Allow:  []*NetworkInterfaceEgressRule{{CIDR: "10.0.0.0/8", Port: 70000}},
+							},
+						},
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`egress allow[1] invalid configuration: invalid port 70000`),
+		},
+		{
+			name: "invalid mac",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "br0"},
+					MAC:    "52:54:00:zz:34:56",
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`invalid mac "52:54:00:zz:34:56"`),
+		},
+		{
+			name: "duplicate mac",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "br0"},
+					MAC:    "52:54:00:12:34:56",
+				},
+				{
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "br1"},
+					MAC:    "52:54:00:12:34:56",
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`mac "52:54:00:12:34:56" is already used by network_interface[1]`),
+		},
+		{
+			name: "macvtap and bridge defined",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Macvtap: &NetworkInterfaceMacvtapConfig{
+						Mode:   "bridge",
+						Device: "eth0",
+					},
+					Bridge: &NetworkInterfaceBridgeConfig{
+						Name: "br0",
+					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`bridge and macvtap are mutually exclusive`),
+		},
+		{
+			name: "valid user",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User: &NetworkInterfaceUserConfig{
+						Ports: []string{"ssh", "dns/udp"},
 					},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{
+						Addresses: []string{"10.0.2.15/24"},
+					},
+					Discovery: []DiscoveryStrategy{DiscoveryStrategyStatic},
 				},
 			},
 			expectedOutput: nil,
 		},
 		{
-			name: "two interfaces",
+			name: "user and bridge defined",
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
-					Bridge: &NetworkInterfaceBridgeConfig{
-						Name:  "virbr0",
-						Ports: []string{"ssh"},
+					User:   &NetworkInterfaceUserConfig{},
+					Bridge: &NetworkInterfaceBridgeConfig{Name: "br0"},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`user can not be combined with bridge or macvtap`),
+		},
+		{
+			name: "user invalid backend",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User: &NetworkInterfaceUserConfig{Backend: "vde"},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`user has invalid backend "vde"; must be one of: passt, slirp`),
+		},
+		{
+			name: "user slirp ports",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User: &NetworkInterfaceUserConfig{
+						Backend: UserBackendSlirp,
+						Ports:   []string{"ssh"},
 					},
 				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`user ports are only supported with the "passt" backend`),
+		},
+		{
+			name: "user sctp port",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User: &NetworkInterfaceUserConfig{Ports: []string{"sig/sctp"}},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port "sig/sctp" uses protocol "sctp" which is not supported with user`),
+		},
+		{
+			name: "user port range",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User: &NetworkInterfaceUserConfig{Ports: []string{"rtp0..rtp9/udp"}},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port range "rtp0..rtp9/udp" is not supported with user`),
+		},
+		{
+			name: "bridge invalid port range",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
 					Bridge: &NetworkInterfaceBridgeConfig{
-						Name:  "br0",
-						Ports: []string{"http"},
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"rtp0..rtp0"},
+						},
 					},
 				},
 			},
 			errorTarget:    errs.ErrInvalidConfiguration,
-			expectedOutput: errors.New("only one network interface can be configured"),
+			expectedOutput: errors.New(`port range "rtp0..rtp0" requires the labels of two different ports`),
 		},
 		{
-			name: "no bridge name",
+			name: "user port mapped by bridge",
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
 					Bridge: &NetworkInterfaceBridgeConfig{
-						Name:  "",
-						Ports: []string{"ssh"},
+						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
 					},
 				},
+				{
+					User: &NetworkInterfaceUserConfig{Ports: []string{"ssh/tcp"}},
+				},
 			},
-			errorTarget:    errs.ErrMissingAttribute,
-			expectedOutput: errors.New("bridge.name"),
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`port "ssh/tcp" is already mapped by network_interface[1]`),
 		},
 		{
-			name: "no macvtap device",
+			name: "valid network",
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
-					Macvtap: &NetworkInterfaceMacvtapConfig{
-						Mode: "bridge",
+					Network: &NetworkInterfaceVirtualNetworkConfig{
+						Name: "default",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports:      []string{"ssh"},
+							DNSAliases: []string{"web.example.virt"},
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "ssh", CIDRs: []string{"10.0.0.0/8"}},
+							},
+						},
+					},
+				},
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "no network name",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Network: &NetworkInterfaceVirtualNetworkConfig{
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
 					},
 				},
 			},
 			errorTarget:    errs.ErrMissingAttribute,
-			expectedOutput: errors.New("macvtap.device"),
+			expectedOutput: errors.New("network.name"),
 		},
 		{
-			name: "invalid macvtap mode",
+			name: "network and bridge defined",
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
-					Macvtap: &NetworkInterfaceMacvtapConfig{
-						Mode:   "unknown",
-						Device: "eth0",
+					Network: &NetworkInterfaceVirtualNetworkConfig{Name: "default"},
+					Bridge:  &NetworkInterfaceBridgeConfig{Name: "br0"},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`network can not be combined with bridge, macvtap or user`),
+		},
+		{
+			name: "network ipam with static addresses",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					Network: &NetworkInterfaceVirtualNetworkConfig{
+						Name: "default",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							IPAM: true,
+						},
+					},
+					NetworkConfig: &NetworkInterfaceNetworkConfig{
+						Addresses: []string{"192.168.122.10/24"},
 					},
 				},
 			},
 			errorTarget:    errs.ErrInvalidConfiguration,
-			expectedOutput: errors.New(`macvtap has invalid mode "unknown"; must be one of: bridge, private, vepa, passthrough`),
+			expectedOutput: errors.New(`network ipam can not be combined with network_config addresses`),
 		},
 		{
-			name: "macvtap and bridge defined",
+			name: "network ingress for unknown port",
 			inputNetworkInterfaces: &NetworkInterfacesConfig{
 				{
-					Macvtap: &NetworkInterfaceMacvtapConfig{
-						Mode:   "bridge",
-						Device: "eth0",
+					Network: &NetworkInterfaceVirtualNetworkConfig{
+						Name: "default",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ingress: []*NetworkInterfaceIngressConfig{
+								{Port: "ssh", CIDRs: []string{"10.0.0.0/8"}},
+							},
+						},
 					},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`ingress port "ssh" is not within the network ports`),
+		},
+		{
+			name: "network port mapped by bridge",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
 					Bridge: &NetworkInterfaceBridgeConfig{
 						Name: "br0",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
+					},
+				},
+				{
+					Network: &NetworkInterfaceVirtualNetworkConfig{
+						Name: "default",
+						NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+							Ports: []string{"ssh"},
+						},
 					},
 				},
 			},
 			errorTarget:    errs.ErrInvalidConfiguration,
-			expectedOutput: errors.New(`bridge and macvtap are mutually exclusive`),
+			expectedOutput: errors.New(`port "ssh" is already mapped by network_interface[1]`),
+		},
+		{
+			name: "user guest agent discovery",
+			inputNetworkInterfaces: &NetworkInterfacesConfig{
+				{
+					User:      &NetworkInterfaceUserConfig{},
+					Discovery: []DiscoveryStrategy{DiscoveryStrategyGuestAgent},
+				},
+			},
+			errorTarget:    errs.ErrInvalidConfiguration,
+			expectedOutput: errors.New(`"guest_agent" discovery is not supported with user`),
 		},
 	}
 
@@ -154,13 +996,244 @@ func Test_InterfaceHCLSpecification(t *testing.T) {
 		expectedOutput TaskConfig
 	}{
 		{
-			name: "full bridge",
+			name: "full bridge",
+			inputConfig: `
+config {
+  network_interface {
+    bridge {
+      name           = "virbr0"
+      ports          = ["ssh"]
+      advertise_ipv6 = true
+      ipam           = true
+      dns_aliases    = ["web.example.virt"]
+      inbound {
+        average = 1000
+        peak    = 2000
+        burst   = 512
+      }
+      outbound {
+        average = 500
+      }
+    }
+    mac = "52:54:00:12:34:56"
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+							NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+								Ports:         []string{"ssh"},
+								AdvertiseIPv6: true,
+								IPAM:          true,
+								DNSAliases:    []string{"web.example.virt"},
+								Inbound:       &NetworkInterfaceBandwidthConfig{Average: 1000, Peak: 2000, Burst: 512},
+								Outbound:      &NetworkInterfaceBandwidthConfig{Average: 500},
+							},
+						},
+						MAC: "52:54:00:12:34:56",
+					},
+				}},
+		},
+		{
+			name: "bridge no ports",
+			inputConfig: `
+config {
+  network_interface {
+    bridge {
+      name = "virbr0"
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+							NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+								Ports: nil,
+							},
+						},
+					},
+				}},
+		},
+		{
+			name: "bridge ingress and egress",
+			inputConfig: `
+config {
+  network_interface {
+    bridge {
+      name          = "virbr0"
+      ports         = ["ssh"]
+      ingress {
+        port  = "ssh"
+        cidrs = ["10.0.0.0/8"]
+      }
+      egress {
+        policy = "allow_list"
+        allow {
+          cidr = "10.0.0.0/8"
+        }
+        allow {
+          cidr     = "192.168.0.0/16"
+          port     = 53
+          protocol = "udp"
+        }
+      }
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+							NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+								Ports: []string{"ssh"},
+								Ingress: []*NetworkInterfaceIngressConfig{
+									{Port: "ssh", CIDRs: []string{"10.0.0.0/8"}},
+								},
+								Egress: &NetworkInterfaceEgressConfig{
+									Policy: EgressPolicyAllowList,
+									Allow: []*NetworkInterfaceEgressRule{
+										{CIDR: "10.0.0.0/8"},
+										{CIDR: "192.168.0.0/16", Port: 53, Protocol: PortProtocolUDP},
+									},
+								},
+							},
+						},
+					},
+				}},
+		},
+		{
+			name: "bridge egress ip",
+			inputConfig: `
+config {
+  network_interface {
+    bridge {
+      name      = "virbr0"
+      egress_ip = "public"
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+							NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+								EgressIP: "public",
+							},
+						},
+					},
+				}},
+		},
+		{
+			name: "bridge openvswitch",
+			inputConfig: `
+config {
+  network_interface {
+    bridge {
+      name = "ovsbr0"
+      openvswitch {
+        vlan         = 10
+        trunk        = [20, 30]
+        interface_id = "09b11c53-8b5c-4eeb-8f00-d84eaa0aaa4f"
+      }
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "ovsbr0",
+							OpenVSwitch: &NetworkInterfaceOpenVSwitchConfig{
+								VLAN:        10,
+								Trunk:       []int{20, 30},
+								InterfaceID: "09b11c53-8b5c-4eeb-8f00-d84eaa0aaa4f",
+							},
+						},
+					},
+				}},
+		},
+		{
+			name: "full macvtap",
+			inputConfig: `
+config {
+  network_interface {
+    macvtap {
+      device  = "eth0"
+      mode    = "private"
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Macvtap: &NetworkInterfaceMacvtapConfig{
+							Device: "eth0",
+							Mode:   MacvtapModePrivate,
+						},
+					},
+				}},
+		},
+		{
+			name: "macvtap without mode",
+			inputConfig: `
+config {
+  network_interface {
+    macvtap {
+      device  = "eth0"
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Macvtap: &NetworkInterfaceMacvtapConfig{
+							Device: "eth0",
+							Mode:   MacvtapModeBridge,
+						},
+					},
+				}},
+		},
+		{
+			name: "user",
+			inputConfig: `
+config {
+  network_interface {
+    user {
+      ports = ["ssh", "dns/udp"]
+    }
+  }
+}
+`,
+			expectedOutput: TaskConfig{
+				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						User: &NetworkInterfaceUserConfig{
+							Backend: UserBackendPasst,
+							Ports:   []string{"ssh", "dns/udp"},
+						},
+					},
+				}},
+		},
+		{
+			name: "user slirp",
 			inputConfig: `
 config {
   network_interface {
-    bridge {
-      name  = "virbr0"
-      ports = ["ssh"]
+    user {
+      backend = "slirp"
     }
   }
 }
@@ -168,20 +1241,28 @@ config {
 			expectedOutput: TaskConfig{
 				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
 					{
-						Bridge: &NetworkInterfaceBridgeConfig{
-							Name:  "virbr0",
-							Ports: []string{"ssh"},
+						User: &NetworkInterfaceUserConfig{
+							Backend: UserBackendSlirp,
 						},
 					},
 				}},
 		},
 		{
-			name: "bridge no ports",
+			name: "network",
 			inputConfig: `
 config {
   network_interface {
-    bridge {
-      name = "virbr0"
+    network {
+      name           = "routed"
+      ports          = ["ssh"]
+      advertise_ipv6 = true
+      dns_aliases    = ["web.example.virt"]
+      outbound {
+        average = 1000
+      }
+      egress {
+        policy = "deny_all"
+      }
     }
   }
 }
@@ -189,42 +1270,72 @@ config {
 			expectedOutput: TaskConfig{
 				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
 					{
-						Bridge: &NetworkInterfaceBridgeConfig{
-							Name:  "virbr0",
-							Ports: nil,
+						Network: &NetworkInterfaceVirtualNetworkConfig{
+							Name: "routed",
+							NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+								Ports:         []string{"ssh"},
+								AdvertiseIPv6: true,
+								DNSAliases:    []string{"web.example.virt"},
+								Outbound:      &NetworkInterfaceBandwidthConfig{Average: 1000},
+								Egress:        &NetworkInterfaceEgressConfig{Policy: EgressPolicyDenyAll},
+							},
 						},
 					},
 				}},
 		},
 		{
-			name: "full macvtap",
+			name: "multiple interfaces with primary",
 			inputConfig: `
 config {
+  network_interface {
+    bridge {
+      name = "virbr0"
+    }
+  }
   network_interface {
     macvtap {
-      device  = "eth0"
-      mode    = "private"
+      device = "eth0"
+      mode   = "bridge"
     }
+    primary = true
   }
 }
 `,
 			expectedOutput: TaskConfig{
 				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
+					{
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+						},
+					},
 					{
 						Macvtap: &NetworkInterfaceMacvtapConfig{
 							Device: "eth0",
-							Mode:   MacvtapModePrivate,
+							Mode:   MacvtapModeBridge,
 						},
+						Primary: true,
 					},
 				}},
 		},
 		{
-			name: "macvtap without mode",
+			name: "network config",
 			inputConfig: `
 config {
   network_interface {
-    macvtap {
-      device  = "eth0"
+    bridge {
+      name = "virbr0"
+    }
+    network_config {
+      addresses = ["192.168.122.10/24"]
+      gateway   = "192.168.122.1"
+      route {
+        to     = "10.0.0.0/8"
+        via    = "192.168.122.254"
+        metric = 100
+      }
+      mtu            = 1400
+      nameservers    = ["192.168.122.1"]
+      search_domains = ["example.com"]
     }
   }
 }
@@ -232,9 +1343,18 @@ config {
 			expectedOutput: TaskConfig{
 				NetworkInterfacesConfig: []*NetworkInterfaceConfig{
 					{
-						Macvtap: &NetworkInterfaceMacvtapConfig{
-							Device: "eth0",
-							Mode:   MacvtapModeBridge,
+						Bridge: &NetworkInterfaceBridgeConfig{
+							Name: "virbr0",
+						},
+						NetworkConfig: &NetworkInterfaceNetworkConfig{
+							Addresses: []string{"192.168.122.10/24"},
+							Gateway:   "192.168.122.1",
+							Routes: []*NetworkInterfaceRouteConfig{
+								{To: "10.0.0.0/8", Via: "192.168.122.254", Metric: 100},
+							},
+							MTU:           1400,
+							Nameservers:   []string{"192.168.122.1"},
+							SearchDomains: []string{"example.com"},
 						},
 					},
 				}},
@@ -256,3 +1376,557 @@ config {
 		})
 	}
 }
+
+func TestNetworkInterfaceConfig_Bridged(t *testing.T) {
+	t.Run("bridge", func(t *testing.T) {
+		bridge := &NetworkInterfaceBridgeConfig{
+			Name: "virbr0",
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				Ports: []string{"ssh"},
+			},
+		}
+		iface := &NetworkInterfaceConfig{Bridge: bridge}
+		must.Eq(t, bridge, iface.Bridged())
+	})
+
+	t.Run("network", func(t *testing.T) {
+		iface := &NetworkInterfaceConfig{
+			Network: &NetworkInterfaceVirtualNetworkConfig{
+				Name: "default",
+				NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+					Ports: []string{"ssh"},
+					IPAM:  true,
+				},
+			},
+		}
+		must.Eq(t, &NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				Ports: []string{"ssh"},
+				IPAM:  true,
+			},
+		}, iface.Bridged())
+	})
+
+	t.Run("network validated defaults", func(t *testing.T) {
+		network := &NetworkInterfaceVirtualNetworkConfig{
+			Name: "default",
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				Egress: &NetworkInterfaceEgressConfig{
+					Policy: EgressPolicyAllowList,
+					Allow:  []*NetworkInterfaceEgressRule{{CIDR: "10.0.0.0/8", Port: 443}},
+				},
+			},
+		}
+		config := &NetworkInterfacesConfig{{Network: network}}
+		must.NoError(t, config.Validate())
+		must.Eq(t, PortProtocolTCP, network.Egress.Allow[0].Protocol)
+
+		bridge := (*config)[0].Bridged()
+		must.Eq(t, PortProtocolTCP, bridge.Egress.Allow[0].Protocol)
+		must.Eq(t, network.BridgeConfig(""), bridge)
+	})
+
+	t.Run("macvtap", func(t *testing.T) {
+		iface := &NetworkInterfaceConfig{
+			Macvtap: &NetworkInterfaceMacvtapConfig{Device: "eth0"},
+		}
+		must.Nil(t, iface.Bridged())
+	})
+}
+
+func TestNetworkInterfaceBridgeConfig_EgressAddress(t *testing.T) {
+	res := &drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Networks: structs.Networks{
+				{
+					ReservedPorts: []structs.Port{{Label: "ssh", Value: 22}},
+					DynamicPorts:  []structs.Port{{Label: "http", HostNetwork: "public"}},
+				},
+			},
+		},
+		Ports: &structs.AllocatedPorts{
+			{Label: "ssh", Value: 22, HostIP: "10.0.0.2"},
+			{Label: "http", Value: 25000, HostIP: "203.0.113.10"},
+		},
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		addr, err := (&NetworkInterfaceBridgeConfig{}).EgressAddress(res)
+		must.NoError(t, err)
+		must.Eq(t, "", addr)
+	})
+
+	t.Run("address", func(t *testing.T) {
+		addr, err := (&NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				EgressIP: "::ffff:192.168.1.10",
+			},
+		}).EgressAddress(nil)
+		must.NoError(t, err)
+		must.Eq(t, "192.168.1.10", addr)
+	})
+
+	t.Run("host network", func(t *testing.T) {
+		addr, err := (&NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				EgressIP: "public",
+			},
+		}).EgressAddress(res)
+		must.NoError(t, err)
+		must.Eq(t, "203.0.113.10", addr)
+	})
+
+	t.Run("unknown host network", func(t *testing.T) {
+		_, err := (&NetworkInterfaceBridgeConfig{
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				EgressIP: "private",
+			},
+		}).EgressAddress(res)
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, `egress_ip host network "private" has no ports allocated to the task`)
+	})
+}
+
+func TestNetworkInterfaces_Primary(t *testing.T) {
+	bridge := &NetworkInterfaceConfig{
+		Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+	}
+	macvtap := &NetworkInterfaceConfig{
+		Macvtap: &NetworkInterfaceMacvtapConfig{Device: "eth0", Mode: MacvtapModeBridge},
+	}
+	primaryMacvtap := &NetworkInterfaceConfig{
+		Macvtap: &NetworkInterfaceMacvtapConfig{Device: "eth0", Mode: MacvtapModeBridge},
+		Primary: true,
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		must.Nil(t, NetworkInterfacesConfig{}.Primary())
+	})
+
+	t.Run("defaults to first", func(t *testing.T) {
+		must.Eq(t, bridge, NetworkInterfacesConfig{bridge, macvtap}.Primary())
+	})
+
+	t.Run("marked primary", func(t *testing.T) {
+		must.Eq(t, primaryMacvtap, NetworkInterfacesConfig{bridge, primaryMacvtap}.Primary())
+	})
+
+	t.Run("skips isolation", func(t *testing.T) {
+		isolation := &NetworkInterfaceConfig{
+			Isolation: &NetworkInterfaceIsolationConfig{Device: "nomad"},
+		}
+		must.Eq(t, bridge, NetworkInterfacesConfig{isolation, bridge, macvtap}.Primary())
+		must.Nil(t, NetworkInterfacesConfig{isolation}.Primary())
+	})
+}
+
+func TestNetworkInterfaces_Isolated(t *testing.T) {
+	bridge := &NetworkInterfaceConfig{
+		Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+	}
+	isolated := &NetworkInterfaceConfig{
+		Isolation: &NetworkInterfaceIsolationConfig{Device: "nvt12345678"},
+	}
+
+	t.Run("none", func(t *testing.T) {
+		must.Nil(t, NetworkInterfacesConfig{bridge}.Isolated())
+	})
+
+	t.Run("found", func(t *testing.T) {
+		must.Eq(t, isolated, NetworkInterfacesConfig{bridge, isolated}.Isolated())
+	})
+}
+
+func TestNetworkInterfaces_IPAM(t *testing.T) {
+	bridge := &NetworkInterfaceConfig{
+		Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+	}
+	ipam := &NetworkInterfaceConfig{
+		Bridge: &NetworkInterfaceBridgeConfig{
+			Name: "virbr1",
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				IPAM: true,
+			},
+		},
+	}
+	macvtap := &NetworkInterfaceConfig{
+		Macvtap: &NetworkInterfaceMacvtapConfig{Device: "eth0"},
+	}
+
+	networkIPAM := &NetworkInterfaceConfig{
+		Network: &NetworkInterfaceVirtualNetworkConfig{
+			Name: "default",
+			NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{
+				IPAM: true,
+			},
+		},
+	}
+
+	must.False(t, NetworkInterfacesConfig{bridge, macvtap}.IPAM())
+	must.True(t, NetworkInterfacesConfig{macvtap, ipam}.IPAM())
+	must.True(t, NetworkInterfacesConfig{bridge, networkIPAM}.IPAM())
+}
+
+func TestNetworkInterfaces_Networks(t *testing.T) {
+	bridge := &NetworkInterfaceConfig{
+		Bridge: &NetworkInterfaceBridgeConfig{Name: "virbr0"},
+	}
+	network := &NetworkInterfaceConfig{
+		Network: &NetworkInterfaceVirtualNetworkConfig{Name: "tenant"},
+	}
+
+	must.False(t, NetworkInterfacesConfig{}.Networks())
+	must.False(t, NetworkInterfacesConfig{bridge}.Networks())
+	must.True(t, NetworkInterfacesConfig{bridge, network}.Networks())
+}
+
+func TestNetworkInterfaceNetworkConfig_StaticAddresses(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		var config *NetworkInterfaceNetworkConfig
+		must.Nil(t, config.StaticAddresses())
+	})
+
+	t.Run("dhcp", func(t *testing.T) {
+		config := &NetworkInterfaceNetworkConfig{MTU: 1400}
+		must.Nil(t, config.StaticAddresses())
+	})
+
+	t.Run("first of each family", func(t *testing.T) {
+		config := &NetworkInterfaceNetworkConfig{
+			Addresses: []string{"fd00::10/64", "192.168.122.10/24", "192.168.122.11/24", "fd00::11/64"},
+		}
+		must.Eq(t, &InterfaceAddresses{IPv4: "192.168.122.10", IPv6: "fd00::10"}, config.StaticAddresses())
+	})
+}
+
+func TestNetworkInterfaceUserConfig_Validate(t *testing.T) {
+	user := &NetworkInterfaceUserConfig{}
+	must.NoError(t, user.validate("network_interface[1] -"))
+	must.Eq(t, UserBackendPasst, user.Backend)
+}
+
+func TestNetworkInterfaceUserConfig_ResolveForwards(t *testing.T) {
+	user := &NetworkInterfaceUserConfig{
+		Ports: []string{"ssh", "dns/udp", "unknown"},
+	}
+
+	t.Run("no resources", func(t *testing.T) {
+		user.ResolveForwards(nil)
+		must.Nil(t, user.Forwards)
+	})
+
+	t.Run("reserved ports", func(t *testing.T) {
+		user.ResolveForwards(&drivers.Resources{
+			Ports: &structs.AllocatedPorts{
+				{Label: "ssh", Value: 25022, To: 22, HostIP: "192.168.1.10"},
+				{Label: "dns", Value: 25053},
+			},
+		})
+
+		must.Eq(t, []*UserPortForward{
+			{Protocol: PortProtocolTCP, Address: "192.168.1.10", HostPort: 25022, GuestPort: 22},
+			{Protocol: PortProtocolUDP, HostPort: 25053, GuestPort: 25053},
+		}, user.Forwards)
+	})
+}
+
+func TestParsePortMapping(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entry    string
+		expected PortMapping
+		err      string
+	}{
+		{
+			name:     "label only",
+			entry:    "http",
+			expected: PortMapping{Label: "http", Protocol: PortProtocolTCP},
+		},
+		{
+			name:     "tcp",
+			entry:    "http/tcp",
+			expected: PortMapping{Label: "http", Protocol: PortProtocolTCP},
+		},
+		{
+			name:     "udp",
+			entry:    "dns/udp",
+			expected: PortMapping{Label: "dns", Protocol: PortProtocolUDP},
+		},
+		{
+			name:     "sctp uppercase",
+			entry:    "diameter/SCTP",
+			expected: PortMapping{Label: "diameter", Protocol: PortProtocolSCTP},
+		},
+		{
+			name:     "range",
+			entry:    "rtp0..rtp9/udp",
+			expected: PortMapping{Label: "rtp0", LastLabel: "rtp9", Protocol: PortProtocolUDP},
+		},
+		{
+			name:  "range missing last label",
+			entry: "rtp0..",
+			err:   `port range "rtp0.." requires the labels of two different ports`,
+		},
+		{
+			name:  "range same label",
+			entry: "rtp0..rtp0/udp",
+			err:   `port range "rtp0..rtp0/udp" requires the labels of two different ports`,
+		},
+		{
+			name:  "no label",
+			entry: "/udp",
+			err:   `port "/udp" has no label`,
+		},
+		{
+			name:  "invalid protocol",
+			entry: "dns/quic",
+			err:   `port "dns/quic" has invalid protocol "quic"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mapping, err := ParsePortMapping(tc.entry)
+			if tc.err != "" {
+				must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+				must.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			must.NoError(t, err)
+			must.Eq(t, tc.expected, mapping)
+		})
+	}
+}
+
+func TestPortMapping_Range(t *testing.T) {
+	mapping := PortMapping{Label: "first", LastLabel: "last", Protocol: PortProtocolUDP}
+
+	testCases := []struct {
+		name  string
+		ports structs.AllocatedPorts
+		ok    bool
+		err   string
+	}{
+		{
+			name: "valid",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30000, HostIP: "10.0.0.2"},
+				{Label: "middle", Value: 30001, To: 30001, HostIP: "10.0.0.2"},
+				{Label: "last", Value: 30002, HostIP: "10.0.0.2"},
+			},
+			ok: true,
+		},
+		{
+			name: "missing label",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30000, HostIP: "10.0.0.2"},
+			},
+		},
+		{
+			name: "different host addresses",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30000, HostIP: "10.0.0.2"},
+				{Label: "last", Value: 30001, HostIP: "10.0.0.3"},
+			},
+			ok:  true,
+			err: `port range "first..last/udp" spans host addresses 10.0.0.2 and 10.0.0.3`,
+		},
+		{
+			name: "reversed",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30001, HostIP: "10.0.0.2"},
+				{Label: "last", Value: 30000, HostIP: "10.0.0.2"},
+			},
+			ok:  true,
+			err: `port range "first..last/udp" ends at port 30000 which is not after port 30001`,
+		},
+		{
+			name: "unreserved port",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30000, HostIP: "10.0.0.2"},
+				{Label: "middle", Value: 30001, HostIP: "10.0.0.3"},
+				{Label: "last", Value: 30002, HostIP: "10.0.0.2"},
+			},
+			ok:  true,
+			err: `port range "first..last/udp" includes port 30001 which is not reserved by the task`,
+		},
+		{
+			name: "translated port",
+			ports: structs.AllocatedPorts{
+				{Label: "first", Value: 30000, HostIP: "10.0.0.2"},
+				{Label: "middle", Value: 30001, To: 8080, HostIP: "10.0.0.2"},
+				{Label: "last", Value: 30002, HostIP: "10.0.0.2"},
+			},
+			ok:  true,
+			err: `port range "first..last/udp" includes port 30001 which is mapped to a different port`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			first, last, ok, err := mapping.Range(tc.ports)
+			must.Eq(t, tc.ok, ok)
+			if tc.err != "" {
+				must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+				must.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			must.NoError(t, err)
+			if tc.ok {
+				must.Eq(t, 30000, first.Value)
+				must.Eq(t, 30002, last.Value)
+			}
+		})
+	}
+}
+
+func TestPortMapping_Contains(t *testing.T) {
+	ports := structs.AllocatedPorts{
+		{Label: "rtp0", Value: 30000, HostIP: "10.0.0.2"},
+		{Label: "rtp1", Value: 30001, HostIP: "10.0.0.2"},
+		{Label: "rtp2", Value: 30002, HostIP: "10.0.0.2"},
+		{Label: "other", Value: 30001, HostIP: "10.0.0.3"},
+		{Label: "http", Value: 30003, HostIP: "10.0.0.2"},
+	}
+
+	single := PortMapping{Label: "http", Protocol: PortProtocolTCP}
+	must.True(t, single.Contains("http", ports))
+	must.False(t, single.Contains("rtp0", ports))
+
+	portRange := PortMapping{Label: "rtp0", LastLabel: "rtp2", Protocol: PortProtocolUDP}
+	must.True(t, portRange.Contains("rtp0", ports))
+	must.True(t, portRange.Contains("rtp1", ports))
+	must.True(t, portRange.Contains("rtp2", ports))
+	must.False(t, portRange.Contains("http", ports))
+	must.False(t, portRange.Contains("other", ports))
+	must.False(t, portRange.Contains("unknown", ports))
+}
+
+func TestNetworkInterfaces_ValidatePorts(t *testing.T) {
+	ports := structs.AllocatedPorts{
+		{Label: "rtp0", Value: 30000, HostIP: "10.0.0.2"},
+		{Label: "rtp1", Value: 30001, HostIP: "10.0.0.2"},
+		{Label: "rtp2", Value: 30002, HostIP: "10.0.0.2"},
+		{Label: "rtp3", Value: 30003, HostIP: "10.0.0.2"},
+		{Label: "public", Value: 30001, HostIP: "10.0.0.3"},
+	}
+
+	bridge := func(ports ...string) *NetworkInterfaceConfig {
+		return &NetworkInterfaceConfig{
+			Bridge: &NetworkInterfaceBridgeConfig{
+				Name:                             "virbr0",
+				NetworkInterfaceAttachmentConfig: NetworkInterfaceAttachmentConfig{Ports: ports},
+			},
+		}
+	}
+
+	withIngress := func(iface *NetworkInterfaceConfig, labels ...string) *NetworkInterfaceConfig {
+		for _, label := range labels {
+			iface.Bridge.Ingress = append(iface.Bridge.Ingress,
+				&NetworkInterfaceIngressConfig{Port: label, CIDRs: []string{"10.0.0.0/8"}})
+		}
+		return iface
+	}
+
+	testCases := []struct {
+		name   string
+		config NetworkInterfacesConfig
+		err    string
+	}{
+		{
+			name:   "disjoint",
+			config: NetworkInterfacesConfig{bridge("rtp0..rtp1/udp", "rtp2..rtp3/udp", "public/udp")},
+		},
+		{
+			name:   "same ports with different protocols",
+			config: NetworkInterfacesConfig{bridge("rtp0..rtp2/udp", "rtp0..rtp2/tcp")},
+		},
+		{
+			name:   "overlapping ranges",
+			config: NetworkInterfacesConfig{bridge("rtp0..rtp2/udp", "rtp1..rtp3/udp")},
+			err:    `network_interface[1] - invalid configuration: port "rtp1..rtp3/udp" overlaps port 30001/udp already mapped by network_interface[1]`,
+		},
+		{
+			name:   "port within range",
+			config: NetworkInterfacesConfig{bridge("rtp0..rtp2"), bridge("rtp1")},
+			err:    `network_interface[2] - invalid configuration: port "rtp1" overlaps port 30001/tcp already mapped by network_interface[1]`,
+		},
+		{
+			name:   "unreserved labels",
+			config: NetworkInterfacesConfig{bridge("rtp0..unknown", "unknown")},
+		},
+		{
+			name:   "ingress within range",
+			config: NetworkInterfacesConfig{withIngress(bridge("rtp0..rtp2/udp"), "rtp1")},
+		},
+		{
+			name:   "ingress for last port of range",
+			config: NetworkInterfacesConfig{withIngress(bridge("rtp0..rtp2/udp"), "rtp2")},
+		},
+		{
+			name:   "ingress outside range",
+			config: NetworkInterfacesConfig{withIngress(bridge("rtp0..rtp2/udp"), "rtp3")},
+			err:    `ingress port "rtp3" is not within the bridge ports`,
+		},
+		{
+			name:   "ingress on another host address",
+			config: NetworkInterfacesConfig{withIngress(bridge("rtp0..rtp2/udp"), "public")},
+			err:    `ingress port "public" is not within the bridge ports`,
+		},
+		{
+			name:   "multiple ingress within range",
+			config: NetworkInterfacesConfig{withIngress(bridge("rtp0..rtp2/udp"), "rtp0", "rtp2")},
+			err:    `ingress for ports "rtp0" and "rtp2" both restrict port range "rtp0..rtp2/udp"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			must.NoError(t, tc.config.Validate())
+
+			err := tc.config.ValidatePorts(ports)
+			if tc.err == "" {
+				must.NoError(t, err)
+				return
+			}
+
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestGuestPort(t *testing.T) {
+	must.Eq(t, 22, GuestPort(structs.AllocatedPortMapping{Label: "ssh", Value: 25000, To: 22}))
+	must.Eq(t, 25001, GuestPort(structs.AllocatedPortMapping{Label: "metrics", Value: 25001}))
+	must.Eq(t, 25002, GuestPort(structs.AllocatedPortMapping{Label: "http", Value: 25002, To: -1}))
+}
+
+func TestHostNetworks(t *testing.T) {
+	must.MapEmpty(t, HostNetworks(nil))
+	must.MapEmpty(t, HostNetworks(&drivers.Resources{}))
+
+	res := &drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Networks: structs.Networks{
+				{
+					ReservedPorts: []structs.Port{
+						{Label: "ssh", Value: 22},
+						{Label: "dns", Value: 53, HostNetwork: "private"},
+					},
+					DynamicPorts: []structs.Port{
+						{Label: "http", HostNetwork: "public"},
+						{Label: "metrics", HostNetwork: "default"},
+					},
+				},
+			},
+		},
+	}
+
+	must.Eq(t, map[string]string{
+		"dns":  "private",
+		"http": "public",
+	}, HostNetworks(res))
+}
@@ -24,16 +24,49 @@ type Net interface {
 	// FingerprintAttributeKeyPrefix as a base.
 	Fingerprint(map[string]*structs.Attribute)
 
+	// VMIsolationBuild attaches a VM to the network namespace of its
+	// allocation, so it shares the network with the other tasks of the
+	// allocation. It is performed before the VM is started and the returned
+	// interface must be added to the VM. Any error returned will be
+	// considered terminal to the start of the VM.
+	VMIsolationBuild(*VMIsolationBuildRequest) (*VMIsolationBuildResponse, error)
+
+	// VMNetworksBuild resolves the networks the VM network interfaces attach
+	// to, creating any network managed by the network sub-system which does
+	// not yet exist for the task. It is performed before the VM is created
+	// and the returned network names must be set on the interfaces. Any error
+	// returned will be considered terminal to the start of the VM.
+	VMNetworksBuild(*VMNetworksBuildRequest) (*VMNetworksBuildResponse, error)
+
+	// VMAddressingBuild assigns the addresses of the VM network interfaces
+	// which use driver IPAM, and reserves them so they are leased to the VM.
+	// It is performed before the VM is created and the returned hardware
+	// addresses must be set on the interfaces. Any error returned will be
+	// considered terminal to the start of the VM.
+	VMAddressingBuild(*VMAddressingBuildRequest) (*VMAddressingBuildResponse, error)
+
 	// VMStartedBuild performs any network configuration required once the
 	// driver has successfully started a VM. Any error returned will be
 	// considered terminal to the start of the VM and therefore halt any
 	// further progress and result in the task being restarted.
 	VMStartedBuild(*VMStartedBuildRequest) (*VMStartedBuildResponse, error)
 
+	// VMRecoveredBuild restores any network configuration held in-process,
+	// such as proxied port forwards, once the driver has recovered a task
+	// using its teardown specifications. Errors should not be considered
+	// terminal to the recovery of the task.
+	VMRecoveredBuild(*VMRecoveredBuildRequest) (*VMRecoveredBuildResponse, error)
+
 	// VMTerminatedTeardown performs all the network teardown required to clean
 	// the host and any systems of configuration specific to the task. If an
 	// error is encountered, Nomad will retry the stop/kill process, so all
 	// implementations must be able to support this and not enter death spirals
 	// when an error occurs.
 	VMTerminatedTeardown(*VMTerminatedTeardownRequest) (*VMTerminatedTeardownResponse, error)
+
+	// FilterReconcile repairs the packet filter configuration of the running
+	// VMs using their teardown specifications, re-adding any configuration
+	// which has been removed outside of the driver. Implementations whose
+	// packet filter cannot be repaired should return an empty response.
+	FilterReconcile(*FilterReconcileRequest) (*FilterReconcileResponse, error)
 }
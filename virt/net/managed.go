@@ -0,0 +1,301 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package net
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+)
+
+// ManagedNetworkMode is the forwarding mode of a network created by the
+// driver from a managed network template.
+type ManagedNetworkMode string
+
+const (
+	// ManagedNetworkModeNAT forwards the traffic of the network to the
+	// physical network using NAT. This is the mode used when none is
+	// specified.
+	ManagedNetworkModeNAT ManagedNetworkMode = "nat"
+
+	// ManagedNetworkModeRoute forwards the traffic of the network to the
+	// physical network without NAT, so routes to the subnet must be
+	// configured on the physical network.
+	ManagedNetworkModeRoute ManagedNetworkMode = "route"
+
+	// ManagedNetworkModeIsolated does not forward any traffic, so VMs can
+	// only communicate with each other and the host.
+	ManagedNetworkModeIsolated ManagedNetworkMode = "isolated"
+)
+
+// validManagedNetworkModes is the set of accepted ManagedNetworkMode values.
+var validManagedNetworkModes = []ManagedNetworkMode{
+	ManagedNetworkModeNAT,
+	ManagedNetworkModeRoute,
+	ManagedNetworkModeIsolated,
+}
+
+// ManagedNetworkScope determines which tasks share a network created from a
+// managed network template.
+type ManagedNetworkScope string
+
+const (
+	// ManagedNetworkScopeNamespace creates a network for each Nomad
+	// namespace, which is shared by all tasks of the namespace. This is the
+	// scope used when none is specified.
+	ManagedNetworkScopeNamespace ManagedNetworkScope = "namespace"
+
+	// ManagedNetworkScopeJob creates a network for each Nomad job, which is
+	// shared by all tasks of the job.
+	ManagedNetworkScopeJob ManagedNetworkScope = "job"
+)
+
+// validManagedNetworkScopes is the set of accepted ManagedNetworkScope
+// values.
+var validManagedNetworkScopes = []ManagedNetworkScope{
+	ManagedNetworkScopeNamespace,
+	ManagedNetworkScopeJob,
+}
+
+const (
+	// defaultManagedSubnetSize is the prefix length of the subnets allocated
+	// to managed networks when none is specified.
+	defaultManagedSubnetSize = 24
+
+	// maxManagedSubnetSize is the longest prefix length of a managed network
+	// subnet which leaves room for the gateway and a DHCP range.
+	maxManagedSubnetSize = 29
+)
+
+// ManagedNetworkConfig is a template used by the driver to create libvirt
+// networks on demand. Network interfaces which attach to a network named
+// after the template are attached to the network created for the scope of
+// their task instead. The network is created when the first task of the scope
+// starts and destroyed once the last task has stopped.
+type ManagedNetworkConfig struct {
+
+	// Mode is the forwarding mode of the created networks.
+	Mode ManagedNetworkMode `codec:"mode"`
+
+	// Scope determines which tasks share a created network.
+	Scope ManagedNetworkScope `codec:"scope"`
+
+	// SubnetPool is the IPv4 CIDR from which the subnet of each created
+	// network is allocated.
+	SubnetPool string `codec:"subnet_pool"`
+
+	// SubnetSize is the prefix length of the subnet allocated to each
+	// created network.
+	SubnetSize int `codec:"subnet_size"`
+}
+
+// Pool returns the parsed subnet pool of the template.
+func (m ManagedNetworkConfig) Pool() (netip.Prefix, error) {
+	pool, err := netip.ParsePrefix(m.SubnetPool)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: invalid subnet pool %q",
+			errs.ErrInvalidConfiguration, m.SubnetPool)
+	}
+
+	return pool.Masked(), nil
+}
+
+// Validate validates the managed network template with the passed name.
+func (m ManagedNetworkConfig) Validate(name string) error {
+	var mErr *multierror.Error
+	errPrefix := fmt.Sprintf("managed_network %q", name)
+
+	if !dnsLabel.MatchString(name) {
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: %s name must be a valid DNS label",
+			errs.ErrInvalidConfiguration, errPrefix))
+	}
+
+	if m.Mode != "" && !slices.Contains(validManagedNetworkModes, m.Mode) {
+		validModes := make([]string, len(validManagedNetworkModes))
+		for i, v := range validManagedNetworkModes {
+			validModes[i] = string(v)
+		}
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: %s unknown mode %q; must be one of: %s",
+			errs.ErrInvalidConfiguration, errPrefix, m.Mode, strings.Join(validModes, ", ")))
+	}
+
+	if m.Scope != "" && !slices.Contains(validManagedNetworkScopes, m.Scope) {
+		validScopes := make([]string, len(validManagedNetworkScopes))
+		for i, v := range validManagedNetworkScopes {
+			validScopes[i] = string(v)
+		}
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: %s unknown scope %q; must be one of: %s",
+			errs.ErrInvalidConfiguration, errPrefix, m.Scope, strings.Join(validScopes, ", ")))
+	}
+
+	if m.SubnetPool == "" {
+		mErr = multierror.Append(mErr,
+			errs.MissingAttribute("managed_network.subnet_pool", m.SubnetPool, errs.WithPrefix(errPrefix)))
+		return mErr.ErrorOrNil()
+	}
+
+	pool, err := m.Pool()
+	if err != nil {
+		return multierror.Append(mErr, fmt.Errorf("%s: %w", errPrefix, err)).ErrorOrNil()
+	}
+
+	if !pool.Addr().Is4() {
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: %s subnet pool %q must be an IPv4 CIDR",
+			errs.ErrInvalidConfiguration, errPrefix, m.SubnetPool))
+	}
+
+	size := m.subnetSize()
+	if size < pool.Bits() || size > maxManagedSubnetSize {
+		mErr = multierror.Append(mErr, fmt.Errorf("%w: %s subnet size %d must be between %d and %d",
+			errs.ErrInvalidConfiguration, errPrefix, size, pool.Bits(), maxManagedSubnetSize))
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// ForwardMode returns the forwarding mode of the created networks, using the
+// default when none is specified.
+func (m ManagedNetworkConfig) ForwardMode() ManagedNetworkMode {
+	if m.Mode == "" {
+		return ManagedNetworkModeNAT
+	}
+
+	return m.Mode
+}
+
+// NetworkName returns the name of the network created from the template with
+// the passed name for a task of the passed namespace and job. The namespace
+// and job are sanitized, and an error is returned when the resulting name is
+// not a valid libvirt network name.
+func (m ManagedNetworkConfig) NetworkName(name, namespace, jobID string) (string, error) {
+	parts := []string{"nomad", name, namespace}
+	if m.Scope == ManagedNetworkScopeJob {
+		parts = append(parts, jobID)
+	}
+
+	for i, part := range parts {
+		parts[i] = managedNameReplacer.Replace(part)
+	}
+
+	networkName := strings.Join(parts, "-")
+	if err := ValidateNetworkName(networkName); err != nil {
+		return "", err
+	}
+
+	return networkName, nil
+}
+
+// managedNameReplacer replaces the characters of Nomad identifiers which are
+// not accepted within the names of networks, such as the separator of
+// dispatched and periodic job IDs.
+var managedNameReplacer = strings.NewReplacer("/", "_")
+
+// maxNetworkNameLength is the longest libvirt network name. The name is used
+// as the file name of the network definition and of the DHCP and DNS files of
+// the network, the longest of which adds the ".addnhosts" suffix.
+const maxNetworkNameLength = 255 - len(".addnhosts")
+
+// ValidateNetworkName validates the passed name is accepted by libvirt as the
+// name of a network. Libvirt rejects names containing a "/", and the name is
+// used within file names, so it must also fit within a file name and must not
+// contain control characters.
+func ValidateNetworkName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: network name can not be empty", errs.ErrInvalidConfiguration)
+	case len(name) > maxNetworkNameLength:
+		return fmt.Errorf("%w: network name %q exceeds %d characters",
+			errs.ErrInvalidConfiguration, name, maxNetworkNameLength)
+	case strings.Contains(name, "/"):
+		return fmt.Errorf("%w: network name %q can not contain %q",
+			errs.ErrInvalidConfiguration, name, "/")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("%w: network name %q can not contain control characters",
+			errs.ErrInvalidConfiguration, name)
+	}
+
+	return nil
+}
+
+// subnetSize returns the prefix length of the subnet allocated to each
+// created network, using the default when none is specified.
+func (m ManagedNetworkConfig) subnetSize() int {
+	if m.SubnetSize == 0 {
+		return defaultManagedSubnetSize
+	}
+
+	return m.SubnetSize
+}
+
+// AllocateSubnet returns the first subnet of the subnet pool which does not
+// overlap any of the passed subnets already in use on the host.
+func (m ManagedNetworkConfig) AllocateSubnet(used []netip.Prefix) (netip.Prefix, error) {
+	pool, err := m.Pool()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	size := m.subnetSize()
+	if size < pool.Bits() || size > maxManagedSubnetSize {
+		return netip.Prefix{}, fmt.Errorf("%w: invalid subnet size %d", errs.ErrInvalidConfiguration, size)
+	}
+
+	addr := pool.Addr()
+	for addr.IsValid() && pool.Contains(addr) {
+		subnet := netip.PrefixFrom(addr, size)
+
+		// Skip past the subnet in use, which may be larger than the subnet
+		// being allocated.
+		idx := slices.IndexFunc(used, subnet.Overlaps)
+		if idx < 0 {
+			return subnet, nil
+		}
+
+		next := LastAddr(subnet)
+		if last := LastAddr(used[idx]); last.Compare(next) > 0 {
+			next = last
+		}
+		addr = netip.PrefixFrom(next.Next(), size).Masked().Addr()
+	}
+
+	return netip.Prefix{}, fmt.Errorf("subnet pool %s is exhausted", pool)
+}
+
+// LastAddr returns the last address within the passed prefix, which is the
+// broadcast address of an IPv4 subnet.
+func LastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// ManagedNetworkHCLSpec returns the HCL specification for the managed network
+// templates of the plugin configuration.
+func ManagedNetworkHCLSpec() *hclspec.Spec {
+	return hclspec.NewBlockMap("managed_network", []string{"name"}, hclspec.NewObject(map[string]*hclspec.Spec{
+		"mode": hclspec.NewDefault(
+			hclspec.NewAttr("mode", "string", false),
+			hclspec.NewLiteral(fmt.Sprintf("%q", ManagedNetworkModeNAT)),
+		),
+		"scope": hclspec.NewDefault(
+			hclspec.NewAttr("scope", "string", false),
+			hclspec.NewLiteral(fmt.Sprintf("%q", ManagedNetworkScopeNamespace)),
+		),
+		"subnet_pool": hclspec.NewAttr("subnet_pool", "string", true),
+		"subnet_size": hclspec.NewDefault(
+			hclspec.NewAttr("subnet_size", "number", false),
+			hclspec.NewLiteral(fmt.Sprintf("%d", defaultManagedSubnetSize)),
+		),
+	}))
+}
@@ -0,0 +1,271 @@
+// Copyright IBM Corp. 2024, 2026
+// SPDX-License-Identifier: MPL-2.0
+
+package net
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
+	"github.com/shoenig/test/must"
+)
+
+func TestManagedNetworkConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		template    string
+		config      ManagedNetworkConfig
+		errContains string
+	}{
+		{
+			name:     "defaults",
+			template: "tenant",
+			config:   ManagedNetworkConfig{SubnetPool: "10.100.0.0/16"},
+		},
+		{
+			name:     "complete",
+			template: "tenant",
+			config: ManagedNetworkConfig{
+				Mode:       ManagedNetworkModeRoute,
+				Scope:      ManagedNetworkScopeJob,
+				SubnetPool: "10.100.0.0/16",
+				SubnetSize: 29,
+			},
+		},
+		{
+			name:        "invalid name",
+			template:    "tenant_net",
+			config:      ManagedNetworkConfig{SubnetPool: "10.100.0.0/16"},
+			errContains: "must be a valid DNS label",
+		},
+		{
+			name:        "unknown mode",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{Mode: "bridge", SubnetPool: "10.100.0.0/16"},
+			errContains: `unknown mode "bridge"`,
+		},
+		{
+			name:        "unknown scope",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{Scope: "group", SubnetPool: "10.100.0.0/16"},
+			errContains: `unknown scope "group"`,
+		},
+		{
+			name:        "missing subnet pool",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{},
+			errContains: "managed_network.subnet_pool",
+		},
+		{
+			name:        "invalid subnet pool",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{SubnetPool: "10.100.0.0"},
+			errContains: "invalid subnet pool",
+		},
+		{
+			name:        "ipv6 subnet pool",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{SubnetPool: "fd00::/48", SubnetSize: 28},
+			errContains: "must be an IPv4 CIDR",
+		},
+		{
+			name:        "subnet size shorter than pool",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{SubnetPool: "10.100.0.0/16", SubnetSize: 12},
+			errContains: "subnet size 12 must be between 16 and 29",
+		},
+		{
+			name:        "subnet size too long",
+			template:    "tenant",
+			config:      ManagedNetworkConfig{SubnetPool: "10.100.0.0/16", SubnetSize: 30},
+			errContains: "subnet size 30 must be between 16 and 29",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate(tc.template)
+			if tc.errContains == "" {
+				must.NoError(t, err)
+				return
+			}
+
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, tc.errContains)
+		})
+	}
+}
+
+func TestManagedNetworkConfig_NetworkName(t *testing.T) {
+	t.Run("namespace", func(t *testing.T) {
+		config := ManagedNetworkConfig{Scope: ManagedNetworkScopeNamespace}
+		networkName, err := config.NetworkName("tenant", "prod", "web")
+		must.NoError(t, err)
+		must.Eq(t, "nomad-tenant-prod", networkName)
+	})
+
+	t.Run("default scope", func(t *testing.T) {
+		config := ManagedNetworkConfig{}
+		networkName, err := config.NetworkName("tenant", "prod", "web")
+		must.NoError(t, err)
+		must.Eq(t, "nomad-tenant-prod", networkName)
+	})
+
+	t.Run("job", func(t *testing.T) {
+		config := ManagedNetworkConfig{Scope: ManagedNetworkScopeJob}
+		networkName, err := config.NetworkName("tenant", "prod", "web")
+		must.NoError(t, err)
+		must.Eq(t, "nomad-tenant-prod-web", networkName)
+	})
+
+	t.Run("dispatched job", func(t *testing.T) {
+		config := ManagedNetworkConfig{Scope: ManagedNetworkScopeJob}
+		networkName, err := config.NetworkName("tenant", "prod", "batch/dispatch-1700000000-3b4c5d6e")
+		must.NoError(t, err)
+		must.Eq(t, "nomad-tenant-prod-batch_dispatch-1700000000-3b4c5d6e", networkName)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		config := ManagedNetworkConfig{Scope: ManagedNetworkScopeJob}
+		_, err := config.NetworkName("tenant", "prod", strings.Repeat("a", 250))
+		must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+		must.ErrorContains(t, err, "exceeds 245 characters")
+	})
+}
+
+func TestValidateNetworkName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		networkName string
+		errContains string
+	}{
+		{
+			name:        "valid",
+			networkName: "nomad-tenant-prod",
+		},
+		{
+			name:        "longest",
+			networkName: strings.Repeat("a", 245),
+		},
+		{
+			name:        "empty",
+			errContains: "network name can not be empty",
+		},
+		{
+			name:        "too long",
+			networkName: strings.Repeat("a", 246),
+			errContains: "exceeds 245 characters",
+		},
+		{
+			name:        "slash",
+			networkName: "nomad-tenant/prod",
+			errContains: `can not contain "/"`,
+		},
+		{
+			name:        "control character",
+			networkName: "nomad-tenant\nprod",
+			errContains: "can not contain control characters",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateNetworkName(tc.networkName)
+			if tc.errContains == "" {
+				must.NoError(t, err)
+				return
+			}
+
+			must.ErrorIs(t, err, errs.ErrInvalidConfiguration)
+			must.ErrorContains(t, err, tc.errContains)
+		})
+	}
+}
+
+func TestManagedNetworkConfig_ForwardMode(t *testing.T) {
+	must.Eq(t, ManagedNetworkModeNAT, ManagedNetworkConfig{}.ForwardMode())
+	must.Eq(t, ManagedNetworkModeIsolated, ManagedNetworkConfig{Mode: ManagedNetworkModeIsolated}.ForwardMode())
+}
+
+func TestManagedNetworkConfig_AllocateSubnet(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      ManagedNetworkConfig
+		used        []string
+		expected    string
+		errContains string
+	}{
+		{
+			name:     "empty pool",
+			config:   ManagedNetworkConfig{SubnetPool: "10.100.0.0/16"},
+			expected: "10.100.0.0/24",
+		},
+		{
+			name:     "unaligned pool",
+			config:   ManagedNetworkConfig{SubnetPool: "10.100.3.7/16", SubnetSize: 24},
+			expected: "10.100.0.0/24",
+		},
+		{
+			name:     "skips used subnets",
+			config:   ManagedNetworkConfig{SubnetPool: "10.100.0.0/16"},
+			used:     []string{"192.168.122.0/24", "10.100.0.0/24", "10.100.1.128/25"},
+			expected: "10.100.2.0/24",
+		},
+		{
+			name:     "skips larger used subnet",
+			config:   ManagedNetworkConfig{SubnetPool: "10.0.0.0/8", SubnetSize: 24},
+			used:     []string{"10.0.0.0/16"},
+			expected: "10.1.0.0/24",
+		},
+		{
+			name:     "small subnets",
+			config:   ManagedNetworkConfig{SubnetPool: "10.100.0.0/24", SubnetSize: 29},
+			used:     []string{"10.100.0.0/29"},
+			expected: "10.100.0.8/29",
+		},
+		{
+			name:        "exhausted",
+			config:      ManagedNetworkConfig{SubnetPool: "10.100.0.0/23"},
+			used:        []string{"10.100.0.0/24", "10.100.1.0/24"},
+			errContains: "subnet pool 10.100.0.0/23 is exhausted",
+		},
+		{
+			name:        "exhausted by larger subnet",
+			config:      ManagedNetworkConfig{SubnetPool: "255.255.254.0/23"},
+			used:        []string{"255.255.0.0/16"},
+			errContains: "is exhausted",
+		},
+		{
+			name:        "invalid pool",
+			config:      ManagedNetworkConfig{SubnetPool: "invalid"},
+			errContains: "invalid subnet pool",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			used := make([]netip.Prefix, len(tc.used))
+			for i, prefix := range tc.used {
+				used[i] = netip.MustParsePrefix(prefix)
+			}
+
+			subnet, err := tc.config.AllocateSubnet(used)
+			if tc.errContains != "" {
+				must.ErrorContains(t, err, tc.errContains)
+				return
+			}
+
+			must.NoError(t, err)
+			must.Eq(t, tc.expected, subnet.String())
+		})
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	must.Eq(t, "10.100.0.255", LastAddr(netip.MustParsePrefix("10.100.0.0/24")).String())
+	must.Eq(t, "10.100.0.15", LastAddr(netip.MustParsePrefix("10.100.0.9/28")).String())
+	must.Eq(t, "255.255.255.255", LastAddr(netip.MustParsePrefix("255.255.0.0/16")).String())
+	must.Eq(t, "fd00::ffff", LastAddr(netip.MustParsePrefix("fd00::/112")).String())
+}
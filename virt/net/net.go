@@ -4,10 +4,16 @@
 package net
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	stdnet "net"
 	"slices"
+	"strconv"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hashicorp/nomad-driver-virt/internal/errs"
 	"github.com/hashicorp/nomad/plugins/drivers"
 )
 
@@ -27,6 +33,99 @@ const (
 	NetworkStateInactive = "inactive"
 )
 
+// VMIsolationBuildRequest is the request object used to ask the network
+// sub-system to attach a VM to the network namespace of its allocation. It is
+// performed before the VM is started.
+type VMIsolationBuildRequest struct {
+	VMName    string
+	Isolation *drivers.NetworkIsolationSpec
+	Resources *drivers.Resources
+}
+
+// VMIsolationBuildResponse is the response object returned once the network
+// sub-system has attached a VM to the network namespace of its allocation.
+type VMIsolationBuildResponse struct {
+
+	// Interface is the network interface which should be added to the VM, in
+	// order to use the network namespace.
+	Interface *NetworkInterfaceConfig
+
+	// TeardownSpec contains the specification used to remove the attachment
+	// when stopping/killing the task.
+	TeardownSpec *TeardownSpec
+}
+
+// VMNetworksBuildRequest is the request object used to ask the network
+// sub-system to resolve the networks the VM network interfaces attach to. It
+// is performed before the VM is created.
+type VMNetworksBuildRequest struct {
+	VMName    string
+	Namespace string
+	JobID     string
+	NetConfig NetworkInterfacesConfig
+
+	// ResolveIPv6 requests the network sub-system to report whether the
+	// network of each interface provides IPv6 addressing, which is required
+	// when generating the guest network configuration.
+	ResolveIPv6 bool
+}
+
+// VMNetworksBuildResponse is the response object returned once the network
+// sub-system has resolved the networks of the VM network interfaces.
+type VMNetworksBuildResponse struct {
+
+	// Networks contains the name of the network each network interface
+	// attaches to. The entries are ordered to match the interfaces within the
+	// request NetConfig. An entry is empty when the network of the interface
+	// is unchanged.
+	Networks []string
+
+	// IPv6 contains whether the network each network interface attaches to
+	// provides IPv6 addressing. The entries are ordered to match the
+	// interfaces within the request NetConfig. It is only populated when
+	// requested using ResolveIPv6.
+	IPv6 []bool
+
+	// TeardownSpecs contains a specification for each managed network used,
+	// which is used to release the network when stopping/killing the task.
+	TeardownSpecs []*TeardownSpec
+}
+
+// VMAddressingBuildRequest is the request object used to ask the network
+// sub-system to assign the addresses of the VM network interfaces which use
+// driver IPAM. It is performed before the VM is created.
+type VMAddressingBuildRequest struct {
+	VMName   string
+	Hostname string
+
+	// UniqueHostname indicates the hostname was generated for the VM and is
+	// not shared with any other VM.
+	UniqueHostname bool
+
+	NetConfig NetworkInterfacesConfig
+}
+
+// VMAddressingBuildResponse is the response object returned once the network
+// sub-system has assigned the addresses of the VM network interfaces.
+type VMAddressingBuildResponse struct {
+
+	// Assignments contains the assignment of each network interface. The
+	// entries are ordered to match the interfaces within the request
+	// NetConfig. An entry is nil when the interface does not use driver IPAM.
+	Assignments []*InterfaceAssignment
+
+	// TeardownSpecs contains a specification for each assignment, which is
+	// used to remove the reservations when stopping/killing the task.
+	TeardownSpecs []*TeardownSpec
+}
+
+// InterfaceAssignment contains the hardware address and the addresses
+// assigned to a VM network interface before the VM is created.
+type InterfaceAssignment struct {
+	MAC       string
+	Addresses *InterfaceAddresses
+}
+
 // VMStartedBuildRequest is the request object used to ask the network
 // sub-system to perform its configuration, once a VM has been started.
 type VMStartedBuildRequest struct {
@@ -34,7 +133,15 @@ type VMStartedBuildRequest struct {
 	Hostname  string
 	NetConfig NetworkInterfacesConfig
 	Resources *drivers.Resources
-	Hwaddrs   []string
+
+	// UniqueHostname indicates the hostname was generated for the VM and is
+	// not shared with any other VM. Only then can it be used to attribute
+	// DHCPv6 leases which do not include a hardware address.
+	UniqueHostname bool
+
+	// Hwaddrs contains the hardware addresses of the VM network interfaces.
+	// The entries are ordered to match the interfaces within NetConfig.
+	Hwaddrs []string
 }
 
 // Equal returns if the given VMStartBuildRequest is equal.
@@ -44,7 +151,7 @@ func (v *VMStartedBuildRequest) Equal(rhs *VMStartedBuildRequest) bool {
 		return false
 	}
 
-	if v.Hostname != rhs.Hostname {
+	if v.Hostname != rhs.Hostname || v.UniqueHostname != rhs.UniqueHostname {
 		return false
 	}
 
@@ -69,17 +176,50 @@ type VMStartedBuildResponse struct {
 	// object straight onto Nomad.
 	DriverNetwork *drivers.DriverNetwork
 
-	// TeardownSpec contains a specification which will be stored in the task
-	// handle and used when stopping/killing the task. It should include
-	// information which either expedites the process or is critical to the
-	// process.
-	TeardownSpec *TeardownSpec
+	// Addresses contains the addresses discovered for each network interface.
+	// The entries are ordered to match the interfaces within the request
+	// NetConfig. An entry is nil when the network sub-system does not manage
+	// the addressing of the interface.
+	Addresses []*InterfaceAddresses
+
+	// TeardownSpecs contains a specification for each network interface
+	// configured by the network sub-system. These will be stored in the task
+	// handle and used when stopping/killing the task.
+	TeardownSpecs []*TeardownSpec
+}
+
+// InterfaceAddresses contains the addresses assigned to a VM network
+// interface, for each address family.
+type InterfaceAddresses struct {
+	IPv4 string
+	IPv6 string
+}
+
+// Equal returns if the given InterfaceAddresses is equal.
+func (i *InterfaceAddresses) Equal(rhs *InterfaceAddresses) bool {
+	if i == nil || rhs == nil {
+		return i == rhs
+	}
+
+	return *i == *rhs
+}
+
+// VMRecoveredBuildRequest is the request object used to ask the network
+// sub-system to restore any in-process configuration of a VM, after the
+// driver has recovered its task.
+type VMRecoveredBuildRequest struct {
+	VMName        string
+	TeardownSpecs []*TeardownSpec
 }
 
+// VMRecoveredBuildResponse is the response object returned once the network
+// sub-system has restored the in-process configuration of a VM.
+type VMRecoveredBuildResponse struct{}
+
 // VMTerminatedTeardownRequest is the request object used to ask the network
 // sub-system to perform its teardown of a VMs network configuration.
 type VMTerminatedTeardownRequest struct {
-	TeardownSpec *TeardownSpec
+	TeardownSpecs []*TeardownSpec
 }
 
 // Equal returns if the given VMTerminatedTeardownRequest is equal.
@@ -88,7 +228,7 @@ func (v *VMTerminatedTeardownRequest) Equal(rhs *VMTerminatedTeardownRequest) bo
 		return false
 	}
 
-	if !v.TeardownSpec.Equal(rhs.TeardownSpec) {
+	if !slices.EqualFunc(v.TeardownSpecs, rhs.TeardownSpecs, (*TeardownSpec).Equal) {
 		return false
 	}
 
@@ -100,10 +240,40 @@ func (v *VMTerminatedTeardownRequest) Equal(rhs *VMTerminatedTeardownRequest) bo
 // configuration.
 type VMTerminatedTeardownResponse struct{}
 
+// FilterReconcileRequest is the request object used to ask the network
+// sub-system to repair the packet filter configuration of the running VMs.
+type FilterReconcileRequest struct {
+
+	// TeardownSpecs contains the specifications of each running VM, keyed by
+	// the VM name. They describe the packet filter configuration expected to
+	// exist.
+	TeardownSpecs map[string][]*TeardownSpec
+
+	// Prune requests the removal of packet filter configuration which does not
+	// belong to any of the VMs.
+	Prune bool
+}
+
+// FilterReconcileResponse is the response object returned once the network
+// sub-system has repaired the packet filter configuration.
+type FilterReconcileResponse struct {
+
+	// Restored contains the number of missing rules and chains re-added for
+	// each VM, keyed by the VM name. VMs without drift are not included.
+	Restored map[string]int
+
+	// BaseRestored is the number of missing rules and chains re-added which
+	// are shared by all VMs.
+	BaseRestored int
+
+	// Removed is the number of stray rules and chains removed.
+	Removed int
+}
+
 // TeardownSpec contains a specification which will be stored in the task
 // handle and used when stopping/killing the task. It should include
 // information which either expedites the process or is critical to the
-// process.
+// process. A specification is generated for each configured network interface.
 type TeardownSpec struct {
 
 	// FilterRemoval contains the information to remove packet filtering
@@ -114,9 +284,79 @@ type TeardownSpec struct {
 	// a DHCP address for a virtual machine.
 	DHCPReservation string
 
+	// IPv6FilterRemoval contains the information to remove packet filtering
+	// configuration for the IPv6 address of the virtual machine.
+	IPv6FilterRemoval *FilterRemoval
+
+	// IPv6DHCPReservation specifies the reservation string used for
+	// registering a DHCPv6 address for a virtual machine.
+	IPv6DHCPReservation string
+
 	// Network is the name of the network used and which provided the
 	// DHCP lease.
 	Network string
+
+	// IsolationDevice is the name of the host device which links the VM to
+	// the network namespace of its allocation.
+	IsolationDevice string
+
+	// DNSHosts specifies the DNS host entries registered within the network
+	// for the hostname and aliases of a virtual machine.
+	DNSHosts []string
+
+	// Proxies contains the port forwards relayed by the in-process proxy,
+	// rather than the packet filter, for the virtual machine.
+	Proxies []*ProxyForward
+
+	// ManagedNetwork contains the information to release the reference the
+	// virtual machine holds to a network created by the network sub-system.
+	ManagedNetwork *ManagedNetworkRelease
+}
+
+// ManagedNetworkRelease contains the information required to release the
+// reference a virtual machine holds to a network created by the network
+// sub-system. The network is destroyed once no virtual machine holds a
+// reference.
+type ManagedNetworkRelease struct {
+
+	// Network is the name of the managed network.
+	Network string
+
+	// VMName is the name of the virtual machine holding the reference.
+	VMName string
+}
+
+// Equal returns if the given ManagedNetworkRelease is equal.
+func (m *ManagedNetworkRelease) Equal(rhs *ManagedNetworkRelease) bool {
+	if m == nil || rhs == nil {
+		return m == rhs
+	}
+
+	return *m == *rhs
+}
+
+// ProxyForward is a port forward relayed by the in-process proxy from an
+// address of the host to an address of a virtual machine.
+type ProxyForward struct {
+
+	// Protocol is the transport protocol relayed by the proxy.
+	Protocol PortProtocol
+
+	// Listen is the host address and port the proxy listens on.
+	Listen string
+
+	// Target is the virtual machine address and port connections are
+	// relayed to.
+	Target string
+}
+
+// Equal returns if the given ProxyForward is equal.
+func (p *ProxyForward) Equal(rhs *ProxyForward) bool {
+	if p == nil || rhs == nil {
+		return p == rhs
+	}
+
+	return *p == *rhs
 }
 
 // FilterRemoval contains the information required to remove any configuration
@@ -142,17 +382,96 @@ func (t *TeardownSpec) Equal(rhs *TeardownSpec) bool {
 		return false
 	}
 
+	if t.IPv6DHCPReservation != rhs.IPv6DHCPReservation {
+		return false
+	}
+
 	if t.Network != rhs.Network {
 		return false
 	}
 
+	if t.IsolationDevice != rhs.IsolationDevice {
+		return false
+	}
+
+	if !t.ManagedNetwork.Equal(rhs.ManagedNetwork) {
+		return false
+	}
+
+	if !slices.Equal(t.DNSHosts, rhs.DNSHosts) {
+		return false
+	}
+
+	if !slices.EqualFunc(t.Proxies, rhs.Proxies, (*ProxyForward).Equal) {
+		return false
+	}
+
 	if !cmp.Equal(t.FilterRemoval, rhs.FilterRemoval, cmp.Options{cmpopts.IgnoreUnexported()}) {
 		return false
 	}
 
+	if !cmp.Equal(t.IPv6FilterRemoval, rhs.IPv6FilterRemoval, cmp.Options{cmpopts.IgnoreUnexported()}) {
+		return false
+	}
+
 	return true
 }
 
+// GenerateHwaddr returns a random hardware address using the prefix which
+// libvirt uses when generating addresses for KVM guests.
+func GenerateHwaddr() (string, error) {
+	hwaddr := stdnet.HardwareAddr{0x52, 0x54, 0x00, 0, 0, 0}
+	if _, err := rand.Read(hwaddr[3:]); err != nil {
+		return "", fmt.Errorf("failed to generate hardware address: %w", err)
+	}
+
+	return hwaddr.String(), nil
+}
+
+// DeriveHwaddr returns a stable hardware address for the network interface at
+// the passed index of a task. The address is derived from the allocation ID
+// and task name, so it remains the same when the task is restarted, and is a
+// locally administered unicast address.
+func DeriveHwaddr(allocID, taskName string, index int) string {
+	return deriveHwaddr(allocID, taskName, strconv.Itoa(index))
+}
+
+// DeriveIsolationHwaddr returns a stable hardware address for the network
+// interface which attaches a task to the network namespace of its allocation.
+// The interface is not defined within the job specification, so its address
+// is derived using a key of its own, which can not collide with the index of
+// a network interface.
+func DeriveIsolationHwaddr(allocID, taskName string) string {
+	return deriveHwaddr(allocID, taskName, "isolation")
+}
+
+// deriveHwaddr returns a locally administered unicast hardware address
+// derived from the allocation ID and task name, along with the passed key
+// identifying the network interface within the task.
+func deriveHwaddr(allocID, taskName, key string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s", allocID, taskName, key)))
+
+	hwaddr := stdnet.HardwareAddr(sum[:6])
+	hwaddr[0] = (hwaddr[0] | 0x02) &^ 0x01
+
+	return hwaddr.String()
+}
+
+// ValidateHwaddr validates the passed hardware address can be assigned to a
+// network interface of a VM.
+func ValidateHwaddr(hwaddr string) error {
+	parsed, err := stdnet.ParseMAC(hwaddr)
+	if err != nil || len(parsed) != 6 {
+		return fmt.Errorf("%w: invalid mac %q", errs.ErrInvalidConfiguration, hwaddr)
+	}
+
+	if parsed[0]&0x01 != 0 {
+		return fmt.Errorf("%w: mac %q is a multicast address", errs.ErrInvalidConfiguration, hwaddr)
+	}
+
+	return nil
+}
+
 // IsActiveString converts the boolean response from the IsActive call of
 // libvirt network to a human-readable string. This string copies the
 // vocabulary used by virsh for consistency.
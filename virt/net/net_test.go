@@ -4,6 +4,7 @@
 package net
 
 import (
+	stdnet "net"
 	"testing"
 
 	"github.com/shoenig/test/must"
@@ -34,3 +35,51 @@ func Test_NetworkActiveString(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateHwaddr(t *testing.T) {
+	hwaddr, err := GenerateHwaddr()
+	must.NoError(t, err)
+	must.StrHasPrefix(t, "52:54:00:", hwaddr)
+
+	_, err = stdnet.ParseMAC(hwaddr)
+	must.NoError(t, err)
+
+	other, err := GenerateHwaddr()
+	must.NoError(t, err)
+	must.NotEq(t, hwaddr, other)
+}
+
+func TestDeriveHwaddr(t *testing.T) {
+	hwaddr := DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", 0)
+	must.NoError(t, ValidateHwaddr(hwaddr))
+
+	// The address must be locally administered.
+	parsed, err := stdnet.ParseMAC(hwaddr)
+	must.NoError(t, err)
+	must.Eq(t, 0x02, parsed[0]&0x03)
+
+	// The address is stable for the same task and interface.
+	must.Eq(t, hwaddr, DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", 0))
+
+	must.NotEq(t, hwaddr, DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", 1))
+	must.NotEq(t, hwaddr, DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "db", 0))
+	must.NotEq(t, hwaddr, DeriveHwaddr("7c2d1b0a-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", 0))
+}
+
+func TestDeriveIsolationHwaddr(t *testing.T) {
+	hwaddr := DeriveIsolationHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web")
+	must.NoError(t, ValidateHwaddr(hwaddr))
+	must.Eq(t, hwaddr, DeriveIsolationHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web"))
+
+	// The address does not collide with the address of any interface.
+	for i := range 8 {
+		must.NotEq(t, hwaddr, DeriveHwaddr("0ea818bc-1c4b-4c5e-8f0e-6c1d2b0a9e11", "web", i))
+	}
+}
+
+func TestValidateHwaddr(t *testing.T) {
+	must.NoError(t, ValidateHwaddr("52:54:00:12:34:56"))
+	must.ErrorContains(t, ValidateHwaddr("52:54:00:12:34"), "invalid mac")
+	must.ErrorContains(t, ValidateHwaddr("00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"), "invalid mac")
+	must.ErrorContains(t, ValidateHwaddr("01:00:5e:00:00:01"), "multicast")
+}